@@ -40,9 +40,10 @@ var REVISION string = "0"
 var VERSION string = "1.0.10"
 
 const (
-	CMD_QUEUE_SIZE    = 10
-	STATUS_QUEUE_SIZE = 10
-	MAX_ERRORS        = 3
+	CMD_QUEUE_SIZE     = 10
+	STATUS_QUEUE_SIZE  = 10
+	MAX_ERRORS         = 3
+	API_PROBE_INTERVAL = 10 * time.Minute
 )
 
 type Agent struct {
@@ -55,10 +56,19 @@ type Agent struct {
 	services  map[string]pct.ServiceManager
 	updater   *pct.Updater
 	keepalive *time.Ticker
+	audit     *Audit
+	watchdog  *Watchdog
+	// --
+	pauseMux   *sync.Mutex
+	pauseTimer *time.Timer
+	foreground bool
 	// --
 	cmdSync        *pct.SyncChan
 	cmdChan        chan *proto.Cmd
 	cmdHandlerSync *pct.SyncChan
+	localMux       *sync.Mutex
+	localReplies   map[*proto.Cmd]chan *proto.Reply // cmds from queueLocal
+	configFiles    map[string][]byte                // as last loaded, see Reload
 	//
 	statusSync        *pct.SyncChan
 	status            *pct.Status
@@ -75,14 +85,26 @@ func NewAgent(config *Config, logger *pct.Logger, api pct.APIConnector, client p
 		client:    client,
 		services:  services,
 		updater:   pct.NewUpdater(logger, api, pct.PublicKey, os.Args[0], VERSION),
+		audit:     NewAudit(pct.Basedir.File("audit-log"), AUDIT_MAX_SIZE, AUDIT_MAX_FILES),
+		watchdog:  NewWatchdog(logger, services, WATCHDOG_MAX_RESTARTS, WATCHDOG_BACKOFF),
+		pauseMux:  &sync.Mutex{},
 		// --
-		status:     pct.NewStatus([]string{"agent", "agent-cmd-handler"}),
-		cmdChan:    make(chan *proto.Cmd, CMD_QUEUE_SIZE),
-		statusChan: make(chan *proto.Cmd, STATUS_QUEUE_SIZE),
+		status:       pct.NewStatus([]string{"agent", "agent-cmd-handler", "agent-maintenance"}),
+		cmdChan:      make(chan *proto.Cmd, CMD_QUEUE_SIZE),
+		localMux:     &sync.Mutex{},
+		localReplies: make(map[*proto.Cmd]chan *proto.Reply),
+		configFiles:  readConfigFiles(),
+		statusChan:   make(chan *proto.Cmd, STATUS_QUEUE_SIZE),
 	}
 	return agent
 }
 
+// SetForeground tells the agent it's attached to a terminal (-foreground), so
+// it must not restart itself in the background.  It must be called before Run.
+func (agent *Agent) SetForeground(foreground bool) {
+	agent.foreground = foreground
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Interface
 /////////////////////////////////////////////////////////////////////////////
@@ -127,6 +149,20 @@ func (agent *Agent) Run() error {
 	// https://jira.percona.com/browse/PCT-765
 	agent.keepalive = time.NewTicker(time.Duration(agent.config.Keepalive) * time.Second)
 
+	// After failing over to another API hostname, go back to the preferred
+	// one when it works again.  Probing can take a while if the preferred
+	// hostname is down, so it's done in another goroutine, one at a time.
+	probe := time.NewTicker(API_PROBE_INTERVAL)
+	defer probe.Stop()
+	probing := make(chan bool, 1)
+
+	// Warn once each time the local clock becomes skewed from the API.
+	clockSkewed := false
+
+	// Restart service managers that crash.
+	watchdog := time.NewTicker(WATCHDOG_INTERVAL)
+	defer watchdog.Stop()
+
 	logger.Info("Started")
 
 	for {
@@ -135,12 +171,30 @@ func (agent *Agent) Run() error {
 
 		select {
 		case cmd := <-cmdChan: // from API
+			if err := agent.audit.Cmd(cmd); err != nil {
+				logger.Warn("Audit log:", err)
+			}
+
+			// Only cmds in AllowCmds, if set.  It can't be changed by the API
+			// (SetConfig), only in the config file.
+			agent.configMux.RLock()
+			allowed := CmdAllowed(agent.config.AllowCmds, cmd.Service, cmd.Cmd)
+			agent.configMux.RUnlock()
+			if !allowed {
+				logger.Warn("Rejected", cmd, ": not in AllowCmds")
+				agent.reply(cmd.Reply(nil, pct.CmdNotAllowedError{Service: cmd.Service, Cmd: cmd.Cmd}))
+				continue
+			}
 			if cmd.Cmd == "Abort" {
 				panic(cmd)
 			}
 			switch cmd.Cmd {
 			case "Restart":
 				logger.Debug("cmd:restart")
+				if agent.foreground {
+					agent.reply(cmd.Reply(nil, fmt.Errorf("Cannot restart percona-agent running in the foreground; restart it manually")))
+					continue
+				}
 				agent.status.UpdateRe("agent", "Restarting", cmd)
 
 				// Secure the start-lock file.  This lets us start our self but
@@ -242,6 +296,23 @@ func (agent *Agent) Run() error {
 				cmd := &proto.Cmd{Cmd: "Pong"}
 				agent.reply(cmd.Reply(nil, nil))
 			}
+			if status := pct.ClockStatus(); strings.HasPrefix(status, "Skewed") != clockSkewed {
+				clockSkewed = !clockSkewed
+				if clockSkewed {
+					logger.Warn(status)
+				} else {
+					logger.Info("Clock is no longer skewed:", status)
+				}
+			}
+		case <-probe.C:
+			select {
+			case probing <- true:
+				go agent.probeAPI(probing)
+			default:
+				logger.Debug("Still probing preferred API")
+			}
+		case <-watchdog.C:
+			go agent.watchdog.Check()
 		}
 	}
 }
@@ -257,6 +328,37 @@ func (agent *Agent) connect() {
 	agent.client.Connect()
 }
 
+// probeAPI reconnects the cmd, data and log websockets if the API changed
+// to the preferred hostname, because their agent links changed.
+// @goroutine[4]
+func (agent *Agent) probeAPI(probing chan bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			agent.logger.Error("API probe crashed: ", err)
+		}
+		<-probing
+	}()
+	changed, err := agent.api.ProbePreferred()
+	if err != nil {
+		agent.logger.Debug("ProbePreferred:", err)
+		return
+	}
+	if !changed {
+		return
+	}
+	agent.logger.Info("Preferred API", agent.api.Hostname(), "is available, reconnecting")
+	for _, service := range []string{"log", "data"} {
+		if manager, ok := agent.services[service]; ok {
+			cmd := &proto.Cmd{Ts: time.Now().UTC(), User: "agent", Service: service, Cmd: "Reconnect"}
+			if reply := manager.Handle(cmd); reply.Error != "" {
+				agent.logger.Warn("Reconnect "+service+":", reply.Error)
+			}
+		}
+	}
+	// Like the Reconnect cmd: Run() reconnects when the client disconnects.
+	agent.client.Disconnect()
+}
+
 // @goroutine[0]
 func (agent *Agent) stop() {
 	cmd := &proto.Cmd{Ts: time.Now().UTC(), User: "agent"}
@@ -293,12 +395,14 @@ func LoadConfig() ([]byte, error) {
 	if config.Keepalive == 0 {
 		config.Keepalive = DEFAULT_KEEPALIVE
 	}
-	if config.ApiKey == "" {
-		return nil, errors.New("Missing ApiKey")
+	if config.ApiKey == "" && config.ClientCert == "" {
+		return nil, errors.New("Missing ApiKey or ClientCert")
 	}
-	if config.AgentUuid == "" {
-		return nil, errors.New("Missing AgentUuid")
+	if err := ValidateAllowCmds(config.AllowCmds); err != nil {
+		return nil, err
 	}
+	// No AgentUuid is ok: the agent was installed offline and registers
+	// itself when it connects to the API (see Register).
 	data, err := json.Marshal(config)
 	if err != nil {
 		return nil, err
@@ -336,10 +440,15 @@ func (agent *Agent) GetConfig() ([]proto.AgentConfig, []error) {
 // Command handler
 // --------------------------------------------------------------------------
 
+// Cmds for these services are not handled one at a time by cmdHandler but
+// concurrently, because they can be slow (e.g. Explain on a loaded server) and
+// the service bounds its own concurrency (see query.Manager).
+var concurrentServices = map[string]bool{
+	"query": true,
+}
+
 // Run:@goroutine[1]
 func (agent *Agent) cmdHandler() {
-	cmdReply := make(chan *proto.Reply, 1)
-
 	defer func() {
 		if err := recover(); err != nil {
 			agent.logger.Error("Agent command handler crashed: ", err)
@@ -353,50 +462,12 @@ func (agent *Agent) cmdHandler() {
 
 		select {
 		case cmd := <-agent.cmdChan:
-			agent.status.UpdateRe("agent-cmd-handler", "Handling", cmd)
-
-			// Handle the cmd in a separate goroutine so if it gets stuck it won't affect us.
-			go func() {
-				var reply *proto.Reply
-				defer func() {
-					if err := recover(); err != nil {
-						agent.logger.Error(fmt.Sprintf("Command %s crashed: %s", cmd, err))
-						reply = cmd.Reply(nil, fmt.Errorf("%s", err))
-					}
-					cmdReply <- reply
-				}()
-				if cmd.Service == "agent" {
-					reply = agent.Handle(cmd)
-				} else {
-					if manager, ok := agent.services[cmd.Service]; ok {
-						reply = manager.Handle(cmd)
-					} else {
-						reply = cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
-					}
-				}
-			}()
-
-			// Wait for the cmd to complete.
-			var timeout <-chan time.Time
-			if cmd.Cmd == "Update" {
-				timeout = time.After(5 * time.Minute)
-			} else {
-				timeout = time.After(20 * time.Second)
-			}
-			var reply *proto.Reply
-			select {
-			case reply = <-cmdReply:
-				// todo: instrument cmd exec time
-			case <-timeout:
-				reply = cmd.Reply(nil, pct.CmdTimeoutError{Cmd: cmd.Cmd})
-			}
-
-			// Reply to cmd.
-			if reply != nil {
-				agent.reply(reply)
-			} else {
-				agent.logger.Info(cmd, "executed, no reply")
+			if concurrentServices[cmd.Service] {
+				go agent.runCmd(cmd)
+				continue
 			}
+			agent.status.UpdateRe("agent-cmd-handler", "Handling", cmd)
+			agent.runCmd(cmd)
 		case <-agent.cmdHandlerSync.StopChan: // from stop()
 			agent.cmdHandlerSync.Graceful()
 			return
@@ -404,17 +475,124 @@ func (agent *Agent) cmdHandler() {
 	}
 }
 
-func (agent *Agent) reply(reply *proto.Reply) {
+// runCmd handles the cmd, waits for it to complete or time out, and replies.
+func (agent *Agent) runCmd(cmd *proto.Cmd) {
+	// Handle the cmd in a separate goroutine so if it gets stuck it won't affect us.
+	// The reply chan is buffered so a timed out cmd can finish.
+	cmdReply := make(chan *proto.Reply, 1)
+	go func() {
+		cmdReply <- agent.handleCmd(cmd)
+	}()
+
+	// Wait for the cmd to complete.
+	var reply *proto.Reply
+	select {
+	case reply = <-cmdReply:
+		// todo: instrument cmd exec time
+	case <-time.After(cmdTimeout(cmd)):
+		reply = cmd.Reply(nil, pct.CmdTimeoutError{Cmd: cmd.Cmd})
+	}
+
+	// Reply to cmd, or return the reply to queueLocal.
+	agent.localMux.Lock()
+	localReply, isLocal := agent.localReplies[cmd]
+	agent.localMux.Unlock()
+	if isLocal {
+		if reply == nil {
+			reply = cmd.Reply(nil)
+		}
+		localReply <- reply
+	} else if reply != nil {
+		agent.reply(reply)
+	} else {
+		agent.logger.Info(cmd, "executed, no reply")
+	}
+}
+
+// cmdTimeout returns how long cmdHandler waits for the cmd to complete.
+func cmdTimeout(cmd *proto.Cmd) time.Duration {
+	switch cmd.Cmd {
+	case "Update", "Reload":
+		return 5 * time.Minute
+	}
+	return 20 * time.Second
+}
+
+// queueLocal queues a cmd that's not from the API, e.g. to reload configs on
+// SIGHUP, for cmdHandler so it's serialized with cmds from the API, and
+// returns its reply instead of sending it to the API.
+func (agent *Agent) queueLocal(cmd *proto.Cmd) *proto.Reply {
+	replyChan := make(chan *proto.Reply, 1)
+	agent.localMux.Lock()
+	agent.localReplies[cmd] = replyChan
+	agent.localMux.Unlock()
+	defer func() {
+		agent.localMux.Lock()
+		delete(agent.localReplies, cmd)
+		agent.localMux.Unlock()
+	}()
+
+	select {
+	case agent.cmdChan <- cmd: // to cmdHandler
+	default:
+		return cmd.Reply(nil, pct.QueueFullError{Cmd: cmd.Cmd, Name: "cmdQueue", Size: CMD_QUEUE_SIZE})
+	}
+
+	// Time to wait in the queue, then to run.
 	select {
-	case agent.client.SendChan() <- reply:
-		// SendChan is buffered so this should be very quick.
-		// On error, client closes connection and sends false
-		// to ConnectChan which is polled in main Run() loop.
-	case <-time.After(20 * time.Second):
-		agent.logger.Warn("Failed to send reply:", reply)
+	case reply := <-replyChan:
+		return reply
+	case <-time.After(CONTROL_TIMEOUT + cmdTimeout(cmd)):
+		return cmd.Reply(nil, pct.CmdTimeoutError{Cmd: cmd.Cmd})
+	}
+}
+
+func (agent *Agent) reply(reply *proto.Reply) {
+	if reply.Cmd != "Pong" { // keepalive, not a reply to a cmd
+		agent.auditReply(reply)
+	}
+
+	// Send large replies, e.g. processlist or EXPLAIN, in parts so they
+	// don't exceed the message size limit, if enabled (MaxReplySize > 0).
+	agent.configMux.RLock()
+	max := agent.config.MaxReplySize
+	agent.configMux.RUnlock()
+	parts := SplitReply(reply, int(max))
+	if len(parts) > 1 {
+		agent.logger.Info(fmt.Sprintf("Sending %s reply (%d bytes) in %d parts", reply.Cmd, len(reply.Data), len(parts)))
+	}
+
+	for _, part := range parts {
+		select {
+		case agent.client.SendChan() <- part:
+			// SendChan is buffered so this should be very quick.
+			// On error, client closes connection and sends false
+			// to ConnectChan which is polled in main Run() loop.
+		case <-time.After(20 * time.Second):
+			agent.logger.Warn("Failed to send reply:", reply)
+			return
+		}
 	}
 }
 
+// handleCmd handles a cmd for the agent or one of its services.
+// cmdHandler:@goroutine[3]
+func (agent *Agent) handleCmd(cmd *proto.Cmd) (reply *proto.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			agent.logger.Error(fmt.Sprintf("Command %s crashed: %s", cmd, err))
+			reply = cmd.Reply(nil, fmt.Errorf("%s", err))
+		}
+	}()
+	if cmd.Service == "agent" {
+		return agent.Handle(cmd)
+	}
+	if manager, ok := agent.services[cmd.Service]; ok {
+		return manager.Handle(cmd)
+	}
+	return cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
+}
+
 // cmdHandler:@goroutine[3]
 func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 	agent.status.UpdateRe("agent-cmd-handler", "Handling", cmd)
@@ -432,6 +610,10 @@ func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 		data, err = agent.handleStartService(cmd)
 	case "StopService":
 		data, err = agent.handleStopService(cmd)
+	case "EnableService":
+		data, err = agent.handleEnableService(cmd)
+	case "DisableService":
+		data, err = agent.handleDisableService(cmd)
 	case "GetConfig":
 		data, errs = agent.handleGetConfig(cmd)
 	case "GetAllConfigs":
@@ -442,6 +624,16 @@ func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 		data, errs = agent.handleUpdate(cmd)
 	case "Version":
 		data, errs = agent.handleVersion(cmd)
+	case "GetAuditLog":
+		data, err = agent.handleGetAuditLog(cmd)
+	case "GetDiagnostics":
+		data, err = agent.handleGetDiagnostics(cmd)
+	case "Pause":
+		data, err = agent.handlePause(cmd)
+	case "Resume":
+		data, err = agent.handleResume(cmd)
+	case "Reload":
+		data, errs = agent.handleReload(cmd)
 	case "Reconnect":
 		/*
 			Reconnect is a special case: there's no reply because we can't
@@ -633,6 +825,27 @@ func (agent *Agent) handleUpdate(cmd *proto.Cmd) (interface{}, []error) {
 	return nil, []error{err}
 }
 
+// Handle:@goroutine[3]
+func (agent *Agent) handleGetAuditLog(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "GetAuditLog", cmd)
+	q := &AuditQuery{}
+	if len(cmd.Data) > 0 {
+		if err := json.Unmarshal(cmd.Data, q); err != nil {
+			return nil, err
+		}
+	}
+	if q.Limit <= 0 {
+		q.Limit = AUDIT_DEFAULT_LIMIT
+	}
+	return agent.audit.Read(q.Limit)
+}
+
+func (agent *Agent) auditReply(reply *proto.Reply) {
+	if err := agent.audit.Reply(reply); err != nil {
+		agent.logger.Warn("Audit log:", err)
+	}
+}
+
 //---------------------------------------------------------------------------
 // Status handler
 // --------------------------------------------------------------------------
@@ -653,18 +866,9 @@ func (agent *Agent) statusHandler() {
 	for {
 		select {
 		case cmd := <-agent.statusChan:
-			switch cmd.Service {
-			case "":
-				replyChan <- cmd.Reply(agent.AllStatus())
-			case "agent":
-				replyChan <- cmd.Reply(agent.Status())
-			default:
-				if manager, ok := agent.services[cmd.Service]; ok {
-					replyChan <- cmd.Reply(manager.Status())
-				} else {
-					replyChan <- cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
-				}
-			}
+			reply := agent.statusReply(cmd)
+			agent.auditReply(reply)
+			replyChan <- reply
 		case <-agent.statusHandlerSync.StopChan:
 			agent.statusHandlerSync.Graceful()
 			return
@@ -672,9 +876,47 @@ func (agent *Agent) statusHandler() {
 	}
 }
 
+// statusReply replies to the Status cmd with all status (no cmd.Service),
+// the agent's status, or a service's status.
+func (agent *Agent) statusReply(cmd *proto.Cmd) *proto.Reply {
+	var status map[string]string
+	switch cmd.Service {
+	case "":
+		status = agent.AllStatus()
+	case "agent":
+		status = agent.Status()
+	default:
+		manager, ok := agent.services[cmd.Service]
+		if !ok {
+			return cmd.Reply(nil, pct.UnknownServiceError{Service: cmd.Service})
+		}
+		status = manager.Status()
+	}
+	if StatusVersion(cmd) >= 2 {
+		return cmd.Reply(pct.StatusTree(status))
+	}
+	return cmd.Reply(status) // original flat map
+}
+
+// Cmd.Data for the Status cmd, optional.  Version 1 (the default) replies with
+// the flat map[string]string status, version 2 with []*pct.StatusNode.
+type StatusQuery struct {
+	Version uint
+}
+
+// StatusVersion returns the version of the Status cmd, 1 if not specified.
+func StatusVersion(cmd *proto.Cmd) uint {
+	q := &StatusQuery{}
+	if len(cmd.Data) == 0 || json.Unmarshal(cmd.Data, q) != nil || q.Version == 0 {
+		return 1
+	}
+	return q.Version
+}
+
 // statusHandler:@goroutine[2]
 func (agent *Agent) Status() map[string]string {
-	return agent.status.Merge(agent.client.Status())
+	clock := map[string]string{"agent-clock": pct.ClockStatus()}
+	return agent.status.Merge(agent.client.Status(), agent.watchdog.Status(), clock)
 }
 
 // statusHandler:@goroutine[2]
@@ -18,6 +18,9 @@
 package agent_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/agent"
@@ -27,10 +30,12 @@ import (
 	"github.com/percona/percona-agent/test"
 	"github.com/percona/percona-agent/test/mock"
 	. "gopkg.in/check.v1"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -150,6 +155,7 @@ func (s *AgentTestSuite) TearDownTest(t *C) {
 			t.Fatal("Agent didn't respond to Stop cmd")
 		}
 		s.agentRunning = false
+		test.WaitReply(s.recvChan) // mock client relays the Stop reply async
 	}
 
 	test.DrainLogChan(s.logChan)
@@ -242,6 +248,33 @@ func (s *AgentTestSuite) TestStatus(t *C) {
 	t.Check(ok, Equals, false)
 }
 
+func (s *AgentTestSuite) TestStatusVersion2(t *C) {
+	data, _ := json.Marshal(agent.StatusQuery{Version: 2})
+	s.sendChan <- &proto.Cmd{Cmd: "Status", Service: "agent", Data: data}
+	reply := test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Check(reply[0].Error, Equals, "")
+
+	tree := []*pct.StatusNode{}
+	t.Assert(json.Unmarshal(reply[0].Data, &tree), IsNil)
+	var agentNode *pct.StatusNode
+	for _, node := range tree {
+		if node.Name == "agent" {
+			agentNode = node
+		}
+	}
+	t.Assert(agentNode, NotNil)
+	t.Check(agentNode.State, Equals, pct.STATE_IDLE)
+	t.Check(agentNode.Status, Equals, "Idle")
+
+	// agent-cmd-handler, agent-maintenance, etc. are children of agent.
+	names := []string{}
+	for _, child := range agentNode.Children {
+		names = append(names, child.Name)
+	}
+	t.Check(names, DeepEquals, []string{"agent-clock", "agent-cmd-handler", "agent-maintenance"})
+}
+
 func (s *AgentTestSuite) TestStatusAfterConnFail(t *C) {
 	// Use optional ConnectChan in mock ws client for this test only.
 	connectChan := make(chan bool)
@@ -758,6 +791,52 @@ func (s *AgentTestSuite) TestSetConfigApiHostname(t *C) {
 	t.Check(gotCalled, DeepEquals, expectCalled)
 }
 
+func (s *AgentTestSuite) TestReload(t *C) {
+	// Reload (SIGHUP) runs in the cmd queue like cmds from the API, then
+	// reconnects even if the agent config didn't change.
+	connectChan := make(chan bool)
+	s.client.SetConnectChan(connectChan)
+	defer s.client.SetConnectChan(nil)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.agent.Reload("root (SIGHUP)")
+	}()
+
+	// Wait for agent to reconnect.
+	<-connectChan
+	connectChan <- true
+
+	gotCalled := test.WaitTrace(s.client.TraceChan)
+	t.Check(gotCalled, DeepEquals, []string{"Disconnect", "Connect"})
+
+	select {
+	case <-errChan:
+	case <-time.After(5 * time.Second):
+		t.Error("Reload did not return")
+	}
+}
+
+func (s *AgentTestSuite) TestControlCmd(t *C) {
+	socket := filepath.Join(s.tmpDir, pct.CONTROL_SOCK)
+	server := agent.NewControlServer(s.agent, socket)
+	t.Assert(server.Start(), IsNil)
+	defer server.Stop()
+
+	// Cmds to services are queued for the cmd handler like cmds from the API,
+	// but the reply is returned, not sent to the API.
+	_, err := agent.SendControlCmd(socket, &proto.Cmd{Service: "mm", Cmd: "Hello"})
+	t.Check(err, IsNil)
+	t.Assert(s.services["mm"].Cmds, HasLen, 1)
+	t.Check(s.services["mm"].Cmds[0].User, Equals, agent.CONTROL_USER)
+	for _, reply := range test.WaitReply(s.recvChan) {
+		t.Check(reply.Cmd, Not(Equals), "Hello") // only keepalive Pong
+	}
+
+	_, err = agent.SendControlCmd(socket, &proto.Cmd{Service: "foo", Cmd: "Hello"})
+	t.Check(err, ErrorMatches, "Unknown service: foo")
+}
+
 func (s *AgentTestSuite) TestKeepalive(t *C) {
 	// Agent should be sending a Pong every 1s now which is sent as a
 	// reply to no cmd (it's a platypus).
@@ -852,3 +931,163 @@ func (s *AgentTestSuite) TestCmdToService(t *C) {
 	t.Assert(s.services["mm"].Cmds, HasLen, 1)
 	t.Check(s.services["mm"].Cmds[0].Cmd, Equals, "Hello")
 }
+
+func (s *AgentTestSuite) TestPause(t *C) {
+	// Duration is required and limited.
+	data, _ := json.Marshal(agent.Pause{Duration: 0})
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "Pause", Data: data}
+	reply := test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Check(reply[0].Error, Matches, "Invalid Duration: 0: .+")
+
+	// Can't resume if not paused.
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "Resume"}
+	reply = test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Check(reply[0].Error, Equals, "Not paused")
+
+	data, _ = json.Marshal(agent.Pause{Duration: 60, Reason: "backup"})
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "Pause", Data: data}
+	reply = test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Check(reply[0].Error, Equals, "")
+	t.Check(s.agent.Status()["agent-maintenance"], Matches, "Paused until .+: backup")
+
+	s.sendChan <- &proto.Cmd{User: "daniel", Service: "agent", Cmd: "Resume"}
+	reply = test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Check(reply[0].Error, Equals, "")
+	t.Check(s.agent.Status()["agent-maintenance"], Matches, "Resumed at .+ by daniel")
+}
+
+func (s *AgentTestSuite) TestDisableService(t *C) {
+	disable := func(cmd, service string) proto.Reply {
+		data, _ := json.Marshal(proto.ServiceData{Name: service})
+		s.sendChan <- &proto.Cmd{Service: "agent", Cmd: cmd, Data: data}
+		reply := test.WaitReply(s.recvChan)
+		t.Assert(reply, HasLen, 1)
+		return reply[0]
+	}
+	readConfig := func() *agent.Config {
+		config := &agent.Config{}
+		data, err := ioutil.ReadFile(s.configFile)
+		t.Assert(err, IsNil)
+		t.Assert(json.Unmarshal(data, config), IsNil)
+		return config
+	}
+
+	// Only some services can be disabled.
+	reply := disable("DisableService", "log")
+	t.Check(reply.Error, Matches, "Service log cannot be enabled or disabled.+")
+
+	// Disabling stops the service and saves it in the agent config.
+	s.readyChan <- true
+	reply = disable("DisableService", "qan")
+	t.Check(reply.Error, Equals, "")
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Stop qan"})
+	t.Check(readConfig().Disabled, DeepEquals, []string{"qan"})
+
+	// Disabling again is a no-op.
+	reply = disable("DisableService", "qan")
+	t.Check(reply.Error, Equals, "")
+
+	// Enabling starts the service and removes it from the agent config.
+	s.readyChan <- true
+	reply = disable("EnableService", "qan")
+	t.Check(reply.Error, Equals, "")
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Start qan"})
+	t.Check(readConfig().Disabled, HasLen, 0)
+}
+
+func (s *AgentTestSuite) TestReloadDisabledService(t *C) {
+	data, _ := json.Marshal(proto.ServiceData{Name: "qan"})
+	s.readyChan <- true
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "DisableService", Data: data}
+	reply := test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Assert(reply[0].Error, Equals, "")
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Stop qan"})
+
+	qanConfig := filepath.Join(pct.Basedir.Dir("config"), "qan"+pct.CONFIG_FILE_SUFFIX)
+	err := ioutil.WriteFile(qanConfig, []byte(`{"Service":"mysql","InstanceId":1,"Interval":60}`), 0600)
+	t.Assert(err, IsNil)
+	defer os.Remove(qanConfig)
+
+	// SIGHUP reloads the qan config, but qan is disabled, so it's not started.
+	connectChan := make(chan bool)
+	s.client.SetConnectChan(connectChan)
+	defer s.client.SetConnectChan(nil)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.agent.Reload("root (SIGHUP)")
+	}()
+	<-connectChan
+	connectChan <- true
+	select {
+	case err = <-errChan:
+		t.Check(err, IsNil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload did not return")
+	}
+	for _, cmd := range s.services["qan"].Cmds {
+		t.Check(cmd.Cmd, Not(Equals), "StartService")
+	}
+	t.Check(s.services["qan"].IsRunningVal, Equals, false)
+
+	// Re-enable qan for the other tests.
+	s.readyChan <- true
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "EnableService", Data: data}
+	reply = test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Check(reply[0].Error, Equals, "")
+}
+
+func (s *AgentTestSuite) TestGetDiagnostics(t *C) {
+	mysqlConfig := filepath.Join(pct.Basedir.Dir("config"), "mysql-1"+pct.CONFIG_FILE_SUFFIX)
+	err := ioutil.WriteFile(mysqlConfig, []byte(`{"Id":1,"Hostname":"db1.example.com","DSN":"percona:s3cret@tcp(db1.example.com:3306)/"}`), 0600)
+	t.Assert(err, IsNil)
+	defer os.Remove(mysqlConfig)
+	spoolFile := filepath.Join(pct.Basedir.Dir("data"), "qan_1")
+	err = ioutil.WriteFile(spoolFile, []byte("report"), 0600)
+	t.Assert(err, IsNil)
+	defer os.Remove(spoolFile)
+	logFile := filepath.Join(pct.Basedir.Path(), "percona-agent.log")
+	err = ioutil.WriteFile(logFile, []byte("Adding postgres-2 host=db2 password=pgs3cret\nCmd {\"ApiKey\":\"k3y\"}\n"), 0600)
+	t.Assert(err, IsNil)
+	defer os.Remove(logFile)
+
+	s.sendChan <- &proto.Cmd{Service: "agent", Cmd: "GetDiagnostics"}
+	reply := test.WaitReply(s.recvChan)
+	t.Assert(reply, HasLen, 1)
+	t.Assert(reply[0].Error, Equals, "")
+	var tarball []byte
+	t.Assert(json.Unmarshal(reply[0].Data, &tarball), IsNil)
+
+	files := map[string]string{}
+	gz, err := gzip.NewReader(bytes.NewReader(tarball))
+	t.Assert(err, IsNil)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		t.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(tr)
+		t.Assert(err, IsNil)
+		files[strings.TrimPrefix(hdr.Name, agent.DIAG_DIR+"/")] = string(data)
+	}
+	for _, name := range []string{"config/mysql-1.conf", "log/percona-agent.log", "status.json", "status-tree.json", "spool.txt", "system.txt"} {
+		_, ok := files[name]
+		t.Check(ok, Equals, true, Commentf(name))
+	}
+
+	// Passwords, keys and hostname are redacted everywhere, logs included.
+	for name, data := range files {
+		t.Check(strings.Contains(data, "s3cret"), Equals, false, Commentf(name))
+		t.Check(strings.Contains(data, "k3y"), Equals, false, Commentf(name))
+		t.Check(strings.Contains(data, "db1.example.com"), Equals, false, Commentf(name))
+	}
+	t.Check(files["config/mysql-1.conf"], Matches, `(?s).*"DSN": "percona:<redacted>@tcp\(host-\d+:3306\)/".*`)
+	t.Check(files["spool.txt"], Matches, `(?s).*\s6\s.+\sqan_1\n.*`)
+}
@@ -0,0 +1,57 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CmdAllowed returns true if the Service/Cmd is in allow, the AllowCmds config:
+// a list of pairs like "qan/StartService" where the service or cmd can be "*".
+// Cmds without a service, like Status, are agent cmds.  If allow is empty,
+// all cmds are allowed.
+func CmdAllowed(allow []string, service, cmd string) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	if service == "" {
+		service = "agent"
+	}
+	for _, pair := range allow {
+		f := strings.SplitN(pair, "/", 2)
+		if len(f) != 2 {
+			continue
+		}
+		if (f[0] == "*" || f[0] == service) && (f[1] == "*" || f[1] == cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAllowCmds returns an error if an AllowCmds pair is not Service/Cmd.
+func ValidateAllowCmds(allow []string) error {
+	for _, pair := range allow {
+		f := strings.SplitN(pair, "/", 2)
+		if len(f) != 2 || f[0] == "" || f[1] == "" || strings.Contains(f[1], "/") {
+			return fmt.Errorf("Invalid AllowCmds value %q: expected Service/Cmd, like qan/StartService or mm/*", pair)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"github.com/percona/percona-agent/agent"
+	. "gopkg.in/check.v1"
+)
+
+type AllowTestSuite struct {
+}
+
+var _ = Suite(&AllowTestSuite{})
+
+func (s *AllowTestSuite) TestCmdAllowed(t *C) {
+	t.Check(agent.CmdAllowed(nil, "query", "Explain"), Equals, true)
+
+	allow := []string{"agent/Status", "agent/GetAllConfigs", "mm/*", "*/Version"}
+	t.Check(agent.CmdAllowed(allow, "agent", "Status"), Equals, true)
+	t.Check(agent.CmdAllowed(allow, "", "Status"), Equals, true)
+	t.Check(agent.CmdAllowed(allow, "mm", "StartService"), Equals, true)
+	t.Check(agent.CmdAllowed(allow, "qan", "Version"), Equals, true)
+	t.Check(agent.CmdAllowed(allow, "query", "Explain"), Equals, false)
+	t.Check(agent.CmdAllowed(allow, "agent", "Restart"), Equals, false)
+	t.Check(agent.CmdAllowed(allow, "agent", "StartService"), Equals, false)
+	t.Check(agent.CmdAllowed(allow, "mmx", "StartService"), Equals, false)
+}
+
+func (s *AllowTestSuite) TestValidateAllowCmds(t *C) {
+	t.Check(agent.ValidateAllowCmds(nil), IsNil)
+	t.Check(agent.ValidateAllowCmds([]string{"agent/Status", "*/*", "qan/*"}), IsNil)
+	t.Check(agent.ValidateAllowCmds([]string{"Explain"}), NotNil)
+	t.Check(agent.ValidateAllowCmds([]string{"query/"}), NotNil)
+	t.Check(agent.ValidateAllowCmds([]string{"/Explain"}), NotNil)
+	t.Check(agent.ValidateAllowCmds([]string{"query/Explain/x"}), NotNil)
+}
@@ -0,0 +1,185 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	AUDIT_MAX_DATA      = 512              // bytes of Cmd.Data recorded
+	AUDIT_MAX_SIZE      = 10 * 1024 * 1024 // bytes, then rotate
+	AUDIT_MAX_FILES     = 5                // rotated files: audit.log.1 (newest) to .5
+	AUDIT_DEFAULT_LIMIT = 100              // entries returned by GetAuditLog
+)
+
+// AuditEntry is one line (JSON) in the audit log: a cmd received from the API,
+// or the reply to it, matched by RelayId.
+type AuditEntry struct {
+	Ts      time.Time
+	Type    string // "cmd" or "reply"
+	User    string `json:",omitempty"`
+	Service string `json:",omitempty"`
+	Cmd     string
+	Data    string `json:",omitempty"` // truncated to AUDIT_MAX_DATA
+	Error   string `json:",omitempty"` // reply error, empty if success
+	RelayId string `json:",omitempty"`
+}
+
+// AuditQuery is the GetAuditLog cmd data.
+type AuditQuery struct {
+	Limit int // most recent entries, default AUDIT_DEFAULT_LIMIT
+}
+
+// Audit is an append-only log of every cmd the agent receives and its reply,
+// so users can see what the API made the agent do.  It's rotated by size.
+type Audit struct {
+	file     string
+	maxSize  int64
+	maxFiles int
+	mux      *sync.Mutex
+}
+
+func NewAudit(file string, maxSize int64, maxFiles int) *Audit {
+	a := &Audit{
+		file:     file,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		mux:      &sync.Mutex{},
+	}
+	return a
+}
+
+// Cmd records a cmd received from the API.
+func (a *Audit) Cmd(cmd *proto.Cmd) error {
+	// Cmd data can have the API key, passwords in DSNs, etc.
+	data := string(redactJSON(cmd.Data))
+	if len(data) > AUDIT_MAX_DATA {
+		data = data[0:AUDIT_MAX_DATA] + "..."
+	}
+	return a.write(AuditEntry{
+		Ts:      time.Now().UTC(),
+		Type:    "cmd",
+		User:    cmd.User,
+		Service: cmd.Service,
+		Cmd:     cmd.Cmd,
+		Data:    data,
+		RelayId: cmd.RelayId,
+	})
+}
+
+// Reply records the agent's reply to a cmd.  The reply data is not recorded.
+func (a *Audit) Reply(reply *proto.Reply) error {
+	return a.write(AuditEntry{
+		Ts:      time.Now().UTC(),
+		Type:    "reply",
+		Cmd:     reply.Cmd,
+		Error:   reply.Error,
+		RelayId: reply.RelayId,
+	})
+}
+
+// Read returns the most recent limit entries, oldest first, from the audit
+// log and its rotated files.
+func (a *Audit) Read(limit int) ([]AuditEntry, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	entries := []AuditEntry{}
+	for n := 0; n <= a.maxFiles && len(entries) < limit; n++ {
+		fileEntries, err := readAuditFile(a.rotatedFile(n))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(fileEntries, entries...)
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+func (a *Audit) write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if fi, err := os.Stat(a.file); err == nil && fi.Size()+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate moves audit.log.N to audit.log.N+1, dropping the oldest, and
+// audit.log to audit.log.1.
+func (a *Audit) rotate() error {
+	for n := a.maxFiles - 1; n >= 0; n-- {
+		if err := os.Rename(a.rotatedFile(n), a.rotatedFile(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Audit) rotatedFile(n int) string {
+	if n == 0 {
+		return a.file
+	}
+	return fmt.Sprintf("%s.%d", a.file, n)
+}
+
+func readAuditFile(file string) ([]AuditEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // partial write
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,143 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type AuditTestSuite struct {
+	tmpDir string
+}
+
+var _ = Suite(&AuditTestSuite{})
+
+func (s *AuditTestSuite) SetUpTest(t *C) {
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-audit-test")
+	t.Assert(err, IsNil)
+}
+
+func (s *AuditTestSuite) TearDownTest(t *C) {
+	os.RemoveAll(s.tmpDir)
+}
+
+// --------------------------------------------------------------------------
+
+func (s *AuditTestSuite) TestCmdReply(t *C) {
+	file := filepath.Join(s.tmpDir, "audit.log")
+	audit := agent.NewAudit(file, agent.AUDIT_MAX_SIZE, agent.AUDIT_MAX_FILES)
+
+	cmd := &proto.Cmd{
+		Ts:      time.Now(),
+		User:    "daniel",
+		Service: "query",
+		Cmd:     "Explain",
+		Data:    []byte(strings.Repeat("x", agent.AUDIT_MAX_DATA+10)),
+		RelayId: "1",
+	}
+	t.Assert(audit.Cmd(cmd), IsNil)
+	t.Assert(audit.Reply(cmd.Reply(nil, fmt.Errorf("not allowed"))), IsNil)
+
+	entries, err := audit.Read(10)
+	t.Assert(err, IsNil)
+	t.Assert(entries, HasLen, 2)
+	t.Check(entries[0].Type, Equals, "cmd")
+	t.Check(entries[0].User, Equals, "daniel")
+	t.Check(entries[0].Service, Equals, "query")
+	t.Check(entries[0].Cmd, Equals, "Explain")
+	t.Check(entries[0].Data, Equals, strings.Repeat("x", agent.AUDIT_MAX_DATA)+"...")
+	t.Check(entries[0].RelayId, Equals, "1")
+	t.Check(entries[1].Type, Equals, "reply")
+	t.Check(entries[1].Cmd, Equals, "Explain")
+	t.Check(entries[1].Error, Equals, "not allowed")
+	t.Check(entries[1].RelayId, Equals, "1")
+
+	fi, err := os.Stat(file)
+	t.Assert(err, IsNil)
+	t.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *AuditTestSuite) TestCmdRedacted(t *C) {
+	file := filepath.Join(s.tmpDir, "audit.log")
+	audit := agent.NewAudit(file, agent.AUDIT_MAX_SIZE, agent.AUDIT_MAX_FILES)
+
+	config := []byte(`{"Id":1,"DSN":"percona-agent:dsnpass@tcp(db1:3306)/"}`)
+	data, _ := json.Marshal(map[string]interface{}{
+		"ApiKey": "00000000000000000000000000000001",
+		"Config": config, // []byte, i.e. base64
+	})
+	cmd := &proto.Cmd{Service: "agent", Cmd: "SetConfig", Data: data}
+	t.Assert(audit.Cmd(cmd), IsNil)
+	cmd = &proto.Cmd{Service: "instance", Cmd: "Add", Data: []byte("host=db1 password=pgpass dbname=x")}
+	t.Assert(audit.Cmd(cmd), IsNil)
+
+	bytes, err := ioutil.ReadFile(file)
+	t.Assert(err, IsNil)
+	log := string(bytes)
+	t.Check(strings.Contains(log, "00000000000000000000000000000001"), Equals, false)
+	t.Check(strings.Contains(log, "pgpass"), Equals, false)
+
+	entries, err := audit.Read(10)
+	t.Assert(err, IsNil)
+	t.Assert(entries, HasLen, 2)
+	got := &struct {
+		ApiKey string
+		Config []byte
+	}{}
+	t.Assert(json.Unmarshal([]byte(entries[0].Data), got), IsNil)
+	t.Check(got.ApiKey, Equals, agent.REDACTED)
+	t.Check(string(got.Config), Equals, `{"DSN":"percona-agent:`+agent.REDACTED+`@tcp(db1:3306)/","Id":1}`)
+	t.Check(entries[1].Data, Equals, "host=db1 password="+agent.REDACTED+" dbname=x")
+}
+
+func (s *AuditTestSuite) TestRotate(t *C) {
+	file := filepath.Join(s.tmpDir, "audit.log")
+	audit := agent.NewAudit(file, 300, 2) // ~2 entries per file
+
+	for n := 1; n <= 10; n++ {
+		cmd := &proto.Cmd{Service: "agent", Cmd: "Status", RelayId: fmt.Sprintf("%d", n)}
+		t.Assert(audit.Cmd(cmd), IsNil)
+	}
+	t.Check(pct.FileExists(file+".1"), Equals, true)
+	t.Check(pct.FileExists(file+".2"), Equals, true)
+	t.Check(pct.FileExists(file+".3"), Equals, false)
+
+	// Most recent entries, oldest first, across files.
+	entries, err := audit.Read(3)
+	t.Assert(err, IsNil)
+	t.Assert(entries, HasLen, 3)
+	t.Check(entries[0].RelayId, Equals, "8")
+	t.Check(entries[2].RelayId, Equals, "10")
+
+	// Oldest entries were dropped.
+	entries, err = audit.Read(100)
+	t.Assert(err, IsNil)
+	t.Check(len(entries) < 10, Equals, true)
+	t.Check(entries[len(entries)-1].RelayId, Equals, "10")
+}
@@ -17,15 +17,65 @@
 
 package agent
 
+import (
+	"github.com/percona/percona-agent/pct"
+)
+
 const (
 	DEFAULT_API_HOSTNAME = "cloud-api.percona.com"
 	DEFAULT_KEEPALIVE    = 76
 )
 
 type Config struct {
-	AgentUuid   string
-	ApiHostname string
-	ApiKey      string
-	Keepalive   uint
-	Links       map[string]string `json:",omitempty"`
+	AgentUuid     string
+	ApiHostname   string
+	ApiHostnames  []string `json:",omitempty"` // failover, tried in order after ApiHostname
+	ApiKey        string
+	Keepalive     uint
+	Links         map[string]string `json:",omitempty"`
+	ProxyURL      string            `json:",omitempty"` // http|socks5://[user:pass@]host:port
+	NoProxy       string            `json:",omitempty"` // like NO_PROXY
+	StatusAddress string            `json:",omitempty"` // local HTTP status, e.g. 127.0.0.1:9555
+	StatusDebug   bool              `json:",omitempty"` // pprof and runtime stats on StatusAddress
+	AllowCmds     []string          `json:",omitempty"` // Service/Cmd pairs, e.g. qan/*; empty allows all
+	AdjustClock   bool              `json:",omitempty"` // correct mm and qan report times for clock skew
+	Disabled      []string          `json:",omitempty"` // services not started, see DisableService cmd
+	CAFile        string            `json:",omitempty"` // PEM certs trusted in addition to system CAs
+	TLSMinVersion string            `json:",omitempty"` // 1.0, 1.1, 1.2 or 1.3
+	TLSPins       []string          `json:",omitempty"` // cert or public key pins, see pct.SetTLS
+	ClientCert    string            `json:",omitempty"` // PEM cert file for mutual TLS, with ClientKey
+	ClientKey     string            `json:",omitempty"` // PEM key file, reloaded with ClientCert if changed
+	Compression   bool              `json:",omitempty"` // gzip websocket messages if the API supports it
+	PingInterval  uint              `json:",omitempty"` // seconds between cmd websocket pings, 0 disables
+	PingTimeout   uint              `json:",omitempty"` // seconds without a message from API before reconnecting
+	Transport     string            `json:",omitempty"` // websocket (default) or grpc for cmd, log and data links
+	MaxReplySize  uint              `json:",omitempty"` // bytes of reply data per message, 0 = no parts, see SplitReply
+
+	// Reconnect wait policy for API, data and MySQL connections, see pct.SetBackoff.
+	Backoff *pct.BackoffConfig `json:",omitempty"`
+}
+
+// ServiceDisabled returns true if the service is in Disabled.
+func (c *Config) ServiceDisabled(service string) bool {
+	for _, disabled := range c.Disabled {
+		if disabled == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Hostnames returns ApiHostname and ApiHostnames, without duplicates, in order
+// of preference.
+func (c *Config) Hostnames() []string {
+	hostnames := []string{}
+	seen := make(map[string]bool)
+	for _, hostname := range append([]string{c.ApiHostname}, c.ApiHostnames...) {
+		if hostname == "" || seen[hostname] {
+			continue
+		}
+		seen[hostname] = true
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames
 }
@@ -0,0 +1,205 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	CONTROL_TIMEOUT = 20 * time.Second // to wait in the cmd queue
+	CONTROL_USER    = "local"          // cmd.User if not set
+)
+
+// A ControlServer lets local users manage the agent without the API, e.g.
+// with "percona-agent status".  It listens on a unix socket that only the
+// agent's user (root) can use, and serves:
+//
+//	POST /cmd   proto.Cmd -> proto.Reply, see Agent.HandleLocal
+type ControlServer struct {
+	agent    *Agent
+	path     string
+	listener net.Listener
+}
+
+func NewControlServer(agent *Agent, path string) *ControlServer {
+	s := &ControlServer{
+		agent: agent,
+		path:  path,
+	}
+	return s
+}
+
+// Start listens on the socket and serves in a goroutine.
+func (s *ControlServer) Start() error {
+	listener, err := ListenControl(s.path)
+	if err != nil {
+		return err
+	}
+	s.Serve(listener)
+	return nil
+}
+
+// ListenControl listens on the control socket, removing it first if it's
+// stale (no agent is listening).  The agent listens before dropping privileges
+// (-user), so the socket is root-only, then calls Serve.
+func ListenControl(path string) (net.Listener, error) {
+	if pct.FileExists(path) {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Another agent is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve serves on the listener from ListenControl in a goroutine.
+func (s *ControlServer) Serve(listener net.Listener) {
+	s.listener = listener
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cmd", s.cmd)
+	go http.Serve(listener, mux)
+}
+
+func (s *ControlServer) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close() // removes the socket
+}
+
+func (s *ControlServer) cmd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	cmd := &proto.Cmd{}
+	if err := json.NewDecoder(r.Body).Decode(cmd); err != nil {
+		http.Error(w, "Invalid cmd: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cmd.User == "" {
+		cmd.User = CONTROL_USER
+	}
+	if cmd.Ts.IsZero() {
+		cmd.Ts = time.Now().UTC()
+	}
+	writeJSON(w, http.StatusOK, s.agent.HandleLocal(cmd))
+}
+
+// HandleLocal handles a cmd from the control socket and returns its reply.
+// Only root can use the socket, so AllowCmds (which restricts the API) doesn't
+// apply, but the cmd is audited like cmds from the API.  Ping replies with
+// the agent Version, Status is handled at once, and other cmds are queued for
+// the cmd handler like cmds from the API, see queueLocal.
+func (agent *Agent) HandleLocal(cmd *proto.Cmd) *proto.Reply {
+	if err := agent.audit.Cmd(cmd); err != nil {
+		agent.logger.Warn("Audit log:", err)
+	}
+	var reply *proto.Reply
+	switch cmd.Cmd {
+	case "Ping":
+		reply = cmd.Reply(Version{Version: VERSION, Revision: REVISION})
+	case "Status":
+		reply = agent.statusReply(cmd)
+	default:
+		// Like cmds from the API, so it's not run at the same time as one,
+		// e.g. StartService twice.
+		reply = agent.queueLocal(cmd)
+	}
+	agent.auditReply(reply)
+	return reply
+}
+
+// NewControlCmd returns a cmd for SendControlCmd from user to the service,
+// with data, if not nil, as its JSON Data.  Data must not be JSON already,
+// else it's encoded twice: a []byte as a base64 JSON string.
+func NewControlCmd(user, service, cmd string, data interface{}) (*proto.Cmd, error) {
+	c := &proto.Cmd{
+		Ts:      time.Now().UTC(),
+		User:    user,
+		Service: service,
+		Cmd:     cmd,
+	}
+	if data != nil {
+		bytes, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		c.Data = bytes
+	}
+	return c, nil
+}
+
+// SendControlCmd sends the cmd to the agent's control socket and returns its
+// reply.  A reply error is returned as the error.
+func SendControlCmd(path string, cmd *proto.Cmd) (*proto.Reply, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		},
+		Timeout: CONTROL_TIMEOUT + cmdTimeout(cmd) + 5*time.Second, // see queueLocal
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post("http://agent/cmd", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to agent: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Agent returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	reply := &proto.Reply{}
+	if err := json.Unmarshal(body, reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return reply, fmt.Errorf("%s", reply.Error)
+	}
+	return reply, nil
+}
@@ -0,0 +1,313 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	DIAG_DIR       = "percona-agent-diagnostics" // top dir in the tarball
+	DIAG_LOG_BYTES = 1024 * 1024                 // tail of each log file
+)
+
+var diagDSNHost = regexp.MustCompile(`@tcp\(([^:)]+)`)
+
+type diagFile struct {
+	name string
+	data []byte
+}
+
+// Handle:@goroutine[3]
+func (agent *Agent) handleGetDiagnostics(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "GetDiagnostics", cmd)
+	agent.logger.Info(cmd)
+	return agent.Diagnostics()
+}
+
+// Diagnostics returns a gzipped tarball for support tickets: configs with
+// secrets removed, the tail of the agent logs, status, the data spool listing,
+// and system facts.  Every file, logs included, then gets a redaction pass that
+// removes DSN and URL passwords and the values of secret keys (e.g. ApiKey,
+// password=), and replaces the hostnames of this server, the API and MySQL
+// instances with host-1, host-2, etc.
+func (agent *Agent) Diagnostics() ([]byte, error) {
+	files := []*diagFile{}
+	add := func(name string, data []byte) {
+		files = append(files, &diagFile{name, data})
+	}
+	addJSON := func(name string, v interface{}) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		add(name, data)
+	}
+
+	// Configs, with secrets removed.
+	hostnames := []string{}
+	configFiles, _ := filepath.Glob(filepath.Join(pct.Basedir.Dir("config"), "*"+pct.CONFIG_FILE_SUFFIX))
+	for _, file := range configFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			add("config/"+filepath.Base(file), []byte(err.Error()))
+			continue
+		}
+		add("config/"+filepath.Base(file), sanitizeConfig(data))
+		hostnames = append(hostnames, configHostnames(data)...)
+	}
+
+	// Recent logs.
+	for _, file := range agent.logFiles() {
+		add("log/"+filepath.Base(file), tailFile(file, DIAG_LOG_BYTES))
+	}
+
+	// Status.
+	status := agent.AllStatus()
+	addJSON("status.json", status)
+	addJSON("status-tree.json", pct.StatusTree(status))
+
+	// Data spool.
+	add("spool.txt", spoolListing(pct.Basedir.Dir("data")))
+
+	// System facts.
+	hostname, _ := os.Hostname()
+	add("system.txt", systemFacts(hostname))
+
+	agent.configMux.RLock()
+	hostnames = append(hostnames, agent.config.Hostnames()...)
+	agent.configMux.RUnlock()
+	hostnames = append(hostnames, hostname)
+
+	// Explicit redaction pass over everything.
+	r := newRedactor(hostnames)
+	for _, f := range files {
+		f.data = r.redact(f.data)
+	}
+	return makeTarball(files)
+}
+
+func (agent *Agent) logFiles() []string {
+	candidates := []string{
+		pct.Basedir.File("audit-log"),
+		filepath.Join(pct.Basedir.Path(), "percona-agent.log"), // init script
+	}
+	logConfig := &struct{ File string }{}
+	if err := pct.Basedir.ReadConfig("log", logConfig); err == nil {
+		file := logConfig.File
+		if file != "" && file != "STDOUT" && file != "STDERR" {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(pct.Basedir.Path(), file)
+			}
+			candidates = append(candidates, file)
+		}
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, file := range candidates {
+		if seen[file] || !pct.FileExists(file) {
+			continue
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	return files
+}
+
+// sanitizeConfig removes secrets from a JSON config.  If it's not JSON, only
+// the redaction pass applies.
+func sanitizeConfig(data []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+	sanitized, err := json.MarshalIndent(sanitizeValue("", v), "", "  ")
+	if err != nil {
+		return data
+	}
+	return unescapeHTML(sanitized)
+}
+
+// configHostnames returns the Hostname values and DSN hosts in a config.
+func configHostnames(data []byte) []string {
+	hostnames := []string{}
+	config := &struct {
+		Hostname string
+		DSN      string
+	}{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return hostnames
+	}
+	if config.Hostname != "" {
+		hostnames = append(hostnames, config.Hostname)
+	}
+	if m := diagDSNHost.FindStringSubmatch(config.DSN); m != nil {
+		hostnames = append(hostnames, m[1])
+	}
+	return hostnames
+}
+
+// tailFile returns the last n bytes of a file, or the error.
+func tailFile(file string, n int64) []byte {
+	f, err := os.Open(file)
+	if err != nil {
+		return []byte(err.Error())
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > n {
+		f.Seek(-n, os.SEEK_END)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return []byte(err.Error())
+	}
+	return data
+}
+
+func spoolListing(dir string) []byte {
+	buf := &bytes.Buffer{}
+	files, total := 0, int64(0)
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(buf, "%s: %s\n", path, err)
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(buf, "%10d  %s  %s\n", fi.Size(), fi.ModTime().UTC().Format(time.RFC3339), rel)
+		files++
+		total += fi.Size()
+		return nil
+	})
+	fmt.Fprintf(buf, "%d files, %d bytes in %s\n", files, total, dir)
+	return buf.Bytes()
+}
+
+func systemFacts(hostname string) []byte {
+	facts := [][2]string{
+		{"Version", fmt.Sprintf("%s rev %s", VERSION, REVISION)},
+		{"Go", runtime.Version()},
+		{"OS", runtime.GOOS + "/" + runtime.GOARCH},
+		{"Hostname", hostname},
+		{"CPUs", fmt.Sprintf("%d", runtime.NumCPU())},
+		{"Goroutines", fmt.Sprintf("%d", runtime.NumGoroutine())},
+		{"PID", fmt.Sprintf("%d", os.Getpid())},
+		{"UID", fmt.Sprintf("%d", os.Getuid())},
+		{"Basedir", pct.Basedir.Path()},
+		{"Time", time.Now().UTC().Format(time.RFC3339)},
+		{"Clock", pct.ClockStatus()},
+	}
+	for _, proc := range []string{"sys/kernel/osrelease", "loadavg", "uptime"} {
+		if data, err := ioutil.ReadFile("/proc/" + proc); err == nil {
+			facts = append(facts, [2]string{"/proc/" + proc, strings.TrimSpace(string(data))})
+		}
+	}
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	facts = append(facts, [2]string{"Memory", fmt.Sprintf("%d bytes (Go sys)", memStats.Sys)})
+
+	buf := &bytes.Buffer{}
+	for _, f := range facts {
+		fmt.Fprintf(buf, "%-22s %s\n", f[0]+":", f[1])
+	}
+	return buf.Bytes()
+}
+
+// redactor removes secrets like redactText, and replaces the given hostnames.
+type redactor struct {
+	hosts []string
+	names map[string]string
+}
+
+func newRedactor(hostnames []string) *redactor {
+	r := &redactor{names: map[string]string{}}
+	for _, h := range hostnames {
+		h = strings.TrimSpace(h)
+		if i := strings.LastIndex(h, ":"); i > 0 && !strings.Contains(h[:i], ":") {
+			h = h[:i] // host:port
+		}
+		if len(h) < 3 || h == "localhost" || h == "127.0.0.1" || r.names[h] != "" {
+			continue
+		}
+		r.hosts = append(r.hosts, h)
+		r.names[h] = fmt.Sprintf("host-%d", len(r.hosts))
+	}
+	// Longest first so "db1.example.com" is replaced before "db1".
+	sort.Sort(byLength(r.hosts))
+	return r
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+
+func (r *redactor) redact(data []byte) []byte {
+	data = redactText(data)
+	for _, h := range r.hosts {
+		data = bytes.Replace(data, []byte(h), []byte(r.names[h]), -1)
+	}
+	return data
+}
+
+func makeTarball(files []*diagFile) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    DIAG_DIR + "/" + f.name,
+			Mode:    0600,
+			Size:    int64(len(f.data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(tw, bytes.NewReader(f.data)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
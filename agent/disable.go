@@ -0,0 +1,125 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Services that can be disabled.  The others, like data and log, are needed
+// by the agent and these services.
+var DISABLE_SERVICES = []string{"mm", "qan", "query", "sysinfo"}
+
+// Handle:@goroutine[3]
+func (agent *Agent) handleEnableService(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "EnableService", cmd)
+	agent.logger.Info(cmd)
+
+	m, name, err := agent.disableServiceManager(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.configMux.RLock()
+	disabled := agent.config.ServiceDisabled(name)
+	agent.configMux.RUnlock()
+	if !disabled {
+		return nil, nil // already enabled
+	}
+
+	// It might have been started by a StartService cmd.
+	if err := m.Start(); err != nil {
+		if _, running := err.(pct.ServiceIsRunningError); !running {
+			return nil, err
+		}
+	}
+	return nil, agent.setServiceDisabled(name, false)
+}
+
+// Handle:@goroutine[3]
+func (agent *Agent) handleDisableService(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "DisableService", cmd)
+	agent.logger.Info(cmd)
+
+	m, name, err := agent.disableServiceManager(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	agent.configMux.RLock()
+	disabled := agent.config.ServiceDisabled(name)
+	agent.configMux.RUnlock()
+	if disabled {
+		return nil, nil // already disabled
+	}
+
+	if err := m.Stop(); err != nil {
+		return nil, err
+	}
+	return nil, agent.setServiceDisabled(name, true)
+}
+
+// disableServiceManager returns the manager and name of the service in cmd.Data
+// (proto.ServiceData) if it can be enabled and disabled.
+func (agent *Agent) disableServiceManager(cmd *proto.Cmd) (pct.ServiceManager, string, error) {
+	s := &proto.ServiceData{}
+	if err := json.Unmarshal(cmd.Data, s); err != nil {
+		return nil, "", err
+	}
+	for _, service := range DISABLE_SERVICES {
+		if service != s.Name {
+			continue
+		}
+		m, ok := agent.services[s.Name]
+		if !ok {
+			return nil, "", pct.UnknownServiceError{Service: s.Name}
+		}
+		return m, s.Name, nil
+	}
+	return nil, "", fmt.Errorf("Service %s cannot be enabled or disabled, only: %v", s.Name, DISABLE_SERVICES)
+}
+
+// setServiceDisabled adds or removes the service from config.Disabled and writes
+// the config so the service stays enabled or disabled when the agent restarts.
+func (agent *Agent) setServiceDisabled(service string, disable bool) error {
+	agent.configMux.Lock()
+	defer agent.configMux.Unlock()
+	newConfig := *agent.config // copy current config
+	newConfig.Disabled = []string{}
+	for _, disabled := range agent.config.Disabled {
+		if disabled != service {
+			newConfig.Disabled = append(newConfig.Disabled, disabled)
+		}
+	}
+	if disable {
+		newConfig.Disabled = append(newConfig.Disabled, service)
+		sort.Strings(newConfig.Disabled)
+	}
+	agent.config = &newConfig
+	if err := pct.Basedir.WriteConfig("agent", newConfig); err != nil {
+		// The service is started or stopped but will revert if the agent restarts.
+		return errors.New("agent.WriteConfig:" + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+)
+
+const MAX_PAUSE = 24 * 3600 // seconds
+
+// Cmd.Data for the Pause cmd.
+type Pause struct {
+	Duration uint   // seconds, at most MAX_PAUSE
+	Reason   string `json:",omitempty"` // e.g. "backup"
+}
+
+// Handle:@goroutine[3]
+func (agent *Agent) handlePause(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "Pause", cmd)
+	p := &Pause{}
+	if len(cmd.Data) == 0 {
+		return nil, errors.New("cmd.Data is empty, expected Duration")
+	}
+	if err := json.Unmarshal(cmd.Data, p); err != nil {
+		return nil, err
+	}
+	if p.Duration == 0 || p.Duration > MAX_PAUSE {
+		return nil, fmt.Errorf("Invalid Duration: %d: must be 1 to %d seconds", p.Duration, MAX_PAUSE)
+	}
+
+	// Pausing again only changes when it resumes.
+	until := time.Now().Add(time.Duration(p.Duration) * time.Second)
+	agent.pauseMux.Lock()
+	defer agent.pauseMux.Unlock()
+	if agent.pauseTimer != nil {
+		agent.pauseTimer.Stop()
+	} else {
+		agent.setPaused(true)
+	}
+	agent.pauseTimer = time.AfterFunc(time.Duration(p.Duration)*time.Second, func() {
+		agent.pauseMux.Lock()
+		defer agent.pauseMux.Unlock()
+		agent.resume("auto")
+	})
+
+	status := fmt.Sprintf("Paused until %s", until.UTC().Format(time.RFC3339))
+	if p.Reason != "" {
+		status += ": " + p.Reason
+	}
+	agent.logger.Warn(status, "by", cmd.User)
+	agent.status.Update("agent-maintenance", status)
+	return nil, nil
+}
+
+// Handle:@goroutine[3]
+func (agent *Agent) handleResume(cmd *proto.Cmd) (interface{}, error) {
+	agent.status.UpdateRe("agent-cmd-handler", "Resume", cmd)
+	agent.pauseMux.Lock()
+	defer agent.pauseMux.Unlock()
+	if agent.pauseTimer == nil {
+		return nil, errors.New("Not paused")
+	}
+	agent.pauseTimer.Stop()
+	agent.resume(cmd.User)
+	return nil, nil
+}
+
+// resume resumes collection.  Caller must lock agent.pauseMux.
+func (agent *Agent) resume(by string) {
+	if agent.pauseTimer == nil {
+		return // already resumed
+	}
+	agent.pauseTimer = nil
+	agent.setPaused(false)
+	agent.logger.Info("Resumed by", by)
+	agent.status.Update("agent-maintenance", fmt.Sprintf("Resumed at %s by %s", time.Now().UTC().Format(time.RFC3339), by))
+}
+
+// setPaused pauses or resumes the services that collect and send data: mm
+// monitors, qan workers and the data sender.
+func (agent *Agent) setPaused(paused bool) {
+	for service, manager := range agent.services {
+		if pauser, ok := manager.(pct.Pauser); ok {
+			agent.logger.Debug("setPaused", service, paused)
+			pauser.Pause(paused)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"net/http"
+)
+
+// Register creates the agent in the API and sets config.AgentUuid and Links.
+// It's for deferred registration: an agent installed without connecting to
+// the API (installer -bundle without an agent UUID) has no UUID, so it
+// registers itself once the API is reachable.  Service configs are not sent;
+// the API gets them from the agent like for any running agent.
+func Register(api pct.APIConnector, config *Config, hostname string) error {
+	agent := &proto.Agent{
+		Hostname: hostname,
+		Version:  VERSION,
+	}
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return err
+	}
+
+	// POST <api>/agents
+	resp, _, err := api.Post(config.ApiKey, pct.URL(config.ApiHostname, "agents"), data)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("Failed to create agent (status code %d)", resp.StatusCode)
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return fmt.Errorf("API did not return location of new agent")
+	}
+
+	// GET <api>/agents/:uuid
+	code, data, err := api.Get(config.ApiKey, uri)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return fmt.Errorf("Failed to get new agent (status code %d)", code)
+	}
+	if err := json.Unmarshal(data, agent); err != nil {
+		return fmt.Errorf("Failed to parse agent entity: %s", err)
+	}
+	if agent.Uuid == "" {
+		return fmt.Errorf("API returned agent without UUID")
+	}
+	config.AgentUuid = agent.Uuid
+	config.Links = agent.Links
+	return nil
+}
@@ -0,0 +1,270 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Services configured with SetConfig; the others are started and stopped.
+var setConfigServices = map[string]bool{
+	"log":       true,
+	"data":      true,
+	"resource":  true,
+	"scheduler": true,
+}
+
+// Services which run one service (monitor) per config file.
+var startServiceServices = map[string]bool{
+	"mm":        true,
+	"sysconfig": true,
+	"qan":       true,
+	"event":     true,
+	"heartbeat": true,
+}
+
+// Reload queues a Reload cmd from the user, e.g. on SIGHUP, and returns its
+// error, if any.  See handleReload.
+func (agent *Agent) Reload(user string) error {
+	cmd := &proto.Cmd{
+		Ts:        time.Now().UTC(),
+		User:      user,
+		AgentUuid: agent.api.AgentUuid(),
+		Service:   "agent",
+		Cmd:       "Reload",
+	}
+	reply := agent.queueLocal(cmd)
+	if reply.Error != "" {
+		return fmt.Errorf("%s", reply.Error)
+	}
+	return nil
+}
+
+// handleReload re-reads the config files in the basedir and applies the
+// changes as if the API had sent the cmds: SetConfig for the agent, log and
+// data, and StopService then StartService for monitors and QAN.  Services
+// whose config did not change are not touched, so there are no gaps in their
+// data.  Then it reconnects to the API, like SIGHUP before configs were
+// reloaded, so a new API key or hostname is used and a stuck connection is
+// reset.  It runs in cmdHandler, so it's serialized with cmds from the API.
+// cmdHandler:@goroutine[3]
+func (agent *Agent) handleReload(cmd *proto.Cmd) (interface{}, []error) {
+	agentChanged, errs := agent.reload(cmd.User)
+	agent.logger.Info("Reloaded configs, reconnecting")
+	agent.client.Disconnect() // Run() reconnects, see Reconnect
+	return struct{ AgentChanged bool }{agentChanged}, errs
+}
+
+// reload applies the changed config files and returns true if the agent
+// config changed.
+func (agent *Agent) reload(user string) (bool, []error) {
+	agent.logger.Info("Reloading configs")
+	errs := []error{}
+
+	// Running configs by file name, e.g. mm-mysql-1, qan.
+	running := map[string]proto.AgentConfig{}
+	configs, getErrs := agent.handleGetAllConfigs(nil)
+	errs = append(errs, getErrs...)
+	for _, config := range configs.([]proto.AgentConfig) {
+		running[configName(config)] = config
+	}
+	// GetConfig doesn't return the agent Links, but they're in agent.conf.
+	agent.configMux.RLock()
+	agentConfig, _ := json.Marshal(agent.config)
+	agent.configMux.RUnlock()
+	running["agent"] = proto.AgentConfig{InternalService: "agent", Config: string(agentConfig), Running: true}
+
+	files, err := filepath.Glob(filepath.Join(pct.Basedir.Dir("config"), "*"+pct.CONFIG_FILE_SUFFIX))
+	if err != nil {
+		return false, append(errs, err)
+	}
+	agentChanged := false
+	onDisk := map[string]bool{}
+	loaded := agent.configFiles
+	agent.configFiles = map[string][]byte{}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), pct.CONFIG_FILE_SUFFIX)
+		service := strings.SplitN(name, "-", 2)[0]
+		if name != "agent" && !setConfigServices[name] && !startServiceServices[service] {
+			continue // instance or other file
+		}
+		onDisk[name] = true
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		agent.configFiles[name] = data
+		config, isRunning := running[name]
+		if isRunning && config.Running {
+			changed, err := ConfigChanged([]byte(config.Config), loaded[name], data)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", file, err))
+				continue
+			}
+			if !changed {
+				continue
+			}
+		}
+		agent.logger.Info("Config changed:", name)
+		switch {
+		case name == "agent":
+			// AllowCmds is only changed locally, not by SetConfig, so set it
+			// first else SetConfig writes the old value to the file.
+			fileConfig := &Config{}
+			if err := json.Unmarshal(data, fileConfig); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", file, err))
+				continue
+			}
+			if err := ValidateAllowCmds(fileConfig.AllowCmds); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", file, err))
+				continue
+			}
+			agent.configMux.Lock()
+			agent.config.AllowCmds = fileConfig.AllowCmds
+			agent.configMux.Unlock()
+			cmd := agent.reloadCmd(user, "agent", "SetConfig", data)
+			_, setErrs := agent.handleSetConfig(cmd)
+			errs = append(errs, setErrs...)
+			agentChanged = true
+		case setConfigServices[name]:
+			errs = append(errs, agent.reloadHandle(user, name, "SetConfig", data)...)
+		default:
+			// A disabled service stays stopped until EnableService, else
+			// StartService would silently turn it back on.
+			agent.configMux.RLock()
+			disabled := agent.config.ServiceDisabled(service)
+			agent.configMux.RUnlock()
+			if disabled {
+				agent.logger.Info("Not starting", name, "because", service, "is disabled")
+				continue
+			}
+			if isRunning && config.Running {
+				if stopErrs := agent.reloadHandle(user, service, "StopService", []byte(config.Config)); len(stopErrs) > 0 {
+					errs = append(errs, stopErrs...)
+					continue
+				}
+			}
+			errs = append(errs, agent.reloadHandle(user, service, "StartService", data)...)
+		}
+	}
+
+	// Stop monitors and QAN whose config files were removed.
+	for name, config := range running {
+		if onDisk[name] || !config.Running || !startServiceServices[config.InternalService] {
+			continue
+		}
+		agent.logger.Info("Config removed:", name)
+		errs = append(errs, agent.reloadHandle(user, config.InternalService, "StopService", []byte(config.Config))...)
+	}
+
+	return agentChanged, errs
+}
+
+// ConfigChanged returns true if a config file sets any value differently than
+// the running config, or removes a value that was in the file when it was last
+// loaded, if known (loaded is nil if not).  Other values only in the running
+// config are not changes because the services fill in defaults which are not
+// in the file.
+func ConfigChanged(running, loaded, file []byte) (bool, error) {
+	runningValues := map[string]interface{}{}
+	if err := json.Unmarshal(running, &runningValues); err != nil {
+		return false, err
+	}
+	fileValues := map[string]interface{}{}
+	if err := json.Unmarshal(file, &fileValues); err != nil {
+		return false, err
+	}
+	for k, v := range fileValues {
+		if !reflect.DeepEqual(runningValues[k], v) {
+			return true, nil
+		}
+	}
+	if loaded != nil {
+		loadedValues := map[string]interface{}{}
+		if err := json.Unmarshal(loaded, &loadedValues); err != nil {
+			return false, err
+		}
+		for k := range loadedValues {
+			if _, ok := fileValues[k]; !ok {
+				return true, nil // removed, so the service should use its default
+			}
+		}
+	}
+	return false, nil
+}
+
+// readConfigFiles returns the contents of the config files in the basedir
+// keyed on name, e.g. mm-mysql-1, to know which values were removed from a
+// file when it's reloaded.
+func readConfigFiles() map[string][]byte {
+	configFiles := map[string][]byte{}
+	files, _ := filepath.Glob(filepath.Join(pct.Basedir.Dir("config"), "*"+pct.CONFIG_FILE_SUFFIX))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		configFiles[strings.TrimSuffix(filepath.Base(file), pct.CONFIG_FILE_SUFFIX)] = data
+	}
+	return configFiles
+}
+
+func configName(config proto.AgentConfig) string {
+	if config.ExternalService.Service == "" {
+		return config.InternalService
+	}
+	if config.InternalService == "event" {
+		// Event monitors are named by type too, e.g. event-errlog-mysql-1.
+		c := struct{ Monitor string }{}
+		json.Unmarshal([]byte(config.Config), &c)
+		return fmt.Sprintf("event-%s-%s-%d", c.Monitor, config.ExternalService.Service, config.ExternalService.InstanceId)
+	}
+	return fmt.Sprintf("%s-%s-%d", config.InternalService, config.ExternalService.Service, config.ExternalService.InstanceId)
+}
+
+func (agent *Agent) reloadCmd(user, service, cmd string, data []byte) *proto.Cmd {
+	return &proto.Cmd{
+		Ts:        time.Now().UTC(),
+		User:      user,
+		AgentUuid: agent.api.AgentUuid(),
+		Service:   service,
+		Cmd:       cmd,
+		Data:      data,
+	}
+}
+
+func (agent *Agent) reloadHandle(user, service, cmd string, data []byte) []error {
+	manager, ok := agent.services[service]
+	if !ok {
+		return []error{pct.UnknownServiceError{Service: service}}
+	}
+	reply := manager.Handle(agent.reloadCmd(user, service, cmd, data))
+	if reply != nil && reply.Error != "" {
+		return []error{fmt.Errorf("%s %s: %s", service, cmd, reply.Error)}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"github.com/percona/percona-agent/agent"
+	. "gopkg.in/check.v1"
+)
+
+type ReloadTestSuite struct {
+}
+
+var _ = Suite(&ReloadTestSuite{})
+
+func (s *ReloadTestSuite) TestConfigChanged(t *C) {
+	running := []byte(`{"Service":"mysql","InstanceId":1,"Collect":1,"Report":60,"Status":{"threads_running":"gauge"}}`)
+
+	// Same values, different order.
+	changed, err := agent.ConfigChanged(running, nil, []byte(`{"Report":60,"Collect":1,"InstanceId":1,"Service":"mysql","Status":{"threads_running":"gauge"}}`))
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, false)
+
+	// Defaults filled in by the service are not in the file.
+	changed, err = agent.ConfigChanged(running, nil, []byte(`{"Service":"mysql","InstanceId":1,"Collect":1}`))
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, false)
+
+	changed, err = agent.ConfigChanged(running, nil, []byte(`{"Service":"mysql","InstanceId":1,"Collect":10,"Report":60}`))
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, true)
+
+	changed, err = agent.ConfigChanged(running, nil, []byte(`{"Status":{"threads_running":"counter"}}`))
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, true)
+
+	_, err = agent.ConfigChanged(running, nil, []byte(`{"Collect":`))
+	t.Check(err, NotNil)
+
+	// A value removed from the file is a change: the service should use its
+	// default instead, but only values that were in the file when loaded.
+	loaded := []byte(`{"Service":"mysql","InstanceId":1,"Collect":1,"Report":60}`)
+	changed, err = agent.ConfigChanged(running, loaded, []byte(`{"Service":"mysql","InstanceId":1,"Collect":1}`))
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, true)
+	changed, err = agent.ConfigChanged(running, loaded, loaded)
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, false)
+}
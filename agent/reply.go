@@ -0,0 +1,114 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+)
+
+// Replies are sent in parts only if the agent config MaxReplySize is set,
+// because the API must join them.  Reply data is base64-encoded twice: in the
+// ReplyPart, then the part in the JSON message, so max bytes of data is a
+// message of ~1.78x max, e.g. 512k is ~910k.
+const REPLY_PART_CMD = "ReplyPart"
+
+// A ReplyPart is one part of a reply too large for one message, e.g. a
+// processlist of thousands of connections.  It's sent as the Data of a reply
+// with the cmd's Id and Cmd REPLY_PART_CMD.  The API concatenates the Data of
+// parts 1 to Parts to get the reply Data, which must match Size and Checksum.
+// Only the last part has the reply Error, if any.  See SplitReply and JoinReply.
+type ReplyPart struct {
+	Cmd      string // of the reply
+	Part     uint   // 1 to Parts
+	Parts    uint
+	Size     int    // of the reply Data
+	Checksum string // SHA-256 hex of the reply Data
+	Data     []byte
+}
+
+// SplitReply returns the parts to send for the reply: the reply alone if its
+// Data isn't larger than max bytes or max is 0, else REPLY_PART_CMD replies.
+func SplitReply(reply *proto.Reply, max int) []*proto.Reply {
+	if max <= 0 || len(reply.Data) <= max {
+		return []*proto.Reply{reply}
+	}
+	sum := sha256.Sum256(reply.Data)
+	checksum := hex.EncodeToString(sum[:])
+	n := (len(reply.Data) + max - 1) / max
+	parts := make([]*proto.Reply, n)
+	for i := 0; i < n; i++ {
+		end := (i + 1) * max
+		if end > len(reply.Data) {
+			end = len(reply.Data)
+		}
+		part := ReplyPart{
+			Cmd:      reply.Cmd,
+			Part:     uint(i + 1),
+			Parts:    uint(n),
+			Size:     len(reply.Data),
+			Checksum: checksum,
+			Data:     reply.Data[i*max : end],
+		}
+		data, _ := json.Marshal(part) // can't fail
+		parts[i] = &proto.Reply{
+			Id:   reply.Id,
+			Cmd:  REPLY_PART_CMD,
+			Data: data,
+		}
+	}
+	parts[n-1].Error = reply.Error
+	return parts
+}
+
+// JoinReply returns the reply split into the parts by SplitReply, in order.
+func JoinReply(parts []*proto.Reply) (*proto.Reply, error) {
+	if len(parts) == 1 && parts[0].Cmd != REPLY_PART_CMD {
+		return parts[0], nil
+	}
+	var buf bytes.Buffer
+	var first ReplyPart
+	for i, reply := range parts {
+		part := ReplyPart{}
+		if err := json.Unmarshal(reply.Data, &part); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			first = part
+		}
+		if reply.Cmd != REPLY_PART_CMD || reply.Id != parts[0].Id || part.Part != uint(i+1) || part.Parts != uint(len(parts)) || part.Checksum != first.Checksum {
+			return nil, fmt.Errorf("Reply part %d of %d is not part %d of %d of reply %d", part.Part, part.Parts, i+1, len(parts), parts[0].Id)
+		}
+		buf.Write(part.Data)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if buf.Len() != first.Size || hex.EncodeToString(sum[:]) != first.Checksum {
+		return nil, fmt.Errorf("Reply %d data does not match its size or checksum", parts[0].Id)
+	}
+	reply := &proto.Reply{
+		Id:    parts[0].Id,
+		Cmd:   first.Cmd,
+		Error: parts[len(parts)-1].Error,
+		Data:  buf.Bytes(),
+	}
+	return reply, nil
+}
@@ -0,0 +1,76 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	. "gopkg.in/check.v1"
+)
+
+type ReplyTestSuite struct {
+}
+
+var _ = Suite(&ReplyTestSuite{})
+
+func (s *ReplyTestSuite) TestSplitReply(t *C) {
+	// Small replies are sent as is.
+	reply := &proto.Reply{Id: 1, Cmd: "GetProcesslist", Data: []byte("[]")}
+	parts := agent.SplitReply(reply, 10)
+	t.Assert(parts, HasLen, 1)
+	t.Check(parts[0], Equals, reply)
+	got, err := agent.JoinReply(parts)
+	t.Check(err, IsNil)
+	t.Check(got, Equals, reply)
+
+	// Parts are disabled by default (max 0).
+	reply = &proto.Reply{Id: 1, Cmd: "GetProcesslist", Data: bytes.Repeat([]byte("a"), 1024)}
+	parts = agent.SplitReply(reply, 0)
+	t.Assert(parts, HasLen, 1)
+	t.Check(parts[0], Equals, reply)
+
+	// 25 bytes in parts of 10: 10, 10, 5.
+	data := bytes.Repeat([]byte("abcde"), 5)
+	reply = &proto.Reply{Id: 2, Cmd: "GetProcesslist", Error: "partial", Data: data}
+	parts = agent.SplitReply(reply, 10)
+	t.Assert(parts, HasLen, 3)
+	for i, p := range parts {
+		t.Check(p.Id, Equals, reply.Id)
+		t.Check(p.Cmd, Equals, agent.REPLY_PART_CMD)
+		part := agent.ReplyPart{}
+		t.Assert(json.Unmarshal(p.Data, &part), IsNil)
+		t.Check(part.Cmd, Equals, "GetProcesslist")
+		t.Check(part.Part, Equals, uint(i+1))
+		t.Check(part.Parts, Equals, uint(3))
+		t.Check(part.Size, Equals, 25)
+	}
+	t.Check(parts[0].Error, Equals, "")
+	t.Check(parts[2].Error, Equals, "partial")
+
+	got, err = agent.JoinReply(parts)
+	t.Assert(err, IsNil)
+	t.Check(got, DeepEquals, reply)
+
+	// Missing or out-of-order parts aren't joined.
+	_, err = agent.JoinReply(parts[:2])
+	t.Check(err, NotNil)
+	_, err = agent.JoinReply([]*proto.Reply{parts[1], parts[0], parts[2]})
+	t.Check(err, NotNil)
+}
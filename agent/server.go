@@ -0,0 +1,365 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-agent/data"
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	HEALTH_READY    = "ready"
+	HEALTH_DEGRADED = "degraded"
+)
+
+type Health struct {
+	Status  string   // HEALTH_* const
+	Reasons []string `json:",omitempty"` // why degraded
+}
+
+type Version struct {
+	Version  string
+	Revision string
+}
+
+// RuntimeStats are Go runtime stats for diagnosing CPU and memory problems.
+type RuntimeStats struct {
+	GoVersion    string
+	NumCPU       int
+	GOMAXPROCS   int
+	Goroutines   int
+	HeapAlloc    uint64 // bytes
+	HeapInuse    uint64 // bytes
+	HeapObjects  uint64
+	Sys          uint64 // bytes from OS
+	NumGC        uint32
+	PauseTotalNs uint64
+	LastGC       time.Time `json:",omitempty"`
+}
+
+func GetRuntimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := RuntimeStats{
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+	}
+	if m.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC)).UTC()
+	}
+	return stats
+}
+
+// StatusServer serves the agent status over HTTP on a local address
+// (Config.StatusAddress), so it can be checked without the API:
+//
+//	/status   all status, like agent -status but local
+//	/health   ready or degraded, HTTP 503 if degraded
+//	/version  agent version and revision
+//
+// and, if SetRecent is called, the most recent data held in memory, read-only,
+// so it's available during API outages (?n=1 for only the latest):
+//
+//	/data/mm   last mm reports (metric intervals)
+//	/data/qan  last QAN reports (query class summaries)
+//
+// Query examples in the data are real queries, which can have sensitive
+// values, and any local user can connect to the server, so they're removed
+// unless the request has the agent API key in the X-Percona-API-Key header.
+//
+// and, if EnableDebug is called (Config.StatusDebug), runtime diagnostics:
+//
+//	/debug/runtime  RuntimeStats
+//	/debug/vars     expvar, including runtime.MemStats
+//	/debug/pprof/   net/http/pprof profiles
+type StatusServer struct {
+	agent    *Agent
+	addr     string
+	mux      *http.ServeMux
+	listener net.Listener
+	recent   RecentData
+}
+
+// RecentData is the data served on /data/, e.g. a data.RecentSpooler.
+type RecentData interface {
+	Recent(service string, n int) ([]data.Recent, bool)
+}
+
+func NewStatusServer(agent *Agent, addr string) *StatusServer {
+	s := &StatusServer{
+		agent: agent,
+		addr:  addr,
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/status", s.status)
+	s.mux.HandleFunc("/health", s.health)
+	s.mux.HandleFunc("/version", s.version)
+	return s
+}
+
+// SetRecent serves the recent data on /data/.  It must be called before Start.
+func (s *StatusServer) SetRecent(recent RecentData) {
+	s.recent = recent
+	s.mux.HandleFunc("/data/", s.recentData)
+}
+
+// EnableDebug serves runtime stats and pprof profiles, for diagnosing high
+// CPU or memory leaks in the field.  It must be called before Start.
+func (s *StatusServer) EnableDebug() {
+	s.mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, GetRuntimeStats())
+	})
+	s.mux.Handle("/debug/vars", expvar.Handler())
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// Start listens on the address, which must be localhost because the status
+// is not authenticated, and serves in a goroutine.
+func (s *StatusServer) Start() error {
+	listener, err := ListenStatus(s.addr)
+	if err != nil {
+		return err
+	}
+	s.Serve(listener)
+	return nil
+}
+
+// ListenStatus listens on the status server address, which must be localhost.
+// The agent listens before dropping privileges (-user), then calls Serve.
+func ListenStatus(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid status address %s: %s", addr, err)
+	}
+	if !IsLoopback(host) {
+		return nil, fmt.Errorf("Invalid status address %s: host must be localhost, 127.0.0.1 or ::1", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve serves on the listener from ListenStatus in a goroutine.
+func (s *StatusServer) Serve(listener net.Listener) {
+	s.listener = listener
+	go http.Serve(listener, s.mux)
+}
+
+func (s *StatusServer) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Addr returns the address the server listens on, e.g. if the configured
+// port was 0.
+func (s *StatusServer) Addr() string {
+	if s.listener == nil {
+		return s.addr
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *StatusServer) status(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("version") == "2" {
+		writeJSON(w, http.StatusOK, pct.StatusTree(s.agent.AllStatus()))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.agent.AllStatus())
+}
+
+func (s *StatusServer) health(w http.ResponseWriter, r *http.Request) {
+	services := make([]string, 0, len(s.agent.services))
+	for service := range s.agent.services {
+		services = append(services, service)
+	}
+	s.agent.configMux.RLock()
+	disabled := append([]string{}, s.agent.config.Disabled...)
+	s.agent.configMux.RUnlock()
+	health := CheckHealth(s.agent.AllStatus(), services, disabled)
+	code := http.StatusOK
+	if health.Status != HEALTH_READY {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, health)
+}
+
+func (s *StatusServer) recentData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Read-only: only GET is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err != nil || !IsLoopback(host) {
+		http.Error(w, "Only local clients are allowed", http.StatusForbidden)
+		return
+	}
+	n := 0
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "Invalid n: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+	service := strings.TrimPrefix(r.URL.Path, "/data/")
+	recent, ok := s.recent.Recent(service, n)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.hasApiKey(r) {
+		recent = StripExamples(recent)
+	}
+	writeJSON(w, http.StatusOK, recent)
+}
+
+// hasApiKey returns true if the request has the agent API key, which only
+// the agent user (and root) can read from the config file.
+func (s *StatusServer) hasApiKey(r *http.Request) bool {
+	s.agent.configMux.RLock()
+	apiKey := s.agent.config.ApiKey
+	s.agent.configMux.RUnlock()
+	got := r.Header.Get("X-Percona-API-Key")
+	return apiKey != "" && subtle.ConstantTimeCompare([]byte(got), []byte(apiKey)) == 1
+}
+
+func (s *StatusServer) version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Version{Version: VERSION, Revision: REVISION})
+}
+
+// CheckHealth returns ready if all websockets (status *-ws) are connected,
+// all service managers are running and not over their limits (resource), and
+// nothing has crashed or been given up on by the watchdog, else degraded and why.
+// Disabled services (agent Config.Disabled) are not checked: they're stopped.
+func CheckHealth(status map[string]string, services, disabled []string) Health {
+	isDisabled := func(proc string) bool {
+		for _, service := range disabled {
+			if proc == service || strings.HasPrefix(proc, service+"-") || proc == "agent-watchdog-"+service {
+				return true
+			}
+		}
+		return false
+	}
+	reasons := []string{}
+	for k, v := range status {
+		if isDisabled(k) {
+			continue
+		}
+		if strings.HasSuffix(k, "-ws") && !strings.HasPrefix(v, "Connected") {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", k, v))
+		} else if strings.HasPrefix(v, "Crashed") || strings.HasPrefix(v, "Gave up") {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", k, v))
+		}
+	}
+	for _, service := range services {
+		if isDisabled(service) {
+			continue
+		}
+		v, ok := status[service]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("%s: no status", service))
+		case strings.HasPrefix(v, "Stopped") || strings.HasPrefix(v, "ERROR") || strings.HasPrefix(v, "Over limit"):
+			reasons = append(reasons, fmt.Sprintf("%s: %s", service, v))
+		}
+	}
+	if len(reasons) > 0 {
+		sort.Strings(reasons)
+		return Health{Status: HEALTH_DEGRADED, Reasons: reasons}
+	}
+	return Health{Status: HEALTH_READY}
+}
+
+// StripExamples returns a copy of the recent data without query examples,
+// i.e. the Example of each qan.Report class.  The data is copied as JSON
+// because it's shared with the spool, so it must not be changed.
+func StripExamples(recent []data.Recent) []data.Recent {
+	stripped := make([]data.Recent, len(recent))
+	for i, r := range recent {
+		stripped[i].Ts = r.Ts
+		bytes, err := json.Marshal(r.Data)
+		if err != nil {
+			continue // don't serve what can't be checked
+		}
+		var v interface{}
+		if err := json.Unmarshal(bytes, &v); err != nil {
+			continue
+		}
+		stripped[i].Data = stripExample(v)
+	}
+	return stripped
+}
+
+func stripExample(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		delete(val, "Example")
+		for k, v := range val {
+			val[k] = stripExample(v)
+		}
+	case []interface{}:
+		for i, v := range val {
+			val[i] = stripExample(v)
+		}
+	}
+	return v
+}
+
+func IsLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bytes)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,257 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"encoding/json"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/data"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test/mock"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+type ServerTestSuite struct {
+}
+
+var _ = Suite(&ServerTestSuite{})
+
+func (s *ServerTestSuite) TestCheckHealth(t *C) {
+	services := []string{"log", "data", "mm"}
+	status := map[string]string{
+		"agent":        "Idle",
+		"agent-ws":     "Connected ws://localhost/agents/123/cmd",
+		"log":          "Running",
+		"log-ws":       "Connected ws://localhost/agents/123/log",
+		"data":         "Running",
+		"data-ws":      "Connected ws://localhost/agents/123/data",
+		"data-ws-link": "ws://localhost/agents/123/data",
+		"mm":           "Running",
+	}
+	t.Check(agent.CheckHealth(status, services, nil), DeepEquals, agent.Health{Status: agent.HEALTH_READY})
+
+	status["data-ws"] = "Disconnected"
+	status["mm"] = "Stopped"
+	delete(status, "log")
+	t.Check(agent.CheckHealth(status, services, nil), DeepEquals, agent.Health{
+		Status: agent.HEALTH_DEGRADED,
+		Reasons: []string{
+			"data-ws: Disconnected",
+			"log: no status",
+			"mm: Stopped",
+		},
+	})
+	status = map[string]string{"resource": "Over limit: fds 900 > 500"}
+	t.Check(agent.CheckHealth(status, []string{"resource"}, nil).Reasons, DeepEquals, []string{"resource: Over limit: fds 900 > 500"})
+	status = map[string]string{"data": "Running", "data-sender": "Crashed", "agent-watchdog-data": "Gave up after 5 restarts"}
+	t.Check(agent.CheckHealth(status, []string{"data"}, nil).Reasons, DeepEquals, []string{
+		"agent-watchdog-data: Gave up after 5 restarts",
+		"data-sender: Crashed",
+	})
+
+	// Disabled services are stopped, which is healthy.
+	status = map[string]string{"mm": "Running", "qan": "Stopped", "qan-parser": "Stopped", "agent-watchdog-qan": "Gave up after 5 restarts"}
+	t.Check(agent.CheckHealth(status, []string{"mm", "qan"}, []string{"qan"}), DeepEquals, agent.Health{Status: agent.HEALTH_READY})
+}
+
+func (s *ServerTestSuite) TestStatusServer(t *C) {
+	sendChan := make(chan *proto.Cmd, 1)
+	recvChan := make(chan *proto.Reply, 1)
+	client := mock.NewWebsocketClient(sendChan, recvChan, nil, nil)
+	a := agent.NewAgent(&agent.Config{}, nil, nil, client, nil)
+
+	server := agent.NewStatusServer(a, "0.0.0.0:0")
+	t.Check(server.Start(), NotNil) // not localhost
+
+	server = agent.NewStatusServer(a, "127.0.0.1:0")
+	t.Assert(server.Start(), IsNil)
+	defer server.Stop()
+
+	resp, err := http.Get("http://" + server.Addr() + "/version")
+	t.Assert(err, IsNil)
+	t.Check(resp.StatusCode, Equals, http.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Assert(err, IsNil)
+	v := agent.Version{}
+	t.Assert(json.Unmarshal(body, &v), IsNil)
+	t.Check(v.Version, Equals, agent.VERSION)
+}
+
+func (s *ServerTestSuite) TestStatusServerDebug(t *C) {
+	client := mock.NewWebsocketClient(nil, nil, nil, nil)
+	a := agent.NewAgent(&agent.Config{}, nil, nil, client, nil)
+
+	// Debug endpoints are off by default.
+	server := agent.NewStatusServer(a, "127.0.0.1:0")
+	t.Assert(server.Start(), IsNil)
+	resp, err := http.Get("http://" + server.Addr() + "/debug/runtime")
+	t.Assert(err, IsNil)
+	resp.Body.Close()
+	t.Check(resp.StatusCode, Equals, http.StatusNotFound)
+	server.Stop()
+
+	server = agent.NewStatusServer(a, "127.0.0.1:0")
+	server.EnableDebug()
+	t.Assert(server.Start(), IsNil)
+	defer server.Stop()
+
+	resp, err = http.Get("http://" + server.Addr() + "/debug/runtime")
+	t.Assert(err, IsNil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Assert(err, IsNil)
+	t.Check(resp.StatusCode, Equals, http.StatusOK)
+	stats := agent.RuntimeStats{}
+	t.Assert(json.Unmarshal(body, &stats), IsNil)
+	t.Check(stats.Goroutines > 0, Equals, true)
+	t.Check(stats.HeapAlloc > 0, Equals, true)
+
+	for _, path := range []string{"/debug/vars", "/debug/pprof/", "/debug/pprof/goroutine?debug=1"} {
+		resp, err = http.Get("http://" + server.Addr() + path)
+		t.Assert(err, IsNil)
+		resp.Body.Close()
+		t.Check(resp.StatusCode, Equals, http.StatusOK, Commentf(path))
+	}
+}
+
+func (s *ServerTestSuite) TestStatusServerRecentData(t *C) {
+	client := mock.NewWebsocketClient(nil, nil, nil, nil)
+	a := agent.NewAgent(&agent.Config{ApiKey: "123"}, nil, nil, client, nil)
+
+	recent := data.NewRecentSpooler(mock.NewSpooler(nil), 10, "mm", "qan")
+	recent.Write("mm", map[string]int{"interval": 1})
+	recent.Write("mm", map[string]int{"interval": 2})
+
+	server := agent.NewStatusServer(a, "127.0.0.1:0")
+	server.SetRecent(recent)
+	t.Assert(server.Start(), IsNil)
+	defer server.Stop()
+
+	get := func(path string) (int, []byte) {
+		resp, err := http.Get("http://" + server.Addr() + path)
+		t.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Assert(err, IsNil)
+		return resp.StatusCode, body
+	}
+
+	code, body := get("/data/mm?n=1")
+	t.Check(code, Equals, http.StatusOK)
+	got := []struct {
+		Data map[string]int
+	}{}
+	t.Assert(json.Unmarshal(body, &got), IsNil)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Data["interval"], Equals, 2)
+
+	code, body = get("/data/qan")
+	t.Check(code, Equals, http.StatusOK)
+	t.Check(string(body), Equals, "[]\n")
+
+	// Query examples only with the API key.
+	recent.Write("qan", map[string]interface{}{
+		"Class": []map[string]interface{}{
+			{"Id": "abc", "Example": map[string]string{"Query": "SELECT secret"}},
+		},
+	})
+	code, body = get("/data/qan")
+	t.Check(code, Equals, http.StatusOK)
+	t.Check(strings.Contains(string(body), `"abc"`), Equals, true)
+	t.Check(strings.Contains(string(body), "SELECT secret"), Equals, false)
+
+	for key, want := range map[string]bool{"123": true, "wrong": false} {
+		req, err := http.NewRequest("GET", "http://"+server.Addr()+"/data/qan", nil)
+		t.Assert(err, IsNil)
+		req.Header.Set("X-Percona-API-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		t.Assert(err, IsNil)
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Assert(err, IsNil)
+		t.Check(strings.Contains(string(body), "SELECT secret"), Equals, want, Commentf(key))
+	}
+
+	code, _ = get("/data/sysconfig")
+	t.Check(code, Equals, http.StatusNotFound)
+
+	code, _ = get("/data/mm?n=x")
+	t.Check(code, Equals, http.StatusBadRequest)
+
+	resp, err := http.Post("http://"+server.Addr()+"/data/mm", "application/json", nil)
+	t.Assert(err, IsNil)
+	resp.Body.Close()
+	t.Check(resp.StatusCode, Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *ServerTestSuite) TestControlServer(t *C) {
+	dir := t.MkDir()
+	t.Assert(pct.Basedir.Init(dir), IsNil) // for the audit log
+	logChan := make(chan *proto.LogEntry, 100)
+	client := mock.NewWebsocketClient(nil, nil, nil, nil)
+	services := map[string]pct.ServiceManager{
+		"mm": mock.NewMockServiceManager("mm", make(chan bool), make(chan string, 10)),
+	}
+	a := agent.NewAgent(&agent.Config{}, pct.NewLogger(logChan, "agent"), nil, client, services)
+
+	socket := filepath.Join(dir, pct.CONTROL_SOCK)
+	server := agent.NewControlServer(a, socket)
+	t.Assert(server.Start(), IsNil)
+	defer server.Stop()
+
+	// Only one agent per socket.
+	t.Check(agent.NewControlServer(a, socket).Start(), ErrorMatches, "Another agent is listening on .+")
+
+	reply, err := agent.SendControlCmd(socket, &proto.Cmd{Service: "agent", Cmd: "Ping"})
+	t.Assert(err, IsNil)
+	v := agent.Version{}
+	t.Assert(json.Unmarshal(reply.Data, &v), IsNil)
+	t.Check(v.Version, Equals, agent.VERSION)
+
+	reply, err = agent.SendControlCmd(socket, &proto.Cmd{Service: "agent", Cmd: "Status"})
+	t.Assert(err, IsNil)
+	status := map[string]string{}
+	t.Assert(json.Unmarshal(reply.Data, &status), IsNil)
+	_, ok := status["agent-maintenance"]
+	t.Check(ok, Equals, true)
+
+	// Like "percona-agent status-history": StatusQuery is the cmd data, not
+	// JSON already, so the reply is version 2.
+	cmd, err := agent.NewControlCmd("root (local)", "agent", "Status", agent.StatusQuery{Version: 2})
+	t.Assert(err, IsNil)
+	t.Check(agent.StatusVersion(cmd), Equals, uint(2))
+	reply, err = agent.SendControlCmd(socket, cmd)
+	t.Assert(err, IsNil)
+	tree := []*pct.StatusNode{}
+	t.Assert(json.Unmarshal(reply.Data, &tree), IsNil)
+	t.Check(len(tree) > 0, Equals, true)
+
+	// Cmds to services are queued for the cmd handler, see AgentTestSuite.TestControlCmd.
+
+	// Stopping removes the socket, so it can be started again.
+	t.Assert(server.Stop(), IsNil)
+	t.Check(pct.FileExists(socket), Equals, false)
+	_, err = agent.SendControlCmd(socket, &proto.Cmd{Service: "agent", Cmd: "Ping"})
+	t.Check(err, ErrorMatches, "Cannot connect to agent: .+")
+}
@@ -0,0 +1,165 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	WATCHDOG_INTERVAL     = 10 * time.Second
+	WATCHDOG_MAX_RESTARTS = 5
+	WATCHDOG_BACKOFF      = 10 * time.Second // doubles after each restart
+	WATCHDOG_STABLE       = 10 * time.Minute // no crash this long resets restarts
+)
+
+type restarts struct {
+	n      int
+	last   time.Time
+	next   time.Time
+	gaveUp bool
+}
+
+// Watchdog restarts service managers that have crashed, i.e. have a "Crashed"
+// status.  It waits longer after each restart (backoff) and gives up after
+// maxRestarts restarts without the service running WATCHDOG_STABLE.
+type Watchdog struct {
+	logger      *pct.Logger
+	services    map[string]pct.ServiceManager
+	maxRestarts int
+	backoff     time.Duration
+	// --
+	restarts map[string]*restarts
+	checking bool
+	mux      *sync.Mutex // guards restarts and checking
+}
+
+func NewWatchdog(logger *pct.Logger, services map[string]pct.ServiceManager, maxRestarts int, backoff time.Duration) *Watchdog {
+	w := &Watchdog{
+		logger:      logger,
+		services:    services,
+		maxRestarts: maxRestarts,
+		backoff:     backoff,
+		// --
+		restarts: make(map[string]*restarts),
+		mux:      &sync.Mutex{},
+	}
+	return w
+}
+
+// Crashed returns the status keys with a "Crashed" value, sorted.
+func Crashed(status map[string]string) []string {
+	crashed := []string{}
+	for k, v := range status {
+		if strings.HasPrefix(v, "Crashed") {
+			crashed = append(crashed, k)
+		}
+	}
+	sort.Strings(crashed)
+	return crashed
+}
+
+// Check restarts crashed services that are due.  It returns immediately if
+// a previous Check is still restarting services.
+// @goroutine[3]
+func (w *Watchdog) Check() {
+	w.mux.Lock()
+	if w.checking {
+		w.mux.Unlock()
+		return
+	}
+	w.checking = true
+	w.mux.Unlock()
+	defer func() {
+		w.mux.Lock()
+		w.checking = false
+		w.mux.Unlock()
+	}()
+
+	for service, manager := range w.services {
+		if manager == nil {
+			continue
+		}
+		crashed := Crashed(manager.Status())
+		now := time.Now()
+
+		w.mux.Lock()
+		r, ok := w.restarts[service]
+		if len(crashed) == 0 {
+			if ok && now.Sub(r.last) > WATCHDOG_STABLE {
+				delete(w.restarts, service)
+			}
+			w.mux.Unlock()
+			continue
+		}
+		if !ok {
+			r = &restarts{}
+			w.restarts[service] = r
+		}
+		if r.gaveUp || now.Before(r.next) {
+			w.mux.Unlock()
+			continue
+		}
+		if r.n >= w.maxRestarts {
+			r.gaveUp = true
+			w.mux.Unlock()
+			w.logger.Error(fmt.Sprintf("%s crashed (%s) after %d restarts, not restarting it again",
+				service, strings.Join(crashed, ", "), r.n))
+			continue
+		}
+		r.n++
+		r.last = now
+		r.next = now.Add(w.backoff * time.Duration(1<<uint(r.n-1)))
+		n := r.n
+		w.mux.Unlock()
+
+		w.logger.Warn(fmt.Sprintf("%s crashed (%s), restarting (%d of %d)",
+			service, strings.Join(crashed, ", "), n, w.maxRestarts))
+		if err := manager.Stop(); err != nil {
+			w.logger.Warn(service, "stop:", err)
+		}
+		if err := manager.Start(); err != nil {
+			w.logger.Error(service, "restart:", err)
+		}
+	}
+}
+
+// Status returns "agent-watchdog-<service>" for each service restarted.
+func (w *Watchdog) Status() map[string]string {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	status := make(map[string]string)
+	for service, r := range w.restarts {
+		if r.n == 0 {
+			continue
+		}
+		k := "agent-watchdog-" + service
+		if r.gaveUp {
+			status[k] = fmt.Sprintf("Gave up after %d restarts, last at %s", r.n, r.last.UTC().Format(time.RFC3339))
+		} else {
+			status[k] = fmt.Sprintf("Restarted %d times, last at %s", r.n, r.last.UTC().Format(time.RFC3339))
+		}
+	}
+	return status
+}
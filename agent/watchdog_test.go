@@ -0,0 +1,102 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test"
+	"github.com/percona/percona-agent/test/mock"
+	. "gopkg.in/check.v1"
+)
+
+type WatchdogTestSuite struct {
+	logChan   chan *proto.LogEntry
+	logger    *pct.Logger
+	readyChan chan bool
+	traceChan chan string
+	manager   *mock.MockServiceManager
+}
+
+var _ = Suite(&WatchdogTestSuite{})
+
+func (s *WatchdogTestSuite) SetUpTest(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "watchdog-test")
+	s.readyChan = make(chan bool, 10)
+	s.traceChan = make(chan string, 100)
+	s.manager = mock.NewMockServiceManager("mm", s.readyChan, s.traceChan)
+}
+
+func (s *WatchdogTestSuite) TestCrashed(t *C) {
+	status := map[string]string{
+		"data":         "Running",
+		"data-spooler": "Crashed",
+		"data-sender":  "Crashed",
+	}
+	t.Check(agent.Crashed(status), DeepEquals, []string{"data-sender", "data-spooler"})
+	t.Check(agent.Crashed(map[string]string{"data": "Running"}), HasLen, 0)
+}
+
+func (s *WatchdogTestSuite) TestRestart(t *C) {
+	services := map[string]pct.ServiceManager{"mm": s.manager}
+	w := agent.NewWatchdog(s.logger, services, 2, 0)
+
+	// Not crashed: nothing to do.
+	w.Check()
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Status mm"})
+	t.Check(w.Status(), HasLen, 0)
+
+	// Crash is restarted twice...
+	for i := 0; i < 2; i++ {
+		s.manager.Crash()
+		s.readyChan <- true
+		s.readyChan <- true
+		w.Check()
+		t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Status mm", "Stop mm", "Start mm"})
+	}
+	t.Check(s.manager.Status()["mm"], Equals, "Ready")
+	test.WaitTrace(s.traceChan)
+	t.Check(w.Status()["agent-watchdog-mm"], Matches, "Restarted 2 times, last at .+")
+
+	// ...then the watchdog gives up.
+	s.manager.Crash()
+	w.Check()
+	w.Check()
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Status mm", "Status mm"})
+	t.Check(w.Status()["agent-watchdog-mm"], Matches, "Gave up after 2 restarts, last at .+")
+}
+
+func (s *WatchdogTestSuite) TestBackoff(t *C) {
+	services := map[string]pct.ServiceManager{"mm": s.manager}
+	w := agent.NewWatchdog(s.logger, services, 5, time.Hour)
+
+	s.manager.Crash()
+	s.readyChan <- true
+	s.readyChan <- true
+	w.Check()
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Status mm", "Stop mm", "Start mm"})
+
+	// Crashes again but the next restart isn't for an hour.
+	s.manager.Crash()
+	w.Check()
+	t.Check(test.WaitTrace(s.traceChan), DeepEquals, []string{"Status mm"})
+}
@@ -23,10 +23,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	pctLog "github.com/percona/percona-agent/log"
 	"io/ioutil"
 	golog "log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -250,10 +253,56 @@ func (cli *Cli) send(args []string) {
 		Cmd:       args[1],
 		Service:   args[2],
 	}
-	if len(args) == 4 {
+	if args[1] == "Pause" {
+		// send Pause agent duration [reason...], e.g. send Pause agent 2h nightly backup
+		if len(args) < 4 {
+			fmt.Println("Usage: send Pause agent duration [reason]")
+			return
+		}
+		d, err := time.ParseDuration(args[3])
+		if err != nil || d < time.Second {
+			fmt.Printf("Invalid duration: %s\n", args[3])
+			return
+		}
+		pause := agent.Pause{
+			Duration: uint(d.Seconds()),
+			Reason:   strings.Join(args[4:], " "),
+		}
+		cmd.Data, _ = json.Marshal(pause)
+	} else if args[1] == "SetLogLevel" {
+		// send SetLogLevel log level [service [duration]], e.g. send SetLogLevel log debug data 30m
+		if len(args) < 4 {
+			fmt.Println("Usage: send SetLogLevel log level [service [duration]]")
+			return
+		}
+		logLevel := pctLog.LogLevel{Level: args[3]}
+		if len(args) > 4 {
+			logLevel.Service = args[4]
+		}
+		if len(args) > 5 {
+			d, err := time.ParseDuration(args[5])
+			if err != nil || d < time.Second {
+				fmt.Printf("Invalid duration: %s\n", args[5])
+				return
+			}
+			logLevel.Duration = uint(d.Seconds())
+		}
+		cmd.Data, _ = json.Marshal(logLevel)
+	} else if len(args) == 4 {
 		switch args[1] {
 		case "Update":
 			cmd.Data = []byte(args[3])
+		case "StartService", "StopService", "EnableService", "DisableService":
+			cmd.Data, _ = json.Marshal(proto.ServiceData{Name: args[3]})
+		case "GetAuditLog":
+			limit, err := strconv.Atoi(args[3])
+			if err != nil {
+				fmt.Printf("Invalid limit: %s\n", args[3])
+				return
+			}
+			cmd.Data, _ = json.Marshal(agent.AuditQuery{Limit: limit})
+		case "GetRecentLogs":
+			cmd.Data, _ = json.Marshal(pctLog.RecentLogsQuery{Service: args[3]})
 		default:
 			fmt.Printf("Unknown arg: %s\n", args[3])
 			return
@@ -278,6 +327,24 @@ func (cli *Cli) send(args []string) {
 			return
 		}
 		fmt.Printf("%#v\n", v)
+	case "GetAuditLog":
+		entries := []agent.AuditEntry{}
+		if err := json.Unmarshal(reply.Data, &entries); err != nil {
+			fmt.Printf("Invalid GetAuditLog reply: %s\n", err)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%s %-5s %s/%s %s %s%s\n", e.Ts.Format(time.RFC3339), e.Type, e.Service, e.Cmd, e.User, e.Data, e.Error)
+		}
+	case "GetRecentLogs":
+		entries := []proto.LogEntry{}
+		if err := json.Unmarshal(reply.Data, &entries); err != nil {
+			fmt.Printf("Invalid GetRecentLogs reply: %s\n", err)
+			return
+		}
+		for _, e := range entries {
+			fmt.Printf("%s %s: %s: %s\n", e.Ts.Format(time.RFC3339), e.Service, proto.LogLevelName[e.Level], e.Msg)
+		}
 	}
 }
 
@@ -0,0 +1,95 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"io/ioutil"
+)
+
+// Bundle is what the installer creates via the API, provisioned elsewhere, to
+// install the agent on a host which cannot connect to the API (-bundle).  If
+// AgentUuid is empty, the agent registers itself when the API is reachable.
+type Bundle struct {
+	ApiHostname    string `json:",omitempty"`
+	ApiKey         string
+	AgentUuid      string                 `json:",omitempty"`
+	ServerInstance *proto.ServerInstance  `json:",omitempty"`
+	MySQLInstances []*proto.MySQLInstance `json:",omitempty"`
+	Configs        []proto.AgentConfig    `json:",omitempty"`
+}
+
+// ReadBundle reads and validates a bundle file: every config must be for an
+// instance in the bundle.
+func ReadBundle(file string) (*Bundle, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("Invalid bundle %s: %s", file, err)
+	}
+	if bundle.ApiKey == "" {
+		return nil, fmt.Errorf("Invalid bundle %s: no ApiKey", file)
+	}
+	instances := make(map[string]bool)
+	if bundle.ServerInstance != nil {
+		if bundle.ServerInstance.Id == 0 {
+			return nil, fmt.Errorf("Invalid bundle %s: server instance has no Id", file)
+		}
+		instances[fmt.Sprintf("server-%d", bundle.ServerInstance.Id)] = true
+	}
+	for _, mi := range bundle.MySQLInstances {
+		if mi.Id == 0 || mi.DSN == "" {
+			return nil, fmt.Errorf("Invalid bundle %s: MySQL instance %s has no Id or DSN", file, mi.Hostname)
+		}
+		instances[fmt.Sprintf("mysql-%d", mi.Id)] = true
+	}
+	for _, config := range bundle.Configs {
+		name := fmt.Sprintf("%s-%d", config.ExternalService.Service, config.ExternalService.InstanceId)
+		if !instances[name] {
+			return nil, fmt.Errorf("Invalid bundle %s: %s config for unknown instance %s", file, config.InternalService, name)
+		}
+	}
+	return bundle, nil
+}
+
+// InstallBundle writes the agent, instance and service configs in the bundle
+// without connecting to the API.
+func (i *Installer) InstallBundle(bundle *Bundle) error {
+	i.agentConfig.ApiKey = bundle.ApiKey
+	if bundle.ApiHostname != "" {
+		i.agentConfig.ApiHostname = bundle.ApiHostname
+	}
+	if err := i.writeInstances(bundle.ServerInstance, bundle.MySQLInstances); err != nil {
+		return err
+	}
+	agent := &proto.Agent{Uuid: bundle.AgentUuid}
+	if err := i.writeConfigs(agent, bundle.Configs); err != nil {
+		return err
+	}
+	if bundle.AgentUuid == "" {
+		fmt.Println("Installed agent; it will register when it can connect to " + i.agentConfig.ApiHostname)
+	} else {
+		fmt.Printf("Installed agent: uuid=%s\n", bundle.AgentUuid)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer_test
+
+import (
+	"github.com/percona/percona-agent/agent"
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	"github.com/percona/percona-agent/bin/percona-agent-installer/term"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test"
+	"github.com/percona/percona-agent/test/mock"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+)
+
+type BundleTestSuite struct {
+	tmpDir string
+}
+
+var _ = Suite(&BundleTestSuite{})
+
+func (s *BundleTestSuite) SetUpTest(t *C) {
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "percona-agent-test")
+	t.Assert(err, IsNil)
+	t.Assert(pct.Basedir.Init(s.tmpDir), IsNil)
+}
+
+func (s *BundleTestSuite) TearDownTest(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *BundleTestSuite) TestReadBundle(t *C) {
+	bundle, err := i.ReadBundle(test.RootDir + "/installer/bundle001.json")
+	t.Assert(err, IsNil)
+	t.Check(bundle.ApiKey, Equals, "123")
+	t.Check(bundle.AgentUuid, Equals, "")
+	t.Check(bundle.ServerInstance.Id, Equals, uint(1))
+	t.Assert(bundle.MySQLInstances, HasLen, 1)
+	t.Check(bundle.MySQLInstances[0].Id, Equals, uint(5))
+	t.Check(bundle.Configs, HasLen, 2)
+
+	// mm config for mysql-5 which isn't in the bundle.
+	_, err = i.ReadBundle(test.RootDir + "/installer/bundle002.json")
+	t.Check(err, ErrorMatches, ".+mm config for unknown instance mysql-5")
+}
+
+func (s *BundleTestSuite) TestInstallBundle(t *C) {
+	bundle, err := i.ReadBundle(test.RootDir + "/installer/bundle001.json")
+	t.Assert(err, IsNil)
+
+	agentConfig := &agent.Config{ApiHostname: agent.DEFAULT_API_HOSTNAME}
+	inst := i.NewInstaller(term.NewTerminal(os.Stdin, false, false), s.tmpDir, mock.NewAPI("", "", "", "", nil), agentConfig, i.Flags{})
+	t.Assert(inst.InstallBundle(bundle), IsNil)
+
+	got := &agent.Config{}
+	t.Assert(pct.Basedir.ReadConfig("agent", got), IsNil)
+	t.Check(got.ApiHostname, Equals, "cloud-api.example.com")
+	t.Check(got.ApiKey, Equals, "123")
+	t.Check(got.AgentUuid, Equals, "")
+
+	for _, name := range []string{"server-1", "mysql-5", "mm-server-1", "qan", "log", "data"} {
+		t.Check(pct.FileExists(pct.Basedir.ConfigFile(name)), Equals, true, Commentf(name))
+	}
+	qan, err := ioutil.ReadFile(pct.Basedir.ConfigFile("qan"))
+	t.Assert(err, IsNil)
+	t.Check(string(qan), Equals, bundle.Configs[1].Config)
+}
@@ -160,6 +160,17 @@ func (i *Installer) getQanConfig(mi *proto.MySQLInstance) (*proto.AgentConfig, e
 	}
 	config.Service = "mysql"
 	config.InstanceId = mi.Id
+	if source := i.flags.String["qan-source"]; source != "" {
+		config.CollectFrom = source
+	} else if config.CollectFrom == "" {
+		config.CollectFrom = QAN_SLOWLOG
+	}
+	if i.flags.Bool["configure-qan"] {
+		if err := i.configureQAN(mi, config.CollectFrom); err != nil {
+			// QAN can still start if MySQL is configured later.
+			i.warn("cannot configure MySQL for Query Analytics", err)
+		}
+	}
 
 	bytes, err := json.Marshal(config)
 	if err != nil {
@@ -177,7 +188,16 @@ func (i *Installer) getQanConfig(mi *proto.MySQLInstance) (*proto.AgentConfig, e
 	return agentConfig, nil
 }
 
-func (i *Installer) writeInstances(si *proto.ServerInstance, mi *proto.MySQLInstance) error {
+func (i *Installer) writeInstances(si *proto.ServerInstance, mis []*proto.MySQLInstance) error {
+	// The repo encrypts MySQL DSNs if the instance key exists.
+	keyFile := pct.Basedir.File("instance-key")
+	if i.flags.Bool["encrypt-dsn"] && !pct.FileExists(keyFile) {
+		if err := instance.MakeKey(keyFile); err != nil {
+			return err
+		}
+		fmt.Printf("Created %s to encrypt MySQL DSNs\n", keyFile)
+	}
+
 	// We could write the instance structs directly, but this is the job of an
 	// instance repo and it's easy enough to create one, so do the right thing.
 	logChan := make(chan *proto.LogEntry, 100)
@@ -192,7 +212,7 @@ func (i *Installer) writeInstances(si *proto.ServerInstance, mi *proto.MySQLInst
 			return err
 		}
 	}
-	if mi != nil {
+	for _, mi := range mis {
 		bytes, err := json.Marshal(mi)
 		if err != nil {
 			return err
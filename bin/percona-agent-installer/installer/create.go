@@ -29,58 +29,99 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"os/user"
 )
 
 func (i *Installer) createMySQLUser(dsn mysql.DSN) (mysql.DSN, error) {
 	// Same host:port or socket, but different user and pass.
 	userDSN := dsn
-	userDSN.Username = "percona-agent"
+	userDSN.Username = AGENT_MYSQL_USER
 	userDSN.Password = fmt.Sprintf("%p%d", &dsn, rand.Uint32())
 	userDSN.OldPasswords = i.flags.Bool["old-passwords"]
 
+	agentUser := AgentUser{
+		Username:       userDSN.Username,
+		Password:       userDSN.Password,
+		Auth:           i.flags.String["mysql-auth"],
+		MaxConnections: i.flags.Int64["mysql-max-user-connections"],
+	}
+	if agentUser.Auth == AUTH_SOCKET {
+		// The Go MySQL driver connects to localhost by TCP, so socket auth
+		// only works with the socket.
+		if dsn.Socket == "" {
+			return userDSN, fmt.Errorf("Socket authentication (-mysql-auth=%s) requires -mysql-socket", AUTH_SOCKET)
+		}
+		osUser, err := user.Current()
+		if err != nil {
+			return userDSN, err
+		}
+		agentUser.OSUser = osUser.Username
+		agentUser.Password = ""
+		userDSN.Password = ""
+		userDSN.OldPasswords = false
+	}
+
 	dsnString, _ := dsn.DSN()
 	conn := mysql.NewConnection(dsnString)
 	if err := conn.Connect(1); err != nil {
 		return userDSN, err
 	}
 	defer conn.Close()
-	grants := MakeGrant(dsn, userDSN.Username, userDSN.Password, i.flags.Int64["mysql-max-user-connections"])
-	for _, grant := range grants {
-		if i.flags.Bool["debug"] {
-			log.Println(grant)
-		}
-		_, err := conn.DB().Exec(grant)
-		if err != nil {
-			return userDSN, fmt.Errorf("Error executing %s: %s", grant, err)
-		}
+
+	var version string
+	if err := conn.DB().QueryRow("SELECT @@version").Scan(&version); err != nil {
+		return userDSN, err
+	}
+	if i.flags.Bool["debug"] {
+		log.Printf("MySQL version: %s\n", version)
 	}
 
+	grants, err := MakeCreateUser(version, i.grantPrivs(), dsn, agentUser)
+	if err != nil {
+		return userDSN, err
+	}
 	// Go MySQL driver resolves localhost to 127.0.0.1 but localhost is a special
 	// value for MySQL, so 127.0.0.1 may not work with a grant @localhost, so we
 	// add a 2nd grant @127.0.0.1 to be sure.
-	if dsn.Hostname == "localhost" {
+	if dsn.Hostname == "localhost" && agentUser.Auth != AUTH_SOCKET {
 		dsn2 := dsn
 		dsn2.Hostname = "127.0.0.1"
-		grants := MakeGrant(dsn2, userDSN.Username, userDSN.Password, i.flags.Int64["mysql-max-user-connections"])
-		for _, grant := range grants {
-			if i.flags.Bool["debug"] {
-				log.Println(grant)
-			}
-			_, err := conn.DB().Exec(grant)
-			if err != nil {
-				return userDSN, fmt.Errorf("Error executing %s: %s", grant, err)
-			}
+		grants2, err := MakeCreateUser(version, i.grantPrivs(), dsn2, agentUser)
+		if err != nil {
+			return userDSN, err
+		}
+		grants = append(grants, grants2...)
+	}
+	for _, grant := range grants {
+		if i.flags.Bool["debug"] {
+			log.Println(grant)
+		}
+		_, err := conn.DB().Exec(grant)
+		if err != nil {
+			return userDSN, fmt.Errorf("Error executing %s: %s", grant, err)
 		}
 	}
 
 	return userDSN, nil
 }
 
+func (i *Installer) grantPrivs() string {
+	if i.flags.Bool["mysql-minimal-grants"] {
+		return GRANT_MINIMAL_PRIVS
+	}
+	return GRANT_PRIVS
+}
+
 func (i *Installer) createServerInstance() (*proto.ServerInstance, error) {
 	// POST <api>/instances/server
 	si := &proto.ServerInstance{
 		Hostname: i.hostname,
+		Alias:    i.flags.String["server-instance-name"],
 	}
+	return i.postServerInstance(si)
+}
+
+func (i *Installer) postServerInstance(si *proto.ServerInstance) (*proto.ServerInstance, error) {
 	data, err := json.Marshal(si)
 	if err != nil {
 		return nil, err
@@ -127,11 +168,12 @@ func (i *Installer) createServerInstance() (*proto.ServerInstance, error) {
 	return si, nil
 }
 
-func (i *Installer) createMySQLInstance(dsn mysql.DSN) (*proto.MySQLInstance, error) {
+func (i *Installer) createMySQLInstance(dsn mysql.DSN, alias string) (*proto.MySQLInstance, error) {
 	// First use instance.Manager to fill in details about the MySQL server.
 	dsnString, _ := dsn.DSN()
 	mi := &proto.MySQLInstance{
 		Hostname: i.hostname,
+		Alias:    alias,
 		DSN:      dsnString,
 	}
 	if err := instance.GetMySQLInfo(mi); err != nil {
@@ -140,7 +182,10 @@ func (i *Installer) createMySQLInstance(dsn mysql.DSN) (*proto.MySQLInstance, er
 		}
 		return nil, err
 	}
+	return i.postMySQLInstance(mi)
+}
 
+func (i *Installer) postMySQLInstance(mi *proto.MySQLInstance) (*proto.MySQLInstance, error) {
 	// POST <api>/instances/mysql
 	data, err := json.Marshal(mi)
 	if err != nil {
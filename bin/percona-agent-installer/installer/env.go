@@ -0,0 +1,74 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+const ENV_PREFIX = "PCT_"
+
+// EnvName returns the env var for the flag, e.g. api-key => PCT_API_KEY.
+func EnvName(flagName string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// ApplyEnv sets every flag not given on the command line from its env var,
+// if set, so config management tools can install without passwords and API
+// keys showing in ps.  Command line flags take precedence.
+func ApplyEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		val := getenv(EnvName(f.Name))
+		if val == "" {
+			return
+		}
+		if setErr := fs.Set(f.Name, val); setErr != nil {
+			err = fmt.Errorf("Invalid %s value '%s': %s", EnvName(f.Name), val, setErr)
+		}
+	})
+	return err
+}
+
+// ProxyFromEnv returns the proxy URL and no-proxy list from the standard env
+// vars, so the agent uses the proxy the installer was run with: the agent is
+// started by its sys-init script without the env.  The API uses https, so
+// HTTPS_PROXY takes precedence over HTTP_PROXY, then ALL_PROXY which is
+// usually a SOCKS5 proxy.
+func ProxyFromEnv(getenv func(string) string) (proxyURL, noProxy string) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"} {
+		if proxyURL = getenv(name); proxyURL != "" {
+			break
+		}
+	}
+	for _, name := range []string{"NO_PROXY", "no_proxy"} {
+		if noProxy = getenv(name); noProxy != "" {
+			break
+		}
+	}
+	return proxyURL, noProxy
+}
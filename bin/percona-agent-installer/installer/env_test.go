@@ -0,0 +1,92 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer_test
+
+import (
+	"flag"
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	. "gopkg.in/check.v1"
+)
+
+type EnvTestSuite struct {
+}
+
+var _ = Suite(&EnvTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *EnvTestSuite) TestEnvName(t *C) {
+	t.Check(i.EnvName("api-key"), Equals, "PCT_API_KEY")
+	t.Check(i.EnvName("non-interactive"), Equals, "PCT_NON_INTERACTIVE")
+	t.Check(i.EnvName("debug"), Equals, "PCT_DEBUG")
+}
+
+func (s *EnvTestSuite) TestApplyEnv(t *C) {
+	env := map[string]string{
+		"PCT_API_KEY":         "from-env",
+		"PCT_MYSQL_PASS":      "secret",
+		"PCT_NON_INTERACTIVE": "true",
+		"PCT_MYSQL_USER":      "ignored",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	apiKey := fs.String("api-key", "", "")
+	mysqlUser := fs.String("mysql-user", "", "")
+	mysqlPass := fs.String("mysql-pass", "", "")
+	mysqlHost := fs.String("mysql-host", "localhost", "")
+	nonInteractive := fs.Bool("non-interactive", false, "")
+	err := fs.Parse([]string{"-mysql-user=root"})
+	t.Assert(err, IsNil)
+
+	err = i.ApplyEnv(fs, getenv)
+	t.Assert(err, IsNil)
+	t.Check(*apiKey, Equals, "from-env")
+	t.Check(*mysqlUser, Equals, "root") // flag takes precedence
+	t.Check(*mysqlPass, Equals, "secret")
+	t.Check(*mysqlHost, Equals, "localhost") // not set in env
+	t.Check(*nonInteractive, Equals, true)
+
+	// Invalid values are errors, like invalid flags.
+	env["PCT_NON_INTERACTIVE"] = "maybe"
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("non-interactive", false, "")
+	err = i.ApplyEnv(fs, getenv)
+	t.Check(err, NotNil)
+}
+
+func (s *EnvTestSuite) TestProxyFromEnv(t *C) {
+	env := map[string]string{
+		"http_proxy":  "http://proxy:3128",
+		"HTTPS_PROXY": "http://secure-proxy:3128",
+		"no_proxy":    "localhost,.internal",
+	}
+	getenv := func(name string) string { return env[name] }
+	proxyURL, noProxy := i.ProxyFromEnv(getenv)
+	t.Check(proxyURL, Equals, "http://secure-proxy:3128")
+	t.Check(noProxy, Equals, "localhost,.internal")
+
+	delete(env, "HTTPS_PROXY")
+	proxyURL, _ = i.ProxyFromEnv(getenv)
+	t.Check(proxyURL, Equals, "http://proxy:3128")
+
+	delete(env, "http_proxy")
+	env["ALL_PROXY"] = "socks5://socks:1080"
+	proxyURL, _ = i.ProxyFromEnv(getenv)
+	t.Check(proxyURL, Equals, "socks5://socks:1080")
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/agent"
 	"github.com/percona/percona-agent/bin/percona-agent-installer/term"
+	"github.com/percona/percona-agent/instance"
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
 	"log"
@@ -50,6 +51,26 @@ type Installer struct {
 	// --
 	hostname   string
 	defaultDSN mysql.DSN
+	result     *Result
+}
+
+// Result is what the installer did, printed as JSON with -json for
+// provisioning tools.  Passwords are not included.
+type Result struct {
+	Ok               bool
+	Error            string `json:",omitempty"`
+	AgentUuid        string `json:",omitempty"`
+	ServerInstanceId uint   `json:",omitempty"`
+	MySQLInstances   []MySQLResult
+	Warnings         []string
+}
+
+type MySQLResult struct {
+	Id          uint
+	Hostname    string
+	DSN         string // password hidden
+	User        string
+	CreatedUser bool // user created by installer, else existing user
 }
 
 func NewInstaller(terminal *term.Terminal, basedir string, api pct.APIConnector, agentConfig *agent.Config, flags Flags) *Installer {
@@ -63,6 +84,7 @@ func NewInstaller(terminal *term.Terminal, basedir string, api pct.APIConnector,
 		Hostname: flags.String["mysql-host"],
 		Port:     flags.String["mysql-port"],
 		Socket:   flags.String["mysql-socket"],
+		Proxy:    flags.String["mysql-proxy"],
 	}
 	installer := &Installer{
 		term:        terminal,
@@ -73,11 +95,27 @@ func NewInstaller(terminal *term.Terminal, basedir string, api pct.APIConnector,
 		// --
 		hostname:   hostname,
 		defaultDSN: defaultDSN,
+		result: &Result{
+			MySQLInstances: []MySQLResult{},
+			Warnings:       []string{},
+		},
 	}
 	return installer
 }
 
+// Result returns what Run did.  It's complete only after Run returns.
+func (i *Installer) Result() *Result {
+	return i.result
+}
+
 func (i *Installer) Run() (err error) {
+	defer func() {
+		i.result.Ok = err == nil
+		if err != nil {
+			i.result.Error = err.Error()
+		}
+	}()
+
 	/**
 	 * Get the API key.
 	 */
@@ -104,28 +142,30 @@ func (i *Installer) Run() (err error) {
 		return err
 	}
 
-	// MySQL instance
-	var mi *proto.MySQLInstance
+	// MySQL instances
+	var mis []*proto.MySQLInstance
 	if i.flags.Bool["mysql"] {
-		mi, err = i.InstallerCreateMySQLInstance()
+		mis, err = i.InstallerCreateMySQLInstances()
 		if err != nil {
-			if i.flags.Bool["interactive"] {
+			// -non-interactive installs fail unless -ignore-failures, but
+			// -interactive=false installs always continued without MySQL.
+			if i.flags.Bool["interactive"] || (i.flags.Bool["non-interactive"] && !i.flags.Bool["ignore-failures"]) {
 				return err
-			} else {
-				// Automated install, log the error and continue.
-				fmt.Printf("Failed to set up MySQL (ignoring because interactive=false): %s\n", err)
 			}
+			// Automated install, log the error and continue.
+			fmt.Printf("Failed to set up MySQL (ignoring because interactive=false): %s\n", err)
+			i.result.Warnings = append(i.result.Warnings, fmt.Sprintf("Failed to set up MySQL: %s", err))
 		}
 	}
 
-	if err = i.writeInstances(si, mi); err != nil {
+	if err = i.writeInstances(si, mis); err != nil {
 		return fmt.Errorf("Created agent but failed to write service instances: %s", err)
 	}
 
 	/**
 	 * Get default configs for all services.
 	 */
-	configs, err := i.InstallerGetDefaultConfigs(si, mi)
+	configs, err := i.InstallerGetDefaultConfigs(si, mis)
 	if err != nil {
 		return err
 	}
@@ -224,6 +264,9 @@ VERIFY_API_KEY:
 		}
 
 		if !ok {
+			if !i.flags.Bool["interactive"] {
+				return fmt.Errorf("Failed to verify API key")
+			}
 			again, err := i.term.PromptBool("Try again?", "Y")
 			if err != nil {
 				return err
@@ -246,12 +289,16 @@ VERIFY_API_KEY:
 					" https://github.com/mitchellh/vagrant/issues/1172\n",
 				elapsedTimeInSeconds,
 			)
-			proceed, err := i.term.PromptBool("Continue?", "Y")
-			if err != nil {
-				return err
-			}
-			if !proceed {
-				return fmt.Errorf("Failed because of slow connection")
+			i.result.Warnings = append(i.result.Warnings, fmt.Sprintf("Request to API took %d seconds", elapsedTimeInSeconds))
+			// Slow isn't failed, so an automated install continues.
+			if i.flags.Bool["interactive"] {
+				proceed, err := i.term.PromptBool("Continue?", "Y")
+				if err != nil {
+					return err
+				}
+				if !proceed {
+					return fmt.Errorf("Failed because of slow connection")
+				}
 			}
 		}
 
@@ -268,6 +315,7 @@ func (i *Installer) InstallerCreateServerInstance() (si *proto.ServerInstance, e
 			return nil, err
 		}
 		fmt.Printf("Created server instance: hostname=%s id=%d\n", si.Hostname, si.Id)
+		i.result.ServerInstanceId = si.Id
 	} else {
 		fmt.Println("Not creating server instance (-create-server-instance=false)")
 	}
@@ -275,22 +323,61 @@ func (i *Installer) InstallerCreateServerInstance() (si *proto.ServerInstance, e
 	return si, nil
 }
 
-func (i *Installer) InstallerCreateMySQLInstance() (mi *proto.MySQLInstance, err error) {
-	if i.flags.Bool["create-mysql-instance"] {
-		// Get MySQL DSN for agent to use.
-		// It is new MySQL user created just for agent
-		// or user is asked for existing one.
-		// DSN is verified prior returning by connecting to MySQL.
-		agentDSN, err := i.getAgentDSN()
+func (i *Installer) InstallerCreateMySQLInstances() (mis []*proto.MySQLInstance, err error) {
+	if !i.flags.Bool["create-mysql-instance"] {
+		fmt.Println("Not creating MySQL instance (-create-mysql-instance=false)")
+		return nil, nil
+	}
+	found := i.discoverMySQL()
+	if len(found) <= 1 {
+		// One local MySQL, or it was specified: install it the usual way.
+		mi, err := i.InstallerCreateMySQLInstance()
+		if mi != nil {
+			mis = append(mis, mi)
+		}
+		return mis, err
+	}
+
+	fmt.Printf("Found %d MySQL instances:\n", len(found))
+	for _, m := range found {
+		fmt.Printf("  %s datadir=%s defaults-file=%s\n", m.DSN().To(), m.Datadir, m.DefaultsFile)
+	}
+	if i.flags.String["mysql-instance-name"] != "" {
+		i.warn("ignoring -mysql-instance-name for multiple MySQL instances, names will be MySQL hostnames", nil)
+	}
+	defaultDSN := i.defaultDSN
+	defer func() { i.defaultDSN = defaultDSN }()
+	for _, m := range found {
+		if i.flags.Bool["interactive"] {
+			add, err := i.term.PromptBool(fmt.Sprintf("Install for MySQL %s?", m.DSN().To()), "Y")
+			if err != nil {
+				return mis, err
+			}
+			if !add {
+				continue
+			}
+		}
+		// Connect to this MySQL, but use the given or auto-detected user.
+		dsn := m.DSN()
+		i.defaultDSN = defaultDSN
+		i.defaultDSN.Hostname = dsn.Hostname
+		i.defaultDSN.Port = dsn.Port
+		i.defaultDSN.Socket = dsn.Socket
+		mi, err := i.createMySQLInstanceWithAgentDSN("")
 		if err != nil {
-			return nil, err
+			return mis, fmt.Errorf("MySQL %s: %s", dsn.To(), err)
 		}
-		// Create MySQL instance in API.
-		mi, err = i.createMySQLInstance(agentDSN)
+		mis = append(mis, mi)
+	}
+	return mis, nil
+}
+
+func (i *Installer) InstallerCreateMySQLInstance() (mi *proto.MySQLInstance, err error) {
+	if i.flags.Bool["create-mysql-instance"] {
+		mi, err = i.createMySQLInstanceWithAgentDSN(i.flags.String["mysql-instance-name"])
 		if err != nil {
 			return nil, err
 		}
-		fmt.Printf("Created MySQL instance: dsn=%s hostname=%s id=%d\n", mi.DSN, mi.Hostname, mi.Id)
 	} else {
 		fmt.Println("Not creating MySQL instance (-create-mysql-instance=false)")
 	}
@@ -298,25 +385,71 @@ func (i *Installer) InstallerCreateMySQLInstance() (mi *proto.MySQLInstance, err
 	return mi, nil
 }
 
-func (i *Installer) InstallerGetDefaultConfigs(si *proto.ServerInstance, mi *proto.MySQLInstance) (configs []proto.AgentConfig, err error) {
+func (i *Installer) createMySQLInstanceWithAgentDSN(alias string) (*proto.MySQLInstance, error) {
+	// Get MySQL DSN for agent to use.
+	// It is new MySQL user created just for agent
+	// or user is asked for existing one.
+	// DSN is verified prior returning by connecting to MySQL.
+	agentDSN, err := i.getAgentDSN()
+	if err != nil {
+		return nil, err
+	}
+	// Create MySQL instance in API.
+	mi, err := i.createMySQLInstance(agentDSN, alias)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Created MySQL instance: dsn=%s hostname=%s id=%d\n", mi.DSN, mi.Hostname, mi.Id)
+	i.result.MySQLInstances = append(i.result.MySQLInstances, MySQLResult{
+		Id:          mi.Id,
+		Hostname:    mi.Hostname,
+		DSN:         agentDSN.String(),
+		User:        agentDSN.Username,
+		CreatedUser: i.flags.Bool["create-mysql-user"],
+	})
+	return mi, nil
+}
+
+// discoverMySQL returns the local mysqld processes, unless MySQL was specified
+// by flags or discovery is disabled.
+func (i *Installer) discoverMySQL() []instance.LocalMySQL {
+	if !i.flags.Bool["discover-mysql"] {
+		return nil
+	}
+	if i.defaultDSN.Hostname != "" || i.defaultDSN.Port != "" || i.defaultDSN.Socket != "" || i.flags.String["mysql-defaults-file"] != "" {
+		return nil
+	}
+	found, err := instance.DiscoverMySQL()
+	if err != nil {
+		if i.flags.Bool["debug"] {
+			log.Println(err)
+		}
+		return nil
+	}
+	if i.flags.Bool["debug"] {
+		log.Printf("discovered MySQL: %#v\n", found)
+	}
+	return found
+}
+
+func (i *Installer) InstallerGetDefaultConfigs(si *proto.ServerInstance, mis []*proto.MySQLInstance) (configs []proto.AgentConfig, err error) {
 
 	if i.flags.Bool["start-services"] {
 		// Server metrics monitor
 		config, err := i.getMmServerConfig(si)
 		if err != nil {
-			fmt.Println(err)
-			fmt.Println("WARNING: cannot start server metrics monitor")
+			i.warn("cannot start server metrics monitor", err)
 		} else {
 			configs = append(configs, *config)
 		}
 
 		if i.flags.Bool["start-mysql-services"] {
-			if mi != nil {
+			qan := false
+			for _, mi := range mis {
 				// MySQL metrics tracker
 				config, err = i.getMmMySQLConfig(mi)
 				if err != nil {
-					fmt.Println(err)
-					fmt.Println("WARNING: cannot start MySQL metrics monitor")
+					i.warn("cannot start MySQL metrics monitor", err)
 				} else {
 					configs = append(configs, *config)
 				}
@@ -324,24 +457,24 @@ func (i *Installer) InstallerGetDefaultConfigs(si *proto.ServerInstance, mi *pro
 				// MySQL config tracker
 				config, err = i.getSysconfigMySQLConfig(mi)
 				if err != nil {
-					fmt.Println(err)
-					fmt.Println("WARNING: cannot start MySQL configuration monitor")
+					i.warn("cannot start MySQL configuration monitor", err)
 				} else {
 					configs = append(configs, *config)
 				}
 
 				// QAN
 				// MySQL is local if the server hostname == MySQL hostname without port number.
-				if i.hostname == portNumberRe.ReplaceAllLiteralString(mi.Hostname, "") {
+				// The agent runs one Query Analytics, so it's for the first local MySQL.
+				if !qan && i.hostname == portNumberRe.ReplaceAllLiteralString(mi.Hostname, "") {
 					if i.flags.Bool["debug"] {
 						log.Printf("MySQL is local")
 					}
 					config, err := i.getQanConfig(mi)
 					if err != nil {
-						fmt.Println(err)
-						fmt.Println("WARNING: cannot start Query Analytics")
+						i.warn("cannot start Query Analytics", err)
 					} else {
 						configs = append(configs, *config)
+						qan = true
 					}
 				}
 			}
@@ -362,6 +495,7 @@ func (i *Installer) InstallerCreateAgentWithInitialServiceConfigs(configs []prot
 			return err
 		}
 		fmt.Printf("Created agent: uuid=%s\n", agent.Uuid)
+		i.result.AgentUuid = agent.Uuid
 
 		if err := i.writeConfigs(agent, configs); err != nil {
 			return fmt.Errorf("Created agent but failed to write configs: %s", err)
@@ -372,3 +506,14 @@ func (i *Installer) InstallerCreateAgentWithInitialServiceConfigs(configs []prot
 
 	return nil
 }
+
+// warn prints the warning (and its cause, if any) and adds it to the result.
+func (i *Installer) warn(msg string, err error) {
+	if err != nil {
+		fmt.Println(err)
+		i.result.Warnings = append(i.result.Warnings, fmt.Sprintf("%s: %s", msg, err))
+	} else {
+		i.result.Warnings = append(i.result.Warnings, msg)
+	}
+	fmt.Println("WARNING: " + msg)
+}
@@ -18,8 +18,53 @@
 package installer_test
 
 import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	"github.com/percona/percona-agent/bin/percona-agent-installer/term"
+	"github.com/percona/percona-agent/test/mock"
 	. "gopkg.in/check.v1"
+	"os"
+	"strings"
 	"testing"
 )
 
 func Test(t *testing.T) { TestingT(t) }
+
+type InstallerTestSuite struct {
+}
+
+var _ = Suite(&InstallerTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *InstallerTestSuite) TestResultError(t *C) {
+	flags := i.Flags{
+		Bool: map[string]bool{"interactive": false},
+	}
+	agentConfig := &agent.Config{ApiHostname: "localhost"}
+	inst := i.NewInstaller(term.NewTerminal(os.Stdin, false, false), "/tmp", mock.NewAPI("", "", "", "", nil), agentConfig, flags)
+	err := inst.Run()
+	t.Assert(err, NotNil)
+	result := inst.Result()
+	t.Check(result.Ok, Equals, false)
+	t.Check(strings.HasPrefix(result.Error, "API key is required"), Equals, true)
+	t.Check(result.MySQLInstances, DeepEquals, []i.MySQLResult{})
+	t.Check(result.Warnings, DeepEquals, []string{})
+}
+
+func (s *InstallerTestSuite) TestResultWarnings(t *C) {
+	flags := i.Flags{
+		Bool: map[string]bool{"start-services": true},
+	}
+	agentConfig := &agent.Config{ApiHostname: "localhost"}
+	api := mock.NewAPI("", "", "", "", nil)
+	api.GetCode = []int{500}
+	inst := i.NewInstaller(term.NewTerminal(os.Stdin, false, false), "/tmp", api, agentConfig, flags)
+	configs, err := inst.InstallerGetDefaultConfigs(&proto.ServerInstance{Id: 1}, nil)
+	t.Assert(err, IsNil)
+	t.Check(configs, HasLen, 0)
+	t.Check(inst.Result().Warnings, DeepEquals, []string{
+		"cannot start server metrics monitor: Failed to get default server monitor config (http://localhost/configs/mm/default-server, status 500)",
+	})
+}
@@ -26,21 +26,157 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
+// Privileges on *.* granted to the agent MySQL user.  SUPER is needed to
+// configure MySQL for Query Analytics (slow log) and some metrics (InnoDB
+// metrics, user stats), but many orgs and managed platforms don't allow it,
+// so the agent works without it: those features are disabled or require the
+// DBA to configure MySQL.
+const (
+	GRANT_PRIVS         = "SUPER, PROCESS, USAGE, SELECT"
+	GRANT_MINIMAL_PRIVS = "PROCESS, REPLICATION CLIENT, SELECT"
+)
+
 func MakeGrant(dsn mysql.DSN, user string, pass string, mysqlMaxUserConns int64) []string {
+	return makeGrants(GRANT_PRIVS, dsn, user, pass, mysqlMaxUserConns)
+}
+
+// MakeMinimalGrant is MakeGrant without SUPER.
+func MakeMinimalGrant(dsn mysql.DSN, user string, pass string, mysqlMaxUserConns int64) []string {
+	return makeGrants(GRANT_MINIMAL_PRIVS, dsn, user, pass, mysqlMaxUserConns)
+}
+
+func makeGrants(privs string, dsn mysql.DSN, user string, pass string, mysqlMaxUserConns int64) []string {
+	host := grantHost(dsn)
+	grants := []string{
+		fmt.Sprintf("GRANT %s ON *.* TO '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d", privs, user, host, pass, mysqlMaxUserConns),
+		fmt.Sprintf("GRANT UPDATE, DELETE, DROP ON performance_schema.* TO '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d", user, host, pass, mysqlMaxUserConns),
+	}
+	return grants
+}
+
+func grantHost(dsn mysql.DSN) string {
 	host := "%"
 	if dsn.Socket != "" || dsn.Hostname == "localhost" {
 		host = "localhost"
 	} else if dsn.Hostname == "127.0.0.1" {
 		host = "127.0.0.1"
 	}
+	return host
+}
+
+// How the agent MySQL user authenticates, -mysql-auth.  With AUTH_SOCKET
+// the user has no password: MySQL allows it only from the OS user the agent
+// runs as, connecting through the socket (auth_socket plugin).
+const (
+	AUTH_PASSWORD = "password"
+	AUTH_SOCKET   = "socket"
+)
+
+type AgentUser struct {
+	Username       string
+	Password       string // AUTH_PASSWORD only
+	Auth           string // AUTH_PASSWORD or AUTH_SOCKET
+	OSUser         string // AUTH_SOCKET only
+	MaxConnections int64
+}
+
+type ServerVersion struct {
+	Major   int64
+	Minor   int64
+	Patch   int64
+	MariaDB bool
+}
+
+var versionRe = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
+
+// ParseServerVersion parses SELECT @@version, e.g. 5.6.22-log, 8.0.21 or
+// 10.4.12-MariaDB-1:10.4.12+maria~bionic.  An unknown version is 0.0.0.
+func ParseServerVersion(version string) ServerVersion {
+	v := ServerVersion{
+		MariaDB: strings.Contains(version, "MariaDB"),
+	}
+	if v.MariaDB {
+		// Replication-compatible prefix: 5.5.5-10.4.12-MariaDB
+		version = strings.TrimPrefix(version, "5.5.5-")
+	}
+	m := versionRe.FindStringSubmatch(version)
+	if m == nil {
+		return v
+	}
+	v.Major, _ = strconv.ParseInt(m[1], 10, 64)
+	v.Minor, _ = strconv.ParseInt(m[2], 10, 64)
+	v.Patch, _ = strconv.ParseInt(m[3], 10, 64)
+	return v
+}
+
+func (v ServerVersion) AtLeast(major, minor, patch int64) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// HasCreateUser returns true if the server has CREATE USER IF NOT EXISTS and
+// ALTER USER, which replace GRANT ... IDENTIFIED BY: deprecated in MySQL 5.7
+// and removed in 8.0.
+func (v ServerVersion) HasCreateUser() bool {
+	if v.MariaDB {
+		return v.AtLeast(10, 4, 0)
+	}
+	return v.AtLeast(5, 7, 6)
+}
+
+// MakeCreateUser returns the statements to create or update the agent MySQL
+// user and grant it privs.  Servers without CREATE USER IF NOT EXISTS get
+// the same grants as makeGrants.  The statements are idempotent, so
+// re-installing resets the password.
+func MakeCreateUser(version string, privs string, dsn mysql.DSN, user AgentUser) ([]string, error) {
+	v := ParseServerVersion(version)
+	host := grantHost(dsn)
+	if user.Auth == AUTH_SOCKET {
+		if host != "localhost" {
+			return nil, fmt.Errorf("Socket authentication requires a local MySQL socket")
+		}
+		if v.MariaDB {
+			// unix_socket requires the MySQL user to have the name of the
+			// OS user, but the agent user is not root.
+			return nil, fmt.Errorf("Socket authentication is not supported for MariaDB, use password authentication")
+		}
+		if !v.HasCreateUser() {
+			return nil, fmt.Errorf("Socket authentication requires MySQL 5.7.6 or newer, MySQL is %s", version)
+		}
+		if user.OSUser == "" {
+			return nil, fmt.Errorf("Socket authentication requires the OS user")
+		}
+	} else if !v.HasCreateUser() {
+		return makeGrants(privs, dsn, user.Username, user.Password, user.MaxConnections), nil
+	}
+
+	var identified string
+	switch {
+	case user.Auth == AUTH_SOCKET:
+		identified = fmt.Sprintf("IDENTIFIED WITH auth_socket AS '%s'", user.OSUser)
+	case v.MariaDB:
+		identified = fmt.Sprintf("IDENTIFIED BY '%s'", user.Password)
+	default:
+		// MySQL 8.0 defaults to caching_sha2_password which the Go MySQL
+		// driver does not support, so always use mysql_native_password.
+		identified = fmt.Sprintf("IDENTIFIED WITH mysql_native_password BY '%s'", user.Password)
+	}
 	grants := []string{
-		fmt.Sprintf("GRANT SUPER, PROCESS, USAGE, SELECT ON *.* TO '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d", user, host, pass, mysqlMaxUserConns),
-		fmt.Sprintf("GRANT UPDATE, DELETE, DROP ON performance_schema.* TO '%s'@'%s' IDENTIFIED BY '%s' WITH MAX_USER_CONNECTIONS %d", user, host, pass, mysqlMaxUserConns),
+		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%s' %s WITH MAX_USER_CONNECTIONS %d", user.Username, host, identified, user.MaxConnections),
+		fmt.Sprintf("ALTER USER '%s'@'%s' %s WITH MAX_USER_CONNECTIONS %d", user.Username, host, identified, user.MaxConnections),
+		fmt.Sprintf("GRANT %s ON *.* TO '%s'@'%s'", privs, user.Username, host),
+		fmt.Sprintf("GRANT UPDATE, DELETE, DROP ON performance_schema.* TO '%s'@'%s'", user.Username, host),
 	}
-	return grants
+	return grants, nil
 }
 
 func (i *Installer) getAgentDSN() (dsn mysql.DSN, err error) {
@@ -52,20 +188,23 @@ func (i *Installer) getAgentDSN() (dsn mysql.DSN, err error) {
 			return dsn, fmt.Errorf("Failed to create MySQL user for agent")
 		}
 		fmt.Printf("Created MySQL user: %s\n", dsn.StringWithSuffixes())
-	} else {
-		if i.flags.Bool["interactive"] {
-			// Prompt for existing percona-agent MySQL user.
-			dsn, err = i.useExistingMySQLUser()
-			if err != nil {
-				fmt.Println(err)
-				return dsn, fmt.Errorf("Failed to get MySQL user for agent")
-			}
-			fmt.Printf("Using MySQL user: %s\n", dsn.StringWithSuffixes())
-		} else {
-			// Non-MySQL install (e.g. only system metrics).
-			fmt.Println("Skip creating MySQL user (-create-mysql-user=false)")
-			return dsn, nil
+		if i.flags.Bool["mysql-minimal-grants"] {
+			i.warn("MySQL user does not have SUPER (-mysql-minimal-grants=true), so InnoDB metrics, user stats,"+
+				" and slow log Query Analytics work only if MySQL is configured for them by a DBA", nil)
 		}
+	} else if i.flags.Bool["interactive"] || i.flags.Bool["non-interactive"] {
+		// Use existing percona-agent MySQL user: prompt for it, or
+		// with -non-interactive, get it from flags and auto-detection.
+		dsn, err = i.useExistingMySQLUser()
+		if err != nil {
+			fmt.Println(err)
+			return dsn, fmt.Errorf("Failed to get MySQL user for agent")
+		}
+		fmt.Printf("Using MySQL user: %s\n", dsn.StringWithSuffixes())
+	} else {
+		// Non-MySQL install (e.g. only system metrics).
+		fmt.Println("Skip creating MySQL user (-create-mysql-user=false)")
+		return dsn, nil
 	}
 	return dsn, nil
 }
@@ -117,8 +256,10 @@ func (i *Installer) createNewMySQLUser() (dsn mysql.DSN, err error) {
 
 func (i *Installer) useExistingMySQLUser() (mysql.DSN, error) {
 	userDSN := i.defaultDSN
-	userDSN.Username = "percona-agent"
-	userDSN.Password = ""
+	if userDSN.Username == "" {
+		userDSN.Username = "percona-agent"
+		userDSN.Password = ""
+	}
 	if i.flags.Bool["auto-detect-mysql"] {
 		if err := i.autodetectDSN(&userDSN); err != nil {
 			if i.flags.Bool["debug"] {
@@ -129,8 +270,13 @@ func (i *Installer) useExistingMySQLUser() (mysql.DSN, error) {
 	for {
 		// Let user specify the MySQL account to use for the agent.
 		fmt.Println("Specify the existing MySQL user to use for the agent")
-		if err := i.getDSNFromUser(&userDSN); err != nil {
-			return userDSN, nil
+		if i.flags.Bool["interactive"] {
+			if err := i.getDSNFromUser(&userDSN); err != nil {
+				return userDSN, err
+			}
+		} else if userDSN.Socket == "" && userDSN.Hostname == "" {
+			return userDSN, fmt.Errorf("MySQL host or socket is required in non-interactive mode:" +
+				" specify -mysql-host or -mysql-socket, or enable -auto-detect-mysql")
 		}
 
 		// Verify DSN provided by user
@@ -223,15 +369,15 @@ func (i *Installer) autodetectDSN(dsn *mysql.DSN) error {
 	if dsn.Password == "" {
 		dsn.Password = autoDSN.Password
 	}
-	if dsn.Hostname == "" {
+	// Socket takes precedence over host:port, so don't add one if the host
+	// is given, e.g. 127.0.0.1:3307 for one of several local MySQL.
+	if dsn.Hostname == "" && dsn.Socket == "" {
 		dsn.Hostname = autoDSN.Hostname
+		dsn.Socket = autoDSN.Socket
 	}
 	if dsn.Port == "" {
 		dsn.Port = autoDSN.Port
 	}
-	if dsn.Socket == "" {
-		dsn.Socket = autoDSN.Socket
-	}
 	if dsn.Username == "" {
 		user, err := user.Current()
 		if err == nil {
@@ -21,6 +21,7 @@ import (
 	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/test"
+	"github.com/percona/percona-agent/test/mock"
 	. "gopkg.in/check.v1"
 	"io/ioutil"
 )
@@ -77,6 +78,104 @@ func (s *MySQLTestSuite) TestMakeGrant(t *C) {
 	t.Check(got, DeepEquals, expect)
 }
 
+func (s *MySQLTestSuite) TestMakeMinimalGrant(t *C) {
+	dsn := mysql.DSN{
+		Hostname: "localhost",
+	}
+	got := i.MakeMinimalGrant(dsn, "new-user", "some pass", 1)
+	expect := []string{
+		"GRANT PROCESS, REPLICATION CLIENT, SELECT ON *.* TO 'new-user'@'localhost' IDENTIFIED BY 'some pass' WITH MAX_USER_CONNECTIONS 1",
+		"GRANT UPDATE, DELETE, DROP ON performance_schema.* TO 'new-user'@'localhost' IDENTIFIED BY 'some pass' WITH MAX_USER_CONNECTIONS 1",
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *MySQLTestSuite) TestParseServerVersion(t *C) {
+	t.Check(i.ParseServerVersion("5.6.22-log"), Equals, i.ServerVersion{Major: 5, Minor: 6, Patch: 22})
+	t.Check(i.ParseServerVersion("8.0.21"), Equals, i.ServerVersion{Major: 8, Minor: 0, Patch: 21})
+	t.Check(i.ParseServerVersion("10.4.12-MariaDB-1:10.4.12+maria~bionic"), Equals, i.ServerVersion{Major: 10, Minor: 4, Patch: 12, MariaDB: true})
+	t.Check(i.ParseServerVersion("5.5.5-10.4.12-MariaDB"), Equals, i.ServerVersion{Major: 10, Minor: 4, Patch: 12, MariaDB: true})
+	t.Check(i.ParseServerVersion("unknown"), Equals, i.ServerVersion{})
+
+	t.Check(i.ParseServerVersion("5.7.5").HasCreateUser(), Equals, false)
+	t.Check(i.ParseServerVersion("5.7.6").HasCreateUser(), Equals, true)
+	t.Check(i.ParseServerVersion("8.0.21").HasCreateUser(), Equals, true)
+	t.Check(i.ParseServerVersion("10.3.22-MariaDB").HasCreateUser(), Equals, false)
+	t.Check(i.ParseServerVersion("10.4.12-MariaDB").HasCreateUser(), Equals, true)
+}
+
+func (s *MySQLTestSuite) TestMakeCreateUser(t *C) {
+	dsn := mysql.DSN{
+		Hostname: "localhost",
+	}
+	user := i.AgentUser{
+		Username:       "new-user",
+		Password:       "some pass",
+		Auth:           i.AUTH_PASSWORD,
+		MaxConnections: 1,
+	}
+
+	// Old MySQL: GRANT ... IDENTIFIED BY, same as MakeGrant.
+	got, err := i.MakeCreateUser("5.6.22-log", i.GRANT_PRIVS, dsn, user)
+	t.Assert(err, IsNil)
+	t.Check(got, DeepEquals, i.MakeGrant(dsn, user.Username, user.Password, 1))
+
+	// MySQL 5.7 and 8.0: CREATE USER + GRANT with mysql_native_password
+	// because the driver doesn't support caching_sha2_password.
+	expect := []string{
+		"CREATE USER IF NOT EXISTS 'new-user'@'localhost' IDENTIFIED WITH mysql_native_password BY 'some pass' WITH MAX_USER_CONNECTIONS 1",
+		"ALTER USER 'new-user'@'localhost' IDENTIFIED WITH mysql_native_password BY 'some pass' WITH MAX_USER_CONNECTIONS 1",
+		"GRANT SUPER, PROCESS, USAGE, SELECT ON *.* TO 'new-user'@'localhost'",
+		"GRANT UPDATE, DELETE, DROP ON performance_schema.* TO 'new-user'@'localhost'",
+	}
+	for _, version := range []string{"5.7.30-log", "8.0.21"} {
+		got, err = i.MakeCreateUser(version, i.GRANT_PRIVS, dsn, user)
+		t.Assert(err, IsNil)
+		t.Check(got, DeepEquals, expect)
+	}
+
+	// MariaDB 10.4
+	dsn.Hostname = "10.1.1.1"
+	got, err = i.MakeCreateUser("10.4.12-MariaDB-log", i.GRANT_MINIMAL_PRIVS, dsn, user)
+	t.Assert(err, IsNil)
+	expect = []string{
+		"CREATE USER IF NOT EXISTS 'new-user'@'%' IDENTIFIED BY 'some pass' WITH MAX_USER_CONNECTIONS 1",
+		"ALTER USER 'new-user'@'%' IDENTIFIED BY 'some pass' WITH MAX_USER_CONNECTIONS 1",
+		"GRANT PROCESS, REPLICATION CLIENT, SELECT ON *.* TO 'new-user'@'%'",
+		"GRANT UPDATE, DELETE, DROP ON performance_schema.* TO 'new-user'@'%'",
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *MySQLTestSuite) TestMakeCreateUserSocketAuth(t *C) {
+	dsn := mysql.DSN{
+		Socket: "/var/lib/mysql/mysql.sock",
+	}
+	user := i.AgentUser{
+		Username:       "new-user",
+		Auth:           i.AUTH_SOCKET,
+		OSUser:         "root",
+		MaxConnections: 1,
+	}
+	got, err := i.MakeCreateUser("8.0.21", i.GRANT_PRIVS, dsn, user)
+	t.Assert(err, IsNil)
+	expect := []string{
+		"CREATE USER IF NOT EXISTS 'new-user'@'localhost' IDENTIFIED WITH auth_socket AS 'root' WITH MAX_USER_CONNECTIONS 1",
+		"ALTER USER 'new-user'@'localhost' IDENTIFIED WITH auth_socket AS 'root' WITH MAX_USER_CONNECTIONS 1",
+		"GRANT SUPER, PROCESS, USAGE, SELECT ON *.* TO 'new-user'@'localhost'",
+		"GRANT UPDATE, DELETE, DROP ON performance_schema.* TO 'new-user'@'localhost'",
+	}
+	t.Check(got, DeepEquals, expect)
+
+	// Not supported: old MySQL, MariaDB, remote MySQL.
+	_, err = i.MakeCreateUser("5.6.22", i.GRANT_PRIVS, dsn, user)
+	t.Check(err, NotNil)
+	_, err = i.MakeCreateUser("10.4.12-MariaDB", i.GRANT_PRIVS, dsn, user)
+	t.Check(err, NotNil)
+	_, err = i.MakeCreateUser("8.0.21", i.GRANT_PRIVS, mysql.DSN{Hostname: "10.1.1.1"}, user)
+	t.Check(err, NotNil)
+}
+
 func (s *MySQLTestSuite) TestParseMySQLDefaults(t *C) {
 	output, err := ioutil.ReadFile(sample + "/defaults001")
 	t.Assert(err, IsNil)
@@ -87,3 +186,35 @@ func (s *MySQLTestSuite) TestParseMySQLDefaults(t *C) {
 	}
 	t.Check(got, DeepEquals, expect)
 }
+
+func (s *MySQLTestSuite) TestMakeQANSetup(t *C) {
+	setup, err := i.MakeQANSetup(i.QAN_SLOWLOG)
+	t.Assert(err, IsNil)
+	t.Check(setup.Queries, DeepEquals, []mysql.Query{
+		{Set: "SET GLOBAL long_query_time=0", Verify: "long_query_time", Expect: "0"},
+		{Set: "SET GLOBAL slow_query_log=ON", Verify: "slow_query_log", Expect: "1"},
+	})
+
+	// long_query_time is compared as a number: MySQL reports 0.000000.
+	t.Check(setup.Queries[0].Expected("0.000000"), Equals, true)
+	t.Check(setup.Queries[0].Expected("0.5"), Equals, false)
+	t.Check(setup.Queries[0].Expected(""), Equals, false)
+
+	// The user is warned about all changes.
+	t.Check(i.QANChanges(mock.NewNullMySQL(), setup.Queries), DeepEquals, []string{
+		"long_query_time:  -> 0",
+		"slow_query_log:  -> 1",
+	})
+	t.Check(setup.MyCnf, DeepEquals, []string{"slow_query_log = ON", "long_query_time = 0"})
+
+	// performance_schema can only be verified, not enabled, at runtime.
+	setup, err = i.MakeQANSetup(i.QAN_PERFSCHEMA)
+	t.Assert(err, IsNil)
+	verify, err := mysql.VerifyOnly(setup.Queries)
+	t.Assert(err, IsNil)
+	t.Check(verify, DeepEquals, []mysql.Query{{Verify: "performance_schema", Expect: "1"}})
+	t.Check(setup.MyCnf[0], Equals, "performance_schema = ON")
+
+	_, err = i.MakeQANSetup("tcpdump")
+	t.Check(err, NotNil)
+}
@@ -0,0 +1,161 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mysql"
+	"log"
+	"strings"
+)
+
+// Query Analytics sources, qan.Config.CollectFrom.
+const (
+	QAN_SLOWLOG    = "slowlog"
+	QAN_PERFSCHEMA = "perfschema"
+)
+
+// QANSetup is how MySQL is configured for a Query Analytics source: at runtime
+// by Queries, and in my.cnf by MyCnf because SET GLOBAL doesn't persist.
+type QANSetup struct {
+	Queries []mysql.Query
+	MyCnf   []string // [mysqld] options
+}
+
+func MakeQANSetup(source string) (QANSetup, error) {
+	switch source {
+	case QAN_SLOWLOG:
+		return QANSetup{
+			Queries: []mysql.Query{
+				{Set: "SET GLOBAL long_query_time=0", Verify: "long_query_time", Expect: "0"},
+				{Set: "SET GLOBAL slow_query_log=ON", Verify: "slow_query_log", Expect: "1"},
+			},
+			MyCnf: []string{
+				"slow_query_log = ON",
+				"long_query_time = 0",
+			},
+		}, nil
+	case QAN_PERFSCHEMA:
+		// performance_schema cannot be enabled at runtime, only verified.
+		return QANSetup{
+			Queries: []mysql.Query{
+				{Verify: "performance_schema", Expect: "1"},
+				{Set: "UPDATE performance_schema.setup_consumers SET ENABLED = 'YES' WHERE NAME IN ('global_instrumentation', 'statements_digest')"},
+				{Set: "UPDATE performance_schema.setup_instruments SET ENABLED = 'YES', TIMED = 'YES' WHERE NAME LIKE 'statement/%'"},
+			},
+			MyCnf: []string{
+				"performance_schema = ON",
+				"performance-schema-consumer-statements-digest = ON",
+				"performance-schema-instrument = 'statement/%=ON'",
+			},
+		}, nil
+	}
+	return QANSetup{}, fmt.Errorf("Invalid Query Analytics source: %s, expected %s or %s", source, QAN_SLOWLOG, QAN_PERFSCHEMA)
+}
+
+// configureQAN configures the MySQL instance for the Query Analytics source
+// as the agent MySQL user.  If the user cannot (e.g. no SUPER), MySQL must
+// already be configured.  Either way, the my.cnf options are printed.
+func (i *Installer) configureQAN(mi *proto.MySQLInstance, source string) error {
+	setup, err := MakeQANSetup(source)
+	if err != nil {
+		return err
+	}
+	conn := mysql.NewConnection(mi.DSN)
+	if err := conn.Connect(1); err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// SET GLOBAL affects all sessions, e.g. long_query_time=0 logs every
+	// query, so say what changes and let the user decline.
+	queries := setup.Queries
+	if changes := QANChanges(conn, setup.Queries); len(changes) > 0 {
+		fmt.Printf("WARNING: configuring MySQL %s for Query Analytics changes it globally:\n\t%s\n",
+			mi.Hostname, strings.Join(changes, "\n\t"))
+		if i.flags.Bool["interactive"] {
+			ok, err := i.term.PromptBool("Change MySQL?", "Y")
+			if err != nil {
+				return err
+			}
+			if !ok {
+				// Only check that MySQL is already configured.
+				if queries, err = mysql.VerifyOnly(setup.Queries); err != nil {
+					printMyCnf(setup)
+					return fmt.Errorf("MySQL not changed and cannot be verified: %s", err)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("Configuring MySQL %s for Query Analytics from %s...\n", mi.Hostname, source)
+	if err := conn.Set(queries); err != nil {
+		if i.flags.Bool["debug"] {
+			log.Printf("err=%s\n", err)
+		}
+		// Not permitted, or not possible at runtime, but ok if a DBA has
+		// already configured MySQL.
+		verify, verr := mysql.VerifyOnly(setup.Queries)
+		if verr == nil {
+			verr = conn.Set(verify)
+		}
+		if verr != nil {
+			printMyCnf(setup)
+			return fmt.Errorf("cannot configure MySQL (%s) and it is not already configured: %s", err, verr)
+		}
+		if source == QAN_PERFSCHEMA {
+			var enabled string
+			err := conn.DB().QueryRow("SELECT ENABLED FROM performance_schema.setup_consumers WHERE NAME = 'statements_digest'").Scan(&enabled)
+			if err != nil || enabled != "YES" {
+				printMyCnf(setup)
+				return fmt.Errorf("performance_schema statements_digest consumer is not enabled")
+			}
+		}
+		fmt.Println("MySQL is already configured for Query Analytics")
+	} else {
+		fmt.Println("Configured MySQL for Query Analytics")
+	}
+	printMyCnf(setup)
+	return nil
+}
+
+// QANChanges returns the changes that the QAN setup queries would make to
+// MySQL: variables that aren't already the expected value, like
+// "long_query_time: 10.000000 -> 0", and statements that aren't verified.
+func QANChanges(conn mysql.Connector, queries []mysql.Query) []string {
+	changes := []string{}
+	for _, q := range queries {
+		if q.Set == "" {
+			continue
+		}
+		if q.Verify == "" {
+			changes = append(changes, q.Set)
+			continue
+		}
+		if got := conn.GetGlobalVarString(q.Verify); !q.Expected(got) {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", q.Verify, got, q.Expect))
+		}
+	}
+	return changes
+}
+
+func printMyCnf(setup QANSetup) {
+	fmt.Printf("To configure MySQL for Query Analytics after restarts, add to the [mysqld] section of my.cnf:\n\t%s\n",
+		strings.Join(setup.MyCnf, "\n\t"))
+}
@@ -0,0 +1,437 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/data"
+	"github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config files in the basedir which are not service configs.
+var agentConfigFiles = map[string]bool{
+	"agent": true,
+	"log":   true,
+	"data":  true,
+}
+
+// Reregister moves an installed agent to a new API key (-api-key) without
+// losing its instances, service configs or spooled data.  If the agent is
+// still registered under the new key (the key was rotated), only the API key
+// in agent.conf changes.  Else (new organization), the instances and the
+// agent are created under the new key, and the instance IDs in the instance
+// and service config files and in spooled data are changed to the new ones.
+// The agent must be stopped while reregistering, and restarted afterwards to
+// use the new config.
+func (i *Installer) Reregister() error {
+	oldConfig := &agent.Config{}
+	if err := pct.Basedir.ReadConfig("agent", oldConfig); err != nil {
+		return fmt.Errorf("Error reading agent config: %s", err)
+	}
+	if oldConfig.AgentUuid == "" {
+		return fmt.Errorf("Agent in %s is not registered, install it instead", pct.Basedir.Path())
+	}
+
+	// Keep everything but the API key, which the user must give.
+	newKey := i.agentConfig.ApiKey
+	*i.agentConfig = *oldConfig
+	i.agentConfig.ApiKey = newKey
+	if err := i.InstallerGetApiKey(); err != nil {
+		return err
+	}
+	if i.agentConfig.ApiKey == oldConfig.ApiKey {
+		return fmt.Errorf("Agent is already registered with API key %s", maskKey(oldConfig.ApiKey))
+	}
+	if err := i.VerifyApiKey(); err != nil {
+		return err
+	}
+
+	registered, err := i.agentExists(i.agentConfig.AgentUuid)
+	if err != nil {
+		return err
+	}
+	if registered {
+		if err := pct.Basedir.WriteConfig("agent", i.agentConfig); err != nil {
+			return err
+		}
+		fmt.Printf("Changed API key of agent %s\n", i.agentConfig.AgentUuid)
+	} else {
+		fmt.Printf("Agent %s is not registered with API key %s, registering it...\n", i.agentConfig.AgentUuid, maskKey(i.agentConfig.ApiKey))
+		if err := i.registerAgent(); err != nil {
+			return err
+		}
+		fmt.Printf("Registered agent: uuid=%s\n", i.agentConfig.AgentUuid)
+	}
+	fmt.Println("Restart percona-agent to use the new API key")
+	return nil
+}
+
+func (i *Installer) agentExists(uuid string) (bool, error) {
+	// GET <api>/agents/:uuid
+	url := pct.URL(i.agentConfig.ApiHostname, "agents", uuid)
+	if i.flags.Bool["debug"] {
+		log.Println(url)
+	}
+	code, _, err := i.api.Get(i.agentConfig.ApiKey, url)
+	if i.flags.Bool["debug"] {
+		log.Printf("code=%d\n", code)
+		log.Printf("err=%s\n", err)
+	}
+	if err != nil {
+		return false, err
+	}
+	switch code {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		return false, nil
+	}
+	return false, fmt.Errorf("Failed to get agent %s (status code %d)", uuid, code)
+}
+
+// registerAgent creates the instances and the agent under the new API key,
+// then changes the local files.  If changing them fails, the config dir is
+// restored, so the agent still works with the old API key.
+func (i *Installer) registerAgent() error {
+	configDir := pct.Basedir.Dir("config")
+	logChan := make(chan *proto.LogEntry, 100)
+	logger := pct.NewLogger(logChan, "instance-repo")
+	repo := instance.NewRepo(logger, configDir, i.api)
+	if err := repo.Init(); err != nil {
+		return err
+	}
+
+	// Create the instances under the new key, mapping old to new IDs.
+	ids := make(map[string]uint)
+	var servers []*proto.ServerInstance
+	var mysqls []*proto.MySQLInstance
+	for _, name := range repo.List() {
+		service, id, err := parseInstanceName(name)
+		if err != nil {
+			return err
+		}
+		switch service {
+		case "server":
+			si := &proto.ServerInstance{}
+			if err := repo.Get(service, id, si); err != nil {
+				return err
+			}
+			si.Id = 0
+			si, err = i.postServerInstance(si)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			fmt.Printf("Created server instance: hostname=%s id=%d (was %d)\n", si.Hostname, si.Id, id)
+			servers = append(servers, si)
+			ids[name] = si.Id
+		case "mysql":
+			mi := &proto.MySQLInstance{}
+			if err := repo.Get(service, id, mi); err != nil {
+				return err
+			}
+			mi.Id = 0
+			mi, err = i.postMySQLInstance(mi)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			fmt.Printf("Created MySQL instance: hostname=%s id=%d (was %d)\n", mi.Hostname, mi.Id, id)
+			mysqls = append(mysqls, mi)
+			ids[name] = mi.Id
+		default:
+			if i.flags.Bool["debug"] {
+				log.Printf("Ignoring %s instance %d\n", service, id)
+			}
+		}
+	}
+
+	// Change the instance IDs in the service configs.  All are read before
+	// any is written because an old ID can be another instance's new ID.
+	files, err := filepath.Glob(filepath.Join(configDir, "*"+pct.CONFIG_FILE_SUFFIX))
+	if err != nil {
+		return err
+	}
+	oldFiles := []string{}
+	newConfigs := map[string]string{}
+	configs := []proto.AgentConfig{}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), pct.CONFIG_FILE_SUFFIX)
+		if _, isInstance := ids[name]; isInstance || agentConfigFiles[name] {
+			continue
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		newName, config, err := RemapServiceConfig(name, content, ids)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		if config == nil {
+			continue // not a service instance config
+		}
+		oldFiles = append(oldFiles, file)
+		newConfigs[newName] = config.Config
+		configs = append(configs, *config)
+	}
+
+	agentRes, err := i.createAgent(configs)
+	if err != nil {
+		return err
+	}
+
+	// Now that the agent is registered, replace the local files.
+	backup, err := backupDir(configDir)
+	if err != nil {
+		return err
+	}
+	i.agentConfig.AgentUuid = agentRes.Uuid
+	i.agentConfig.Links = agentRes.Links
+	if err := i.replaceFiles(ids, servers, mysqls, oldFiles, newConfigs); err != nil {
+		if restoreErr := restoreDir(configDir, backup); restoreErr != nil {
+			return fmt.Errorf("%s, and error restoring %s: %s", err, configDir, restoreErr)
+		}
+		return fmt.Errorf("%s (agent %s was created but not installed, restored %s)", err, agentRes.Uuid, configDir)
+	}
+	return nil
+}
+
+// replaceFiles writes the new instance, service and agent config files, and
+// changes the instance IDs in spooled data, which is signed again with the
+// new key.  Spooled data is changed last: it's either all changed or not at
+// all, unless writing it to the store fails.
+func (i *Installer) replaceFiles(ids map[string]uint, servers []*proto.ServerInstance, mysqls []*proto.MySQLInstance, oldFiles []string, newConfigs map[string]string) error {
+	for name := range ids {
+		if err := os.Remove(pct.Basedir.ConfigFile(name)); err != nil {
+			return err
+		}
+	}
+	for _, si := range servers {
+		if err := i.writeInstances(si, nil); err != nil {
+			return err
+		}
+	}
+	if err := i.writeInstances(nil, mysqls); err != nil {
+		return err
+	}
+	for _, file := range oldFiles {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	for name, config := range newConfigs {
+		if err := pct.Basedir.WriteConfigString(name, config); err != nil {
+			return err
+		}
+	}
+	if err := pct.Basedir.WriteConfig("agent", i.agentConfig); err != nil {
+		return err
+	}
+
+	key := []byte(i.agentConfig.ApiKey)
+	rewrite := func(service string, content []byte) ([]byte, error) {
+		return RemapData(content, ids)
+	}
+	dataDir := pct.Basedir.Dir("data")
+	n, err := data.RewriteSpool(data.NewDiskvStore(dataDir), key, rewrite)
+	if err != nil {
+		return fmt.Errorf("Error changing spooled data in %s: %s", dataDir, err)
+	}
+	if boltFile := data.BoltFile(dataDir); pct.FileExists(boltFile) {
+		store, err := data.NewBoltStore(boltFile)
+		if err != nil {
+			return err
+		}
+		m, err := data.RewriteSpool(store, key, rewrite)
+		store.Close()
+		if err != nil {
+			return fmt.Errorf("Error changing spooled data in %s: %s", boltFile, err)
+		}
+		n += m
+	}
+	if n > 0 {
+		fmt.Printf("Changed instance IDs in %d spooled data\n", n)
+	}
+	return nil
+}
+
+// RemapData changes the instance IDs in spooled data, e.g. an mm or qan
+// report, to the new IDs in ids, like RemapServiceConfig.  Every JSON object
+// with a Service and an InstanceId, at any depth, is an instance.  Unknown
+// instances are not changed.
+func RemapData(content []byte, ids map[string]uint) ([]byte, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(content))
+	d.UseNumber() // keep int64 values as-is
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	remapValue(v, ids)
+	return json.Marshal(v)
+}
+
+func remapValue(v interface{}, ids map[string]uint) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		service, _ := val["Service"].(string)
+		idNumber, _ := val["InstanceId"].(json.Number)
+		if service != "" && idNumber != "" {
+			if newId, ok := ids[fmt.Sprintf("%s-%s", service, idNumber)]; ok {
+				val["InstanceId"] = newId
+			}
+		}
+		for _, v := range val {
+			remapValue(v, ids)
+		}
+	case []interface{}:
+		for _, v := range val {
+			remapValue(v, ids)
+		}
+	}
+}
+
+type backupFile struct {
+	content []byte
+	mode    os.FileMode
+}
+
+// backupDir returns the content of the files in dir, for restoreDir.
+func backupDir(dir string) (map[string]backupFile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	backup := make(map[string]backupFile)
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		backup[fi.Name()] = backupFile{content: content, mode: fi.Mode().Perm()}
+	}
+	return backup, nil
+}
+
+// restoreDir removes the files in dir which are not in the backup and writes
+// the files in the backup.
+func restoreDir(dir string, backup map[string]backupFile) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if _, ok := backup[fi.Name()]; !ok && fi.Mode().IsRegular() {
+			if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for name, f := range backup {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), f.content, f.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maskKey returns the last 4 characters of an API key, so it can be printed.
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// RemapServiceConfig changes the instance ID in a service config, e.g.
+// mm-mysql-1, to the new ID in ids, which maps old instance names like
+// mysql-1 to new IDs.  It returns the new config name (e.g. mm-mysql-5) and
+// the config for the API, or nil if the config is not for a service instance.
+// Other config values are not changed.
+func RemapServiceConfig(name string, content []byte, ids map[string]uint) (string, *proto.AgentConfig, error) {
+	config := make(map[string]interface{})
+	d := json.NewDecoder(bytes.NewReader(content))
+	d.UseNumber() // keep int64 values as-is
+	if err := d.Decode(&config); err != nil {
+		return "", nil, err
+	}
+	service, _ := config["Service"].(string)
+	idNumber, _ := config["InstanceId"].(json.Number)
+	if service == "" || idNumber == "" {
+		return "", nil, nil
+	}
+	oldId, err := strconv.ParseUint(string(idNumber), 10, 32)
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid InstanceId: %s", idNumber)
+	}
+	instanceName := fmt.Sprintf("%s-%d", service, oldId)
+	newId, ok := ids[instanceName]
+	if !ok {
+		return "", nil, fmt.Errorf("Unknown instance %s", instanceName)
+	}
+	config["InstanceId"] = newId
+	newContent, err := json.Marshal(config)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Configs are named <internal service>-<instance>, except qan.
+	internalService := name
+	newName := name
+	if strings.HasSuffix(name, "-"+instanceName) {
+		internalService = strings.TrimSuffix(name, "-"+instanceName)
+		newName = fmt.Sprintf("%s-%s-%d", internalService, service, newId)
+	}
+	agentConfig := &proto.AgentConfig{
+		InternalService: internalService,
+		ExternalService: proto.ServiceInstance{
+			Service:    service,
+			InstanceId: newId,
+		},
+		Config:  string(newContent),
+		Running: true,
+	}
+	return newName, agentConfig, nil
+}
+
+// parseInstanceName returns the service and ID of an instance repo name
+// like mysql-1.
+func parseInstanceName(name string) (string, uint, error) {
+	f := strings.SplitN(name, "-", 2)
+	if len(f) != 2 {
+		return "", 0, fmt.Errorf("Invalid instance name: %s", name)
+	}
+	id, err := strconv.ParseUint(f[1], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid instance name: %s", name)
+	}
+	return f[0], uint(id), nil
+}
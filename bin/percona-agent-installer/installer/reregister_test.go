@@ -0,0 +1,104 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer_test
+
+import (
+	"encoding/json"
+	"github.com/percona/cloud-protocol/proto"
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	. "gopkg.in/check.v1"
+)
+
+type ReregisterTestSuite struct {
+}
+
+var _ = Suite(&ReregisterTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *ReregisterTestSuite) TestRemapServiceConfig(t *C) {
+	ids := map[string]uint{
+		"server-1": 7,
+		"mysql-1":  2,
+		"mysql-2":  1,
+	}
+
+	name, config, err := i.RemapServiceConfig("mm-mysql-2", []byte(`{"Service":"mysql","InstanceId":2,"Collect":1,"Report":60}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(name, Equals, "mm-mysql-1")
+	t.Assert(config, NotNil)
+	t.Check(config.InternalService, Equals, "mm")
+	t.Check(config.ExternalService, DeepEquals, proto.ServiceInstance{Service: "mysql", InstanceId: 1})
+	t.Check(config.Running, Equals, true)
+	got := make(map[string]interface{})
+	t.Assert(json.Unmarshal([]byte(config.Config), &got), IsNil)
+	t.Check(got, DeepEquals, map[string]interface{}{
+		"Service":    "mysql",
+		"InstanceId": float64(1),
+		"Collect":    float64(1),
+		"Report":     float64(60),
+	})
+
+	// qan.conf isn't named by instance.
+	name, config, err = i.RemapServiceConfig("qan", []byte(`{"Service":"mysql","InstanceId":1,"MaxSlowLogSize":1073741824}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(name, Equals, "qan")
+	t.Assert(config, NotNil)
+	t.Check(config.InternalService, Equals, "qan")
+	t.Check(config.Config, Equals, `{"InstanceId":2,"MaxSlowLogSize":1073741824,"Service":"mysql"}`)
+
+	name, config, err = i.RemapServiceConfig("mm-server-1", []byte(`{"Service":"server","InstanceId":1}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(name, Equals, "mm-server-7")
+	t.Check(config.ExternalService, DeepEquals, proto.ServiceInstance{Service: "server", InstanceId: 7})
+
+	// Not a service instance config.
+	name, config, err = i.RemapServiceConfig("sysinfo", []byte(`{"Interval":60}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(config, IsNil)
+
+	// Instance without a new ID.
+	_, _, err = i.RemapServiceConfig("mm-mysql-3", []byte(`{"Service":"mysql","InstanceId":3}`), ids)
+	t.Check(err, NotNil)
+}
+
+func (s *ReregisterTestSuite) TestRemapData(t *C) {
+	ids := map[string]uint{
+		"server-1": 7,
+		"mysql-1":  2,
+		"mysql-2":  1,
+	}
+
+	// mm report: instances are in Stats.
+	got, err := i.RemapData([]byte(`{"Ts":"2014-01-01T00:00:00Z","Stats":[{"Service":"mysql","InstanceId":2,"Stats":[{"Name":"threads","Value":1}]},{"Service":"server","InstanceId":1},{"Service":"mysql","InstanceId":9}]}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(string(got), Equals, `{"Stats":[{"InstanceId":1,"Service":"mysql","Stats":[{"Name":"threads","Value":1}]},{"InstanceId":7,"Service":"server"},{"InstanceId":9,"Service":"mysql"}],"Ts":"2014-01-01T00:00:00Z"}`)
+
+	// qan report: the instance is top-level, and big numbers don't change.
+	got, err = i.RemapData([]byte(`{"Service":"mysql","InstanceId":1,"EndOffset":9007199254740993}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(string(got), Equals, `{"EndOffset":9007199254740993,"InstanceId":2,"Service":"mysql"}`)
+
+	// Not an instance.
+	got, err = i.RemapData([]byte(`{"Service":"mm","Msg":"hello"}`), ids)
+	t.Assert(err, IsNil)
+	t.Check(string(got), Equals, `{"Msg":"hello","Service":"mm"}`)
+
+	_, err = i.RemapData([]byte(`not json`), ids)
+	t.Check(err, NotNil)
+}
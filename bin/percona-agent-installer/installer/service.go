@@ -0,0 +1,234 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const (
+	SERVICE_NAME = "percona-agent"
+	INIT_SCRIPT  = "/etc/init.d/" + SERVICE_NAME
+	SYSTEMD_UNIT = "/etc/systemd/system/" + SERVICE_NAME + ".service"
+	// Agent runs as root by default to read MySQL slow log for QAN.
+	DEFAULT_SERVICE_USER = "root"
+)
+
+// Init systems returned by InitSystem.
+const (
+	INIT_SYSTEMD  = "systemd"
+	INIT_SYSVINIT = "sysvinit"
+)
+
+// InitSystem returns INIT_SYSTEMD if the system was booted with systemd (like
+// sd_booted()), else INIT_SYSVINIT if it has /etc/init.d, else "".
+func InitSystem() string {
+	if pct.FileExists("/run/systemd/system") {
+		return INIT_SYSTEMD
+	}
+	if pct.FileExists("/etc/init.d") {
+		return INIT_SYSVINIT
+	}
+	return ""
+}
+
+// MakeSystemdUnit returns the systemd unit to run the agent in basedir as user.
+// Env vars like HTTPS_PROXY can be set in /etc/sysconfig/percona-agent or
+// /etc/default/percona-agent, the same file the sys-init script reads.
+func MakeSystemdUnit(basedir, user string) string {
+	bin := filepath.Join(basedir, pct.BIN_DIR, SERVICE_NAME)
+	return fmt.Sprintf(`[Unit]
+Description=Percona Agent
+After=network.target mysql.service mysqld.service mariadb.service
+
+[Service]
+Type=simple
+# Agent user must be able to read MySQL slow log for Query Analytics (QAN).
+User=%[4]s
+EnvironmentFile=-/etc/sysconfig/%[1]s
+EnvironmentFile=-/etc/default/%[1]s
+ExecStart=%[2]s -basedir %[3]s
+Restart=on-failure
+RestartSec=10
+LimitNOFILE=16384
+# Give QAN time to turn off the slow log.
+TimeoutStopSec=60
+
+[Install]
+WantedBy=multi-user.target
+`, SERVICE_NAME, bin, basedir, user)
+}
+
+var (
+	initBasedirRe  = regexp.MustCompile(`(?m)^BASEDIR=.*$`)
+	initUsernameRe = regexp.MustCompile(`(?m)^USERNAME=.*$`)
+)
+
+// MakeSysvInitScript returns the sys-init script template (install/percona-agent)
+// with its BASEDIR set to basedir and its USERNAME set to user.
+func MakeSysvInitScript(template, basedir, user string) string {
+	script := initBasedirRe.ReplaceAllLiteralString(template, fmt.Sprintf("BASEDIR=%q", basedir))
+	return initUsernameRe.ReplaceAllLiteralString(script, fmt.Sprintf("USERNAME=%q", user))
+}
+
+// InstallService installs the agent service for the init system: a systemd
+// unit, or the sys-init script in basedir/init.d which install.sh copies
+// there.  Then it enables the service to start on boot and (re)starts it.
+func (i *Installer) InstallService() error {
+	basedir := pct.Basedir.Path()
+	serviceUser := i.flags.String["service-user"]
+	if serviceUser == "" {
+		serviceUser = DEFAULT_SERVICE_USER
+	}
+	if _, err := user.Lookup(serviceUser); err != nil {
+		return fmt.Errorf("Invalid -service-user %s: %s", serviceUser, err)
+	}
+	if runtime.GOOS == "darwin" {
+		fmt.Println("Mac OS detected, not installing sys-init script.  To start percona-agent:")
+		fmt.Printf("%s -basedir %s\n", filepath.Join(basedir, pct.BIN_DIR, SERVICE_NAME), basedir)
+		return nil
+	}
+	switch InitSystem() {
+	case INIT_SYSTEMD:
+		// Replace the sys-init script if upgrading, else systemd runs both.
+		if err := i.stopAgent(); err != nil {
+			return err
+		}
+		if err := removeInitScript(); err != nil {
+			return err
+		}
+		fmt.Printf("Installing %s...\n", SYSTEMD_UNIT)
+		if err := ioutil.WriteFile(SYSTEMD_UNIT, []byte(MakeSystemdUnit(basedir, serviceUser)), 0644); err != nil {
+			return err
+		}
+		for _, args := range [][]string{{"daemon-reload"}, {"enable", SERVICE_NAME}, {"restart", SERVICE_NAME}} {
+			if err := i.run("systemctl", args...); err != nil {
+				return err
+			}
+		}
+	case INIT_SYSVINIT:
+		template, err := ioutil.ReadFile(filepath.Join(basedir, "init.d", SERVICE_NAME))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installing %s...\n", INIT_SCRIPT)
+		if err := ioutil.WriteFile(INIT_SCRIPT, []byte(MakeSysvInitScript(string(template), basedir, serviceUser)), 0755); err != nil {
+			return err
+		}
+		if _, err := exec.LookPath("update-rc.d"); err == nil {
+			fmt.Println("Using update-rc.d to install percona-agent service")
+			if err := i.run("update-rc.d", SERVICE_NAME, "defaults"); err != nil {
+				return err
+			}
+		} else if _, err := exec.LookPath("chkconfig"); err == nil {
+			fmt.Println("Using chkconfig to install percona-agent service")
+			if err := i.run("chkconfig", SERVICE_NAME, "on"); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println("Cannot find chkconfig or update-rc.d.  percona-agent is installed but" +
+				" it will not restart automatically with the server on reboot.")
+		}
+		if err := i.run(INIT_SCRIPT, "restart"); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Cannot detect init system (systemd or sysvinit), not installing percona-agent service")
+	}
+	fmt.Println("Started percona-agent")
+	return nil
+}
+
+func (i *Installer) run(cmd string, args ...string) error {
+	out, err := exec.Command(cmd, args...).CombinedOutput()
+	if i.flags.Bool["debug"] {
+		log.Println(cmd, strings.Join(args, " "))
+		log.Println(string(out))
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %s: %s", cmd, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (i *Installer) stopAgent() error {
+	if runtime.GOOS == "darwin" {
+		fmt.Println("Mac OS detected, no sys-init script. To stop percona-agent: killall percona-agent")
+		return nil
+	}
+	if pct.FileExists(SYSTEMD_UNIT) {
+		return i.run("systemctl", "stop", SERVICE_NAME)
+	}
+	if _, err := os.Stat(INIT_SCRIPT); os.IsNotExist(err) {
+		return nil
+	}
+	out, err := exec.Command(INIT_SCRIPT, "stop").CombinedOutput()
+	if i.flags.Bool["debug"] {
+		log.Println(string(out))
+	}
+	return err
+}
+
+// removeService removes the systemd unit and sys-init script, if installed.
+func (i *Installer) removeService() error {
+	if pct.FileExists(SYSTEMD_UNIT) {
+		if err := i.run("systemctl", "disable", SERVICE_NAME); err != nil {
+			return err
+		}
+		fmt.Printf("Removing %s...\n", SYSTEMD_UNIT)
+		if err := os.Remove(SYSTEMD_UNIT); err != nil {
+			return err
+		}
+		if err := i.run("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+	}
+	return removeInitScript()
+}
+
+func removeInitScript() error {
+	if runtime.GOOS == "darwin" {
+		return nil
+	}
+	if _, err := os.Stat(INIT_SCRIPT); os.IsNotExist(err) {
+		return nil
+	}
+	if path, err := exec.LookPath("update-rc.d"); err == nil {
+		fmt.Println("Using update-rc.d to uninstall percona-agent service")
+		if err := exec.Command(path, "-f", "percona-agent", "remove").Run(); err != nil {
+			return err
+		}
+	} else if path, err := exec.LookPath("chkconfig"); err == nil {
+		fmt.Println("Using chkconfig to uninstall percona-agent service")
+		if err := exec.Command(path, "--del", "percona-agent").Run(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Removing %s...\n", INIT_SCRIPT)
+	return os.Remove(INIT_SCRIPT)
+}
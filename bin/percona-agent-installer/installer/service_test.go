@@ -0,0 +1,54 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer_test
+
+import (
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	. "gopkg.in/check.v1"
+	"strings"
+)
+
+type ServiceTestSuite struct {
+}
+
+var _ = Suite(&ServiceTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *ServiceTestSuite) TestMakeSystemdUnit(t *C) {
+	unit := i.MakeSystemdUnit("/opt/percona-agent", "mysql")
+	t.Check(strings.Contains(unit, "\nUser=mysql\n"), Equals, true)
+	t.Check(strings.Contains(unit, "\nExecStart=/opt/percona-agent/bin/percona-agent -basedir /opt/percona-agent\n"), Equals, true)
+	t.Check(strings.Contains(unit, "\nRestart=on-failure\n"), Equals, true)
+	t.Check(strings.Contains(unit, "\nEnvironmentFile=-/etc/sysconfig/percona-agent\n"), Equals, true)
+	t.Check(strings.HasSuffix(unit, "[Install]\nWantedBy=multi-user.target\n"), Equals, true)
+}
+
+func (s *ServiceTestSuite) TestMakeSysvInitScript(t *C) {
+	template := "SERVICE=\"percona-agent\"\n" +
+		"USERNAME=\"root\"\n" +
+		"# Agent uses a single base directory for all its files and data.\n" +
+		"BASEDIR=\"/usr/local/percona/$SERVICE\"\n" +
+		"if [ ! -d \"$BASEDIR\" ]; then\n"
+	got := i.MakeSysvInitScript(template, "/opt/percona agent", "mysql")
+	t.Check(got, Equals, "SERVICE=\"percona-agent\"\n"+
+		"USERNAME=\"mysql\"\n"+
+		"# Agent uses a single base directory for all its files and data.\n"+
+		"BASEDIR=\"/opt/percona agent\"\n"+
+		"if [ ! -d \"$BASEDIR\" ]; then\n")
+}
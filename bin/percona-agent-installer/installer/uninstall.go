@@ -0,0 +1,279 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/instance"
+	pctLog "github.com/percona/percona-agent/log"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const AGENT_MYSQL_USER = "percona-agent"
+
+// Files and dirs in the basedir created by the installer, install.sh, the
+// sys-init script and the agent.  Only these are removed, so a mistaken
+// -basedir (e.g. /usr/local) doesn't remove anything else.
+var basedirFiles = []string{
+	pct.CONFIG_DIR,
+	pct.DATA_DIR,
+	pct.BIN_DIR,
+	pct.TRASH_DIR,
+	"init.d",
+	pct.START_LOCK,
+	pct.START_SCRIPT,
+	"percona-agent.log",
+	"percona-agent.pid",
+}
+
+// Uninstall stops the agent, deregisters it with the API, optionally drops the
+// MySQL user the installer created (-drop-mysql-user), removes the systemd
+// unit or sys-init script and removes the agent's files in the basedir.  It does as much as it
+// can: a failed step is reported, but the rest are still done.
+func (i *Installer) Uninstall() error {
+	basedir := pct.Basedir.Path()
+	if i.flags.Bool["interactive"] {
+		ok, err := i.term.PromptBool(fmt.Sprintf("Uninstall percona-agent in %s?", basedir), "N")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Not uninstalling")
+		}
+	}
+
+	failed := false
+
+	fmt.Println("Stopping agent...")
+	if err := i.stopAgent(); err != nil {
+		// If still running, the agent would rewrite its files.
+		return fmt.Errorf("Failed to stop agent: %s", err)
+	}
+
+	// The agent config has the agent UUID and API key, so it must be
+	// read before the basedir is removed.
+	config := &agent.Config{}
+	if err := pct.Basedir.ReadConfig("agent", config); err != nil {
+		fmt.Printf("Error reading agent config: %s\n", err)
+		failed = true
+	}
+	if config.ApiKey == "" {
+		config.ApiKey = i.agentConfig.ApiKey
+	}
+	if config.ApiHostname == "" {
+		config.ApiHostname = i.agentConfig.ApiHostname
+	}
+
+	if config.AgentUuid == "" {
+		fmt.Println("Agent is not registered, skipping deregistration")
+	} else if err := i.deleteAgent(config); err != nil {
+		fmt.Printf("Failed to deregister agent %s: %s\n", config.AgentUuid, err)
+		failed = true
+	} else {
+		fmt.Printf("Deregistered agent: uuid=%s\n", config.AgentUuid)
+	}
+
+	if i.flags.Bool["drop-mysql-user"] {
+		if err := i.dropMySQLUsers(); err != nil {
+			fmt.Printf("Failed to drop MySQL user: %s\n", err)
+			failed = true
+		}
+	}
+
+	if err := i.removeService(); err != nil {
+		fmt.Printf("Failed to remove percona-agent service: %s\n", err)
+		failed = true
+	}
+
+	// The log file can be outside the basedir.
+	logConfig := &pctLog.Config{}
+	if err := pct.Basedir.ReadConfig("log", logConfig); err == nil && logConfig.File != "" {
+		if err := pct.RemoveFile(logConfig.File); err != nil {
+			fmt.Printf("Failed to remove %s: %s\n", logConfig.File, err)
+			failed = true
+		}
+	}
+
+	fmt.Printf("Removing %s...\n", basedir)
+	if err := RemoveBasedir(basedir); err != nil {
+		fmt.Println(err)
+		failed = true
+	}
+
+	if failed {
+		return fmt.Errorf("Uninstalled percona-agent with errors, see above")
+	}
+	fmt.Println("Uninstalled percona-agent")
+	return nil
+}
+
+// RemoveBasedir removes the agent's files in the basedir, then the basedir if
+// nothing else is in it.
+func RemoveBasedir(basedir string) error {
+	for _, file := range basedirFiles {
+		if err := os.RemoveAll(filepath.Join(basedir, file)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(basedir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Not removing %s because it has other files: %s", basedir, err)
+	}
+	return nil
+}
+
+func (i *Installer) deleteAgent(config *agent.Config) error {
+	// DELETE <api>/agents/:uuid
+	url := pct.URL(config.ApiHostname, "agents", config.AgentUuid)
+	if i.flags.Bool["debug"] {
+		log.Println(url)
+	}
+	resp, _, err := i.api.Delete(config.ApiKey, url)
+	if i.flags.Bool["debug"] {
+		log.Printf("resp=%#v\n", resp)
+		log.Printf("err=%s\n", err)
+	}
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		// deleted, or already deleted
+		return nil
+	}
+	return fmt.Errorf("status code %d", resp.StatusCode)
+}
+
+// dropMySQLUsers drops the agent MySQL user of every MySQL instance, if it's
+// the user the installer creates, connecting as the root MySQL user.
+func (i *Installer) dropMySQLUsers() error {
+	logChan := make(chan *proto.LogEntry, 100)
+	logger := pct.NewLogger(logChan, "instance-repo")
+	repo := instance.NewRepo(logger, pct.Basedir.Dir("config"), i.api)
+	if err := repo.Init(); err != nil {
+		return err
+	}
+	for _, name := range repo.List() {
+		if !strings.HasPrefix(name, "mysql-") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(name, "mysql-"), 10, 32)
+		if err != nil {
+			return err
+		}
+		mi := &proto.MySQLInstance{}
+		if err := repo.Get("mysql", uint(id), mi); err != nil {
+			return err
+		}
+		agentDSN := ParseDSNString(mi.DSN)
+		if agentDSN.Username != AGENT_MYSQL_USER {
+			fmt.Printf("Not dropping MySQL user %s for %s because the installer did not create it\n", agentDSN.Username, name)
+			continue
+		}
+		if err := i.dropMySQLUser(agentDSN); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func (i *Installer) dropMySQLUser(agentDSN mysql.DSN) error {
+	// Connect as root to the agent's MySQL.
+	superUserDSN := i.defaultDSN
+	if superUserDSN.Hostname == "" && superUserDSN.Socket == "" {
+		superUserDSN.Hostname = agentDSN.Hostname
+		superUserDSN.Port = agentDSN.Port
+		superUserDSN.Socket = agentDSN.Socket
+	}
+	if i.flags.Bool["auto-detect-mysql"] {
+		if err := i.autodetectDSN(&superUserDSN); err != nil {
+			if i.flags.Bool["debug"] {
+				log.Println(err)
+			}
+		}
+	}
+	dsnString, err := superUserDSN.DSN()
+	if err != nil {
+		return err
+	}
+	conn := mysql.NewConnection(dsnString)
+	if err := conn.Connect(1); err != nil {
+		return fmt.Errorf("Error connecting to MySQL %s: %s", superUserDSN, err)
+	}
+	defer conn.Close()
+
+	// The installer creates the user @localhost and @127.0.0.1, or @%.
+	rows, err := conn.DB().Query("SELECT Host FROM mysql.user WHERE User = ?", agentDSN.Username)
+	if err != nil {
+		return err
+	}
+	hosts := []string{}
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			rows.Close()
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+	rows.Close()
+	for _, host := range hosts {
+		drop := fmt.Sprintf("DROP USER '%s'@'%s'", agentDSN.Username, host)
+		if i.flags.Bool["debug"] {
+			log.Println(drop)
+		}
+		if _, err := conn.DB().Exec(drop); err != nil {
+			return fmt.Errorf("Error executing %s: %s", drop, err)
+		}
+		fmt.Printf("Dropped MySQL user %s@%s\n", agentDSN.Username, host)
+	}
+	return nil
+}
+
+var dsnRe = regexp.MustCompile(`^([^:@]*)(?::[^@]*)?@(unix|tcp)\(([^)]*)\)`)
+
+// ParseDSNString returns the user and address of a Go MySQL driver DSN like
+// user:pass@unix(/var/lib/mysql/mysql.sock)/ or user:pass@tcp(host:port)/.
+// The password is not returned.
+func ParseDSNString(dsnString string) mysql.DSN {
+	dsn := mysql.DSN{}
+	m := dsnRe.FindStringSubmatch(dsnString)
+	if m == nil {
+		return dsn
+	}
+	dsn.Username = m[1]
+	if m[2] == "unix" {
+		dsn.Socket = m[3]
+	} else {
+		f := strings.SplitN(m[3], ":", 2)
+		dsn.Hostname = f[0]
+		if len(f) > 1 {
+			dsn.Port = f[1]
+		}
+	}
+	return dsn
+}
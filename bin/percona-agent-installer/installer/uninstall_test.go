@@ -0,0 +1,88 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer_test
+
+import (
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type UninstallTestSuite struct {
+	tmpDir string
+}
+
+var _ = Suite(&UninstallTestSuite{})
+
+func (s *UninstallTestSuite) SetUpTest(t *C) {
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "percona-agent-test")
+	t.Assert(err, IsNil)
+}
+
+func (s *UninstallTestSuite) TearDownTest(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *UninstallTestSuite) TestParseDSNString(t *C) {
+	got := i.ParseDSNString("percona-agent:0xc2080@unix(/var/run/mysqld/mysqld.sock)/?parseTime=true")
+	t.Check(got, Equals, mysql.DSN{Username: "percona-agent", Socket: "/var/run/mysqld/mysqld.sock"})
+
+	got = i.ParseDSNString("percona-agent:pass@tcp(127.0.0.1:3307)/?parseTime=true&allowOldPasswords=true")
+	t.Check(got, Equals, mysql.DSN{Username: "percona-agent", Hostname: "127.0.0.1", Port: "3307"})
+
+	got = i.ParseDSNString("root@tcp(db1)/")
+	t.Check(got, Equals, mysql.DSN{Username: "root", Hostname: "db1"})
+
+	got = i.ParseDSNString("invalid")
+	t.Check(got, Equals, mysql.DSN{})
+}
+
+func (s *UninstallTestSuite) TestRemoveBasedir(t *C) {
+	basedir := filepath.Join(s.tmpDir, "percona-agent")
+	err := pct.Basedir.Init(basedir)
+	t.Assert(err, IsNil)
+	err = pct.Basedir.WriteConfig("agent", map[string]string{"AgentUuid": "123"})
+	t.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(basedir, "percona-agent.log"), []byte("log"), 0644)
+	t.Assert(err, IsNil)
+
+	err = i.RemoveBasedir(basedir)
+	t.Check(err, IsNil)
+	t.Check(pct.FileExists(basedir), Equals, false)
+
+	// Other files are not removed, nor the basedir.
+	err = pct.Basedir.Init(basedir)
+	t.Assert(err, IsNil)
+	other := filepath.Join(basedir, "other-file")
+	err = ioutil.WriteFile(other, []byte("keep"), 0644)
+	t.Assert(err, IsNil)
+
+	err = i.RemoveBasedir(basedir)
+	t.Check(err, NotNil)
+	t.Check(pct.FileExists(other), Equals, true)
+	t.Check(pct.FileExists(pct.Basedir.Dir("config")), Equals, false)
+}
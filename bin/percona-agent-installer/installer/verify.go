@@ -0,0 +1,326 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	CHECK_OK   = "ok"
+	CHECK_WARN = "warn"
+	CHECK_FAIL = "fail"
+	CHECK_SKIP = "skip"
+)
+
+const (
+	MIN_FREE_DISK_SPACE = 100 * 1024 * 1024 // bytes, for the basedir
+	MAX_CLOCK_SKEW      = 5 * time.Minute   // vs. API server time
+)
+
+type VerifyCheck struct {
+	Name    string
+	Status  string // CHECK_* const
+	Message string `json:",omitempty"`
+}
+
+// VerifyReport is the result of -verify, printed as JSON.  Ok is false if any
+// check failed; warnings are ok.
+type VerifyReport struct {
+	Ok     bool
+	Checks []VerifyCheck
+}
+
+func (r *VerifyReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, VerifyCheck{
+		Name:    name,
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	})
+	if status == CHECK_FAIL {
+		r.Ok = false
+	}
+}
+
+// Verify checks that the agent can be installed and work, without changing
+// anything: no API resources, MySQL users or files are created.
+func (i *Installer) Verify(basedir string) *VerifyReport {
+	report := &VerifyReport{Ok: true}
+	i.verifyAPI(report)
+	i.verifyDiskSpace(report, basedir)
+	if i.flags.Bool["mysql"] {
+		i.verifyMySQL(report)
+	}
+	return report
+}
+
+func (i *Installer) verifyAPI(report *VerifyReport) {
+	pingURL := pct.URL(i.agentConfig.ApiHostname, "ping")
+	req, err := http.NewRequest("GET", pingURL, nil)
+	if err != nil {
+		report.add("api-connection", CHECK_FAIL, "%s: %s", pingURL, err)
+		return
+	}
+	req.Header.Add("X-Percona-API-Key", i.agentConfig.ApiKey)
+	req.Header.Add("X-Percona-Agent-Version", agent.VERSION)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: pct.TimeoutDialer(&pct.TimeoutClientConfig{
+				ConnectTimeout:   10 * time.Second,
+				ReadWriteTimeout: 10 * time.Second,
+			}),
+			Proxy:           pct.Proxy,
+			TLSClientConfig: pct.TLSConfig(),
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if IsCertError(err) {
+			report.add("api-connection", CHECK_OK, "%s", pingURL)
+			report.add("api-tls", CHECK_FAIL, "%s", err)
+		} else {
+			report.add("api-connection", CHECK_FAIL, "%s", err)
+			report.add("api-tls", CHECK_SKIP, "no connection")
+		}
+		report.add("api-key", CHECK_SKIP, "no connection")
+		report.add("clock", CHECK_SKIP, "no connection")
+		return
+	}
+	resp.Body.Close()
+	report.add("api-connection", CHECK_OK, "%s", pingURL)
+	if strings.HasPrefix(pingURL, "https://") {
+		report.add("api-tls", CHECK_OK, "")
+	} else {
+		report.add("api-tls", CHECK_SKIP, "not https")
+	}
+
+	switch {
+	case i.agentConfig.ApiKey == "":
+		report.add("api-key", CHECK_FAIL, "no API key, specify -api-key")
+	case resp.StatusCode == http.StatusOK:
+		report.add("api-key", CHECK_OK, "")
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		report.add("api-key", CHECK_FAIL, "access denied (status code %d)", resp.StatusCode)
+	default:
+		report.add("api-key", CHECK_WARN, "unexpected status code %d", resp.StatusCode)
+	}
+
+	skew, err := ClockSkew(time.Now(), resp.Header.Get("Date"))
+	if err != nil {
+		report.add("clock", CHECK_SKIP, "%s", err)
+	} else if skew > MAX_CLOCK_SKEW || skew < -MAX_CLOCK_SKEW {
+		report.add("clock", CHECK_FAIL, "local time is %s off API time, check NTP", skew)
+	} else {
+		report.add("clock", CHECK_OK, "%s off API time", skew)
+	}
+}
+
+// IsCertError returns true if the HTTP request failed because the server
+// TLS certificate is not trusted.
+func IsCertError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	switch err.(type) {
+	case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError:
+		return true
+	}
+	return false
+}
+
+// ClockSkew returns how far local time is ahead (positive) or behind
+// (negative) the HTTP Date header, rounded to seconds.
+func ClockSkew(local time.Time, date string) (time.Duration, error) {
+	if date == "" {
+		return 0, fmt.Errorf("API did not return Date header")
+	}
+	remote, err := http.ParseTime(date)
+	if err != nil {
+		return 0, err
+	}
+	// Date has second precision.
+	return local.Truncate(time.Second).Sub(remote), nil
+}
+
+func (i *Installer) verifyDiskSpace(report *VerifyReport, basedir string) {
+	// The basedir might not exist yet, so check the filesystem it will be on.
+	dir, err := filepath.Abs(basedir)
+	if err != nil {
+		report.add("disk-space", CHECK_FAIL, "%s", err)
+		return
+	}
+	for !pct.FileExists(dir) && dir != filepath.Dir(dir) {
+		dir = filepath.Dir(dir)
+	}
+	_, free, _, err := pct.FsSpace(dir)
+	if err != nil {
+		report.add("disk-space", CHECK_FAIL, "%s: %s", dir, err)
+		return
+	}
+	if free < MIN_FREE_DISK_SPACE {
+		report.add("disk-space", CHECK_FAIL, "%s has %d MB free, need %d MB", dir, free/1048576, MIN_FREE_DISK_SPACE/1048576)
+	} else {
+		report.add("disk-space", CHECK_OK, "%s has %d MB free", dir, free/1048576)
+	}
+}
+
+func (i *Installer) verifyMySQL(report *VerifyReport) {
+	dsn := i.defaultDSN
+	if i.flags.Bool["auto-detect-mysql"] {
+		if err := i.autodetectDSN(&dsn); err != nil {
+			if i.flags.Bool["debug"] {
+				log.Println(err)
+			}
+		}
+	}
+	dsnString, err := dsn.DSN()
+	if err != nil {
+		report.add("mysql-connection", CHECK_FAIL, "%s", err)
+		return
+	}
+	conn := mysql.NewConnection(dsnString)
+	if err := conn.Connect(1); err != nil {
+		report.add("mysql-connection", CHECK_FAIL, "%s: %s", dsn, err)
+		return
+	}
+	defer conn.Close()
+	report.add("mysql-connection", CHECK_OK, "%s", dsn)
+
+	// Privileges
+	grants := []string{}
+	rows, err := conn.DB().Query("SHOW GRANTS")
+	if err == nil {
+		for rows.Next() {
+			var grant string
+			if err = rows.Scan(&grant); err != nil {
+				break
+			}
+			grants = append(grants, grant)
+		}
+		rows.Close()
+	}
+	if err != nil {
+		report.add("mysql-privileges", CHECK_FAIL, "SHOW GRANTS: %s", err)
+		return
+	}
+	privs := ParseGlobalPrivileges(grants)
+	if i.flags.Bool["create-mysql-user"] {
+		// The given user creates the agent user, so it needs the agent privs
+		// too: a user can only grant privileges it has.
+		missing := missingPrivileges(privs, "CREATE USER", "GRANT OPTION", "PROCESS", "SELECT")
+		if len(missing) > 0 {
+			report.add("mysql-privileges", CHECK_FAIL, "%s cannot create the agent MySQL user, missing: %s", dsn.Username, strings.Join(missing, ", "))
+		} else {
+			report.add("mysql-privileges", CHECK_OK, "%s can create the agent MySQL user", dsn.Username)
+		}
+	} else {
+		if missing := missingPrivileges(privs, "PROCESS", "SELECT"); len(missing) > 0 {
+			report.add("mysql-privileges", CHECK_FAIL, "missing: %s", strings.Join(missing, ", "))
+		} else if missing := missingPrivileges(privs, "SUPER", "REPLICATION CLIENT"); len(missing) > 0 {
+			report.add("mysql-privileges", CHECK_WARN, "missing: %s; some metrics and Query Analytics require MySQL configured by a DBA", strings.Join(missing, ", "))
+		} else {
+			report.add("mysql-privileges", CHECK_OK, "")
+		}
+	}
+
+	// Slow log: Query Analytics reads it, so it must be local and readable.
+	var slowLog, slowLogFile string
+	err = conn.DB().QueryRow("SELECT @@slow_query_log, @@slow_query_log_file").Scan(&slowLog, &slowLogFile)
+	switch {
+	case err != nil:
+		report.add("slow-log", CHECK_WARN, "%s", err)
+	case slowLog == "1" || strings.EqualFold(slowLog, "ON"):
+		if f, err := os.Open(slowLogFile); err != nil {
+			report.add("slow-log", CHECK_WARN, "slow log is on but cannot be read: %s", err)
+		} else {
+			f.Close()
+			report.add("slow-log", CHECK_OK, "%s", slowLogFile)
+		}
+	case privs["SUPER"]:
+		report.add("slow-log", CHECK_OK, "slow log is off, Query Analytics will enable it")
+	default:
+		report.add("slow-log", CHECK_WARN, "slow log is off and cannot be enabled without SUPER")
+	}
+
+	// Performance Schema
+	var ps string
+	err = conn.DB().QueryRow("SELECT @@performance_schema").Scan(&ps)
+	switch {
+	case err != nil:
+		report.add("performance-schema", CHECK_WARN, "not available: %s", err)
+	case ps == "1" || strings.EqualFold(ps, "ON"):
+		report.add("performance-schema", CHECK_OK, "")
+	default:
+		report.add("performance-schema", CHECK_WARN, "performance_schema is off")
+	}
+}
+
+var grantOnAllRe = regexp.MustCompile(`^GRANT (.+) ON \*\.\* TO `)
+
+// ParseGlobalPrivileges returns the privileges ON *.* from SHOW GRANTS output,
+// e.g. "PROCESS", "REPLICATION CLIENT" and "GRANT OPTION".  ALL [PRIVILEGES]
+// is returned as "ALL".
+func ParseGlobalPrivileges(grants []string) map[string]bool {
+	privs := make(map[string]bool)
+	for _, grant := range grants {
+		m := grantOnAllRe.FindStringSubmatch(grant)
+		if m == nil {
+			continue
+		}
+		for _, priv := range strings.Split(m[1], ",") {
+			priv = strings.TrimSpace(priv)
+			if priv == "ALL PRIVILEGES" {
+				priv = "ALL"
+			}
+			privs[priv] = true
+		}
+		if strings.Contains(grant, "WITH GRANT OPTION") {
+			privs["GRANT OPTION"] = true
+		}
+	}
+	return privs
+}
+
+func missingPrivileges(privs map[string]bool, need ...string) []string {
+	missing := []string{}
+	for _, priv := range need {
+		if priv == "GRANT OPTION" {
+			// Not included in ALL.
+			if !privs[priv] {
+				missing = append(missing, priv)
+			}
+			continue
+		}
+		if !privs["ALL"] && !privs[priv] {
+			missing = append(missing, priv)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,115 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package installer_test
+
+import (
+	"github.com/percona/percona-agent/agent"
+	i "github.com/percona/percona-agent/bin/percona-agent-installer/installer"
+	"github.com/percona/percona-agent/bin/percona-agent-installer/term"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+)
+
+type VerifyTestSuite struct {
+}
+
+var _ = Suite(&VerifyTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *VerifyTestSuite) TestParseGlobalPrivileges(t *C) {
+	got := i.ParseGlobalPrivileges([]string{
+		"GRANT PROCESS, REPLICATION CLIENT, SELECT ON *.* TO 'percona-agent'@'localhost' IDENTIFIED BY PASSWORD '*ABC'",
+		"GRANT UPDATE, DELETE, DROP ON `performance_schema`.* TO 'percona-agent'@'localhost'",
+	})
+	t.Check(got, DeepEquals, map[string]bool{
+		"PROCESS":            true,
+		"REPLICATION CLIENT": true,
+		"SELECT":             true,
+	})
+
+	got = i.ParseGlobalPrivileges([]string{
+		"GRANT ALL PRIVILEGES ON *.* TO 'root'@'localhost' WITH GRANT OPTION",
+	})
+	t.Check(got, DeepEquals, map[string]bool{
+		"ALL":          true,
+		"GRANT OPTION": true,
+	})
+}
+
+func (s *VerifyTestSuite) TestClockSkew(t *C) {
+	now := time.Date(2015, 1, 2, 3, 4, 5, 600, time.UTC)
+	skew, err := i.ClockSkew(now, "Fri, 02 Jan 2015 03:04:05 GMT")
+	t.Check(err, IsNil)
+	t.Check(skew, Equals, time.Duration(0))
+
+	skew, err = i.ClockSkew(now, "Fri, 02 Jan 2015 03:14:05 GMT")
+	t.Check(err, IsNil)
+	t.Check(skew, Equals, -10*time.Minute)
+
+	_, err = i.ClockSkew(now, "")
+	t.Check(err, NotNil)
+}
+
+func (s *VerifyTestSuite) TestVerifyAPI(t *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Percona-API-Key") != "good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	flags := i.Flags{
+		Bool: map[string]bool{"mysql": false},
+	}
+	agentConfig := &agent.Config{
+		ApiHostname: strings.TrimPrefix(server.URL, "http://"),
+		ApiKey:      "good-key",
+	}
+	verifier := i.NewInstaller(term.NewTerminal(os.Stdin, false, false), "/tmp", pct.NewAPI(), agentConfig, flags)
+	report := verifier.Verify("/tmp/percona-agent-verify-test/basedir")
+	t.Check(report.Ok, Equals, true)
+	status := map[string]string{}
+	for _, check := range report.Checks {
+		status[check.Name] = check.Status
+	}
+	t.Check(status, DeepEquals, map[string]string{
+		"api-connection": i.CHECK_OK,
+		"api-tls":        i.CHECK_SKIP,
+		"api-key":        i.CHECK_OK,
+		"clock":          i.CHECK_OK,
+		"disk-space":     i.CHECK_OK,
+	})
+	t.Check(pct.FileExists("/tmp/percona-agent-verify-test"), Equals, false)
+
+	agentConfig.ApiKey = "bad-key"
+	report = verifier.Verify("/tmp")
+	t.Check(report.Ok, Equals, false)
+	t.Check(report.Checks[2], DeepEquals, i.VerifyCheck{
+		Name:    "api-key",
+		Status:  i.CHECK_FAIL,
+		Message: "access denied (status code 401)",
+	})
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/qan"
+)
+
+// benchmarkSlowLog parses the slow log like QAN does, from beginning to end,
+// and prints how fast, so users can check if parsing their slow log, e.g. on a
+// busy server, is what makes the agent use a lot of CPU.  It measures the
+// slow log parser and the QAN worker together.
+func benchmarkSlowLog(file string) error {
+	logChan := make(chan *proto.LogEntry, 100)
+	go func() {
+		for entry := range logChan {
+			if entry.Level <= proto.LOG_WARNING {
+				fmt.Println(entry.Msg)
+			}
+		}
+	}()
+
+	job := &qan.Job{
+		Id:             "benchmark",
+		SlowLogFile:    file,
+		StartOffset:    0,
+		EndOffset:      qan.END_OF_FILE,
+		RunTime:        24 * time.Hour,
+		ExampleQueries: true,
+	}
+	w := qan.NewSlowLogWorker(pct.NewLogger(logChan, "qan-worker"), "qan-benchmark")
+
+	var m0, m1 runtime.MemStats
+	runtime.ReadMemStats(&m0)
+	t0 := time.Now()
+	result, err := w.Run(job)
+	d := time.Now().Sub(t0)
+	runtime.ReadMemStats(&m1)
+	if err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return fmt.Errorf("Error parsing %s: %s", file, result.Error)
+	}
+
+	mb := float64(result.StopOffset) / 1024 / 1024
+	queries := uint64(0)
+	if result.Global != nil {
+		queries = result.Global.TotalQueries
+	}
+	fmt.Printf("Parsed %.1f MB, %d queries, %d classes in %.2fs\n", mb, queries, len(result.Class), d.Seconds())
+	fmt.Printf("%.1f MB/s, %.0f queries/s\n", mb/d.Seconds(), float64(queries)/d.Seconds())
+	if queries > 0 {
+		fmt.Printf("%.0f allocs/query, %.0f bytes/query\n",
+			float64(m1.Mallocs-m0.Mallocs)/float64(queries), float64(m1.TotalAlloc-m0.TotalAlloc)/float64(queries))
+	}
+	return nil
+}
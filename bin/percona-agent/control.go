@@ -0,0 +1,280 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/log"
+	"github.com/percona/percona-agent/pct"
+)
+
+const controlUsage = `Commands (sent to the running agent's control socket):
+  status [service]             Print status of all services or one service
+  status-history [service]     Print recent state changes of all services or one service
+  ping                         Check that the agent is running
+  send-data                    Send spooled data now
+  set-log-level level          Set and save log level: debug, info, warning, error, etc.
+  set-log-level level service [duration]
+                               Set log level of a service ("all" for all) until
+                               restart or for duration, e.g. debug data 30m;
+                               level "reset" reverts the service
+  pause duration [reason...]   Pause collecting and sending data, e.g. pause 2h backup
+  resume                       Resume after pause
+  logs [service] [limit]       Print recent log entries of all services or one service
+  diagnostics [file]           Save a diagnostic bundle (secrets and hostnames redacted)
+`
+
+// control runs a control command like "percona-agent status".
+func control(args []string) error {
+	socket := filepath.Join(flagBasedir, pct.CONTROL_SOCK)
+	user := "local"
+	if u := os.Getenv("SUDO_USER"); u != "" {
+		user = u
+	} else if u := os.Getenv("USER"); u != "" {
+		user = u
+	}
+	send := func(service, cmd string, data interface{}) (*proto.Reply, error) {
+		c, err := agent.NewControlCmd(user+" (local)", service, cmd, data)
+		if err != nil {
+			return nil, err
+		}
+		return agent.SendControlCmd(socket, c)
+	}
+
+	switch args[0] {
+	case "status":
+		service := ""
+		if len(args) > 1 {
+			service = args[1]
+		}
+		reply, err := send(service, "Status", nil)
+		if err != nil {
+			return err
+		}
+		status := map[string]string{}
+		if err := json.Unmarshal(reply.Data, &status); err != nil {
+			return err
+		}
+		bytes, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Println(string(bytes))
+	case "status-history":
+		service := ""
+		if len(args) > 1 {
+			service = args[1]
+		}
+		reply, err := send(service, "Status", agent.StatusQuery{Version: 2})
+		if err != nil {
+			return err
+		}
+		tree := []*pct.StatusNode{}
+		if err := json.Unmarshal(reply.Data, &tree); err != nil {
+			return err
+		}
+		printStatusHistory(tree)
+	case "ping":
+		reply, err := send("agent", "Ping", nil)
+		if err != nil {
+			return err
+		}
+		v := agent.Version{}
+		json.Unmarshal(reply.Data, &v)
+		fmt.Printf("OK, percona-agent %s rev %s is running\n", v.Version, v.Revision)
+	case "send-data":
+		if _, err := send("data", "SendData", nil); err != nil {
+			return err
+		}
+		fmt.Println("OK, sending data")
+	case "set-log-level":
+		if len(args) < 2 || len(args) > 4 {
+			return fmt.Errorf("Usage: set-log-level level [service [duration]]")
+		}
+		if len(args) > 2 {
+			return setLogLevel(send, args[1:])
+		}
+		if _, ok := proto.LogLevelNumber[args[1]]; !ok {
+			return fmt.Errorf("Invalid log level: %s", args[1])
+		}
+		// log SetConfig sets all values, so change only Level in the current config.
+		reply, err := send("log", "GetConfig", nil)
+		if err != nil {
+			return err
+		}
+		configs := []proto.AgentConfig{}
+		if err := json.Unmarshal(reply.Data, &configs); err != nil || len(configs) == 0 {
+			return fmt.Errorf("Invalid log GetConfig reply: %s", reply.Data)
+		}
+		config := &log.Config{}
+		if err := json.Unmarshal([]byte(configs[0].Config), config); err != nil {
+			return err
+		}
+		config.Level = args[1]
+		if _, err := send("log", "SetConfig", config); err != nil {
+			return err
+		}
+		fmt.Println("OK, log level " + args[1])
+	case "pause":
+		if len(args) < 2 {
+			return fmt.Errorf("Usage: pause duration [reason...]")
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d < time.Second {
+			return fmt.Errorf("Invalid duration: %s", args[1])
+		}
+		pause := agent.Pause{
+			Duration: uint(d.Seconds()),
+			Reason:   strings.Join(args[2:], " "),
+		}
+		if _, err := send("agent", "Pause", pause); err != nil {
+			return err
+		}
+		fmt.Printf("OK, paused for %s\n", d)
+	case "resume":
+		if _, err := send("agent", "Resume", nil); err != nil {
+			return err
+		}
+		fmt.Println("OK, resumed")
+	case "logs":
+		q := log.RecentLogsQuery{}
+		if len(args) > 1 {
+			q.Service = args[1]
+		}
+		if len(args) > 2 {
+			limit, err := strconv.Atoi(args[2])
+			if err != nil || limit <= 0 {
+				return fmt.Errorf("Invalid limit: %s", args[2])
+			}
+			q.Limit = limit
+		}
+		reply, err := send("log", "GetRecentLogs", q)
+		if err != nil {
+			return err
+		}
+		entries := []proto.LogEntry{}
+		if err := json.Unmarshal(reply.Data, &entries); err != nil {
+			return fmt.Errorf("Invalid GetRecentLogs reply: %s", err)
+		}
+		color := consoleColor()
+		for i := range entries {
+			fmt.Print(log.FormatConsole(&entries[i], color))
+		}
+	case "diagnostics":
+		file := fmt.Sprintf("percona-agent-diagnostics-%s.tar.gz", time.Now().Format("20060102-150405"))
+		if len(args) > 1 {
+			file = args[1]
+		}
+		reply, err := send("agent", "GetDiagnostics", nil)
+		if err != nil {
+			return err
+		}
+		var tarball []byte
+		if err := json.Unmarshal(reply.Data, &tarball); err != nil {
+			return fmt.Errorf("Invalid GetDiagnostics reply: %s", err)
+		}
+		if err := ioutil.WriteFile(file, tarball, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("OK, saved %s (%d bytes); review it before attaching it to a support ticket\n", file, len(tarball))
+	default:
+		fmt.Fprint(os.Stderr, controlUsage)
+		return fmt.Errorf("Unknown command: %s", args[0])
+	}
+	return nil
+}
+
+// setLogLevel sets the log level of a service, or all services, without
+// saving it: set-log-level level service [duration].
+func setLogLevel(send func(string, string, interface{}) (*proto.Reply, error), args []string) error {
+	logLevel := log.LogLevel{
+		Level:   args[0],
+		Service: args[1],
+	}
+	if logLevel.Level == "reset" {
+		logLevel.Level = ""
+	} else if _, ok := proto.LogLevelNumber[logLevel.Level]; !ok {
+		return fmt.Errorf("Invalid log level: %s", logLevel.Level)
+	}
+	if logLevel.Service == "all" {
+		logLevel.Service = ""
+	}
+	var d time.Duration
+	if len(args) > 2 {
+		var err error
+		if d, err = time.ParseDuration(args[2]); err != nil || d < time.Second {
+			return fmt.Errorf("Invalid duration: %s", args[2])
+		}
+		logLevel.Duration = uint(d.Seconds())
+	}
+	if _, err := send("log", "SetLogLevel", logLevel); err != nil {
+		return err
+	}
+	who := "all services"
+	if logLevel.Service != "" {
+		who = logLevel.Service
+	}
+	switch {
+	case logLevel.Level == "":
+		fmt.Println("OK, reset log level of " + who)
+	case d > 0:
+		fmt.Printf("OK, log level %s for %s for %s\n", logLevel.Level, who, d)
+	default:
+		fmt.Printf("OK, log level %s for %s\n", logLevel.Level, who)
+	}
+	return nil
+}
+
+type statusChange struct {
+	proc string
+	pct.StatusTransition
+}
+
+type byTs []statusChange
+
+func (a byTs) Len() int           { return len(a) }
+func (a byTs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTs) Less(i, j int) bool { return a[i].Ts.Before(a[j].Ts) }
+
+// printStatusHistory prints the state changes of all procs in the status tree,
+// oldest first.
+func printStatusHistory(tree []*pct.StatusNode) {
+	changes := []statusChange{}
+	var walk func(nodes []*pct.StatusNode)
+	walk = func(nodes []*pct.StatusNode) {
+		for _, node := range nodes {
+			for _, t := range node.History {
+				changes = append(changes, statusChange{node.Name, t})
+			}
+			walk(node.Children)
+		}
+	}
+	walk(tree)
+	sort.Stable(byTs(changes))
+	for _, c := range changes {
+		fmt.Printf("%s %-24s %s -> %s: %s\n", c.Ts.Local().Format("2006-01-02 15:04:05"), c.proc, c.From, c.To, c.Status)
+	}
+}
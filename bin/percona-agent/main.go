@@ -26,6 +26,9 @@ import (
 	"github.com/percona/percona-agent/agent"
 	"github.com/percona/percona-agent/client"
 	"github.com/percona/percona-agent/data"
+	"github.com/percona/percona-agent/event"
+	eventMonitor "github.com/percona/percona-agent/event/monitor"
+	"github.com/percona/percona-agent/heartbeat"
 	"github.com/percona/percona-agent/instance"
 	"github.com/percona/percona-agent/log"
 	"github.com/percona/percona-agent/mm"
@@ -35,32 +38,52 @@ import (
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
 	pctCmd "github.com/percona/percona-agent/pct/cmd"
+	"github.com/percona/percona-agent/plugin"
 	"github.com/percona/percona-agent/qan"
 	"github.com/percona/percona-agent/query"
 	queryService "github.com/percona/percona-agent/query/service"
+	"github.com/percona/percona-agent/resource"
+	"github.com/percona/percona-agent/scheduler"
 	"github.com/percona/percona-agent/sysconfig"
 	sysconfigMonitor "github.com/percona/percona-agent/sysconfig/monitor"
 	"github.com/percona/percona-agent/sysinfo"
 	mysqlSysinfo "github.com/percona/percona-agent/sysinfo/mysql"
+	scriptSysinfo "github.com/percona/percona-agent/sysinfo/script"
 	systemSysinfo "github.com/percona/percona-agent/sysinfo/system"
 	"github.com/percona/percona-agent/ticker"
+	"io/ioutil"
 	golog "log"
+	"net"
 	"os"
 	"os/signal"
 	"os/user"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 )
 
 var (
-	flagPing    bool
-	flagStatus  bool
-	flagBasedir string
-	flagPidFile string
-	flagVersion bool
+	flagPing       bool
+	flagStatus     bool
+	flagBasedir    string
+	flagPidFile    string
+	flagVersion    bool
+	flagEncrypt    bool
+	flagExport     string
+	flagImport     string
+	flagUser       string
+	flagSelftest   bool
+	flagForeground bool
+	flagDebug      bool
+	flagBenchmark  string
 )
 
+// SIGINT and SIGTERM stop the agent, as does the Windows service manager by
+// sending SIGTERM here, see service_windows.go.
+var sigChan = make(chan os.Signal, 1)
+
 func init() {
 	golog.SetFlags(golog.Ldate | golog.Ltime | golog.Lmicroseconds | golog.Lshortfile)
 	golog.SetOutput(os.Stdout)
@@ -70,6 +93,19 @@ func init() {
 	flag.StringVar(&flagBasedir, "basedir", pct.DEFAULT_BASEDIR, "Agent basedir")
 	flag.StringVar(&flagPidFile, "pidfile", "", "PID file")
 	flag.BoolVar(&flagVersion, "version", false, "Print version")
+	flag.BoolVar(&flagEncrypt, "encrypt-dsn", false, "Encrypt MySQL DSNs in instance configs with basedir/"+pct.INSTANCE_KEY+" (created if needed) and exit")
+	flag.StringVar(&flagExport, "export-config", "", "Export instance and service configs to a signed bundle file, to move the agent to a new host, and exit")
+	flag.StringVar(&flagImport, "import-config", "", "Import a bundle file from -export-config, take over the old agent's UUID, and exit")
+	flag.BoolVar(&flagSelftest, "selftest", false, "Check API, websocket, MySQL, slow log, spool and clock, print results and exit (non-zero if any fail)")
+	flag.BoolVar(&flagForeground, "foreground", false, "Stay attached to the terminal and log all services to stderr")
+	flag.BoolVar(&flagDebug, "debug", false, "Log at debug level, regardless of the log config")
+	flag.StringVar(&flagUser, "user", "", "Run as this user after binding local listeners (requires root)")
+	flag.StringVar(&flagBenchmark, "benchmark-slowlog", "", "Parse a slow log file like QAN, print throughput and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [command]\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(os.Stderr, "\n"+controlUsage)
+	}
 	flag.Parse()
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -81,12 +117,44 @@ func run() error {
 		fmt.Println(version)
 		return nil
 	}
+	if flag.NArg() > 0 {
+		return control(flag.Args()) // e.g. percona-agent status
+	}
+	if flagBenchmark != "" {
+		return benchmarkSlowLog(flagBenchmark)
+	}
+	if flagForeground {
+		golog.SetOutput(os.Stderr)
+	}
 	golog.Printf("Running %s pid %d\n", version, os.Getpid())
 
 	if err := pct.Basedir.Init(flagBasedir); err != nil {
 		return err
 	}
 
+	if flagEncrypt {
+		return encryptDSN()
+	}
+	if flagExport != "" {
+		return exportConfig(flagExport)
+	}
+	if flagImport != "" {
+		return importConfig(flagImport)
+	}
+
+	// Check before writing anything that the -user can use the basedir and
+	// log file, else the agent fails later, after dropping privileges.
+	var runAs *pct.RunAs
+	if flagUser != "" {
+		var err error
+		if runAs, err = pct.LookupRunAs(flagUser); err != nil {
+			return fmt.Errorf("Invalid -user: %s", err)
+		}
+		if err := checkRunAs(runAs); err != nil {
+			return err
+		}
+	}
+
 	// Start-lock file is used to let agent1 self-update, create start-lock,
 	// start updated agent2, exit cleanly, then agent2 starts.  agent1 may
 	// not use a PID file, so this special file is required.
@@ -97,7 +165,7 @@ func run() error {
 	defer os.Remove(pct.Basedir.File("start-lock"))
 
 	/**
-	 * Agent config (require API key and agent UUID)
+	 * Agent config (require API key; no agent UUID if not registered yet)
 	 */
 
 	if !pct.FileExists(pct.Basedir.ConfigFile("agent")) {
@@ -116,6 +184,34 @@ func run() error {
 	golog.Println("ApiHostname: " + agentConfig.ApiHostname)
 	golog.Println("AgentUuid: " + agentConfig.AgentUuid)
 
+	// Proxy for all connections to API, else HTTP(S)_PROXY env vars are used.
+	if err := pct.SetProxy(agentConfig.ProxyURL, agentConfig.NoProxy); err != nil {
+		return err
+	}
+	if agentConfig.ProxyURL != "" {
+		// Already parsed by SetProxy; don't log the proxy password.
+		proxyURL, _ := pct.ParseProxyURL(agentConfig.ProxyURL)
+		golog.Println("ProxyURL: " + proxyURL.Redacted())
+	}
+
+	// Custom CA, min TLS version and pins for all connections to API.
+	if err := pct.SetTLS(agentConfig.CAFile, agentConfig.TLSMinVersion, agentConfig.TLSPins); err != nil {
+		return fmt.Errorf("Invalid TLS config: %s", err)
+	}
+	if err := pct.SetClientCert(agentConfig.ClientCert, agentConfig.ClientKey); err != nil {
+		return err
+	}
+
+	// Correct report timestamps if the local clock is skewed from the API.
+	pct.SetClockAdjust(agentConfig.AdjustClock)
+
+	// Reconnect wait policy for API, data and MySQL connections.
+	if agentConfig.Backoff != nil {
+		if err := pct.SetBackoff(*agentConfig.Backoff); err != nil {
+			return fmt.Errorf("Invalid Backoff config: %s", err)
+		}
+	}
+
 	/**
 	 * Ping and exit, maybe.
 	 */
@@ -126,6 +222,10 @@ func run() error {
 		"X-Percona-Agent-Version": agent.VERSION,
 	}
 
+	if flagSelftest {
+		return selftest(agentConfig, headers)
+	}
+
 	if flagPing {
 		t0 := time.Now()
 		code, err := pct.Ping(agentConfig.ApiHostname, agentConfig.ApiKey, headers)
@@ -150,6 +250,36 @@ func run() error {
 		defer pidFile.Remove()
 	}
 
+	/**
+	 * Local listeners, then drop privileges
+	 */
+
+	// Bind the local listeners and load the instance key as root, then drop
+	// privileges before any service starts, so services never run as root
+	// and the files they create are owned by the -user.  The control socket
+	// and instance key stay root-only.
+	var statusListener net.Listener
+	if agentConfig.StatusAddress != "" {
+		if statusListener, err = agent.ListenStatus(agentConfig.StatusAddress); err != nil {
+			// Not fatal: the status server is only for local checks.
+			golog.Printf("Error starting status server: %s\n", err)
+		}
+	}
+	controlListener, err := agent.ListenControl(pct.Basedir.File("control-socket"))
+	if err != nil {
+		// Not fatal: the agent is managed through the API.
+		golog.Printf("Error starting control server: %s\n", err)
+	}
+	if runAs != nil {
+		if err := instance.PreloadKey(pct.Basedir.File("instance-key")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := runAs.Drop(); err != nil {
+			return err
+		}
+		golog.Printf("Running as user %s (uid %d)\n", runAs.Name, runAs.Uid)
+	}
+
 	/**
 	 * REST API
 	 */
@@ -191,15 +321,20 @@ func run() error {
 
 	logChan := make(chan *proto.LogEntry, log.BUFFER_SIZE*3)
 
-	// Log websocket client, possibly disabled later.
-	logClient, err := client.NewWebsocketClient(pct.NewLogger(logChan, "log-ws"), api, "log", headers)
+	// Log API client, possibly disabled later.
+	logClient, err := client.NewClient(agentConfig.Transport, pct.NewLogger(logChan, "log-ws"), api, "log", headers)
 	if err != nil {
 		golog.Fatalln(err)
 	}
+	logClient.SetCompression(agentConfig.Compression)
 	logManager := log.NewManager(
 		logClient,
 		logChan,
 	)
+	if flagForeground {
+		logManager.SetConsole(os.Stderr, consoleColor())
+	}
+	logManager.SetDebug(flagDebug)
 	if err := logManager.Start(); err != nil {
 		return fmt.Errorf("Error starting logmanager: %s\n", err)
 	}
@@ -239,10 +374,11 @@ func run() error {
 
 	hostname, _ := os.Hostname()
 
-	dataClient, err := client.NewWebsocketClient(pct.NewLogger(logChan, "data-ws"), api, "data", headers)
+	dataClient, err := client.NewClient(agentConfig.Transport, pct.NewLogger(logChan, "data-ws"), api, "data", headers)
 	if err != nil {
 		golog.Fatalln(err)
 	}
+	dataClient.SetCompression(agentConfig.Compression)
 	dataManager := data.NewManager(
 		pct.NewLogger(logChan, "data"),
 		pct.Basedir.Dir("data"),
@@ -250,10 +386,14 @@ func run() error {
 		hostname,
 		dataClient,
 	)
+	dataManager.SetSigningKey([]byte(agentConfig.ApiKey))
 	if err := dataManager.Start(); err != nil {
 		return fmt.Errorf("Error starting data manager: %s\n", err)
 	}
 
+	// Keep the last mm and QAN data in memory for the local status server.
+	recentSpool := data.NewRecentSpooler(dataManager.Spooler(), data.DEFAULT_RECENT, "mm", "qan")
+
 	/**
 	 * Collecct/report ticker (master clock)
 	 */
@@ -269,14 +409,28 @@ func run() error {
 		pct.NewLogger(logChan, "mm"),
 		mmMonitor.NewFactory(logChan, itManager.Repo(), mrm),
 		clock,
-		dataManager.Spooler(),
+		recentSpool,
 		itManager.Repo(),
 		mrm,
 	)
-	if err := mmManager.Start(); err != nil {
+	if agentConfig.ServiceDisabled("mm") {
+		golog.Println("mm disabled")
+	} else if err := mmManager.Start(); err != nil {
 		return fmt.Errorf("Error starting mm manager: %s\n", err)
 	}
 
+	/**
+	 * Agent resource limits
+	 */
+
+	resourceManager := resource.NewManager(
+		pct.NewLogger(logChan, "resource"),
+		mmManager,
+	)
+	if err := resourceManager.Start(); err != nil {
+		return fmt.Errorf("Error starting resource manager: %s\n", err)
+	}
+
 	sysconfigManager := sysconfig.NewManager(
 		pct.NewLogger(logChan, "sysconfig"),
 		sysconfigMonitor.NewFactory(logChan, itManager.Repo()),
@@ -288,6 +442,30 @@ func run() error {
 		return fmt.Errorf("Error starting sysconfig manager: %s\n", err)
 	}
 
+	eventManager := event.NewManager(
+		pct.NewLogger(logChan, "event"),
+		eventMonitor.NewFactory(logChan, itManager.Repo()),
+		clock,
+		dataManager.Spooler(),
+		itManager.Repo(),
+	)
+	if agentConfig.ServiceDisabled("event") {
+		golog.Println("event disabled")
+	} else if err := eventManager.Start(); err != nil {
+		return fmt.Errorf("Error starting event manager: %s\n", err)
+	}
+
+	heartbeatManager := heartbeat.NewManager(
+		pct.NewLogger(logChan, "heartbeat"),
+		&mysql.RealConnectionFactory{},
+		itManager.Repo(),
+	)
+	if agentConfig.ServiceDisabled("heartbeat") {
+		golog.Println("heartbeat disabled")
+	} else if err := heartbeatManager.Start(); err != nil {
+		return fmt.Errorf("Error starting heartbeat manager: %s\n", err)
+	}
+
 	/**
 	 * Query service
 	 */
@@ -296,11 +474,25 @@ func run() error {
 		&mysql.RealConnectionFactory{},
 		itManager.Repo(),
 	)
+	indexesService := queryService.NewIndexes(
+		pct.NewLogger(logChan, "query-indexes"),
+		&mysql.RealConnectionFactory{},
+		itManager.Repo(),
+	)
+	replicationService := queryService.NewReplication(
+		pct.NewLogger(logChan, "query-replication"),
+		&mysql.RealConnectionFactory{},
+		itManager.Repo(),
+	)
 	queryManager := query.NewManager(
 		pct.NewLogger(logChan, "query"),
 		explainService,
+		indexesService,
+		replicationService,
 	)
-	if err := queryManager.Start(); err != nil {
+	if agentConfig.ServiceDisabled("query") {
+		golog.Println("query disabled")
+	} else if err := queryManager.Start(); err != nil {
 		return fmt.Errorf("Error starting query manager: %s\n", err)
 	}
 
@@ -314,11 +506,13 @@ func run() error {
 		clock,
 		qan.NewRealIntervalIterFactory(logChan),
 		qan.NewRealWorkerFactory(logChan),
-		dataManager.Spooler(),
+		recentSpool,
 		itManager.Repo(),
 		mrm,
 	)
-	if err := qanManager.Start(); err != nil {
+	if agentConfig.ServiceDisabled("qan") {
+		golog.Println("qan disabled")
+	} else if err := qanManager.Start(); err != nil {
 		return fmt.Errorf("Error starting qan manager: %s\n", err)
 	}
 
@@ -346,8 +540,66 @@ func run() error {
 		return fmt.Errorf("Error registering System Sysinfo service: %s\n", err)
 	}
 
+	// Host Sysinfo (native, structured pt-summary)
+	hostSysinfoService := systemSysinfo.NewSummary(
+		pct.NewLogger(logChan, "sysinfo-host"),
+	)
+	if err := sysinfoManager.RegisterService("HostSummary", hostSysinfoService); err != nil {
+		return fmt.Errorf("Error registering Host Sysinfo service: %s\n", err)
+	}
+
+	// Top processes Sysinfo
+	topSysinfoService := systemSysinfo.NewTop(
+		pct.NewLogger(logChan, "sysinfo-top"),
+	)
+	if err := sysinfoManager.RegisterService("TopProcesses", topSysinfoService); err != nil {
+		return fmt.Errorf("Error registering Top Processes Sysinfo service: %s\n", err)
+	}
+
+	// Kernel log Sysinfo
+	dmesgSysinfoService := systemSysinfo.NewDmesg(
+		pct.NewLogger(logChan, "sysinfo-dmesg"),
+	)
+	if err := sysinfoManager.RegisterService("KernelLog", dmesgSysinfoService); err != nil {
+		return fmt.Errorf("Error registering Kernel Log Sysinfo service: %s\n", err)
+	}
+
+	// Disk and RAID health Sysinfo
+	healthSysinfoService := systemSysinfo.NewHealth(
+		pct.NewLogger(logChan, "sysinfo-health"),
+	)
+	if err := sysinfoManager.RegisterService("DiskHealth", healthSysinfoService); err != nil {
+		return fmt.Errorf("Error registering Disk Health Sysinfo service: %s\n", err)
+	}
+
+	// NUMA and swapping Sysinfo
+	numaSysinfoService := systemSysinfo.NewNUMA(
+		pct.NewLogger(logChan, "sysinfo-numa"),
+	)
+	if err := sysinfoManager.RegisterService("NUMA", numaSysinfoService); err != nil {
+		return fmt.Errorf("Error registering NUMA Sysinfo service: %s\n", err)
+	}
+
+	// Whitelisted custom scripts Sysinfo
+	scriptSysinfoService := scriptSysinfo.NewScript(
+		pct.NewLogger(logChan, "sysinfo-script"),
+	)
+	if err := sysinfoManager.RegisterService("Script", scriptSysinfoService); err != nil {
+		return fmt.Errorf("Error registering Script Sysinfo service: %s\n", err)
+	}
+
+	// External plugins Sysinfo
+	pluginSysinfoService := plugin.NewService(
+		pct.NewLogger(logChan, "sysinfo-plugin"),
+	)
+	if err := sysinfoManager.RegisterService(plugin.SERVICE_NAME, pluginSysinfoService); err != nil {
+		return fmt.Errorf("Error registering Plugin Sysinfo service: %s\n", err)
+	}
+
 	// Start Sysinfo manager
-	if err := sysinfoManager.Start(); err != nil {
+	if agentConfig.ServiceDisabled("sysinfo") {
+		golog.Println("sysinfo disabled")
+	} else if err := sysinfoManager.Start(); err != nil {
 		return fmt.Errorf("Error starting Sysinfo manager: %s\n", err)
 	}
 
@@ -358,7 +610,6 @@ func run() error {
 	// Generally the agent has a crash-only design, but QAN is so far the only service
 	// which reconfigures MySQL: it enables the slow log, sets long_query_time, etc.
 	// It's not terrible to leave slow log on, but it's nicer to turn it off.
-	sigChan := make(chan os.Signal, 1)
 	stopChan := make(chan error, 2)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
@@ -371,10 +622,12 @@ func run() error {
 	 * Agent
 	 */
 
-	cmdClient, err := client.NewWebsocketClient(pct.NewLogger(logChan, "agent-ws"), api, "cmd", headers)
+	cmdClient, err := client.NewClient(agentConfig.Transport, pct.NewLogger(logChan, "agent-ws"), api, "cmd", headers)
 	if err != nil {
 		golog.Fatal(err)
 	}
+	cmdClient.SetCompression(agentConfig.Compression)
+	cmdClient.SetKeepalive(agentConfig.PingInterval, agentConfig.PingTimeout)
 
 	// The official list of services known to the agent.  Adding a new service
 	// requires a manager, starting the manager as above, and adding the manager
@@ -387,9 +640,33 @@ func run() error {
 		"instance":  itManager,
 		"mrms":      mrmsManager,
 		"sysconfig": sysconfigManager,
+		"event":     eventManager,
+		"heartbeat": heartbeatManager,
 		"query":     queryManager,
 		"sysinfo":   sysinfoManager,
+		"resource":  resourceManager,
 	}
+	itManager.SetServices(services) // stop services using a removed instance
+
+	/**
+	 * Scheduled cmds
+	 */
+
+	// The scheduler runs cmds on the other services, so it's made last.
+	// It can only schedule cmds allowed by the agent config.
+	schedulerManager := scheduler.NewManager(
+		pct.NewLogger(logChan, "scheduler"),
+		clock,
+		dataManager.Spooler(),
+		services,
+		func(service, cmd string) bool {
+			return agent.CmdAllowed(agentConfig.AllowCmds, service, cmd)
+		},
+	)
+	if err := schedulerManager.Start(); err != nil {
+		return fmt.Errorf("Error starting scheduler manager: %s\n", err)
+	}
+	services["scheduler"] = schedulerManager
 
 	// Set the global pct/cmd.Factory, used for the Restart cmd.
 	pctCmd.Factory = &pctCmd.RealCmdFactory{}
@@ -401,6 +678,26 @@ func run() error {
 		cmdClient,
 		services,
 	)
+	agent.SetForeground(flagForeground)
+
+	/**
+	 * Local status server (optional)
+	 */
+
+	if statusListener != nil {
+		statusServer := startStatusServer(agent, statusListener, agentConfig.StatusDebug, recentSpool)
+		golog.Println("Status server: http://" + statusServer.Addr())
+		defer statusServer.Stop()
+	}
+
+	/**
+	 * Local control socket for "percona-agent status", etc.
+	 */
+
+	if controlListener != nil {
+		controlServer := startControlServer(agent, controlListener)
+		defer controlServer.Stop()
+	}
 
 	/**
 	 * Run agent, wait for it to stop, signal, or crash.
@@ -422,9 +719,9 @@ func run() error {
 	// Wait for agent to stop, or for signals.
 	agentRunning := true
 	statusSigChan := make(chan os.Signal, 1)
-	signal.Notify(statusSigChan, syscall.SIGUSR1) // kill -USER1 PID
-	reconnectSigChan := make(chan os.Signal, 1)
-	signal.Notify(reconnectSigChan, syscall.SIGHUP) // kill -HUP PID
+	notifyStatus(statusSigChan) // kill -USER1 PID
+	reloadSigChan := make(chan os.Signal, 1)
+	signal.Notify(reloadSigChan, syscall.SIGHUP) // kill -HUP PID
 	for agentRunning {
 		select {
 		case stopErr = <-stopChan: // agent or signal
@@ -433,16 +730,16 @@ func run() error {
 		case <-statusSigChan:
 			status := agent.AllStatus()
 			golog.Printf("Status: %+v\n", status)
-		case <-reconnectSigChan:
+		case <-reloadSigChan:
+			// Apply changed config files, then reconnect.  The agent does
+			// this in its cmd queue, so don't block on it here.
 			u, _ := user.Current()
-			cmd := &proto.Cmd{
-				Ts:        time.Now().UTC(),
-				User:      u.Username + " (SIGHUP)",
-				AgentUuid: agentConfig.AgentUuid,
-				Service:   "agent",
-				Cmd:       "Reconnect",
-			}
-			agent.Handle(cmd)
+			golog.Println("Caught SIGHUP, reloading configs and reconnecting...")
+			go func() {
+				if err := agent.Reload(u.Username + " (SIGHUP)"); err != nil {
+					golog.Println(err)
+				}
+			}()
 		}
 	}
 
@@ -452,7 +749,8 @@ func run() error {
 }
 
 func ConnectAPI(agentConfig *agent.Config, retry int) (*pct.API, error) {
-	golog.Println("ApiHostname: " + agentConfig.ApiHostname)
+	hostnames := agentConfig.Hostnames()
+	golog.Println("ApiHostname: " + strings.Join(hostnames, ", "))
 	golog.Println("ApiKey: " + agentConfig.ApiKey)
 
 	api := pct.NewAPI()
@@ -463,8 +761,21 @@ func ConnectAPI(agentConfig *agent.Config, retry int) (*pct.API, error) {
 	for (retry == -1 || try < retry) && time.Now().Sub(t0) < week {
 		try++
 		time.Sleep(backoff.Wait())
+		if agentConfig.AgentUuid == "" {
+			// Installed offline; register now that the API might be reachable.
+			golog.Println("Registering agent")
+			hostname, _ := os.Hostname()
+			if err := agent.Register(api, agentConfig, hostname); err != nil {
+				golog.Println(err)
+				continue
+			}
+			if err := pct.Basedir.WriteConfig("agent", agentConfig); err != nil {
+				return nil, err
+			}
+			golog.Println("Registered agent: " + agentConfig.AgentUuid)
+		}
 		golog.Println("Connecting to API")
-		if err := api.Connect(agentConfig.ApiHostname, agentConfig.ApiKey, agentConfig.AgentUuid); err != nil {
+		if err := api.ConnectAny(hostnames, agentConfig.ApiKey, agentConfig.AgentUuid); err != nil {
 			golog.Println(err)
 			continue
 		}
@@ -472,11 +783,160 @@ func ConnectAPI(agentConfig *agent.Config, retry int) (*pct.API, error) {
 		return api, nil // success
 	}
 
-	return nil, errors.New("Timeout connecting to " + agentConfig.ApiHostname)
+	return nil, errors.New("Timeout connecting to " + strings.Join(hostnames, ", "))
+}
+
+// encryptDSN makes the instance key if it doesn't exist, then loads the
+// instance configs which encrypts their plaintext MySQL DSNs.  The agent
+// must be restarted to use the key.
+func encryptDSN() error {
+	keyFile := pct.Basedir.File("instance-key")
+	if !pct.FileExists(keyFile) {
+		if err := instance.MakeKey(keyFile); err != nil {
+			return err
+		}
+		golog.Println("Created " + keyFile)
+	}
+	logChan := make(chan *proto.LogEntry, 100)
+	repo := instance.NewRepo(pct.NewLogger(logChan, "instance-repo"), pct.Basedir.Dir("config"), nil)
+	if err := repo.Init(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case log := <-logChan:
+			if strings.HasPrefix(log.Msg, "Encrypted") {
+				golog.Println(log.Msg)
+			}
+		default:
+			golog.Println("All MySQL DSNs are encrypted; restart percona-agent to use " + keyFile)
+			return nil
+		}
+	}
+}
+
+// exportConfig writes a bundle of the instance and service configs to file.
+// MySQL DSNs in it are not encrypted, so it's only readable by the user.
+func exportConfig(file string) error {
+	config := &agent.Config{}
+	if err := pct.Basedir.ReadConfig("agent", config); err != nil {
+		return err
+	}
+	logChan := make(chan *proto.LogEntry, 100)
+	repo := instance.NewRepo(pct.NewLogger(logChan, "instance-repo"), pct.Basedir.Dir("config"), nil)
+	if err := repo.Init(); err != nil {
+		return err
+	}
+	bundle, err := instance.ExportBundle(repo, pct.Basedir.Dir("config"), config.AgentUuid, config.ApiKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return err
+	}
+	golog.Printf("Exported %d configs to %s; it contains MySQL passwords, so keep it safe\n", len(bundle.Configs), file)
+	return nil
+}
+
+// importConfig imports a bundle from exportConfig on another host, and rebinds
+// this agent to the old agent's UUID so the API sees the same agent on the new
+// host.  The old agent must be stopped first.
+func importConfig(file string) error {
+	config := &agent.Config{}
+	if err := pct.Basedir.ReadConfig("agent", config); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	// Instances are added through a repo to encrypt their DSNs if there's
+	// an instance key.
+	logChan := make(chan *proto.LogEntry, 100)
+	repo := instance.NewRepo(pct.NewLogger(logChan, "instance-repo"), pct.Basedir.Dir("config"), nil)
+	bundle, err := instance.ImportBundle(repo, data, config.ApiKey)
+	if err != nil {
+		return err
+	}
+	golog.Printf("Imported %d configs from %s (exported on %s at %s)\n", len(bundle.Configs), file, bundle.Hostname, bundle.Ts)
+	if bundle.AgentUuid != "" && bundle.AgentUuid != config.AgentUuid {
+		golog.Printf("Agent UUID changed from %s to %s\n", config.AgentUuid, bundle.AgentUuid)
+		config.AgentUuid = bundle.AgentUuid
+		if err := pct.Basedir.WriteConfig("agent", config); err != nil {
+			return err
+		}
+	}
+	golog.Println("Stop percona-agent on " + bundle.Hostname + ", then restart percona-agent here")
+	return nil
+}
+
+// consoleColor returns true if stderr is a terminal and NO_COLOR isn't set.
+func consoleColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func checkRunAs(r *pct.RunAs) error {
+	if uid := os.Getuid(); uid != 0 && uid != r.Uid {
+		return fmt.Errorf("Cannot run as user %s: percona-agent is not running as root", r.Name)
+	}
+	if err := r.CheckPermissions(pct.Basedir.Path()); err != nil {
+		return err
+	}
+
+	// The log file can be outside the basedir, e.g. /var/log/percona-agent.log.
+	logConfig := &log.Config{}
+	if err := pct.Basedir.ReadConfig("log", logConfig); err != nil {
+		return nil // log manager uses defaults
+	}
+	logFile := logConfig.File
+	if logFile == "" || logFile == "STDOUT" || logFile == "STDERR" {
+		return nil
+	}
+	if !filepath.IsAbs(logFile) {
+		logFile = filepath.Join(pct.Basedir.Path(), logFile)
+	}
+	if pct.FileExists(logFile) {
+		return r.CheckPermissions(logFile)
+	}
+	fi, err := os.Stat(filepath.Dir(logFile))
+	if err != nil {
+		return fmt.Errorf("Invalid log file %s: %s", logFile, err)
+	}
+	if !r.CanAccess(fi, 03) {
+		return fmt.Errorf("Cannot create log file %s: directory %s is not writable by user %s", logFile, filepath.Dir(logFile), r.Name)
+	}
+	return nil
+}
+
+func startStatusServer(a *agent.Agent, listener net.Listener, debug bool, recent agent.RecentData) *agent.StatusServer {
+	s := agent.NewStatusServer(a, listener.Addr().String())
+	s.SetRecent(recent)
+	if debug {
+		s.EnableDebug()
+	}
+	s.Serve(listener)
+	return s
+}
+
+func startControlServer(a *agent.Agent, listener net.Listener) *agent.ControlServer {
+	s := agent.NewControlServer(a, listener.Addr().String())
+	s.Serve(listener)
+	return s
 }
 
 func main() {
-	if err := run(); err != nil {
+	if err := runService(run); err != nil {
 		golog.Fatal(err) // non-zero exit
 		os.Exit(1)
 	}
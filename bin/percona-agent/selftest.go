@@ -0,0 +1,199 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/agent"
+	"github.com/percona/percona-agent/client"
+	"github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/qan"
+)
+
+const (
+	SELFTEST_PASS = "PASS"
+	SELFTEST_FAIL = "FAIL"
+	SELFTEST_SKIP = "SKIP"
+)
+
+// Seconds to wait for the websocket and each MySQL connection.
+const SELFTEST_TIMEOUT = 10
+
+type selftestResult struct {
+	Check  string
+	Result string
+	Detail string
+}
+
+// selftest checks everything the agent needs to run, without starting it,
+// prints a pass/fail matrix, and returns an error if any check fails so that
+// "percona-agent -selftest" exits non-zero in deployment pipelines.
+func selftest(agentConfig *agent.Config, headers map[string]string) error {
+	results := []selftestResult{}
+	add := func(check, result, detail string) {
+		results = append(results, selftestResult{check, result, detail})
+	}
+	check := func(name string, err error, detail string) {
+		if err != nil {
+			add(name, SELFTEST_FAIL, err.Error())
+		} else {
+			add(name, SELFTEST_PASS, detail)
+		}
+	}
+
+	logChan := make(chan *proto.LogEntry, 100) // not relayed, non-blocking
+
+	// API connectivity; also measures the clock skew.
+	api := pct.NewAPI()
+	apiErr := api.ConnectAny(agentConfig.Hostnames(), agentConfig.ApiKey, agentConfig.AgentUuid)
+	check("api", apiErr, api.Hostname())
+
+	// Websocket handshake, on the data link so a running agent's cmd link
+	// isn't replaced.
+	if apiErr != nil {
+		add("websocket", SELFTEST_SKIP, "API not connected")
+	} else {
+		detail, err := selftestWebsocket(logChan, api, headers, agentConfig.Transport, agentConfig.Compression)
+		check("websocket", err, detail)
+	}
+
+	// Each configured MySQL instance.
+	repo := instance.NewRepo(pct.NewLogger(logChan, "instance-repo"), pct.Basedir.Dir("config"), nil)
+	if err := repo.Init(); err != nil {
+		add("instances", SELFTEST_FAIL, err.Error())
+	} else {
+		n := 0
+		for _, name := range repo.List() {
+			if !strings.HasPrefix(name, "mysql-") {
+				continue
+			}
+			n++
+			check(name, selftestMySQL(repo, name), "Connected")
+		}
+		if n == 0 {
+			add("mysql", SELFTEST_SKIP, "No MySQL instances")
+		}
+	}
+
+	// Slow log readability, if QAN collects from it.
+	qanConfig := &qan.Config{}
+	if !pct.FileExists(pct.Basedir.ConfigFile("qan")) {
+		add("slow log", SELFTEST_SKIP, "QAN not configured")
+	} else if err := pct.Basedir.ReadConfig("qan", qanConfig); err != nil {
+		add("slow log", SELFTEST_FAIL, err.Error())
+	} else if qanConfig.CollectFrom == "perfschema" {
+		add("slow log", SELFTEST_SKIP, "QAN collects from Performance Schema")
+	} else {
+		file, err := selftestSlowLog(repo, qanConfig.InstanceId)
+		check("slow log", err, file)
+	}
+
+	// Spool writability.
+	spoolFile, err := ioutil.TempFile(pct.Basedir.Dir("data"), "selftest-")
+	if err == nil {
+		spoolFile.Close()
+		err = os.Remove(spoolFile.Name())
+	}
+	check("spool", err, pct.Basedir.Dir("data"))
+
+	// Local clock compared to the API.
+	if skew, ok := pct.ClockSkew(); !ok {
+		add("clock", SELFTEST_SKIP, pct.ClockStatus())
+	} else if skew > pct.CLOCK_SKEW_WARN || skew < -pct.CLOCK_SKEW_WARN {
+		add("clock", SELFTEST_FAIL, pct.ClockStatus())
+	} else {
+		add("clock", SELFTEST_PASS, pct.ClockStatus())
+	}
+
+	failed := 0
+	for _, r := range results {
+		fmt.Printf("%-4s  %-14s %s\n", r.Result, r.Check, r.Detail)
+		if r.Result == SELFTEST_FAIL {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Self-test failed: %d of %d checks failed", failed, len(results))
+	}
+	fmt.Println("Self-test OK")
+	return nil
+}
+
+func selftestWebsocket(logChan chan *proto.LogEntry, api pct.APIConnector, headers map[string]string, transport string, compression bool) (string, error) {
+	ws, err := client.NewClient(transport, pct.NewLogger(logChan, "selftest-ws"), api, "data", headers)
+	if err != nil {
+		return "", err
+	}
+	ws.SetCompression(compression)
+	if err := ws.ConnectOnce(SELFTEST_TIMEOUT); err != nil {
+		return "", err
+	}
+	defer ws.DisconnectOnce()
+	return ws.Status()["selftest-ws"], nil
+}
+
+func selftestMySQL(repo *instance.Repo, name string) error {
+	id, err := strconv.ParseUint(strings.TrimPrefix(name, "mysql-"), 10, 32)
+	if err != nil {
+		return err
+	}
+	mi := &proto.MySQLInstance{}
+	if err := repo.Get("mysql", uint(id), mi); err != nil {
+		return err
+	}
+	conn := mysql.NewConnection(mi.DSN)
+	if err := conn.Connect(1); err != nil {
+		return fmt.Errorf("%s: %s", mysql.HideDSNPassword(mi.DSN), mysql.FormatError(err))
+	}
+	conn.Close()
+	return nil
+}
+
+func selftestSlowLog(repo *instance.Repo, id uint) (string, error) {
+	mi := &proto.MySQLInstance{}
+	if err := repo.Get("mysql", id, mi); err != nil {
+		return "", err
+	}
+	conn := mysql.NewConnection(mi.DSN)
+	if err := conn.Connect(1); err != nil {
+		return "", fmt.Errorf("Cannot get slow log file: %s", mysql.FormatError(err))
+	}
+	defer conn.Close()
+	file := conn.GetGlobalVarString("slow_query_log_file")
+	if file == "" {
+		return "", fmt.Errorf("slow_query_log_file is not set")
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(conn.GetGlobalVarString("datadir"), file)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return file, nil
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyStatus makes SIGUSR1 print the agent status.
+func notifyStatus(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
+
+// runService runs the agent.  On Unix, the init script daemonizes it.
+func runService(run func() error) error {
+	return run()
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package main
+
+import (
+	golog "log"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+const SERVICE_NAME = "percona-agent"
+
+// notifyStatus does nothing: Windows has no SIGUSR1.  Use "percona-agent
+// status" instead.
+func notifyStatus(c chan os.Signal) {
+}
+
+// runService runs the agent as a Windows service if the service control
+// manager (SCM) started it, else in the console like on Unix.  Install the
+// service with: sc create percona-agent binPath= "C:\...\percona-agent.exe"
+func runService(run func() error) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return run()
+	}
+	return svc.Run(SERVICE_NAME, &service{run: run})
+}
+
+type service struct {
+	run func() error
+}
+
+// Execute runs the agent and reports its state to the SCM.  Stop and
+// Shutdown send SIGTERM to sigChan, so the agent stops like on Unix.
+func (s *service) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.run()
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-errChan:
+			changes <- svc.Status{State: svc.StopPending}
+			if err != nil {
+				golog.Println(err)
+				return false, 1
+			}
+			return false, 0
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				select {
+				case sigChan <- syscall.SIGTERM:
+				default: // already stopping
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,359 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package agentpb has the messages of the gRPC Agent service (agent.proto)
+// with the Marshal, Unmarshal, and Size methods that protoc-gen-gogofaster
+// generates, so Codec needs no protobuf runtime.
+package agentpb
+
+//go:generate protoc --gogofaster_out=. agent.proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrTruncated = errors.New("truncated protobuf message")
+
+type Cmd struct {
+	Id        uint64
+	Ts        int64 // Unix nanoseconds, UTC
+	User      string
+	AgentUuid string
+	Service   string
+	Cmd       string
+	Data      []byte
+	RelayId   string
+}
+
+func (m *Cmd) Size() int {
+	return sizeVarint(1, m.Id) + sizeVarint(2, uint64(m.Ts)) + sizeString(3, m.User) +
+		sizeString(4, m.AgentUuid) + sizeString(5, m.Service) + sizeString(6, m.Cmd) +
+		sizeBytes(7, m.Data) + sizeString(8, m.RelayId)
+}
+
+func (m *Cmd) Marshal() ([]byte, error) {
+	b := make([]byte, 0, m.Size())
+	b = appendVarint(b, 1, m.Id)
+	b = appendVarint(b, 2, uint64(m.Ts))
+	b = appendString(b, 3, m.User)
+	b = appendString(b, 4, m.AgentUuid)
+	b = appendString(b, 5, m.Service)
+	b = appendString(b, 6, m.Cmd)
+	b = appendBytes(b, 7, m.Data)
+	b = appendString(b, 8, m.RelayId)
+	return b, nil
+}
+
+func (m *Cmd) Unmarshal(data []byte) error {
+	*m = Cmd{}
+	return unmarshal(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Id = f.varint
+		case 2:
+			m.Ts = int64(f.varint)
+		case 3:
+			m.User = string(f.bytes)
+		case 4:
+			m.AgentUuid = string(f.bytes)
+		case 5:
+			m.Service = string(f.bytes)
+		case 6:
+			m.Cmd = string(f.bytes)
+		case 7:
+			m.Data = append([]byte{}, f.bytes...)
+		case 8:
+			m.RelayId = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+type Reply struct {
+	Id      uint64
+	Cmd     string
+	Error   string
+	Data    []byte
+	RelayId string
+}
+
+func (m *Reply) Size() int {
+	return sizeVarint(1, m.Id) + sizeString(2, m.Cmd) + sizeString(3, m.Error) +
+		sizeBytes(4, m.Data) + sizeString(5, m.RelayId)
+}
+
+func (m *Reply) Marshal() ([]byte, error) {
+	b := make([]byte, 0, m.Size())
+	b = appendVarint(b, 1, m.Id)
+	b = appendString(b, 2, m.Cmd)
+	b = appendString(b, 3, m.Error)
+	b = appendBytes(b, 4, m.Data)
+	b = appendString(b, 5, m.RelayId)
+	return b, nil
+}
+
+func (m *Reply) Unmarshal(data []byte) error {
+	*m = Reply{}
+	return unmarshal(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Id = f.varint
+		case 2:
+			m.Cmd = string(f.bytes)
+		case 3:
+			m.Error = string(f.bytes)
+		case 4:
+			m.Data = append([]byte{}, f.bytes...)
+		case 5:
+			m.RelayId = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+type LogEntry struct {
+	Ts      int64 // Unix nanoseconds, UTC
+	Level   uint32
+	Service string
+	Msg     string
+	Offline bool
+}
+
+func (m *LogEntry) Size() int {
+	return sizeVarint(1, uint64(m.Ts)) + sizeVarint(2, uint64(m.Level)) + sizeString(3, m.Service) +
+		sizeString(4, m.Msg) + sizeVarint(5, boolVarint(m.Offline))
+}
+
+func (m *LogEntry) Marshal() ([]byte, error) {
+	b := make([]byte, 0, m.Size())
+	b = appendVarint(b, 1, uint64(m.Ts))
+	b = appendVarint(b, 2, uint64(m.Level))
+	b = appendString(b, 3, m.Service)
+	b = appendString(b, 4, m.Msg)
+	b = appendVarint(b, 5, boolVarint(m.Offline))
+	return b, nil
+}
+
+func (m *LogEntry) Unmarshal(data []byte) error {
+	*m = LogEntry{}
+	return unmarshal(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Ts = int64(f.varint)
+		case 2:
+			m.Level = uint32(f.varint)
+		case 3:
+			m.Service = string(f.bytes)
+		case 4:
+			m.Msg = string(f.bytes)
+		case 5:
+			m.Offline = f.varint != 0
+		}
+		return nil
+	})
+}
+
+type DataFile struct {
+	Data []byte
+}
+
+func (m *DataFile) Size() int {
+	return sizeBytes(1, m.Data)
+}
+
+func (m *DataFile) Marshal() ([]byte, error) {
+	return appendBytes(make([]byte, 0, m.Size()), 1, m.Data), nil
+}
+
+func (m *DataFile) Unmarshal(data []byte) error {
+	*m = DataFile{}
+	return unmarshal(data, func(f field) error {
+		if f.num == 1 {
+			m.Data = append([]byte{}, f.bytes...)
+		}
+		return nil
+	})
+}
+
+type Response struct {
+	Code  uint32
+	Error string
+}
+
+func (m *Response) Size() int {
+	return sizeVarint(1, uint64(m.Code)) + sizeString(2, m.Error)
+}
+
+func (m *Response) Marshal() ([]byte, error) {
+	b := make([]byte, 0, m.Size())
+	b = appendVarint(b, 1, uint64(m.Code))
+	b = appendString(b, 2, m.Error)
+	return b, nil
+}
+
+func (m *Response) Unmarshal(data []byte) error {
+	*m = Response{}
+	return unmarshal(data, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Code = uint32(f.varint)
+		case 2:
+			m.Error = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Wire format
+/////////////////////////////////////////////////////////////////////////////
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// Default (zero) values are not sent, like proto3.
+
+func sizeVarint(num int, v uint64) int {
+	if v == 0 {
+		return 0
+	}
+	return uvarintLen(uint64(num)<<3) + uvarintLen(v)
+}
+
+func sizeString(num int, s string) int {
+	if len(s) == 0 {
+		return 0
+	}
+	return uvarintLen(uint64(num)<<3) + uvarintLen(uint64(len(s))) + len(s)
+}
+
+func sizeBytes(num int, b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	return uvarintLen(uint64(num)<<3) + uvarintLen(uint64(len(b))) + len(b)
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func boolVarint(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func appendVarint(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(num)<<3|wireVarint)
+	return appendUvarint(b, v)
+}
+
+func appendString(b []byte, num int, s string) []byte {
+	if len(s) == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(num)<<3|wireBytes)
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, num int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(num)<<3|wireBytes)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// A field is one decoded field: varint is set for varint fields, bytes for
+// length-delimited fields.  Fixed-size fields aren't used by the messages, so
+// they're skipped like unknown fields.
+type field struct {
+	num    uint64
+	varint uint64
+	bytes  []byte
+}
+
+// unmarshal decodes each field in data and calls set for it.  Fields that set
+// doesn't know are skipped, so newer messages with more fields can be read.
+func unmarshal(data []byte, set func(field) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrTruncated
+		}
+		data = data[n:]
+		f := field{num: key >> 3}
+		switch wireType := key & 7; wireType {
+		case wireVarint:
+			if f.varint, n = binary.Uvarint(data); n <= 0 {
+				return ErrTruncated
+			}
+			data = data[n:]
+		case wire64:
+			if len(data) < 8 {
+				return ErrTruncated
+			}
+			data = data[8:]
+			continue
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return ErrTruncated
+			}
+			f.bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case wire32:
+			if len(data) < 4 {
+				return ErrTruncated
+			}
+			data = data[4:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		if err := set(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agentpb_test
+
+import (
+	"github.com/percona/percona-agent/client/agentpb"
+	. "gopkg.in/check.v1"
+	"testing"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestCodec(t *C) {
+	codec := agentpb.Codec{}
+	data, err := codec.Marshal(&agentpb.Reply{Id: 300, Cmd: "Ping"})
+	t.Assert(err, IsNil)
+	// field 1 varint 300, field 2 bytes "Ping"
+	t.Check(data, DeepEquals, []byte{0x08, 0xac, 0x02, 0x12, 0x04, 'P', 'i', 'n', 'g'})
+
+	reply := &agentpb.Reply{Error: "old"}
+	t.Assert(codec.Unmarshal(data, reply), IsNil)
+	t.Check(reply, DeepEquals, &agentpb.Reply{Id: 300, Cmd: "Ping"})
+
+	// Default values are not sent.
+	data, err = codec.Marshal(&agentpb.Cmd{})
+	t.Assert(err, IsNil)
+	t.Check(data, HasLen, 0)
+
+	_, err = codec.Marshal(struct{}{})
+	t.Check(err, NotNil)
+	t.Check(codec.Unmarshal(data, &struct{}{}), NotNil)
+}
+
+func (s *TestSuite) TestMessages(t *C) {
+	msgs := []agentpb.Message{
+		&agentpb.Cmd{Id: 1, Ts: 1416000000000000123, User: "u", AgentUuid: "uuid", Service: "qan", Cmd: "StartService", Data: []byte(`{"x":1}`), RelayId: "2"},
+		&agentpb.Reply{Id: 1, Cmd: "StartService", Error: "oops", Data: []byte(`{}`), RelayId: "2"},
+		&agentpb.LogEntry{Ts: 1416000000000000123, Level: 4, Service: "mm", Msg: "hi", Offline: true},
+		&agentpb.DataFile{Data: []byte{0x1f, 0x8b, 0, 1}},
+		&agentpb.Response{Code: 503, Error: "busy"},
+	}
+	empty := []agentpb.Message{
+		&agentpb.Cmd{},
+		&agentpb.Reply{},
+		&agentpb.LogEntry{},
+		&agentpb.DataFile{},
+		&agentpb.Response{},
+	}
+	for i, msg := range msgs {
+		data, err := msg.Marshal()
+		t.Assert(err, IsNil)
+		t.Check(empty[i].Unmarshal(data), IsNil)
+		t.Check(empty[i], DeepEquals, msg)
+	}
+}
+
+func (s *TestSuite) TestUnknownFields(t *C) {
+	data, err := (&agentpb.Response{Code: 200}).Marshal()
+	t.Assert(err, IsNil)
+
+	// Fields of newer messages are skipped: field 3 varint 150, field 4
+	// bytes "x", field 5 fixed32, field 6 fixed64.
+	unknown := []byte{0x18, 0x96, 0x01, 0x22, 0x01, 'x', 0x2d, 1, 2, 3, 4, 0x31, 1, 2, 3, 4, 5, 6, 7, 8}
+	resp := &agentpb.Response{}
+	t.Assert(resp.Unmarshal(append(unknown, data...)), IsNil)
+	t.Check(resp, DeepEquals, &agentpb.Response{Code: 200})
+
+	err = resp.Unmarshal([]byte{0x12, 0x05, 'a'})
+	t.Check(err, Equals, agentpb.ErrTruncated)
+	err = resp.Unmarshal([]byte{0x08})
+	t.Check(err, Equals, agentpb.ErrTruncated)
+}
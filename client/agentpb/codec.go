@@ -0,0 +1,52 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agentpb
+
+import (
+	"fmt"
+)
+
+// A Message is one of the messages in agent.proto.
+type Message interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+// Codec is the gRPC codec of the Agent service: it marshals Message in
+// protobuf wire format.
+type Codec struct{}
+
+func (Codec) Name() string {
+	return "proto" // so the content type is application/grpc+proto
+}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(Message)
+	if !ok {
+		return nil, fmt.Errorf("agentpb.Codec cannot marshal %T", v)
+	}
+	return m.Marshal()
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(Message)
+	if !ok {
+		return fmt.Errorf("agentpb.Codec cannot unmarshal %T", v)
+	}
+	return m.Unmarshal(data)
+}
@@ -0,0 +1,48 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package client
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Transports of agent.Config.Transport.
+const (
+	TRANSPORT_WEBSOCKET = "websocket" // default
+	TRANSPORT_GRPC      = "grpc"
+)
+
+// Client is a WebsocketClient or GRPCClient.
+type Client interface {
+	pct.WebsocketClient
+	SetCompression(enabled bool)
+	SetKeepalive(interval, timeout uint)
+}
+
+// NewClient returns a client for the API link using the transport.
+func NewClient(transport string, logger *pct.Logger, api pct.APIConnector, link string, headers map[string]string) (Client, error) {
+	switch transport {
+	case "", TRANSPORT_WEBSOCKET:
+		return NewWebsocketClient(logger, api, link, headers)
+	case TRANSPORT_GRPC:
+		return NewGRPCClient(logger, api, link, headers)
+	default:
+		return nil, fmt.Errorf("Invalid transport: %s (expected %s or %s)", transport, TRANSPORT_WEBSOCKET, TRANSPORT_GRPC)
+	}
+}
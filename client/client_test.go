@@ -18,6 +18,7 @@
 package client_test
 
 import (
+	"code.google.com/p/go.net/websocket"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/client"
 	"github.com/percona/percona-agent/pct"
@@ -26,6 +27,9 @@ import (
 	. "gopkg.in/check.v1"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -319,6 +323,48 @@ func (s *TestSuite) TestErrorChan(t *C) {
 	ws.Disconnect()
 }
 
+func (s *TestSuite) TestKeepalive(t *C) {
+	/**
+	 * With keepalive, client pings API and disconnects if API doesn't send
+	 * anything, e.g. because the connection is half-open.
+	 */
+
+	ws, err := client.NewWebsocketClient(s.logger, s.api, "agent", nil)
+	t.Assert(err, IsNil)
+	ws.SetKeepalive(1, 2)
+
+	ws.Start()
+	defer ws.Stop()
+
+	ws.Connect()
+	c := <-mock.ClientConnectChan
+	<-ws.ConnectChan()
+
+	// Client pings API every 1s.
+	select {
+	case data := <-c.RecvChan:
+		m := data.(map[string]interface{})
+		t.Check(m["Cmd"], Equals, "Ping")
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client pings API")
+	}
+
+	// API's Pong resets the read timeout but isn't forwarded to the agent.
+	c.SendChan <- &proto.Cmd{Cmd: "Pong"}
+	got := test.WaitCmd(ws.RecvChan())
+	t.Check(got, HasLen, 0)
+
+	// API stops responding, so client disconnects after 2s.
+	t0 := time.Now()
+	select {
+	case connected := <-ws.ConnectChan():
+		t.Check(connected, Equals, false)
+		t.Check(time.Now().Sub(t0) < 3*time.Second, Equals, true)
+	case <-time.After(5 * time.Second):
+		t.Error("Client disconnects")
+	}
+}
+
 func (s *TestSuite) TestConnectBackoff(t *C) {
 	/**
 	 * Connect() should wait between attempts, using pct.Backoff (pct/backoff.go).
@@ -531,3 +577,84 @@ func (s *TestSuite) TestCloseTimeout(t *C) {
 	err = ws.Disconnect()
 	t.Check(err, IsNil)
 }
+
+func (s *TestSuite) TestCompression(t *C) {
+	// Echo server that chooses gzip if offered, like the API.
+	gzipServer := httptest.NewServer(websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			for _, p := range config.Protocol {
+				if p == client.WS_PROTOCOL_GZIP {
+					config.Protocol = []string{p}
+					return nil
+				}
+			}
+			config.Protocol = nil
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			var data interface{}
+			for client.GzipJSON.Receive(ws, &data) == nil {
+				client.GzipJSON.Send(ws, data)
+			}
+		},
+	})
+	defer gzipServer.Close()
+
+	links := map[string]string{"agent": strings.Replace(gzipServer.URL, "http://", "ws://", 1)}
+	api := mock.NewAPI("http://localhost", gzipServer.Listener.Addr().String(), "apikey", "uuid", links)
+	ws, err := client.NewWebsocketClient(s.logger, api, "agent", nil)
+	t.Assert(err, IsNil)
+	ws.SetCompression(true)
+	t.Assert(ws.ConnectOnce(5), IsNil)
+	defer ws.DisconnectOnce()
+	t.Check(ws.Status()["ws"], Matches, "Connected .+ \\(gzip\\)")
+
+	t.Assert(ws.Send(&proto.LogEntry{Msg: "Hello"}, 5), IsNil)
+	got := &proto.LogEntry{}
+	t.Assert(ws.Recv(got, 5), IsNil)
+	t.Check(got.Msg, Equals, "Hello")
+
+	// go.net/websocket servers without a Handshake reject subprotocols,
+	// so the client connects again without compression.
+	plainServer := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var data interface{}
+		for websocket.JSON.Receive(ws, &data) == nil {
+			websocket.JSON.Send(ws, data)
+		}
+	}))
+	defer plainServer.Close()
+
+	links = map[string]string{"agent": strings.Replace(plainServer.URL, "http://", "ws://", 1)}
+	api = mock.NewAPI("http://localhost", plainServer.Listener.Addr().String(), "apikey", "uuid", links)
+	ws2, err := client.NewWebsocketClient(s.logger, api, "agent", nil)
+	t.Assert(err, IsNil)
+	ws2.SetCompression(true)
+	t.Assert(ws2.ConnectOnce(5), IsNil)
+	defer ws2.DisconnectOnce()
+	t.Check(ws2.Status()["ws"], Not(Matches), ".+\\(gzip\\)")
+
+	t.Assert(ws2.Send(&proto.LogEntry{Msg: "Hello"}, 5), IsNil)
+	got = &proto.LogEntry{}
+	t.Assert(ws2.Recv(got, 5), IsNil)
+	t.Check(got.Msg, Equals, "Hello")
+
+	// Errors that aren't a handshake rejection, e.g. the connection closing,
+	// don't make the client connect again without compression.
+	requests := make(chan bool, 2)
+	hangupServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- true
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer hangupServer.Close()
+
+	links = map[string]string{"agent": strings.Replace(hangupServer.URL, "http://", "ws://", 1)}
+	api = mock.NewAPI("http://localhost", hangupServer.Listener.Addr().String(), "apikey", "uuid", links)
+	ws3, err := client.NewWebsocketClient(s.logger, api, "agent", nil)
+	t.Assert(err, IsNil)
+	ws3.SetCompression(true)
+	t.Check(ws3.ConnectOnce(5), NotNil)
+	t.Check(requests, HasLen, 1)
+}
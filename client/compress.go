@@ -0,0 +1,69 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package client
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/websocket"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Websocket subprotocols offered when compression is enabled.  If the API
+// chooses WS_PROTOCOL_GZIP, JSON messages are sent with GzipJSON instead of
+// websocket.JSON.  SendBytes is not affected: data is already encoded (gzip
+// by default) by the data spooler.
+const (
+	WS_PROTOCOL      = "percona.v1"
+	WS_PROTOCOL_GZIP = "percona-gzip.v1"
+)
+
+// GzipJSON is a websocket.Codec for gzip-compressed JSON in binary frames.
+// Text frames are received as plain JSON.
+var GzipJSON = websocket.Codec{Marshal: gzipJSONMarshal, Unmarshal: gzipJSONUnmarshal}
+
+func gzipJSONMarshal(v interface{}) ([]byte, byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, websocket.BinaryFrame, err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, websocket.BinaryFrame, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, websocket.BinaryFrame, err
+	}
+	return buf.Bytes(), websocket.BinaryFrame, nil
+}
+
+func gzipJSONUnmarshal(msg []byte, payloadType byte, v interface{}) error {
+	if payloadType == websocket.BinaryFrame {
+		r, err := gzip.NewReader(bytes.NewReader(msg))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if msg, err = ioutil.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(msg, v)
+}
@@ -0,0 +1,621 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package client
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"context"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/client/agentpb"
+	"github.com/percona/percona-agent/pct"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the "gzip" compressor
+	"google.golang.org/grpc/metadata"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GRPC_SERVICE is the gRPC service of the API, see agentpb/agent.proto.  Each
+// agent link (cmd, log, data) is a bidirectional stream method of it, e.g. the
+// cmd link is /percona.agent.v1.Agent/Cmd.
+const GRPC_SERVICE = "percona.agent.v1.Agent"
+
+var grpcStreamDesc = &grpc.StreamDesc{
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// GRPCClient is a pct.WebsocketClient that sends and receives the same
+// messages as WebsocketClient but as protobuf messages (agentpb) on a gRPC
+// stream, for networks (load balancers, service meshes) that handle gRPC
+// better than websockets.  The API link is still a ws:// or wss:// URL: its
+// host is the gRPC server and wss:// means TLS.
+type GRPCClient struct {
+	logger  *pct.Logger
+	api     pct.APIConnector
+	link    string
+	headers map[string]string
+	// --
+	conn      *grpc.ClientConn
+	stream    grpc.ClientStream
+	cancel    context.CancelFunc // cancels stream
+	connected bool
+	mux       *sync.Mutex // guard conn, stream, cancel, and connected
+	// --
+	compression  bool
+	pingInterval uint
+	readTimeout  uint
+	// --
+	started     bool
+	recvChan    chan *proto.Cmd
+	sendChan    chan *proto.Reply
+	connectChan chan bool
+	errChan     chan error
+	backoff     *pct.Backoff
+	sendSync    *pct.SyncChan
+	recvSync    *pct.SyncChan
+	status      *pct.Status
+	name        string
+}
+
+func NewGRPCClient(logger *pct.Logger, api pct.APIConnector, link string, headers map[string]string) (*GRPCClient, error) {
+	name := logger.Service()
+	c := &GRPCClient{
+		logger:  logger,
+		api:     api,
+		link:    link,
+		headers: headers,
+		// --
+		mux: new(sync.Mutex),
+		// --
+		recvChan:    make(chan *proto.Cmd, RECV_BUFFER_SIZE),
+		sendChan:    make(chan *proto.Reply, SEND_BUFFER_SIZE),
+		connectChan: make(chan bool, 1),
+		errChan:     make(chan error, 2),
+		backoff:     pct.NewBackoff(5 * time.Minute),
+		sendSync:    pct.NewSyncChan(),
+		recvSync:    pct.NewSyncChan(),
+		status:      pct.NewStatus([]string{name, name + "-link"}),
+		name:        name,
+	}
+	return c, nil
+}
+
+// SetCompression makes the client gzip messages with the gRPC gzip compressor.
+func (c *GRPCClient) SetCompression(enabled bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.compression = enabled
+}
+
+// SetKeepalive is like WebsocketClient.SetKeepalive: Ping every interval seconds
+// and reconnect if nothing is received in timeout seconds (default 3 * interval).
+func (c *GRPCClient) SetKeepalive(interval, timeout uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if interval > 0 && timeout == 0 {
+		timeout = 3 * interval
+	}
+	c.pingInterval = interval
+	c.readTimeout = timeout
+}
+
+func (c *GRPCClient) keepalive() (interval, timeout uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.pingInterval, c.readTimeout
+}
+
+func (c *GRPCClient) Start() {
+	// Start send() and recv() goroutines, but they wait for successful Connect().
+	if !c.started {
+		c.started = true
+		go c.send()
+		go c.recv()
+	}
+}
+
+func (c *GRPCClient) Stop() {
+	if c.started {
+		c.sendSync.Stop()
+		c.recvSync.Stop()
+		c.sendSync.Wait()
+		c.recvSync.Wait()
+		c.started = false
+	}
+}
+
+func (c *GRPCClient) Connect() {
+	c.logger.Debug("Connect:call")
+	defer c.logger.Debug("Connect:return")
+
+	for {
+		// Wait before attempt to avoid DDoS'ing the API.
+		c.logger.Debug("Connect:backoff.Wait")
+		c.status.Update(c.name, "Connect wait")
+		time.Sleep(c.backoff.Wait())
+
+		if err := c.ConnectOnce(10); err != nil {
+			c.logger.Warn(err)
+			if changed, err := c.api.Failover(); err != nil {
+				c.logger.Warn("API failover:", err)
+			} else if changed {
+				c.logger.Warn("Failed over to API", c.api.Hostname())
+			}
+			continue
+		}
+		c.backoff.Success()
+
+		// Start/resume send() and recv() goroutines if Start() was called.
+		if c.started {
+			c.recvSync.Start()
+			c.sendSync.Start()
+		}
+
+		c.notifyConnect(true)
+		return // success
+	}
+}
+
+func (c *GRPCClient) ConnectOnce(timeout uint) error {
+	c.logger.Debug("ConnectOnce:call")
+	defer c.logger.Debug("ConnectOnce:return")
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	link := c.api.AgentLink(c.link)
+	c.logger.Debug("ConnectOnce:link:" + link)
+	location, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+
+	opts, err := c.dialOptions(location, timeout)
+	if err != nil {
+		return err
+	}
+
+	c.status.Update(c.name, "Connecting "+link)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, wsAddr(location), opts...)
+	if err != nil {
+		return fmt.Errorf("Cannot connect to %s: %s", link, err)
+	}
+
+	// The API key, headers, and link (which has the agent UUID) are sent as
+	// stream metadata like they're sent as websocket handshake headers.
+	md := metadata.Pairs("x-percona-api-key", c.api.ApiKey(), "x-percona-agent-link", link)
+	for k, v := range c.headers {
+		md.Append(strings.ToLower(k), v)
+	}
+	streamCtx, streamCancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), md))
+	callOpts := []grpc.CallOption{grpc.ForceCodec(agentpb.Codec{})}
+	if c.compression {
+		callOpts = append(callOpts, grpc.UseCompressor("gzip"))
+	}
+	stream, err := conn.NewStream(streamCtx, grpcStreamDesc, grpcMethod(c.link), callOpts...)
+	if err != nil {
+		streamCancel()
+		conn.Close()
+		return fmt.Errorf("Cannot open %s stream: %s", grpcMethod(c.link), err)
+	}
+
+	c.conn = conn
+	c.stream = stream
+	c.cancel = streamCancel
+	c.connected = true
+	c.status.Update(c.name, "Connected "+link+" (gRPC)")
+
+	return nil
+}
+
+func (c *GRPCClient) dialOptions(location *url.URL, timeout uint) ([]grpc.DialOption, error) {
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	if location.Scheme == "wss" {
+		tlsConfig := pct.TLSConfig() // CA file, min version, pins
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	// Use the same proxy as API requests, if any, by tunneling through it.
+	proxyURL, err := wsProxy(location)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			c.logger.Debug("ConnectOnce:proxy:" + proxyURL.Host)
+			return pct.DialProxy(proxyURL, addr, time.Duration(timeout)*time.Second)
+		}))
+	}
+	return opts, nil
+}
+
+// grpcMethod returns the stream method of the link, e.g. /percona.agent.v1.Agent/Cmd.
+func grpcMethod(link string) string {
+	if link == "" {
+		return "/" + GRPC_SERVICE + "/"
+	}
+	return "/" + GRPC_SERVICE + "/" + strings.ToUpper(link[:1]) + link[1:]
+}
+
+func (c *GRPCClient) Disconnect() error {
+	c.logger.DebugOffline("Disconnect:call")
+	defer c.logger.DebugOffline("Disconnect:return")
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.connected {
+		return nil
+	}
+
+	err := c.disconnect()
+	c.notifyConnect(false)
+	return err
+}
+
+func (c *GRPCClient) DisconnectOnce() error {
+	c.logger.DebugOffline("DisconnectOnce:call")
+	defer c.logger.DebugOffline("DisconnectOnce:return")
+
+	// Guard c.conn like WebsocketClient.DisconnectOnce to prevent duplicate
+	// notifyConnect() when recv() errors because the stream was closed here.
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.connected {
+		return nil
+	}
+
+	return c.disconnect()
+}
+
+func (c *GRPCClient) disconnect() error {
+	c.logger.DebugOffline("disconnect:call")
+	defer c.logger.DebugOffline("disconnect:return")
+
+	// Half-close the stream so the API sees a clean end, then cancel it to
+	// unblock Send and Recv.  Like WebsocketClient, c.stream isn't set nil:
+	// Send and Recv on a canceled stream return an error, not panic.
+	c.stream.CloseSend()
+	c.cancel()
+	err := c.conn.Close()
+	if err != nil {
+		c.logger.DebugOffline("disconnect:grpc.ClientConn.Close:err:" + err.Error())
+	}
+	c.connected = false
+
+	c.logger.DebugOffline("disconnected")
+	c.status.Update(c.name, "Disconnected")
+	return err
+}
+
+func (c *GRPCClient) send() {
+	/**
+	 * Send Reply from agent to API.
+	 */
+
+	c.logger.DebugOffline("send:call")
+	defer c.logger.DebugOffline("send:return")
+	defer c.sendSync.Done()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("ERROR: GRPCClient.send crashed: %s\n", err)
+		}
+	}()
+
+	for {
+		// Wait to start (connect) or be told to stop.
+		c.logger.DebugOffline("send:wait:start")
+		select {
+		case <-c.sendSync.StartChan:
+			c.sendSync.StartChan <- true
+		case <-c.sendSync.StopChan:
+			return
+		}
+
+		var pingTicker *time.Ticker
+		var pingChan <-chan time.Time
+		if interval, _ := c.keepalive(); interval > 0 {
+			pingTicker = time.NewTicker(time.Duration(interval) * time.Second)
+			pingChan = pingTicker.C
+		}
+
+	SEND_LOOP:
+		for {
+			c.logger.DebugOffline("send:idle")
+			var err error
+			select {
+			case reply := <-c.sendChan:
+				c.logger.DebugOffline("send:reply:", reply)
+				err = c.Send(reply, SEND_TIMEOUT)
+			case <-pingChan:
+				c.logger.DebugOffline("send:ping")
+				err = c.Send(&proto.Reply{Cmd: "Ping"}, SEND_TIMEOUT)
+			case <-c.sendSync.StopChan:
+				c.logger.DebugOffline("send:stop")
+				if pingTicker != nil {
+					pingTicker.Stop()
+				}
+				return
+			}
+			if err != nil {
+				c.logger.DebugOffline("send:err:", err)
+				select {
+				case c.errChan <- err:
+				default:
+				}
+				break SEND_LOOP
+			}
+		}
+		if pingTicker != nil {
+			pingTicker.Stop()
+		}
+
+		c.logger.DebugOffline("send:Disconnect")
+		c.Disconnect()
+	}
+}
+
+func (c *GRPCClient) recv() {
+	/**
+	 * Receive Cmd from API, forward to agent.
+	 */
+
+	c.logger.DebugOffline("recv:call")
+	defer c.logger.DebugOffline("recv:return")
+	defer c.recvSync.Done()
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("ERROR: GRPCClient.recv crashed: %s\n", err)
+		}
+	}()
+
+	for {
+		// Wait to start (connect) or be told to stop.
+		c.logger.DebugOffline("recv:wait:start")
+		select {
+		case <-c.recvSync.StartChan:
+			c.recvSync.StartChan <- true
+		case <-c.recvSync.StopChan:
+			return
+		}
+
+	RECV_LOOP:
+		for {
+			// Before blocking on Recv, see if we're supposed to stop.
+			select {
+			case <-c.recvSync.StopChan:
+				c.logger.DebugOffline("recv:stop")
+				return
+			default:
+			}
+
+			// Wait for Cmd from API.
+			_, timeout := c.keepalive()
+			cmd := &proto.Cmd{}
+			if err := c.Recv(cmd, timeout); err != nil {
+				c.logger.DebugOffline("recv:err:", err)
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					c.logger.Warn(fmt.Sprintf("No message from API in %ds, reconnecting", timeout))
+				}
+				select {
+				case c.errChan <- err:
+				default:
+				}
+				break RECV_LOOP
+			}
+
+			if cmd.Cmd == "Pong" && cmd.Service == "" {
+				continue // reply to send:ping
+			}
+
+			// Forward Cmd to agent.
+			c.logger.DebugOffline("recv:cmd:", cmd)
+			c.recvChan <- cmd
+		}
+
+		c.logger.DebugOffline("recv:Disconnect")
+		c.Disconnect()
+	}
+}
+
+func (c *GRPCClient) SendChan() chan *proto.Reply {
+	return c.sendChan
+}
+
+func (c *GRPCClient) RecvChan() chan *proto.Cmd {
+	return c.recvChan
+}
+
+// Send sends a proto.Reply on the cmd link or a proto.LogEntry on the log
+// link.  Other types aren't in agent.proto, so they can't be sent.
+func (c *GRPCClient) Send(data interface{}, timeout uint) error {
+	var msg agentpb.Message
+	switch v := data.(type) {
+	case *proto.Reply:
+		msg = ReplyMessage(v)
+	case *proto.LogEntry:
+		msg = LogEntryMessage(v)
+	default:
+		return fmt.Errorf("Cannot send %T over gRPC", data)
+	}
+	return c.sendMsg(msg, timeout)
+}
+
+// SendBytes sends a spooled data file on the data link.
+func (c *GRPCClient) SendBytes(data []byte, timeout uint) error {
+	return c.sendMsg(&agentpb.DataFile{Data: data}, timeout)
+}
+
+func (c *GRPCClient) sendMsg(msg agentpb.Message, timeout uint) error {
+	c.logger.DebugOffline("sendMsg:call")
+	defer c.logger.DebugOffline("sendMsg:return")
+	return c.deadline(timeout, func() error {
+		return c.stream.SendMsg(msg)
+	})
+}
+
+// Recv receives a proto.Cmd on the cmd link or a proto.Response on the log
+// and data links.
+func (c *GRPCClient) Recv(data interface{}, timeout uint) error {
+	c.logger.DebugOffline("Recv:call")
+	defer c.logger.DebugOffline("Recv:return")
+	switch v := data.(type) {
+	case *proto.Cmd:
+		msg := &agentpb.Cmd{}
+		if err := c.recvMsg(msg, timeout); err != nil {
+			return err
+		}
+		*v = *CmdFromMessage(msg)
+	case *proto.Response:
+		msg := &agentpb.Response{}
+		if err := c.recvMsg(msg, timeout); err != nil {
+			return err
+		}
+		*v = proto.Response{Code: uint(msg.Code), Error: msg.Error}
+	default:
+		return fmt.Errorf("Cannot receive %T over gRPC", data)
+	}
+	return nil
+}
+
+func (c *GRPCClient) recvMsg(msg agentpb.Message, timeout uint) error {
+	return c.deadline(timeout, func() error {
+		return c.stream.RecvMsg(msg)
+	})
+}
+
+// deadline runs f, a stream Send or Recv, and cancels the stream if it takes
+// longer than timeout seconds (0 waits forever), which is what a websocket
+// write or read deadline does.  The error is a net.Error so callers can tell
+// it's a timeout.
+func (c *GRPCClient) deadline(timeout uint, f func() error) error {
+	if timeout == 0 {
+		return f()
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- f()
+	}()
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case err := <-errChan:
+		return err
+	case <-timer.C:
+		c.cancel()
+		return timeoutError{fmt.Sprintf("gRPC stream timeout after %ds", timeout)}
+	}
+}
+
+type timeoutError struct {
+	msg string
+}
+
+func (e timeoutError) Error() string   { return e.msg }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func (c *GRPCClient) ConnectChan() chan bool {
+	return c.connectChan
+}
+
+func (c *GRPCClient) ErrorChan() chan error {
+	return c.errChan
+}
+
+// Conn returns nil: there's no websocket connection.
+func (c *GRPCClient) Conn() *websocket.Conn {
+	return nil
+}
+
+func (c *GRPCClient) Status() map[string]string {
+	c.status.Update(c.name+"-link", c.api.AgentLink(c.link))
+	return c.status.All()
+}
+
+func (c *GRPCClient) notifyConnect(state bool) {
+	c.logger.DebugOffline(fmt.Sprintf("notifyConnect:call:%t", state))
+	defer c.logger.DebugOffline("notifyConnect:return")
+	select {
+	case c.connectChan <- state:
+	case <-time.After(20 * time.Second):
+		c.logger.Error("notifyConnect timeout")
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// agentpb messages
+/////////////////////////////////////////////////////////////////////////////
+
+// ReplyMessage returns the agentpb message of the reply.
+func ReplyMessage(reply *proto.Reply) *agentpb.Reply {
+	return &agentpb.Reply{
+		Id:      uint64(reply.Id),
+		Cmd:     reply.Cmd,
+		Error:   reply.Error,
+		Data:    reply.Data,
+		RelayId: reply.RelayId,
+	}
+}
+
+// LogEntryMessage returns the agentpb message of the log entry.
+func LogEntryMessage(entry *proto.LogEntry) *agentpb.LogEntry {
+	return &agentpb.LogEntry{
+		Ts:      unixNano(entry.Ts),
+		Level:   uint32(entry.Level),
+		Service: entry.Service,
+		Msg:     entry.Msg,
+		Offline: entry.Offline,
+	}
+}
+
+// CmdFromMessage returns the proto.Cmd of the agentpb message.
+func CmdFromMessage(msg *agentpb.Cmd) *proto.Cmd {
+	cmd := &proto.Cmd{
+		Id:        uint(msg.Id),
+		User:      msg.User,
+		AgentUuid: msg.AgentUuid,
+		Service:   msg.Service,
+		Cmd:       msg.Cmd,
+		Data:      msg.Data,
+		RelayId:   msg.RelayId,
+	}
+	if msg.Ts != 0 {
+		cmd.Ts = time.Unix(0, msg.Ts).UTC()
+	}
+	return cmd
+}
+
+// unixNano returns 0 for the zero time, which is not sent, like JSON omits
+// empty values.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
@@ -0,0 +1,154 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package client_test
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/client"
+	"github.com/percona/percona-agent/client/agentpb"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	. "gopkg.in/check.v1"
+	"net"
+	"time"
+)
+
+type GRPCTestSuite struct {
+	logChan  chan *proto.LogEntry
+	logger   *pct.Logger
+	listener net.Listener
+	server   *grpc.Server
+	api      *mock.API
+	// --
+	method  chan string
+	apiKey  chan string
+	recvd   chan *agentpb.Reply
+	sendCmd *proto.Cmd
+}
+
+var _ = Suite(&GRPCTestSuite{})
+
+func (s *GRPCTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "grpc")
+	s.method = make(chan string, 1)
+	s.apiKey = make(chan string, 1)
+	s.recvd = make(chan *agentpb.Reply, 1)
+	s.sendCmd = &proto.Cmd{Id: 3, Ts: time.Unix(1416000000, 0).UTC(), Service: "agent", Cmd: "Status", Data: []byte(`{"x":1}`)}
+
+	var err error
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	t.Assert(err, IsNil)
+
+	// Echo server: receive one Reply, send the Cmd, then wait for client to hang up.
+	s.server = grpc.NewServer(
+		grpc.CustomCodec(agentpb.Codec{}),
+		grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			s.method <- method
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			s.apiKey <- md.Get("x-percona-api-key")[0]
+			reply := &agentpb.Reply{}
+			if err := stream.RecvMsg(reply); err != nil {
+				return err
+			}
+			s.recvd <- reply
+			cmd := &agentpb.Cmd{
+				Id:      uint64(s.sendCmd.Id),
+				Ts:      s.sendCmd.Ts.UnixNano(),
+				Service: s.sendCmd.Service,
+				Cmd:     s.sendCmd.Cmd,
+				Data:    s.sendCmd.Data,
+			}
+			if err := stream.SendMsg(cmd); err != nil {
+				return err
+			}
+			return stream.RecvMsg(reply)
+		}),
+	)
+	go s.server.Serve(s.listener)
+
+	links := map[string]string{"cmd": "ws://" + s.listener.Addr().String() + "/agents/uuid/cmd"}
+	s.api = mock.NewAPI("http://localhost", s.listener.Addr().String(), "apikey", "uuid", links)
+}
+
+func (s *GRPCTestSuite) TearDownSuite(t *C) {
+	s.server.Stop()
+}
+
+// --------------------------------------------------------------------------
+
+func (s *GRPCTestSuite) TestMessages(t *C) {
+	ts := time.Unix(1416000000, 123).UTC()
+	msg := client.LogEntryMessage(&proto.LogEntry{Ts: ts, Level: proto.LOG_WARNING, Service: "mm", Msg: "hi", Offline: true})
+	t.Check(msg, DeepEquals, &agentpb.LogEntry{Ts: ts.UnixNano(), Level: uint32(proto.LOG_WARNING), Service: "mm", Msg: "hi", Offline: true})
+
+	// Zero time is not sent, and not received as 1970.
+	msg = client.LogEntryMessage(&proto.LogEntry{Msg: "hi"})
+	t.Check(msg.Ts, Equals, int64(0))
+	cmd := client.CmdFromMessage(&agentpb.Cmd{Cmd: "Status"})
+	t.Check(cmd.Ts.IsZero(), Equals, true)
+
+	cmd = client.CmdFromMessage(&agentpb.Cmd{Id: 9, Ts: ts.UnixNano(), User: "u", AgentUuid: "uuid", Service: "qan", Cmd: "StartService", Data: []byte("{}"), RelayId: "1"})
+	t.Check(cmd, DeepEquals, &proto.Cmd{Id: 9, Ts: ts, User: "u", AgentUuid: "uuid", Service: "qan", Cmd: "StartService", Data: []byte("{}"), RelayId: "1"})
+
+	reply := client.ReplyMessage(&proto.Reply{Id: 9, Cmd: "StartService", Error: "oops", RelayId: "1"})
+	t.Check(reply, DeepEquals, &agentpb.Reply{Id: 9, Cmd: "StartService", Error: "oops", RelayId: "1"})
+}
+
+func (s *GRPCTestSuite) TestNewClient(t *C) {
+	c, err := client.NewClient("", s.logger, s.api, "cmd", nil)
+	t.Assert(err, IsNil)
+	_, ok := c.(*client.WebsocketClient)
+	t.Check(ok, Equals, true)
+
+	c, err = client.NewClient("grpc", s.logger, s.api, "cmd", nil)
+	t.Assert(err, IsNil)
+	_, ok = c.(*client.GRPCClient)
+	t.Check(ok, Equals, true)
+
+	_, err = client.NewClient("carrier-pigeon", s.logger, s.api, "cmd", nil)
+	t.Check(err, NotNil)
+}
+
+func (s *GRPCTestSuite) TestSendRecv(t *C) {
+	c, err := client.NewGRPCClient(s.logger, s.api, "cmd", nil)
+	t.Assert(err, IsNil)
+	err = c.ConnectOnce(5)
+	t.Assert(err, IsNil)
+	defer c.DisconnectOnce()
+
+	reply := &proto.Reply{Id: 1, Cmd: "Ping"}
+	err = c.Send(reply, 5)
+	t.Assert(err, IsNil)
+
+	t.Check(<-s.method, Equals, "/percona.agent.v1.Agent/Cmd")
+	t.Check(<-s.apiKey, Equals, "apikey")
+	t.Check(<-s.recvd, DeepEquals, &agentpb.Reply{Id: 1, Cmd: "Ping"})
+
+	cmd := &proto.Cmd{}
+	err = c.Recv(cmd, 5)
+	t.Assert(err, IsNil)
+	t.Check(cmd, DeepEquals, s.sendCmd)
+
+	// Only messages in agent.proto can be sent.
+	err = c.Send(map[string]string{"Cmd": "Ping"}, 5)
+	t.Check(err, NotNil)
+}
@@ -25,6 +25,8 @@ import (
 	"github.com/percona/percona-agent/pct"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
 	"sync"
 	"time"
 )
@@ -32,6 +34,7 @@ import (
 const (
 	SEND_BUFFER_SIZE = 10
 	RECV_BUFFER_SIZE = 10
+	SEND_TIMEOUT     = 10 // seconds to write a Reply or Ping
 )
 
 type WebsocketClient struct {
@@ -44,6 +47,13 @@ type WebsocketClient struct {
 	connected bool
 	mux       *sync.Mutex // guard conn and connected
 	// --
+	compression   bool // offer WS_PROTOCOL_GZIP
+	noCompression bool // API rejected subprotocols, don't offer again
+	compressed    bool // conn uses GzipJSON
+	// --
+	pingInterval uint // seconds between Ping, 0 disables
+	readTimeout  uint // seconds without a message from API before reconnecting
+	// --
 	started     bool
 	recvChan    chan *proto.Cmd
 	sendChan    chan *proto.Reply
@@ -80,6 +90,35 @@ func NewWebsocketClient(logger *pct.Logger, api pct.APIConnector, link string, h
 	return c, nil
 }
 
+// SetCompression makes the client offer to gzip JSON messages when it connects.
+// It's only used if the API chooses the WS_PROTOCOL_GZIP subprotocol.
+func (c *WebsocketClient) SetCompression(enabled bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.compression = enabled
+}
+
+// SetKeepalive makes send() send a Ping to the API every interval seconds and
+// recv() reconnect if nothing, not even the API's Pong, is received in timeout
+// seconds (default 3 * interval).  Without it, a half-open connection (e.g. a
+// NAT or firewall dropped it) looks connected until TCP gives up, which can
+// take many minutes.  Call it before Start; it takes effect on next connect.
+func (c *WebsocketClient) SetKeepalive(interval, timeout uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if interval > 0 && timeout == 0 {
+		timeout = 3 * interval
+	}
+	c.pingInterval = interval
+	c.readTimeout = timeout
+}
+
+func (c *WebsocketClient) keepalive() (interval, timeout uint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.pingInterval, c.readTimeout
+}
+
 func (c *WebsocketClient) Start() {
 	// Start send() and recv() goroutines, but they wait for successful Connect().
 	if !c.started {
@@ -112,6 +151,12 @@ func (c *WebsocketClient) Connect() {
 
 		if err := c.ConnectOnce(10); err != nil {
 			c.logger.Warn(err)
+			// The API might be down: fail over to another API hostname, if any.
+			if changed, err := c.api.Failover(); err != nil {
+				c.logger.Warn("API failover:", err)
+			} else if changed {
+				c.logger.Warn("Failed over to API", c.api.Hostname())
+			}
 			continue
 		}
 		c.backoff.Success()
@@ -149,15 +194,37 @@ func (c *WebsocketClient) ConnectOnce(timeout uint) error {
 		}
 	}
 
+	if c.compression && !c.noCompression {
+		config.Protocol = []string{WS_PROTOCOL_GZIP, WS_PROTOCOL}
+	}
+
 	c.status.Update(c.name, "Connecting "+link)
 	conn, err := c.dialTimeout(config, timeout)
+	if err != nil && len(config.Protocol) > 0 && subprotocolRejected(err) {
+		// The API might not support subprotocols, e.g. go.net/websocket servers
+		// reject more than one unless their handshake chooses one.  Other
+		// errors, e.g. network errors, don't mean that, so compression stays
+		// on for the next connection.
+		config.Protocol = nil
+		if conn, err = c.dialTimeout(config, timeout); err == nil {
+			c.logger.Warn("API does not support compression, connected without it")
+			c.noCompression = true
+		}
+	}
 	if err != nil {
 		return err
 	}
 
+	// If the API doesn't choose a subprotocol, both offered remain.
+	c.compressed = len(config.Protocol) == 1 && config.Protocol[0] == WS_PROTOCOL_GZIP
+
 	c.conn = conn
 	c.connected = true
-	c.status.Update(c.name, "Connected "+link)
+	if c.compressed {
+		c.status.Update(c.name, "Connected "+link+" (gzip)")
+	} else {
+		c.status.Update(c.name, "Connected "+link)
+	}
 
 	return nil
 }
@@ -176,23 +243,50 @@ func (c *WebsocketClient) dialTimeout(config *websocket.Config, timeout uint) (w
 		return nil, websocket.ErrBadWebSocketOrigin
 	}
 
+	// Use the same proxy as API requests, if any, by tunneling through it.
+	proxyURL, err := wsProxy(config.Location)
+	if err != nil {
+		return nil, &websocket.DialError{config, err}
+	}
+
 	var conn net.Conn
 	switch config.Location.Scheme {
 	case "ws":
-		conn, err = net.DialTimeout("tcp", config.Location.Host, time.Duration(timeout)*time.Second)
+		conn, err = c.dialTCP(proxyURL, wsAddr(config.Location), timeout)
 	case "wss":
-		dialer := &net.Dialer{
-			Timeout: time.Duration(timeout) * time.Second,
+		if config.TlsConfig == nil {
+			config.TlsConfig = pct.TLSConfig() // CA file, min version, pins
 		}
 		if config.Location.Host == "localhost:8443" {
 			// Test uses mock ws server which uses self-signed cert which causes Go to throw
 			// an error like "x509: certificate signed by unknown authority".  This disables
 			// the cert verification for testing.
-			config.TlsConfig = &tls.Config{
-				InsecureSkipVerify: true,
+			config.TlsConfig.InsecureSkipVerify = true
+		}
+		if proxyURL == nil {
+			dialer := &net.Dialer{
+				Timeout: time.Duration(timeout) * time.Second,
 			}
+			conn, err = tls.DialWithDialer(dialer, "tcp", config.Location.Host, config.TlsConfig)
+			break
+		}
+		conn, err = c.dialTCP(proxyURL, wsAddr(config.Location), timeout)
+		if err != nil {
+			break
+		}
+		tlsConfig := &tls.Config{}
+		if config.TlsConfig != nil {
+			tlsConfig = config.TlsConfig.Clone()
 		}
-		conn, err = tls.DialWithDialer(dialer, "tcp", config.Location.Host, config.TlsConfig)
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(wsAddr(config.Location))
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			break
+		}
+		conn = tlsConn
 	default:
 		err = websocket.ErrBadScheme
 	}
@@ -202,12 +296,51 @@ func (c *WebsocketClient) dialTimeout(config *websocket.Config, timeout uint) (w
 
 	ws, err = websocket.NewClient(config, conn)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
 	return ws, nil
 }
 
+// subprotocolRejected returns true if the API rejected the websocket handshake
+// like it does if it doesn't support the subprotocols offered: a bad status,
+// e.g. 400 from go.net/websocket servers, or a subprotocol not offered.
+func subprotocolRejected(err error) bool {
+	return err == websocket.ErrBadStatus || err == websocket.ErrBadWebSocketProtocol
+}
+
+func (c *WebsocketClient) dialTCP(proxyURL *url.URL, addr string, timeout uint) (net.Conn, error) {
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", addr, time.Duration(timeout)*time.Second)
+	}
+	c.logger.Debug("ConnectOnce:proxy:" + proxyURL.Host)
+	return pct.DialProxy(proxyURL, addr, time.Duration(timeout)*time.Second)
+}
+
+// wsProxy returns the proxy for the ws:// or wss:// location, which is the
+// proxy for the equivalent http:// or https:// URL.
+func wsProxy(location *url.URL) (*url.URL, error) {
+	u := *location
+	if u.Scheme == "wss" {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
+	return pct.Proxy(&http.Request{URL: &u})
+}
+
+// wsAddr returns the host:port of the ws:// or wss:// location.
+func wsAddr(location *url.URL) string {
+	if _, _, err := net.SplitHostPort(location.Host); err == nil {
+		return location.Host
+	}
+	if location.Scheme == "wss" {
+		return location.Host + ":443"
+	}
+	return location.Host + ":80"
+}
+
 func (c *WebsocketClient) Disconnect() error {
 	c.logger.DebugOffline("Disconnect:call")
 	defer c.logger.DebugOffline("Disconnect:return")
@@ -303,25 +436,45 @@ func (c *WebsocketClient) send() {
 			return
 		}
 
+		var pingTicker *time.Ticker
+		var pingChan <-chan time.Time
+		if interval, _ := c.keepalive(); interval > 0 {
+			pingTicker = time.NewTicker(time.Duration(interval) * time.Second)
+			pingChan = pingTicker.C
+		}
+
 	SEND_LOOP:
 		for {
 			c.logger.DebugOffline("send:idle")
+			var err error
 			select {
 			case reply := <-c.sendChan:
 				// Got Reply from agent, send to API.
 				c.logger.DebugOffline("send:reply:", reply)
-				if err := c.Send(reply, 10); err != nil {
-					c.logger.DebugOffline("send:err:", err)
-					select {
-					case c.errChan <- err:
-					default:
-					}
-					break SEND_LOOP
-				}
+				err = c.Send(reply, SEND_TIMEOUT)
+			case <-pingChan:
+				// API replies with Pong, which recv() drops; it only needs
+				// to receive something before its read timeout.
+				c.logger.DebugOffline("send:ping")
+				err = c.Send(&proto.Reply{Cmd: "Ping"}, SEND_TIMEOUT)
 			case <-c.sendSync.StopChan:
 				c.logger.DebugOffline("send:stop")
+				if pingTicker != nil {
+					pingTicker.Stop()
+				}
 				return
 			}
+			if err != nil {
+				c.logger.DebugOffline("send:err:", err)
+				select {
+				case c.errChan <- err:
+				default:
+				}
+				break SEND_LOOP
+			}
+		}
+		if pingTicker != nil {
+			pingTicker.Stop()
 		}
 
 		c.logger.DebugOffline("send:Disconnect")
@@ -365,9 +518,13 @@ func (c *WebsocketClient) recv() {
 			}
 
 			// Wait for Cmd from API.
+			_, timeout := c.keepalive()
 			cmd := &proto.Cmd{}
-			if err := c.Recv(cmd, 0); err != nil {
+			if err := c.Recv(cmd, timeout); err != nil {
 				c.logger.DebugOffline("recv:err:", err)
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					c.logger.Warn(fmt.Sprintf("No message from API in %ds, reconnecting", timeout))
+				}
 				select {
 				case c.errChan <- err:
 				default:
@@ -375,6 +532,10 @@ func (c *WebsocketClient) recv() {
 				break RECV_LOOP
 			}
 
+			if cmd.Cmd == "Pong" && cmd.Service == "" {
+				continue // reply to send:ping
+			}
+
 			// Forward Cmd to agent.
 			c.logger.DebugOffline("recv:cmd:", cmd)
 			c.recvChan <- cmd
@@ -403,6 +564,9 @@ func (c *WebsocketClient) Send(data interface{}, timeout uint) error {
 	} else {
 		c.conn.SetWriteDeadline(time.Time{})
 	}
+	if c.compressed {
+		return GzipJSON.Send(c.conn, data)
+	}
 	return websocket.JSON.Send(c.conn, data)
 }
 
@@ -427,6 +591,9 @@ func (c *WebsocketClient) Recv(data interface{}, timeout uint) error {
 	} else {
 		c.conn.SetReadDeadline(time.Time{})
 	}
+	if c.compressed {
+		return GzipJSON.Receive(c.conn, data)
+	}
 	return websocket.JSON.Receive(c.conn, data)
 }
 
@@ -22,8 +22,19 @@ const (
 	DEFAULT_DATA_SEND_INTERVAL = 63
 )
 
+// Order in which the sender sends spooled data.  Oldest first keeps the data
+// in order; newest first is for when fresh data matters most, e.g. after the
+// agent was offline for a long time and the spool is large.
+const (
+	SEND_OLDEST_FIRST = "oldest-first"
+	SEND_NEWEST_FIRST = "newest-first"
+)
+
 type Config struct {
 	Encoding     string
 	SendInterval uint
 	Blackhole    bool
+	SendOrder    string // SEND_OLDEST_FIRST (default) or SEND_NEWEST_FIRST
+	Store        string // STORE_DISKV (default) or STORE_BOLT
+	Sign         bool   // sign spooled data with the agent's API key, see Sign
 }
@@ -173,6 +173,89 @@ func (s *DiskvSpoolerTestSuite) TestSpoolData(t *C) {
 	spool.Stop()
 }
 
+func (s *DiskvSpoolerTestSuite) TestSignData(t *C) {
+	key := []byte("api-key")
+	spool := data.NewDiskvSpooler(s.logger, s.dataDir, s.trashDir, "localhost")
+	spool.SetSigningKey(key)
+	if err := spool.Start(data.NewJsonSerializer()); err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Stop()
+
+	spool.Write("log", &proto.LogEntry{Ts: time.Now(), Level: 1, Service: "mm", Msg: "hello world"})
+	files := test.WaitFiles(s.dataDir, 1)
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d\n", len(files))
+	}
+	bytes, err := spool.Read(files[0].Name())
+	t.Assert(err, IsNil)
+
+	// Signed data is still proto.Data, plus the signature.
+	signed := &data.SignedData{Data: &proto.Data{}}
+	t.Assert(json.Unmarshal(bytes, signed), IsNil)
+	t.Check(signed.Service, Equals, "log")
+	t.Check(signed.Signature, Equals, data.Sign(key, signed.Data))
+	t.Check(signed.Signature, Matches, "hmac-sha256:[0-9a-f]{64}")
+
+	t.Check(data.Verify(key, bytes), IsNil)
+	t.Check(data.Verify([]byte("other-key"), bytes), Equals, data.ErrBadSignature)
+
+	// Changing the data or its metadata invalidates the signature.
+	signed.Hostname = "other-host"
+	tampered, _ := json.Marshal(signed)
+	t.Check(data.Verify(key, tampered), Equals, data.ErrBadSignature)
+
+	unsigned, _ := json.Marshal(signed.Data)
+	t.Check(data.Verify(key, unsigned), Equals, data.ErrNotSigned)
+
+	spool.Remove(files[0].Name())
+}
+
+func (s *DiskvSpoolerTestSuite) TestRewriteSpool(t *C) {
+	oldKey := []byte("old-key")
+	newKey := []byte("new-key")
+	spool := data.NewDiskvSpooler(s.logger, s.dataDir, s.trashDir, "localhost")
+	spool.SetSigningKey(oldKey)
+	if err := spool.Start(data.NewJsonGzipSerializer()); err != nil {
+		t.Fatal(err)
+	}
+	spool.Write("log", &proto.LogEntry{Ts: time.Now(), Level: 1, Service: "mm", Msg: "hello world"})
+	files := test.WaitFiles(s.dataDir, 1)
+	spool.Stop()
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d\n", len(files))
+	}
+
+	store := data.NewDiskvStore(s.dataDir)
+	n, err := data.RewriteSpool(store, newKey, func(service string, content []byte) ([]byte, error) {
+		t.Check(service, Equals, "log")
+		return bytes.Replace(content, []byte("hello world"), []byte("hello moon"), 1), nil
+	})
+	t.Assert(err, IsNil)
+	t.Check(n, Equals, 1)
+
+	// Data is still gzipped and signed, but with the new key.
+	val, err := store.Read(files[0].Name())
+	t.Assert(err, IsNil)
+	t.Check(data.Verify(newKey, val), IsNil)
+	signed := &data.SignedData{Data: &proto.Data{}}
+	t.Assert(json.Unmarshal(val, signed), IsNil)
+	t.Check(signed.ContentEncoding, Equals, "gzip")
+	r, err := gzip.NewReader(bytes.NewReader(signed.Data.Data))
+	t.Assert(err, IsNil)
+	logEntry := &proto.LogEntry{}
+	t.Assert(json.NewDecoder(r).Decode(logEntry), IsNil)
+	t.Check(logEntry.Msg, Equals, "hello moon")
+
+	// If rewrite fails, nothing is changed.
+	_, err = data.RewriteSpool(store, oldKey, func(service string, content []byte) ([]byte, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+	t.Check(err, NotNil)
+	val2, _ := store.Read(files[0].Name())
+	t.Check(val2, DeepEquals, val)
+}
+
 func (s *DiskvSpoolerTestSuite) TestSpoolGzipData(t *C) {
 	// Same as TestSpoolData, but use the gzip serializer.
 
@@ -376,6 +459,101 @@ func (s *DiskvSpoolerTestSuite) TestRejectData(t *C) {
 	spool.Stop()
 }
 
+func (s *DiskvSpoolerTestSuite) TestFilesOrder(t *C) {
+	// Names sort differently than their timestamps, and the filesystem can
+	// list them in any order, but Files() must return them in ts order.
+	names := []string{"qan_300", "mm_100", "log_200", "mm_20", "mm_1000"}
+	t.Assert(pct.MakeDir(s.dataDir), IsNil)
+	for _, name := range names {
+		err := ioutil.WriteFile(path.Join(s.dataDir, name), []byte("{}"), 0644)
+		t.Assert(err, IsNil)
+	}
+
+	spool := data.NewDiskvSpooler(s.logger, s.dataDir, s.trashDir, "localhost")
+	t.Assert(spool.Start(data.NewJsonSerializer()), IsNil)
+	defer spool.Stop()
+
+	gotFiles := []string{}
+	for file := range spool.Files() {
+		gotFiles = append(gotFiles, file)
+	}
+	t.Check(gotFiles, DeepEquals, []string{"mm_20", "mm_100", "log_200", "qan_300", "mm_1000"})
+
+	spool.SetOrder(data.SEND_NEWEST_FIRST)
+	gotFiles = []string{}
+	for file := range spool.Files() {
+		gotFiles = append(gotFiles, file)
+	}
+	t.Check(gotFiles, DeepEquals, []string{"mm_1000", "qan_300", "log_200", "mm_100", "mm_20"})
+}
+
+func (s *DiskvSpoolerTestSuite) TestBoltStore(t *C) {
+	boltFile := s.dataDir + ".db"
+	defer os.Remove(boltFile)
+
+	// Data spooled with diskv before changing the store to bolt.
+	t.Assert(pct.MakeDir(s.dataDir), IsNil)
+	err := ioutil.WriteFile(path.Join(s.dataDir, "mm_100"), []byte("{}"), 0644)
+	t.Assert(err, IsNil)
+
+	spool := data.NewDiskvSpooler(s.logger, s.dataDir, s.trashDir, "localhost")
+	spool.SetStore(data.STORE_BOLT)
+	t.Assert(spool.Start(data.NewJsonSerializer()), IsNil)
+
+	// The diskv file is moved to the bolt database.
+	files, _ := filepath.Glob(s.dataDir + "/*")
+	t.Check(files, HasLen, 0)
+	t.Check(pct.FileExists(boltFile), Equals, true)
+
+	logEntry := &proto.LogEntry{
+		Ts:      time.Now(),
+		Level:   1,
+		Service: "mm",
+		Msg:     "hello world",
+	}
+	t.Assert(spool.Write("log", logEntry), IsNil)
+	var gotFiles []string
+	for i := 0; i < 20; i++ {
+		gotFiles = []string{}
+		for file := range spool.Files() {
+			gotFiles = append(gotFiles, file)
+		}
+		if len(gotFiles) == 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Assert(gotFiles, HasLen, 2)
+	t.Check(gotFiles[0], Equals, "mm_100")
+
+	bytes, err := spool.Read(gotFiles[1])
+	t.Assert(err, IsNil)
+	protoData := &proto.Data{}
+	t.Assert(json.Unmarshal(bytes, protoData), IsNil)
+	t.Check(protoData.Service, Equals, "log")
+
+	// Rejected data is written to the trash dir like diskv files are moved to it.
+	t.Assert(spool.Reject("mm_100"), IsNil)
+	t.Check(pct.FileExists(path.Join(s.trashDir, "data", "mm_100")), Equals, true)
+	t.Assert(spool.Remove(gotFiles[1]), IsNil)
+	gotFiles = []string{}
+	for file := range spool.Files() {
+		gotFiles = append(gotFiles, file)
+	}
+	t.Check(gotFiles, HasLen, 0)
+
+	// Changing the store back to diskv moves data back to files.
+	t.Assert(spool.Write("log", logEntry), IsNil)
+	time.Sleep(200 * time.Millisecond)
+	spool.Stop()
+	spool = data.NewDiskvSpooler(s.logger, s.dataDir, s.trashDir, "localhost")
+	t.Assert(spool.Start(data.NewJsonSerializer()), IsNil)
+	defer spool.Stop()
+	files, _ = filepath.Glob(s.dataDir + "/*")
+	t.Check(files, HasLen, 1)
+	t.Check(pct.FileExists(boltFile), Equals, false)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Sender test suite
 /////////////////////////////////////////////////////////////////////////////
@@ -460,6 +638,34 @@ func (s *SenderTestSuite) TestSendData(t *C) {
 	t.Check(len(spool.RejectedFiles), Equals, 0)
 }
 
+func (s *SenderTestSuite) TestSendNow(t *C) {
+	spool := mock.NewSpooler(nil)
+	slow001, err := ioutil.ReadFile(sample + "slow001.json")
+	t.Assert(err, IsNil)
+	spool.FilesOut = []string{"slow001.json"}
+	spool.DataOut = map[string][]byte{"slow001.json": slow001}
+
+	sender := data.NewSender(s.logger, s.client)
+	t.Assert(sender.Start(spool, s.tickerChan, 5, false), IsNil)
+	defer sender.Stop()
+
+	// Can't send now while paused.
+	sender.Pause(true)
+	t.Check(sender.SendNow(), NotNil)
+	sender.Pause(false)
+
+	// Send without waiting for a tick.
+	t.Assert(sender.SendNow(), IsNil)
+	data := test.WaitBytes(s.dataChan)
+	t.Assert(data, HasLen, 1)
+	t.Check(data[0], DeepEquals, slow001)
+	select {
+	case s.respChan <- &proto.Response{Code: 200}:
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Sender receives prot.Response after sending data")
+	}
+}
+
 func (s *SenderTestSuite) TestBlackhole(t *C) {
 	spool := mock.NewSpooler(nil)
 
@@ -1003,3 +1209,56 @@ func (s *ManagerTestSuite) TestStatus(t *C) {
 	t.Check(status["data-spooler"], Equals, "Idle")
 	t.Check(status["data-sender"], Equals, "Idle")
 }
+
+func (s *ManagerTestSuite) TestReconnect(t *C) {
+	m := data.NewManager(s.logger, s.dataDir, s.trashDir, "localhost", s.client)
+	t.Assert(m, NotNil)
+	test.DrainTraceChan(s.client.TraceChan)
+
+	// Reconnect disconnects the sender, if it's sending, so it reconnects
+	// with the current agent links.
+	reply := m.Handle(&proto.Cmd{Service: "data", Cmd: "Reconnect"})
+	t.Check(reply.Error, Equals, "")
+	disconnected := false
+	for _, trace := range test.WaitTrace(s.client.TraceChan) {
+		if trace == "DisconnectOnce" {
+			disconnected = true
+		}
+	}
+	t.Check(disconnected, Equals, true)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// RecentSpooler test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type RecentSpoolerTestSuite struct{}
+
+var _ = Suite(&RecentSpoolerTestSuite{})
+
+func (s *RecentSpoolerTestSuite) TestRecent(t *C) {
+	spool := mock.NewSpooler(nil)
+	recent := data.NewRecentSpooler(spool, 2, "mm")
+
+	for _, v := range []string{"a", "b", "c"} {
+		t.Assert(recent.Write("mm", v), IsNil)
+	}
+	t.Assert(recent.Write("qan", "d"), IsNil)
+
+	// All data is spooled, but only the last 2 mm are kept.
+	t.Check(spool.DataIn, DeepEquals, []interface{}{"a", "b", "c", "d"})
+	got, ok := recent.Recent("mm", 0)
+	t.Assert(ok, Equals, true)
+	t.Assert(got, HasLen, 2)
+	t.Check(got[0].Data, Equals, "b")
+	t.Check(got[1].Data, Equals, "c")
+	t.Check(got[1].Ts.IsZero(), Equals, false)
+
+	got, ok = recent.Recent("mm", 1)
+	t.Assert(ok, Equals, true)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Data, Equals, "c")
+
+	_, ok = recent.Recent("qan", 0)
+	t.Check(ok, Equals, false)
+}
@@ -34,13 +34,14 @@ type Manager struct {
 	hostname string
 	client   pct.WebsocketClient
 	// --
-	config  *Config
-	running bool
-	mux     *sync.Mutex // guards config and running
-	sz      Serializer
-	spooler Spooler
-	sender  *Sender
-	status  *pct.Status
+	signingKey []byte
+	config     *Config
+	running    bool
+	mux        *sync.Mutex // guards config and running
+	sz         Serializer
+	spooler    Spooler
+	sender     *Sender
+	status     *pct.Status
 }
 
 func NewManager(logger *pct.Logger, dataDir, trashDir, hostname string, client pct.WebsocketClient) *Manager {
@@ -66,7 +67,7 @@ func (m *Manager) Start() error {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
-	if m.config != nil {
+	if m.running {
 		return pct.ServiceIsRunningError{Service: "data"}
 	}
 
@@ -98,28 +99,35 @@ func (m *Manager) Start() error {
 	}
 
 	// Make persistent (disk-back) key-value cache and start data spooler.
+	// If restarting, the same spooler is restarted because other services
+	// (mm, qan, etc.) have it.
 	m.status.Update("data", "Starting spooler")
-	spooler := NewDiskvSpooler(
-		pct.NewLogger(m.logger.LogChan(), "data-spooler"),
-		m.dataDir,
-		m.trashDir,
-		m.hostname,
-	)
-	if err := spooler.Start(sz); err != nil {
+	if m.spooler == nil {
+		m.spooler = NewDiskvSpooler(
+			pct.NewLogger(m.logger.LogChan(), "data-spooler"),
+			m.dataDir,
+			m.trashDir,
+			m.hostname,
+		)
+	}
+	m.spooler.SetOrder(config.SendOrder)
+	m.spooler.SetStore(config.Store)
+	m.spooler.SetSigningKey(m.spoolerKey(config))
+	if err := m.spooler.Start(sz); err != nil {
 		return err
 	}
-	m.spooler = spooler
 
 	// Start data sender.
 	m.status.Update("data", "Starting sender")
-	sender := NewSender(
-		pct.NewLogger(m.logger.LogChan(), "data-sender"),
-		m.client,
-	)
-	if err := sender.Start(m.spooler, time.Tick(time.Duration(config.SendInterval)*time.Second), config.SendInterval, config.Blackhole); err != nil {
+	if m.sender == nil {
+		m.sender = NewSender(
+			pct.NewLogger(m.logger.LogChan(), "data-sender"),
+			m.client,
+		)
+	}
+	if err := m.sender.Start(m.spooler, time.Tick(time.Duration(config.SendInterval)*time.Second), config.SendInterval, config.Blackhole); err != nil {
 		return err
 	}
-	m.sender = sender
 
 	m.config = config
 	m.running = true
@@ -131,6 +139,13 @@ func (m *Manager) Start() error {
 
 // @goroutine[0]
 func (m *Manager) Stop() error {
+	m.mux.Lock()
+	running := m.running
+	m.mux.Unlock()
+	if !running {
+		return nil
+	}
+
 	m.status.Update("data", "Stopping sender")
 	m.sender.Stop()
 
@@ -161,6 +176,18 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	case "SetConfig":
 		newConfig, errs := m.handleSetConfig(cmd)
 		return cmd.Reply(newConfig, errs...)
+	case "SendData":
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		if !m.running {
+			return cmd.Reply(nil, pct.ServiceIsNotRunningError{Service: "data"})
+		}
+		return cmd.Reply(nil, m.sender.SendNow())
+	case "Reconnect":
+		// The sender connects for every send, so this only matters while
+		// it's sending: it reconnects, using the current agent links.
+		m.client.DisconnectOnce()
+		return cmd.Reply(nil)
 	default:
 		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 	}
@@ -190,6 +217,21 @@ func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
 	return []proto.AgentConfig{config}, nil
 }
 
+// Pause pauses or resumes the sender.  The spooler keeps spooling.
+func (m *Manager) Pause(paused bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.sender != nil {
+		m.sender.Pause(paused)
+	}
+}
+
+// SetSigningKey sets the agent's secret, its API key, to sign spooled data
+// with if the config enables signing.  Call it before Start.
+func (m *Manager) SetSigningKey(key []byte) {
+	m.signingKey = key
+}
+
 func (m *Manager) Spooler() Spooler {
 	return m.spooler
 }
@@ -212,6 +254,19 @@ func (m *Manager) validateConfig(config *Config) error {
 	} else if config.SendInterval == 0 {
 		config.SendInterval = DEFAULT_DATA_SEND_INTERVAL
 	}
+	switch config.Store {
+	case "", STORE_DISKV, STORE_BOLT:
+	default:
+		return errors.New("Invalid Store: " + config.Store + ", expected " + STORE_DISKV + " or " + STORE_BOLT)
+	}
+	switch config.SendOrder {
+	case "", SEND_OLDEST_FIRST, SEND_NEWEST_FIRST:
+	default:
+		return errors.New("Invalid SendOrder: " + config.SendOrder + ", expected " + SEND_OLDEST_FIRST + " or " + SEND_NEWEST_FIRST)
+	}
+	if config.Sign && len(m.signingKey) == 0 {
+		return errors.New("Cannot sign data: agent has no API key")
+	}
 	return nil
 }
 
@@ -248,16 +303,28 @@ func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
 	 * Data spooler
 	 */
 
-	if newConfig.Encoding != finalConfig.Encoding {
+	if newConfig.SendOrder != finalConfig.SendOrder {
+		m.spooler.SetOrder(newConfig.SendOrder)
+		finalConfig.SendOrder = newConfig.SendOrder
+	}
+
+	if newConfig.Sign != finalConfig.Sign {
+		m.spooler.SetSigningKey(m.spoolerKey(newConfig))
+		finalConfig.Sign = newConfig.Sign
+	}
+
+	if newConfig.Encoding != finalConfig.Encoding || newConfig.Store != finalConfig.Store {
 		sz, err := makeSerializer(newConfig.Encoding)
 		if err != nil {
 			errs = append(errs, err)
 		} else {
 			m.spooler.Stop()
+			m.spooler.SetStore(newConfig.Store)
 			if err := m.spooler.Start(sz); err != nil {
 				errs = append(errs, err)
 			} else {
 				finalConfig.Encoding = newConfig.Encoding
+				finalConfig.Store = newConfig.Store
 			}
 		}
 	}
@@ -271,6 +338,15 @@ func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
 	return m.config, errs
 }
 
+// spoolerKey returns the key the spooler signs data with, or nil if the config
+// doesn't enable signing.
+func (m *Manager) spoolerKey(config *Config) []byte {
+	if !config.Sign {
+		return nil
+	}
+	return m.signingKey
+}
+
 func makeSerializer(encoding string) (Serializer, error) {
 	switch encoding {
 	case "":
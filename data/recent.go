@@ -0,0 +1,86 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package data
+
+import (
+	"sync"
+	"time"
+)
+
+const DEFAULT_RECENT = 10 // data kept per service
+
+// Recent is data written to the spool, e.g. an mm.Report.
+type Recent struct {
+	Ts   time.Time // when written, UTC
+	Data interface{}
+}
+
+// RecentSpooler is a Spooler that keeps the most recent data written for the
+// given services in memory, e.g. the last mm intervals and QAN reports, so
+// local tools can get it without the API (see agent.StatusServer).  Data is
+// kept as written, so it must not be changed after Write, which is already
+// true because the spool serializes it asynchronously.
+type RecentSpooler struct {
+	Spooler
+	size   int
+	recent map[string][]Recent // keyed on service, oldest first
+	mux    *sync.RWMutex
+}
+
+func NewRecentSpooler(spool Spooler, size int, services ...string) *RecentSpooler {
+	if size < 1 {
+		size = DEFAULT_RECENT
+	}
+	s := &RecentSpooler{
+		Spooler: spool,
+		size:    size,
+		recent:  make(map[string][]Recent),
+		mux:     &sync.RWMutex{},
+	}
+	for _, service := range services {
+		s.recent[service] = []Recent{}
+	}
+	return s
+}
+
+func (s *RecentSpooler) Write(service string, data interface{}) error {
+	s.mux.Lock()
+	if recent, ok := s.recent[service]; ok {
+		if len(recent) == s.size {
+			recent = append(recent[:0:0], recent[1:]...)
+		}
+		s.recent[service] = append(recent, Recent{Ts: time.Now().UTC(), Data: data})
+	}
+	s.mux.Unlock()
+	return s.Spooler.Write(service, data)
+}
+
+// Recent returns the most recent data written for the service, up to n
+// (0 for all kept), oldest first.  It returns false if the service isn't kept.
+func (s *RecentSpooler) Recent(service string, n int) ([]Recent, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	recent, ok := s.recent[service]
+	if !ok {
+		return nil, false
+	}
+	if n > 0 && n < len(recent) {
+		recent = recent[len(recent)-n:]
+	}
+	return append([]Recent{}, recent...), true
+}
@@ -0,0 +1,106 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"io/ioutil"
+)
+
+// RewriteSpool changes the data of every spooled proto.Data in the store with
+// rewrite, which gets and returns it uncompressed, e.g. to change instance IDs
+// when the agent is registered again (percona-agent-installer -reregister).
+// Data that was signed is signed again with key, the new API key.  All data
+// is rewritten before any is written, so if rewrite fails the store is not
+// changed.  It returns the number of spooled data rewritten.  The agent must
+// not be running.
+func RewriteSpool(store Store, key []byte, rewrite func(service string, data []byte) ([]byte, error)) (int, error) {
+	newData := make(map[string][]byte)
+	for _, storeKey := range store.Keys() {
+		val, err := store.Read(storeKey)
+		if err != nil {
+			return 0, err
+		}
+		d := &SignedData{Data: &proto.Data{}}
+		if err := json.Unmarshal(val, d); err != nil {
+			return 0, fmt.Errorf("%s: %s", storeKey, err)
+		}
+		content, err := decode(d.Data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", storeKey, err)
+		}
+		if content, err = rewrite(d.Service, content); err != nil {
+			return 0, fmt.Errorf("%s: %s", storeKey, err)
+		}
+		if d.Data.Data, err = encode(d.ContentEncoding, content); err != nil {
+			return 0, fmt.Errorf("%s: %s", storeKey, err)
+		}
+		if d.Signature != "" {
+			d.Signature = Sign(key, d.Data)
+			val, err = json.Marshal(d)
+		} else {
+			val, err = json.Marshal(d.Data)
+		}
+		if err != nil {
+			return 0, err
+		}
+		newData[storeKey] = val
+	}
+	n := 0
+	for storeKey, val := range newData {
+		if err := store.Write(storeKey, val); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func decode(d *proto.Data) ([]byte, error) {
+	switch d.ContentEncoding {
+	case "":
+		return d.Data, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(d.Data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("Unknown content encoding: %s", d.ContentEncoding)
+}
+
+func encode(encoding string, content []byte) ([]byte, error) {
+	if encoding == "" {
+		return content, nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -18,15 +18,18 @@
 package data
 
 import (
+	"errors"
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
+	"sync"
 	"time"
 )
 
 const (
-	MAX_SEND_ERRORS    = 3
-	CONNECT_ERROR_WAIT = 3
+	MAX_SEND_ERRORS      = 3
+	CONNECT_ERROR_WAIT   = 3
+	CONNECT_ERROR_JITTER = 0.25
 )
 
 type Sender struct {
@@ -39,6 +42,9 @@ type Sender struct {
 	blackhole  bool
 	sync       *pct.SyncChan
 	status     *pct.Status
+	paused     bool
+	pausedMux  *sync.Mutex
+	sendNow    chan bool
 	// --
 	sent       uint
 	sentBytes  int
@@ -51,10 +57,12 @@ type Sender struct {
 
 func NewSender(logger *pct.Logger, client pct.WebsocketClient) *Sender {
 	s := &Sender{
-		logger: logger,
-		client: client,
-		sync:   pct.NewSyncChan(),
-		status: pct.NewStatus([]string{"data-sender"}),
+		logger:    logger,
+		client:    client,
+		sync:      pct.NewSyncChan(),
+		status:    pct.NewStatus([]string{"data-sender"}),
+		pausedMux: &sync.Mutex{},
+		sendNow:   make(chan bool, 1),
 	}
 	return s
 }
@@ -64,6 +72,7 @@ func (s *Sender) Start(spool Spooler, tickerChan <-chan time.Time, timeout uint,
 	s.tickerChan = tickerChan
 	s.timeout = timeout
 	s.blackhole = blackhole
+	s.sync = pct.NewSyncChan() // not graceful, else a crash after a restart looks like a stop
 	go s.run()
 	s.logger.Info("Started")
 	return nil
@@ -78,6 +87,34 @@ func (s *Sender) Stop() error {
 	return nil
 }
 
+// Pause makes the sender skip sending until resumed.  Data stays in the
+// spool and is sent on the first tick after resuming.
+func (s *Sender) Pause(paused bool) {
+	s.pausedMux.Lock()
+	defer s.pausedMux.Unlock()
+	s.paused = paused
+	if paused {
+		s.status.Update("data-sender", "Paused")
+	} else {
+		s.status.Update("data-sender", "Idle")
+	}
+}
+
+// SendNow makes the sender send spooled data now instead of on the next tick.
+// The data is sent in the background.
+func (s *Sender) SendNow() error {
+	s.pausedMux.Lock()
+	defer s.pausedMux.Unlock()
+	if s.paused {
+		return errors.New("Data sender is paused")
+	}
+	select {
+	case s.sendNow <- true:
+	default: // already sending now
+	}
+	return nil
+}
+
 func (s *Sender) Status() map[string]string {
 	return s.status.Merge(s.client.Status())
 }
@@ -106,6 +143,15 @@ func (s *Sender) run() {
 	for {
 		select {
 		case <-s.tickerChan:
+			s.pausedMux.Lock()
+			paused := s.paused
+			s.pausedMux.Unlock()
+			if paused {
+				s.logger.Debug("Paused, not sending")
+				continue
+			}
+			s.send()
+		case <-s.sendNow:
 			s.send()
 		case <-s.sync.StopChan:
 			s.sync.Graceful()
@@ -141,7 +187,14 @@ func (s *Sender) send() {
 		}
 	}()
 
-	// Connect and send files until too many errors occur.
+	// Connect and send files until too many errors occur. Agents send on the
+	// same interval boundaries, so retries are jittered to not hit the API
+	// in lockstep, but they don't back off because sending is time-limited.
+	backoff := pct.NewBackoff(0)
+	backoff.Base = CONNECT_ERROR_WAIT * time.Second
+	backoff.Multiplier = 1
+	backoff.Max = backoff.Base
+	backoff.Jitter = CONNECT_ERROR_JITTER
 	startTime := time.Now()
 	for !s.apiErr && s.errs < MAX_SEND_ERRORS && !s.timeoutErr {
 
@@ -156,9 +209,7 @@ func (s *Sender) send() {
 		// Connect to API, or retry.
 		s.status.Update("data-sender", "Connecting")
 		s.logger.Debug("send:connecting")
-		if s.errs > 0 {
-			time.Sleep(CONNECT_ERROR_WAIT * time.Second)
-		}
+		time.Sleep(backoff.Wait()) // 0s on first try
 		if err := s.client.ConnectOnce(10); err != nil {
 			s.errs++
 			s.logger.Warn("Cannot connect to API: ", err)
@@ -0,0 +1,80 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/percona/cloud-protocol/proto"
+	"strings"
+	"time"
+)
+
+const SIGNATURE_PREFIX = "hmac-sha256:" // + hex(HMAC-SHA256(key, SignedBytes))
+
+var (
+	ErrNotSigned    = errors.New("Data is not signed")
+	ErrBadSignature = errors.New("Data signature does not match")
+)
+
+// SignedData is proto.Data with a Signature, which is how data is spooled and
+// sent when signing is enabled (Config.Sign).  The API, or a collector between
+// the agent and the API, verifies the signature with the agent's API key to
+// know the data wasn't changed on disk or in transit, see Verify.
+type SignedData struct {
+	*proto.Data
+	Signature string `json:",omitempty"`
+}
+
+// SignedBytes returns what's signed: the service, hostname, created time and
+// content encoding, one per line, then the encoded data.
+func SignedBytes(d *proto.Data) []byte {
+	header := strings.Join([]string{
+		d.Service,
+		d.Hostname,
+		d.Created.UTC().Format(time.RFC3339Nano),
+		d.ContentEncoding,
+	}, "\n")
+	return append([]byte(header+"\n"), d.Data...)
+}
+
+// Sign returns the signature of the data, like "hmac-sha256:1a2b...".
+func Sign(key []byte, d *proto.Data) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(SignedBytes(d))
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns nil if the spooled data, a JSON SignedData, is signed with
+// the key, else ErrNotSigned or ErrBadSignature.
+func Verify(key []byte, bytes []byte) error {
+	d := &SignedData{Data: &proto.Data{}}
+	if err := json.Unmarshal(bytes, d); err != nil {
+		return err
+	}
+	if d.Signature == "" {
+		return ErrNotSigned
+	}
+	if !hmac.Equal([]byte(d.Signature), []byte(Sign(key, d.Data))) {
+		return ErrBadSignature
+	}
+	return nil
+}
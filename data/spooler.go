@@ -23,9 +23,10 @@ import (
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
-	"github.com/peterbourgon/diskv"
+	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -45,11 +46,15 @@ type Spooler interface {
 	Status() map[string]string
 	Write(service string, data interface{}) error
 	Files() <-chan string
+	SetOrder(order string)
+	SetStore(backend string)
+	SetSigningKey(key []byte)
 	Read(file string) ([]byte, error)
 	Remove(file string) error
 	Reject(file string) error
 }
 
+// DiskvSpooler spools data to a Store, diskv by default, see SetStore.
 // http://godoc.org/github.com/peterbourgon/diskv
 type DiskvSpooler struct {
 	logger   *pct.Logger
@@ -60,7 +65,8 @@ type DiskvSpooler struct {
 	sz           Serializer
 	dataChan     chan *proto.Data
 	sync         *pct.SyncChan
-	cache        *diskv.Diskv
+	store        Store
+	backend      string
 	status       *pct.Status
 	mux          *sync.Mutex
 	trashDataDir string
@@ -68,6 +74,8 @@ type DiskvSpooler struct {
 	size         int
 	oldest       int64
 	fileSize     map[string]int
+	order        string
+	signingKey   []byte
 }
 
 func NewDiskvSpooler(logger *pct.Logger, dataDir, trashDir, hostname string) *DiskvSpooler {
@@ -82,6 +90,8 @@ func NewDiskvSpooler(logger *pct.Logger, dataDir, trashDir, hostname string) *Di
 		status:   pct.NewStatus([]string{"data-spooler", "data-spooler-count", "data-spooler-size", "data-spooler-oldest"}),
 		mux:      new(sync.Mutex),
 		fileSize: make(map[string]int),
+		order:    SEND_OLDEST_FIRST,
+		backend:  STORE_DISKV,
 	}
 	return s
 }
@@ -108,36 +118,33 @@ func (s *DiskvSpooler) Start(sz Serializer) error {
 	// T{} -> []byte
 	s.sz = sz
 
-	// diskv reads all files in BasePath on startup.
-	s.cache = diskv.New(diskv.Options{
-		BasePath:     s.dataDir,
-		Transform:    func(s string) []string { return []string{} },
-		CacheSizeMax: CACHE_SIZE,
-		Index:        &diskv.LLRBIndex{},
-		IndexLess:    func(a, b string) bool { return a < b },
-	})
+	store, err := s.openStore()
+	if err != nil {
+		return err
+	}
+	s.store = store
 
 	s.mux.Lock()
 	defer s.mux.Unlock()
 	s.oldest = time.Now().UTC().UnixNano()
-	for key := range s.cache.Keys() {
-		data, err := s.cache.Read(key)
+	for _, key := range s.store.Keys() {
+		data, err := s.store.Read(key)
 		if err != nil {
 			s.logger.Error("Cannot read data file", key, ":", err)
-			s.cache.Erase(key)
+			s.store.Erase(key)
 			continue
 		}
 		parts := strings.Split(key, "_") // service_nanoUnixTs
 		if len(parts) != 2 {
 			s.logger.Error("Invalid data file name:", key)
-			s.cache.Erase(key)
+			s.store.Erase(key)
 			continue
 		}
 
 		ts, err := strconv.ParseInt(parts[1], 10, 64)
 		if err != nil {
 			s.logger.Error("ParseInt", key, ":", err)
-			s.cache.Erase(key)
+			s.store.Erase(key)
 			continue
 		}
 		if ts < s.oldest {
@@ -147,6 +154,7 @@ func (s *DiskvSpooler) Start(sz Serializer) error {
 		s.size += len(data)
 	}
 
+	s.sync = pct.NewSyncChan() // new on every start for restarts
 	go s.run()
 	s.logger.Info("Started")
 	return nil
@@ -156,7 +164,12 @@ func (s *DiskvSpooler) Stop() error {
 	s.sync.Stop()
 	s.sync.Wait()
 	s.sz = nil
-	s.cache = nil
+	if s.store != nil {
+		if err := s.store.Close(); err != nil {
+			s.logger.Warn(err)
+		}
+		s.store = nil
+	}
 	s.logger.Info("Stopped")
 	return nil
 }
@@ -213,12 +226,56 @@ func (s *DiskvSpooler) Write(service string, data interface{}) error {
 	return nil
 }
 
+// Files returns the spooled files in the order set by SetOrder, oldest first
+// by default.  The order is by the time the data was spooled, not by the order
+// in which the filesystem happens to list the files.
 func (s *DiskvSpooler) Files() <-chan string {
-	return s.cache.Keys()
+	files := s.store.Keys()
+
+	s.mux.Lock()
+	newestFirst := s.order == SEND_NEWEST_FIRST
+	s.mux.Unlock()
+	if newestFirst {
+		sort.Sort(sort.Reverse(byTs(files)))
+	} else {
+		sort.Sort(byTs(files))
+	}
+
+	// Buffered to hold all the files, so no goroutine is left blocked if the
+	// caller stops reading early, e.g. the sender times out.
+	filesChan := make(chan string, len(files))
+	for _, file := range files {
+		filesChan <- file
+	}
+	close(filesChan)
+	return filesChan
+}
+
+// SetOrder sets the order of Files(): SEND_OLDEST_FIRST or SEND_NEWEST_FIRST.
+func (s *DiskvSpooler) SetOrder(order string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.order = order
+}
+
+// SetStore sets the Store backend, STORE_DISKV (default) or STORE_BOLT, used
+// on the next Start.  Data spooled with the other backend is moved to it.
+func (s *DiskvSpooler) SetStore(backend string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.backend = backend
+}
+
+// SetSigningKey sets the key to sign spooled data with, see Sign, or nil to
+// not sign it.
+func (s *DiskvSpooler) SetSigningKey(key []byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.signingKey = key
 }
 
 func (s *DiskvSpooler) Read(file string) ([]byte, error) {
-	bytes, err := s.cache.Read(file)
+	bytes, err := s.store.Read(file)
 	// Cache file size because we expect caller to call Remove() next.
 	s.fileSize[file] = len(bytes)
 	return bytes, err
@@ -231,7 +288,7 @@ func (s *DiskvSpooler) Remove(file string) error {
 		size = len(data)
 	}
 	// Don't lock mutex yet in case this takes awhile (it shouldn't):
-	if err := s.cache.Erase(file); err != nil && !os.IsNotExist(err) {
+	if err := s.store.Erase(file); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	s.mux.Lock()
@@ -245,6 +302,17 @@ func (s *DiskvSpooler) Remove(file string) error {
 }
 
 func (s *DiskvSpooler) Reject(file string) error {
+	if _, ok := s.store.(*DiskvStore); !ok {
+		// The file is in a database, so write it to the trash dir.
+		data, err := s.store.Read(file)
+		if err != nil {
+			return nil
+		}
+		if err := ioutil.WriteFile(path.Join(s.trashDataDir, file), data, 0600); err != nil {
+			return err
+		}
+		return s.Remove(file)
+	}
 	if err := os.Rename(path.Join(s.dataDir, file), path.Join(s.trashDataDir, file)); err != nil {
 		return nil
 	}
@@ -262,6 +330,50 @@ func (s *DiskvSpooler) Reject(file string) error {
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
 
+// openStore opens the store of the backend and moves data from the other
+// backend to it.
+func (s *DiskvSpooler) openStore() (Store, error) {
+	s.mux.Lock()
+	backend := s.backend
+	s.mux.Unlock()
+
+	boltFile := BoltFile(s.dataDir)
+	diskvStore := NewDiskvStore(s.dataDir)
+	if backend != STORE_BOLT {
+		if !pct.FileExists(boltFile) {
+			return diskvStore, nil
+		}
+		boltStore, err := NewBoltStore(boltFile)
+		if err != nil {
+			return nil, err
+		}
+		n, err := moveKeys(boltStore, diskvStore)
+		boltStore.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot move data from %s to %s: %s", boltFile, s.dataDir, err)
+		}
+		s.logger.Info(fmt.Sprintf("Moved %d files from %s to %s", n, boltFile, s.dataDir))
+		if err := os.Remove(boltFile); err != nil {
+			s.logger.Warn(err)
+		}
+		return diskvStore, nil
+	}
+
+	boltStore, err := NewBoltStore(boltFile)
+	if err != nil {
+		return nil, err
+	}
+	n, err := moveKeys(diskvStore, boltStore)
+	if err != nil {
+		boltStore.Close()
+		return nil, fmt.Errorf("Cannot move data from %s to %s: %s", s.dataDir, boltFile, err)
+	}
+	if n > 0 {
+		s.logger.Info(fmt.Sprintf("Moved %d files from %s to %s", n, s.dataDir, boltFile))
+	}
+	return boltStore, nil
+}
+
 // @goroutine[1]
 func (s *DiskvSpooler) run() {
 	defer func() {
@@ -287,13 +399,23 @@ func (s *DiskvSpooler) run() {
 			s.logger.Debug("run:spool:" + key)
 			s.status.Update("data-spooler", "Spooling "+key)
 
-			bytes, err := json.Marshal(protoData)
+			s.mux.Lock()
+			signingKey := s.signingKey
+			s.mux.Unlock()
+
+			var bytes []byte
+			var err error
+			if signingKey != nil {
+				bytes, err = json.Marshal(SignedData{Data: protoData, Signature: Sign(signingKey, protoData)})
+			} else {
+				bytes, err = json.Marshal(protoData)
+			}
 			if err != nil {
 				s.logger.Error(err)
 				continue
 			}
 
-			if err := s.cache.Write(key, bytes); err != nil {
+			if err := s.store.Write(key, bytes); err != nil {
 				s.logger.Error(err)
 			}
 
@@ -310,3 +432,22 @@ func (s *DiskvSpooler) run() {
 		}
 	}
 }
+
+// byTs sorts spool files, which are named service_ts, by ts (Unix nanoseconds),
+// then by name if two files have the same ts.
+type byTs []string
+
+func (f byTs) Len() int      { return len(f) }
+func (f byTs) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f byTs) Less(i, j int) bool {
+	tsi, tsj := fileTs(f[i]), fileTs(f[j])
+	if tsi == tsj {
+		return f[i] < f[j]
+	}
+	return tsi < tsj
+}
+
+func fileTs(file string) int64 {
+	ts, _ := strconv.ParseInt(file[strings.LastIndex(file, "_")+1:], 10, 64)
+	return ts
+}
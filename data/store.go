@@ -0,0 +1,191 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package data
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/peterbourgon/diskv"
+	"os"
+	"time"
+)
+
+// Spool storage backends, set in data.Config.Store.
+const (
+	STORE_DISKV = "diskv" // one file per key in the data dir (default)
+	STORE_BOLT  = "bolt"  // one bolt database file for all keys
+)
+
+// A Store is where the spooler keeps data until it's sent, keyed on
+// service_nanoUnixTs.  The diskv store is simple, but it's one file per key,
+// so a backlog of tens of thousands of keys means as many inodes and slow
+// listing.  The bolt store is one file, so it's better for agents that spool
+// a lot of data.
+type Store interface {
+	Keys() []string
+	Read(key string) ([]byte, error)
+	Write(key string, data []byte) error
+	Erase(key string) error // error is os.IsNotExist if key doesn't exist
+	Close() error
+}
+
+// --------------------------------------------------------------------------
+
+type DiskvStore struct {
+	cache *diskv.Diskv
+}
+
+// NewDiskvStore returns a Store with one file per key in dir.  diskv reads
+// all files in dir on startup.
+func NewDiskvStore(dir string) *DiskvStore {
+	s := &DiskvStore{
+		cache: diskv.New(diskv.Options{
+			BasePath:     dir,
+			Transform:    func(s string) []string { return []string{} },
+			CacheSizeMax: CACHE_SIZE,
+			Index:        &diskv.LLRBIndex{},
+			IndexLess:    func(a, b string) bool { return a < b },
+		}),
+	}
+	return s
+}
+
+func (s *DiskvStore) Keys() []string {
+	keys := []string{}
+	for key := range s.cache.Keys() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *DiskvStore) Read(key string) ([]byte, error) {
+	return s.cache.Read(key)
+}
+
+func (s *DiskvStore) Write(key string, data []byte) error {
+	return s.cache.Write(key, data)
+}
+
+func (s *DiskvStore) Erase(key string) error {
+	return s.cache.Erase(key)
+}
+
+func (s *DiskvStore) Close() error {
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+var boltBucket = []byte("data")
+
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the bolt database file.  Only one process
+// can open it, so it times out if another agent has it open.
+func NewBoltStore(file string) (*BoltStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %s: %s", file, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &BoltStore{
+		db: db,
+	}
+	return s, nil
+}
+
+func (s *BoltStore) Keys() []string {
+	keys := []string{}
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys
+}
+
+func (s *BoltStore) Read(key string) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return &os.PathError{Op: "read", Path: key, Err: os.ErrNotExist}
+		}
+		// v is only valid during the tx.
+		data = make([]byte, len(v))
+		copy(data, v)
+		return nil
+	})
+	return data, err
+}
+
+func (s *BoltStore) Write(key string, data []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *BoltStore) Erase(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b.Get([]byte(key)) == nil {
+			return &os.PathError{Op: "erase", Path: key, Err: os.ErrNotExist}
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// BoltFile returns the bolt database file for the data dir.  It's next to the
+// data dir, not in it, because diskv treats every file in the data dir as a key.
+func BoltFile(dataDir string) string {
+	return dataDir + ".db"
+}
+
+// moveKeys moves all keys from one store to another, e.g. data spooled with
+// diskv before the store was changed to bolt, so it's still sent.
+func moveKeys(from, to Store) (int, error) {
+	n := 0
+	for _, key := range from.Keys() {
+		data, err := from.Read(key)
+		if err != nil {
+			return n, err
+		}
+		if err := to.Write(key, data); err != nil {
+			return n, err
+		}
+		if err := from.Erase(key); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog_test
+
+import (
+	"testing"
+
+	"github.com/percona/percona-agent/event/binlog"
+	"github.com/percona/percona-agent/mysql"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type BinlogTestSuite struct{}
+
+var _ = Suite(&BinlogTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *BinlogTestSuite) TestHoursToFull(t *C) {
+	const GiB = 1024 * 1024 * 1024
+	b := &mysql.Binlogs{
+		Count:  10,
+		Size:   10 * GiB,
+		Dir:    "/var/lib/mysql",
+		FsSize: 100 * GiB,
+		FsFree: 36 * GiB,
+	}
+
+	// 1 MiB/s and binlogs never expire: 36 GiB / 3.5 GiB/hour.
+	rate := float64(1024 * 1024)
+	t.Check(binlog.HoursToFull(b, rate), Equals, float64(36*1024)/3600)
+
+	// Not growing or not on this host: never.
+	t.Check(binlog.HoursToFull(b, 0), Equals, float64(-1))
+	fs := *b
+	fs.FsSize = 0
+	t.Check(binlog.HoursToFull(&fs, rate), Equals, float64(-1))
+
+	// Binlogs expire after 1 hour, so they level off at ~3.5 GiB, less
+	// than now.  Older binlogs will be purged: never.
+	b.ExpireSeconds = 3600
+	t.Check(binlog.HoursToFull(b, rate), Equals, float64(-1))
+
+	// Binlogs expire after 1 day, so they level off at ~84 GiB, 74 GiB more
+	// than now, which is more than is free.
+	b.ExpireSeconds = 86400
+	t.Check(binlog.HoursToFull(b, rate), Equals, float64(36*1024)/3600)
+
+	// Binlogs expire after 12 hours, so they level off at ~42 GiB, 32 GiB
+	// more than now, which is less than is free: never.
+	b.ExpireSeconds = 12 * 3600
+	t.Check(binlog.HoursToFull(b, rate), Equals, float64(-1))
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog
+
+import (
+	"github.com/percona/percona-agent/event"
+)
+
+const (
+	DEFAULT_MIN_HOURS_TO_FULL = 24
+	DEFAULT_WINDOW            = 3600 // seconds
+)
+
+type Config struct {
+	event.Config
+	MinHoursToFull uint `json:",omitempty"` // warn if binlogs fill the filesystem sooner, default 24
+	Window         uint `json:",omitempty"` // seconds of binlog growth to project from, default 1h
+}
+
+func (c *Config) minHoursToFull() uint {
+	if c.MinHoursToFull == 0 {
+		return DEFAULT_MIN_HOURS_TO_FULL
+	}
+	return c.MinHoursToFull
+}
+
+func (c *Config) window() int64 {
+	if c.Window == 0 {
+		return DEFAULT_WINDOW
+	}
+	return int64(c.Window)
+}
@@ -0,0 +1,263 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package binlog
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event type
+const DISK_HEADROOM = "binlog-disk-headroom"
+
+// HoursToFull returns how many hours until binlogs growing at rate bytes/s
+// fill their filesystem, or -1 if they never will because they don't grow or
+// they're purged (expire) before the filesystem fills.
+func HoursToFull(b *mysql.Binlogs, rate float64) float64 {
+	if rate <= 0 || b.FsSize == 0 {
+		return -1
+	}
+	if b.ExpireSeconds > 0 {
+		// Binlogs stop growing at about rate * expire bytes.
+		if rate*float64(b.ExpireSeconds)-float64(b.Size) < float64(b.FsFree) {
+			return -1
+		}
+	}
+	return float64(b.FsFree) / rate / 3600
+}
+
+type sample struct {
+	ts   int64
+	size uint64
+}
+
+// Monitor projects when the binary logs will fill their filesystem from their
+// growth over the last Window seconds, and sends a warning event when it's
+// sooner than MinHoursToFull.  It warns once until the projection is above
+// the threshold again.  Binlog metrics are collected by the mm MySQL monitor
+// (Binlog config).
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	samples   []sample
+	warned    bool
+	local     bool // MySQL is on this host, see mysql.IsLocal
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:    pct.NewSyncChan(),
+		status:  pct.NewStatus([]string{name}),
+		samples: []sample{},
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	m.local = mysql.IsLocal(m.conn.DSN())
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Binlog monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	idle := "Idle"
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, idle)
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+			b, err := m.getBinlogs()
+			if err != nil {
+				m.logger.Warn(err)
+				continue
+			}
+			if b == nil {
+				idle = "Idle (binary logging is off)"
+				continue
+			}
+			if b.FsSize == 0 {
+				idle = "Idle (MySQL " + b.Dir + " is not on this host)"
+				continue
+			}
+			hours, e := m.check(now, b)
+			if hours < 0 {
+				idle = "Idle"
+			} else {
+				idle = fmt.Sprintf("Idle (binlogs fill %s in %.1f hours)", b.Dir, hours)
+			}
+			if e != nil {
+				select {
+				case m.eventChan <- e:
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+func (m *Monitor) getBinlogs() (*mysql.Binlogs, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return nil, err
+	}
+	defer m.conn.Close()
+	return mysql.GetBinlogs(m.conn.DB(), m.local)
+}
+
+// check adds a sample and returns the projected hours to full, or -1, and an
+// event if it's time to warn.
+func (m *Monitor) check(now time.Time, b *mysql.Binlogs) (float64, *event.Event) {
+	ts := now.UTC().Unix()
+	m.samples = append(m.samples, sample{ts: ts, size: b.Size})
+	for len(m.samples) > 1 && ts-m.samples[0].ts > m.config.window() {
+		m.samples = m.samples[1:]
+	}
+
+	// Project only from at least half the window, else a burst of writes
+	// when the monitor starts looks like the norm.
+	elapsed := ts - m.samples[0].ts
+	if elapsed*2 < m.config.window() {
+		return -1, nil
+	}
+
+	// Growth is the sum of increases, so purging binlogs doesn't hide it.
+	var growth uint64
+	for i := 1; i < len(m.samples); i++ {
+		if m.samples[i].size > m.samples[i-1].size {
+			growth += m.samples[i].size - m.samples[i-1].size
+		}
+	}
+	rate := float64(growth) / float64(elapsed)
+
+	hours := HoursToFull(b, rate)
+	if hours < 0 || hours >= float64(m.config.minHoursToFull()) {
+		m.warned = false
+		return hours, nil
+	}
+	if m.warned {
+		return hours, nil
+	}
+	m.warned = true
+
+	e := &event.Event{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    m.config.Service,
+			InstanceId: m.config.InstanceId,
+		},
+		Ts:       ts,
+		Monitor:  "binlog",
+		Type:     DISK_HEADROOM,
+		Severity: event.SEVERITY_WARNING,
+		Message:  fmt.Sprintf("Binary logs will fill %s in %.1f hours at %.1f MiB/hour", b.Dir, hours, rate*3600/(1024*1024)),
+		Details: map[string]string{
+			"dir":             b.Dir,
+			"fs_free":         fmt.Sprintf("%d", b.FsFree),
+			"binlog_size":     fmt.Sprintf("%d", b.Size),
+			"binlog_files":    fmt.Sprintf("%d", b.Count),
+			"expire_seconds":  fmt.Sprintf("%d", b.ExpireSeconds),
+			"growth_per_hour": fmt.Sprintf("%.0f", math.Floor(rate*3600)), // bytes
+			"hours_to_full":   fmt.Sprintf("%.1f", hours),
+		},
+	}
+	return hours, e
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cert_test
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-agent/event/cert"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type CertTestSuite struct{}
+
+var _ = Suite(&CertTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *CertTestSuite) TestDaysLeft(t *C) {
+	now := time.Date(2015, 11, 20, 12, 0, 0, 0, time.UTC)
+	t.Check(cert.DaysLeft(now.Add(30*24*time.Hour), now), Equals, 30)
+	t.Check(cert.DaysLeft(now.Add(29*24*time.Hour+23*time.Hour), now), Equals, 29)
+	t.Check(cert.DaysLeft(now.Add(1*time.Hour), now), Equals, 0)
+	t.Check(cert.DaysLeft(now.Add(-1*time.Hour), now), Equals, -1)
+	t.Check(cert.DaysLeft(now.Add(-48*time.Hour), now), Equals, -2)
+}
+
+func (s *CertTestSuite) TestLevel(t *C) {
+	t.Check(cert.Level(90, 30), Equals, -1)
+	t.Check(cert.Level(30, 30), Equals, -1)
+	t.Check(cert.Level(29, 30), Equals, 30)
+	t.Check(cert.Level(7, 30), Equals, 30)
+	t.Check(cert.Level(6, 30), Equals, 7)
+	t.Check(cert.Level(0, 30), Equals, 1)
+	t.Check(cert.Level(-1, 30), Equals, 0)
+
+	// Warning sooner than a week only warns at a day and expiry.
+	t.Check(cert.Level(5, 3), Equals, 7)
+}
+
+func (s *CertTestSuite) TestParseOpenSSLTime(t *C) {
+	// As reported by Ssl_server_not_after.
+	got, err := time.Parse(cert.OPENSSL_TIME_FORMAT, "Apr  7 00:00:01 2025 GMT")
+	t.Assert(err, IsNil)
+	t.Check(got.Year(), Equals, 2025)
+	t.Check(got.Month(), Equals, time.April)
+	t.Check(got.Day(), Equals, 7)
+}
+
+func (s *CertTestSuite) TestEndpointNotAfter(t *C) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	x509Cert, err := x509.ParseCertificate(ts.TLS.Certificates[0].Certificate[0])
+	t.Assert(err, IsNil)
+
+	addr := strings.TrimPrefix(ts.URL, "https://")
+	notAfter, _, err := cert.EndpointNotAfter(addr)
+	t.Assert(err, IsNil)
+	t.Check(notAfter.Equal(x509Cert.NotAfter), Equals, true)
+
+	ts.Close()
+	_, _, err = cert.EndpointNotAfter(addr)
+	t.Check(err, NotNil)
+}
@@ -0,0 +1,37 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cert
+
+import (
+	"github.com/percona/percona-agent/event"
+)
+
+const DEFAULT_WARN_DAYS = 30
+
+type Config struct {
+	event.Config
+	WarnDays  uint     `json:",omitempty"` // warn when a certificate expires sooner, default 30 days
+	Endpoints []string `json:",omitempty"` // other TLS host:port to check, e.g. the API
+}
+
+func (c *Config) warnDays() int {
+	if c.WarnDays == 0 {
+		return DEFAULT_WARN_DAYS
+	}
+	return int(c.WarnDays)
+}
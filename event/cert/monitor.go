@@ -0,0 +1,283 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cert
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event type
+const EXPIRY = "cert-expiry"
+
+const TIMEOUT = 5 * time.Second
+
+// OpenSSL date format of Ssl_server_not_after, e.g. Apr 17 00:00:00 2025 GMT.
+const OPENSSL_TIME_FORMAT = "Jan _2 15:04:05 2006 MST"
+
+// DaysLeft returns the days until notAfter, rounded down, negative if expired.
+func DaysLeft(notAfter, now time.Time) int {
+	return int(math.Floor(notAfter.Sub(now).Hours() / 24))
+}
+
+// Level returns the warning level of a certificate that expires in days:
+// 0 if it's expired, 1 if it expires within a day, 7 within a week, warnDays
+// within that, else -1 if it's not time to warn.  Each level is warned once,
+// so there's one event at warnDays, a week, a day, and expiry.
+func Level(days, warnDays int) int {
+	for _, level := range []int{0, 1, 7, warnDays} {
+		if days < level {
+			return level
+		}
+	}
+	return -1
+}
+
+// EndpointNotAfter returns the expiry of the certificate of a TLS host:port.
+// The certificate isn't verified because it only needs to be inspected.
+func EndpointNotAfter(addr string) (time.Time, string, error) {
+	dialer := &net.Dialer{Timeout: TIMEOUT}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, "", errors.New(addr + " has no TLS certificate")
+	}
+	return certs[0].NotAfter, certs[0].Subject.CommonName, nil
+}
+
+type expiry struct {
+	notAfter time.Time
+	level    int // last warned
+}
+
+// Monitor checks the expiry of the TLS certificate of MySQL (Ssl_server_not_after)
+// and of other endpoints, like the API, every interval (e.g. daily), and sends
+// an event when one expires sooner than WarnDays, again a week and a day
+// before, and once expired.  A renewed certificate starts over.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	expiries  map[string]*expiry // keyed on endpoint
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:     pct.NewSyncChan(),
+		status:   pct.NewStatus([]string{name}),
+		expiries: make(map[string]*expiry),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Certificate monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, "Idle")
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+
+			events := []*event.Event{}
+			notAfter, err := m.mysqlNotAfter()
+			if err != nil {
+				m.logger.Warn("Cannot get MySQL certificate expiry: ", err)
+			} else if !notAfter.IsZero() {
+				if e := m.check("mysql", notAfter, "", now); e != nil {
+					events = append(events, e)
+				}
+			}
+			for _, endpoint := range m.config.Endpoints {
+				notAfter, subject, err := EndpointNotAfter(endpoint)
+				if err != nil {
+					m.logger.Warn("Cannot get certificate expiry: ", err)
+					continue
+				}
+				if e := m.check(endpoint, notAfter, subject, now); e != nil {
+					events = append(events, e)
+				}
+			}
+
+			for _, e := range events {
+				select {
+				case m.eventChan <- e:
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// mysqlNotAfter returns the expiry of the MySQL server certificate, or zero
+// time if MySQL doesn't have SSL.
+func (m *Monitor) mysqlNotAfter() (time.Time, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return time.Time{}, err
+	}
+	defer m.conn.Close()
+	var varName, value string
+	err := m.conn.DB().QueryRow("SHOW /*!50002 GLOBAL */ STATUS LIKE 'Ssl_server_not_after'").Scan(&varName, &value)
+	if err != nil || value == "" {
+		return time.Time{}, nil // no SSL, or MySQL too old
+	}
+	return time.Parse(OPENSSL_TIME_FORMAT, value)
+}
+
+// check returns an event if the certificate of the endpoint reached a new
+// warning level.
+func (m *Monitor) check(endpoint string, notAfter time.Time, subject string, now time.Time) *event.Event {
+	x, ok := m.expiries[endpoint]
+	if !ok || !x.notAfter.Equal(notAfter) {
+		x = &expiry{notAfter: notAfter, level: -1}
+		m.expiries[endpoint] = x
+	}
+	days := DaysLeft(notAfter, now)
+	level := Level(days, m.config.warnDays())
+	if level < 0 || (x.level >= 0 && level >= x.level) {
+		return nil
+	}
+	x.level = level
+
+	var msg string
+	severity := event.SEVERITY_WARNING
+	if days < 0 {
+		msg = fmt.Sprintf("TLS certificate of %s expired on %s", endpoint, notAfter.UTC().Format("2006-01-02"))
+		severity = event.SEVERITY_ERROR
+	} else {
+		msg = fmt.Sprintf("TLS certificate of %s expires in %d days on %s", endpoint, days, notAfter.UTC().Format("2006-01-02"))
+		if days < 7 {
+			severity = event.SEVERITY_ERROR
+		}
+	}
+	e := &event.Event{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    m.config.Service,
+			InstanceId: m.config.InstanceId,
+		},
+		Ts:       now.UTC().Unix(),
+		Monitor:  "cert",
+		Type:     EXPIRY,
+		Severity: severity,
+		Message:  msg,
+		Details: map[string]string{
+			"endpoint":  endpoint,
+			"not_after": notAfter.UTC().Format(time.RFC3339),
+			"days":      fmt.Sprintf("%d", days),
+		},
+	}
+	if subject != "" {
+		e.Details["subject"] = subject
+	}
+	return e
+}
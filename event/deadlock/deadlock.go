@@ -0,0 +1,180 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package deadlock
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lock is a lock in a deadlock, held by or waited for by a transaction.
+type Lock struct {
+	Type  string // RECORD or TABLE
+	Table string // e.g. `test`.`t`
+	Index string // e.g. `PRIMARY`, only for RECORD locks
+	Mode  string // e.g. lock_mode X locks rec but not gap waiting
+}
+
+func (l *Lock) String() string {
+	if l.Index != "" {
+		return fmt.Sprintf("%s lock on %s index %s: %s", l.Type, l.Table, l.Index, l.Mode)
+	}
+	return fmt.Sprintf("%s lock on %s: %s", l.Type, l.Table, l.Mode)
+}
+
+// Transaction is one of the transactions in a deadlock.
+type Transaction struct {
+	Id       string // InnoDB trx id
+	Active   string // e.g. 5 sec
+	ThreadId string // MySQL thread (connection) id
+	QueryId  string
+	Query    string
+	Holds    *Lock // nil if not reported
+	Waits    *Lock
+}
+
+// Deadlock is the LATEST DETECTED DEADLOCK of SHOW ENGINE INNODB STATUS.
+type Deadlock struct {
+	Ts           string // as printed by InnoDB, e.g. 2014-11-20 10:00:01 7f2b4c0d5700
+	Transactions []*Transaction
+	Victim       int // transaction rolled back, 1-based, or 0 if not reported
+}
+
+// Id returns a string that identifies the deadlock, i.e. the same deadlock
+// in different outputs of SHOW ENGINE INNODB STATUS has the same id.
+func (d *Deadlock) Id() string {
+	id := d.Ts
+	for _, trx := range d.Transactions {
+		id += " " + trx.Id
+	}
+	return id
+}
+
+const sectionHeader = "LATEST DETECTED DEADLOCK\n------------------------\n"
+
+var (
+	headerRe      = regexp.MustCompile(`^\*\*\* \((\d+)\) (TRANSACTION|HOLDS THE LOCK|WAITING FOR THIS LOCK)`)
+	victimRe      = regexp.MustCompile(`^\*\*\* WE ROLL BACK TRANSACTION \((\d+)\)`)
+	trxRe         = regexp.MustCompile(`^(?:---)?TRANSACTION (\w+), ACTIVE (\d+ sec)`)
+	threadRe      = regexp.MustCompile(`^MySQL thread id (\d+), .*query id (\d+)`)
+	recordLockRe  = regexp.MustCompile(`^RECORD LOCKS .* index (\S+) of table (\S+) trx id \w+ (.+)$`)
+	tableLockRe   = regexp.MustCompile(`^TABLE LOCK table (\S+) trx id \w+ (.+)$`)
+	nextSectionRe = regexp.MustCompile(`^-{4,}$`)
+)
+
+// Parse parses the LATEST DETECTED DEADLOCK section of the output of SHOW
+// ENGINE INNODB STATUS.  It returns nil if there's no such section, i.e. no
+// deadlock since MySQL started.
+func Parse(status string) (*Deadlock, error) {
+	i := strings.Index(status, sectionHeader)
+	if i < 0 {
+		return nil, nil
+	}
+	lines := strings.Split(status[i+len(sectionHeader):], "\n")
+
+	d := &Deadlock{
+		Ts:           strings.TrimSpace(lines[0]),
+		Transactions: []*Transaction{},
+	}
+	var trx *Transaction
+	var lock **Lock
+	query := []string{}
+	inQuery := false
+	for _, line := range lines[1:] {
+		line = strings.TrimRight(line, "\r")
+		if nextSectionRe.MatchString(line) {
+			break
+		}
+
+		if strings.HasPrefix(line, "***") {
+			if inQuery {
+				trx.Query = strings.Join(query, " ")
+				query = []string{}
+				inQuery = false
+			}
+			lock = nil
+			if m := victimRe.FindStringSubmatch(line); m != nil {
+				d.Victim, _ = strconv.Atoi(m[1])
+				continue
+			}
+			m := headerRe.FindStringSubmatch(line)
+			if m == nil {
+				continue // e.g. *** TOO DEEP OR LONG SEARCH...
+			}
+			n, _ := strconv.Atoi(m[1])
+			if m[2] == "TRANSACTION" {
+				if n != len(d.Transactions)+1 {
+					return nil, fmt.Errorf("deadlock transaction (%d) out of order", n)
+				}
+				trx = &Transaction{}
+				d.Transactions = append(d.Transactions, trx)
+				continue
+			}
+			if n < 1 || n > len(d.Transactions) {
+				return nil, fmt.Errorf("deadlock lock of unknown transaction (%d)", n)
+			}
+			trx = d.Transactions[n-1]
+			if m[2] == "HOLDS THE LOCK" {
+				lock = &trx.Holds
+			} else {
+				lock = &trx.Waits
+			}
+			continue
+		}
+
+		if trx == nil {
+			continue
+		}
+		if inQuery {
+			if s := strings.TrimSpace(line); s != "" {
+				query = append(query, s)
+			}
+			continue
+		}
+		if lock != nil {
+			if *lock != nil {
+				continue // record dump after the lock
+			}
+			if m := recordLockRe.FindStringSubmatch(line); m != nil {
+				*lock = &Lock{Type: "RECORD", Index: m[1], Table: m[2], Mode: m[3]}
+			} else if m := tableLockRe.FindStringSubmatch(line); m != nil {
+				*lock = &Lock{Type: "TABLE", Table: m[1], Mode: m[2]}
+			}
+			continue
+		}
+		if m := trxRe.FindStringSubmatch(line); m != nil {
+			trx.Id = m[1]
+			trx.Active = m[2]
+		} else if m := threadRe.FindStringSubmatch(line); m != nil {
+			trx.ThreadId = m[1]
+			trx.QueryId = m[2]
+			inQuery = true // query follows the thread line
+		}
+	}
+	if inQuery {
+		trx.Query = strings.Join(query, " ")
+	}
+
+	if len(d.Transactions) == 0 {
+		return nil, errors.New("no transactions in LATEST DETECTED DEADLOCK")
+	}
+	return d, nil
+}
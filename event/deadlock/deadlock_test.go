@@ -0,0 +1,134 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package deadlock_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/event/deadlock"
+	"github.com/percona/percona-agent/test"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type DeadlockTestSuite struct{}
+
+var _ = Suite(&DeadlockTestSuite{})
+
+var sample = test.RootDir + "/event/deadlock"
+
+func readStatus(t *C, file string) string {
+	data, err := ioutil.ReadFile(sample + "/" + file)
+	t.Assert(err, IsNil)
+	return string(data)
+}
+
+// --------------------------------------------------------------------------
+
+func (s *DeadlockTestSuite) TestParse(t *C) {
+	d, err := deadlock.Parse(readStatus(t, "innodb-status-001.txt"))
+	t.Assert(err, IsNil)
+	t.Assert(d, NotNil)
+	expect := &deadlock.Deadlock{
+		Ts: "2014-11-20 10:00:01 7f2b4c0d5700",
+		Transactions: []*deadlock.Transaction{
+			{
+				Id:       "1234",
+				Active:   "5 sec",
+				ThreadId: "5",
+				QueryId:  "50",
+				Query:    "UPDATE t SET a=1 WHERE id=2",
+				Waits: &deadlock.Lock{
+					Type:  "RECORD",
+					Table: "`test`.`t`",
+					Index: "`PRIMARY`",
+					Mode:  "lock_mode X locks rec but not gap waiting",
+				},
+			},
+			{
+				Id:       "1235",
+				Active:   "8 sec",
+				ThreadId: "6",
+				QueryId:  "51",
+				Query:    "UPDATE t SET a=2 WHERE id=1",
+				Holds: &deadlock.Lock{
+					Type:  "RECORD",
+					Table: "`test`.`t`",
+					Index: "`PRIMARY`",
+					Mode:  "lock_mode X locks rec but not gap",
+				},
+				Waits: &deadlock.Lock{
+					Type:  "RECORD",
+					Table: "`test`.`t`",
+					Index: "`PRIMARY`",
+					Mode:  "lock_mode X locks rec but not gap waiting",
+				},
+			},
+		},
+		Victim: 1,
+	}
+	t.Check(d, DeepEquals, expect)
+	t.Check(d.Id(), Equals, "2014-11-20 10:00:01 7f2b4c0d5700 1234 1235")
+}
+
+func (s *DeadlockTestSuite) TestParseNoDeadlock(t *C) {
+	d, err := deadlock.Parse(readStatus(t, "innodb-status-002.txt"))
+	t.Check(err, IsNil)
+	t.Check(d, IsNil)
+}
+
+func (s *DeadlockTestSuite) TestParseTableLock(t *C) {
+	// MySQL 5.5 format with a table (AUTO-INC) lock.
+	d, err := deadlock.Parse(readStatus(t, "innodb-status-003.txt"))
+	t.Assert(err, IsNil)
+	t.Assert(d, NotNil)
+	t.Check(d.Ts, Equals, "141120 10:00:01")
+	t.Assert(d.Transactions, HasLen, 2)
+	t.Check(d.Transactions[0].Id, Equals, "4A2")
+	t.Check(d.Transactions[0].Holds, IsNil)
+	t.Check(d.Transactions[0].Waits, DeepEquals, &deadlock.Lock{
+		Type:  "TABLE",
+		Table: "`shop`.`orders`",
+		Mode:  "lock mode AUTO-INC waiting",
+	})
+	t.Check(d.Transactions[1].Holds.String(), Equals, "TABLE lock on `shop`.`orders`: lock mode AUTO-INC")
+	t.Check(d.Transactions[1].Waits.Index, Equals, "`GEN_CLUST_INDEX`")
+	t.Check(d.Victim, Equals, 2)
+}
+
+func (s *DeadlockTestSuite) TestMakeEvent(t *C) {
+	d, err := deadlock.Parse(readStatus(t, "innodb-status-001.txt"))
+	t.Assert(err, IsNil)
+	now := time.Unix(1416477601, 0)
+	e := deadlock.MakeEvent(d, proto.ServiceInstance{Service: "mysql", InstanceId: 1}, now)
+	t.Check(e.Ts, Equals, int64(1416477601))
+	t.Check(e.Type, Equals, deadlock.DEADLOCK)
+	t.Check(e.Severity, Equals, event.SEVERITY_WARNING)
+	t.Check(e.Message, Equals, "Deadlock between MySQL threads 5, 6 on `test`.`t`; rolled back transaction (1)")
+	t.Check(e.Details["victim"], Equals, "1")
+	t.Check(e.Details["trx2_query"], Equals, "UPDATE t SET a=2 WHERE id=1")
+	t.Check(e.Details["trx2_holds"], Equals, "RECORD lock on `test`.`t` index `PRIMARY`: lock_mode X locks rec but not gap")
+	_, ok := e.Details["trx1_holds"]
+	t.Check(ok, Equals, false)
+}
@@ -0,0 +1,244 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package deadlock
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event type
+const DEADLOCK = "deadlock"
+
+// Monitor checks LATEST DETECTED DEADLOCK in SHOW ENGINE INNODB STATUS every
+// interval and sends each new deadlock as an event.  The deadlock reported
+// when the monitor starts is old, so it isn't sent.
+type Monitor struct {
+	name   string
+	config *event.Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	checked   bool   // false until first successful check
+	lastId    string // Deadlock.Id() of last deadlock
+}
+
+func NewMonitor(name string, config *event.Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Deadlock monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	for {
+		m.logger.Debug("run:idle")
+		if m.lastId == "" {
+			m.status.Update(m.name, "Idle")
+		} else {
+			m.status.Update(m.name, "Idle (last deadlock at "+m.lastId+")")
+		}
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+			e, err := m.check(now)
+			if err != nil {
+				m.logger.Warn(err)
+				continue
+			}
+			if e != nil {
+				select {
+				case m.eventChan <- e:
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// check returns an event if the latest deadlock is new, else nil.
+func (m *Monitor) check(now time.Time) (*event.Event, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return nil, err
+	}
+	defer m.conn.Close()
+
+	var engine, name, status string
+	if err := m.conn.DB().QueryRow("SHOW /* percona-agent */ ENGINE INNODB STATUS").Scan(&engine, &name, &status); err != nil {
+		return nil, err
+	}
+	d, err := Parse(status)
+	if err != nil {
+		return nil, err
+	}
+
+	first := !m.checked
+	m.checked = true
+	if d == nil || d.Id() == m.lastId {
+		return nil, nil
+	}
+	m.lastId = d.Id()
+	if first {
+		return nil, nil // happened before the monitor started
+	}
+	it := proto.ServiceInstance{
+		Service:    m.config.Service,
+		InstanceId: m.config.InstanceId,
+	}
+	return MakeEvent(d, it, now), nil
+}
+
+// MakeEvent returns a deadlock event with the transactions and locks in its
+// details, e.g. trx1_query and trx1_waits.
+func MakeEvent(d *Deadlock, it proto.ServiceInstance, now time.Time) *event.Event {
+	details := map[string]string{
+		"ts": d.Ts,
+	}
+	if d.Victim > 0 {
+		details["victim"] = fmt.Sprintf("%d", d.Victim)
+	}
+	threads := make([]string, len(d.Transactions))
+	tables := []string{}
+	seen := make(map[string]bool)
+	for i, trx := range d.Transactions {
+		prefix := fmt.Sprintf("trx%d_", i+1)
+		details[prefix+"id"] = trx.Id
+		details[prefix+"active"] = trx.Active
+		details[prefix+"thread_id"] = trx.ThreadId
+		details[prefix+"query_id"] = trx.QueryId
+		details[prefix+"query"] = trx.Query
+		if trx.Holds != nil {
+			details[prefix+"holds"] = trx.Holds.String()
+		}
+		if trx.Waits != nil {
+			details[prefix+"waits"] = trx.Waits.String()
+			if !seen[trx.Waits.Table] {
+				tables = append(tables, trx.Waits.Table)
+				seen[trx.Waits.Table] = true
+			}
+		}
+		threads[i] = trx.ThreadId
+	}
+
+	msg := "Deadlock between MySQL threads " + strings.Join(threads, ", ")
+	if len(tables) > 0 {
+		msg += " on " + strings.Join(tables, ", ")
+	}
+	if d.Victim > 0 {
+		msg += fmt.Sprintf("; rolled back transaction (%d)", d.Victim)
+	}
+
+	e := &event.Event{
+		ServiceInstance: it,
+		Ts:              now.UTC().Unix(),
+		Monitor:         "deadlock",
+		Type:            DEADLOCK,
+		Severity:        event.SEVERITY_WARNING,
+		Message:         msg,
+		Details:         details,
+	}
+	return e
+}
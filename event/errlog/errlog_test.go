@@ -0,0 +1,148 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package errlog_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/event/errlog"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type ErrlogTestSuite struct {
+	tmpDir  string
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&ErrlogTestSuite{})
+
+func (s *ErrlogTestSuite) SetUpSuite(t *C) {
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-test")
+	t.Assert(err, IsNil)
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "event-errlog-test")
+}
+
+func (s *ErrlogTestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *ErrlogTestSuite) TestClassify(t *C) {
+	lines := map[string]string{
+		"14:22:11 UTC - mysqld got signal 11 ;":                                                                 errlog.CRASH,
+		"2014-11-20 10:00:01 1234 [Note] InnoDB: Starting crash recovery.":                                      errlog.CRASH_RECOVERY,
+		"InnoDB: Database was not shutdown normally!":                                                           errlog.CRASH_RECOVERY,
+		"2014-11-20 10:00:01 1234 [ERROR] InnoDB: Database page corruption on disk or a failed file read":       errlog.CORRUPTION,
+		"2014-11-20 10:00:01 1234 [Note] InnoDB: Transactions deadlock detected, dumping detailed information.": errlog.DEADLOCK,
+		"2014-11-20 10:00:01 1234 [Warning] Aborted connection 42 to db: 'app' user: 'app' host: 'web1'":        errlog.ABORTED_CONNECTION,
+		"2014-11-20 10:00:01 1234 [Note] /usr/sbin/mysqld: ready for connections.":                              errlog.STARTUP,
+		"2014-11-20 10:00:01 1234 [Note] /usr/sbin/mysqld: Shutdown complete":                                   errlog.SHUTDOWN,
+		"2014-11-20 10:00:01 1234 [ERROR] Can't open the mysql.plugin table.":                                   errlog.ERROR,
+	}
+	for line, expect := range lines {
+		got, _, ok := errlog.Classify(line)
+		t.Check(ok, Equals, true, Commentf(line))
+		t.Check(got, Equals, expect, Commentf(line))
+	}
+	_, _, ok := errlog.Classify("2014-11-20 10:00:01 1234 [Note] Event Scheduler: Loaded 0 events")
+	t.Check(ok, Equals, false)
+}
+
+func (s *ErrlogTestSuite) TestTail(t *C) {
+	file := filepath.Join(s.tmpDir, "mysqld.err")
+	t.Assert(ioutil.WriteFile(file, []byte("[ERROR] old error before the monitor started\n"), 0644), IsNil)
+
+	config := &errlog.Config{
+		Config: event.Config{
+			ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+			Monitor:         "errlog",
+			Interval:        1,
+		},
+		File: file,
+	}
+	m := errlog.NewMonitor("event-errlog-db1", config, s.logger, nil)
+	tickChan := make(chan time.Time)
+	eventChan := make(chan *event.Event, 10)
+	t.Assert(m.Start(tickChan, eventChan), IsNil)
+	defer m.Stop()
+
+	appendLog := func(lines string) {
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+		t.Assert(err, IsNil)
+		f.WriteString(lines)
+		f.Close()
+	}
+	getEvents := func() []*event.Event {
+		tickChan <- time.Now()
+		tickChan <- time.Now() // wait for the first check to finish
+		events := []*event.Event{}
+		for {
+			select {
+			case e := <-eventChan:
+				events = append(events, e)
+			default:
+				return events
+			}
+		}
+	}
+
+	// Old entries aren't events, and aborted connections are counted.
+	appendLog("[Warning] Aborted connection 1 to db: 'app'\n" +
+		"[Note] something normal\n" +
+		"[Warning] Aborted connection 2 to db: 'app'\n" +
+		"[ERROR] InnoDB: Database page corruption on disk\n" +
+		"[Note] InnoDB: Starting crash")
+	events := getEvents()
+	t.Assert(events, HasLen, 2)
+	t.Check(events[0].Type, Equals, errlog.ABORTED_CONNECTION)
+	t.Check(events[0].Message, Equals, "[Warning] Aborted connection 2 to db: 'app'")
+	t.Check(events[0].Details, DeepEquals, map[string]string{"count": "2"})
+	t.Check(events[1].Type, Equals, errlog.CORRUPTION)
+	t.Check(events[1].Severity, Equals, event.SEVERITY_ERROR)
+	t.Check(events[1].InstanceId, Equals, uint(1))
+
+	// Partial line is read when it's complete.
+	appendLog(" recovery.\n")
+	events = getEvents()
+	t.Assert(events, HasLen, 1)
+	t.Check(events[0].Type, Equals, errlog.CRASH_RECOVERY)
+	t.Check(events[0].Message, Equals, "[Note] InnoDB: Starting crash recovery.")
+
+	// Rotated: renamed and a new log created.
+	t.Assert(os.Rename(file, file+".1"), IsNil)
+	t.Assert(ioutil.WriteFile(file, []byte("[Note] mysqld: ready for connections.\n"), 0644), IsNil)
+	events = getEvents()
+	t.Assert(events, HasLen, 1)
+	t.Check(events[0].Type, Equals, errlog.STARTUP)
+}
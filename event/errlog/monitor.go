@@ -0,0 +1,313 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package errlog
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	DEFAULT_MAX_BYTES = 1024 * 1024
+)
+
+// Event types
+const (
+	CRASH              = "crash"
+	CRASH_RECOVERY     = "crash-recovery"
+	CORRUPTION         = "corruption"
+	DEADLOCK           = "deadlock"
+	ABORTED_CONNECTION = "aborted-connection"
+	STARTUP            = "startup"
+	SHUTDOWN           = "shutdown"
+	ERROR              = "error"
+)
+
+type class struct {
+	re       *regexp.Regexp
+	Type     string
+	Severity string
+}
+
+// Classes of error log entries, most specific first.  Entries that don't match
+// any are not events, e.g. most [Note] and [Warning] lines.
+var classes = []class{
+	{regexp.MustCompile(`mysqld got (signal|exception)`), CRASH, event.SEVERITY_ERROR},
+	{regexp.MustCompile(`(?i)corrupt|checksum mismatch|page .* is in the future`), CORRUPTION, event.SEVERITY_ERROR},
+	{regexp.MustCompile(`(?i)crash recovery|was not shut ?down normally`), CRASH_RECOVERY, event.SEVERITY_ERROR},
+	{regexp.MustCompile(`(?i)deadlock`), DEADLOCK, event.SEVERITY_WARNING},
+	{regexp.MustCompile(`Aborted connection`), ABORTED_CONNECTION, event.SEVERITY_WARNING},
+	{regexp.MustCompile(`ready for connections`), STARTUP, event.SEVERITY_INFO},
+	{regexp.MustCompile(`Shutdown complete`), SHUTDOWN, event.SEVERITY_INFO},
+	{regexp.MustCompile(`\[ERROR\]`), ERROR, event.SEVERITY_ERROR},
+}
+
+// Classify returns the event type and severity of an error log line, or false
+// if it's not an event.
+func Classify(line string) (string, string, bool) {
+	for _, c := range classes {
+		if c.re.MatchString(line) {
+			return c.Type, c.Severity, true
+		}
+	}
+	return "", "", false
+}
+
+// Monitor tails the MySQL error log and sends classified entries as events.
+// It starts at the end of the log, so old entries aren't sent, and follows the
+// log when it's rotated (renamed or truncated).
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	tail      *event.Tail // nil until the error log is found
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("MySQL error log monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	// Start at the end of the log, if it's known now.
+	if err := m.open(true); err != nil {
+		m.logger.Warn(err)
+	}
+
+	var lastTs int64
+	for {
+		m.logger.Debug("run:idle")
+		if m.tail == nil {
+			m.status.Update(m.name, "Idle (error log not found yet)")
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (%s at offset %d, last event at %s)", m.tail.File, m.tail.Offset(), time.Unix(lastTs, 0)))
+		}
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+			if m.tail == nil {
+				// MySQL wasn't up at start; start at the end now.
+				if err := m.open(true); err != nil {
+					m.logger.Warn(err)
+					continue
+				}
+			}
+			events, err := m.check(now)
+			if err != nil {
+				m.logger.Warn(err)
+			}
+			for _, e := range events {
+				select {
+				case m.eventChan <- e:
+					lastTs = e.Ts
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// open finds and stats the error log.  If atEnd is true, reading starts at its
+// end, else at the beginning.
+func (m *Monitor) open(atEnd bool) error {
+	file := m.config.File
+	if file == "" {
+		var err error
+		if file, err = m.logError(); err != nil {
+			return err
+		}
+	}
+	maxBytes := m.config.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DEFAULT_MAX_BYTES
+	}
+	tail := event.NewTail(file, maxBytes)
+	if err := tail.Open(atEnd); err != nil {
+		return err
+	}
+	m.tail = tail
+	return nil
+}
+
+// logError returns the absolute path of @@log_error.
+func (m *Monitor) logError() (string, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return "", err
+	}
+	defer m.conn.Close()
+	var file, datadir string
+	if err := m.conn.DB().QueryRow("SELECT /* percona-agent */ @@log_error, @@datadir").Scan(&file, &datadir); err != nil {
+		return "", err
+	}
+	if file == "" || file == "stderr" {
+		return "", fmt.Errorf("MySQL error log is %q; set File in the %s config", file, m.name)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(datadir, file)
+	}
+	return file, nil
+}
+
+// check reads the lines appended since the last check and returns their
+// events.  Aborted connections are counted in one event per check because
+// there can be many.
+func (m *Monitor) check(now time.Time) ([]*event.Event, error) {
+	lines, skipped, rotated, err := m.tail.Lines()
+	if rotated {
+		m.logger.Info("Error log rotated: " + m.tail.File)
+	}
+	if skipped > 0 {
+		// Too much was logged, e.g. a flood of aborted connections, so the
+		// most recent entries were read.
+		m.logger.Warn(fmt.Sprintf("Skipped %d bytes of %s", skipped, m.tail.File))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ts := now.UTC().Unix()
+	events := []*event.Event{}
+	var aborted *event.Event
+	abortedCount := 0
+	for _, line := range lines {
+		eventType, severity, ok := Classify(line)
+		if !ok {
+			continue
+		}
+		e := &event.Event{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    m.config.Service,
+				InstanceId: m.config.InstanceId,
+			},
+			Ts:       ts,
+			Monitor:  "errlog",
+			Type:     eventType,
+			Severity: severity,
+			Message:  strings.TrimSpace(line),
+		}
+		if eventType == ABORTED_CONNECTION {
+			if aborted == nil {
+				aborted = e
+				events = append(events, e)
+			}
+			aborted.Message = e.Message // last one
+			abortedCount++
+			continue
+		}
+		events = append(events, e)
+	}
+	if aborted != nil {
+		aborted.Details = map[string]string{"count": strconv.Itoa(abortedCount)}
+	}
+	return events, nil
+}
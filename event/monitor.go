@@ -0,0 +1,55 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package event
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"time"
+)
+
+type Monitor interface {
+	Start(tickChan chan time.Time, eventChan chan *Event) error
+	Stop() error
+	Status() map[string]string
+	TickChan() chan time.Time
+	Config() interface{}
+}
+
+type MonitorFactory interface {
+	Make(monitor, service string, instanceId uint, data []byte) (Monitor, error)
+}
+
+// Event severities
+const (
+	SEVERITY_INFO    = "info"
+	SEVERITY_WARNING = "warning"
+	SEVERITY_ERROR   = "error"
+)
+
+// An Event is something that happened to a service instance, e.g. crash
+// recovery in the MySQL error log.  Unlike mm metrics, events are sent as
+// they happen, not aggregated.
+type Event struct {
+	proto.ServiceInstance
+	Ts       int64  // UTC Unix timestamp
+	Monitor  string // type of monitor that found the event, e.g. errlog
+	Type     string // e.g. crash-recovery
+	Severity string
+	Message  string
+	Details  map[string]string `json:",omitempty"`
+}
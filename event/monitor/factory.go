@@ -0,0 +1,197 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/event/binlog"
+	"github.com/percona/percona-agent/event/cert"
+	"github.com/percona/percona-agent/event/deadlock"
+	"github.com/percona/percona-agent/event/errlog"
+	"github.com/percona/percona-agent/event/query"
+	"github.com/percona/percona-agent/event/restart"
+	"github.com/percona/percona-agent/event/schema"
+	"github.com/percona/percona-agent/event/variables"
+	"github.com/percona/percona-agent/instance"
+	mysqlConn "github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+type Factory struct {
+	logChan chan *proto.LogEntry
+	ir      *instance.Repo
+}
+
+func NewFactory(logChan chan *proto.LogEntry, ir *instance.Repo) *Factory {
+	f := &Factory{
+		logChan: logChan,
+		ir:      ir,
+	}
+	return f
+}
+
+func (f *Factory) Make(monitorType, service string, instanceId uint, data []byte) (event.Monitor, error) {
+	if service != "mysql" {
+		return nil, errors.New("Event monitors only support MySQL, not " + service)
+	}
+
+	// Load the MySQL instance info (DSN, name, etc.).
+	mysqlIt := &proto.MySQLInstance{}
+	if err := f.ir.Get(service, instanceId, mysqlIt); err != nil {
+		return nil, err
+	}
+
+	var monitor event.Monitor
+	switch monitorType {
+	case "errlog":
+		// Parse the error log monitor config.
+		config := &errlog.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-errlog-db101:
+		alias := "event-errlog-" + mysqlIt.Hostname
+
+		// Make a MySQL error log monitor.
+		monitor = errlog.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "deadlock":
+		config := &event.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-deadlock-db101:
+		alias := "event-deadlock-" + mysqlIt.Hostname
+
+		// Make an InnoDB deadlock monitor.
+		monitor = deadlock.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "query":
+		config := &query.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-query-db101:
+		alias := "event-query-" + mysqlIt.Hostname
+
+		// Make a long-running and blocked query monitor.
+		monitor = query.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "binlog":
+		config := &binlog.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-binlog-db101:
+		alias := "event-binlog-" + mysqlIt.Hostname
+
+		// Make a binlog disk headroom monitor.
+		monitor = binlog.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "restart":
+		config := &restart.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-restart-db101:
+		alias := "event-restart-" + mysqlIt.Hostname
+
+		// Make a MySQL restart and OOM monitor.
+		monitor = restart.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "cert":
+		config := &cert.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-cert-db101:
+		alias := "event-cert-" + mysqlIt.Hostname
+
+		// Make a TLS certificate expiry monitor.
+		monitor = cert.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "schema":
+		config := &schema.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-schema-db101:
+		alias := "event-schema-" + mysqlIt.Hostname
+
+		// Make a schema change monitor.
+		monitor = schema.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "variables":
+		config := &variables.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. event-variables-db101:
+		alias := "event-variables-" + mysqlIt.Hostname
+
+		// Make a global variable change monitor.
+		monitor = variables.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	default:
+		return nil, errors.New("Unknown event monitor type: " + monitorType)
+	}
+	return monitor, nil
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package query
+
+import (
+	"github.com/percona/percona-agent/event"
+)
+
+const (
+	DEFAULT_LONG_QUERY_TIME = 60 // seconds
+	DEFAULT_LOCK_WAIT_TIME  = 10 // seconds
+)
+
+type Config struct {
+	event.Config
+	LongQueryTime uint `json:",omitempty"` // report queries running longer, default 60s
+	LockWaitTime  uint `json:",omitempty"` // report lock waits longer, default 10s
+}
+
+func (c *Config) longQueryTime() uint {
+	if c.LongQueryTime == 0 {
+		return DEFAULT_LONG_QUERY_TIME
+	}
+	return c.LongQueryTime
+}
+
+func (c *Config) lockWaitTime() uint {
+	if c.LockWaitTime == 0 {
+		return DEFAULT_LOCK_WAIT_TIME
+	}
+	return c.LockWaitTime
+}
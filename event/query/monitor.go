@@ -0,0 +1,414 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event types
+const (
+	LONG_QUERY = "long-query"
+	LOCK_WAIT  = "lock-wait"
+)
+
+const longQuerySQL = "SELECT /* percona-agent */ ID, USER, HOST, DB, STATE, TIME, INFO" +
+	" FROM information_schema.PROCESSLIST" +
+	" WHERE COMMAND = 'Query' AND USER != 'system user' AND ID != CONNECTION_ID() AND TIME >= ?"
+
+// Lock waits are in information_schema.INNODB_LOCK_WAITS before MySQL 8.0 and
+// in performance_schema.data_lock_waits since.
+const lockWaitSQL = "SELECT /* percona-agent */" +
+	" r.trx_mysql_thread_id, r.trx_query, TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW())," +
+	" b.trx_mysql_thread_id, b.trx_query" +
+	" FROM information_schema.INNODB_LOCK_WAITS w" +
+	" JOIN information_schema.INNODB_TRX b ON b.trx_id = w.blocking_trx_id" +
+	" JOIN information_schema.INNODB_TRX r ON r.trx_id = w.requesting_trx_id"
+
+const lockWaitSQL80 = "SELECT /* percona-agent */" +
+	" r.trx_mysql_thread_id, r.trx_query, TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW())," +
+	" b.trx_mysql_thread_id, b.trx_query" +
+	" FROM performance_schema.data_lock_waits w" +
+	" JOIN information_schema.INNODB_TRX b ON b.trx_id = w.BLOCKING_ENGINE_TRANSACTION_ID" +
+	" JOIN information_schema.INNODB_TRX r ON r.trx_id = w.REQUESTING_ENGINE_TRANSACTION_ID"
+
+// LockWait is a transaction waiting for a lock held by another transaction.
+type LockWait struct {
+	ThreadId         uint64
+	Query            string
+	WaitTime         uint // seconds
+	BlockingThreadId uint64
+	BlockingQuery    string // empty if the blocking trx is idle
+}
+
+// Blocker is a thread at the head of a lock wait chain: it blocks other
+// threads but doesn't wait itself.
+type Blocker struct {
+	ThreadId uint64
+	Query    string
+	Waiting  []uint64 // all threads waiting on it, directly or not
+	MaxWait  uint     // longest wait of the waiting threads
+	Chain    []uint64 // longest chain, from a waiting thread to the blocker
+}
+
+// Blockers returns the blockers at the head of the lock wait chains.
+func Blockers(waits []LockWait) []*Blocker {
+	waitingFor := make(map[uint64]LockWait)
+	for _, w := range waits {
+		waitingFor[w.ThreadId] = w
+	}
+
+	blockers := make(map[uint64]*Blocker)
+	ids := []uint64{}
+	for _, w := range waits {
+		// Follow the chain to the thread that doesn't wait.
+		chain := []uint64{w.ThreadId}
+		seen := map[uint64]bool{w.ThreadId: true}
+		head := w
+		for {
+			next, ok := waitingFor[head.BlockingThreadId]
+			if !ok || seen[next.ThreadId] {
+				break
+			}
+			chain = append(chain, next.ThreadId)
+			seen[next.ThreadId] = true
+			head = next
+		}
+		chain = append(chain, head.BlockingThreadId)
+
+		b, ok := blockers[head.BlockingThreadId]
+		if !ok {
+			b = &Blocker{
+				ThreadId: head.BlockingThreadId,
+				Query:    head.BlockingQuery,
+				Waiting:  []uint64{},
+			}
+			blockers[b.ThreadId] = b
+			ids = append(ids, b.ThreadId)
+		}
+		b.Waiting = append(b.Waiting, w.ThreadId)
+		if w.WaitTime > b.MaxWait {
+			b.MaxWait = w.WaitTime
+		}
+		if len(chain) > len(b.Chain) {
+			b.Chain = chain
+		}
+	}
+
+	sort.Sort(byId(ids))
+	list := make([]*Blocker, len(ids))
+	for i, id := range ids {
+		list[i] = blockers[id]
+	}
+	return list
+}
+
+type byId []uint64
+
+func (a byId) Len() int           { return len(a) }
+func (a byId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byId) Less(i, j int) bool { return a[i] < a[j] }
+
+// Monitor samples the processlist and InnoDB lock waits every interval, which
+// should be short (a few seconds), and sends an event when a query runs longer
+// than LongQueryTime or a thread blocks others longer than LockWaitTime.  Each
+// long query and blocker is reported once, not every interval.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan    chan time.Time
+	eventChan   chan *event.Event
+	status      *pct.Status
+	sync        *pct.SyncChan
+	running     bool
+	lockWaitSQL string
+	reported    map[string]bool
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:        pct.NewSyncChan(),
+		status:      pct.NewStatus([]string{name}),
+		lockWaitSQL: lockWaitSQL,
+		reported:    make(map[string]bool),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Query monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, fmt.Sprintf("Idle (%d long queries and blockers)", len(m.reported)))
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+			events, err := m.check(now)
+			if err != nil {
+				m.logger.Warn(err)
+			}
+			for _, e := range events {
+				select {
+				case m.eventChan <- e:
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// check returns events for long queries and blockers not reported yet.
+func (m *Monitor) check(now time.Time) ([]*event.Event, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return nil, err
+	}
+	defer m.conn.Close()
+
+	ts := now.UTC().Unix()
+	it := proto.ServiceInstance{
+		Service:    m.config.Service,
+		InstanceId: m.config.InstanceId,
+	}
+	events := []*event.Event{}
+	reported := make(map[string]bool)
+
+	longQueries, err := m.longQueries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range longQueries {
+		key := LONG_QUERY + " " + e.Details["thread_id"] + " " + e.Details["query"]
+		reported[key] = true
+		if !m.reported[key] {
+			e.ServiceInstance = it
+			e.Ts = ts
+			events = append(events, e)
+		}
+	}
+
+	waits, err := m.lockWaits()
+	if err != nil {
+		// Keep the reported blockers, else they're reported again.
+		for key := range m.reported {
+			if strings.HasPrefix(key, LOCK_WAIT) {
+				reported[key] = true
+			}
+		}
+		m.reported = reported
+		return events, err
+	}
+	for _, b := range Blockers(waits) {
+		if b.MaxWait < m.config.lockWaitTime() {
+			continue
+		}
+		key := fmt.Sprintf("%s %d", LOCK_WAIT, b.ThreadId)
+		reported[key] = true
+		if !m.reported[key] {
+			e := MakeLockWaitEvent(b)
+			e.ServiceInstance = it
+			e.Ts = ts
+			events = append(events, e)
+		}
+	}
+
+	// Forget queries and blockers that are gone so they're reported again if
+	// they come back.
+	m.reported = reported
+	return events, nil
+}
+
+func (m *Monitor) longQueries() ([]*event.Event, error) {
+	rows, err := m.conn.DB().Query(longQuerySQL, m.config.longQueryTime())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*event.Event{}
+	for rows.Next() {
+		var id uint64
+		var runTime uint
+		var user, host, db, state, info sql.NullString
+		if err := rows.Scan(&id, &user, &host, &db, &state, &runTime, &info); err != nil {
+			return nil, err
+		}
+		e := &event.Event{
+			Monitor:  "query",
+			Type:     LONG_QUERY,
+			Severity: event.SEVERITY_WARNING,
+			Message:  fmt.Sprintf("MySQL thread %d running query for %ds: %s", id, runTime, info.String),
+			Details: map[string]string{
+				"thread_id": fmt.Sprintf("%d", id),
+				"query":     info.String,
+				"user":      user.String,
+				"host":      host.String,
+				"db":        db.String,
+				"state":     state.String,
+				"time":      fmt.Sprintf("%d", runTime),
+			},
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (m *Monitor) lockWaits() ([]LockWait, error) {
+	rows, err := m.conn.DB().Query(m.lockWaitSQL)
+	if err != nil && mysql.MySQLErrorCode(err) == mysql.ER_UNKNOWN_TABLE && m.lockWaitSQL == lockWaitSQL {
+		m.logger.Info("No INNODB_LOCK_WAITS, using performance_schema.data_lock_waits")
+		m.lockWaitSQL = lockWaitSQL80
+		rows, err = m.conn.DB().Query(m.lockWaitSQL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	waits := []LockWait{}
+	for rows.Next() {
+		w := LockWait{}
+		var query, blockingQuery sql.NullString
+		var waitTime sql.NullInt64
+		if err := rows.Scan(&w.ThreadId, &query, &waitTime, &w.BlockingThreadId, &blockingQuery); err != nil {
+			return nil, err
+		}
+		w.Query = query.String
+		w.WaitTime = uint(waitTime.Int64)
+		w.BlockingQuery = blockingQuery.String
+		waits = append(waits, w)
+	}
+	return waits, rows.Err()
+}
+
+// MakeLockWaitEvent returns a lock wait event for the blocker.  The service
+// instance and timestamp are not set.
+func MakeLockWaitEvent(b *Blocker) *event.Event {
+	waiting := make([]string, len(b.Waiting))
+	for i, id := range b.Waiting {
+		waiting[i] = fmt.Sprintf("%d", id)
+	}
+	chain := make([]string, len(b.Chain))
+	for i, id := range b.Chain {
+		chain[i] = fmt.Sprintf("%d", id)
+	}
+	query := b.Query
+	if query == "" {
+		query = "idle transaction"
+	}
+	e := &event.Event{
+		Monitor:  "query",
+		Type:     LOCK_WAIT,
+		Severity: event.SEVERITY_WARNING,
+		Message:  fmt.Sprintf("MySQL thread %d blocks %d threads for up to %ds: %s", b.ThreadId, len(b.Waiting), b.MaxWait, query),
+		Details: map[string]string{
+			"blocking_thread_id": fmt.Sprintf("%d", b.ThreadId),
+			"blocking_query":     b.Query,
+			"waiting_thread_ids": strings.Join(waiting, ","),
+			"max_wait_time":      fmt.Sprintf("%d", b.MaxWait),
+			"chain":              strings.Join(chain, " -> "), // waits for
+		},
+	}
+	return e
+}
@@ -0,0 +1,86 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package query_test
+
+import (
+	"testing"
+
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/event/query"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type QueryTestSuite struct{}
+
+var _ = Suite(&QueryTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *QueryTestSuite) TestBlockers(t *C) {
+	// 12 waits for 11 which waits for 10, and 13 waits for 10.  20 waits for
+	// 21, an idle transaction.
+	waits := []query.LockWait{
+		{ThreadId: 12, Query: "UPDATE t SET a=3", WaitTime: 2, BlockingThreadId: 11, BlockingQuery: "UPDATE t SET a=2"},
+		{ThreadId: 11, Query: "UPDATE t SET a=2", WaitTime: 30, BlockingThreadId: 10, BlockingQuery: "UPDATE t SET a=1"},
+		{ThreadId: 13, Query: "DELETE FROM t", WaitTime: 5, BlockingThreadId: 10, BlockingQuery: "UPDATE t SET a=1"},
+		{ThreadId: 20, Query: "UPDATE u SET b=1", WaitTime: 1, BlockingThreadId: 21},
+	}
+	got := query.Blockers(waits)
+	expect := []*query.Blocker{
+		{
+			ThreadId: 10,
+			Query:    "UPDATE t SET a=1",
+			Waiting:  []uint64{12, 11, 13},
+			MaxWait:  30,
+			Chain:    []uint64{12, 11, 10},
+		},
+		{
+			ThreadId: 21,
+			Query:    "",
+			Waiting:  []uint64{20},
+			MaxWait:  1,
+			Chain:    []uint64{20, 21},
+		},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	t.Check(query.Blockers([]query.LockWait{}), HasLen, 0)
+}
+
+func (s *QueryTestSuite) TestMakeLockWaitEvent(t *C) {
+	b := &query.Blocker{
+		ThreadId: 21,
+		Waiting:  []uint64{20, 22},
+		MaxWait:  15,
+		Chain:    []uint64{22, 20, 21},
+	}
+	e := query.MakeLockWaitEvent(b)
+	t.Check(e.Type, Equals, query.LOCK_WAIT)
+	t.Check(e.Severity, Equals, event.SEVERITY_WARNING)
+	t.Check(e.Message, Equals, "MySQL thread 21 blocks 2 threads for up to 15s: idle transaction")
+	t.Check(e.Details, DeepEquals, map[string]string{
+		"blocking_thread_id": "21",
+		"blocking_query":     "",
+		"waiting_thread_ids": "20,22",
+		"max_wait_time":      "15",
+		"chain":              "22 -> 20 -> 21",
+	})
+}
@@ -0,0 +1,30 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package restart
+
+import (
+	"github.com/percona/percona-agent/event"
+)
+
+// Kernel logs searched for OOM killer traces if Config.KernelLog isn't set.
+var DEFAULT_KERNEL_LOGS = []string{"/var/log/kern.log", "/var/log/messages"}
+
+type Config struct {
+	event.Config
+	KernelLog string `json:",omitempty"` // default first of DEFAULT_KERNEL_LOGS that exists
+}
@@ -0,0 +1,323 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package restart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event type
+const RESTART = "mysql-restart"
+
+// Probable causes of a restart
+const (
+	CAUSE_OOM     = "oom-killer"
+	CAUSE_UNKNOWN = "unknown"
+)
+
+const MAX_KERNEL_LOG_BYTES = 1024 * 1024
+
+// OOM killer traces, e.g. "Out of memory: Kill process 1234 (mysqld) score 900
+// or sacrifice child", "Killed process 1234 (mysqld) total-vm:...", and
+// "oom-kill:constraint=CONSTRAINT_NONE,...,task=mysqld,pid=1234,uid=27".
+var oomRe = []*regexp.Regexp{
+	regexp.MustCompile(`Kill(?:ed)? process (\d+) \(([^)]+)\)`),
+	regexp.MustCompile(`oom-kill:.*task=([^,]+),pid=(\d+)`),
+}
+
+// OOM is an OOM killer trace in the kernel log.
+type OOM struct {
+	Pid  int
+	Comm string // process name, e.g. mysqld
+	Line string
+}
+
+// ParseOOM returns the process killed by the OOM killer if the kernel log line
+// is an OOM killer trace.
+func ParseOOM(line string) (*OOM, bool) {
+	if m := oomRe[0].FindStringSubmatch(line); m != nil {
+		pid, _ := strconv.Atoi(m[1])
+		return &OOM{Pid: pid, Comm: m[2], Line: strings.TrimSpace(line)}, true
+	}
+	if m := oomRe[1].FindStringSubmatch(line); m != nil {
+		pid, _ := strconv.Atoi(m[2])
+		return &OOM{Pid: pid, Comm: m[1], Line: strings.TrimSpace(line)}, true
+	}
+	return nil, false
+}
+
+// Monitor detects MySQL restarts: its uptime resets or its PID changes.  It
+// also tails the kernel log for OOM killer traces, so a restart after mysqld
+// was killed is reported with that probable cause.  The PID and kernel log are
+// only available if MySQL is on this host.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	kernLog   *event.Tail // nil if no kernel log
+	uptime    int64       // at last check, 0 before first check
+	pid       int         // at last check, 0 if unknown
+	ooms      []*OOM      // since last check that MySQL was up
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+		ooms:   []*OOM{},
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("MySQL restart monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	m.openKernelLog()
+
+	idle := "Idle"
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, idle)
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+
+			// Read the kernel log every check, even if MySQL is down, so
+			// OOM killer traces aren't missed.
+			m.readKernelLog()
+
+			e, err := m.check(now)
+			if err != nil {
+				idle = "Idle (MySQL is down: " + err.Error() + ")"
+				continue
+			}
+			idle = fmt.Sprintf("Idle (uptime %ds, PID %d)", m.uptime, m.pid)
+			if e != nil {
+				select {
+				case m.eventChan <- e:
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+func (m *Monitor) openKernelLog() {
+	files := DEFAULT_KERNEL_LOGS
+	if m.config.KernelLog != "" {
+		files = []string{m.config.KernelLog}
+	}
+	for _, file := range files {
+		tail := event.NewTail(file, MAX_KERNEL_LOG_BYTES)
+		if err := tail.Open(true); err == nil {
+			m.kernLog = tail
+			return
+		}
+	}
+	m.logger.Info("No kernel log, cannot detect OOM killer:", strings.Join(files, ", "))
+}
+
+func (m *Monitor) readKernelLog() {
+	if m.kernLog == nil {
+		return
+	}
+	lines, _, _, err := m.kernLog.Lines()
+	if err != nil {
+		m.logger.Warn(err)
+		return
+	}
+	for _, line := range lines {
+		if oom, ok := ParseOOM(line); ok {
+			m.ooms = append(m.ooms, oom)
+		}
+	}
+}
+
+// check returns an event if MySQL restarted since the last check, or an error
+// if MySQL is down.
+func (m *Monitor) check(now time.Time) (*event.Event, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return nil, err
+	}
+	defer m.conn.Close()
+
+	var varName string
+	var uptime int64
+	if err := m.conn.DB().QueryRow("SHOW /*!50002 GLOBAL */ STATUS LIKE 'Uptime'").Scan(&varName, &uptime); err != nil {
+		return nil, err
+	}
+	pid := 0
+	var pidFile string
+	if err := m.conn.DB().QueryRow("SELECT @@GLOBAL.pid_file").Scan(&pidFile); err == nil {
+		pid = readPid(pidFile)
+	}
+
+	prevUptime, prevPid := m.uptime, m.pid
+	ooms := m.ooms
+	m.uptime, m.pid = uptime, pid
+	m.ooms = []*OOM{}
+
+	if prevUptime == 0 {
+		return nil, nil // first check
+	}
+	restarted := uptime < prevUptime || (pid != 0 && prevPid != 0 && pid != prevPid)
+	if !restarted {
+		return nil, nil
+	}
+
+	// The OOM killer killed the old mysqld, or some mysqld if its PID
+	// isn't known.
+	var oom *OOM
+	for _, o := range ooms {
+		if (prevPid != 0 && o.Pid == prevPid) || (prevPid == 0 && o.Comm == "mysqld") {
+			oom = o
+		}
+	}
+
+	e := &event.Event{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    m.config.Service,
+			InstanceId: m.config.InstanceId,
+		},
+		Ts:       now.UTC().Unix(),
+		Monitor:  "restart",
+		Type:     RESTART,
+		Severity: event.SEVERITY_WARNING,
+		Message:  "MySQL restarted",
+		Details: map[string]string{
+			"cause":           CAUSE_UNKNOWN,
+			"uptime":          fmt.Sprintf("%d", uptime),
+			"previous_uptime": fmt.Sprintf("%d", prevUptime),
+		},
+	}
+	if pid != 0 {
+		e.Details["pid"] = fmt.Sprintf("%d", pid)
+	}
+	if prevPid != 0 {
+		e.Details["previous_pid"] = fmt.Sprintf("%d", prevPid)
+	}
+	if oom != nil {
+		e.Severity = event.SEVERITY_ERROR
+		e.Message = "MySQL restarted after the OOM killer killed mysqld"
+		e.Details["cause"] = CAUSE_OOM
+		e.Details["oom"] = oom.Line
+	}
+	return e, nil
+}
+
+// readPid returns the PID in the pid file, or 0 if it can't be read, e.g.
+// because MySQL is on another host.
+func readPid(file string) int {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
@@ -0,0 +1,65 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package restart_test
+
+import (
+	"testing"
+
+	"github.com/percona/percona-agent/event/restart"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type RestartTestSuite struct{}
+
+var _ = Suite(&RestartTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *RestartTestSuite) TestParseOOM(t *C) {
+	lines := map[string]*restart.OOM{
+		"Nov 20 10:00:01 db1 kernel: [123.456] Out of memory: Kill process 1234 (mysqld) score 900 or sacrifice child": {
+			Pid:  1234,
+			Comm: "mysqld",
+		},
+		"Nov 20 10:00:01 db1 kernel: [123.457] Killed process 1234 (mysqld) total-vm:8000000kB, anon-rss:7000000kB": {
+			Pid:  1234,
+			Comm: "mysqld",
+		},
+		"Nov 20 10:00:01 db1 kernel: oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,task=mysqld,pid=5678,uid=27": {
+			Pid:  5678,
+			Comm: "mysqld",
+		},
+		"Nov 20 10:00:01 db1 kernel: Memory cgroup out of memory: Kill process 42 (java) score 1000 or sacrifice child": {
+			Pid:  42,
+			Comm: "java",
+		},
+	}
+	for line, expect := range lines {
+		oom, ok := restart.ParseOOM(line)
+		t.Assert(ok, Equals, true, Commentf(line))
+		t.Check(oom.Pid, Equals, expect.Pid, Commentf(line))
+		t.Check(oom.Comm, Equals, expect.Comm, Commentf(line))
+		t.Check(oom.Line, Equals, line)
+	}
+
+	_, ok := restart.ParseOOM("Nov 20 10:00:01 db1 kernel: e1000e: eth0 NIC Link is Up")
+	t.Check(ok, Equals, false)
+}
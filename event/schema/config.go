@@ -0,0 +1,37 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema
+
+import (
+	"github.com/percona/percona-agent/event"
+)
+
+const DEFAULT_MAX_TABLES = 1000
+
+type Config struct {
+	event.Config
+	Schemas   []string `json:",omitempty"` // all but system schemas if empty
+	MaxTables uint     `json:",omitempty"` // tables to track at most, default 1000
+}
+
+func (c *Config) maxTables() int {
+	if c.MaxTables == 0 {
+		return DEFAULT_MAX_TABLES
+	}
+	return int(c.MaxTables)
+}
@@ -0,0 +1,362 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event types
+const (
+	CHANGED = "schema-changed"
+	CREATED = "table-created"
+	DROPPED = "table-dropped"
+)
+
+// Diffs longer than this are truncated in event details.
+const MAX_DIFF_LINES = 100
+
+// Schemas not tracked when Config.Schemas is empty.
+var SystemSchemas []string = []string{"mysql", "information_schema", "performance_schema", "sys"}
+
+var autoIncRe = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// Normalize removes the parts of SHOW CREATE TABLE that change without DDL,
+// i.e. the next AUTO_INCREMENT value.
+func Normalize(createTable string) string {
+	return autoIncRe.ReplaceAllString(strings.TrimSpace(createTable), "")
+}
+
+// Hash returns the SHA1 of a normalized table definition.
+func Hash(createTable string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(createTable)))
+}
+
+// A Change is a table created, dropped, or changed between two checks.
+type Change struct {
+	Table string // db.table
+	Type  string // CHANGED, CREATED, or DROPPED
+	Old   string // normalized definition, "" if created
+	New   string // normalized definition, "" if dropped
+}
+
+// Compare returns the changes from old to new table definitions, keyed on
+// db.table, sorted by table.
+func Compare(old, new map[string]string) []Change {
+	changes := []Change{}
+	for table, def := range new {
+		oldDef, ok := old[table]
+		if !ok {
+			changes = append(changes, Change{Table: table, Type: CREATED, New: def})
+		} else if Hash(oldDef) != Hash(def) {
+			changes = append(changes, Change{Table: table, Type: CHANGED, Old: oldDef, New: def})
+		}
+	}
+	for table, def := range old {
+		if _, ok := new[table]; !ok {
+			changes = append(changes, Change{Table: table, Type: DROPPED, Old: def})
+		}
+	}
+	sort.Sort(changesByTable(changes))
+	return changes
+}
+
+// Diff returns a line diff of two table definitions: removed lines start
+// with "-", added lines with "+", and unchanged lines are omitted.
+func Diff(old, new string) string {
+	a := strings.Split(old, "\n")
+	b := strings.Split(new, "\n")
+
+	// Longest common subsequence of lines; definitions are small.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, "+"+b[j])
+			j++
+		default:
+			diff = append(diff, "-"+a[i])
+			i++
+		}
+	}
+	if len(diff) > MAX_DIFF_LINES {
+		diff = append(diff[:MAX_DIFF_LINES], fmt.Sprintf("(%d more lines)", len(diff)-MAX_DIFF_LINES))
+	}
+	return strings.Join(diff, "\n")
+}
+
+// Monitor tracks SHOW CREATE TABLE of the tables in the configured schemas
+// every interval and sends an event with a diff for every table created,
+// dropped, or altered, so query regressions can be correlated with DDL.  The
+// first check is the baseline, so DDL while the monitor isn't running isn't
+// reported.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	tables    map[string]string // normalized definitions at last check, nil before first check
+	truncated bool              // warned about MaxTables
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Schema monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, "Idle")
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+
+			tables, err := m.getTables()
+			if err != nil {
+				m.logger.Warn("Cannot get table definitions: ", err)
+				continue
+			}
+			if m.tables != nil {
+				for _, c := range Compare(m.tables, tables) {
+					e := m.makeEvent(c, now)
+					select {
+					case m.eventChan <- e:
+					case <-time.After(500 * time.Millisecond):
+						m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+					}
+				}
+			}
+			m.tables = tables
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// getTables returns the normalized definition of every base table in the
+// configured schemas, keyed on db.table.
+func (m *Monitor) getTables() (map[string]string, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return nil, err
+	}
+	defer m.conn.Close()
+
+	in := m.config.Schemas
+	not := ""
+	if len(in) == 0 {
+		in = SystemSchemas
+		not = "NOT "
+	}
+	args := make([]interface{}, len(in))
+	for i, s := range in {
+		args[i] = s
+	}
+	query := fmt.Sprintf("SELECT /* percona-agent */ TABLE_SCHEMA, TABLE_NAME"+
+		" FROM information_schema.tables"+
+		" WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA %sIN (%s)"+
+		" ORDER BY TABLE_SCHEMA, TABLE_NAME",
+		not, strings.TrimSuffix(strings.Repeat("?,", len(in)), ","))
+	rows, err := m.conn.DB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	names := [][2]string{}
+	for rows.Next() {
+		var db, table string
+		if err := rows.Scan(&db, &table); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, [2]string{db, table})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if max := m.config.maxTables(); len(names) > max {
+		if !m.truncated {
+			m.logger.Warn(fmt.Sprintf("Tracking only the first %d of %d tables (MaxTables)", max, len(names)))
+			m.truncated = true
+		}
+		names = names[:max]
+	}
+
+	tables := make(map[string]string, len(names))
+	for _, name := range names {
+		var table, def string
+		query := fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", name[0], name[1])
+		if err := m.conn.DB().QueryRow(query).Scan(&table, &def); err != nil {
+			// Dropped since listed, or no privilege: the next check will tell.
+			m.logger.Debug(query, ": ", err)
+			continue
+		}
+		tables[name[0]+"."+name[1]] = Normalize(def)
+	}
+	return tables, nil
+}
+
+func (m *Monitor) makeEvent(c Change, now time.Time) *event.Event {
+	e := &event.Event{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    m.config.Service,
+			InstanceId: m.config.InstanceId,
+		},
+		Ts:       now.UTC().Unix(),
+		Monitor:  "schema",
+		Type:     c.Type,
+		Severity: event.SEVERITY_INFO,
+		Details: map[string]string{
+			"table": c.Table,
+		},
+	}
+	switch c.Type {
+	case CREATED:
+		e.Message = "Table " + c.Table + " created"
+		e.Details["new_hash"] = Hash(c.New)
+		e.Details["definition"] = c.New
+	case DROPPED:
+		e.Message = "Table " + c.Table + " dropped"
+		e.Details["old_hash"] = Hash(c.Old)
+	default:
+		e.Message = "Table " + c.Table + " changed"
+		e.Details["old_hash"] = Hash(c.Old)
+		e.Details["new_hash"] = Hash(c.New)
+		e.Details["diff"] = Diff(c.Old, c.New)
+	}
+	return e
+}
+
+type changesByTable []Change
+
+func (a changesByTable) Len() int           { return len(a) }
+func (a changesByTable) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a changesByTable) Less(i, j int) bool { return a[i].Table < a[j].Table }
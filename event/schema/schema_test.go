@@ -0,0 +1,91 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema_test
+
+import (
+	"testing"
+
+	"github.com/percona/percona-agent/event/schema"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type SchemaTestSuite struct{}
+
+var _ = Suite(&SchemaTestSuite{})
+
+var t1 = "CREATE TABLE `t1` (\n" +
+	"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+	"  `name` varchar(64) DEFAULT NULL,\n" +
+	"  PRIMARY KEY (`id`)\n" +
+	") ENGINE=InnoDB AUTO_INCREMENT=42 DEFAULT CHARSET=utf8"
+
+// --------------------------------------------------------------------------
+
+func (s *SchemaTestSuite) TestNormalize(t *C) {
+	// AUTO_INCREMENT=N changes on insert, not DDL.
+	t1more := schema.Normalize(t1[:len(t1)-len("42 DEFAULT CHARSET=utf8")] + "1042 DEFAULT CHARSET=utf8")
+	t.Check(schema.Normalize(t1), Equals, t1more)
+	t.Check(schema.Hash(schema.Normalize(t1)), Equals, schema.Hash(t1more))
+	t.Check(schema.Normalize(t1), Not(Matches), "(?s).*AUTO_INCREMENT=.*")
+	t.Check(schema.Normalize(t1), Matches, "(?s).*`id` int\\(11\\) NOT NULL AUTO_INCREMENT,.*")
+}
+
+func (s *SchemaTestSuite) TestCompare(t *C) {
+	old := map[string]string{
+		"db.t1": schema.Normalize(t1),
+		"db.t2": "CREATE TABLE `t2` (`a` int)",
+		"db.t3": "CREATE TABLE `t3` (`a` int)",
+	}
+	new := map[string]string{
+		"db.t1": schema.Normalize(t1),
+		"db.t2": "CREATE TABLE `t2` (`a` bigint)",
+		"db.t4": "CREATE TABLE `t4` (`a` int)",
+	}
+	got := schema.Compare(old, new)
+	t.Check(got, DeepEquals, []schema.Change{
+		{Table: "db.t2", Type: schema.CHANGED, Old: "CREATE TABLE `t2` (`a` int)", New: "CREATE TABLE `t2` (`a` bigint)"},
+		{Table: "db.t3", Type: schema.DROPPED, Old: "CREATE TABLE `t3` (`a` int)"},
+		{Table: "db.t4", Type: schema.CREATED, New: "CREATE TABLE `t4` (`a` int)"},
+	})
+
+	t.Check(schema.Compare(old, old), HasLen, 0)
+}
+
+func (s *SchemaTestSuite) TestDiff(t *C) {
+	old := schema.Normalize(t1)
+	new := "CREATE TABLE `t1` (\n" +
+		"  `id` int(11) NOT NULL AUTO_INCREMENT,\n" +
+		"  `name` varchar(128) DEFAULT NULL,\n" +
+		"  `email` varchar(255) DEFAULT NULL,\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  KEY `idx_email` (`email`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+	got := schema.Diff(old, new)
+	t.Check(got, Equals,
+		"-  `name` varchar(64) DEFAULT NULL,\n"+
+			"-  PRIMARY KEY (`id`)\n"+
+			"+  `name` varchar(128) DEFAULT NULL,\n"+
+			"+  `email` varchar(255) DEFAULT NULL,\n"+
+			"+  PRIMARY KEY (`id`),\n"+
+			"+  KEY `idx_email` (`email`)")
+
+	t.Check(schema.Diff(old, old), Equals, "")
+}
@@ -0,0 +1,108 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package event
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// Tail reads the lines appended to a log file, like tail -F, for monitors that
+// check logs every interval.  It follows the log when it's rotated (renamed or
+// truncated).
+type Tail struct {
+	File     string
+	MaxBytes int64 // most bytes read per Lines call
+	// --
+	fileInfo os.FileInfo
+	offset   int64
+}
+
+func NewTail(file string, maxBytes int64) *Tail {
+	t := &Tail{
+		File:     file,
+		MaxBytes: maxBytes,
+	}
+	return t
+}
+
+// Open stats the file.  If atEnd is true, reading starts at its end, i.e.
+// existing lines aren't read, else at the beginning.
+func (t *Tail) Open(atEnd bool) error {
+	fi, err := os.Stat(t.File)
+	if err != nil {
+		return err
+	}
+	t.fileInfo = fi
+	t.offset = 0
+	if atEnd {
+		t.offset = fi.Size()
+	}
+	return nil
+}
+
+// Offset returns the offset of the next line to read.
+func (t *Tail) Offset() int64 {
+	return t.offset
+}
+
+// Lines returns the complete lines appended since the last call; a partial
+// last line is returned when it's complete.  If more than MaxBytes were
+// appended, the older bytes are skipped and their number returned.  rotated is
+// true if the file was rotated, in which case lines are read from the
+// beginning of the new file.
+func (t *Tail) Lines() (lines []string, skipped int64, rotated bool, err error) {
+	fi, err := os.Stat(t.File)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if t.fileInfo == nil || !os.SameFile(fi, t.fileInfo) || fi.Size() < t.offset {
+		rotated = t.fileInfo != nil
+		t.fileInfo = fi
+		t.offset = 0
+	}
+	if fi.Size() == t.offset {
+		return nil, 0, rotated, nil
+	}
+
+	f, err := os.Open(t.File)
+	if err != nil {
+		return nil, 0, rotated, err
+	}
+	defer f.Close()
+	n := fi.Size() - t.offset
+	if t.MaxBytes > 0 && n > t.MaxBytes {
+		skipped = n - t.MaxBytes
+		t.offset = fi.Size() - t.MaxBytes
+		n = t.MaxBytes
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, t.offset); err != nil && err != io.EOF {
+		return nil, skipped, rotated, err
+	}
+
+	// Only complete lines; a partial last line is read next call.
+	end := bytes.LastIndex(buf, []byte("\n"))
+	if end < 0 {
+		return nil, skipped, rotated, nil
+	}
+	t.offset += int64(end + 1)
+	return strings.Split(string(buf[:end]), "\n"), skipped, rotated, nil
+}
@@ -0,0 +1,42 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package variables
+
+import (
+	"github.com/percona/percona-agent/event"
+)
+
+// Variables that change without anyone changing them, ignored by default.
+var DEFAULT_IGNORE []string = []string{"gtid_executed", "gtid_purged"}
+
+type Config struct {
+	event.Config
+	Ignore []string `json:",omitempty"` // variables not to track, default DEFAULT_IGNORE
+}
+
+func (c *Config) ignore() map[string]bool {
+	vars := c.Ignore
+	if vars == nil {
+		vars = DEFAULT_IGNORE
+	}
+	ignore := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		ignore[v] = true
+	}
+	return ignore
+}
@@ -0,0 +1,339 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package variables
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Event type
+const CHANGED = "variable-changed"
+
+// Changes to these variables affect durability or availability, so they're
+// warnings, not info.
+var WARN_VARIABLES []string = []string{
+	"innodb_flush_log_at_trx_commit",
+	"sync_binlog",
+	"innodb_doublewrite",
+	"read_only",
+	"super_read_only",
+	"offline_mode",
+	"max_connections",
+	"binlog_format",
+}
+
+// A Change is a global variable with a new value.
+type Change struct {
+	Name    string
+	Old     string
+	New     string
+	User    string // who set it, from performance_schema.variables_info (MySQL 8.0)
+	Host    string
+	SetTime string
+}
+
+// Compare returns the variables with different values, sorted by name.
+// Variables that appear or disappear, e.g. when a plugin is loaded, are
+// changes from or to "".
+func Compare(old, new map[string]string, ignore map[string]bool) []Change {
+	changes := []Change{}
+	for name, val := range new {
+		if ignore[name] {
+			continue
+		}
+		if oldVal := old[name]; oldVal != val {
+			changes = append(changes, Change{Name: name, Old: oldVal, New: val})
+		}
+	}
+	for name, val := range old {
+		if _, ok := new[name]; !ok && !ignore[name] {
+			changes = append(changes, Change{Name: name, Old: val})
+		}
+	}
+	sort.Sort(changesByName(changes))
+	return changes
+}
+
+// Monitor compares SHOW GLOBAL VARIABLES every interval with the previous
+// interval and sends an event for every variable that changed, with who
+// changed it if MySQL records that.  The first check is the baseline.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan  chan time.Time
+	eventChan chan *event.Event
+	status    *pct.Status
+	sync      *pct.SyncChan
+	running   bool
+	vars      map[string]string // at last check, nil before first check
+	noSetUser bool              // variables_info doesn't exist, don't query it again
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, eventChan chan *event.Event) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.eventChan = eventChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Variables monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	ignore := m.config.ignore()
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, "Idle")
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:check:start")
+			m.status.Update(m.name, "Running")
+
+			events, err := m.check(ignore, now)
+			if err != nil {
+				m.logger.Warn("Cannot get global variables: ", err)
+				continue
+			}
+			for _, e := range events {
+				select {
+				case m.eventChan <- e:
+				case <-time.After(500 * time.Millisecond):
+					m.logger.Warn("Lost event; timeout spooling after 500ms: ", e.Message)
+				}
+			}
+			m.logger.Debug("run:check:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+func (m *Monitor) check(ignore map[string]bool, now time.Time) ([]*event.Event, error) {
+	if err := m.conn.Connect(1); err != nil {
+		return nil, err
+	}
+	defer m.conn.Close()
+
+	vars, err := GetGlobalVariables(m.conn.DB())
+	if err != nil {
+		return nil, err
+	}
+	if m.vars == nil {
+		m.vars = vars
+		return nil, nil // first check
+	}
+	changes := Compare(m.vars, vars, ignore)
+	m.vars = vars
+	if len(changes) > 0 && !m.noSetUser {
+		if err := m.setUsers(changes); err != nil {
+			m.logger.Debug("Cannot get who changed variables: ", err)
+			m.noSetUser = true // MySQL < 8.0
+		}
+	}
+
+	events := make([]*event.Event, len(changes))
+	for i, c := range changes {
+		events[i] = MakeEvent(m.config.ServiceInstance, c, now)
+	}
+	return events, nil
+}
+
+// setUsers sets who changed the variables and when from
+// performance_schema.variables_info.
+func (m *Monitor) setUsers(changes []Change) error {
+	names := make([]interface{}, len(changes))
+	for i, c := range changes {
+		names[i] = c.Name
+	}
+	query := "SELECT /* percona-agent */ VARIABLE_NAME, SET_USER, SET_HOST, SET_TIME" +
+		" FROM performance_schema.variables_info" +
+		" WHERE VARIABLE_NAME IN (" + strings.TrimSuffix(strings.Repeat("?,", len(names)), ",") + ")"
+	rows, err := m.conn.DB().Query(query, names...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byName := make(map[string]int, len(changes))
+	for i, c := range changes {
+		byName[c.Name] = i
+	}
+	for rows.Next() {
+		var name string
+		var user, host, setTime sql.NullString
+		if err := rows.Scan(&name, &user, &host, &setTime); err != nil {
+			return err
+		}
+		if i, ok := byName[strings.ToLower(name)]; ok {
+			changes[i].User = user.String
+			changes[i].Host = host.String
+			changes[i].SetTime = setTime.String
+		}
+	}
+	return rows.Err()
+}
+
+// GetGlobalVariables returns SHOW GLOBAL VARIABLES keyed on lowercase name.
+func GetGlobalVariables(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query("SHOW /*!50002 GLOBAL */ VARIABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vars := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		vars[strings.ToLower(name)] = value
+	}
+	return vars, rows.Err()
+}
+
+// MakeEvent returns the event for a changed variable.
+func MakeEvent(it proto.ServiceInstance, c Change, now time.Time) *event.Event {
+	msg := fmt.Sprintf("%s changed from %s to %s", c.Name, quote(c.Old), quote(c.New))
+	if c.User != "" {
+		msg += fmt.Sprintf(" by %s@%s", c.User, c.Host)
+	}
+	severity := event.SEVERITY_INFO
+	for _, name := range WARN_VARIABLES {
+		if c.Name == name {
+			severity = event.SEVERITY_WARNING
+			break
+		}
+	}
+	e := &event.Event{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    it.Service,
+			InstanceId: it.InstanceId,
+		},
+		Ts:       now.UTC().Unix(),
+		Monitor:  "variables",
+		Type:     CHANGED,
+		Severity: severity,
+		Message:  msg,
+		Details: map[string]string{
+			"variable": c.Name,
+			"old":      c.Old,
+			"new":      c.New,
+		},
+	}
+	if c.User != "" {
+		e.Details["user"] = c.User
+		e.Details["host"] = c.Host
+	}
+	if c.SetTime != "" {
+		e.Details["set_time"] = c.SetTime
+	}
+	return e
+}
+
+func quote(val string) string {
+	if val == "" {
+		return "''"
+	}
+	return val
+}
+
+type changesByName []Change
+
+func (a changesByName) Len() int           { return len(a) }
+func (a changesByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a changesByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
@@ -0,0 +1,97 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package variables_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/event"
+	"github.com/percona/percona-agent/event/variables"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type VariablesTestSuite struct{}
+
+var _ = Suite(&VariablesTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *VariablesTestSuite) TestCompare(t *C) {
+	old := map[string]string{
+		"innodb_flush_log_at_trx_commit": "1",
+		"max_connections":                "151",
+		"gtid_executed":                  "uuid:1-100",
+		"rpl_semi_sync_master_enabled":   "OFF",
+	}
+	new := map[string]string{
+		"innodb_flush_log_at_trx_commit": "2",
+		"max_connections":                "151",
+		"gtid_executed":                  "uuid:1-200",
+		"validate_password_length":       "8",
+	}
+	ignore := map[string]bool{"gtid_executed": true}
+	got := variables.Compare(old, new, ignore)
+	t.Check(got, DeepEquals, []variables.Change{
+		{Name: "innodb_flush_log_at_trx_commit", Old: "1", New: "2"},
+		{Name: "rpl_semi_sync_master_enabled", Old: "OFF", New: ""},
+		{Name: "validate_password_length", Old: "", New: "8"},
+	})
+
+	t.Check(variables.Compare(old, old, ignore), HasLen, 0)
+}
+
+func (s *VariablesTestSuite) TestMakeEvent(t *C) {
+	it := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	now := time.Unix(1420070400, 0)
+
+	c := variables.Change{
+		Name:    "innodb_flush_log_at_trx_commit",
+		Old:     "1",
+		New:     "2",
+		User:    "root",
+		Host:    "localhost",
+		SetTime: "2015-01-01 00:00:00.000000",
+	}
+	e := variables.MakeEvent(it, c, now)
+	t.Check(e.Service, Equals, "mysql")
+	t.Check(e.InstanceId, Equals, uint(1))
+	t.Check(e.Ts, Equals, int64(1420070400))
+	t.Check(e.Type, Equals, variables.CHANGED)
+	t.Check(e.Severity, Equals, event.SEVERITY_WARNING)
+	t.Check(e.Message, Equals, "innodb_flush_log_at_trx_commit changed from 1 to 2 by root@localhost")
+	t.Check(e.Details, DeepEquals, map[string]string{
+		"variable": "innodb_flush_log_at_trx_commit",
+		"old":      "1",
+		"new":      "2",
+		"user":     "root",
+		"host":     "localhost",
+		"set_time": "2015-01-01 00:00:00.000000",
+	})
+
+	// No user before MySQL 8.0, and most variables are only info.
+	c = variables.Change{Name: "long_query_time", Old: "10.000000", New: ""}
+	e = variables.MakeEvent(it, c, now)
+	t.Check(e.Severity, Equals, event.SEVERITY_INFO)
+	t.Check(e.Message, Equals, "long_query_time changed from 10.000000 to ''")
+	t.Check(e.Details["user"], Equals, "")
+}
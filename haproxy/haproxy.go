@@ -0,0 +1,135 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package haproxy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-agent/instance"
+)
+
+// Instance is an HAProxy instance in the instance repo, like proto.MySQLInstance.
+type Instance struct {
+	Id       uint
+	Hostname string
+	Addr     string // stats socket path, host:port of stats socket, or http[s]://[user:pass@]host/stats URL, encrypted on disk
+	Version  string `json:",omitempty"`
+}
+
+func init() {
+	instance.RegisterType("haproxy", instance.Type{
+		New:    func() interface{} { return &Instance{} },
+		Secret: func(it interface{}) *string { return &it.(*Instance).Addr },
+	})
+}
+
+const TIMEOUT = 2 * time.Second
+
+type Connector interface {
+	Addr() string
+	Stats() ([]map[string]string, error)
+}
+
+// Connection gets stats from the HAProxy stats socket (show stat) or the CSV
+// export of the stats page for each call.
+type Connection struct {
+	addr   string
+	client *http.Client
+}
+
+func NewConnection(addr string) *Connection {
+	c := &Connection{
+		addr:   addr,
+		client: &http.Client{Timeout: TIMEOUT},
+	}
+	return c
+}
+
+func (c *Connection) Addr() string {
+	return c.addr
+}
+
+// Stats returns one row per frontend, backend, and server, keyed by CSV field,
+// e.g. pxname, svname, scur.
+func (c *Connection) Stats() ([]map[string]string, error) {
+	if strings.HasPrefix(c.addr, "http://") || strings.HasPrefix(c.addr, "https://") {
+		url := c.addr
+		if !strings.HasSuffix(url, ";csv") {
+			url += ";csv"
+		}
+		resp, err := c.client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			ioutil.ReadAll(resp.Body)
+			return nil, fmt.Errorf("HAProxy stats page returned %s", resp.Status)
+		}
+		return ParseStats(resp.Body)
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(c.addr, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, c.addr, TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+	if _, err := io.WriteString(conn, "show stat\n"); err != nil {
+		return nil, err
+	}
+	return ParseStats(conn) // HAProxy closes the socket after the reply
+}
+
+// ParseStats parses HAProxy stats CSV.  The first line is the header, like
+// "# pxname,svname,qcur,...", and lines end with a comma.
+func ParseStats(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || len(records[0]) == 0 || !strings.HasPrefix(records[0][0], "# ") {
+		return nil, fmt.Errorf("HAProxy stats have no header")
+	}
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "# ")
+	rows := []map[string]string{}
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, value := range record {
+			if i < len(header) && header[i] != "" {
+				row[header[i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
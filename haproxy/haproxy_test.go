@@ -0,0 +1,74 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package haproxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-agent/haproxy"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type HAProxyTestSuite struct {
+}
+
+var _ = Suite(&HAProxyTestSuite{})
+
+const stats = `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,
+pxc-front,FRONTEND,,,2,10,2000,150,1000,2000,0,0,0,,,,,OPEN,
+pxc-back,db1,0,0,1,5,,75,500,1000,,0,,0,0,0,0,UP,
+pxc-back,db2,0,0,0,5,,75,500,1000,,0,,3,0,1,0,DOWN,
+pxc-back,BACKEND,0,0,1,10,200,150,1000,2000,0,0,,3,0,1,0,UP,
+
+`
+
+func (s *HAProxyTestSuite) TestParseStats(t *C) {
+	rows, err := haproxy.ParseStats(strings.NewReader(stats))
+	t.Assert(err, IsNil)
+	t.Assert(rows, HasLen, 4)
+	t.Check(rows[0]["pxname"], Equals, "pxc-front")
+	t.Check(rows[0]["svname"], Equals, "FRONTEND")
+	t.Check(rows[0]["status"], Equals, "OPEN")
+	t.Check(rows[2]["svname"], Equals, "db2")
+	t.Check(rows[2]["econ"], Equals, "3")
+	t.Check(rows[2]["qcur"], Equals, "0")
+
+	_, err = haproxy.ParseStats(strings.NewReader("Unknown command.\n"))
+	t.Check(err, NotNil)
+}
+
+func (s *HAProxyTestSuite) TestStatsHTTP(t *C) {
+	var gotURL string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		w.Write([]byte(stats))
+	}))
+	defer ts.Close()
+
+	c := haproxy.NewConnection(ts.URL + "/stats")
+	rows, err := c.Stats()
+	t.Assert(err, IsNil)
+	t.Check(gotURL, Equals, "/stats;csv")
+	t.Check(rows, HasLen, 4)
+}
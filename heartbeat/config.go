@@ -0,0 +1,48 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package heartbeat
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+)
+
+const (
+	DEFAULT_TABLE    = "percona.heartbeat"
+	DEFAULT_INTERVAL = 1 // seconds
+)
+
+type Config struct {
+	proto.ServiceInstance
+	Table       string `json:",omitempty"` // db.table, default percona.heartbeat
+	Interval    uint   `json:",omitempty"` // seconds between updates, default 1
+	CreateTable bool   // create Table if it doesn't exist
+}
+
+func (c *Config) table() string {
+	if c.Table == "" {
+		return DEFAULT_TABLE
+	}
+	return c.Table
+}
+
+func (c *Config) interval() uint {
+	if c.Interval == 0 {
+		return DEFAULT_INTERVAL
+	}
+	return c.Interval
+}
@@ -0,0 +1,68 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package heartbeat
+
+import (
+	"database/sql"
+	"time"
+)
+
+// The heartbeat table is compatible with pt-heartbeat, so either can write it
+// and either can read it, but pt-heartbeat must use --utc because the agent
+// writes and reads UTC timestamps.
+const TS_FORMAT = "2006-01-02T15:04:05.000000"
+
+// CreateTableSQL returns the CREATE TABLE statement of the heartbeat table.
+func CreateTableSQL(table string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + " (" +
+		" ts varchar(26) NOT NULL," +
+		" server_id int unsigned NOT NULL PRIMARY KEY," +
+		" file varchar(255) DEFAULT NULL," +
+		" position bigint unsigned DEFAULT NULL," +
+		" relay_master_log_file varchar(255) DEFAULT NULL," +
+		" exec_master_log_pos bigint unsigned DEFAULT NULL" +
+		") ENGINE=InnoDB"
+}
+
+// Write updates the heartbeat row of the MySQL server, i.e. its @@server_id.
+func Write(conn *sql.DB, table string, now time.Time) error {
+	_, err := conn.Exec("REPLACE INTO "+table+" (ts, server_id) VALUES (?, @@server_id)", now.UTC().Format(TS_FORMAT))
+	return err
+}
+
+// Read returns the replication delay of the MySQL server: the time since the
+// most recent heartbeat written by another server, its master or a master up
+// the chain.  sql.ErrNoRows is returned if there's no such heartbeat, e.g. on
+// a master.  The delay is never negative: clock skew isn't delay.
+func Read(conn *sql.DB, table string, now time.Time) (time.Duration, error) {
+	var ts string
+	err := conn.QueryRow("SELECT ts FROM " + table + " WHERE server_id != @@server_id ORDER BY ts DESC LIMIT 1").Scan(&ts)
+	if err != nil {
+		return 0, err
+	}
+	// Fractional seconds are parsed even though the layout doesn't have them.
+	t, err := time.Parse("2006-01-02T15:04:05", ts)
+	if err != nil {
+		return 0, err
+	}
+	delay := now.UTC().Sub(t)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, nil
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package heartbeat_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-agent/heartbeat"
+	"github.com/percona/percona-agent/mysql"
+	. "gopkg.in/check.v1"
+)
+
+/**
+ * This must be set, else all tests will fail.
+ */
+var dsn = os.Getenv("PCT_TEST_MYSQL_DSN")
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type HeartbeatTestSuite struct {
+	conn  *mysql.Connection
+	table string
+}
+
+var _ = Suite(&HeartbeatTestSuite{})
+
+func (s *HeartbeatTestSuite) SetUpSuite(t *C) {
+	if dsn == "" {
+		t.Fatal("PCT_TEST_MYSQL_DSN is not set")
+	}
+	s.conn = mysql.NewConnection(dsn)
+	if err := s.conn.Connect(1); err != nil {
+		t.Fatal(err)
+	}
+	s.table = "percona_agent_test.heartbeat"
+	s.conn.DB().Exec("CREATE DATABASE IF NOT EXISTS percona_agent_test")
+}
+
+func (s *HeartbeatTestSuite) SetUpTest(t *C) {
+	s.conn.DB().Exec("DROP TABLE IF EXISTS " + s.table)
+	_, err := s.conn.DB().Exec(heartbeat.CreateTableSQL(s.table))
+	t.Assert(err, IsNil)
+}
+
+func (s *HeartbeatTestSuite) TearDownSuite(t *C) {
+	s.conn.DB().Exec("DROP DATABASE IF EXISTS percona_agent_test")
+	s.conn.Close()
+}
+
+// --------------------------------------------------------------------------
+
+func (s *HeartbeatTestSuite) TestWriteRead(t *C) {
+	now := time.Now().Truncate(time.Microsecond) // heartbeat ts resolution
+	err := heartbeat.Write(s.conn.DB(), s.table, now)
+	t.Assert(err, IsNil)
+
+	// The server's own heartbeat isn't replication delay.
+	_, err = heartbeat.Read(s.conn.DB(), s.table, now)
+	t.Check(err, Equals, sql.ErrNoRows)
+
+	// Heartbeat from a master (server_id 0 is not a valid server_id, so it
+	// can't be this server) 2.5 seconds ago, like pt-heartbeat --utc writes.
+	masterTs := now.Add(-2500 * time.Millisecond).UTC().Format(heartbeat.TS_FORMAT)
+	_, err = s.conn.DB().Exec("INSERT INTO "+s.table+" (ts, server_id) VALUES (?, 0)", masterTs)
+	t.Assert(err, IsNil)
+	delay, err := heartbeat.Read(s.conn.DB(), s.table, now)
+	t.Assert(err, IsNil)
+	t.Check(delay, Equals, 2500*time.Millisecond)
+
+	// Clock skew isn't delay.
+	delay, err = heartbeat.Read(s.conn.DB(), s.table, now.Add(-time.Hour))
+	t.Assert(err, IsNil)
+	t.Check(delay, Equals, time.Duration(0))
+}
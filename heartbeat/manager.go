@@ -0,0 +1,245 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package heartbeat
+
+/**
+ * heartbeat is an optional service that writes a heartbeat row on MySQL masters
+ * like pt-heartbeat.  The mm MySQL monitor reads it on replicas (Heartbeat
+ * config) to report the true replication delay.  Like event, the manager is
+ * always running and starts and stops one writer per MySQL instance.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Manager struct {
+	logger      *pct.Logger
+	connFactory mysql.ConnectionFactory
+	ir          *instance.Repo
+	// --
+	writers map[string]*Writer
+	running bool
+	mux     *sync.RWMutex // guards writers
+	status  *pct.Status
+}
+
+func NewManager(logger *pct.Logger, connFactory mysql.ConnectionFactory, ir *instance.Repo) *Manager {
+	m := &Manager{
+		logger:      logger,
+		connFactory: connFactory,
+		ir:          ir,
+		// --
+		writers: make(map[string]*Writer),
+		mux:     &sync.RWMutex{},
+		status:  pct.NewStatus([]string{"heartbeat"}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (m *Manager) Start() error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: "heartbeat"}
+	}
+
+	// Start all heartbeat writers.
+	glob := filepath.Join(pct.Basedir.Dir("config"), "heartbeat-*.conf")
+	configFiles, err := filepath.Glob(glob)
+	if err != nil {
+		return err
+	}
+	for _, configFile := range configFiles {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			m.logger.Error("Read " + configFile + ": " + err.Error())
+			continue
+		}
+		cmd := &proto.Cmd{
+			Ts:   time.Now().UTC(),
+			Cmd:  "StartService",
+			Data: data,
+		}
+		reply := m.Handle(cmd)
+		if reply.Error != "" {
+			m.logger.Error("Start " + configFile + ": " + reply.Error)
+			continue
+		}
+		m.logger.Info("Started " + configFile)
+	}
+
+	m.running = true
+
+	m.logger.Info("Started")
+	m.status.Update("heartbeat", "Running")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Manager) Stop() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for name, writer := range m.writers {
+		m.status.Update("heartbeat", "Stopping "+name)
+		if err := writer.Stop(); err != nil {
+			m.logger.Warn("Failed to stop " + name + ": " + err.Error())
+			continue
+		}
+		delete(m.writers, name)
+	}
+	m.running = false
+	m.logger.Info("Stopped")
+	m.status.Update("heartbeat", "Stopped")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
+	m.status.UpdateRe("heartbeat", "Handling", cmd)
+	defer m.status.Update("heartbeat", "Running")
+
+	switch cmd.Cmd {
+	case "StartService":
+		c, name, err := m.getConfig(cmd)
+		if err != nil {
+			return cmd.Reply(nil, err)
+		}
+		m.logger.Info("Start", name, cmd)
+
+		m.mux.RLock()
+		_, haveWriter := m.writers[name]
+		m.mux.RUnlock()
+		if haveWriter {
+			return cmd.Reply(nil, errors.New("Duplicate heartbeat writer: "+name))
+		}
+
+		if c.Service != "mysql" {
+			return cmd.Reply(nil, errors.New("Heartbeat only supports MySQL, not "+c.Service))
+		}
+		mysqlIt := &proto.MySQLInstance{}
+		if err := m.ir.Get(c.Service, c.InstanceId, mysqlIt); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		writer := NewWriter(name, c, pct.NewLogger(m.logger.LogChan(), name), m.connFactory.Make(mysqlIt.DSN))
+		if err := writer.Start(); err != nil {
+			return cmd.Reply(nil, errors.New("Start "+name+": "+err.Error()))
+		}
+		m.mux.Lock()
+		m.writers[name] = writer
+		m.mux.Unlock()
+
+		// Save the config to disk so the writer starts on restart.
+		if err := pct.Basedir.WriteConfig(name, c); err != nil {
+			return cmd.Reply(nil, errors.New("Write "+name+" config:"+err.Error()))
+		}
+		return cmd.Reply(nil) // success
+	case "StopService":
+		_, name, err := m.getConfig(cmd)
+		if err != nil {
+			return cmd.Reply(nil, err)
+		}
+		m.logger.Info("Stop", name, cmd)
+		m.mux.RLock()
+		writer, ok := m.writers[name]
+		m.mux.RUnlock()
+		if !ok {
+			return cmd.Reply(nil, errors.New("Unknown heartbeat writer: "+name))
+		}
+		if err := writer.Stop(); err != nil {
+			return cmd.Reply(nil, errors.New("Stop "+name+": "+err.Error()))
+		}
+		if err := pct.Basedir.RemoveConfig(name); err != nil {
+			return cmd.Reply(nil, errors.New("Remove "+name+": "+err.Error()))
+		}
+		m.mux.Lock()
+		delete(m.writers, name)
+		m.mux.Unlock()
+		return cmd.Reply(nil) // success
+	case "GetConfig":
+		config, errs := m.GetConfig()
+		return cmd.Reply(config, errs...)
+	default:
+		// To re-configure a writer, stop it then start it with the new config.
+		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
+	}
+}
+
+// @goroutine[1]
+func (m *Manager) Status() map[string]string {
+	status := m.status.All()
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	for _, writer := range m.writers {
+		for k, v := range writer.Status() {
+			status[k] = v
+		}
+	}
+	return status
+}
+
+func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	// Manager does not have its own config.  It returns all writers' configs instead.
+	configs := []proto.AgentConfig{}
+	errs := []error{}
+	for _, writer := range m.writers {
+		c := writer.Config()
+		bytes, err := json.Marshal(c)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		config := proto.AgentConfig{
+			InternalService: "heartbeat",
+			ExternalService: proto.ServiceInstance{
+				Service:    c.Service,
+				InstanceId: c.InstanceId,
+			},
+			Config:  string(bytes),
+			Running: true, // config removed if stopped, so it must be running
+		}
+		configs = append(configs, config)
+	}
+	return configs, errs
+}
+
+// --------------------------------------------------------------------------
+
+func (m *Manager) getConfig(cmd *proto.Cmd) (*Config, string, error) {
+	c := &Config{}
+	if err := json.Unmarshal(cmd.Data, c); err != nil {
+		return nil, "", errors.New("heartbeat.Handle:json.Unmarshal:" + err.Error())
+	}
+	// The real name of the internal service, e.g. heartbeat-mysql-1:
+	name := "heartbeat-" + m.ir.Name(c.Service, c.InstanceId)
+	return c, name, nil
+}
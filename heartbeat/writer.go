@@ -0,0 +1,159 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package heartbeat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Writer updates the heartbeat row of a MySQL instance every interval while
+// it's writable, i.e. a master.  Replicas are read-only, so the writer idles
+// on them but starts writing if one is promoted.
+type Writer struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	sync    *pct.SyncChan
+	status  *pct.Status
+	running bool
+}
+
+func NewWriter(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Writer {
+	w := &Writer{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+	}
+	return w
+}
+
+// @goroutine[0]
+func (w *Writer) Start() error {
+	if w.running {
+		return pct.ServiceIsRunningError{Service: w.name}
+	}
+	w.status.Update(w.name, "Starting")
+	go w.run()
+	w.running = true
+	w.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (w *Writer) Stop() error {
+	if !w.running {
+		return nil // already stopped
+	}
+	w.status.Update(w.name, "Stopping")
+	w.sync.Stop()
+	w.sync.Wait()
+	w.running = false
+	w.logger.Info("Stopped")
+	return nil
+}
+
+// @goroutine[0]
+func (w *Writer) Status() map[string]string {
+	return w.status.All()
+}
+
+// @goroutine[0]
+func (w *Writer) Config() *Config {
+	return w.config
+}
+
+// --------------------------------------------------------------------------
+
+// @goroutine[1]
+func (w *Writer) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			w.logger.Error("Heartbeat writer crashed: ", err)
+		}
+		w.conn.Close()
+		w.status.Update(w.name, "Stopped")
+		w.sync.Done()
+	}()
+
+	ticker := time.NewTicker(time.Duration(w.config.interval()) * time.Second)
+	defer ticker.Stop()
+
+	connected := false
+	created := false
+	var lastErr string
+	for {
+		select {
+		case now := <-ticker.C:
+			if !connected {
+				if err := w.conn.Connect(1); err != nil {
+					w.status.Update(w.name, "Not connected: "+err.Error())
+					continue
+				}
+				connected = true
+			}
+			status, err := w.write(now, &created)
+			if err != nil {
+				// Log each error once, not every interval.
+				if err.Error() != lastErr {
+					w.logger.Warn(err)
+					lastErr = err.Error()
+				}
+				w.status.Update(w.name, "Error: "+err.Error())
+				w.conn.Close()
+				connected = false
+				continue
+			}
+			lastErr = ""
+			w.status.Update(w.name, status)
+		case <-w.sync.StopChan:
+			return
+		}
+	}
+}
+
+// write updates the heartbeat if MySQL is writable, and returns the status.
+func (w *Writer) write(now time.Time, created *bool) (string, error) {
+	db := w.conn.DB()
+	var readOnly bool
+	if err := db.QueryRow("SELECT @@GLOBAL.read_only").Scan(&readOnly); err != nil {
+		return "", err
+	}
+	if readOnly {
+		return "Idle (read-only)", nil
+	}
+	if w.config.CreateTable && !*created {
+		if _, err := db.Exec(CreateTableSQL(w.config.table())); err != nil {
+			return "", err
+		}
+		*created = true
+	}
+	if err := Write(db, w.config.table(), now); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Writing %s (last at %s)", w.config.table(), now.UTC().Format(TS_FORMAT)), nil
+}
@@ -42,6 +42,7 @@ INSTALL_DIR="/usr/local/percona"
 # BASEDIR here must match BASEDIR in percona-agent sys-init script.
 BASEDIR="$INSTALL_DIR/$BIN"
 INIT_SCRIPT="/etc/init.d/$BIN"
+SYSTEMD_UNIT="/etc/systemd/system/$BIN.service"
 
 # ###########################################################################
 # Version comparision
@@ -69,6 +70,21 @@ ver_cmp() {
   return $?
 }
 
+stop_agent() {
+  if [ -f "$SYSTEMD_UNIT" ]; then
+    systemctl stop $BIN
+  elif [ -x "$INIT_SCRIPT" ]; then
+    ${INIT_SCRIPT} stop
+  fi
+}
+
+# The installer writes a systemd unit or sys-init script, depending on the
+# init system, enables it and (re)starts the agent.  -service-user, if given
+# to this script, is passed through.
+install_service() {
+  "$INSTALLER_DIR/bin/$BIN-installer" -basedir "$BASEDIR" -install-service ${SERVICE_USER_ARGS[@]+"${SERVICE_USER_ARGS[@]}"}
+}
+
 install() {
     # ###########################################################################
     # Check if already installed and upgrade if needed
@@ -84,7 +100,7 @@ install() {
         if [ "$cmpVer" == "2" ]; then
             echo "Upgrading to $newVersion..."
             if [ "$KERNEL" != "Darwin" ]; then
-                ${INIT_SCRIPT} stop
+                stop_agent
             else
                 echo "killall $BIN"
             fi
@@ -92,17 +108,10 @@ install() {
             # Install agent binary
             cp -f "$INSTALLER_DIR/bin/$BIN" "$BASEDIR/bin/"
 
-            # Copy init script (for backup, as we are going to install it in /etc/init.d)
+            # Copy init script (template for the installer -install-service)
             cp -f "$INSTALLER_DIR/init.d/$BIN" "$BASEDIR/init.d/"
 
-            if [ "$KERNEL" != "Darwin" ]; then
-                cp -f "$INSTALL_DIR/$BIN/init.d/$BIN" "/etc/init.d/"
-                chmod a+x "/etc/init.d/$BIN"
-                ${INIT_SCRIPT} start
-            else
-               echo "Mac OS detected, not installing sys-init script.  To start $BIN:"
-               echo "$BASEDIR/bin/$BIN -basedir $BASEDIR"
-            fi
+            install_service || error "Failed to install and start $BIN service"
             echo
             echo "Success! $BIN was upgraded to $newVersion and restarted."
             echo
@@ -144,41 +153,19 @@ install() {
     # Install agent binary
     cp -f "$INSTALLER_DIR/bin/$BIN" "$BASEDIR/bin/"
 
-    # Copy init script (for backup, as we are going to install it in /etc/init.d)
+    # Copy init script (template for the installer -install-service)
     cp -f "$INSTALLER_DIR/init.d/$BIN" "$BASEDIR/init.d/"
 
-    "$BASEDIR/bin/$BIN" -ping >/dev/null
-    if [ $? -ne 0 ]; then
-       error "Installed $BIN but ping test failed"
-    fi
-
-    if [ "$KERNEL" != "Darwin" ]; then
-       cp -f "$INSTALL_DIR/$BIN/init.d/$BIN" "/etc/init.d/"
-       chmod a+x "/etc/init.d/$BIN"
-
-       # Check if the system has chkconfig or update-rc.d.
-       if hash update-rc.d 2>/dev/null; then
-               echo "Using update-rc.d to install $BIN service"
-               update-rc.d  $BIN defaults >/dev/null
-       elif hash chkconfig 2>/dev/null; then
-               echo "Using chkconfig to install $BIN service"
-               chkconfig $BIN on >/dev/null
-       else
-          echo "Cannot find chkconfig or update-rc.d.  $BIN is installed but"
-          echo "it will not restart automatically with the server on reboot.  Please"
-          echo "email the follow to cloud-tools@percona.com:"
-          cat /etc/*release
-       fi
-
-       ${INIT_SCRIPT} start
+    # With -bundle the host may not have access to the API yet.
+    if [[ $* != *-bundle* ]]; then
+       "$BASEDIR/bin/$BIN" -ping >/dev/null
        if [ $? -ne 0 ]; then
-          error "Failed to start $BIN"
+          error "Installed $BIN but ping test failed"
        fi
-    else
-       echo "Mac OS detected, not installing sys-init script.  To start $BIN:"
-       echo "$BASEDIR/bin/$BIN -basedir $BASEDIR"
     fi
 
+    install_service || error "Failed to install and start $BIN service"
+
     # ###########################################################################
     # Cleanup
     # ###########################################################################
@@ -192,62 +179,31 @@ install() {
 
 uninstall() {
     # ###########################################################################
-    # Stop agent and uninstall sys-int script
+    # Stop and deregister agent, remove sys-init script and basedir.
+    # The installer does it all; see its -uninstall and -drop-mysql-user.
     # ###########################################################################
-    if [ "$KERNEL" != "Darwin" ]; then
-       if [ -x "$INIT_SCRIPT" ]; then
-           echo "Stopping agent ..."
-           ${INIT_SCRIPT} stop
-           if [ $? -ne 0 ]; then
-              error "Failed to stop $BIN"
-           fi
-       fi
-
-       echo "Uninstalling sys-init script ..."
-       # Check if the system has chkconfig or update-rc.d.
-       if hash update-rc.d 2>/dev/null; then
-               echo "Using update-rc.d to uninstall $BIN service"
-               update-rc.d -f "$BIN" remove
-       elif hash chkconfig 2>/dev/null; then
-               echo "Using chkconfig to uninstall $BIN service"
-               chkconfig --del "$BIN"
-       else
-          echo "Cannot find chkconfig or update-rc.d.  $BIN is installed but"
-          echo "it will not restart automatically with the server on reboot.  Please"
-          echo "email the follow to cloud-tools@percona.com:"
-          cat /etc/*release
-       fi
-
-       # Remove init script
-       echo "Removing $INIT_SCRIPT ..."
-       rm -f "$INIT_SCRIPT"
-    else
-       echo "Mac OS detected, no sys-init script. To stop $BIN:"
-       echo "killall $BIN"
+    "$INSTALLER_DIR/bin/$BIN-installer" -basedir "$BASEDIR" $@
+    if [ $? -ne 0 ]; then
+       error "Failed to uninstall $BIN"
     fi
-
-    # ###########################################################################
-    # Uninstall percona-agent
-    # ###########################################################################
-
-    # BASEDIR here must match BASEDIR in percona-agent sys-init script.
-    echo "Removing dir $BASEDIR ..."
-    rm -rf "$BASEDIR"
-
-    # ###########################################################################
-    # Cleanup
-    # ###########################################################################
-
-    echo "$BIN uninstall successful"
     exit 0
 }
 
+SERVICE_USER_ARGS=()
+args=("$@")
+for ((n = 0; n < ${#args[@]}; n++)); do
+  case "${args[n]}" in
+    -service-user=*|--service-user=*) SERVICE_USER_ARGS=("${args[n]}") ;;
+    -service-user|--service-user) SERVICE_USER_ARGS=("${args[n]}" "${args[n+1]:-}") ;;
+  esac
+done
+
 if [ "$*" == "--help" -o "$*" == "-help" -o "$*" == "-h" -o "$*" == "-?" ]; then
    "$INSTALLER_DIR/bin/$BIN-installer" -h
    echo "See http://cloud-docs.percona.com/Install.html for more information."
    exit 0
 fi
-[[ $* == *-uninstall* ]] && uninstall
+[[ $* == *-uninstall* ]] && uninstall $@
 install $@
 
 
@@ -0,0 +1,172 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const BUNDLE_VERSION = 1
+
+// Configs not exported because they belong to the host, not its instances and
+// services: the agent config has the API key, and the instance cache has
+// validators of downloads by the old agent.
+var bundleSkip = map[string]bool{
+	"agent":      true,
+	CACHE_CONFIG: true,
+}
+
+var bundleNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// A Bundle is all instance and service configs of an agent, to move them to a
+// new host.  Instance secrets like MySQL DSNs are decrypted, so they can be
+// encrypted with the new host's instance key, and the bundle is signed with
+// the API key.
+type Bundle struct {
+	Version   int
+	AgentUuid string // of the old agent, which the new agent takes over
+	Hostname  string
+	Ts        time.Time
+	Configs   map[string]json.RawMessage // by name, e.g. mysql-1, qan
+	Signature string                     // hex HMAC-SHA256 of the bundle without it, keyed by API key
+}
+
+// ExportBundle returns a signed bundle of the configs in configDir, with the
+// instances from the repo, which must be initialized.
+func ExportBundle(repo *Repo, configDir, agentUuid, apiKey string) (*Bundle, error) {
+	if apiKey == "" {
+		return nil, errors.New("Cannot sign bundle: agent has no API key")
+	}
+	hostname, _ := os.Hostname()
+	b := &Bundle{
+		Version:   BUNDLE_VERSION,
+		AgentUuid: agentUuid,
+		Hostname:  hostname,
+		Ts:        time.Now().UTC(),
+		Configs:   make(map[string]json.RawMessage),
+	}
+	files, err := filepath.Glob(filepath.Join(configDir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".conf")
+		if bundleSkip[name] {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var config json.RawMessage
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		b.Configs[name] = config
+	}
+
+	// Instances from the repo, not disk, because their secrets are decrypted.
+	repo.mux.RLock()
+	for name, it := range repo.it {
+		data, err := json.Marshal(it)
+		if err != nil {
+			repo.mux.RUnlock()
+			return nil, err
+		}
+		b.Configs[name] = json.RawMessage(data)
+	}
+	repo.mux.RUnlock()
+
+	sig, err := b.sign(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	b.Signature = sig
+	return b, nil
+}
+
+// ImportBundle verifies the bundle signature with the API key and writes its
+// configs.  Instances are added to the repo, so their secrets are encrypted if
+// the new host has an instance key; other configs are written as is.  It
+// doesn't overwrite existing configs; the new host shouldn't have instances or
+// services yet.  The caller must rebind the agent UUID.
+func ImportBundle(repo *Repo, data []byte, apiKey string) (*Bundle, error) {
+	b := &Bundle{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("Invalid bundle: %s", err)
+	}
+	if b.Version != BUNDLE_VERSION {
+		return nil, fmt.Errorf("Unsupported bundle version %d, expected %d", b.Version, BUNDLE_VERSION)
+	}
+	sig, err := b.sign(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	got, err := hex.DecodeString(b.Signature)
+	if err != nil {
+		return nil, errors.New("Invalid bundle signature")
+	}
+	expect, _ := hex.DecodeString(sig)
+	if !hmac.Equal(got, expect) {
+		return nil, errors.New("Invalid bundle signature: it was not exported by an agent with the same API key, or it was modified")
+	}
+	for name := range b.Configs {
+		if !bundleNameRe.MatchString(name) || bundleSkip[name] {
+			return nil, fmt.Errorf("Invalid config name in bundle: %s", name)
+		}
+		if pct.FileExists(pct.Basedir.ConfigFile(name)) {
+			return nil, fmt.Errorf("%s exists; remove it to import the bundle", pct.Basedir.ConfigFile(name))
+		}
+	}
+	for name, config := range b.Configs {
+		if service, id, err := parseName(name); err == nil && valid(service, id) {
+			if err := repo.Add(service, id, config, true); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			continue
+		}
+		if err := pct.Basedir.WriteConfig(name, config); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (b *Bundle) sign(apiKey string) (string, error) {
+	unsigned := *b
+	unsigned.Signature = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
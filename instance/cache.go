@@ -0,0 +1,118 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+	"net/http"
+	"sort"
+)
+
+// The cache validators of instances downloaded from the API are saved in
+// instance-cache.conf, by instance name, so Refresh can re-get them with
+// conditional GETs that return 304 Not Modified without data if unchanged.
+const CACHE_CONFIG = "instance-cache"
+
+// loadCache loads the cache validators and drops those of instances that are
+// no longer on disk.  Caller must lock r.mux.
+func (r *Repo) loadCache() error {
+	cache := make(map[string]pct.CacheValidators)
+	if err := pct.Basedir.ReadConfig(CACHE_CONFIG, &cache); err != nil {
+		return fmt.Errorf("%s: %s", CACHE_CONFIG, err)
+	}
+	stale := false
+	for name := range cache {
+		if _, ok := r.it[name]; !ok {
+			r.logger.Debug("Dropping cache validators of " + name)
+			delete(cache, name)
+			stale = true
+		}
+	}
+	r.cache = cache
+	if stale {
+		return r.saveCache()
+	}
+	return nil
+}
+
+// saveCache writes the cache validators.  Caller must lock r.mux.
+func (r *Repo) saveCache() error {
+	if len(r.cache) == 0 {
+		return pct.Basedir.RemoveConfig(CACHE_CONFIG)
+	}
+	return pct.Basedir.WriteConfig(CACHE_CONFIG, r.cache)
+}
+
+// Refresh re-gets the instances downloaded from the API with conditional GETs
+// and updates the ones that changed.  An instance that cannot be refreshed is
+// kept as is, so Refresh only returns the errors.
+func (r *Repo) Refresh() []error {
+	r.logger.Debug("Refresh:call")
+	defer r.logger.Debug("Refresh:return")
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	link := r.api.EntryLink("instances")
+	if link == "" {
+		return nil // offline; nothing to refresh from
+	}
+
+	names := make([]string, 0, len(r.cache))
+	for name := range r.cache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		service, id, err := parseName(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		url := fmt.Sprintf("%s/%s/%d", link, service, id)
+		code, data, validators, err := r.api.GetIfModified(r.api.ApiKey(), url, r.cache[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to refresh %s instance from %s: %s", name, link, err))
+			continue
+		}
+		switch code {
+		case http.StatusNotModified:
+			r.logger.Debug(name + " not modified")
+		case http.StatusOK:
+			old := r.it[name]
+			delete(r.it, name)
+			if err := r.add(service, id, data, true); err != nil {
+				r.it[name] = old
+				errs = append(errs, fmt.Errorf("Failed to refresh %s instance: %s", name, err))
+				continue
+			}
+			delete(r.health, name)
+			r.cache[name] = validators
+			r.logger.Info("Refreshed " + name)
+		default:
+			errs = append(errs, fmt.Errorf("Refreshing %s instance from %s returned code %d, expected 200 or 304", name, link, code))
+		}
+	}
+	if err := r.saveCache(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"time"
+)
+
+const (
+	DEFAULT_DISCOVER_INTERVAL = 300 // seconds
+	DEFAULT_PROBE_INTERVAL    = 60  // seconds
+)
+
+// Config is the instance manager config, instance.conf, which is optional.
+type Config struct {
+	DiscoverInterval int  `json:",omitempty"` // seconds between scans for new local MySQL, 0 = default, < 0 = never
+	AutoAdd          bool `json:",omitempty"` // add new MySQL with the user and password of an existing MySQL instance
+	ValidateDSN      bool `json:",omitempty"` // connect and check privileges before adding a MySQL instance
+	ProbeInterval    int  `json:",omitempty"` // seconds between instance health probes, 0 = default, < 0 = never
+	MaxConnections   int  `json:",omitempty"` // max connections to each MySQL shared by all services, 0 = default (10), < 0 = no limit
+}
+
+func (c *Config) discoverInterval() time.Duration {
+	switch {
+	case c.DiscoverInterval < 0:
+		return 0
+	case c.DiscoverInterval == 0:
+		return DEFAULT_DISCOVER_INTERVAL * time.Second
+	}
+	return time.Duration(c.DiscoverInterval) * time.Second
+}
+
+func (c *Config) probeInterval() time.Duration {
+	switch {
+	case c.ProbeInterval < 0:
+		return 0
+	case c.ProbeInterval == 0:
+		return DEFAULT_PROBE_INTERVAL * time.Second
+	}
+	return time.Duration(c.ProbeInterval) * time.Second
+}
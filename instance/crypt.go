@@ -0,0 +1,160 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	ENCRYPTED_PREFIX = "enc:v1:" // + base64(nonce + AES-256-GCM ciphertext)
+	KEY_SIZE         = 32
+)
+
+// Crypter encrypts and decrypts credentials, like MySQL DSNs, in instance
+// configs with a key from a key file (LoadKey).  Plaintext is only in memory.
+type Crypter struct {
+	aead cipher.AEAD
+}
+
+func NewCrypter(key []byte) (*Crypter, error) {
+	if len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("Invalid key size: %d bytes, expected %d", len(key), KEY_SIZE)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Crypter{aead: aead}, nil
+}
+
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, ENCRYPTED_PREFIX)
+}
+
+func (c *Crypter) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return ENCRYPTED_PREFIX + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns s decrypted, or s if it's not encrypted.
+func (c *Crypter) Decrypt(s string) (string, error) {
+	if !IsEncrypted(s) {
+		return s, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, ENCRYPTED_PREFIX))
+	if err != nil {
+		return "", err
+	}
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return "", errors.New("Encrypted value is too short")
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", errors.New("Cannot decrypt, wrong key?")
+	}
+	return string(plaintext), nil
+}
+
+var (
+	preloadedKeys = map[string][]byte{}
+	preloadMux    = &sync.Mutex{}
+)
+
+// PreloadKey loads the key in file like LoadKey and keeps it, so LoadKey
+// returns it without reading file again.  The agent preloads the key as root
+// before it drops privileges (-user), so the key file stays root-only.
+func PreloadKey(file string) error {
+	key, err := LoadKey(file)
+	if err != nil {
+		return err
+	}
+	preloadMux.Lock()
+	defer preloadMux.Unlock()
+	preloadedKeys[file] = key
+	return nil
+}
+
+// LoadKey reads the hex-encoded key in file, unless it was preloaded by
+// PreloadKey.  The file must not be readable or writable by group or others
+// and, if the agent runs as root, it must be owned by root.
+func LoadKey(file string) ([]byte, error) {
+	preloadMux.Lock()
+	key, ok := preloadedKeys[file]
+	preloadMux.Unlock()
+	if ok {
+		return key, nil
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s must not be accessible by group or others (mode %s)", file, fi.Mode().Perm())
+	}
+	if uid, _, ok := pct.FileOwner(fi); ok && os.Geteuid() == 0 && uid != 0 {
+		return nil, fmt.Errorf("%s must be owned by root", file)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid key: %s", file, err)
+	}
+	return key, nil
+}
+
+// MakeKey writes a new random key to file, which must not exist.
+func MakeKey(file string) error {
+	key := make([]byte, KEY_SIZE)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,206 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"github.com/percona/percona-agent/mysql"
+	"io/ioutil"
+	"runtime"
+	"strings"
+)
+
+// A mysqld process running on this host.  Hosts can run several (mysqld_multi,
+// sandboxes, containers), each with its own socket, port and defaults file.
+type LocalMySQL struct {
+	DefaultsFile string
+	Socket       string
+	Port         string
+	Datadir      string
+}
+
+// DSN returns how to connect to the mysqld: by its socket if known, else by
+// its port on 127.0.0.1 because localhost means the default socket.  On
+// Windows, socket is the named pipe which mysqld only listens on if it's
+// enabled, so the port is used.
+func (m LocalMySQL) DSN() mysql.DSN {
+	if m.Socket != "" && runtime.GOOS != "windows" {
+		return mysql.DSN{Socket: m.Socket}
+	}
+	if m.Port != "" {
+		return mysql.DSN{Hostname: "127.0.0.1", Port: m.Port}
+	}
+	return mysql.DSN{Hostname: "localhost"}
+}
+
+var mysqldNames = map[string]bool{
+	"mysqld":       true,
+	"mysqld-debug": true,
+	"mariadbd":     true,
+	"mysqld.exe":   true,
+}
+
+// DiscoverMySQL returns the running mysqld processes.  Options not on the
+// command line are read from the --defaults-file, if any.
+func DiscoverMySQL() ([]LocalMySQL, error) {
+	out, err := processArgs()
+	if err != nil {
+		return nil, err
+	}
+	found := ParseMysqldProcesses(out)
+	for n, m := range found {
+		if m.DefaultsFile == "" || (m.Socket != "" && m.Port != "") {
+			continue
+		}
+		content, err := ioutil.ReadFile(m.DefaultsFile)
+		if err != nil {
+			continue // not readable, use what's on the command line
+		}
+		found[n] = withDefaults(m, ParseMyCnfGroup(string(content), "mysqld"))
+	}
+	return uniqueMySQL(found), nil
+}
+
+func ParseMysqldProcesses(output string) []LocalMySQL {
+	/**
+	 * COMMAND
+	 * /usr/sbin/mysqld --basedir=/usr --datadir=/var/lib/mysql --socket=/var/run/mysqld/mysqld.sock --port=3306
+	 * /usr/sbin/mysqld --defaults-file=/etc/mysql/my3307.cnf
+	 *
+	 * On Windows, paths with spaces are quoted:
+	 * "C:\Program Files\MySQL\MySQL Server 5.6\bin\mysqld.exe" --defaults-file="C:\ProgramData\MySQL\my.ini" MySQL56
+	 */
+	found := []LocalMySQL{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := splitArgs(line)
+		if len(fields) == 0 || !mysqldNames[strings.ToLower(programBase(fields[0]))] {
+			continue
+		}
+		m := LocalMySQL{}
+		for _, arg := range fields[1:] {
+			kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+			if len(kv) != 2 || !strings.HasPrefix(arg, "--") {
+				continue
+			}
+			switch strings.Replace(kv[0], "_", "-", -1) {
+			case "defaults-file":
+				m.DefaultsFile = kv[1]
+			case "socket":
+				m.Socket = kv[1]
+			case "port":
+				m.Port = kv[1]
+			case "datadir":
+				m.Datadir = kv[1]
+			}
+		}
+		found = append(found, m)
+	}
+	return found
+}
+
+// splitArgs splits a command line on spaces, except in double quotes, which
+// are removed.  Only Windows quotes; ps prints args as they are.
+func splitArgs(line string) []string {
+	args := []string{}
+	arg := ""
+	inArg := false
+	quoted := false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case (c == ' ' || c == '\t' || c == '\r') && !quoted:
+			if inArg {
+				args = append(args, arg)
+			}
+			arg = ""
+			inArg = false
+		default:
+			arg += string(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg)
+	}
+	return args
+}
+
+// programBase returns the file name of the program, with / or \ separators
+// whatever the OS, because filepath.Base only knows the OS's separator.
+func programBase(program string) string {
+	if i := strings.LastIndexAny(program, `/\`); i >= 0 {
+		return program[i+1:]
+	}
+	return program
+}
+
+// ParseMyCnfGroup returns the options in the [group] of a my.cnf file.
+// Option names use "-", e.g. "log-error", whichever was used in the file.
+func ParseMyCnfGroup(content string, group string) map[string]string {
+	options := make(map[string]string)
+	inGroup := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			inGroup = strings.TrimSpace(strings.Trim(line, "[]")) == group
+			continue
+		}
+		if !inGroup {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1)
+		value := ""
+		if len(kv) == 2 {
+			value = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+		options[name] = value
+	}
+	return options
+}
+
+func withDefaults(m LocalMySQL, options map[string]string) LocalMySQL {
+	if m.Socket == "" {
+		m.Socket = options["socket"]
+	}
+	if m.Port == "" {
+		m.Port = options["port"]
+	}
+	if m.Datadir == "" {
+		m.Datadir = options["datadir"]
+	}
+	return m
+}
+
+func uniqueMySQL(found []LocalMySQL) []LocalMySQL {
+	seen := make(map[string]bool)
+	unique := []LocalMySQL{}
+	for _, m := range found {
+		to := m.DSN().To()
+		if seen[to] {
+			continue
+		}
+		seen[to] = true
+		unique = append(unique, m)
+	}
+	return unique
+}
@@ -0,0 +1,117 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance_test
+
+import (
+	i "github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/test"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+)
+
+var sample = test.RootDir + "/mysql"
+
+type DiscoverTestSuite struct {
+}
+
+var _ = Suite(&DiscoverTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *DiscoverTestSuite) TestParseMysqldProcesses(t *C) {
+	output, err := ioutil.ReadFile(sample + "/ps001")
+	t.Assert(err, IsNil)
+	got := i.ParseMysqldProcesses(string(output))
+	expect := []i.LocalMySQL{
+		{
+			Socket:  "/var/run/mysqld/mysqld.sock",
+			Port:    "3306",
+			Datadir: "/var/lib/mysql",
+		},
+		{
+			DefaultsFile: "/etc/mysql/my3307.cnf",
+			Datadir:      "/data/mysql3307",
+		},
+		{
+			Socket:  "/tmp/mysql_sandbox8021.sock",
+			Port:    "8021",
+			Datadir: "/home/sandbox/sandboxes/msb_8_0_21/data",
+		},
+		{
+			Socket: "/var/run/mysqld/mysqld.sock",
+			Port:   "3306",
+		},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *DiscoverTestSuite) TestParseMysqldProcessesWindows(t *C) {
+	// wmic process where name='mysqld.exe' get CommandLine
+	output, err := ioutil.ReadFile(sample + "/wmic001")
+	t.Assert(err, IsNil)
+	got := i.ParseMysqldProcesses(string(output))
+	expect := []i.LocalMySQL{
+		{
+			DefaultsFile: `C:\ProgramData\MySQL\MySQL Server 5.6\my.ini`,
+		},
+		{
+			Port:    "3307",
+			Datadir: `C:\mysql\data3307`,
+		},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *DiscoverTestSuite) TestParseMyCnfGroup(t *C) {
+	content, err := ioutil.ReadFile(sample + "/my3307.cnf")
+	t.Assert(err, IsNil)
+	got := i.ParseMyCnfGroup(string(content), "mysqld")
+	expect := map[string]string{
+		"port":              "3307",
+		"socket":            "/var/run/mysqld/mysqld3307.sock",
+		"log-error":         "/var/log/mysql/error3307.log",
+		"skip-name-resolve": "",
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *DiscoverTestSuite) TestLocalMySQLDSN(t *C) {
+	m := i.LocalMySQL{Socket: "/tmp/mysql.sock", Port: "3307"}
+	t.Check(m.DSN(), Equals, mysql.DSN{Socket: "/tmp/mysql.sock"})
+
+	m = i.LocalMySQL{Port: "3307"}
+	t.Check(m.DSN(), Equals, mysql.DSN{Hostname: "127.0.0.1", Port: "3307"})
+
+	m = i.LocalMySQL{}
+	t.Check(m.DSN(), Equals, mysql.DSN{Hostname: "localhost"})
+}
+
+func (s *DiscoverTestSuite) TestMissingPrivileges(t *C) {
+	grants := []string{
+		"GRANT PROCESS, REPLICATION CLIENT ON *.* TO 'percona-agent'@'localhost' IDENTIFIED BY PASSWORD '*ABC'",
+		"GRANT SELECT, UPDATE ON `performance_schema`.* TO 'percona-agent'@'localhost'",
+	}
+	t.Check(i.MissingPrivileges(grants, i.REQUIRED_PRIVILEGES), DeepEquals, []string{"SELECT"})
+
+	grants = []string{"GRANT ALL PRIVILEGES ON *.* TO 'root'@'localhost' WITH GRANT OPTION"}
+	t.Check(i.MissingPrivileges(grants, i.REQUIRED_PRIVILEGES), HasLen, 0)
+
+	grants = []string{"GRANT USAGE ON *.* TO 'percona-agent'@'%'"}
+	t.Check(i.MissingPrivileges(grants, i.REQUIRED_PRIVILEGES), DeepEquals, i.REQUIRED_PRIVILEGES)
+}
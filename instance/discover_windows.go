@@ -0,0 +1,29 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"os/exec"
+)
+
+// processArgs returns the command line of every mysqld process, one per line.
+// Windows has no ps, and wmic can filter by name, so only mysqld are listed.
+func processArgs() (string, error) {
+	out, err := exec.Command("wmic", "process", "where", "name='mysqld.exe'", "get", "CommandLine").Output()
+	return string(out), err
+}
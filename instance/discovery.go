@@ -0,0 +1,226 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Discovered returns the local MySQL found by the last scan that are not
+// instances, i.e. not monitored by the agent.
+func (m *Manager) Discovered() []LocalMySQL {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]LocalMySQL{}, m.discovered...)
+}
+
+// @goroutine[1]
+func (m *Manager) runDiscovery(interval time.Duration) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("MySQL discovery crashed: ", err)
+			m.status.Update("instance-discovery", "Crashed")
+		}
+		m.sync.Done()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.discover()
+		select {
+		case <-ticker.C:
+		case <-m.sync.StopChan:
+			return
+		}
+	}
+}
+
+// @goroutine[1]
+func (m *Manager) discover() {
+	m.status.Update("instance-discovery", "Scanning")
+	found, err := m.DiscoverFunc()
+	if err != nil {
+		m.logger.Warn("Cannot discover MySQL: ", err)
+		m.status.Update("instance-discovery", "Error: "+err.Error())
+		return
+	}
+
+	instances := m.repo.MySQLInstances()
+	known := make(map[string]bool)
+	for _, it := range instances {
+		known[dsnAddr(it.DSN)] = true
+	}
+
+	discovered := []LocalMySQL{}
+	for _, mysqld := range found {
+		if isKnown(mysqld, known) {
+			continue
+		}
+		to := mysqld.DSN().To()
+		if m.config.AutoAdd {
+			it, err := m.addMySQL(mysqld, instances)
+			if err == nil {
+				m.logger.Info(fmt.Sprintf("Added discovered MySQL at %s as mysql-%d", to, it.Id))
+				delete(m.reported, to)
+				continue
+			}
+			if !m.reported[to] {
+				m.logger.Warn(fmt.Sprintf("Cannot add discovered MySQL at %s: %s", to, err))
+			}
+		} else if !m.reported[to] {
+			m.logger.Warn("Discovered MySQL at " + to + " that the agent does not monitor")
+		}
+		m.reported[to] = true
+		discovered = append(discovered, mysqld)
+	}
+
+	m.mux.Lock()
+	m.discovered = discovered
+	m.mux.Unlock()
+
+	if len(discovered) == 0 {
+		m.status.Update("instance-discovery", "Idle")
+		return
+	}
+	to := make([]string, len(discovered))
+	for i, mysqld := range discovered {
+		to[i] = mysqld.DSN().To()
+	}
+	m.status.Update("instance-discovery", fmt.Sprintf("Idle (new MySQL: %s)", strings.Join(to, ", ")))
+}
+
+// addMySQL adds the local MySQL as a new instance in the API and locally.  The
+// agent's MySQL user isn't created like the installer does, so the user and
+// password of the first existing MySQL instance must work.
+func (m *Manager) addMySQL(mysqld LocalMySQL, instances []*proto.MySQLInstance) (*proto.MySQLInstance, error) {
+	if len(instances) == 0 {
+		return nil, errors.New("no MySQL instance to get the user and password from")
+	}
+	dsn := mysqld.DSN()
+	dsn.Username, dsn.Password = dsnUser(instances[0].DSN)
+	dsnString, err := dsn.DSN()
+	if err != nil {
+		return nil, err
+	}
+	it := &proto.MySQLInstance{DSN: dsnString}
+	if err := GetMySQLInfo(it); err != nil {
+		return nil, err
+	}
+	if it, err = m.postMySQLInstance(it); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(it)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.repo.Add("mysql", it.Id, data, true); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// postMySQLInstance creates, or updates if it exists, the instance in the API
+// like the installer does, and returns it with its id.
+func (m *Manager) postMySQLInstance(it *proto.MySQLInstance) (*proto.MySQLInstance, error) {
+	link := m.api.EntryLink("instances")
+	if link == "" {
+		return nil, errors.New("No 'instances' API link")
+	}
+	data, err := json.Marshal(it)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := m.api.Post(m.api.ApiKey(), link+"/mysql", data)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		// API returns URI of existing instance in Location header.
+		if resp, _, err = m.api.Put(m.api.ApiKey(), resp.Header.Get("Location"), data); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Updating MySQL instance returned code %d, expected 200", resp.StatusCode)
+		}
+	} else if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("Creating MySQL instance returned code %d, expected 201", resp.StatusCode)
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return nil, errors.New("API did not return location of MySQL instance")
+	}
+	code, data, err := m.api.Get(m.api.ApiKey(), uri)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("Getting MySQL instance from %s returned code %d, expected 200", uri, code)
+	}
+	newIt := &proto.MySQLInstance{}
+	if err := json.Unmarshal(data, newIt); err != nil {
+		return nil, err
+	}
+	newIt.DSN = it.DSN // API doesn't return the password
+	return newIt, nil
+}
+
+// isKnown returns true if an instance connects to the mysqld by its socket or
+// its port on localhost.
+func isKnown(mysqld LocalMySQL, known map[string]bool) bool {
+	if mysqld.Socket != "" && known[mysqld.Socket] {
+		return true
+	}
+	port := mysqld.Port
+	if port == "" && mysqld.Socket == "" {
+		port = "3306"
+	}
+	return port != "" && known["127.0.0.1:"+port]
+}
+
+// dsnAddr returns the socket or host:port of a DSN like user:pass@unix(/tmp/mysql.sock)/
+// or user:pass@tcp(localhost:3306)/.  localhost is returned as 127.0.0.1.
+func dsnAddr(dsn string) string {
+	addr := dsn[strings.LastIndex(dsn, "@")+1:]
+	if i := strings.Index(addr, "("); i >= 0 {
+		addr = addr[i+1:]
+	}
+	if i := strings.Index(addr, ")"); i >= 0 {
+		addr = addr[:i]
+	}
+	return strings.Replace(addr, "localhost:", "127.0.0.1:", 1)
+}
+
+// dsnUser returns the user and password of a DSN like user:pass@tcp(host:port)/.
+func dsnUser(dsn string) (string, string) {
+	i := strings.LastIndex(dsn, "@")
+	if i < 0 {
+		return "", ""
+	}
+	userPass := strings.SplitN(dsn[:i], ":", 2)
+	if len(userPass) == 1 {
+		return userPass[0], ""
+	}
+	return userPass[0], userPass[1]
+}
@@ -0,0 +1,164 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mysql"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Instance health states
+const (
+	HEALTH_OK          = "ok"
+	HEALTH_UNREACHABLE = "unreachable"
+	HEALTH_AUTH_FAILED = "auth-failed"
+)
+
+// Health is the state of an instance from the last probe.  Since is when it
+// changed to State, so an instance can be unreachable since T.
+type Health struct {
+	State string
+	Since time.Time
+	Error string `json:",omitempty"`
+}
+
+func (h Health) String() string {
+	if h.State == HEALTH_OK {
+		return h.State
+	}
+	return fmt.Sprintf("%s since %s: %s", h.State, h.Since.Format("2006-01-02 15:04:05 MST"), h.Error)
+}
+
+// ProbeMySQL pings MySQL with a new connection, without the retries and
+// backoff of mysql.Connection, and returns its health state.
+func ProbeMySQL(it *proto.MySQLInstance) (string, error) {
+	dsn, err := mysql.DriverDSN(it.DSN)
+	if err != nil {
+		return HEALTH_UNREACHABLE, err
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return HEALTH_UNREACHABLE, err
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		if mysql.MySQLErrorCode(err) == mysql.ER_ACCESS_DENIED_ERROR {
+			return HEALTH_AUTH_FAILED, err
+		}
+		return HEALTH_UNREACHABLE, err
+	}
+	return HEALTH_OK, nil
+}
+
+// SetHealth sets the health of the instance and returns true if its state
+// changed.  Since is only reset when the state changes.
+func (r *Repo) SetHealth(service string, id uint, state string, err error) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	name := r.Name(service, id)
+	if _, ok := r.it[name]; !ok {
+		return false // removed while probing
+	}
+	h := Health{State: state, Since: time.Now().UTC()}
+	if err != nil {
+		h.Error = mysql.FormatError(err)
+	}
+	old, ok := r.health[name]
+	if ok && old.State == state {
+		h.Since = old.Since
+	}
+	r.health[name] = h
+	return !ok || old.State != state
+}
+
+// Health returns the health of the instance, and false if it hasn't been
+// probed yet.
+func (r *Repo) Health(service string, id uint) (Health, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	h, ok := r.health[r.Name(service, id)]
+	return h, ok
+}
+
+// Down returns true if the last probe of the instance failed.  Services can
+// use it to not repeat errors that the instance manager already reports.
+func (r *Repo) Down(service string, id uint) bool {
+	h, ok := r.Health(service, id)
+	return ok && h.State != HEALTH_OK
+}
+
+// healthStatus returns the health of probed instances for Status, e.g.
+// "mysql-1: ok, mysql-2: unreachable since ...".
+func (r *Repo) healthStatus() string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	names := make([]string, 0, len(r.health))
+	for name := range r.health {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	status := make([]string, len(names))
+	for i, name := range names {
+		status[i] = name + ": " + r.health[name].String()
+	}
+	return strings.Join(status, ", ")
+}
+
+// @goroutine[2]
+func (m *Manager) runProber(interval time.Duration) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Instance prober crashed: ", err)
+		}
+		m.probeSync.Done()
+	}()
+
+	// First probe after one interval so a starting agent isn't delayed by
+	// instances that aren't up yet; services report their own errors until then.
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.probe()
+		case <-m.probeSync.StopChan:
+			return
+		}
+	}
+}
+
+// @goroutine[2]
+func (m *Manager) probe() {
+	for _, it := range m.repo.MySQLInstances() {
+		state, err := m.ProbeFunc(it)
+		if !m.repo.SetHealth("mysql", it.Id, state, err) {
+			continue
+		}
+		name := m.repo.Name("mysql", it.Id)
+		if state == HEALTH_OK {
+			m.logger.Info(name + " is ok")
+		} else {
+			m.logger.Warn(fmt.Sprintf("%s is %s: %s", name, state, mysql.FormatError(err)))
+		}
+	}
+}
@@ -24,15 +24,29 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Manager struct {
 	logger    *pct.Logger
 	configDir string
+	api       pct.APIConnector
 	// --
-	status *pct.Status
-	repo   *Repo
+	status   *pct.Status
+	repo     *Repo
+	services map[string]pct.ServiceManager
+	// --
+	DiscoverFunc func() ([]LocalMySQL, error)               // DiscoverMySQL, or mock for testing
+	ProbeFunc    func(*proto.MySQLInstance) (string, error) // ProbeMySQL, or mock for testing
+	config       *Config
+	discovered   []LocalMySQL
+	reported     map[string]bool // discovered MySQL logged, by DSN().To()
+	mux          *sync.Mutex     // guards discovered
+	sync         *pct.SyncChan
+	probeSync    *pct.SyncChan
 }
 
 func NewManager(logger *pct.Logger, configDir string, api pct.APIConnector) *Manager {
@@ -40,9 +54,15 @@ func NewManager(logger *pct.Logger, configDir string, api pct.APIConnector) *Man
 	m := &Manager{
 		logger:    logger,
 		configDir: configDir,
+		api:       api,
 		// --
-		status: pct.NewStatus([]string{"instance", "instance-repo"}),
+		status: pct.NewStatus([]string{"instance", "instance-repo", "instance-discovery", "instance-health", "instance-connections"}),
 		repo:   repo,
+		// --
+		DiscoverFunc: DiscoverMySQL,
+		ProbeFunc:    ProbeMySQL,
+		reported:     make(map[string]bool),
+		mux:          &sync.Mutex{},
 	}
 	return m
 }
@@ -57,6 +77,33 @@ func (m *Manager) Start() error {
 	if err := m.repo.Init(); err != nil {
 		return err
 	}
+	for _, err := range m.repo.Refresh() {
+		m.logger.Warn(err)
+	}
+
+	// Load config from disk (optional: discover but don't add MySQL by default).
+	config := &Config{}
+	if err := pct.Basedir.ReadConfig("instance", config); err != nil {
+		return err
+	}
+	m.config = config
+	mysql.Connections.SetMaxConnections(config.MaxConnections)
+	if config.ValidateDSN {
+		m.repo.ValidateFunc = ValidateMySQL
+	}
+	if interval := config.discoverInterval(); interval > 0 {
+		m.sync = pct.NewSyncChan()
+		go m.runDiscovery(interval)
+	} else {
+		m.status.Update("instance-discovery", "Disabled")
+	}
+	if interval := config.probeInterval(); interval > 0 {
+		m.probeSync = pct.NewSyncChan()
+		go m.runProber(interval)
+	} else {
+		m.status.Update("instance-health", "Disabled")
+	}
+
 	m.logger.Info("Started")
 	m.status.Update("instance", "Running")
 	return nil
@@ -64,7 +111,19 @@ func (m *Manager) Start() error {
 
 // @goroutine[0]
 func (m *Manager) Stop() error {
-	// Can't stop the instance manager.
+	// Can't stop the instance manager, only discovery and the prober.
+	if m.sync != nil {
+		m.sync.Stop()
+		m.sync.Wait()
+		m.sync = nil
+		m.status.Update("instance-discovery", "Stopped")
+	}
+	if m.probeSync != nil {
+		m.probeSync.Stop()
+		m.probeSync.Wait()
+		m.probeSync = nil
+		m.status.Update("instance-health", "Stopped")
+	}
 	return nil
 }
 
@@ -73,6 +132,10 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	m.status.UpdateRe("instance", "Handling", cmd)
 	defer m.status.Update("instance", "Running")
 
+	if cmd.Cmd == "GetDiscovered" {
+		return cmd.Reply(m.Discovered())
+	}
+
 	it := &proto.ServiceInstance{}
 	if err := json.Unmarshal(cmd.Data, it); err != nil {
 		return cmd.Reply(nil, err)
@@ -81,8 +144,24 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	switch cmd.Cmd {
 	case "Add":
 		err := m.repo.Add(it.Service, it.InstanceId, it.Instance, true) // true = write to disk
+		if verr, ok := err.(*ValidationError); ok {
+			return cmd.Reply(verr, err)
+		}
 		return cmd.Reply(nil, err)
+	case "Update":
+		errs := m.update(it.Service, it.InstanceId, it.Instance)
+		if len(errs) == 1 {
+			if verr, ok := errs[0].(*ValidationError); ok {
+				return cmd.Reply(verr, verr)
+			}
+		}
+		return cmd.Reply(nil, errs...)
 	case "Remove":
+		// Stop what uses the instance first, else it'd keep running (and
+		// start again on restart) with an instance that doesn't exist.
+		if errs := m.stopDependents(m.dependents(it.Service, it.InstanceId)); len(errs) > 0 {
+			return cmd.Reply(nil, errs...)
+		}
 		err := m.repo.Remove(it.Service, it.InstanceId)
 		return cmd.Reply(nil, err)
 	case "GetInfo":
@@ -95,6 +174,10 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 
 func (m *Manager) Status() map[string]string {
 	m.status.Update("instance-repo", strings.Join(m.repo.List(), " "))
+	if m.probeSync != nil {
+		m.status.Update("instance-health", m.repo.healthStatus())
+	}
+	m.status.Update("instance-connections", connStatus(mysql.Connections.Stats()))
 	return m.status.All()
 }
 
@@ -106,6 +189,12 @@ func (m *Manager) Repo() *Repo {
 	return m.repo
 }
 
+// SetServices sets the agent's services so that Update and Remove can restart
+// or stop the ones that use an instance, e.g. mm-mysql-1 and qan.
+func (m *Manager) SetServices(services map[string]pct.ServiceManager) {
+	m.services = services
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
@@ -129,6 +218,124 @@ func (m *Manager) handleGetInfo(service string, data []byte) (interface{}, error
 	}
 }
 
+// A dependent is a service config that uses an instance, e.g. the config of
+// the mm-mysql-1 monitor in the mm service.
+type dependent struct {
+	service string
+	config  proto.AgentConfig
+}
+
+// dependents returns the configs of running services that use the instance.
+func (m *Manager) dependents(service string, id uint) []dependent {
+	// Sorted so services are always stopped and started in the same order.
+	names := make([]string, 0, len(m.services))
+	for name := range m.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	deps := []dependent{}
+	for _, name := range names {
+		manager := m.services[name]
+		if name == "instance" || manager == nil {
+			continue
+		}
+		configs, _ := manager.GetConfig()
+		for _, config := range configs {
+			if !config.Running {
+				continue
+			}
+			// Service configs embed proto.ServiceInstance, but not all
+			// managers return it as the ExternalService, e.g. qan.
+			it := config.ExternalService
+			if it.Service == "" {
+				json.Unmarshal([]byte(config.Config), &it)
+			}
+			if it.Service == service && it.InstanceId == id {
+				deps = append(deps, dependent{name, config})
+			}
+		}
+	}
+	return deps
+}
+
+// stopDependents stops the dependents in order.  If one cannot be stopped,
+// it stops no more and restarts the ones it stopped, so the instance is either
+// not used or used as before.
+func (m *Manager) stopDependents(deps []dependent) []error {
+	for n, dep := range deps {
+		m.logger.Info("Stopping", dep.service, dep.config.Config)
+		cmd := &proto.Cmd{Ts: time.Now().UTC(), Service: dep.service, Cmd: "StopService", Data: []byte(dep.config.Config)}
+		if reply := m.services[dep.service].Handle(cmd); reply.Error != "" {
+			errs := []error{fmt.Errorf("Cannot stop %s: %s", dep.service, reply.Error)}
+			return append(errs, m.startDependents(deps[:n])...)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) startDependents(deps []dependent) []error {
+	errs := []error{}
+	for _, dep := range deps {
+		m.logger.Info("Starting", dep.service, dep.config.Config)
+		cmd := &proto.Cmd{Ts: time.Now().UTC(), Service: dep.service, Cmd: "StartService", Data: []byte(dep.config.Config)}
+		if reply := m.services[dep.service].Handle(cmd); reply.Error != "" {
+			errs = append(errs, fmt.Errorf("Cannot start %s: %s", dep.service, reply.Error))
+		}
+	}
+	return errs
+}
+
+// update validates the new instance, e.g. that the agent can connect to MySQL
+// with the new DSN, then updates it and restarts the services using it so they
+// use the new instance.  If the repo validates instances, its *ValidationError
+// is returned alone.
+func (m *Manager) update(service string, id uint, data []byte) []error {
+	if m.repo.ValidateFunc != nil {
+		if err := m.repo.Validate(service, id, data); err != nil {
+			return []error{err}
+		}
+	} else if service == "mysql" {
+		it := &proto.MySQLInstance{}
+		if err := json.Unmarshal(data, it); err != nil {
+			return []error{errors.New("instance.Repo:json.Unmarshal:" + err.Error())}
+		}
+		if it.DSN == "" {
+			return []error{fmt.Errorf("MySQL instance DSN is not set")}
+		}
+		conn := mysql.NewConnection(it.DSN)
+		if err := conn.Connect(1); err != nil {
+			return []error{err}
+		}
+		conn.Close()
+	}
+	deps := m.dependents(service, id)
+	if errs := m.stopDependents(deps); len(errs) > 0 {
+		return errs
+	}
+	errs := []error{}
+	if err := m.repo.Update(service, id, data); err != nil {
+		errs = append(errs, err) // restart dependents with the old instance
+	}
+	return append(errs, m.startDependents(deps)...)
+}
+
+// connStatus returns the MySQL connections shared by all services, e.g.
+// "user:***@tcp(127.0.0.1:3306)/: 2/10 open, 4 refs".
+func connStatus(stats []mysql.ConnStats) string {
+	if len(stats) == 0 {
+		return "None"
+	}
+	status := make([]string, len(stats))
+	for i, s := range stats {
+		max := "unlimited"
+		if s.MaxOpen > 0 {
+			max = fmt.Sprintf("%d", s.MaxOpen)
+		}
+		status[i] = fmt.Sprintf("%s: %d/%s open, %d refs", s.DSN, s.Open, max, s.Refs)
+	}
+	return strings.Join(status, ", ")
+}
+
 func GetMySQLInfo(it *proto.MySQLInstance) error {
 	conn := mysql.NewConnection(it.DSN)
 	if err := conn.Connect(1); err != nil {
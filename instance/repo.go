@@ -28,18 +28,29 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
+// Repo is the instance configs in configDir, one per registered Type.  If the
+// instance key file (basedir/instance.key) exists, MySQL DSNs and other type
+// secrets are encrypted on disk with it, and plaintext ones on disk are
+// encrypted when loaded.
 type Repo struct {
 	logger    *pct.Logger
 	configDir string
 	api       pct.APIConnector
+	keyFile   string
 	// --
-	it  map[string]interface{}
-	mux *sync.RWMutex
+	ValidateFunc func(*proto.MySQLInstance) error // ValidateMySQL, or nil to not validate
+	it           map[string]interface{}
+	health       map[string]Health
+	cache        map[string]pct.CacheValidators
+	crypter      *Crypter
+	keyLoaded    bool
+	mux          *sync.RWMutex
 }
 
 func NewRepo(logger *pct.Logger, configDir string, api pct.APIConnector) *Repo {
@@ -47,20 +58,25 @@ func NewRepo(logger *pct.Logger, configDir string, api pct.APIConnector) *Repo {
 		logger:    logger,
 		configDir: configDir,
 		api:       api,
+		keyFile:   pct.Basedir.File("instance-key"),
 		// --
-		it:  make(map[string]interface{}),
-		mux: &sync.RWMutex{},
+		it:     make(map[string]interface{}),
+		health: make(map[string]Health),
+		cache:  make(map[string]pct.CacheValidators),
+		mux:    &sync.RWMutex{},
 	}
 	return m
 }
 
 func (r *Repo) Init() error {
-	for service, _ := range proto.ExternalService {
+	for _, service := range Types() {
 		if err := r.loadInstances(service); err != nil {
 			return fmt.Errorf("%s: %s", service, err)
 		}
 	}
-	return nil
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.loadCache()
 }
 
 func (r *Repo) loadInstances(service string) error {
@@ -72,19 +88,9 @@ func (r *Repo) loadInstances(service string) error {
 	for _, file := range files {
 		r.logger.Debug("Reading " + file)
 
-		// 0       1
-		// service-id
-		part := strings.Split(strings.TrimSuffix(filepath.Base(file), ".conf"), "-")
-		if len(part) != 2 {
-			return errors.New("Invalid instance file name: " + file)
-		}
-		service := part[0]
-		id, err := strconv.ParseUint(part[1], 10, 32)
+		service, id, err := parseName(strings.TrimSuffix(filepath.Base(file), ".conf"))
 		if err != nil {
-			return err
-		}
-		if !valid(service, uint(id)) {
-			return pct.InvalidServiceInstanceError{Service: service, Id: uint(id)}
+			return errors.New(file + ": " + err.Error())
 		}
 
 		data, err := ioutil.ReadFile(file)
@@ -92,7 +98,7 @@ func (r *Repo) loadInstances(service string) error {
 			return errors.New(file + ":" + err.Error())
 		}
 
-		if err := r.Add(service, uint(id), data, false); err != nil {
+		if err := r.Add(service, id, data, false); err != nil {
 			return errors.New(file + ":" + err.Error())
 		}
 
@@ -101,6 +107,9 @@ func (r *Repo) loadInstances(service string) error {
 	return nil
 }
 
+// Add adds the instance.  If writeToDisk is true, i.e. the instance is new,
+// and ValidateFunc is set, a MySQL instance is validated first; the error is
+// a *ValidationError if it's invalid.
 func (r *Repo) Add(service string, id uint, data []byte, writeToDisk bool) error {
 	r.logger.Debug("Add:call")
 	defer r.logger.Debug("Add:return")
@@ -109,6 +118,12 @@ func (r *Repo) Add(service string, id uint, data []byte, writeToDisk bool) error
 		return pct.InvalidServiceInstanceError{Service: service, Id: id}
 	}
 
+	if writeToDisk {
+		if err := r.Validate(service, id, data); err != nil {
+			return err
+		}
+	}
+
 	r.mux.Lock()
 	defer r.mux.Unlock()
 
@@ -119,22 +134,31 @@ func (r *Repo) add(service string, id uint, data []byte, writeToDisk bool) error
 	r.logger.Debug("add:call")
 	defer r.logger.Debug("add:return")
 
-	var info interface{}
-	switch service {
-	case "server":
-		it := &proto.ServerInstance{}
-		if err := json.Unmarshal(data, it); err != nil {
-			return errors.New("instance.Repo:json.Unmarshal:" + err.Error())
+	t, ok := getType(service)
+	if !ok {
+		return errors.New(fmt.Sprintf("Invalid service name: %s", service))
+	}
+	info := t.New()
+	if err := json.Unmarshal(data, info); err != nil {
+		return errors.New("instance.Repo:json.Unmarshal:" + err.Error())
+	}
+	migrate := false
+	if t.Secret != nil {
+		secret := t.Secret(info)
+		crypter, err := r.getCrypter()
+		if err != nil {
+			return err
 		}
-		info = it
-	case "mysql":
-		it := &proto.MySQLInstance{}
-		if err := json.Unmarshal(data, it); err != nil {
-			return errors.New("instance.Repo:json.Unmarshal:" + err.Error())
+		if IsEncrypted(*secret) {
+			if crypter == nil {
+				return fmt.Errorf("DSN is encrypted but %s does not exist", r.keyFile)
+			}
+			if *secret, err = crypter.Decrypt(*secret); err != nil {
+				return err
+			}
+		} else if crypter != nil && *secret != "" && !writeToDisk {
+			migrate = true // plaintext DSN on disk
 		}
-		info = it
-	default:
-		return errors.New(fmt.Sprintf("Invalid service name: %s", service))
 	}
 
 	name := r.Name(service, id)
@@ -142,11 +166,15 @@ func (r *Repo) add(service string, id uint, data []byte, writeToDisk bool) error
 		return pct.DuplicateServiceInstanceError{Service: service, Id: id}
 	}
 
-	if writeToDisk {
-		if err := pct.Basedir.WriteConfig(name, info); err != nil {
+	if writeToDisk || migrate {
+		if err := r.write(service, name, info); err != nil {
 			return err
 		}
-		r.logger.Info("Added " + name)
+		if migrate {
+			r.logger.Info("Encrypted DSN in " + name)
+		} else {
+			r.logger.Info("Added " + name)
+		}
 	}
 
 	r.it[name] = info
@@ -187,7 +215,7 @@ func (r *Repo) get(service string, id uint, info interface{}) error {
 		}
 		url := fmt.Sprintf("%s/%s/%d", link, service, id)
 		r.logger.Info("GET", url)
-		code, data, err := r.api.Get(r.api.ApiKey(), url)
+		code, data, validators, err := r.api.GetIfModified(r.api.ApiKey(), url, pct.CacheValidators{})
 		if err != nil {
 			return fmt.Errorf("Failed to get %s instance from %s: %s", name, link, err)
 		} else if code != 200 {
@@ -199,6 +227,13 @@ func (r *Repo) get(service string, id uint, info interface{}) error {
 			if err := r.add(service, uint(id), data, true); err != nil {
 				return fmt.Errorf("Failed to add new instance: %s", err)
 			}
+			// Save its cache validators so Refresh can re-get it conditionally.
+			if validators.ETag != "" || validators.LastModified != "" {
+				r.cache[name] = validators
+				if err := r.saveCache(); err != nil {
+					r.logger.Warn(err)
+				}
+			}
 			// Recurse to re-get and return new instance.
 			return r.get(service, id, info)
 		}
@@ -245,12 +280,108 @@ func (r *Repo) Remove(service string, id uint) error {
 	}
 
 	delete(r.it, name)
+	delete(r.health, name)
+	if _, ok := r.cache[name]; ok {
+		delete(r.cache, name)
+		if err := r.saveCache(); err != nil {
+			r.logger.Warn(err)
+		}
+	}
 	r.logger.Info("Removed " + name)
 	return nil
 }
 
+// Update replaces an existing instance, on disk too.
+func (r *Repo) Update(service string, id uint, data []byte) error {
+	r.logger.Debug("Update:call")
+	defer r.logger.Debug("Update:return")
+
+	if !valid(service, id) {
+		return pct.InvalidServiceInstanceError{Service: service, Id: id}
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	name := r.Name(service, id)
+	old, ok := r.it[name]
+	if !ok {
+		return pct.UnknownServiceInstanceError{Service: service, Id: id}
+	}
+	delete(r.it, name)
+	if err := r.add(service, id, data, true); err != nil {
+		r.it[name] = old
+		return err
+	}
+	delete(r.health, name) // not probed with the new instance yet
+	return nil
+}
+
+// Validate validates a new MySQL instance with ValidateFunc.  It returns nil
+// if ValidateFunc is not set, for other services, and for encrypted DSNs which
+// only come from disk.
+func (r *Repo) Validate(service string, id uint, data []byte) error {
+	if r.ValidateFunc == nil || service != "mysql" {
+		return nil
+	}
+	it := &proto.MySQLInstance{}
+	if err := json.Unmarshal(data, it); err != nil {
+		return errors.New("instance.Repo:json.Unmarshal:" + err.Error())
+	}
+	if IsEncrypted(it.DSN) {
+		return nil
+	}
+	it.Id = id
+	if err := r.ValidateFunc(it); err != nil {
+		r.logger.Warn(err)
+		return err
+	}
+	return nil
+}
+
+// write writes the instance config, with its secret (e.g. the MySQL DSN)
+// encrypted if there's an instance key.
+func (r *Repo) write(service, name string, info interface{}) error {
+	if t, _ := getType(service); t.Secret != nil && r.crypter != nil {
+		// Encrypt a copy; the instance in memory keeps the plaintext secret.
+		encrypted := reflect.New(reflect.TypeOf(info).Elem())
+		encrypted.Elem().Set(reflect.ValueOf(info).Elem())
+		secret := t.Secret(encrypted.Interface())
+		ciphertext, err := r.crypter.Encrypt(*secret)
+		if err != nil {
+			return err
+		}
+		*secret = ciphertext
+		info = encrypted.Interface()
+	}
+	return pct.Basedir.WriteConfig(name, info)
+}
+
+// getCrypter returns the Crypter for the instance key, or nil if there's no
+// key file.  Caller must lock r.mux.
+func (r *Repo) getCrypter() (*Crypter, error) {
+	if r.keyLoaded {
+		return r.crypter, nil
+	}
+	key, err := LoadKey(r.keyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.keyLoaded = true
+			return nil, nil
+		}
+		return nil, err
+	}
+	crypter, err := NewCrypter(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", r.keyFile, err)
+	}
+	r.crypter = crypter
+	r.keyLoaded = true
+	return crypter, nil
+}
+
 func valid(service string, id uint) bool {
-	if _, ok := proto.ExternalService[service]; !ok {
+	if _, ok := getType(service); !ok {
 		return false
 	}
 	if id == 0 {
@@ -259,10 +390,50 @@ func valid(service string, id uint) bool {
 	return true
 }
 
+// parseName parses an instance name like mysql-1 into its service and id.
+func parseName(name string) (string, uint, error) {
+	// 0       1
+	// service-id
+	part := strings.Split(name, "-")
+	if len(part) != 2 {
+		return "", 0, errors.New("Invalid instance name: " + name)
+	}
+	service := part[0]
+	id, err := strconv.ParseUint(part[1], 10, 32)
+	if err != nil {
+		return "", 0, err
+	}
+	if !valid(service, uint(id)) {
+		return "", 0, pct.InvalidServiceInstanceError{Service: service, Id: uint(id)}
+	}
+	return service, uint(id), nil
+}
+
 func (r *Repo) Name(service string, id uint) string {
 	return fmt.Sprintf("%s-%d", service, id)
 }
 
+// MySQLInstances returns copies of the MySQL instances, sorted by id.
+func (r *Repo) MySQLInstances() []*proto.MySQLInstance {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	instances := []*proto.MySQLInstance{}
+	for _, info := range r.it {
+		if it, ok := info.(*proto.MySQLInstance); ok {
+			copy := *it
+			instances = append(instances, &copy)
+		}
+	}
+	sort.Sort(byId(instances))
+	return instances
+}
+
+type byId []*proto.MySQLInstance
+
+func (a byId) Len() int           { return len(a) }
+func (a byId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byId) Less(i, j int) bool { return a[i].Id < a[j].Id }
+
 func (r *Repo) List() []string {
 	r.mux.Lock()
 	defer r.mux.Unlock()
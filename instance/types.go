@@ -0,0 +1,84 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"sort"
+	"sync"
+)
+
+// A Type is a kind of instance that the repo can hold, e.g. mysql.  Packages
+// for other services register their instance type in init(), like:
+//
+//	instance.RegisterType("mongo", instance.Type{
+//	    New: func() interface{} { return &mongo.Instance{} },
+//	})
+type Type struct {
+	// New returns a pointer to a new, empty instance struct which the
+	// instance JSON is decoded into.
+	New func() interface{}
+
+	// Secret returns a pointer to the instance field that is encrypted on
+	// disk with the instance key, e.g. the MySQL DSN, or nil if none.
+	Secret func(it interface{}) *string
+}
+
+var typesMux = &sync.RWMutex{}
+var types = map[string]Type{
+	"server": {
+		New: func() interface{} { return &proto.ServerInstance{} },
+	},
+	"mysql": {
+		New:    func() interface{} { return &proto.MySQLInstance{} },
+		Secret: func(it interface{}) *string { return &it.(*proto.MySQLInstance).DSN },
+	},
+}
+
+// RegisterType makes an instance type available to the repo.  It panics if
+// the type is registered twice or New is nil, like sql.Register.
+func RegisterType(service string, t Type) {
+	typesMux.Lock()
+	defer typesMux.Unlock()
+	if t.New == nil {
+		panic("instance: RegisterType " + service + ": New is nil")
+	}
+	if _, dup := types[service]; dup {
+		panic("instance: RegisterType called twice for " + service)
+	}
+	types[service] = t
+}
+
+// Types returns the registered instance types, sorted.
+func Types() []string {
+	typesMux.RLock()
+	defer typesMux.RUnlock()
+	services := make([]string, 0, len(types))
+	for service := range types {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+func getType(service string) (Type, bool) {
+	typesMux.RLock()
+	defer typesMux.RUnlock()
+	t, ok := types[service]
+	return t, ok
+}
@@ -0,0 +1,114 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mysql"
+	"regexp"
+	"strings"
+)
+
+// Global privileges the agent needs at least, like the installer's minimal grants.
+var REQUIRED_PRIVILEGES = []string{"PROCESS", "REPLICATION CLIENT", "SELECT"}
+
+// A ValidationError is why an instance is invalid.  It's returned as the data
+// and the error of the Add or Update reply.
+type ValidationError struct {
+	Service           string
+	InstanceId        uint
+	DSN               string   // password hidden
+	Connect           string   `json:",omitempty"` // connection error
+	MissingPrivileges []string `json:",omitempty"`
+}
+
+func (e *ValidationError) Error() string {
+	name := fmt.Sprintf("%s-%d", e.Service, e.InstanceId)
+	if e.Connect != "" {
+		return fmt.Sprintf("Invalid %s: cannot connect with DSN %s: %s", name, e.DSN, e.Connect)
+	}
+	return fmt.Sprintf("Invalid %s: DSN %s lacks global privileges: %s", name, e.DSN, strings.Join(e.MissingPrivileges, ", "))
+}
+
+// ValidateMySQL connects to MySQL with the instance DSN and checks that its
+// user has REQUIRED_PRIVILEGES.  It returns a *ValidationError if not.
+func ValidateMySQL(it *proto.MySQLInstance) error {
+	verr := &ValidationError{
+		Service:    "mysql",
+		InstanceId: it.Id,
+		DSN:        mysql.HideDSNPassword(it.DSN),
+	}
+	if it.DSN == "" {
+		verr.Connect = "DSN is not set"
+		return verr
+	}
+	conn := mysql.NewConnection(it.DSN)
+	if err := conn.Connect(1); err != nil {
+		verr.Connect = mysql.FormatError(err)
+		return verr
+	}
+	defer conn.Close()
+
+	rows, err := conn.DB().Query("SHOW GRANTS")
+	if err != nil {
+		verr.Connect = mysql.FormatError(err)
+		return verr
+	}
+	defer rows.Close()
+	grants := []string{}
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			verr.Connect = mysql.FormatError(err)
+			return verr
+		}
+		grants = append(grants, grant)
+	}
+	if verr.MissingPrivileges = MissingPrivileges(grants, REQUIRED_PRIVILEGES); len(verr.MissingPrivileges) > 0 {
+		return verr
+	}
+	return nil
+}
+
+var globalGrantRe = regexp.MustCompile(`^GRANT (.+) ON \*\.\* TO `)
+
+// MissingPrivileges returns the required global privileges not in the
+// SHOW GRANTS output.
+func MissingPrivileges(grants []string, required []string) []string {
+	have := make(map[string]bool)
+	for _, grant := range grants {
+		m := globalGrantRe.FindStringSubmatch(grant)
+		if m == nil {
+			continue
+		}
+		for _, priv := range strings.Split(m[1], ",") {
+			have[strings.ToUpper(strings.TrimSpace(priv))] = true
+		}
+	}
+	if have["ALL PRIVILEGES"] || have["ALL"] {
+		return nil
+	}
+	missing := []string{}
+	for _, priv := range required {
+		if !have[priv] {
+			missing = append(missing, priv)
+		}
+	}
+	return missing
+}
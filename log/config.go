@@ -18,12 +18,35 @@
 package log
 
 const (
-	DEFAULT_LOG_FILE  = ""
-	DEFAULT_LOG_LEVEL = "info"
+	DEFAULT_LOG_FILE      = ""
+	DEFAULT_LOG_LEVEL     = "info"
+	DEFAULT_LOG_MAX_SIZE  = 100 * 1024 * 1024 // bytes
+	DEFAULT_LOG_MAX_FILES = 5
 )
 
 type Config struct {
 	Level   string
 	File    string
 	Offline bool
+	// Rotation of File: File.1 (newest) to File.MaxFiles
+	MaxSize  int64 // bytes, rotate when larger; 0 = DEFAULT_LOG_MAX_SIZE, < 0 = never
+	MaxAge   uint  // days, rotate when older; 0 = never
+	MaxFiles int   // rotated files kept; 0 = DEFAULT_LOG_MAX_FILES
+	Compress bool  // gzip rotated files: File.1.gz, etc.
+}
+
+// LogLevel is the SetLogLevel cmd data.  It changes the log level of all
+// services (Service is empty) or one service and its sub-services until
+// changed again, or for Duration seconds, then reverts.  It's not saved.
+type LogLevel struct {
+	Service  string `json:",omitempty"`
+	Level    string // empty to revert Service to the global log level
+	Duration uint   `json:",omitempty"` // seconds, 0 = until changed
+}
+
+// ServiceLevel sets (or unsets) a service's log level in the relay.
+type ServiceLevel struct {
+	Service string
+	Level   byte
+	Unset   bool
 }
@@ -0,0 +1,56 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package log
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"hash/fnv"
+)
+
+// ANSI colors for service prefixes, chosen by service name so a service
+// always has the same color.  Red and yellow are left for errors and warnings.
+var serviceColors = []int{32, 34, 35, 36, 92, 94, 95, 96}
+
+const (
+	COLOR_RED    = 31
+	COLOR_YELLOW = 33
+)
+
+// FormatConsole formats a log entry for the console (-foreground), with
+// colorized service prefixes and levels if color is true.
+func FormatConsole(entry *proto.LogEntry, color bool) string {
+	ts := entry.Ts.Local().Format("15:04:05.000000")
+	service := entry.Service
+	level := proto.LogLevelName[entry.Level]
+	if color {
+		h := fnv.New32a()
+		h.Write([]byte(service))
+		service = colorize(service, serviceColors[h.Sum32()%uint32(len(serviceColors))])
+		if entry.Level <= proto.LOG_ERROR {
+			level = colorize(level, COLOR_RED)
+		} else if entry.Level == proto.LOG_WARNING {
+			level = colorize(level, COLOR_YELLOW)
+		}
+	}
+	return fmt.Sprintf("%s %s: %s: %s\n", ts, service, level, entry.Msg)
+}
+
+func colorize(s string, color int) string {
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", color, s)
+}
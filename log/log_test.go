@@ -18,6 +18,7 @@
 package log_test
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
@@ -133,6 +134,26 @@ func (s *RelayTestSuite) TestLogLevel(t *C) {
 	t.Check(got, DeepEquals, expect)
 }
 
+func (s *RelayTestSuite) TestFormatConsole(t *C) {
+	entry := &proto.LogEntry{
+		Ts:      time.Date(2015, 1, 2, 3, 4, 5, 6000, time.Local),
+		Level:   proto.LOG_WARNING,
+		Service: "qan",
+		Msg:     "Slow log rotated",
+	}
+	t.Check(log.FormatConsole(entry, false), Equals, "03:04:05.000006 qan: warning: Slow log rotated\n")
+
+	// Service prefix and warning level are colorized, message isn't.
+	line := log.FormatConsole(entry, true)
+	t.Check(line, Matches, "03:04:05.000006 \x1b\\[[39][2-6]mqan\x1b\\[0m: \x1b\\[33mwarning\x1b\\[0m: Slow log rotated\n")
+
+	// Same service, same color.
+	entry.Level = proto.LOG_INFO
+	line2 := log.FormatConsole(entry, true)
+	t.Check(line2[:strings.Index(line2, "qan")], Equals, line[:strings.Index(line, "qan")])
+	t.Check(strings.Contains(line2, " info: "), Equals, true)
+}
+
 func (s *RelayTestSuite) TestLogFile(t *C) {
 	/**
 	 * This test is going to be a real pain in the ass because it writes/reads
@@ -661,6 +682,109 @@ func (s *ManagerTestSuite) TestLogService(t *C) {
 	t.Check(status["log-level"], Equals, "warning")
 }
 
+func (s *ManagerTestSuite) TestSetLogLevel(t *C) {
+	pct.Basedir.WriteConfig("log", &log.Config{Level: "info"})
+
+	// Own channels: relays of other tests' managers are still running.
+	recvChan := make(chan interface{}, log.BUFFER_SIZE*3)
+	client := mock.NewWebsocketClient(nil, nil, make(chan interface{}, 5), recvChan)
+	m := log.NewManager(client, make(chan *proto.LogEntry, log.BUFFER_SIZE*3))
+	err := m.Start()
+	t.Assert(err, IsNil)
+	test.WaitLog(recvChan, 2) // Started, Connected to API
+
+	setLogLevel := func(logLevel log.LogLevel) string {
+		data, _ := json.Marshal(logLevel)
+		reply := m.Handle(&proto.Cmd{Service: "log", Cmd: "SetLogLevel", Data: data})
+		return reply.Error
+	}
+	t.Check(setLogLevel(log.LogLevel{Level: "verbose"}), Equals, "Invalid log level: verbose")
+	t.Check(setLogLevel(log.LogLevel{}), Matches, "Log level is required.+")
+
+	// Debug only data and its sub-services, for 1s.
+	t.Check(setLogLevel(log.LogLevel{Service: "data", Level: "debug", Duration: 1}), Equals, "")
+	t.Check(test.WaitStatus(1, m, "log-level", "info (data=debug)"), Equals, true)
+	pct.NewLogger(m.Relay().LogChan(), "qan").Debug("qan debug")
+	pct.NewLogger(m.Relay().LogChan(), "data-sender").Debug("data debug")
+	got := test.WaitLog(recvChan, 1)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Msg, Equals, "data debug")
+
+	// Then it reverts to the global log level.
+	time.Sleep(1 * time.Second)
+	t.Check(test.WaitStatus(1, m, "log-level", "info"), Equals, true)
+	pct.NewLogger(m.Relay().LogChan(), "data-sender").Debug("data debug")
+	got = test.WaitLog(recvChan, 1)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Msg, Equals, "Reverted log level of data")
+}
+
+func (s *ManagerTestSuite) TestGetRecentLogs(t *C) {
+	pct.Basedir.WriteConfig("log", &log.Config{Level: "info"})
+
+	// Own channels: relays of other tests' managers are still running.
+	recvChan := make(chan interface{}, log.BUFFER_SIZE*3)
+	client := mock.NewWebsocketClient(nil, nil, make(chan interface{}, 5), recvChan)
+	// More than cap(TraceChan) entries are sent, so drain it.
+	doneChan := make(chan bool)
+	defer close(doneChan)
+	go func() {
+		for {
+			select {
+			case <-client.TraceChan:
+			case <-doneChan:
+				return
+			}
+		}
+	}()
+	m := log.NewManager(client, make(chan *proto.LogEntry, log.BUFFER_SIZE*3))
+	err := m.Start()
+	t.Assert(err, IsNil)
+	test.WaitLog(recvChan, 2) // Started, Connected to API
+
+	getRecentLogs := func(q log.RecentLogsQuery) []proto.LogEntry {
+		data, _ := json.Marshal(q)
+		reply := m.Handle(&proto.Cmd{Service: "log", Cmd: "GetRecentLogs", Data: data})
+		t.Assert(reply.Error, Equals, "")
+		entries := []proto.LogEntry{}
+		t.Assert(json.Unmarshal(reply.Data, &entries), IsNil)
+		return entries
+	}
+	waitRecentLogs := func(q log.RecentLogsQuery, n int) []proto.LogEntry {
+		var entries []proto.LogEntry
+		for i := 0; i < 20; i++ {
+			if entries = getRecentLogs(q); len(entries) >= n {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return entries
+	}
+
+	data := pct.NewLogger(m.Relay().LogChan(), "data-sender")
+	data.SetDedupWindow(0)
+	qan := pct.NewLogger(m.Relay().LogChan(), "qan")
+	qan.Warn("qan warning")
+	data.Debug("not logged at info")
+	for i := 1; i <= log.RECENT_SIZE+5; i++ {
+		data.Info(fmt.Sprintf("data %d", i))
+	}
+
+	// Only the last RECENT_SIZE entries of a service are kept.
+	got := waitRecentLogs(log.RecentLogsQuery{Service: "data"}, log.RECENT_SIZE)
+	t.Assert(got, HasLen, log.RECENT_SIZE)
+	t.Check(got[0].Msg, Equals, "data 6")
+	t.Check(got[len(got)-1].Msg, Equals, fmt.Sprintf("data %d", log.RECENT_SIZE+5))
+
+	got = getRecentLogs(log.RecentLogsQuery{Service: "qan"})
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Msg, Equals, "qan warning")
+
+	got = getRecentLogs(log.RecentLogsQuery{Limit: 2})
+	t.Assert(got, HasLen, 2)
+	t.Check(got[1].Msg, Equals, fmt.Sprintf("data %d", log.RECENT_SIZE+5))
+}
+
 func (s *ManagerTestSuite) TestReconnect(t *C) {
 	config := &log.Config{
 		File:  s.logFile,
@@ -726,3 +850,75 @@ func (s *ManagerTestSuite) TestReconnect(t *C) {
 		t.Error(diff)
 	}
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// RotatingFile test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type RotateTestSuite struct {
+	tmpDir string
+}
+
+var _ = Suite(&RotateTestSuite{})
+
+func (s *RotateTestSuite) SetUpTest(t *C) {
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "log-rotate-test")
+	t.Assert(err, IsNil)
+}
+
+func (s *RotateTestSuite) TearDownTest(t *C) {
+	os.RemoveAll(s.tmpDir)
+}
+
+func (s *RotateTestSuite) TestRotateBySize(t *C) {
+	file := s.tmpDir + "/agent.log"
+	r, err := log.NewRotatingFile(file, 10, 0, 2, true)
+	t.Assert(err, IsNil)
+	defer r.Close()
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		_, err := r.Write([]byte(line))
+		t.Assert(err, IsNil)
+	}
+
+	// Each write makes the file larger than 10 bytes, so each rotates, and
+	// only 2 rotated files are kept, compressed.
+	data, err := ioutil.ReadFile(file)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "line 4\n")
+	t.Check(gunzip(t, file+".1.gz"), Equals, "line 3\n")
+	t.Check(gunzip(t, file+".2.gz"), Equals, "line 2\n")
+	t.Check(test.FileExists(file+".3.gz"), Equals, false)
+	t.Check(test.FileExists(file+".1"), Equals, false)
+}
+
+func (s *RotateTestSuite) TestRotateByAge(t *C) {
+	file := s.tmpDir + "/agent.log"
+	r, err := log.NewRotatingFile(file, 0, 100*time.Millisecond, 5, false)
+	t.Assert(err, IsNil)
+	defer r.Close()
+
+	r.Write([]byte("old\n"))
+	r.Write([]byte("still new\n"))
+	time.Sleep(150 * time.Millisecond)
+	r.Write([]byte("new\n"))
+
+	data, err := ioutil.ReadFile(file)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "new\n")
+	data, err = ioutil.ReadFile(file + ".1")
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "old\nstill new\n")
+}
+
+func gunzip(t *C, file string) string {
+	f, err := os.Open(file)
+	t.Assert(err, IsNil)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	t.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(gz)
+	t.Assert(err, IsNil)
+	return string(data)
+}
@@ -20,8 +20,10 @@ package log
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
+	"io"
 	"os"
 	"sync"
 	"time"
@@ -30,6 +32,9 @@ import (
 type Manager struct {
 	client  pct.WebsocketClient
 	logChan chan *proto.LogEntry
+	console io.Writer
+	color   bool
+	debug   bool
 	// --
 	config  *Config
 	running bool
@@ -37,6 +42,7 @@ type Manager struct {
 	logger  *pct.Logger
 	relay   *Relay
 	status  *pct.Status
+	reverts map[string]*time.Timer // SetLogLevel Duration, by service ("" = all)
 }
 
 func NewManager(client pct.WebsocketClient, logChan chan *proto.LogEntry) *Manager {
@@ -44,12 +50,26 @@ func NewManager(client pct.WebsocketClient, logChan chan *proto.LogEntry) *Manag
 		client:  client,
 		logChan: logChan,
 		// --
-		status: pct.NewStatus([]string{"log"}),
-		mux:    &sync.RWMutex{},
+		status:  pct.NewStatus([]string{"log"}),
+		mux:     &sync.RWMutex{},
+		reverts: make(map[string]*time.Timer),
 	}
 	return m
 }
 
+// SetConsole makes the relay also write all log entries to w (-foreground).
+// It must be called before Start.
+func (m *Manager) SetConsole(w io.Writer, color bool) {
+	m.console = w
+	m.color = color
+}
+
+// SetDebug logs at debug level regardless of the configured level (-debug),
+// without changing the config.  It must be called before Start.
+func (m *Manager) SetDebug(debug bool) {
+	m.debug = debug
+}
+
 // @goroutine[0]
 func (m *Manager) Start() error {
 	m.mux.Lock()
@@ -72,7 +92,14 @@ func (m *Manager) Start() error {
 
 	// Start relay (it buffers and sends log entries to API).
 	level := proto.LogLevelNumber[config.Level]
+	if m.debug {
+		level = proto.LOG_DEBUG
+	}
 	m.relay = NewRelay(m.client, m.logChan, config.File, level, config.Offline)
+	m.relay.SetRotation(*config)
+	if m.console != nil {
+		m.relay.SetConsole(m.console, m.color)
+	}
 	go m.relay.Run()
 
 	m.logger = pct.NewLogger(m.relay.LogChan(), "log")
@@ -110,7 +137,19 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		}
 
 		errs := []error{}
-		if m.config.File != newConfig.File {
+		rotationChanged := m.config.MaxSize != newConfig.MaxSize ||
+			m.config.MaxAge != newConfig.MaxAge ||
+			m.config.MaxFiles != newConfig.MaxFiles ||
+			m.config.Compress != newConfig.Compress
+		if rotationChanged {
+			m.relay.SetRotation(*newConfig)
+			m.config.MaxSize = newConfig.MaxSize
+			m.config.MaxAge = newConfig.MaxAge
+			m.config.MaxFiles = newConfig.MaxFiles
+			m.config.Compress = newConfig.Compress
+		}
+		if m.config.File != newConfig.File || (rotationChanged && newConfig.File != "") {
+			// Setting the same file reopens it with the new rotation.
 			select {
 			case m.relay.LogFileChan() <- newConfig.File:
 				m.config.File = newConfig.File
@@ -134,6 +173,25 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		}
 
 		return cmd.Reply(m.config, errs...)
+	case "SetLogLevel":
+		logLevel := &LogLevel{}
+		if err := json.Unmarshal(cmd.Data, logLevel); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		return cmd.Reply(logLevel, m.setLogLevel(logLevel))
+	case "GetRecentLogs":
+		q := &RecentLogsQuery{}
+		if len(cmd.Data) > 0 {
+			if err := json.Unmarshal(cmd.Data, q); err != nil {
+				return cmd.Reply(nil, err)
+			}
+		}
+		if q.Limit <= 0 {
+			q.Limit = RECENT_SIZE
+		}
+		return cmd.Reply(m.relay.Recent(q.Service, q.Limit))
 	case "GetConfig":
 		config, errs := m.GetConfig()
 		return cmd.Reply(config, errs...)
@@ -145,6 +203,69 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	}
 }
 
+// setLogLevel applies the SetLogLevel cmd.  Caller must lock m.mux.
+func (m *Manager) setLogLevel(logLevel *LogLevel) error {
+	var level byte
+	if logLevel.Level != "" {
+		var ok bool
+		if level, ok = proto.LogLevelNumber[logLevel.Level]; !ok {
+			return errors.New("Invalid log level: " + logLevel.Level)
+		}
+	} else if logLevel.Service == "" {
+		return errors.New("Log level is required to set the log level of all services")
+	}
+
+	service := logLevel.Service
+	if timer, ok := m.reverts[service]; ok {
+		timer.Stop()
+		delete(m.reverts, service)
+	}
+	if err := m.sendLevel(service, level, logLevel.Level == ""); err != nil {
+		return err
+	}
+	if logLevel.Duration > 0 && logLevel.Level != "" {
+		m.reverts[service] = time.AfterFunc(time.Duration(logLevel.Duration)*time.Second, func() {
+			m.revertLogLevel(service)
+		})
+	}
+	return nil
+}
+
+// revertLogLevel reverts a service to the global log level, or all services
+// to the configured log level, after SetLogLevel Duration.
+func (m *Manager) revertLogLevel(service string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.reverts, service)
+	level := proto.LogLevelNumber[m.config.Level]
+	if err := m.sendLevel(service, level, service != ""); err != nil {
+		m.logger.Warn("Cannot revert log level: ", err)
+		return
+	}
+	if service == "" {
+		m.logger.Info("Reverted log level to " + m.config.Level)
+	} else {
+		m.logger.Info("Reverted log level of " + service)
+	}
+}
+
+func (m *Manager) sendLevel(service string, level byte, unset bool) error {
+	if service == "" {
+		select {
+		case m.relay.LogLevelChan() <- level:
+			return nil
+		case <-time.After(3 * time.Second):
+			return errors.New("Timeout setting log level")
+		}
+	}
+	select {
+	case m.relay.ServiceLevelChan() <- ServiceLevel{Service: service, Level: level, Unset: unset}:
+		return nil
+	case <-time.After(3 * time.Second):
+		return errors.New("Timeout setting log level of " + service)
+	}
+}
+
 // @goroutine[0]
 func (m *Manager) Status() map[string]string {
 	return m.status.Merge(m.client.Status(), m.relay.Status())
@@ -180,6 +301,9 @@ func (m *Manager) validateConfig(config *Config) error {
 			return errors.New("Invalid log level: " + config.Level)
 		}
 	}
+	if config.MaxFiles < 0 {
+		return fmt.Errorf("Invalid MaxFiles: %d: must be greater than zero", config.MaxFiles)
+	}
 	// todo: log file should be relative to basedir, e.g. can't be /etc/passwd
 	return nil
 }
@@ -0,0 +1,89 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package log
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const RECENT_SIZE = 100 // log entries kept per service
+
+// RecentLogsQuery is the GetRecentLogs cmd data, optional.
+type RecentLogsQuery struct {
+	Service string `json:",omitempty"` // and its sub-services, all if empty
+	Limit   int    `json:",omitempty"` // most recent entries, default RECENT_SIZE
+}
+
+// recentLogs keeps the last size log entries of each service in memory, so
+// they can be fetched even if the log websocket was down.
+type recentLogs struct {
+	size    int
+	entries map[string][]proto.LogEntry // ring per service
+	next    map[string]int
+	mux     *sync.Mutex
+}
+
+func newRecentLogs(size int) *recentLogs {
+	r := &recentLogs{
+		size:    size,
+		entries: make(map[string][]proto.LogEntry),
+		next:    make(map[string]int),
+		mux:     &sync.Mutex{},
+	}
+	return r
+}
+
+func (r *recentLogs) add(entry *proto.LogEntry) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	entries := r.entries[entry.Service]
+	if len(entries) < r.size {
+		r.entries[entry.Service] = append(entries, *entry)
+		return
+	}
+	n := r.next[entry.Service]
+	entries[n] = *entry
+	r.next[entry.Service] = (n + 1) % r.size
+}
+
+// get returns the last limit entries of the service and its sub-services
+// (all services if empty), oldest first.
+func (r *recentLogs) get(service string, limit int) []proto.LogEntry {
+	r.mux.Lock()
+	all := []proto.LogEntry{}
+	for s, entries := range r.entries {
+		if service == "" || s == service || strings.HasPrefix(s, service+"-") {
+			all = append(all, entries...)
+		}
+	}
+	r.mux.Unlock()
+	sort.Sort(byTs(all))
+	if limit > 0 && len(all) > limit {
+		all = all[len(all)-limit:]
+	}
+	return all
+}
+
+type byTs []proto.LogEntry
+
+func (s byTs) Len() int           { return len(s) }
+func (s byTs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTs) Less(i, j int) bool { return s[i].Ts.Before(s[j].Ts) }
@@ -21,9 +21,13 @@ import (
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
+	"io"
 	golog "log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,11 +41,19 @@ type Relay struct {
 	logFile  string
 	logLevel byte
 	offline  bool
+	console  io.Writer
+	color    bool
+	rotation Config // MaxSize, MaxAge, MaxFiles, Compress
+	rotMux   *sync.Mutex
+	recent   *recentLogs
 	// --
 	connected     bool
 	logLevelChan  chan byte
+	svcLevels     map[string]byte
+	svcLevelChan  chan ServiceLevel
 	logFileChan   chan string
 	logger        *golog.Logger
+	file          *RotatingFile
 	firstBuf      []*proto.LogEntry
 	firstBufSize  int
 	secondBuf     []*proto.LogEntry
@@ -59,7 +71,11 @@ func NewRelay(client pct.WebsocketClient, logChan chan *proto.LogEntry, logFile
 		offline:  offline,
 		// --
 		logLevelChan: make(chan byte),
+		svcLevels:    make(map[string]byte),
 		logFileChan:  make(chan string),
+		svcLevelChan: make(chan ServiceLevel),
+		rotMux:       &sync.Mutex{},
+		recent:       newRecentLogs(RECENT_SIZE),
 		firstBuf:     make([]*proto.LogEntry, BUFFER_SIZE),
 		secondBuf:    make([]*proto.LogEntry, BUFFER_SIZE),
 		status: pct.NewStatus([]string{
@@ -74,6 +90,21 @@ func NewRelay(client pct.WebsocketClient, logChan chan *proto.LogEntry, logFile
 	return r
 }
 
+// SetConsole makes the relay also write all log entries to w, e.g. os.Stderr
+// for -foreground.  It must be called before Run.
+func (r *Relay) SetConsole(w io.Writer, color bool) {
+	r.console = w
+	r.color = color
+}
+
+// SetRotation sets how the log file is rotated.  It takes effect when the
+// log file is (re)set on LogFileChan, or when Run starts.
+func (r *Relay) SetRotation(config Config) {
+	r.rotMux.Lock()
+	defer r.rotMux.Unlock()
+	r.rotation = config
+}
+
 func (r *Relay) LogChan() chan *proto.LogEntry {
 	return r.logChan
 }
@@ -86,6 +117,18 @@ func (r *Relay) LogFileChan() chan string {
 	return r.logFileChan
 }
 
+// ServiceLevelChan sets or unsets the log level of one service, overriding
+// the log level for it and its sub-services, e.g. "data" for "data-sender".
+func (r *Relay) ServiceLevelChan() chan ServiceLevel {
+	return r.svcLevelChan
+}
+
+// Recent returns the last limit log entries of the service and its
+// sub-services, or all services if empty, oldest first.
+func (r *Relay) Recent(service string, limit int) []proto.LogEntry {
+	return r.recent.get(service, limit)
+}
+
 func (r *Relay) Status() map[string]string {
 	return r.status.Merge(r.client.Status())
 }
@@ -110,15 +153,23 @@ func (r *Relay) Run() {
 		select {
 		case entry := <-r.logChan:
 			// Skip if log level too high, too verbose.
-			if entry.Level > r.logLevel {
+			if entry.Level > r.level(entry.Service) {
 				continue
 			}
 
+			// Keep recent entries in memory for GetRecentLogs.
+			r.recent.add(entry)
+
 			// Write to file if there's a file (usually there isn't).
 			if r.logger != nil {
 				r.logger.Printf("%s: %s: %s\n", entry.Service, proto.LogLevelName[entry.Level], entry.Msg)
 			}
 
+			// Write to console if running in the foreground.
+			if r.console != nil {
+				io.WriteString(r.console, FormatConsole(entry, r.color))
+			}
+
 			// Send to API if we have a websocket client, and not in offline mode.
 			if !r.offline && !entry.Offline && r.client != nil {
 				r.send(entry, true) // buffer on err
@@ -142,6 +193,8 @@ func (r *Relay) Run() {
 			r.setLogFile(file)
 		case level := <-r.logLevelChan:
 			r.setLogLevel(level)
+		case sl := <-r.svcLevelChan:
+			r.setServiceLevel(sl)
 		}
 	}
 }
@@ -271,37 +324,105 @@ func (r *Relay) setLogLevel(level byte) {
 	}
 
 	r.logLevel = level
-	r.status.Update("log-level", proto.LogLevelName[level])
+	r.updateLevelStatus()
+}
+
+func (r *Relay) setServiceLevel(sl ServiceLevel) {
+	if sl.Unset {
+		delete(r.svcLevels, sl.Service)
+	} else if sl.Level > proto.LOG_DEBUG {
+		r.internal(fmt.Sprintf("Invalid log level for %s: %d\n", sl.Service, sl.Level), proto.LOG_WARNING)
+		return
+	} else {
+		r.svcLevels[sl.Service] = sl.Level
+	}
+	r.updateLevelStatus()
+}
+
+// level returns the log level of the service: its own, else its parent's
+// ("data" for "data-sender"), else the global log level.
+func (r *Relay) level(service string) byte {
+	if len(r.svcLevels) == 0 {
+		return r.logLevel
+	}
+	for {
+		if level, ok := r.svcLevels[service]; ok {
+			return level
+		}
+		i := strings.LastIndex(service, "-")
+		if i < 0 {
+			return r.logLevel
+		}
+		service = service[:i]
+	}
+}
+
+func (r *Relay) updateLevelStatus() {
+	status := proto.LogLevelName[r.logLevel]
+	if len(r.svcLevels) > 0 {
+		services := []string{}
+		for service, level := range r.svcLevels {
+			services = append(services, service+"="+proto.LogLevelName[level])
+		}
+		sort.Strings(services)
+		status += " (" + strings.Join(services, ", ") + ")"
+	}
+	r.status.Update("log-level", status)
 }
 
 func (r *Relay) setLogFile(logFile string) {
 	r.status.Update("log-relay", "Setting log file: "+logFile)
 
 	if logFile == "" {
+		r.closeLogFile()
 		r.logger = nil
 		r.logFile = ""
 		r.status.Update("log-file", "")
 		return
 	}
 
-	var file *os.File
+	var w io.Writer
+	var name string
+	var rotating *RotatingFile
 	if logFile == "STDOUT" {
-		file = os.Stdout
+		w, name = os.Stdout, os.Stdout.Name()
 	} else if logFile == "STDERR" {
-		file = os.Stderr
+		w, name = os.Stderr, os.Stderr.Name()
 	} else {
 		if !filepath.IsAbs(logFile) {
 			logFile = filepath.Join(pct.Basedir.Path(), logFile)
 		}
+		r.rotMux.Lock()
+		rot := r.rotation
+		r.rotMux.Unlock()
+		maxSize := rot.MaxSize
+		if maxSize == 0 {
+			maxSize = DEFAULT_LOG_MAX_SIZE
+		} else if maxSize < 0 {
+			maxSize = 0 // never
+		}
+		maxFiles := rot.MaxFiles
+		if maxFiles == 0 {
+			maxFiles = DEFAULT_LOG_MAX_FILES
+		}
 		var err error
-		file, err = os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		rotating, err = NewRotatingFile(logFile, maxSize, time.Duration(rot.MaxAge)*24*time.Hour, maxFiles, rot.Compress)
 		if err != nil {
 			r.internal(err.Error(), proto.LOG_WARNING)
 			return
 		}
+		w, name = rotating, rotating.Name()
 	}
-	logger := golog.New(file, "", golog.Ldate|golog.Ltime|golog.Lmicroseconds)
-	r.logger = logger
-	r.logFile = file.Name()
+	r.closeLogFile()
+	r.file = rotating
+	r.logger = golog.New(w, "", golog.Ldate|golog.Ltime|golog.Lmicroseconds)
+	r.logFile = name
 	r.status.Update("log-file", logFile)
 }
+
+func (r *Relay) closeLogFile() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
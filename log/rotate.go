@@ -0,0 +1,186 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotatingFile is a log file that rotates itself by size and age, so no
+// external logrotate is needed (which breaks the open file handle unless
+// it uses copytruncate).  Rotated files are File.1 (newest) to File.N, or
+// File.1.gz, etc. if compressed.
+type RotatingFile struct {
+	file     string
+	maxSize  int64         // bytes, 0 = no limit
+	maxAge   time.Duration // 0 = no limit
+	maxFiles int
+	compress bool
+	// --
+	f      *os.File
+	size   int64
+	opened time.Time
+	mux    *sync.Mutex
+}
+
+func NewRotatingFile(file string, maxSize int64, maxAge time.Duration, maxFiles int, compress bool) (*RotatingFile, error) {
+	r := &RotatingFile{
+		file:     file,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		maxFiles: maxFiles,
+		compress: compress,
+		mux:      &sync.Mutex{},
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Name() string {
+	return r.file
+}
+
+// Write writes p to the file, rotating it first if p would make it larger
+// than maxSize or the file is older than maxAge.  A single write is never
+// split across files.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) || (r.maxAge > 0 && time.Now().Sub(r.opened) > r.maxAge)) {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing entries.
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %s\n", r.file, err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now.
+func (r *RotatingFile) Rotate() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.rotate()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = fi.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+// rotate moves File.N to File.N+1, dropping the oldest, File to File.1,
+// compresses File.1 if enabled, and reopens File.
+func (r *RotatingFile) rotate() error {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	for n := r.maxFiles - 1; n >= 1; n-- {
+		for _, ext := range []string{"", ".gz"} {
+			if err := os.Rename(r.rotatedFile(n)+ext, r.rotatedFile(n+1)+ext); err != nil && !os.IsNotExist(err) {
+				return r.reopen(err)
+			}
+		}
+	}
+	if r.maxFiles > 0 {
+		if err := os.Rename(r.file, r.rotatedFile(1)); err != nil && !os.IsNotExist(err) {
+			return r.reopen(err)
+		}
+		if r.compress {
+			if err := gzipFile(r.rotatedFile(1)); err != nil {
+				return r.reopen(err)
+			}
+		}
+	} else if err := os.Remove(r.file); err != nil && !os.IsNotExist(err) {
+		return r.reopen(err)
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) reopen(err error) error {
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (r *RotatingFile) rotatedFile(n int) string {
+	return fmt.Sprintf("%s.%d", r.file, n)
+}
+
+// gzipFile compresses file to file.gz and removes file.
+func gzipFile(file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(file+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(file + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(file + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(file + ".gz")
+		return err
+	}
+	return os.Remove(file)
+}
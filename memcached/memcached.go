@@ -0,0 +1,115 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package memcached
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-agent/instance"
+)
+
+// Instance is a memcached instance in the instance repo, like proto.MySQLInstance.
+type Instance struct {
+	Id       uint
+	Hostname string
+	Addr     string // host:port or Unix socket path
+	Version  string `json:",omitempty"`
+}
+
+func init() {
+	instance.RegisterType("memcached", instance.Type{
+		New: func() interface{} { return &Instance{} },
+	})
+}
+
+const TIMEOUT = 2 * time.Second
+
+type Connector interface {
+	Addr() string
+	Stats() (map[string]string, error)
+}
+
+// Connection connects to memcached for each command because commands are only
+// run every collect interval and memcached connections are cheap.
+type Connection struct {
+	addr string
+}
+
+func NewConnection(addr string) *Connection {
+	c := &Connection{
+		addr: addr,
+	}
+	return c
+}
+
+func (c *Connection) Addr() string {
+	return c.addr
+}
+
+// Stats returns the output of the stats command, e.g. curr_connections: 10.
+func (c *Connection) Stats() (map[string]string, error) {
+	conn, err := Dial(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+	if _, err := io.WriteString(conn, "stats\r\n"); err != nil {
+		return nil, err
+	}
+	return ParseStats(bufio.NewReader(conn))
+}
+
+// Dial connects to a host:port or, if addr is a path, a Unix socket.
+func Dial(addr string) (net.Conn, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	return net.DialTimeout(network, addr, TIMEOUT)
+}
+
+// ParseStats parses the reply to the stats command: STAT name value lines
+// ending with END.
+func ParseStats(r *bufio.Reader) (map[string]string, error) {
+	stats := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "END" {
+			return stats, nil
+		}
+		if strings.HasSuffix(line, "ERROR") || strings.HasPrefix(line, "SERVER_ERROR") || strings.HasPrefix(line, "CLIENT_ERROR") {
+			return nil, errors.New("memcached: " + line)
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			return nil, fmt.Errorf("memcached: invalid stats line: %q", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+}
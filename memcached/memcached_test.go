@@ -0,0 +1,78 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package memcached_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-agent/memcached"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MemcachedTestSuite struct {
+}
+
+var _ = Suite(&MemcachedTestSuite{})
+
+func (s *MemcachedTestSuite) TestParseStats(t *C) {
+	reply := "STAT pid 1234\r\nSTAT version 1.4.20\r\nSTAT curr_connections 10\r\nEND\r\n"
+	stats, err := memcached.ParseStats(bufio.NewReader(strings.NewReader(reply)))
+	t.Assert(err, IsNil)
+	t.Check(stats, DeepEquals, map[string]string{
+		"pid":              "1234",
+		"version":          "1.4.20",
+		"curr_connections": "10",
+	})
+
+	_, err = memcached.ParseStats(bufio.NewReader(strings.NewReader("ERROR\r\n")))
+	t.Check(err, NotNil)
+
+	// Connection closed before END.
+	_, err = memcached.ParseStats(bufio.NewReader(strings.NewReader("STAT pid 1234\r\n")))
+	t.Check(err, NotNil)
+}
+
+func (s *MemcachedTestSuite) TestStats(t *C) {
+	// Fake memcached that replies to one stats command.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	t.Assert(err, IsNil)
+	defer l.Close()
+	cmdChan := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		cmd, _ := bufio.NewReader(conn).ReadString('\n')
+		cmdChan <- cmd
+		conn.Write([]byte("STAT curr_items 42\r\nEND\r\n"))
+	}()
+
+	c := memcached.NewConnection(l.Addr().String())
+	stats, err := c.Stats()
+	t.Assert(err, IsNil)
+	t.Check(<-cmdChan, Equals, "stats\r\n")
+	t.Check(stats, DeepEquals, map[string]string{"curr_items": "42"})
+}
@@ -32,8 +32,18 @@ type Aggregator struct {
 	collectionChan chan *Collection
 	spool          data.Spooler
 	// --
-	sync    *pct.SyncChan
-	running bool
+	exporter Exporter
+	sync     *pct.SyncChan
+	running  bool
+	flush    bool // report current interval on stop
+}
+
+// span is the part of an interval with collections.
+type span struct {
+	first         int64 // Ts of first collection
+	last          int64 // Ts of last collection
+	firstInterval bool
+	lastInterval  bool
 }
 
 func NewAggregator(logger *pct.Logger, interval int64, collectionChan chan *Collection, spool data.Spooler) *Aggregator {
@@ -52,18 +62,33 @@ func NewAggregator(logger *pct.Logger, interval int64, collectionChan chan *Coll
 // Interface
 /////////////////////////////////////////////////////////////////////////////
 
+// SetExporter makes report() also export reports.  Call it before Start.
+// @goroutine[0]
+func (a *Aggregator) SetExporter(exporter Exporter) {
+	a.exporter = exporter
+}
+
 // @goroutine[0]
 func (a *Aggregator) Start() {
 	go a.run()
 	a.running = true // XXX: not guarded
 }
 
+// Stop stops the aggregator, discarding the current interval.
 // @goroutine[0]
 func (a *Aggregator) Stop() {
 	a.sync.Stop()
 	a.sync.Wait()
 }
 
+// Close stops the aggregator like Stop, but first reports the current interval
+// cut short, i.e. with LastInterval and Truncated.
+// @goroutine[0]
+func (a *Aggregator) Close() {
+	a.flush = true // run() reads it after StopChan, so it's not a race
+	a.Stop()
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
@@ -80,85 +105,112 @@ func (a *Aggregator) run() {
 
 	var curInterval int64
 	var startTs time.Time
+	var curSpan span
 	cur := []*InstanceStats{}
 
-	for {
-		select {
-		case collection := <-a.collectionChan:
-			interval := (collection.Ts / a.interval) * a.interval
-			if curInterval == 0 {
-				curInterval = interval
-				startTs = GoTime(a.interval, interval)
-				a.logger.Debug("Start first interval", startTs)
-			}
-			if interval > curInterval {
-				// Metrics for next interval have arrived.  Process and spool
-				// the current interval, then advance to this interval.
-				a.report(startTs, cur)
-
-				// Init next stats based on current ones to avoid re-creating them.
-				// todo: what if metrics from an instance aren't collected?
-				for n := range cur {
-					for key, _ := range cur[n].Stats {
-						cur[n].Stats[key].Reset()
-					}
+	add := func(collection *Collection) {
+		interval := (collection.Ts / a.interval) * a.interval
+		if curInterval == 0 {
+			curInterval = interval
+			startTs = GoTime(a.interval, interval)
+			curSpan = span{first: collection.Ts, firstInterval: true}
+			a.logger.Debug("Start first interval", startTs)
+		}
+		if interval > curInterval {
+			// Metrics for next interval have arrived.  Process and spool
+			// the current interval, then advance to this interval.
+			a.report(startTs, cur, curSpan)
+
+			// Init next stats based on current ones to avoid re-creating them.
+			// todo: what if metrics from an instance aren't collected?
+			for n := range cur {
+				for key, _ := range cur[n].Stats {
+					cur[n].Stats[key].Reset()
 				}
-				curInterval = interval
-				startTs = GoTime(a.interval, interval)
-				a.logger.Debug("Start interval", startTs)
-			} else if interval < curInterval {
-				t := GoTime(a.interval, interval)
-				a.logger.Info("Lost collection for interval", t, "; current interval is", startTs)
 			}
+			curInterval = interval
+			startTs = GoTime(a.interval, interval)
+			curSpan = span{first: collection.Ts}
+			a.logger.Debug("Start interval", startTs)
+		} else if interval < curInterval {
+			t := GoTime(a.interval, interval)
+			a.logger.Info("Lost collection for interval", t, "; current interval is", startTs)
+		}
+		if collection.Ts > curSpan.last {
+			curSpan.last = collection.Ts
+		}
 
-			// Each collection is from a specific service instance.
-			// Find the stats for this instance, create if they don't exist.
-			var is *InstanceStats
-			for _, i := range cur {
-				if collection.Service == i.Service && collection.InstanceId == i.InstanceId {
-					is = i
-					break
-				}
+		// Each collection is from a specific service instance.
+		// Find the stats for this instance, create if they don't exist.
+		var is *InstanceStats
+		for _, i := range cur {
+			if collection.Service == i.Service && collection.InstanceId == i.InstanceId {
+				is = i
+				break
 			}
+		}
 
-			if is == nil {
-				// New service instance, create stats for it.
-				is = &InstanceStats{
-					ServiceInstance: proto.ServiceInstance{
-						Service:    collection.Service,
-						InstanceId: collection.InstanceId,
-					},
-					Stats: make(map[string]*Stats),
+		if is == nil {
+			// New service instance, create stats for it.
+			is = &InstanceStats{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    collection.Service,
+					InstanceId: collection.InstanceId,
+				},
+				Stats: make(map[string]*Stats),
+			}
+			cur = append(cur, is)
+		}
+
+		// Add each metric in the collection to its Stats.
+		for _, metric := range collection.Metrics {
+			stats, haveStats := is.Stats[metric.Name]
+			if !haveStats {
+				// New metric, create stats for it.
+				var err error
+				stats, err = NewStats(metric.Type)
+				if err != nil {
+					a.logger.Error(metric.Name, "invalid:", err.Error())
+					continue
 				}
-				cur = append(cur, is)
+				is.Stats[metric.Name] = stats
+			}
+			if err := stats.Add(&metric, collection.Ts); err != nil {
+				a.logger.Error(
+					fmt.Sprintf("stats.Add(%+v, %d): %s", metric, collection.Ts, err))
 			}
+		}
+	}
 
-			// Add each metric in the collection to its Stats.
-			for _, metric := range collection.Metrics {
-				stats, haveStats := is.Stats[metric.Name]
-				if !haveStats {
-					// New metric, create stats for it.
-					var err error
-					stats, err = NewStats(metric.Type)
-					if err != nil {
-						a.logger.Error(metric.Name, "invalid:", err.Error())
-						continue
+	for {
+		select {
+		case collection := <-a.collectionChan:
+			add(collection)
+		case <-a.sync.StopChan:
+			if a.flush {
+				// Add collections sent before the monitors stopped, then report
+				// the interval so far.
+			drain:
+				for {
+					select {
+					case collection := <-a.collectionChan:
+						add(collection)
+					default:
+						break drain
 					}
-					is.Stats[metric.Name] = stats
 				}
-				if err := stats.Add(&metric, collection.Ts); err != nil {
-					a.logger.Error(
-						fmt.Sprintf("stats.Add(%+v, %d): %s", metric, collection.Ts, err))
+				if curInterval != 0 {
+					curSpan.lastInterval = true
+					a.report(startTs, cur, curSpan)
 				}
 			}
-		case <-a.sync.StopChan:
 			return
 		}
 	}
 }
 
 // @goroutine[1]
-func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
+func (a *Aggregator) report(startTs time.Time, is []*InstanceStats, sp span) {
 	a.logger.Debug("Summarize metrics for", startTs)
 
 	// The instance stats given (is) are a persistent buffer, so we need
@@ -208,12 +260,37 @@ func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
 	}
 
 	report := &Report{
-		Ts:       startTs,
-		Duration: uint(a.interval),
-		Stats:    finalInstanceStats,
+		Ts:            startTs,
+		Duration:      uint(a.interval),
+		StartTs:       startTs,
+		EndTs:         startTs.Add(time.Duration(a.interval) * time.Second),
+		FirstInterval: sp.firstInterval,
+		LastInterval:  sp.lastInterval,
+		Stats:         finalInstanceStats,
+	}
+	if sp.firstInterval && sp.first > startTs.Unix() {
+		report.StartTs = time.Unix(sp.first, 0).UTC()
+		report.Truncated = true
+	}
+	if sp.lastInterval && sp.last < report.EndTs.Unix() {
+		report.EndTs = time.Unix(sp.last, 0).UTC()
+		report.Truncated = true
+	}
+
+	// Intervals are in local time, so collections stay in their interval if
+	// the clock skew changes; only the report times are corrected, once.
+	if adjust := pct.ClockAdjustment(); adjust != 0 {
+		report.Ts = report.Ts.Add(adjust)
+		report.StartTs = report.StartTs.Add(adjust)
+		report.EndTs = report.EndTs.Add(adjust)
+	}
+	if a.spool != nil {
+		if err := a.spool.Write("mm", report); err != nil {
+			a.logger.Warn("Lost report:", err)
+		}
 	}
-	if err := a.spool.Write("mm", report); err != nil {
-		a.logger.Warn("Lost report:", err)
+	if a.exporter != nil {
+		a.exporter.Export(report)
 	}
 }
 
@@ -0,0 +1,90 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+// Monitors that can tell when the server they monitor is struggling, e.g.
+// the MySQL monitor from Threads_running, use a LoadBackoff to collect less
+// often until the load is normal again, so the agent doesn't add to the load.
+const (
+	DEFAULT_MAX_BACKOFF = 8 // collect at least every 8 ticks
+	CALM_COLLECTIONS    = 3 // normal collections before collecting more often
+)
+
+// A LoadBackoff decides which ticks a monitor collects on.  Each loaded
+// collection doubles the number of ticks between collections, up to max, and
+// CALM_COLLECTIONS normal collections in a row halve it, down to every tick.
+// It's not safe for concurrent use; monitors use it in their run goroutine.
+type LoadBackoff struct {
+	max     uint
+	factor  uint // collect every factor ticks
+	skipped uint // ticks since last collection
+	calm    uint // normal collections in a row
+}
+
+func NewLoadBackoff(max uint) *LoadBackoff {
+	if max == 0 {
+		max = DEFAULT_MAX_BACKOFF
+	}
+	b := &LoadBackoff{
+		max:    max,
+		factor: 1,
+	}
+	return b
+}
+
+// Collect returns true if the monitor should collect on this tick.
+func (b *LoadBackoff) Collect() bool {
+	if b.skipped+1 >= b.factor {
+		b.skipped = 0
+		return true
+	}
+	b.skipped++
+	return false
+}
+
+// Update sets whether the server was loaded during the last collection, and
+// returns true if that changed how often the monitor collects.
+func (b *LoadBackoff) Update(loaded bool) bool {
+	if loaded {
+		b.calm = 0
+		if b.factor >= b.max {
+			return false
+		}
+		b.factor *= 2
+		if b.factor > b.max {
+			b.factor = b.max
+		}
+		return true
+	}
+	if b.factor == 1 {
+		return false
+	}
+	b.calm++
+	if b.calm < CALM_COLLECTIONS {
+		return false
+	}
+	b.calm = 0
+	b.factor /= 2
+	return true
+}
+
+// Factor returns how many ticks there are between collections, 1 if the
+// monitor isn't backed off.
+func (b *LoadBackoff) Factor() uint {
+	return b.factor
+}
@@ -0,0 +1,45 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+// An Exporter sends finalized reports somewhere besides the data spooler.
+// Export must not block the aggregator.
+type Exporter interface {
+	Export(report *Report)
+}
+
+// Exporters exports to each Exporter.
+type Exporters []Exporter
+
+func (e Exporters) Export(report *Report) {
+	for _, exporter := range e {
+		exporter.Export(report)
+	}
+}
+
+// ExportConfig is the mm manager config, mm.conf, which only configures
+// exporting: monitors have their own mm-<service>-<id>.conf.
+type ExportConfig struct {
+	OTLPEndpoint string            `json:",omitempty"` // collector base URL, e.g. http://127.0.0.1:4318
+	InfluxURL    string            `json:",omitempty"` // e.g. http://127.0.0.1:8086/write?db=percona
+	InfluxFile   string            `json:",omitempty"` // line protocol file, appended
+	Headers      map[string]string `json:",omitempty"` // e.g. Authorization for the collector or InfluxDB
+	Attributes   map[string]string `json:",omitempty"` // extra OTLP resource attributes, e.g. deployment.environment
+	Timeout      uint              `json:",omitempty"` // seconds, default 10
+	NoSpool      bool              `json:",omitempty"` // only export, don't send reports to the API
+}
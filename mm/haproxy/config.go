@@ -0,0 +1,45 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package haproxy
+
+import (
+	"github.com/percona/percona-agent/mm"
+)
+
+type Config struct {
+	mm.Config
+	Stats map[string]string // CSV fields to collect, e.g. scur: gauge, default DEFAULT_STATS
+}
+
+// Fields collected if Config.Stats is empty: sessions, queue, errors, and
+// retries and redispatches to other servers.
+var DEFAULT_STATS = map[string]string{
+	"qcur":   "gauge",
+	"scur":   "gauge",
+	"stot":   "counter",
+	"bin":    "counter",
+	"bout":   "counter",
+	"dreq":   "counter",
+	"dresp":  "counter",
+	"ereq":   "counter",
+	"econ":   "counter",
+	"eresp":  "counter",
+	"wretr":  "counter",
+	"wredis": "counter",
+	"status": "gauge", // UP or OPEN=1, else 0
+}
@@ -0,0 +1,62 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package haproxy_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/haproxy"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type byName []mm.Metric
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type HAProxyTestSuite struct {
+}
+
+var _ = Suite(&HAProxyTestSuite{})
+
+func (s *HAProxyTestSuite) TestStatsMetrics(t *C) {
+	stats := []map[string]string{
+		{"pxname": "pxc-back", "svname": "db1", "qcur": "0", "scur": "1", "econ": "", "status": "UP"},
+		{"pxname": "pxc-back", "svname": "db2", "qcur": "2", "scur": "0", "econ": "3", "status": "DOWN"},
+	}
+	collect := map[string]string{
+		"qcur":   "gauge",
+		"econ":   "counter",
+		"status": "gauge",
+	}
+	got := haproxy.StatsMetrics(stats, collect)
+	sort.Sort(byName(got))
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"haproxy/pxc-back/db1/qcur", "gauge", 0, ""},
+		{"haproxy/pxc-back/db1/status", "gauge", 1, ""},
+		{"haproxy/pxc-back/db2/econ", "counter", 3, ""},
+		{"haproxy/pxc-back/db2/qcur", "gauge", 2, ""},
+		{"haproxy/pxc-back/db2/status", "gauge", 0, ""},
+	})
+}
@@ -0,0 +1,241 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-agent/pct"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxExporter writes reports in InfluxDB line protocol to an HTTP write
+// endpoint (InfluxURL, e.g. http://127.0.0.1:8086/write?db=percona), a local
+// file (InfluxFile, appended), or both.  Each metric is one point: the
+// measurement is the metric name, e.g. mysql/status/Threads_running, tagged
+// with the instance and host, with the interval stats as fields:
+//
+//	mysql/status/Threads_running,host=db1,instance=mysql-1,instance_id=1,service=mysql cnt=4i,min=1,pct5=1,avg=2.5,med=2,pct95=4,max=4 1400000000000000000
+//
+// The timestamp is the start of the interval, like Report.Ts.
+type InfluxExporter struct {
+	logger *pct.Logger
+	name   func(service string, instanceId uint) string // e.g. instance.Repo.Name
+	// --
+	config     *ExportConfig
+	client     *http.Client
+	hostname   string
+	reportChan chan *Report
+	sync       *pct.SyncChan
+	running    bool
+	mux        *sync.Mutex // guards config, client, reportChan, and running
+	status     *pct.Status
+}
+
+func NewInfluxExporter(logger *pct.Logger, name func(service string, instanceId uint) string) *InfluxExporter {
+	hostname, _ := os.Hostname()
+	e := &InfluxExporter{
+		logger: logger,
+		name:   name,
+		// --
+		hostname: hostname,
+		mux:      &sync.Mutex{},
+		status:   pct.NewStatus([]string{"mm-influx"}),
+	}
+	return e
+}
+
+// @goroutine[0]
+func (e *InfluxExporter) Start(config *ExportConfig) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.running {
+		return pct.ServiceIsRunningError{Service: "mm-influx"}
+	}
+	if config.InfluxURL == "" && config.InfluxFile == "" {
+		return fmt.Errorf("InfluxURL or InfluxFile must be set")
+	}
+	if config.InfluxURL != "" && !strings.HasPrefix(config.InfluxURL, "http://") && !strings.HasPrefix(config.InfluxURL, "https://") {
+		return fmt.Errorf("Invalid InfluxURL: %s: expected http:// or https:// URL", config.InfluxURL)
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = OTLP_DEFAULT_TIMEOUT
+	}
+	e.config = config
+	e.client = &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{Proxy: pct.Proxy, TLSClientConfig: pct.TLSConfig()},
+	}
+	e.reportChan = make(chan *Report, OTLP_BUFFER_SIZE)
+	e.sync = pct.NewSyncChan()
+	go e.run(e.reportChan)
+	e.running = true
+	e.logger.Info("Started exporting to " + strings.TrimSpace(config.InfluxURL+" "+config.InfluxFile))
+	return nil
+}
+
+// @goroutine[0]
+func (e *InfluxExporter) Stop() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if !e.running {
+		return
+	}
+	e.sync.Stop()
+	e.sync.Wait()
+	e.running = false
+	e.logger.Info("Stopped exporting")
+}
+
+// Export queues the report to be written, or drops it if the exporter isn't
+// running or the queue is full.
+// @goroutine[1] (aggregator)
+func (e *InfluxExporter) Export(report *Report) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if !e.running {
+		return
+	}
+	select {
+	case e.reportChan <- report:
+	default:
+		e.logger.Warn("Lost InfluxDB export of", report.Ts, ": queue is full")
+	}
+}
+
+func (e *InfluxExporter) Status() map[string]string {
+	return e.status.All()
+}
+
+// @goroutine[2]
+func (e *InfluxExporter) run(reportChan chan *Report) {
+	defer func() {
+		if err := recover(); err != nil {
+			e.logger.Error("InfluxDB exporter crashed: ", err)
+		}
+		e.status.Update("mm-influx", "Stopped")
+		e.sync.Done()
+	}()
+	for {
+		e.status.Update("mm-influx", "Idle")
+		select {
+		case report := <-reportChan:
+			e.status.Update("mm-influx", "Exporting "+report.Ts.String())
+			lines := e.Lines(report)
+			if e.config.InfluxURL != "" {
+				if err := e.post(lines); err != nil {
+					e.logger.Warn("Lost InfluxDB export of", report.Ts, ":", err)
+				}
+			}
+			if e.config.InfluxFile != "" {
+				if err := e.write(lines); err != nil {
+					e.logger.Warn("Lost InfluxDB export of", report.Ts, ":", err)
+				}
+			}
+		case <-e.sync.StopChan:
+			return
+		}
+	}
+}
+
+func (e *InfluxExporter) post(lines []byte) error {
+	req, err := http.NewRequest("POST", e.config.InfluxURL, bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP status %d, expected 204", e.config.InfluxURL, resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *InfluxExporter) write(lines []byte) error {
+	file, err := os.OpenFile(e.config.InfluxFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Lines returns the report in InfluxDB line protocol, one line per metric.
+func (e *InfluxExporter) Lines(report *Report) []byte {
+	ts := strconv.FormatInt(report.Ts.UnixNano(), 10)
+	var buf bytes.Buffer
+	for _, is := range report.Stats {
+		tags := fmt.Sprintf(",host=%s,instance=%s,instance_id=%d,service=%s",
+			influxTagEscaper.Replace(e.hostname),
+			influxTagEscaper.Replace(e.name(is.Service, is.InstanceId)),
+			is.InstanceId,
+			influxTagEscaper.Replace(is.Service),
+		)
+		names := make([]string, 0, len(is.Stats))
+		for name := range is.Stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stats := is.Stats[name]
+			buf.WriteString(influxMeasurementEscaper.Replace(name))
+			buf.WriteString(tags)
+			fmt.Fprintf(&buf, " cnt=%di,min=%s,pct5=%s,avg=%s,med=%s,pct95=%s,max=%s %s\n",
+				stats.Cnt,
+				influxFloat(stats.Min),
+				influxFloat(stats.Pct5),
+				influxFloat(stats.Avg),
+				influxFloat(stats.Med),
+				influxFloat(stats.Pct95),
+				influxFloat(stats.Max),
+				ts,
+			)
+		}
+	}
+	return buf.Bytes()
+}
+
+func influxFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
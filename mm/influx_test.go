@@ -0,0 +1,137 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+type InfluxTestSuite struct {
+	logChan  chan *proto.LogEntry
+	logger   *pct.Logger
+	tmpDir   string
+	hostname string
+	report   *mm.Report
+}
+
+var _ = Suite(&InfluxTestSuite{})
+
+func (s *InfluxTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "mm-influx")
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-test")
+	t.Assert(err, IsNil)
+	s.hostname, _ = os.Hostname()
+	s.report = &mm.Report{
+		Ts:       time.Unix(1400000000, 0).UTC(),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+				Stats: map[string]*mm.Stats{
+					"mysql/status/Threads_running": {Cnt: 4, Min: 1, Pct5: 1, Avg: 2.5, Med: 2, Pct95: 4, Max: 4},
+					"mysql/status/Com_select":      {Cnt: 2, Min: 10, Pct5: 10, Avg: 15, Med: 10, Pct95: 20, Max: 20},
+				},
+			},
+		},
+	}
+}
+
+func (s *InfluxTestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
+}
+
+func (s *InfluxTestSuite) lines() string {
+	tags := ",host=" + s.hostname + ",instance=mysql-1,instance_id=1,service=mysql"
+	return "mysql/status/Com_select" + tags + " cnt=2i,min=10,pct5=10,avg=15,med=10,pct95=20,max=20 1400000000000000000\n" +
+		"mysql/status/Threads_running" + tags + " cnt=4i,min=1,pct5=1,avg=2.5,med=2,pct95=4,max=4 1400000000000000000\n"
+}
+
+// --------------------------------------------------------------------------
+
+func (s *InfluxTestSuite) TestLines(t *C) {
+	e := mm.NewInfluxExporter(s.logger, instanceName)
+	t.Check(string(e.Lines(s.report)), Equals, s.lines())
+
+	// Commas, spaces, and equal signs are escaped.
+	report := &mm.Report{
+		Ts: time.Unix(1400000000, 0).UTC(),
+		Stats: []*mm.InstanceStats{
+			{
+				ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 2},
+				Stats: map[string]*mm.Stats{
+					"mysql/a b,c": {Cnt: 1, Min: 0.5, Pct5: 0.5, Avg: 0.5, Med: 0.5, Pct95: 0.5, Max: 0.5},
+				},
+			},
+		},
+	}
+	e = mm.NewInfluxExporter(s.logger, func(string, uint) string { return "db=1, prod" })
+	t.Check(string(e.Lines(report)), Equals,
+		`mysql/a\ b\,c,host=`+s.hostname+`,instance=db\=1\,\ prod,instance_id=2,service=mysql cnt=1i,min=0.5,pct5=0.5,avg=0.5,med=0.5,pct95=0.5,max=0.5 1400000000000000000`+"\n")
+}
+
+func (s *InfluxTestSuite) TestExport(t *C) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- r.URL.RawQuery + "\n" + string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.tmpDir, "metrics.txt")
+	e := mm.NewInfluxExporter(s.logger, instanceName)
+	err := e.Start(&mm.ExportConfig{
+		InfluxURL:  server.URL + "/write?db=percona",
+		InfluxFile: file,
+	})
+	t.Assert(err, IsNil)
+
+	e.Export(s.report)
+	select {
+	case body := <-bodies:
+		t.Check(body, Equals, "db=percona\n"+s.lines())
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for InfluxDB write")
+	}
+
+	// Stop waits for run() to finish writing the file.
+	e.Stop()
+	data, err := ioutil.ReadFile(file)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, s.lines())
+}
+
+func (s *InfluxTestSuite) TestBadConfig(t *C) {
+	e := mm.NewInfluxExporter(s.logger, instanceName)
+	t.Check(e.Start(&mm.ExportConfig{}), NotNil)
+	t.Check(e.Start(&mm.ExportConfig{InfluxURL: "influx:8086"}), NotNil)
+}
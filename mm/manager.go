@@ -47,6 +47,11 @@ type Binding struct {
 	collectionChan chan *Collection // <- metrics from monitors
 }
 
+// Collections buffered for each aggregator, so that many monitors, e.g. for 20+
+// MySQL instances, can send their collections at the same tick without waiting
+// for the aggregator.  Aggregators are per report interval, not per monitor.
+const COLLECTION_BUFFER = 100
+
 type Manager struct {
 	logger  *pct.Logger
 	factory MonitorFactory
@@ -55,11 +60,19 @@ type Manager struct {
 	im      *instance.Repo
 	// --
 	monitors    map[string]Monitor
+	collect     map[string]uint     // monitor collect intervals
+	tickGroups  map[uint]*tickGroup // by collect interval, shared by monitors
+	throttle    uint                // collect this many times less often
+	paused      bool                // monitors not ticking, see Pause
 	running     bool
-	mux         *sync.RWMutex // guards monitors and running
+	mux         *sync.RWMutex // guards monitors, collect, tickGroups, throttle, paused and running
 	status      *pct.Status
 	aggregators map[uint]*Binding
 	mrm         mrms.Monitor
+	otlp        *OTLPExporter
+	influx      *InfluxExporter
+	exporting   []exportService // started exporters
+	noSpool     bool            // only export reports
 }
 
 func NewManager(logger *pct.Logger, factory MonitorFactory, clock ticker.Manager, spool data.Spooler, im *instance.Repo, mrm mrms.Monitor) *Manager {
@@ -71,11 +84,16 @@ func NewManager(logger *pct.Logger, factory MonitorFactory, clock ticker.Manager
 		im:      im,
 		// --
 		monitors:    make(map[string]Monitor),
-		status:      pct.NewStatus([]string{"mm"}),
+		collect:     make(map[string]uint),
+		tickGroups:  make(map[uint]*tickGroup),
+		throttle:    1,
+		status:      pct.NewStatus([]string{"mm", "mm-tickers"}),
 		aggregators: make(map[uint]*Binding),
 		mux:         &sync.RWMutex{},
 		mrm:         mrm,
 	}
+	m.otlp = NewOTLPExporter(pct.NewLogger(logger.LogChan(), "mm-otlp"), im.Name)
+	m.influx = NewInfluxExporter(pct.NewLogger(logger.LogChan(), "mm-influx"), im.Name)
 	return m
 }
 
@@ -93,6 +111,26 @@ func (m *Manager) Start() error {
 		return pct.ServiceIsRunningError{Service: "mm"}
 	}
 
+	// Load config from disk (optional: reports are only spooled by default).
+	config := &ExportConfig{}
+	if err := pct.Basedir.ReadConfig("mm", config); err != nil {
+		return err
+	}
+	if config.OTLPEndpoint != "" {
+		if err := m.otlp.Start(config); err != nil {
+			return err
+		}
+		m.exporting = append(m.exporting, m.otlp)
+	}
+	if config.InfluxURL != "" || config.InfluxFile != "" {
+		if err := m.influx.Start(config); err != nil {
+			m.stopExporters()
+			return err
+		}
+		m.exporting = append(m.exporting, m.influx)
+	}
+	m.noSpool = config.NoSpool && len(m.exporting) > 0
+
 	// Start all metric monitors.
 	glob := filepath.Join(pct.Basedir.Dir("config"), "mm-*.conf")
 	configFiles, err := filepath.Glob(glob)
@@ -137,13 +175,32 @@ func (m *Manager) Stop() error {
 	defer m.mux.Unlock()
 	for name, monitor := range m.monitors {
 		m.status.Update("mm", "Stopping "+name)
+		if c, ok := monitor.(Collector); ok {
+			m.removeMonitor(c, nil, m.collect[name])
+		}
 		if err := monitor.Stop(); err != nil {
 			m.logger.Warn("Failed to stop " + name + ": " + err.Error())
+			if _, ok := monitor.(Collector); ok {
+				m.addMonitor(monitor, nil, m.collect[name]) // still running
+			}
 			continue
 		}
-		m.clock.Remove(monitor.TickChan())
+		if tickChan := monitor.TickChan(); tickChan != nil {
+			m.removeMonitor(monitor, tickChan, m.collect[name])
+		}
 		delete(m.monitors, name)
+		delete(m.collect, name)
 	}
+	// Report the intervals cut short by stopping, and make new aggregators
+	// for the monitors on Start.  A monitor that didn't stop could still
+	// send collections, so keep the aggregators for it.
+	if len(m.monitors) == 0 {
+		for interval, a := range m.aggregators {
+			a.aggregator.Close()
+			delete(m.aggregators, interval)
+		}
+	}
+	m.stopExporters()
 	m.running = false
 	m.logger.Info("Stopped")
 	m.status.Update("mm", "Stopped")
@@ -179,13 +236,19 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 			return cmd.Reply(nil, errors.New("Factory: "+err.Error()))
 		}
 
-		// Make synchronized (3rd arg=true) ticker for collect interval.  It's
-		// synchronized so all data aligns in charts, else we can get MySQL metrics
-		// at 00:03 and system metrics at 00:05 and other metrics at 00:06 which
-		// makes it very difficult to see all metrics at a single point in time
-		// or meaningfully compare a single interval, e.g. 00:00 to 00:05.
-		tickChan := make(chan time.Time)
-		m.clock.Add(tickChan, mm.Collect, true)
+		// Tick the monitor with the other monitors that collect at the same
+		// interval, see tickGroup.  The ticker is synchronized so all data aligns
+		// in charts, else we can get MySQL metrics at 00:03 and system metrics at
+		// 00:05 and other metrics at 00:06 which makes it very difficult to see
+		// all metrics at a single point in time or meaningfully compare a single
+		// interval, e.g. 00:00 to 00:05.  A Collector doesn't get a tickChan: the
+		// group runs it on its collect workers.
+		var tickChan chan time.Time
+		if _, ok := monitor.(Collector); !ok {
+			tickChan = make(chan time.Time)
+		}
+		m.mux.Lock()
+		m.addMonitor(monitor, tickChan, mm.Collect)
 
 		// We need one aggregator for each unique report interval.  There's usually
 		// just one: 60s.  Remember: report interval != collect interval.  Monitors
@@ -195,8 +258,13 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		if !ok {
 			// Make new aggregator for this report interval.
 			logger := pct.NewLogger(m.logger.LogChan(), fmt.Sprintf("mm-ag-%d", mm.Report))
-			collectionChan := make(chan *Collection, 5)
-			aggregator := NewAggregator(logger, int64(mm.Report), collectionChan, m.spool)
+			collectionChan := make(chan *Collection, COLLECTION_BUFFER)
+			spool := m.spool
+			if m.noSpool {
+				spool = nil // reports only exported, see ExportConfig.NoSpool
+			}
+			aggregator := NewAggregator(logger, int64(mm.Report), collectionChan, spool)
+			aggregator.SetExporter(Exporters{m.otlp, m.influx})
 			aggregator.Start()
 
 			// Save aggregator for other monitors with same report interval.
@@ -204,13 +272,18 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 			m.aggregators[mm.Report] = a
 			m.logger.Info("Created", mm.Report, "second aggregator")
 		}
+		m.mux.Unlock()
 
 		// Start the monitor.
 		if err := monitor.Start(tickChan, a.collectionChan); err != nil {
+			m.mux.Lock()
+			m.removeMonitor(monitor, tickChan, mm.Collect)
+			m.mux.Unlock()
 			return cmd.Reply(nil, errors.New("Start "+name+": "+err.Error()))
 		}
 		m.mux.Lock()
 		m.monitors[name] = monitor
+		m.collect[name] = mm.Collect
 		m.mux.Unlock()
 
 		// Save the monitor-specific config to disk so agent starts on restart.
@@ -233,15 +306,34 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		if !ok {
 			return cmd.Reply(nil, errors.New("Unknown monitor: "+name))
 		}
+		// Remove a Collector first so it's not collecting while it stops.
+		// Stopping a monitor with a tickChan stops its goroutine, then the
+		// tickChan can be removed.
+		m.mux.Lock()
+		collect := m.collect[name]
+		if c, ok := monitor.(Collector); ok {
+			m.removeMonitor(c, nil, collect)
+		}
+		m.mux.Unlock()
 		if err := monitor.Stop(); err != nil {
+			if c, ok := monitor.(Collector); ok {
+				m.mux.Lock()
+				m.addMonitor(c, nil, collect) // still running
+				m.mux.Unlock()
+			}
 			return cmd.Reply(nil, errors.New("Stop "+name+": "+err.Error()))
 		}
-		m.clock.Remove(monitor.TickChan())
+		if tickChan := monitor.TickChan(); tickChan != nil {
+			m.mux.Lock()
+			m.removeMonitor(monitor, tickChan, collect)
+			m.mux.Unlock()
+		}
 		if err := pct.Basedir.RemoveConfig(name); err != nil {
 			return cmd.Reply(nil, errors.New("Remove "+name+": "+err.Error()))
 		}
 		m.mux.Lock()
 		delete(m.monitors, name)
+		delete(m.collect, name)
 		m.mux.Unlock()
 		return cmd.Reply(nil) // success
 	case "GetConfig":
@@ -254,11 +346,64 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	}
 }
 
+// Throttle makes monitors collect factor times less often, e.g. every 10s
+// instead of every 1s if factor is 10, to reduce the agent's load on the
+// server.  Factor 1 is the normal collect intervals.  Ticks stay synchronized
+// because factor * collect interval is also an even interval.
+func (m *Manager) Throttle(factor uint) {
+	if factor < 1 {
+		factor = 1
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if factor == m.throttle {
+		return
+	}
+	m.logger.Info(fmt.Sprintf("Throttle collect intervals: %dx", factor))
+	m.throttle = factor
+	if m.paused {
+		return // Pause(false) uses new throttle
+	}
+	for _, g := range m.tickGroups {
+		m.clock.Remove(g.tickChan)
+		m.clock.Add(g.tickChan, g.collect*factor, true)
+	}
+}
+
+// Pause stops or restarts the monitors' tickers so they stop or resume
+// collecting.  The monitors keep running, so resuming is immediate and
+// only the intervals while paused have no metrics.
+func (m *Manager) Pause(paused bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if paused == m.paused {
+		return
+	}
+	m.paused = paused
+	if paused {
+		m.logger.Info("Paused")
+		for _, g := range m.tickGroups {
+			m.clock.Remove(g.tickChan)
+		}
+	} else {
+		m.logger.Info("Resumed")
+		for _, g := range m.tickGroups {
+			m.clock.Add(g.tickChan, g.collect*m.throttle, true)
+		}
+	}
+}
+
 // @goroutine[1]
 func (m *Manager) Status() map[string]string {
-	status := m.status.All()
 	m.mux.RLock()
 	defer m.mux.RUnlock()
+	m.status.Update("mm-tickers", fmt.Sprintf("%d monitors, %d tickers, %d aggregators", len(m.monitors), len(m.tickGroups), len(m.aggregators)))
+	status := m.status.All()
+	for _, exporter := range m.exporting {
+		for k, v := range exporter.Status() {
+			status[k] = v
+		}
+	}
 	for _, monitor := range m.monitors {
 		monitorStatus := monitor.Status()
 		for k, v := range monitorStatus {
@@ -309,6 +454,63 @@ func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
 	return configs, errs
 }
 
+type exportService interface {
+	Stop()
+	Status() map[string]string
+}
+
+func (m *Manager) stopExporters() {
+	for _, exporter := range m.exporting {
+		exporter.Stop()
+	}
+	m.exporting = nil
+}
+
+// addMonitor adds the monitor to the tickGroup for its collect interval,
+// making and starting the group if it's the first monitor.  A Collector is
+// added to run on the group's workers, else tickChan is added.  Caller must
+// lock m.mux.
+func (m *Manager) addMonitor(monitor Monitor, tickChan chan time.Time, collect uint) {
+	g, ok := m.tickGroups[collect]
+	if !ok {
+		g = newTickGroup(collect)
+		g.Start()
+		m.tickGroups[collect] = g
+		if !m.paused {
+			m.clock.Add(g.tickChan, collect*m.throttle, true)
+		}
+	}
+	if c, ok := monitor.(Collector); ok && tickChan == nil {
+		g.AddCollector(c)
+	} else {
+		g.Add(tickChan)
+	}
+}
+
+// removeMonitor removes the monitor from its tickGroup, like addMonitor, and
+// stops the group if it was the last monitor.  Removing a Collector waits for
+// it to finish collecting.  Caller must lock m.mux.
+func (m *Manager) removeMonitor(monitor Monitor, tickChan chan time.Time, collect uint) {
+	g, ok := m.tickGroups[collect]
+	if !ok {
+		return
+	}
+	var left int
+	if c, ok := monitor.(Collector); ok && tickChan == nil {
+		left = g.RemoveCollector(c)
+	} else {
+		left = g.Remove(tickChan)
+	}
+	if left > 0 {
+		return
+	}
+	if !m.paused {
+		m.clock.Remove(g.tickChan)
+	}
+	g.Stop()
+	delete(m.tickGroups, collect)
+}
+
 func (m *Manager) getMonitorConfig(cmd *proto.Cmd) (*Config, string, error) {
 	/**
 	 * cmd.Data is a monitor-specific config, e.g. mysql.Config.  But monitor-specific
@@ -0,0 +1,44 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package memcached
+
+import (
+	"github.com/percona/percona-agent/mm"
+)
+
+type Config struct {
+	mm.Config
+	Stats map[string]string // stats to collect, e.g. curr_connections: gauge, default DEFAULT_STATS
+}
+
+// Stats collected if Config.Stats is empty.
+var DEFAULT_STATS = map[string]string{
+	"curr_connections":    "gauge",
+	"total_connections":   "counter",
+	"cmd_get":             "counter",
+	"cmd_set":             "counter",
+	"get_hits":            "counter",
+	"get_misses":          "counter",
+	"evictions":           "counter",
+	"curr_items":          "gauge",
+	"bytes":               "gauge",
+	"limit_maxbytes":      "gauge",
+	"bytes_read":          "counter",
+	"bytes_written":       "counter",
+	"listen_disabled_num": "counter",
+}
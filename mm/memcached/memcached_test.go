@@ -0,0 +1,73 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package memcached_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/memcached"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type byName []mm.Metric
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type MemcachedTestSuite struct {
+}
+
+var _ = Suite(&MemcachedTestSuite{})
+
+var stats = map[string]string{
+	"pid":              "1234",
+	"version":          "1.4.20",
+	"curr_connections": "10",
+	"cmd_get":          "500",
+	"get_hits":         "450",
+}
+
+func (s *MemcachedTestSuite) TestStatsMetrics(t *C) {
+	collect := map[string]string{
+		"curr_connections": "gauge",
+		"cmd_get":          "counter",
+		"version":          "gauge",   // not a number
+		"evictions":        "counter", // doesn't exist
+	}
+	got := memcached.StatsMetrics(stats, collect)
+	sort.Sort(byName(got))
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"memcached/cmd_get", "counter", 500, ""},
+		{"memcached/curr_connections", "gauge", 10, ""},
+	})
+
+	// Default stats.
+	got = memcached.StatsMetrics(stats, nil)
+	sort.Sort(byName(got))
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"memcached/cmd_get", "counter", 500, ""},
+		{"memcached/curr_connections", "gauge", 10, ""},
+		{"memcached/get_hits", "counter", 450, ""},
+	})
+}
@@ -0,0 +1,193 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package memcached
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/memcached"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Monitor is an mm monitor that collects memcached stats.  It connects for
+// each collection, so there's no connection to lose.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   memcached.Connector
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	status         *pct.Status
+	sync           *pct.SyncChan
+	running        bool
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn memcached.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		status: pct.NewStatus([]string{name}),
+		sync:   pct.NewSyncChan(),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.running = false
+	m.logger.Info("Stopped")
+
+	// Do not update status to "Stopped" here; run() does that on return.
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("memcached monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	m.status.Update(m.name, "Ready")
+
+	var lastTs int64
+	var lastError string
+	for {
+		t := time.Unix(lastTs, 0)
+		if lastError == "" {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
+		}
+
+		select {
+		case now := <-m.tickChan:
+			m.status.Update(m.name, "Running")
+			stats, err := m.conn.Stats()
+			if err != nil {
+				// Log only new errors, not one every interval while it's down.
+				if err.Error() != lastError {
+					m.logger.Warn(err)
+				}
+				lastError = err.Error()
+				continue
+			}
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:      now.UTC().Unix(),
+				Metrics: StatsMetrics(stats, m.config.Stats),
+			}
+			if len(c.Metrics) == 0 {
+				lastError = "No metrics"
+				continue
+			}
+			select {
+			case m.collectionChan <- c:
+				lastTs = c.Ts
+				lastError = ""
+			case <-time.After(500 * time.Millisecond):
+				// lost collection
+				m.logger.Debug("Lost memcached metrics; timeout spooling after 500ms")
+				lastError = "Spool timeout"
+			}
+		case <-m.sync.StopChan:
+			return
+		}
+	}
+}
+
+// StatsMetrics returns the stats as metrics, e.g. curr_connections is
+// memcached/curr_connections.  If collect is empty, DEFAULT_STATS are
+// collected.  Stats that don't exist or aren't numbers are skipped.
+func StatsMetrics(stats map[string]string, collect map[string]string) []mm.Metric {
+	if len(collect) == 0 {
+		collect = DEFAULT_STATS
+	}
+	metrics := []mm.Metric{}
+	for name, metricType := range collect {
+		n, err := strconv.ParseFloat(stats[name], 64)
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, mm.Metric{"memcached/" + name, metricType, n, ""})
+	}
+	return metrics
+}
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"testing"
 	"time"
@@ -494,6 +495,59 @@ func (s *AggregatorTestSuite) TestMissingAllMetrics(t *C) {
 	t.Check(got.Stats[0].Stats["foo"].Avg, Equals, float64(170))
 }
 
+func (s *AggregatorTestSuite) TestTruncatedIntervals(t *C) {
+	interval := int64(60)
+	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool)
+	go a.Start()
+
+	collect := func(ts int64) {
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+			Ts:              ts,
+			Metrics:         []mm.Metric{{Name: "foo", Type: "gauge", Number: 1}},
+		}
+	}
+
+	// 2009-11-10 23:00:00.  The agent starts 15s into the first interval.
+	t0 := int64(1257894000)
+	collect(t0 + 15)
+	collect(t0 + 30)
+	collect(t0 + 60) // next interval, reports the first
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(t0, 0).UTC())
+	t.Check(got.Duration, Equals, uint(60))
+	t.Check(got.StartTs, Equals, time.Unix(t0+15, 0).UTC())
+	t.Check(got.EndTs, Equals, time.Unix(t0+60, 0).UTC())
+	t.Check(got.Truncated, Equals, true)
+	t.Check(got.FirstInterval, Equals, true)
+	t.Check(got.LastInterval, Equals, false)
+
+	collect(t0 + 90)
+	collect(t0 + 120) // next interval, reports the second
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(t0+60, 0).UTC())
+	t.Check(got.StartTs, Equals, got.Ts)
+	t.Check(got.EndTs, Equals, time.Unix(t0+120, 0).UTC())
+	t.Check(got.Truncated, Equals, false)
+	t.Check(got.FirstInterval, Equals, false)
+	t.Check(got.LastInterval, Equals, false)
+
+	// The agent stops 20s into the third interval.  Close reports it, unlike
+	// Stop which discards it.
+	collect(t0 + 140)
+	a.Close()
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(t0+120, 0).UTC())
+	t.Check(got.StartTs, Equals, got.Ts)
+	t.Check(got.EndTs, Equals, time.Unix(t0+140, 0).UTC())
+	t.Check(got.Truncated, Equals, true)
+	t.Check(got.FirstInterval, Equals, false)
+	t.Check(got.LastInterval, Equals, true)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager test suite
 /////////////////////////////////////////////////////////////////////////////
@@ -641,6 +695,199 @@ func (s *ManagerTestSuite) TestStartStopManager(t *C) {
 	t.Check(status["mm"], Equals, "Stopped")
 }
 
+func (s *ManagerTestSuite) TestThrottle(t *C) {
+	mrm := mock.NewMrmsMonitor()
+	m := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im, mrm)
+	t.Assert(m, NotNil)
+	config := &mm.Config{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    "mysql",
+			InstanceId: 1,
+		},
+		Collect: 2,
+		Report:  60,
+	}
+	err := pct.Basedir.WriteConfig("mm-mysql-1", config)
+	t.Assert(err, IsNil)
+	err = m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+	t.Check(s.clock.Added, DeepEquals, []uint{2})
+
+	// Collect 5x less often: re-add the tickChan at 5 * 2s.
+	m.Throttle(5)
+	t.Check(s.clock.Added, DeepEquals, []uint{2, 10})
+	t.Check(s.clock.Removed, HasLen, 1)
+
+	// Same throttle is a no-op.
+	m.Throttle(5)
+	t.Check(s.clock.Added, DeepEquals, []uint{2, 10})
+
+	// Back to normal.
+	m.Throttle(1)
+	t.Check(s.clock.Added, DeepEquals, []uint{2, 10, 2})
+	t.Check(s.clock.Removed, HasLen, 2)
+}
+
+func (s *ManagerTestSuite) TestPause(t *C) {
+	mrm := mock.NewMrmsMonitor()
+	m := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im, mrm)
+	t.Assert(m, NotNil)
+	config := &mm.Config{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    "mysql",
+			InstanceId: 1,
+		},
+		Collect: 2,
+		Report:  60,
+	}
+	err := pct.Basedir.WriteConfig("mm-mysql-1", config)
+	t.Assert(err, IsNil)
+	err = m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+	t.Check(s.clock.Added, DeepEquals, []uint{2})
+
+	// Pausing removes the tickChan so the monitor stops collecting.
+	m.Pause(true)
+	t.Check(s.clock.Removed, HasLen, 1)
+	t.Check(s.clock.Added, DeepEquals, []uint{2})
+
+	// Throttling while paused doesn't re-add the tickChan...
+	m.Throttle(5)
+	t.Check(s.clock.Added, DeepEquals, []uint{2})
+
+	// ...but resuming does, at the throttled interval.
+	m.Pause(false)
+	t.Check(s.clock.Added, DeepEquals, []uint{2, 10})
+	t.Check(s.clock.Removed, HasLen, 1)
+}
+
+func (s *ManagerTestSuite) TestSharedTicker(t *C) {
+	mrm := mock.NewMrmsMonitor()
+	m := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im, mrm)
+	t.Assert(m, NotNil)
+	for _, name := range []string{"mm-mysql-1", "mm-server-1"} {
+		service := "mysql"
+		if name == "mm-server-1" {
+			service = "server"
+		}
+		config := &mm.Config{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    service,
+				InstanceId: 1,
+			},
+			Collect: 1,
+			Report:  60,
+		}
+		err := pct.Basedir.WriteConfig(name, config)
+		t.Assert(err, IsNil)
+	}
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	// Monitors that collect at the same interval share one ticker.
+	t.Check(s.clock.Added, DeepEquals, []uint{1})
+	t.Check(m.Status()["mm-tickers"], Equals, "2 monitors, 1 tickers, 1 aggregators")
+
+	// A tick from the clock ticks both monitors.
+	now := time.Now().UTC()
+	got := make(chan time.Time, 2)
+	for _, monitor := range []*mock.MmMonitor{s.mysqlMonitor, s.systemMonitor} {
+		go func(c chan time.Time) {
+			select {
+			case tick := <-c:
+				got <- tick
+			case <-time.After(time.Second):
+			}
+		}(monitor.TickChan())
+	}
+	s.clock.Watchers[0] <- now
+	for i := 0; i < 2; i++ {
+		select {
+		case t1 := <-got:
+			t.Check(t1, Equals, now)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Monitor not ticked")
+		}
+	}
+
+	// The ticker is removed when its last monitor stops.
+	stop := func(service string) {
+		data, _ := json.Marshal(&mm.Config{ServiceInstance: proto.ServiceInstance{Service: service, InstanceId: 1}})
+		reply := m.Handle(&proto.Cmd{Service: "mm", Cmd: "StopService", Data: data})
+		t.Assert(reply.Error, Equals, "")
+	}
+	stop("mysql")
+	t.Check(s.clock.Removed, HasLen, 0)
+	stop("server")
+	t.Check(s.clock.Removed, HasLen, 1)
+	t.Check(m.Status()["mm-tickers"], Equals, "0 monitors, 0 tickers, 1 aggregators")
+}
+
+func (s *ManagerTestSuite) TestCollectWorkers(t *C) {
+	// More collectors than workers, so some wait for a worker.
+	n := mm.COLLECT_WORKERS * 5
+	collected := make(chan time.Time, n)
+	monitors := map[string]mm.Monitor{}
+	for i := 1; i <= n; i++ {
+		c := mock.NewMmCollector()
+		c.CollectChan = collected
+		monitors[fmt.Sprintf("mysql-%d", i)] = c
+		config := &mm.Config{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    "mysql",
+				InstanceId: uint(i),
+			},
+			Collect: 1,
+			Report:  60,
+		}
+		err := pct.Basedir.WriteConfig(fmt.Sprintf("mm-mysql-%d", i), config)
+		t.Assert(err, IsNil)
+	}
+	factory := mock.NewMmMonitorFactory(monitors)
+	mrm := mock.NewMrmsMonitor()
+	m := mm.NewManager(s.logger, factory, s.clock, s.spool, s.im, mrm)
+	t.Assert(m, NotNil)
+
+	// The collectors run on the tickGroup's workers, not their own
+	// goroutines, so starting them doesn't add a goroutine per monitor.
+	goroutines := runtime.NumGoroutine()
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+	t.Check(runtime.NumGoroutine()-goroutines < n, Equals, true)
+	t.Check(s.clock.Added, DeepEquals, []uint{1})
+	t.Check(m.Status()["mm-tickers"], Equals, fmt.Sprintf("%d monitors, 1 tickers, 1 aggregators", n))
+	for _, monitor := range monitors {
+		t.Check(monitor.TickChan(), IsNil)
+	}
+
+	// A tick from the clock collects every collector once.
+	now := time.Now().UTC()
+	s.clock.Watchers[0] <- now
+	for i := 0; i < n; i++ {
+		select {
+		case t1 := <-collected:
+			t.Check(t1, Equals, now)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Collected %d of %d", i, n)
+		}
+	}
+	select {
+	case <-collected:
+		t.Error("Collector collected twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Stopping the manager removes the collectors and the ticker.
+	err = m.Stop()
+	t.Assert(err, IsNil)
+	t.Check(s.clock.Removed, HasLen, 1)
+	t.Check(m.Status()["mm-tickers"], Equals, "0 monitors, 0 tickers, 0 aggregators")
+}
+
 /**
  * Tests:
  * - starting monitor
@@ -1030,3 +1277,50 @@ func (s *StatsTestSuite) TestPCT939(t *C) {
 		test.Dump(got)
 	*/
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// LoadBackoff test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type LoadBackoffTestSuite struct{}
+
+var _ = Suite(&LoadBackoffTestSuite{})
+
+func (s *LoadBackoffTestSuite) TestBackoff(t *C) {
+	b := mm.NewLoadBackoff(4)
+	t.Check(b.Factor(), Equals, uint(1))
+	t.Check(b.Collect(), Equals, true)
+	t.Check(b.Update(false), Equals, false)
+
+	// Loaded: collect every 2, then 4 ticks, but no more than max.
+	t.Check(b.Update(true), Equals, true)
+	t.Check(b.Factor(), Equals, uint(2))
+	t.Check(b.Collect(), Equals, false)
+	t.Check(b.Collect(), Equals, true)
+	t.Check(b.Update(true), Equals, true)
+	t.Check(b.Factor(), Equals, uint(4))
+	t.Check(b.Update(true), Equals, false)
+	t.Check(b.Factor(), Equals, uint(4))
+	got := []bool{}
+	for i := 0; i < 8; i++ {
+		got = append(got, b.Collect())
+	}
+	t.Check(got, DeepEquals, []bool{false, false, false, true, false, false, false, true})
+
+	// Normal load: back to every 2 ticks after CALM_COLLECTIONS.
+	for i := 1; i < mm.CALM_COLLECTIONS; i++ {
+		t.Check(b.Update(false), Equals, false)
+	}
+	t.Check(b.Update(false), Equals, true)
+	t.Check(b.Factor(), Equals, uint(2))
+
+	// Loaded again resets the calm count.
+	t.Check(b.Update(false), Equals, false)
+	t.Check(b.Update(true), Equals, true)
+	t.Check(b.Factor(), Equals, uint(4))
+	for i := 0; i < 2*mm.CALM_COLLECTIONS; i++ {
+		b.Update(false)
+	}
+	t.Check(b.Factor(), Equals, uint(1))
+	t.Check(b.Collect(), Equals, true)
+}
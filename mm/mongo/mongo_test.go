@@ -0,0 +1,163 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mongo_test
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/mongo"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+// fakeConn is a mongo.Connector that returns canned status.
+type fakeConn struct {
+	serverStatus bson.M
+	replStatus   bson.M
+	err          error
+	mux          sync.Mutex
+}
+
+func (c *fakeConn) URL() string              { return "mongodb://localhost" }
+func (c *fakeConn) Connect(tries uint) error { return nil }
+func (c *fakeConn) Close()                   {}
+func (c *fakeConn) ServerStatus() (bson.M, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.serverStatus, c.err
+}
+func (c *fakeConn) ReplSetGetStatus() (bson.M, error) { return c.replStatus, nil }
+
+type byName []mm.Metric
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type MongoTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&MongoTestSuite{})
+
+func (s *MongoTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "mm-mongo-test")
+}
+
+var serverStatus = bson.M{
+	"version":     "2.6.5",
+	"connections": bson.M{"current": 12, "available": int64(807)},
+	"opcounters":  bson.M{"insert": int64(100), "query": 250.0},
+	"mem":         bson.M{"supported": true},
+}
+
+func (s *MongoTestSuite) TestServerStatusMetrics(t *C) {
+	metrics := map[string]string{
+		"connections.current": "gauge",
+		"opcounters.insert":   "counter",
+		"opcounters.query":    "counter",
+		"mem.supported":       "gauge",
+		"version":             "string", // not a number
+		"wiredTiger.cache":    "gauge",  // doesn't exist
+	}
+	got := mongo.ServerStatusMetrics(serverStatus, metrics)
+	sort.Sort(byName(got))
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"mongo/connections.current", "gauge", 12, ""},
+		{"mongo/mem.supported", "gauge", 1, ""},
+		{"mongo/opcounters.insert", "counter", 100, ""},
+		{"mongo/opcounters.query", "counter", 250, ""},
+	})
+}
+
+func (s *MongoTestSuite) TestReplSetMetrics(t *C) {
+	now := time.Now().UTC()
+	status := bson.M{
+		"set":     "rs0",
+		"myState": 2,
+		"members": []interface{}{
+			bson.M{"name": "db1:27017", "health": 1.0, "state": 1, "optimeDate": now},
+			bson.M{"name": "db2:27017", "health": 1.0, "state": 2, "optimeDate": now.Add(-5 * time.Second), "self": true},
+			bson.M{"name": "db3:27017", "health": 0.0, "state": 8},
+		},
+	}
+	got := mongo.ReplSetMetrics(status)
+	sort.Sort(byName(got))
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"mongo/repl/lag", "gauge", 5, ""},
+		{"mongo/repl/members", "gauge", 3, ""},
+		{"mongo/repl/members_healthy", "gauge", 2, ""},
+		{"mongo/repl/my_state", "gauge", 2, ""},
+	})
+}
+
+func (s *MongoTestSuite) TestMonitor(t *C) {
+	config := &mongo.Config{
+		Config: mm.Config{
+			ServiceInstance: proto.ServiceInstance{Service: "mongo", InstanceId: 1},
+			Collect:         1,
+			Report:          60,
+		},
+		ServerStatus: map[string]string{"connections.current": "gauge"},
+	}
+	conn := &fakeConn{serverStatus: serverStatus}
+	m := mongo.NewMonitor("mm-mongo-db1", config, s.logger, conn)
+
+	tickChan := make(chan time.Time)
+	collectionChan := make(chan *mm.Collection, 1)
+	t.Assert(m.Start(tickChan, collectionChan), IsNil)
+	defer m.Stop()
+
+	// Wait for connect goroutine.
+	time.Sleep(100 * time.Millisecond)
+
+	now := time.Now()
+	tickChan <- now
+	select {
+	case c := <-collectionChan:
+		t.Check(c.Service, Equals, "mongo")
+		t.Check(c.InstanceId, Equals, uint(1))
+		t.Check(c.Ts, Equals, now.UTC().Unix())
+		t.Check(c.Metrics, DeepEquals, []mm.Metric{{"mongo/connections.current", "gauge", 12, ""}})
+	case <-time.After(1 * time.Second):
+		t.Fatal("No collection")
+	}
+
+	// serverStatus error: reconnect and no collection.
+	conn.mux.Lock()
+	conn.err = errors.New("connection reset")
+	conn.mux.Unlock()
+	tickChan <- now.Add(time.Second)
+	select {
+	case <-collectionChan:
+		t.Error("Got collection after serverStatus error")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,356 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mongo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mongo"
+	"github.com/percona/percona-agent/pct"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Monitor is an mm monitor that collects MongoDB serverStatus metrics and,
+// optionally, replica set metrics, like the MySQL monitor collects SHOW STATUS.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mongo.Connector
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	connectedChan  chan bool
+	status         *pct.Status
+	sync           *pct.SyncChan
+	running        bool
+	collectLimit   float64
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mongo.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		connectedChan: make(chan bool, 1),
+		status:        pct.NewStatus([]string{name, name + "-mongo"}),
+		sync:          pct.NewSyncChan(),
+		collectLimit:  float64(config.Collect) * 0.1, // 10% of Collect time
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	m.logger.Debug("Start:call")
+	defer m.logger.Debug("Start:return")
+
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	m.logger.Debug("Stop:call")
+	defer m.logger.Debug("Stop:return")
+
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.running = false
+	m.logger.Info("Stopped")
+
+	// Do not update status to "Stopped" here; run() does that on return.
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// run:@goroutine[3]
+func (m *Monitor) connect(err error) {
+	m.logger.Debug("connect:call")
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("MongoDB connection crashed: ", err)
+		}
+		m.logger.Debug("connect:return")
+	}()
+
+	// Close/release previous connection, if any.
+	m.conn.Close()
+
+	// Try forever to connect to MongoDB...
+	for {
+		m.logger.Debug("connect:try")
+		if err != nil {
+			m.status.Update(m.name+"-mongo", fmt.Sprintf("Connecting (%s)", err))
+		} else {
+			m.status.Update(m.name+"-mongo", "Connecting")
+		}
+		if err = m.conn.Connect(1); err != nil {
+			m.logger.Warn(err)
+			continue
+		}
+		m.logger.Info("Connected")
+		m.status.Update(m.name+"-mongo", "Connected")
+
+		// Tell run() goroutine that it can try to collect metrics.
+		// If connection is lost, it will call us again.
+		m.connectedChan <- true
+		return
+	}
+}
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	m.logger.Debug("run:call")
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("MongoDB monitor crashed: ", err)
+		}
+		m.conn.Close()
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+		m.logger.Debug("run:return")
+	}()
+
+	connected := false
+	go m.connect(nil)
+
+	m.status.Update(m.name, "Ready")
+
+	var lastTs int64
+	var lastError string
+	for {
+		t := time.Unix(lastTs, 0)
+		if lastError == "" {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
+		}
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			if !connected {
+				m.logger.Debug("run:collect:disconnected")
+				lastError = "Not connected to MongoDB"
+				continue
+			}
+			m.status.Update(m.name, "Running")
+
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:      now.UTC().Unix(),
+				Metrics: []mm.Metric{},
+			}
+
+			// Start timing the collection.  If must take < collectLimit else
+			// it's discarded.
+			start := time.Now()
+
+			// db.serverStatus()
+			status, err := m.conn.ServerStatus()
+			if err != nil {
+				// Can't get the status of a server that's up, so assume the
+				// connection is lost and reconnect.
+				lastError = fmt.Sprintf("serverStatus: %s", err)
+				m.logger.Warn(lastError)
+				connected = false
+				go m.connect(err)
+				continue
+			}
+			c.Metrics = append(c.Metrics, ServerStatusMetrics(status, m.config.ServerStatus)...)
+
+			// rs.status()
+			if m.config.ReplSet {
+				replStatus, err := m.conn.ReplSetGetStatus()
+				if err != nil {
+					m.logger.Warn("replSetGetStatus: ", err)
+				} else if replStatus != nil {
+					c.Metrics = append(c.Metrics, ReplSetMetrics(replStatus)...)
+				}
+			}
+
+			diff := time.Now().Sub(start).Seconds()
+			if diff >= m.collectLimit {
+				lastError = fmt.Sprintf("Skipping interval because it took too long to collect: %.2fs >= %.2fs", diff, m.collectLimit)
+				m.logger.Warn(lastError)
+				continue
+			}
+
+			// Send the metrics to an mm.Aggregator.
+			m.status.Update(m.name, "Sending metrics")
+			if len(c.Metrics) > 0 {
+				select {
+				case m.collectionChan <- c:
+					lastTs = c.Ts
+					lastError = ""
+				case <-time.After(500 * time.Millisecond):
+					// lost collection
+					m.logger.Debug("Lost MongoDB metrics; timeout spooling after 500ms")
+					lastError = "Spool timeout"
+				}
+			} else {
+				m.logger.Debug("run:no metrics")
+				lastError = "No metrics"
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case connected = <-m.connectedChan:
+			m.logger.Debug("run:connected:true")
+			m.status.Update(m.name, "Ready")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// ServerStatusMetrics returns the serverStatus metrics by path, e.g.
+// opcounters.insert is mongo/opcounters.insert.  Paths that don't exist, e.g.
+// wiredTiger metrics with MMAPv1, or that aren't numbers are skipped.
+func ServerStatusMetrics(status bson.M, metrics map[string]string) []mm.Metric {
+	collected := []mm.Metric{}
+	for path, metricType := range metrics {
+		var v interface{} = status
+		for _, key := range strings.Split(path, ".") {
+			doc, ok := v.(bson.M)
+			if !ok {
+				v = nil
+				break
+			}
+			v = doc[key]
+		}
+		n, ok := number(v)
+		if !ok {
+			continue
+		}
+		collected = append(collected, mm.Metric{"mongo/" + path, metricType, n, ""})
+	}
+	return collected
+}
+
+// ReplSetMetrics returns the replica set member state, the number of members
+// and healthy members, and the replication lag: how many seconds the member's
+// last applied op is behind the primary's.
+func ReplSetMetrics(status bson.M) []mm.Metric {
+	collected := []mm.Metric{}
+	if state, ok := number(status["myState"]); ok {
+		collected = append(collected, mm.Metric{"mongo/repl/my_state", "gauge", state, ""})
+	}
+	members, _ := status["members"].([]interface{})
+	if len(members) == 0 {
+		return collected
+	}
+	var self, primary time.Time
+	healthy := 0
+	for _, v := range members {
+		member, ok := v.(bson.M)
+		if !ok {
+			continue
+		}
+		if health, _ := number(member["health"]); health == 1 {
+			healthy++
+		}
+		optime, _ := member["optimeDate"].(time.Time)
+		if isSelf, _ := member["self"].(bool); isSelf {
+			self = optime
+		}
+		if state, _ := number(member["state"]); state == 1 { // PRIMARY
+			primary = optime
+		}
+	}
+	collected = append(collected,
+		mm.Metric{"mongo/repl/members", "gauge", float64(len(members)), ""},
+		mm.Metric{"mongo/repl/members_healthy", "gauge", float64(healthy), ""},
+	)
+	if !self.IsZero() && !primary.IsZero() {
+		lag := primary.Sub(self).Seconds()
+		if lag < 0 {
+			lag = 0 // clocks or optimes of members differ slightly
+		}
+		collected = append(collected, mm.Metric{"mongo/repl/lag", "gauge", lag, ""})
+	}
+	return collected
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -41,6 +41,16 @@ type Monitor interface {
 	Config() interface{}
 }
 
+// A Collector is a Monitor that the Manager runs on the shared collect workers
+// of its tickGroup instead of its own goroutine: Start is called with a nil
+// tickChan, then Collect is called for each tick.  Calls to Collect are never
+// concurrent for one Collector; if it's still collecting when the next tick
+// comes, it misses that tick.
+type Collector interface {
+	Monitor
+	Collect(now time.Time)
+}
+
 type MonitorFactory interface {
 	Make(service string, instanceId uint, data []byte) (Monitor, error)
 }
@@ -74,8 +84,16 @@ type InstanceStats struct {
 	Stats map[string]*Stats // keyed on metric name
 }
 
+// A Report is the stats of one interval.  Ts and Duration are the interval;
+// StartTs and EndTs are the part of it with collections, which is less if
+// the aggregator started or stopped during the interval.
 type Report struct {
-	Ts       time.Time // start, UTC
-	Duration uint      // seconds
-	Stats    []*InstanceStats
+	Ts            time.Time // interval start, UTC
+	Duration      uint      // interval seconds
+	StartTs       time.Time // first collection if Truncated at start, else Ts, UTC
+	EndTs         time.Time // last collection if Truncated at stop, else Ts + Duration, UTC
+	Truncated     bool      `json:",omitempty"` // collections don't cover the whole interval
+	FirstInterval bool      `json:",omitempty"` // first interval after the aggregator started
+	LastInterval  bool      `json:",omitempty"` // interval cut short because the aggregator stopped
+	Stats         []*InstanceStats
 }
@@ -21,13 +21,24 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/percona/cloud-protocol/proto"
+	haproxyConn "github.com/percona/percona-agent/haproxy"
 	"github.com/percona/percona-agent/instance"
+	memcachedConn "github.com/percona/percona-agent/memcached"
 	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/haproxy"
+	"github.com/percona/percona-agent/mm/memcached"
+	"github.com/percona/percona-agent/mm/mongo"
 	"github.com/percona/percona-agent/mm/mysql"
+	"github.com/percona/percona-agent/mm/postgres"
+	"github.com/percona/percona-agent/mm/redis"
 	"github.com/percona/percona-agent/mm/system"
+	mongoConn "github.com/percona/percona-agent/mongo"
 	"github.com/percona/percona-agent/mrms"
 	mysqlConn "github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/plugin"
+	postgresConn "github.com/percona/percona-agent/postgres"
+	redisConn "github.com/percona/percona-agent/redis"
 )
 
 type Factory struct {
@@ -65,13 +76,132 @@ func (f *Factory) Make(service string, instanceId uint, data []byte) (mm.Monitor
 		alias := "mm-mysql-" + mysqlIt.Hostname
 
 		// Make a MySQL metrics monitor.
-		monitor = mysql.NewMonitor(
+		mysqlMonitor := mysql.NewMonitor(
 			alias,
 			config,
 			pct.NewLogger(f.logChan, alias),
 			mysqlConn.NewConnection(mysqlIt.DSN),
 			f.mrm,
 		)
+		mysqlMonitor.InstanceDown = func() bool {
+			return f.ir.Down(service, instanceId)
+		}
+		monitor = mysqlMonitor
+	case "mongo":
+		// Load the MongoDB instance info (URL, name, etc.).
+		mongoIt := &mongoConn.Instance{}
+		if err := f.ir.Get(service, instanceId, mongoIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the MongoDB mm config.
+		config := &mongo.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. mm-mongo-db101:
+		alias := "mm-mongo-" + mongoIt.Hostname
+
+		// Make a MongoDB metrics monitor.
+		monitor = mongo.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mongoConn.NewConnection(mongoIt.URL),
+		)
+	case "postgres":
+		// Load the PostgreSQL instance info (DSN, name, etc.).
+		postgresIt := &postgresConn.Instance{}
+		if err := f.ir.Get(service, instanceId, postgresIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the PostgreSQL mm config.
+		config := &postgres.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. mm-postgres-db101:
+		alias := "mm-postgres-" + postgresIt.Hostname
+
+		// Make a PostgreSQL metrics monitor.
+		monitor = postgres.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			postgresConn.NewConnection(postgresIt.DSN),
+		)
+	case "memcached":
+		// Load the memcached instance info (address, name, etc.).
+		memcachedIt := &memcachedConn.Instance{}
+		if err := f.ir.Get(service, instanceId, memcachedIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the memcached mm config.
+		config := &memcached.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. mm-memcached-cache101:
+		alias := "mm-memcached-" + memcachedIt.Hostname
+
+		// Make a memcached metrics monitor.
+		monitor = memcached.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			memcachedConn.NewConnection(memcachedIt.Addr),
+		)
+	case "redis":
+		// Load the Redis instance info (address, password, name, etc.).
+		redisIt := &redisConn.Instance{}
+		if err := f.ir.Get(service, instanceId, redisIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the Redis mm config.
+		config := &redis.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. mm-redis-cache101:
+		alias := "mm-redis-" + redisIt.Hostname
+
+		// Make a Redis metrics monitor.
+		monitor = redis.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			redisConn.NewConnection(redisIt.Addr, redisIt.Password),
+		)
+	case "haproxy":
+		// Load the HAProxy instance info (stats address, name, etc.).
+		haproxyIt := &haproxyConn.Instance{}
+		if err := f.ir.Get(service, instanceId, haproxyIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the HAProxy mm config.
+		config := &haproxy.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// The user-friendly name of the service, e.g. mm-haproxy-lb101:
+		alias := "mm-haproxy-" + haproxyIt.Hostname
+
+		// Make an HAProxy metrics monitor.
+		monitor = haproxy.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			haproxyConn.NewConnection(haproxyIt.Addr),
+		)
 	case "server":
 		// Parse the system mm config.
 		config := &system.Config{}
@@ -88,6 +218,26 @@ func (f *Factory) Make(service string, instanceId uint, data []byte) (mm.Monitor
 			config,
 			pct.NewLogger(f.logChan, alias),
 		)
+	case "plugin":
+		// Parse the plugin mm config.
+		config := &plugin.MonitorConfig{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+		if config.Plugin == "" {
+			return nil, errors.New("Plugin not set")
+		}
+
+		// Plugin instances are not in the instance repo, so the plugin name
+		// is the user-friendly name, e.g. mm-plugin-redis.
+		alias := "mm-plugin-" + config.Plugin
+
+		// Make an external plugin metrics monitor.
+		monitor = plugin.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+		)
 	default:
 		return nil, errors.New("Unknown metrics monitor type: " + service)
 	}
@@ -24,7 +24,24 @@ import (
 type Config struct {
 	mm.Config
 	Status            map[string]string // SHOW STATUS variables to collect, case-sensitive
+	Types             map[string]string // override StatusTypes, e.g. "foo_count": "gauge"
 	InnoDB            []string          // SET GLOBAL innodb_monitor_enable="<value>"
 	UserStats         bool              // SET GLOBAL userstat=ON|OFF
 	UserStatsIgnoreDb string
+	Binlog            bool   // SHOW BINARY LOGS and binlog filesystem space, every BINLOG_INTERVAL
+	Heartbeat         string // heartbeat table to get replication delay from, e.g. percona.heartbeat
+	RocksDB           bool   // SHOW ENGINE ROCKSDB STATUS and rocksdb_% status, if engine is enabled
+	// Adaptive collection: collect less often while MySQL is struggling,
+	// see mm.LoadBackoff.  Zero values are the defaults.
+	Adaptive          bool
+	MaxThreadsRunning uint // struggling if Threads_running >= this
+	MaxLatency        uint // ms, struggling if SHOW STATUS takes longer
+	MaxBackoff        uint // collect at least every MaxBackoff * Collect seconds
 }
+
+const (
+	DEFAULT_MAX_THREADS_RUNNING = 32
+	DEFAULT_MAX_LATENCY         = 500 // ms
+	UPTIME_STALL                = 2   // seconds Uptime can drift from wall time
+	BINLOG_INTERVAL             = 300 // seconds between SHOW BINARY LOGS, see Binlog
+)
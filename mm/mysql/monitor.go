@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/heartbeat"
 	"github.com/percona/percona-agent/mm"
 	"github.com/percona/percona-agent/mrms"
 	"github.com/percona/percona-agent/mysql"
@@ -47,9 +48,29 @@ type Monitor struct {
 	running        bool
 	collectLimit   float64
 	mrm            mrms.Monitor
+	rocksdb        bool            // config.RocksDB and engine is enabled
+	statusStmt     *sql.Stmt       // SHOW GLOBAL STATUS, prepared once per connection
+	statusDB       *sql.DB         // that statusStmt was prepared on
+	backoff        *mm.LoadBackoff // nil unless config.Adaptive
+	threadsRunning float64         // from last SHOW STATUS, -1 if unknown
+	uptime         float64         // from last SHOW STATUS, -1 if unknown
+	lastUptime     float64         // from previous SHOW STATUS, for stalls
+	lastUptimeTs   time.Time
+	local          bool           // MySQL is on this host, see mysql.IsLocal
+	binlogs        *mysql.Binlogs // from last SHOW BINARY LOGS, see GetBinlogMetrics
+	binlogsTs      time.Time
+	connected      bool   // see Collect
+	lastTs         int64  // last collection sent
+	lastError      string // from last collection, if any
+	// --
+	InstanceDown func() bool // true if the instance repo reports MySQL down, or nil
 }
 
 func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector, mrm mrms.Monitor) *Monitor {
+	procs := []string{name, name + "-mysql"}
+	if config.Adaptive {
+		procs = append(procs, name+"-load")
+	}
 	m := &Monitor{
 		name:   name,
 		config: config,
@@ -58,7 +79,7 @@ func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Conn
 		// --
 		connectedChan: make(chan bool, 1),
 		restartChan:   nil,
-		status:        pct.NewStatus([]string{name, name + "-mysql"}),
+		status:        pct.NewStatus(procs),
 		sync:          pct.NewSyncChan(),
 		collectLimit:  float64(config.Collect) * 0.1, // 10% of Collect time
 		mrm:           mrm,
@@ -80,14 +101,39 @@ func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collect
 		return pct.ServiceIsRunningError{m.name}
 	}
 
+	if err := ValidateTypes(m.config.Types); err != nil {
+		return err
+	}
+	if len(m.config.Types) > 0 {
+		types := make(map[string]string, len(m.config.Types))
+		for name, metricType := range m.config.Types {
+			types[strings.ToLower(name)] = metricType
+		}
+		m.config.Types = types
+	}
+
 	m.tickChan = tickChan
 	m.collectionChan = collectionChan
 
+	if m.config.Adaptive {
+		m.backoff = mm.NewLoadBackoff(m.config.MaxBackoff)
+		m.lastUptime = -1
+		m.status.Update(m.name+"-load", fmt.Sprintf("Normal (collecting every %ds)", m.config.Collect))
+	}
+
+	m.local = mysql.IsLocal(m.conn.DSN())
 	m.restartChan, err = m.mrm.Add(m.conn.DSN())
 	if err != nil {
 		return err
 	}
-	go m.run()
+	if tickChan != nil {
+		go m.run()
+	} else {
+		// The mm.Manager calls Collect on its collect workers.
+		m.connected = false
+		go m.connect(nil)
+		m.status.Update(m.name, "Ready")
+	}
 	m.running = true
 	m.logger.Info("Started")
 
@@ -103,10 +149,17 @@ func (m *Monitor) Stop() error {
 		return nil // already stopped
 	}
 
-	// Stop run().  When it returns, it updates status to "Stopped".
+	// Stop run().  When it returns, it updates status to "Stopped".  Without
+	// run(), the mm.Manager has stopped calling Collect, so clean up here.
 	m.status.Update(m.name, "Stopping")
-	m.sync.Stop()
-	m.sync.Wait()
+	if m.tickChan != nil {
+		m.sync.Stop()
+		m.sync.Wait()
+	} else {
+		m.closeStatusStmt()
+		m.conn.Close()
+		m.status.Update(m.name, "Stopped")
+	}
 
 	m.mrm.Remove(m.conn.DSN(), m.restartChan)
 
@@ -132,6 +185,31 @@ func (m *Monitor) Config() interface{} {
 	return m.config
 }
 
+// Collect implements mm.Collector: it's called instead of run() when the
+// monitor is started without a tickChan.
+// mm collect worker:@goroutine[2]
+func (m *Monitor) Collect(now time.Time) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("MySQL monitor crashed: ", err)
+		}
+		m.idle()
+	}()
+	select {
+	case m.connected = <-m.connectedChan:
+		m.logger.Debug("Collect:connected:true")
+	default:
+	}
+	select {
+	case <-m.restartChan:
+		m.logger.Debug("Collect:mysql:restart")
+		m.connected = false
+		go m.connect(fmt.Errorf("Lost connection to MySQL, restarting"))
+	default:
+	}
+	m.collect(now)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
@@ -158,13 +236,22 @@ func (m *Monitor) connect(err error) {
 			m.status.Update(m.name+"-mysql", fmt.Sprintf("Connecting"))
 		}
 		if err = m.conn.Connect(1); err != nil {
-			m.logger.Warn(err)
+			// Don't repeat the error every try if the instance manager
+			// already reports that MySQL is down.
+			if m.InstanceDown != nil && m.InstanceDown() {
+				m.logger.Debug(err)
+			} else {
+				m.logger.Warn(err)
+			}
 			continue
 		}
 		m.logger.Info("Connected")
 		m.status.Update(m.name+"-mysql", "Connected")
 
 		m.setGlobalVars()
+		if m.config.RocksDB {
+			m.rocksdb = m.haveRocksDB()
+		}
 
 		// Tell run() goroutine that it can try to collect metrics.
 		// If connection is lost, it will call us again.
@@ -186,7 +273,12 @@ func (m *Monitor) setGlobalVars() {
 			sql := "SET GLOBAL innodb_monitor_enable = '" + module + "'"
 			if _, err := m.conn.DB().Exec(sql); err != nil {
 				errMsg := fmt.Sprintf("Cannot collect InnoDB stats because '%s' failed: %s", sql, err)
-				m.logger.Error(errMsg)
+				if mysql.MissingPrivilege(err) {
+					// Expected if the agent MySQL user doesn't have SUPER.
+					m.logger.Warn(errMsg)
+				} else {
+					m.logger.Error(errMsg)
+				}
 				m.config.InnoDB = []string{}
 				break
 			}
@@ -199,7 +291,11 @@ func (m *Monitor) setGlobalVars() {
 		sql := "SET GLOBAL userstat=ON"
 		if _, err := m.conn.DB().Exec(sql); err != nil {
 			errMsg := fmt.Sprintf("Cannot collect user stats because '%s' failed: %s", sql, err)
-			m.logger.Error(errMsg)
+			if mysql.MissingPrivilege(err) {
+				m.logger.Warn(errMsg)
+			} else {
+				m.logger.Error(errMsg)
+			}
 			m.config.UserStats = false
 		}
 	}
@@ -212,122 +308,164 @@ func (m *Monitor) run() {
 		if err := recover(); err != nil {
 			m.logger.Error("MySQL monitor crashed: ", err)
 		}
+		m.closeStatusStmt()
 		m.conn.Close()
 		m.status.Update(m.name, "Stopped")
 		m.sync.Done()
 		m.logger.Debug("run:return")
 	}()
 
-	connected := false
+	m.connected = false
 	go m.connect(nil)
 
 	m.status.Update(m.name, "Ready")
 
-	var lastTs int64
-	var lastError string
 	for {
-		t := time.Unix(lastTs, 0)
-		if lastError == "" {
-			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
-		} else {
-			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
-		}
+		m.idle()
 
 		select {
 		case now := <-m.tickChan:
-			m.logger.Debug("run:collect:start")
-			if !connected {
-				m.logger.Debug("run:collect:disconnected")
-				lastError = "Not connected to MySQL"
-				continue
-			}
-			m.status.Update(m.name, "Running")
-
-			c := &mm.Collection{
-				ServiceInstance: proto.ServiceInstance{
-					Service:    m.config.Service,
-					InstanceId: m.config.InstanceId,
-				},
-				Ts:      now.UTC().Unix(),
-				Metrics: []mm.Metric{},
-			}
+			m.collect(now)
+		case m.connected = <-m.connectedChan:
+			m.logger.Debug("run:connected:true")
+			m.status.Update(m.name, "Ready")
+		case <-m.restartChan:
+			m.logger.Debug("run:mysql:restart")
+			m.connected = false
+			go m.connect(fmt.Errorf("Lost connection to MySQL, restarting"))
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
 
-			// Start timing the collection.  If must take < collectLimit else
-			// it's discarded.
-			start := time.Now()
-			conn := m.conn.DB()
+// collect collects and sends metrics for one tick.
+func (m *Monitor) collect(now time.Time) {
+	m.logger.Debug("collect:start")
+	if !m.connected {
+		m.logger.Debug("collect:disconnected")
+		m.lastError = "Not connected to MySQL"
+		return
+	}
+	if m.backoff != nil && !m.backoff.Collect() {
+		m.logger.Debug("collect:backoff")
+		return
+	}
+	m.status.Update(m.name, "Running")
+
+	c := &mm.Collection{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    m.config.Service,
+			InstanceId: m.config.InstanceId,
+		},
+		Ts:      now.UTC().Unix(),
+		Metrics: []mm.Metric{},
+	}
+
+	// Start timing the collection.  If must take < collectLimit else
+	// it's discarded.
+	start := time.Now()
+	conn := m.conn.DB()
+
+	// SHOW GLOBAL STATUS
+	if err := m.GetShowStatusMetrics(conn, c); err != nil {
+		m.collectError(err)
+	}
+	if m.backoff != nil {
+		m.updateBackoff(start, time.Now().Sub(start))
+	}
 
-			// SHOW GLOBAL STATUS
-			if err := m.GetShowStatusMetrics(conn, c); err != nil {
-				m.collectError(err)
+	// SELECT NAME, ... FROM INFORMATION_SCHEMA.INNODB_METRICS
+	if len(m.config.InnoDB) > 0 {
+		if err := m.GetInnoDBMetrics(conn, c); err != nil {
+			if disable := m.collectError(err); disable {
+				m.config.InnoDB = []string{}
 			}
+		}
+	}
 
-			// SELECT NAME, ... FROM INFORMATION_SCHEMA.INNODB_METRICS
-			if len(m.config.InnoDB) > 0 {
-				if err := m.GetInnoDBMetrics(conn, c); err != nil {
-					if disable := m.collectError(err); disable {
-						m.config.InnoDB = []string{}
-					}
-				}
+	if m.config.UserStats {
+		// SELECT ... FROM INFORMATION_SCHEMA.TABLE_STATISTICS
+		if err := m.getTableUserStats(conn, c, m.config.UserStatsIgnoreDb); err != nil {
+			if disable := m.collectError(err); disable {
+				m.config.UserStats = false
+			}
+		}
+		// SELECT ... FROM INFORMATION_SCHEMA.INDEX_STATISTICS
+		if err := m.getIndexUserStats(conn, c, m.config.UserStatsIgnoreDb); err != nil {
+			if disable := m.collectError(err); disable {
+				m.config.UserStats = false
 			}
+		}
+	}
 
-			if m.config.UserStats {
-				// SELECT ... FROM INFORMATION_SCHEMA.TABLE_STATISTICS
-				if err := m.getTableUserStats(conn, c, m.config.UserStatsIgnoreDb); err != nil {
-					if disable := m.collectError(err); disable {
-						m.config.UserStats = false
-					}
-				}
-				// SELECT ... FROM INFORMATION_SCHEMA.INDEX_STATISTICS
-				if err := m.getIndexUserStats(conn, c, m.config.UserStatsIgnoreDb); err != nil {
-					if disable := m.collectError(err); disable {
-						m.config.UserStats = false
-					}
-				}
+	if m.config.Binlog {
+		// SHOW BINARY LOGS
+		if err := m.GetBinlogMetrics(conn, c, now); err != nil {
+			if disable := m.collectError(err); disable {
+				m.config.Binlog = false
 			}
+		}
+	}
 
-			// It is possible that collecting metrics will stall for many
-			// seconds for some reason so even though we issued captures 1 sec in
-			// between, we actually got 5 seconds between results and as such we
-			// might be showing huge spike.
-			// To avoid that, if the time to collect metrics is >= collectLimit
-			// then warn and discard the metrics.
-			diff := time.Now().Sub(start).Seconds()
-			if diff >= m.collectLimit {
-				lastError = fmt.Sprintf("Skipping interval because it took too long to collect: %.2fs >= %.2fs", diff, m.collectLimit)
-				m.logger.Warn(lastError)
-				continue
+	if m.rocksdb {
+		// SHOW ENGINE ROCKSDB STATUS
+		if err := m.GetRocksDBMetrics(conn, c); err != nil {
+			if disable := m.collectError(err); disable {
+				m.rocksdb = false
 			}
+		}
+	}
 
-			// Send the metrics to an mm.Aggregator.
-			m.status.Update(m.name, "Sending metrics")
-			if len(c.Metrics) > 0 {
-				select {
-				case m.collectionChan <- c:
-					lastTs = c.Ts
-					lastError = ""
-				case <-time.After(500 * time.Millisecond):
-					// lost collection
-					m.logger.Debug("Lost MySQL metrics; timeout spooling after 500ms")
-					lastError = "Spool timeout"
-				}
-			} else {
-				m.logger.Debug("run:no metrics") // shouldn't happen
-				lastError = "No metrics"
+	if m.config.Heartbeat != "" {
+		// SELECT ts FROM <heartbeat table>
+		if err := m.GetHeartbeatMetrics(conn, c, now); err != nil {
+			if disable := m.collectError(err); disable {
+				m.config.Heartbeat = ""
 			}
+		}
+	}
 
-			m.logger.Debug("run:collect:stop")
-		case connected = <-m.connectedChan:
-			m.logger.Debug("run:connected:true")
-			m.status.Update(m.name, "Ready")
-		case <-m.restartChan:
-			m.logger.Debug("run:mysql:restart")
-			connected = false
-			go m.connect(fmt.Errorf("Lost connection to MySQL, restarting"))
-		case <-m.sync.StopChan:
-			m.logger.Debug("run:stop")
-			return
+	// It is possible that collecting metrics will stall for many
+	// seconds for some reason so even though we issued captures 1 sec in
+	// between, we actually got 5 seconds between results and as such we
+	// might be showing huge spike.
+	// To avoid that, if the time to collect metrics is >= collectLimit
+	// then warn and discard the metrics.
+	diff := time.Now().Sub(start).Seconds()
+	if diff >= m.collectLimit {
+		m.lastError = fmt.Sprintf("Skipping interval because it took too long to collect: %.2fs >= %.2fs", diff, m.collectLimit)
+		m.logger.Warn(m.lastError)
+		return
+	}
+
+	// Send the metrics to an mm.Aggregator.
+	m.status.Update(m.name, "Sending metrics")
+	if len(c.Metrics) > 0 {
+		select {
+		case m.collectionChan <- c:
+			m.lastTs = c.Ts
+			m.lastError = ""
+		case <-time.After(500 * time.Millisecond):
+			// lost collection
+			m.logger.Debug("Lost MySQL metrics; timeout spooling after 500ms")
+			m.lastError = "Spool timeout"
 		}
+	} else {
+		m.logger.Debug("collect:no metrics") // shouldn't happen
+		m.lastError = "No metrics"
+	}
+
+	m.logger.Debug("collect:stop")
+}
+
+func (m *Monitor) idle() {
+	t := time.Unix(m.lastTs, 0)
+	if m.lastError == "" {
+		m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+	} else {
+		m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, m.lastError))
 	}
 }
 
@@ -342,7 +480,10 @@ func (m *Monitor) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) error {
 
 	m.status.Update(m.name, "Getting global status metrics")
 
-	rows, err := conn.Query("SHOW /*!50002 GLOBAL */ STATUS")
+	m.threadsRunning = -1
+	m.uptime = -1
+
+	rows, err := m.showStatus(conn)
 	if err != nil {
 		return err
 	}
@@ -354,11 +495,23 @@ func (m *Monitor) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) error {
 			return err
 		}
 
+		// Load indicators for adaptive collection, even if not collected.
 		statName = strings.ToLower(statName)
+		switch statName {
+		case "threads_running":
+			m.threadsRunning, _ = strconv.ParseFloat(statValue, 64)
+		case "uptime":
+			m.uptime, _ = strconv.ParseFloat(statValue, 64)
+		}
+
 		metricType, ok := m.config.Status[statName]
 		if !ok {
 			continue // not collecting this stat
 		}
+		metricType = StatusType(statName, m.config.Types, metricType)
+		if metricType == STRING {
+			continue // not a number
+		}
 
 		if statValue == "" {
 			// Some values aren't set when not applicable,
@@ -381,6 +534,86 @@ func (m *Monitor) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) error {
 	return nil
 }
 
+// updateBackoff backs off collecting if MySQL is struggling, or collects more
+// often again if it's not, and logs when that changes.  start is when SHOW
+// STATUS was run, and latency is how long it took.
+// @goroutine[2]
+func (m *Monitor) updateBackoff(start time.Time, latency time.Duration) {
+	reason := m.loadReason(start, latency)
+	if !m.backoff.Update(reason != "") {
+		return
+	}
+	every := m.backoff.Factor() * m.config.Collect
+	if reason != "" {
+		m.logger.Warn(fmt.Sprintf("MySQL is struggling (%s), collecting every %ds", reason, every))
+		m.status.Update(m.name+"-load", fmt.Sprintf("Backed off (%s), collecting every %ds", reason, every))
+	} else if m.backoff.Factor() > 1 {
+		m.logger.Info(fmt.Sprintf("MySQL load is lower, collecting every %ds", every))
+		m.status.Update(m.name+"-load", fmt.Sprintf("Recovering, collecting every %ds", every))
+	} else {
+		m.logger.Info(fmt.Sprintf("MySQL load is normal, collecting every %ds", every))
+		m.status.Update(m.name+"-load", fmt.Sprintf("Normal (collecting every %ds)", every))
+	}
+}
+
+// loadReason returns why MySQL is struggling, or "" if it's not: too many
+// threads running, our SHOW STATUS was slow, or Uptime stalled, i.e. didn't
+// keep up with wall time between collections because MySQL was too busy to
+// run the query when it was sent.
+func (m *Monitor) loadReason(start time.Time, latency time.Duration) string {
+	maxThreads := m.config.MaxThreadsRunning
+	if maxThreads == 0 {
+		maxThreads = DEFAULT_MAX_THREADS_RUNNING
+	}
+	maxLatency := time.Duration(m.config.MaxLatency) * time.Millisecond
+	if maxLatency == 0 {
+		maxLatency = DEFAULT_MAX_LATENCY * time.Millisecond
+	}
+
+	reason := ""
+	if m.threadsRunning >= float64(maxThreads) {
+		reason = fmt.Sprintf("Threads_running %.0f >= %d", m.threadsRunning, maxThreads)
+	} else if latency >= maxLatency {
+		reason = fmt.Sprintf("SHOW STATUS took %s >= %s", latency, maxLatency)
+	} else if m.uptime >= 0 && m.lastUptime >= 0 && m.uptime >= m.lastUptime {
+		// Uptime < last means MySQL restarted, which isn't a stall.
+		drift := (m.uptime - m.lastUptime) - start.Sub(m.lastUptimeTs).Seconds()
+		if drift >= UPTIME_STALL || drift <= -UPTIME_STALL {
+			reason = fmt.Sprintf("Uptime stalled %.0fs", drift)
+		}
+	}
+	m.lastUptime = m.uptime
+	m.lastUptimeTs = start
+	return reason
+}
+
+// showStatus runs SHOW GLOBAL STATUS with a statement prepared once per
+// connection, or without one if MySQL can't prepare it.
+func (m *Monitor) showStatus(conn *sql.DB) (*sql.Rows, error) {
+	const query = "SHOW /*!50002 GLOBAL */ STATUS"
+	if m.statusDB != conn {
+		m.closeStatusStmt()
+		stmt, err := conn.Prepare(query)
+		if err != nil {
+			m.logger.Debug("Cannot prepare " + query + ": " + err.Error())
+		}
+		m.statusStmt = stmt
+		m.statusDB = conn
+	}
+	if m.statusStmt == nil {
+		return conn.Query(query)
+	}
+	return m.statusStmt.Query()
+}
+
+func (m *Monitor) closeStatusStmt() {
+	if m.statusStmt != nil {
+		m.statusStmt.Close()
+	}
+	m.statusStmt = nil
+	m.statusDB = nil
+}
+
 // --------------------------------------------------------------------------
 // InnoDB Metrics
 // http://dev.mysql.com/doc/refman/5.6/en/innodb-metrics-table.html
@@ -430,6 +663,67 @@ func (m *Monitor) GetInnoDBMetrics(conn *sql.DB, c *mm.Collection) error {
 	return nil
 }
 
+// --------------------------------------------------------------------------
+// Binary logs
+// --------------------------------------------------------------------------
+
+// GetBinlogMetrics gets binlog metrics every BINLOG_INTERVAL because SHOW
+// BINARY LOGS can be slow with many binlogs and they change slowly.  Other
+// collections have the last values, so every report has the metrics.
+// @goroutine[2]
+func (m *Monitor) GetBinlogMetrics(conn *sql.DB, c *mm.Collection, now time.Time) error {
+	m.logger.Debug("GetBinlogMetrics:call")
+	defer m.logger.Debug("GetBinlogMetrics:return")
+
+	if m.binlogsTs.IsZero() || now.Sub(m.binlogsTs) >= BINLOG_INTERVAL*time.Second {
+		m.status.Update(m.name, "Getting binlog metrics")
+		b, err := mysql.GetBinlogs(conn, m.local)
+		if err != nil {
+			return err
+		}
+		m.binlogs = b
+		m.binlogsTs = now
+	}
+	b := m.binlogs
+	if b == nil {
+		return nil // binary logging is off
+	}
+	c.Metrics = append(c.Metrics,
+		mm.Metric{"mysql/binlog/files", "gauge", float64(b.Count), ""},
+		mm.Metric{"mysql/binlog/size", "gauge", float64(b.Size), ""},
+		mm.Metric{"mysql/binlog/expire_seconds", "gauge", float64(b.ExpireSeconds), ""},
+	)
+	if b.FsSize > 0 {
+		c.Metrics = append(c.Metrics,
+			mm.Metric{"mysql/binlog/fs_size", "gauge", float64(b.FsSize), ""},
+			mm.Metric{"mysql/binlog/fs_free", "gauge", float64(b.FsFree), ""},
+		)
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// Heartbeat (replication delay)
+// --------------------------------------------------------------------------
+
+// @goroutine[2]
+func (m *Monitor) GetHeartbeatMetrics(conn *sql.DB, c *mm.Collection, now time.Time) error {
+	m.logger.Debug("GetHeartbeatMetrics:call")
+	defer m.logger.Debug("GetHeartbeatMetrics:return")
+
+	m.status.Update(m.name, "Getting heartbeat metrics")
+
+	delay, err := heartbeat.Read(conn, m.config.Heartbeat, now)
+	if err == sql.ErrNoRows {
+		return nil // not a replica
+	}
+	if err != nil {
+		return err
+	}
+	c.Metrics = append(c.Metrics, mm.Metric{"mysql/heartbeat/delay", "gauge", delay.Seconds(), ""})
+	return nil
+}
+
 // --------------------------------------------------------------------------
 // User Statistics
 // http://www.percona.com/doc/percona-server/5.5/diagnostics/user_stats.html
@@ -0,0 +1,219 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/percona/percona-agent/mm"
+)
+
+// --------------------------------------------------------------------------
+// MyRocks (RocksDB storage engine)
+// https://github.com/facebook/mysql-5.6/wiki/MyRocks-Status
+// --------------------------------------------------------------------------
+
+// rocksdb_% status variables that are gauges; the rest are counters.
+var rocksdbGaugeRe = regexp.MustCompile(`^rocksdb_(memtable_(total|unflushed)|cur_size_|size_all_mem_tables|num_running_|num_immutable_mem_table|estimate_|block_cache_(pinned_)?usage|actual_delayed_write_rate|is_write_stopped|num_snapshots|oldest_snapshot_time)`)
+
+// haveRocksDB returns true if the RocksDB storage engine is enabled.
+func (m *Monitor) haveRocksDB() bool {
+	var support string
+	err := m.conn.DB().QueryRow("SELECT SUPPORT FROM information_schema.ENGINES WHERE ENGINE = 'ROCKSDB'").Scan(&support)
+	if err != nil && err != sql.ErrNoRows {
+		m.logger.Warn("Cannot detect RocksDB engine: ", err)
+	}
+	return support == "YES" || support == "DEFAULT"
+}
+
+// @goroutine[2]
+func (m *Monitor) GetRocksDBMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.logger.Debug("GetRocksDBMetrics:call")
+	defer m.logger.Debug("GetRocksDBMetrics:return")
+
+	m.status.Update(m.name, "Getting RocksDB metrics")
+
+	// rocksdb_% status variables, e.g. rocksdb_stall_total_stops
+	rows, err := conn.Query("SHOW GLOBAL STATUS LIKE 'rocksdb\\_%'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var statName, statValue string
+		if err := rows.Scan(&statName, &statValue); err != nil {
+			return err
+		}
+		statName = strings.ToLower(statName)
+		metricValue, err := strconv.ParseFloat(statValue, 64)
+		if err != nil {
+			continue // not a number
+		}
+		metricType := COUNTER
+		if rocksdbGaugeRe.MatchString(statName) {
+			metricType = GAUGE
+		}
+		if t, ok := m.config.Types[statName]; ok {
+			if t == STRING {
+				continue
+			}
+			metricType = t
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/rocksdb/" + strings.TrimPrefix(statName, "rocksdb_"), metricType, metricValue, ""})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// SHOW ENGINE ROCKSDB STATUS: compaction stats per column family and
+	// memory usage.
+	rows, err = conn.Query("SHOW ENGINE ROCKSDB STATUS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var statusType, name, status string
+		if err := rows.Scan(&statusType, &name, &status); err != nil {
+			return err
+		}
+		switch statusType {
+		case "CF_COMPACTION":
+			c.Metrics = append(c.Metrics, ParseRocksDBCompactionStats(name, status)...)
+		case "MEMORY_STATS":
+			c.Metrics = append(c.Metrics, ParseRocksDBMemoryStats(status)...)
+		}
+	}
+	return rows.Err()
+}
+
+var rocksdbUnits = map[string]float64{
+	"B":  1,
+	"KB": 1024,
+	"MB": 1024 * 1024,
+	"GB": 1024 * 1024 * 1024,
+	"TB": 1024 * 1024 * 1024 * 1024,
+}
+
+// Compaction Stats Sum columns collected.
+var rocksdbSumStats = []struct {
+	col        string
+	metric     string
+	metricType string
+}{
+	{"W-Amp", "write_amp", "gauge"},
+	{"Comp(sec)", "compaction_seconds", "counter"},
+	{"Comp(cnt)", "compactions", "counter"},
+}
+
+// ParseRocksDBCompactionStats parses the Compaction Stats table of a column
+// family in SHOW ENGINE ROCKSDB STATUS:
+//
+//	Level    Files   Size     Score Read(GB) ... W-Amp ... Comp(sec) Comp(cnt) ...
+//	---------------------------------------------------------------------------
+//	  L0      2/0    1.90 KB   0.5      0.0 ...   1.0 ...         0         2 ...
+//	 Sum      2/0    1.90 KB   0.0      0.0 ...   1.0 ...         0         2 ...
+//
+// Columns vary by version, so they're found by header.  Each level has files
+// and size (bytes) gauges, e.g. mysql/rocksdb/cf.default/l0/files, and the Sum
+// row has compaction counters, e.g. mysql/rocksdb/cf.default/compactions.
+func ParseRocksDBCompactionStats(cf, status string) []mm.Metric {
+	metrics := []mm.Metric{}
+	prefix := "mysql/rocksdb/cf." + cf + "/"
+	var header []string
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "Level" {
+			header = fields
+			continue
+		}
+		if header == nil || strings.HasPrefix(fields[0], "---") {
+			continue
+		}
+		if len(fields) == len(header)+1 {
+			// Size is value and unit, e.g. 1.90 KB.
+			fields = append([]string{fields[0], fields[1], fields[2] + " " + fields[3]}, fields[4:]...)
+		}
+		if len(fields) != len(header) {
+			header = nil // end of table
+			continue
+		}
+		level := strings.ToLower(fields[0])
+		if level != "sum" && !strings.HasPrefix(level, "l") {
+			continue // e.g. Int (interval) row
+		}
+		cols := make(map[string]string)
+		for i, h := range header {
+			cols[h] = fields[i]
+		}
+		if level == "sum" {
+			for _, s := range rocksdbSumStats {
+				if v, err := strconv.ParseFloat(cols[s.col], 64); err == nil {
+					metrics = append(metrics, mm.Metric{prefix + s.metric, s.metricType, v, ""})
+				}
+			}
+			continue
+		}
+		if files, err := strconv.ParseFloat(strings.SplitN(cols["Files"], "/", 2)[0], 64); err == nil {
+			metrics = append(metrics, mm.Metric{prefix + level + "/files", "gauge", files, ""})
+		}
+		if size, ok := rocksdbSize(cols["Size"]); ok {
+			metrics = append(metrics, mm.Metric{prefix + level + "/size", "gauge", size, ""})
+		}
+	}
+	return metrics
+}
+
+// rocksdbSize returns bytes of a size like 1.90 KB.
+func rocksdbSize(s string) (float64, bool) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(parts[0], 64)
+	unit, ok := rocksdbUnits[parts[1]]
+	if err != nil || !ok {
+		return 0, false
+	}
+	return v * unit, true
+}
+
+// ParseRocksDBMemoryStats parses the MEMORY_STATS of SHOW ENGINE ROCKSDB STATUS,
+// lines like "MemTable Total: 91344", as gauges like mysql/rocksdb/memory/memtable_total.
+func ParseRocksDBMemoryStats(status string) []mm.Metric {
+	metrics := []mm.Metric{}
+	for _, line := range strings.Split(status, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			continue
+		}
+		name := strings.ToLower(strings.Join(strings.Fields(parts[0]), "_"))
+		metrics = append(metrics, mm.Metric{"mysql/rocksdb/memory/" + name, "gauge", v, ""})
+	}
+	return metrics
+}
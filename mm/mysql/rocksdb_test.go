@@ -0,0 +1,69 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql_test
+
+import (
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/mysql"
+	. "gopkg.in/check.v1"
+)
+
+// Parsers only, no MySQL needed.
+type RocksDBTestSuite struct{}
+
+var _ = Suite(&RocksDBTestSuite{})
+
+func (s *RocksDBTestSuite) TestCompactionStats(t *C) {
+	status := `
+** Compaction Stats [default] **
+Level    Files   Size     Score Read(GB)  Rn(GB) Rnp1(GB) Write(GB) Wnew(GB) Moved(GB) W-Amp Rd(MB/s) Wr(MB/s) Comp(sec) Comp(cnt) Avg(sec) KeyIn KeyDrop
+----------------------------------------------------------------------------------------------------------------------------------------------------------
+  L0      2/0    1.90 KB   0.5      0.0     0.0      0.0       0.0      0.0       0.0   1.0      0.0      0.4         3         2    0.002       0      0
+  L6      1/0    2.50 MB   0.0      0.1     0.0      0.1       0.1      0.0       0.0   2.5      1.2      1.1        12         4    3.000     100     10
+ Sum      3/0    2.50 MB   0.0      0.1     0.0      0.1       0.1      0.0       0.0   3.5      1.2      1.5        15         6    2.500     100     10
+ Int      0/0    0.00 KB   0.0      0.0     0.0      0.0       0.0      0.0       0.0   0.0      0.0      0.0         0         0    0.000       0      0
+Uptime(secs): 1234.5 total, 60.0 interval
+Flush(GB): cumulative 0.000, interval 0.000
+`
+	got := mysql.ParseRocksDBCompactionStats("default", status)
+	expect := []mm.Metric{
+		{"mysql/rocksdb/cf.default/l0/files", "gauge", 2, ""},
+		{"mysql/rocksdb/cf.default/l0/size", "gauge", 1.90 * 1024, ""},
+		{"mysql/rocksdb/cf.default/l6/files", "gauge", 1, ""},
+		{"mysql/rocksdb/cf.default/l6/size", "gauge", 2.50 * 1024 * 1024, ""},
+		{"mysql/rocksdb/cf.default/write_amp", "gauge", 3.5, ""},
+		{"mysql/rocksdb/cf.default/compaction_seconds", "counter", 15, ""},
+		{"mysql/rocksdb/cf.default/compactions", "counter", 6, ""},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	t.Check(mysql.ParseRocksDBCompactionStats("default", ""), HasLen, 0)
+}
+
+func (s *RocksDBTestSuite) TestMemoryStats(t *C) {
+	status := "\nMemTable Total: 91344\nMemTable Unflushed: 512\nTable Readers Total: 0\nCache Total: 8388608\nDefault Cache Capacity: 0\n"
+	got := mysql.ParseRocksDBMemoryStats(status)
+	expect := []mm.Metric{
+		{"mysql/rocksdb/memory/memtable_total", "gauge", 91344, ""},
+		{"mysql/rocksdb/memory/memtable_unflushed", "gauge", 512, ""},
+		{"mysql/rocksdb/memory/table_readers_total", "gauge", 0, ""},
+		{"mysql/rocksdb/memory/cache_total", "gauge", 8388608, ""},
+		{"mysql/rocksdb/memory/default_cache_capacity", "gauge", 0, ""},
+	}
+	t.Check(got, DeepEquals, expect)
+}
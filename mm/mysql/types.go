@@ -0,0 +1,204 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Metric types of status variables.  Counters only increase (until MySQL
+// restarts) so their rate is reported; gauges go up and down so their value
+// is reported.  Strings, e.g. Slave_running=ON, aren't numbers so they're not
+// collected.
+const (
+	COUNTER = "counter"
+	GAUGE   = "gauge"
+	STRING  = "string"
+)
+
+// StatusTypes is the metric type of known SHOW GLOBAL STATUS variables,
+// lowercase.  It takes precedence over the type in Config.Status, which is
+// often wrong for variables that aren't obviously counters or gauges, e.g.
+// a counter like Innodb_row_lock_time reported as a gauge, or a gauge like
+// Innodb_row_lock_current_waits reported as a counter, which produces garbage
+// rates.  Config.Types overrides it.
+var StatusTypes = map[string]string{
+	"aborted_clients":                       COUNTER,
+	"aborted_connects":                      COUNTER,
+	"binlog_cache_disk_use":                 COUNTER,
+	"binlog_cache_use":                      COUNTER,
+	"binlog_stmt_cache_disk_use":            COUNTER,
+	"binlog_stmt_cache_use":                 COUNTER,
+	"bytes_received":                        COUNTER,
+	"bytes_sent":                            COUNTER,
+	"compression":                           STRING,
+	"connection_errors_internal":            COUNTER,
+	"connection_errors_max_connections":     COUNTER,
+	"connections":                           COUNTER,
+	"flush_commands":                        COUNTER,
+	"innodb_buffer_pool_bytes_data":         GAUGE,
+	"innodb_buffer_pool_bytes_dirty":        GAUGE,
+	"innodb_buffer_pool_dump_status":        STRING,
+	"innodb_buffer_pool_load_status":        STRING,
+	"innodb_buffer_pool_pages_data":         GAUGE,
+	"innodb_buffer_pool_pages_dirty":        GAUGE,
+	"innodb_buffer_pool_pages_flushed":      COUNTER,
+	"innodb_buffer_pool_pages_free":         GAUGE,
+	"innodb_buffer_pool_pages_misc":         GAUGE,
+	"innodb_buffer_pool_pages_total":        GAUGE,
+	"innodb_buffer_pool_read_ahead":         COUNTER,
+	"innodb_buffer_pool_read_ahead_evicted": COUNTER,
+	"innodb_buffer_pool_read_requests":      COUNTER,
+	"innodb_buffer_pool_reads":              COUNTER,
+	"innodb_buffer_pool_wait_free":          COUNTER,
+	"innodb_buffer_pool_write_requests":     COUNTER,
+	"innodb_checkpoint_age":                 GAUGE,
+	"innodb_data_fsyncs":                    COUNTER,
+	"innodb_data_pending_fsyncs":            GAUGE,
+	"innodb_data_pending_reads":             GAUGE,
+	"innodb_data_pending_writes":            GAUGE,
+	"innodb_data_read":                      COUNTER,
+	"innodb_data_reads":                     COUNTER,
+	"innodb_data_writes":                    COUNTER,
+	"innodb_data_written":                   COUNTER,
+	"innodb_dblwr_pages_written":            COUNTER,
+	"innodb_dblwr_writes":                   COUNTER,
+	"innodb_history_list_length":            GAUGE,
+	"innodb_log_waits":                      COUNTER,
+	"innodb_log_write_requests":             COUNTER,
+	"innodb_log_writes":                     COUNTER,
+	"innodb_os_log_fsyncs":                  COUNTER,
+	"innodb_os_log_pending_fsyncs":          GAUGE,
+	"innodb_os_log_pending_writes":          GAUGE,
+	"innodb_os_log_written":                 COUNTER,
+	"innodb_page_size":                      GAUGE,
+	"innodb_pages_created":                  COUNTER,
+	"innodb_pages_read":                     COUNTER,
+	"innodb_pages_written":                  COUNTER,
+	"innodb_row_lock_current_waits":         GAUGE,
+	"innodb_row_lock_time":                  COUNTER,
+	"innodb_row_lock_time_avg":              GAUGE,
+	"innodb_row_lock_time_max":              GAUGE,
+	"innodb_row_lock_waits":                 COUNTER,
+	"innodb_rows_deleted":                   COUNTER,
+	"innodb_rows_inserted":                  COUNTER,
+	"innodb_rows_read":                      COUNTER,
+	"innodb_rows_updated":                   COUNTER,
+	"key_blocks_not_flushed":                GAUGE,
+	"key_blocks_unused":                     GAUGE,
+	"key_blocks_used":                       GAUGE,
+	"key_read_requests":                     COUNTER,
+	"key_reads":                             COUNTER,
+	"key_write_requests":                    COUNTER,
+	"key_writes":                            COUNTER,
+	"last_query_cost":                       GAUGE,
+	"max_used_connections":                  GAUGE,
+	"not_flushed_delayed_rows":              GAUGE,
+	"open_files":                            GAUGE,
+	"open_streams":                          GAUGE,
+	"open_table_definitions":                GAUGE,
+	"open_tables":                           GAUGE,
+	"opened_files":                          COUNTER,
+	"opened_table_definitions":              COUNTER,
+	"opened_tables":                         COUNTER,
+	"prepared_stmt_count":                   GAUGE,
+	"qcache_free_blocks":                    GAUGE,
+	"qcache_free_memory":                    GAUGE,
+	"qcache_hits":                           COUNTER,
+	"qcache_inserts":                        COUNTER,
+	"qcache_lowmem_prunes":                  COUNTER,
+	"qcache_not_cached":                     COUNTER,
+	"qcache_queries_in_cache":               GAUGE,
+	"qcache_total_blocks":                   GAUGE,
+	"queries":                               COUNTER,
+	"questions":                             COUNTER,
+	"rpl_semi_sync_master_clients":          GAUGE,
+	"rpl_semi_sync_master_status":           STRING,
+	"rpl_semi_sync_slave_status":            STRING,
+	"slave_open_temp_tables":                GAUGE,
+	"slave_retried_transactions":            COUNTER,
+	"slave_running":                         STRING,
+	"slow_launch_threads":                   COUNTER,
+	"slow_queries":                          COUNTER,
+	"ssl_cipher":                            STRING,
+	"ssl_version":                           STRING,
+	"table_locks_immediate":                 COUNTER,
+	"table_locks_waited":                    COUNTER,
+	"table_open_cache_hits":                 COUNTER,
+	"table_open_cache_misses":               COUNTER,
+	"table_open_cache_overflows":            COUNTER,
+	"tc_log_page_waits":                     COUNTER,
+	"threads_cached":                        GAUGE,
+	"threads_connected":                     GAUGE,
+	"threads_created":                       COUNTER,
+	"threads_running":                       GAUGE,
+	"uptime":                                COUNTER,
+	"uptime_since_flush_status":             COUNTER,
+	"wsrep_cluster_size":                    GAUGE,
+	"wsrep_cluster_status":                  STRING,
+	"wsrep_local_recv_queue":                GAUGE,
+	"wsrep_local_send_queue":                GAUGE,
+	"wsrep_local_state":                     GAUGE,
+	"wsrep_local_state_comment":             STRING,
+	"wsrep_ready":                           STRING,
+}
+
+// Families of status variables that are all the same type, e.g. Com_select.
+var statusTypePrefixes = []struct {
+	prefix     string
+	metricType string
+}{
+	{"com_", COUNTER},
+	{"created_tmp_", COUNTER},
+	{"handler_", COUNTER},
+	{"performance_schema_", COUNTER}, // *_lost
+	{"select_", COUNTER},
+	{"sort_", COUNTER},
+}
+
+// StatusType returns the metric type of the status variable: its type in
+// overrides (Config.Types) if set, else its known type, else def (its type in
+// Config.Status).  The name must be lowercase.
+func StatusType(name string, overrides map[string]string, def string) string {
+	if metricType, ok := overrides[name]; ok {
+		return metricType
+	}
+	if metricType, ok := StatusTypes[name]; ok {
+		return metricType
+	}
+	for _, p := range statusTypePrefixes {
+		if strings.HasPrefix(name, p.prefix) {
+			return p.metricType
+		}
+	}
+	return def
+}
+
+// ValidateTypes returns an error if a type in Config.Types is not COUNTER,
+// GAUGE, or STRING.
+func ValidateTypes(types map[string]string) error {
+	for name, metricType := range types {
+		switch metricType {
+		case COUNTER, GAUGE, STRING:
+		default:
+			return fmt.Errorf("Invalid metric type for %s: %s; expected %s, %s, or %s", name, metricType, COUNTER, GAUGE, STRING)
+		}
+	}
+	return nil
+}
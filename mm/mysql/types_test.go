@@ -0,0 +1,57 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql_test
+
+import (
+	"github.com/percona/percona-agent/mm/mysql"
+	. "gopkg.in/check.v1"
+)
+
+type TypesTestSuite struct{}
+
+var _ = Suite(&TypesTestSuite{})
+
+func (s *TypesTestSuite) TestStatusType(t *C) {
+	// Known types take precedence over the type in Config.Status.
+	t.Check(mysql.StatusType("innodb_row_lock_time", nil, "gauge"), Equals, mysql.COUNTER)
+	t.Check(mysql.StatusType("innodb_row_lock_current_waits", nil, "counter"), Equals, mysql.GAUGE)
+	t.Check(mysql.StatusType("slave_running", nil, "gauge"), Equals, mysql.STRING)
+
+	// Families of variables.
+	t.Check(mysql.StatusType("com_select", nil, "gauge"), Equals, mysql.COUNTER)
+	t.Check(mysql.StatusType("handler_read_rnd_next", nil, ""), Equals, mysql.COUNTER)
+
+	// Unknown variables are the type in Config.Status.
+	t.Check(mysql.StatusType("foo_bar", nil, "gauge"), Equals, mysql.GAUGE)
+
+	// Config.Types overrides all.
+	types := map[string]string{
+		"innodb_row_lock_time": mysql.GAUGE,
+		"com_select":           mysql.GAUGE,
+		"foo_bar":              mysql.COUNTER,
+	}
+	t.Check(mysql.StatusType("innodb_row_lock_time", types, "counter"), Equals, mysql.GAUGE)
+	t.Check(mysql.StatusType("com_select", types, "counter"), Equals, mysql.GAUGE)
+	t.Check(mysql.StatusType("foo_bar", types, "gauge"), Equals, mysql.COUNTER)
+}
+
+func (s *TypesTestSuite) TestValidateTypes(t *C) {
+	t.Check(mysql.ValidateTypes(nil), IsNil)
+	t.Check(mysql.ValidateTypes(map[string]string{"a": "counter", "b": "gauge", "c": "string"}), IsNil)
+	t.Check(mysql.ValidateTypes(map[string]string{"a": "rate"}), ErrorMatches, "Invalid metric type for a: rate.*")
+}
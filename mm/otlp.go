@@ -0,0 +1,315 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	OTLP_METRICS_PATH     = "/v1/metrics"
+	OTLP_DEFAULT_TIMEOUT  = 10 // seconds
+	OTLP_BUFFER_SIZE      = 10 // reports
+	OTLP_SCOPE            = "percona-agent/mm"
+	OTLP_SERVICE_NAME     = "percona-agent"
+	OTLP_CONTENT_TYPE     = "application/json"
+	OTLP_ATTR_SERVICE     = "percona.service"
+	OTLP_ATTR_INSTANCE_ID = "percona.instance.id"
+	OTLP_ATTR_INSTANCE    = "percona.instance"
+)
+
+// OTLPExporter pushes reports to an OpenTelemetry collector as OTLP/HTTP JSON.
+// Each instance is a resource (service.name=percona-agent, host.name, and the
+// percona.* instance attributes) and each metric is a summary of the interval:
+// count, sum, and min, p5, median, p95, max as quantiles.  Metric names have
+// dots instead of slashes, e.g. mysql.status.Threads_running.
+type OTLPExporter struct {
+	logger *pct.Logger
+	name   func(service string, instanceId uint) string // e.g. instance.Repo.Name
+	// --
+	config     *ExportConfig
+	client     *http.Client
+	hostname   string
+	reportChan chan *Report
+	sync       *pct.SyncChan
+	running    bool
+	mux        *sync.Mutex // guards config, client, reportChan, and running
+	status     *pct.Status
+}
+
+func NewOTLPExporter(logger *pct.Logger, name func(service string, instanceId uint) string) *OTLPExporter {
+	hostname, _ := os.Hostname()
+	e := &OTLPExporter{
+		logger: logger,
+		name:   name,
+		// --
+		hostname: hostname,
+		mux:      &sync.Mutex{},
+		status:   pct.NewStatus([]string{"mm-otlp"}),
+	}
+	return e
+}
+
+// @goroutine[0]
+func (e *OTLPExporter) Start(config *ExportConfig) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.running {
+		return pct.ServiceIsRunningError{Service: "mm-otlp"}
+	}
+	if !strings.HasPrefix(config.OTLPEndpoint, "http://") && !strings.HasPrefix(config.OTLPEndpoint, "https://") {
+		return fmt.Errorf("Invalid OTLPEndpoint: %s: expected http:// or https:// URL", config.OTLPEndpoint)
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = OTLP_DEFAULT_TIMEOUT
+	}
+	e.config = config
+	e.client = &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{Proxy: pct.Proxy, TLSClientConfig: pct.TLSConfig()},
+	}
+	e.reportChan = make(chan *Report, OTLP_BUFFER_SIZE)
+	e.sync = pct.NewSyncChan()
+	go e.run(e.reportChan)
+	e.running = true
+	e.logger.Info("Started exporting to " + config.OTLPEndpoint)
+	return nil
+}
+
+// @goroutine[0]
+func (e *OTLPExporter) Stop() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if !e.running {
+		return
+	}
+	e.sync.Stop()
+	e.sync.Wait()
+	e.running = false
+	e.logger.Info("Stopped exporting")
+}
+
+// Export queues the report to be pushed, or drops it if the exporter isn't
+// running or the collector is too slow and the queue is full.
+// @goroutine[1] (aggregator)
+func (e *OTLPExporter) Export(report *Report) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if !e.running {
+		return
+	}
+	select {
+	case e.reportChan <- report:
+	default:
+		e.logger.Warn("Lost OTLP export of", report.Ts, ": queue is full")
+	}
+}
+
+func (e *OTLPExporter) Status() map[string]string {
+	return e.status.All()
+}
+
+// @goroutine[2]
+func (e *OTLPExporter) run(reportChan chan *Report) {
+	defer func() {
+		if err := recover(); err != nil {
+			e.logger.Error("OTLP exporter crashed: ", err)
+		}
+		e.status.Update("mm-otlp", "Stopped")
+		e.sync.Done()
+	}()
+	for {
+		e.status.Update("mm-otlp", "Idle")
+		select {
+		case report := <-reportChan:
+			e.status.Update("mm-otlp", "Exporting "+report.Ts.String())
+			if err := e.push(report); err != nil {
+				e.logger.Warn("Lost OTLP export of", report.Ts, ":", err)
+			}
+		case <-e.sync.StopChan:
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) push(report *Report) error {
+	data, err := json.Marshal(e.Metrics(report))
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(e.config.OTLPEndpoint, "/") + OTLP_METRICS_PATH
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", OTLP_CONTENT_TYPE)
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP status %d, expected 200", url, resp.StatusCode)
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// OTLP JSON encoding, see opentelemetry-proto metrics/v1/metrics.proto
+/////////////////////////////////////////////////////////////////////////////
+
+type OTLPMetricsData struct {
+	ResourceMetrics []OTLPResourceMetrics `json:"resourceMetrics"`
+}
+
+type OTLPResourceMetrics struct {
+	Resource     OTLPResource       `json:"resource"`
+	ScopeMetrics []OTLPScopeMetrics `json:"scopeMetrics"`
+}
+
+type OTLPResource struct {
+	Attributes []OTLPKeyValue `json:"attributes"`
+}
+
+type OTLPKeyValue struct {
+	Key   string       `json:"key"`
+	Value OTLPAnyValue `json:"value"`
+}
+
+type OTLPAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type OTLPScopeMetrics struct {
+	Scope   OTLPScope    `json:"scope"`
+	Metrics []OTLPMetric `json:"metrics"`
+}
+
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+type OTLPMetric struct {
+	Name    string      `json:"name"`
+	Summary OTLPSummary `json:"summary"`
+}
+
+type OTLPSummary struct {
+	DataPoints []OTLPSummaryDataPoint `json:"dataPoints"`
+}
+
+// Times and count are strings because they're 64-bit integers in OTLP JSON.
+type OTLPSummaryDataPoint struct {
+	StartTimeUnixNano string              `json:"startTimeUnixNano"`
+	TimeUnixNano      string              `json:"timeUnixNano"`
+	Count             string              `json:"count"`
+	Sum               float64             `json:"sum"`
+	QuantileValues    []OTLPQuantileValue `json:"quantileValues"`
+}
+
+type OTLPQuantileValue struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Metrics returns the report as an OTLP ExportMetricsServiceRequest.
+func (e *OTLPExporter) Metrics(report *Report) *OTLPMetricsData {
+	start := strconv.FormatInt(report.Ts.UnixNano(), 10)
+	end := strconv.FormatInt(report.Ts.Add(time.Duration(report.Duration)*time.Second).UnixNano(), 10)
+	data := &OTLPMetricsData{ResourceMetrics: []OTLPResourceMetrics{}}
+	for _, is := range report.Stats {
+		names := make([]string, 0, len(is.Stats))
+		for name := range is.Stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		metrics := make([]OTLPMetric, len(names))
+		for i, name := range names {
+			stats := is.Stats[name]
+			metrics[i] = OTLPMetric{
+				Name: strings.Replace(name, "/", ".", -1),
+				Summary: OTLPSummary{DataPoints: []OTLPSummaryDataPoint{{
+					StartTimeUnixNano: start,
+					TimeUnixNano:      end,
+					Count:             strconv.Itoa(stats.Cnt),
+					Sum:               stats.Avg * float64(stats.Cnt),
+					QuantileValues: []OTLPQuantileValue{
+						{0, stats.Min},
+						{0.05, stats.Pct5},
+						{0.5, stats.Med},
+						{0.95, stats.Pct95},
+						{1, stats.Max},
+					},
+				}}},
+			}
+		}
+		data.ResourceMetrics = append(data.ResourceMetrics, OTLPResourceMetrics{
+			Resource: OTLPResource{Attributes: e.attributes(is.Service, is.InstanceId)},
+			ScopeMetrics: []OTLPScopeMetrics{{
+				Scope:   OTLPScope{Name: OTLP_SCOPE},
+				Metrics: metrics,
+			}},
+		})
+	}
+	return data
+}
+
+func (e *OTLPExporter) attributes(service string, instanceId uint) []OTLPKeyValue {
+	attrs := map[string]string{
+		"service.name":        OTLP_SERVICE_NAME,
+		"host.name":           e.hostname,
+		OTLP_ATTR_SERVICE:     service,
+		OTLP_ATTR_INSTANCE_ID: strconv.FormatUint(uint64(instanceId), 10),
+		OTLP_ATTR_INSTANCE:    e.name(service, instanceId),
+	}
+	if e.config != nil {
+		for k, v := range e.config.Attributes {
+			if _, ok := attrs[k]; !ok {
+				attrs[k] = v // agent and instance attributes can't be overridden
+			}
+		}
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := make([]OTLPKeyValue, len(keys))
+	for i, k := range keys {
+		kv[i] = OTLPKeyValue{k, OTLPAnyValue{attrs[k]}}
+	}
+	return kv
+}
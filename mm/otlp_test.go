@@ -0,0 +1,158 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+type OTLPTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+	server  *httptest.Server
+	reqChan chan *http.Request
+	bodies  chan []byte
+	report  *mm.Report
+}
+
+var _ = Suite(&OTLPTestSuite{})
+
+func (s *OTLPTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "mm-otlp")
+	s.reqChan = make(chan *http.Request, 1)
+	s.bodies = make(chan []byte, 1)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.reqChan <- r
+		s.bodies <- body
+	}))
+	s.report = &mm.Report{
+		Ts:       time.Unix(1400000000, 0).UTC(),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+				Stats: map[string]*mm.Stats{
+					"mysql/status/Threads_running": {Cnt: 4, Min: 1, Pct5: 1, Avg: 2.5, Med: 2, Pct95: 4, Max: 4},
+					"mysql/status/Com_select":      {Cnt: 2, Min: 10, Pct5: 10, Avg: 15, Med: 10, Pct95: 20, Max: 20},
+				},
+			},
+		},
+	}
+}
+
+func (s *OTLPTestSuite) TearDownSuite(t *C) {
+	s.server.Close()
+}
+
+func instanceName(service string, instanceId uint) string {
+	return fmt.Sprintf("%s-%d", service, instanceId)
+}
+
+// --------------------------------------------------------------------------
+
+func (s *OTLPTestSuite) TestMetrics(t *C) {
+	e := mm.NewOTLPExporter(s.logger, instanceName)
+	data := e.Metrics(s.report)
+	t.Assert(data.ResourceMetrics, HasLen, 1)
+
+	attrs := map[string]string{}
+	for _, kv := range data.ResourceMetrics[0].Resource.Attributes {
+		attrs[kv.Key] = kv.Value.StringValue
+	}
+	t.Check(attrs["service.name"], Equals, "percona-agent")
+	t.Check(attrs[mm.OTLP_ATTR_SERVICE], Equals, "mysql")
+	t.Check(attrs[mm.OTLP_ATTR_INSTANCE_ID], Equals, "1")
+	t.Check(attrs[mm.OTLP_ATTR_INSTANCE], Equals, "mysql-1")
+
+	metrics := data.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	t.Assert(metrics, HasLen, 2)
+	t.Check(metrics[0].Name, Equals, "mysql.status.Com_select")
+	t.Check(metrics[1].Name, Equals, "mysql.status.Threads_running")
+	dp := metrics[1].Summary.DataPoints[0]
+	t.Check(dp.StartTimeUnixNano, Equals, "1400000000000000000")
+	t.Check(dp.TimeUnixNano, Equals, "1400000060000000000")
+	t.Check(dp.Count, Equals, "4")
+	t.Check(dp.Sum, Equals, float64(10))
+	t.Check(dp.QuantileValues, DeepEquals, []mm.OTLPQuantileValue{
+		{0, 1},
+		{0.05, 1},
+		{0.5, 2},
+		{0.95, 4},
+		{1, 4},
+	})
+}
+
+func (s *OTLPTestSuite) TestExport(t *C) {
+	e := mm.NewOTLPExporter(s.logger, instanceName)
+
+	// Not running, so report is dropped.
+	e.Export(s.report)
+
+	config := &mm.ExportConfig{
+		OTLPEndpoint: s.server.URL + "/",
+		Headers:      map[string]string{"Authorization": "Bearer token"},
+		Attributes:   map[string]string{"deployment.environment": "prod", "service.name": "nope"},
+	}
+	err := e.Start(config)
+	t.Assert(err, IsNil)
+	defer e.Stop()
+
+	e.Export(s.report)
+	var req *http.Request
+	select {
+	case req = <-s.reqChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for OTLP export")
+	}
+	t.Check(req.Method, Equals, "POST")
+	t.Check(req.URL.Path, Equals, mm.OTLP_METRICS_PATH)
+	t.Check(req.Header.Get("Content-Type"), Equals, "application/json")
+	t.Check(req.Header.Get("Authorization"), Equals, "Bearer token")
+
+	data := &mm.OTLPMetricsData{}
+	err = json.Unmarshal(<-s.bodies, data)
+	t.Assert(err, IsNil)
+	t.Assert(data.ResourceMetrics, HasLen, 1)
+	attrs := map[string]string{}
+	for _, kv := range data.ResourceMetrics[0].Resource.Attributes {
+		attrs[kv.Key] = kv.Value.StringValue
+	}
+	t.Check(attrs["deployment.environment"], Equals, "prod")
+	t.Check(attrs["service.name"], Equals, "percona-agent")
+
+	err = e.Start(config)
+	t.Check(err, FitsTypeOf, pct.ServiceIsRunningError{})
+}
+
+func (s *OTLPTestSuite) TestBadEndpoint(t *C) {
+	e := mm.NewOTLPExporter(s.logger, instanceName)
+	err := e.Start(&mm.ExportConfig{OTLPEndpoint: "collector:4318"})
+	t.Check(err, NotNil)
+}
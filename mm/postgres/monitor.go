@@ -0,0 +1,364 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/postgres"
+)
+
+// Monitor is an mm monitor that collects PostgreSQL statistics from
+// pg_stat_database and pg_stat_bgwriter and, optionally, replication metrics,
+// like the MySQL monitor collects SHOW STATUS.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   postgres.Connector
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	connectedChan  chan bool
+	status         *pct.Status
+	sync           *pct.SyncChan
+	running        bool
+	collectLimit   float64
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn postgres.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		connectedChan: make(chan bool, 1),
+		status:        pct.NewStatus([]string{name, name + "-postgres"}),
+		sync:          pct.NewSyncChan(),
+		collectLimit:  float64(config.Collect) * 0.1, // 10% of Collect time
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	m.logger.Debug("Start:call")
+	defer m.logger.Debug("Start:return")
+
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	m.logger.Debug("Stop:call")
+	defer m.logger.Debug("Stop:return")
+
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.running = false
+	m.logger.Info("Stopped")
+
+	// Do not update status to "Stopped" here; run() does that on return.
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// run:@goroutine[3]
+func (m *Monitor) connect(err error) {
+	m.logger.Debug("connect:call")
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("PostgreSQL connection crashed: ", err)
+		}
+		m.logger.Debug("connect:return")
+	}()
+
+	// Close/release previous connection, if any.
+	m.conn.Close()
+
+	// Try forever to connect to PostgreSQL...
+	for {
+		m.logger.Debug("connect:try")
+		if err != nil {
+			m.status.Update(m.name+"-postgres", fmt.Sprintf("Connecting (%s)", err))
+		} else {
+			m.status.Update(m.name+"-postgres", "Connecting")
+		}
+		if err = m.conn.Connect(1); err != nil {
+			m.logger.Warn(err)
+			continue
+		}
+		m.logger.Info("Connected")
+		m.status.Update(m.name+"-postgres", "Connected")
+
+		// Tell run() goroutine that it can try to collect metrics.
+		// If connection is lost, it will call us again.
+		m.connectedChan <- true
+		return
+	}
+}
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	m.logger.Debug("run:call")
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("PostgreSQL monitor crashed: ", err)
+		}
+		m.conn.Close()
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+		m.logger.Debug("run:return")
+	}()
+
+	connected := false
+	go m.connect(nil)
+
+	m.status.Update(m.name, "Ready")
+
+	var lastTs int64
+	var lastError string
+	for {
+		t := time.Unix(lastTs, 0)
+		if lastError == "" {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
+		}
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			if !connected {
+				m.logger.Debug("run:collect:disconnected")
+				lastError = "Not connected to PostgreSQL"
+				continue
+			}
+			m.status.Update(m.name, "Running")
+
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:      now.UTC().Unix(),
+				Metrics: []mm.Metric{},
+			}
+
+			// Start timing the collection.  If must take < collectLimit else
+			// it's discarded.
+			start := time.Now()
+			conn := m.conn.DB()
+
+			if err := conn.Ping(); err != nil {
+				lastError = fmt.Sprintf("Lost connection to PostgreSQL: %s", err)
+				m.logger.Warn(lastError)
+				connected = false
+				go m.connect(err)
+				continue
+			}
+
+			// SELECT * FROM pg_stat_database
+			if len(m.config.Database) > 0 {
+				if err := m.getStatMetrics(conn, "pg_stat_database", "postgres/database/", m.config.Database, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// SELECT * FROM pg_stat_bgwriter
+			if len(m.config.Bgwriter) > 0 {
+				if err := m.getStatMetrics(conn, "pg_stat_bgwriter", "postgres/bgwriter/", m.config.Bgwriter, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			if m.config.Replication {
+				if err := m.getReplicationMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			diff := time.Now().Sub(start).Seconds()
+			if diff >= m.collectLimit {
+				lastError = fmt.Sprintf("Skipping interval because it took too long to collect: %.2fs >= %.2fs", diff, m.collectLimit)
+				m.logger.Warn(lastError)
+				continue
+			}
+
+			// Send the metrics to an mm.Aggregator.
+			m.status.Update(m.name, "Sending metrics")
+			if len(c.Metrics) > 0 {
+				select {
+				case m.collectionChan <- c:
+					lastTs = c.Ts
+					lastError = ""
+				case <-time.After(500 * time.Millisecond):
+					// lost collection
+					m.logger.Debug("Lost PostgreSQL metrics; timeout spooling after 500ms")
+					lastError = "Spool timeout"
+				}
+			} else {
+				m.logger.Debug("run:no metrics")
+				lastError = "No metrics"
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case connected = <-m.connectedChan:
+			m.logger.Debug("run:connected:true")
+			m.status.Update(m.name, "Ready")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+func (m *Monitor) getStatMetrics(conn *sql.DB, view, prefix string, metrics map[string]string, c *mm.Collection) error {
+	m.status.Update(m.name, "Getting "+view+" metrics")
+	rows, err := conn.Query("SELECT /* percona-agent */ * FROM " + view)
+	if err != nil {
+		return fmt.Errorf("%s: %s", view, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("%s: %s", view, err)
+	}
+	values := [][]string{}
+	for rows.Next() {
+		raw := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("%s: %s", view, err)
+		}
+		row := make([]string, len(columns))
+		for i := range raw {
+			row[i] = raw[i].String // "" if NULL
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%s: %s", view, err)
+	}
+	c.Metrics = append(c.Metrics, SumColumns(prefix, columns, values, metrics)...)
+	return nil
+}
+
+func (m *Monitor) getReplicationMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.status.Update(m.name, "Getting replication metrics")
+	var standby bool
+	if err := conn.QueryRow("SELECT /* percona-agent */ pg_is_in_recovery()").Scan(&standby); err != nil {
+		return fmt.Errorf("pg_is_in_recovery: %s", err)
+	}
+	if standby {
+		// Seconds since the last replayed transaction; 0 if none yet.
+		var lag float64
+		err := conn.QueryRow("SELECT /* percona-agent */ COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)").Scan(&lag)
+		if err != nil {
+			return fmt.Errorf("pg_last_xact_replay_timestamp: %s", err)
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"postgres/replication/lag", "gauge", lag, ""})
+		return nil
+	}
+	var standbys float64
+	if err := conn.QueryRow("SELECT /* percona-agent */ COUNT(*) FROM pg_stat_replication").Scan(&standbys); err != nil {
+		return fmt.Errorf("pg_stat_replication: %s", err)
+	}
+	c.Metrics = append(c.Metrics, mm.Metric{"postgres/replication/standbys", "gauge", standbys, ""})
+	return nil
+}
+
+// SumColumns returns the metrics for the columns, each summed over all rows,
+// e.g. xact_commit of all databases in pg_stat_database.  Empty (NULL) and
+// non-numeric values are skipped, so a column with none isn't a metric.
+func SumColumns(prefix string, columns []string, values [][]string, metrics map[string]string) []mm.Metric {
+	collected := []mm.Metric{}
+	for i, column := range columns {
+		metricType, ok := metrics[column]
+		if !ok {
+			continue // not collecting this column
+		}
+		sum := 0.0
+		n := 0
+		for _, row := range values {
+			if i >= len(row) || row[i] == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(row[i], 64)
+			if err != nil {
+				continue
+			}
+			sum += v
+			n++
+		}
+		if n > 0 {
+			collected = append(collected, mm.Metric{prefix + column, metricType, sum, ""})
+		}
+	}
+	return collected
+}
@@ -0,0 +1,57 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/postgres"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type PostgresTestSuite struct {
+}
+
+var _ = Suite(&PostgresTestSuite{})
+
+func (s *PostgresTestSuite) TestSumColumns(t *C) {
+	// SELECT datname, numbackends, xact_commit, blks_hit, stats_reset FROM pg_stat_database
+	columns := []string{"datname", "numbackends", "xact_commit", "blks_hit", "stats_reset"}
+	values := [][]string{
+		{"template0", "0", "0", "0", ""},
+		{"postgres", "2", "1500", "30000", "2014-11-20 10:00:00+00"},
+		{"app", "10", "98500", "", "2014-11-20 10:00:00+00"},
+	}
+	metrics := map[string]string{
+		"numbackends": "gauge",
+		"xact_commit": "counter",
+		"blks_hit":    "counter",
+		"stats_reset": "gauge",   // not a number
+		"conflicts":   "counter", // not a column
+	}
+	got := postgres.SumColumns("postgres/database/", columns, values, metrics)
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"postgres/database/numbackends", "gauge", 12, ""},
+		{"postgres/database/xact_commit", "counter", 100000, ""},
+		{"postgres/database/blks_hit", "counter", 30000, ""},
+	})
+}
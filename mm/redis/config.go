@@ -0,0 +1,48 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package redis
+
+import (
+	"github.com/percona/percona-agent/mm"
+)
+
+type Config struct {
+	mm.Config
+	Info map[string]string // INFO values to collect, e.g. connected_clients: gauge, default DEFAULT_INFO
+}
+
+// INFO values collected if Config.Info is empty.  Keys per db, e.g. db0 keys,
+// are always collected.
+var DEFAULT_INFO = map[string]string{
+	"connected_clients":           "gauge",
+	"blocked_clients":             "gauge",
+	"used_memory":                 "gauge",
+	"used_memory_rss":             "gauge",
+	"mem_fragmentation_ratio":     "gauge",
+	"total_connections_received":  "counter",
+	"total_commands_processed":    "counter",
+	"rejected_connections":        "counter",
+	"expired_keys":                "counter",
+	"evicted_keys":                "counter",
+	"keyspace_hits":               "counter",
+	"keyspace_misses":             "counter",
+	"connected_slaves":            "gauge",
+	"master_link_status":          "gauge", // up=1, down=0
+	"master_last_io_seconds_ago":  "gauge",
+	"rdb_changes_since_last_save": "gauge",
+}
@@ -0,0 +1,69 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package redis_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/redis"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type byName []mm.Metric
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+type RedisTestSuite struct {
+}
+
+var _ = Suite(&RedisTestSuite{})
+
+func (s *RedisTestSuite) TestInfoMetrics(t *C) {
+	info := map[string]string{
+		"redis_version":      "2.8.17",
+		"connected_clients":  "3",
+		"used_memory":        "1048576",
+		"master_link_status": "down",
+		"role":               "slave",
+		"db0":                "keys=10,expires=2,avg_ttl=0",
+		"db1":                "keys=5,expires=0,avg_ttl=0",
+	}
+	got := redis.InfoMetrics(info, nil)
+	sort.Sort(byName(got))
+	t.Check(got, DeepEquals, []mm.Metric{
+		{"redis/connected_clients", "gauge", 3, ""},
+		{"redis/db0/expires", "gauge", 2, ""},
+		{"redis/db0/keys", "gauge", 10, ""},
+		{"redis/db1/expires", "gauge", 0, ""},
+		{"redis/db1/keys", "gauge", 5, ""},
+		{"redis/master_link_status", "gauge", 0, ""},
+		{"redis/used_memory", "gauge", 1048576, ""},
+	})
+
+	got = redis.InfoMetrics(info, map[string]string{"redis_version": "gauge", "used_memory": "gauge"})
+	sort.Sort(byName(got))
+	t.Check(got, HasLen, 5) // used_memory and db keys, not the version
+	t.Check(got[4], DeepEquals, mm.Metric{"redis/used_memory", "gauge", 1048576, ""})
+}
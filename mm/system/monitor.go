@@ -22,7 +22,9 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/mm"
 	"github.com/percona/percona-agent/pct"
+	sysinfo "github.com/percona/percona-agent/sysinfo/system"
 	"io/ioutil"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +35,9 @@ var CPUStates []string = []string{"user", "nice", "system", "idle", "iowait", "i
 
 const nCPUStates = 10
 
+// Per-node counters from /sys/devices/system/node/nodeN/numastat, in order.
+var NUMAStats []string = []string{"numa_hit", "numa_miss", "numa_foreign", "interleave_hit", "local_node", "other_node"}
+
 type Monitor struct {
 	name   string
 	logger *pct.Logger
@@ -163,49 +168,25 @@ func (m *Monitor) run() {
 				Metrics: []mm.Metric{},
 			}
 
-			content, err := ioutil.ReadFile("/proc/stat")
-			if err == nil {
-				if metrics, err := m.ProcStat(content); err != nil {
-					m.logger.Warn("system:run:ProcStat:", err)
-				} else {
-					c.Metrics = append(c.Metrics, metrics...)
-				}
-			}
-
-			content, err = ioutil.ReadFile("/proc/meminfo")
-			if err == nil {
-				if metrics, err := m.ProcMeminfo(content); err != nil {
-					m.logger.Warn("system:run:ProcMeminfo:", err)
-				} else {
-					c.Metrics = append(c.Metrics, metrics...)
-				}
+			// FreeBSD, Mac OS X and Windows don't have /proc.
+			switch {
+			case runtime.GOOS == "windows":
+				c.Metrics = append(c.Metrics, m.collectWindows()...)
+			case pct.UsesSysctl(runtime.GOOS):
+				c.Metrics = append(c.Metrics, m.collectSysctl()...)
+			default:
+				c.Metrics = append(c.Metrics, m.collectProc()...)
 			}
 
-			content, err = ioutil.ReadFile("/proc/vmstat")
-			if err == nil {
-				if metrics, err := m.ProcVmstat(content); err != nil {
-					m.logger.Warn("system:run:ProcVmstat:", err)
-				} else {
-					c.Metrics = append(c.Metrics, metrics...)
-				}
+			// In a container, the metrics above are for the host, so also
+			// report the container's limits and usage.
+			if cg := pct.GetCgroup(); cg.Limited() {
+				c.Metrics = append(c.Metrics, m.Cgroup(cg)...)
 			}
 
-			content, err = ioutil.ReadFile("/proc/loadavg")
-			if err == nil {
-				if metrics, err := m.ProcLoadavg(content); err != nil {
-					m.logger.Warn("system:run:ProcLoadavg:", err)
-				} else {
-					c.Metrics = append(c.Metrics, metrics...)
-				}
-			}
-
-			content, err = ioutil.ReadFile("/proc/diskstats")
-			if err == nil {
-				if metrics, err := m.ProcDiskstats(content); err != nil {
-					m.logger.Warn("system:run:ProcDiskstats:", err)
-				} else {
-					c.Metrics = append(c.Metrics, metrics...)
-				}
+			// Clock skew from the API makes intervals and report times wrong.
+			if skew, ok := pct.ClockSkew(); ok {
+				c.Metrics = append(c.Metrics, mm.Metric{Name: "agent/clock-skew", Type: "gauge", Number: skew.Seconds()})
 			}
 
 			// Send the metrics to the aggregator.
@@ -229,6 +210,75 @@ func (m *Monitor) run() {
 	}
 }
 
+func (m *Monitor) collectProc() []mm.Metric {
+	metrics := []mm.Metric{}
+
+	content, err := ioutil.ReadFile("/proc/stat")
+	if err == nil {
+		if procMetrics, err := m.ProcStat(content); err != nil {
+			m.logger.Warn("system:run:ProcStat:", err)
+		} else {
+			metrics = append(metrics, procMetrics...)
+		}
+	}
+
+	content, err = ioutil.ReadFile("/proc/meminfo")
+	if err == nil {
+		if procMetrics, err := m.ProcMeminfo(content); err != nil {
+			m.logger.Warn("system:run:ProcMeminfo:", err)
+		} else {
+			metrics = append(metrics, procMetrics...)
+		}
+	}
+
+	content, err = ioutil.ReadFile("/proc/vmstat")
+	if err == nil {
+		if procMetrics, err := m.ProcVmstat(content); err != nil {
+			m.logger.Warn("system:run:ProcVmstat:", err)
+		} else {
+			metrics = append(metrics, procMetrics...)
+		}
+	}
+
+	content, err = ioutil.ReadFile("/proc/loadavg")
+	if err == nil {
+		if procMetrics, err := m.ProcLoadavg(content); err != nil {
+			m.logger.Warn("system:run:ProcLoadavg:", err)
+		} else {
+			metrics = append(metrics, procMetrics...)
+		}
+	}
+
+	content, err = ioutil.ReadFile("/proc/diskstats")
+	if err == nil {
+		if procMetrics, err := m.ProcDiskstats(content); err != nil {
+			m.logger.Warn("system:run:ProcDiskstats:", err)
+		} else {
+			metrics = append(metrics, procMetrics...)
+		}
+	}
+
+	// Partial NUMA info is fine, e.g. no nodes on hosts without NUMA.
+	numa, _ := sysinfo.ReadNUMA("/proc", "/sys")
+	metrics = append(metrics, m.NUMA(numa)...)
+
+	return metrics
+}
+
+func (m *Monitor) collectSysctl() []mm.Metric {
+	values, err := pct.Sysctl(SysctlNames(runtime.GOOS)...)
+	if err != nil {
+		m.logger.Warn("system:run:Sysctl:", err)
+		return nil
+	}
+	metrics, err := m.Sysctl(runtime.GOOS, values)
+	if err != nil {
+		m.logger.Warn("system:run:Sysctl:", err)
+		return nil
+	}
+	return metrics
+}
+
 func (m *Monitor) ProcStat(content []byte) ([]mm.Metric, error) {
 	m.logger.Debug("ProcStat:call")
 	defer m.logger.Debug("ProcStat:return")
@@ -414,6 +464,38 @@ func (m *Monitor) ProcVmstat(content []byte) ([]mm.Metric, error) {
 	return metrics, nil
 }
 
+func (m *Monitor) NUMA(info *sysinfo.NUMAInfo) []mm.Metric {
+	m.logger.Debug("NUMA:call")
+	defer m.logger.Debug("NUMA:return")
+
+	m.status.Update(m.name, "Getting NUMA metrics")
+
+	// Swappiness matters with or without NUMA, so it's always reported.
+	metrics := []mm.Metric{
+		{Name: "vm/swappiness", Type: "gauge", Number: float64(info.Swappiness)},
+	}
+	if len(info.Nodes) == 0 {
+		return metrics
+	}
+	metrics = append(metrics, mm.Metric{Name: "vm/zone_reclaim_mode", Type: "gauge", Number: float64(info.ZoneReclaimMode)})
+
+	// Memory in kB like memory/* metrics from /proc/meminfo.
+	for _, node := range info.Nodes {
+		prefix := fmt.Sprintf("numa/node%d/", node.Id)
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "MemTotal", Type: "gauge", Number: float64(node.MemTotal / 1024)},
+			mm.Metric{Name: prefix + "MemFree", Type: "gauge", Number: float64(node.MemFree / 1024)},
+			mm.Metric{Name: prefix + "MemUsed", Type: "gauge", Number: float64(node.MemUsed / 1024)},
+		)
+		for _, stat := range NUMAStats {
+			if val, ok := node.Stats[stat]; ok {
+				metrics = append(metrics, mm.Metric{Name: prefix + stat, Type: "counter", Number: float64(val)})
+			}
+		}
+	}
+	return metrics
+}
+
 func (m *Monitor) ProcLoadavg(content []byte) ([]mm.Metric, error) {
 	m.logger.Debug("ProcLoadavg:call")
 	defer m.logger.Debug("ProcLoadavg:return")
@@ -527,3 +609,22 @@ func (m *Monitor) ProcDiskstats(content []byte) ([]mm.Metric, error) {
 	}
 	return metrics, nil
 }
+
+func (m *Monitor) Cgroup(cg *pct.Cgroup) []mm.Metric {
+	m.logger.Debug("Cgroup:call")
+	defer m.logger.Debug("Cgroup:return")
+
+	m.status.Update(m.name, "Getting cgroup metrics")
+
+	// Limits are zero if not set, in which case they're not reported.
+	metrics := []mm.Metric{}
+	if cg.MemoryLimit > 0 {
+		metrics = append(metrics, mm.Metric{Name: "cgroup/memory_limit", Type: "gauge", Number: float64(cg.MemoryLimit)})
+	}
+	metrics = append(metrics, mm.Metric{Name: "cgroup/memory_usage", Type: "gauge", Number: float64(cg.MemoryUsage)})
+	if cg.CPUQuota > 0 {
+		metrics = append(metrics, mm.Metric{Name: "cgroup/cpu_quota", Type: "gauge", Number: cg.CPUQuota})
+	}
+	metrics = append(metrics, mm.Metric{Name: "cgroup/cpu_usage", Type: "counter", Number: float64(cg.CPUUsage)})
+	return metrics
+}
@@ -0,0 +1,133 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	sysinfo "github.com/percona/percona-agent/sysinfo/system"
+	"strings"
+)
+
+// SysctlNames returns the sysctl names that Sysctl uses on the OS, FreeBSD or
+// Mac OS X which don't have /proc.  Memory names are the same as sysinfo's.
+func SysctlNames(goos string) []string {
+	names := []string{"vm.loadavg"}
+	switch goos {
+	case "freebsd":
+		names = append(names,
+			"kern.cp_time",
+			"kern.cp_times",
+			"vm.stats.vm.v_active_count",
+			"vm.stats.vm.v_inactive_count",
+			"vm.stats.vm.v_wire_count",
+		)
+		names = append(names, sysinfo.FreeBSDMemSysctlNames...)
+	case "darwin":
+		names = append(names, sysinfo.DarwinMemSysctlNames...)
+	}
+	return names
+}
+
+// Sysctl reports the same metrics as the /proc methods, with the same names
+// and units, for the values that FreeBSD and Mac OS X have.  Mac OS X has no
+// CPU time sysctl, and neither OS has disk stats without devstat/IOKit, so
+// those metrics are Linux-only.
+func (m *Monitor) Sysctl(goos string, values map[string]string) ([]mm.Metric, error) {
+	m.logger.Debug("Sysctl:call")
+	defer m.logger.Debug("Sysctl:return")
+
+	m.status.Update(m.name, "Getting sysctl metrics")
+
+	metrics := []mm.Metric{}
+
+	// CPU: kern.cp_time is user nice sys intr idle, for all CPUs, and
+	// kern.cp_times is the same five values per CPU.  Rewriting them as
+	// /proc/stat lines lets ProcStat() do the hard part: the diffs.
+	if cpTime, ok := values["kern.cp_time"]; ok {
+		procStat := cpTimeToProcStat("cpu", strings.Fields(cpTime))
+		cpTimes := strings.Fields(values["kern.cp_times"])
+		for cpu := 0; (cpu+1)*5 <= len(cpTimes); cpu++ {
+			procStat += cpTimeToProcStat(fmt.Sprintf("cpu%d", cpu), cpTimes[cpu*5:(cpu+1)*5])
+		}
+		cpuMetrics, err := m.ProcStat([]byte(procStat))
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, cpuMetrics...)
+	}
+
+	// Load average: { 0.21 0.30 0.27 }
+	if loadavg := strings.Fields(strings.Trim(values["vm.loadavg"], "{} ")); len(loadavg) >= 3 {
+		metrics = append(metrics, mm.Metric{Name: "loadavg/1min", Type: "gauge", Number: StrToFloat(loadavg[0])})
+		metrics = append(metrics, mm.Metric{Name: "loadavg/5min", Type: "gauge", Number: StrToFloat(loadavg[1])})
+		metrics = append(metrics, mm.Metric{Name: "loadavg/15min", Type: "gauge", Number: StrToFloat(loadavg[2])})
+	}
+
+	// Memory, in kB like /proc/meminfo.  Counts are pages.
+	pageKb := StrToFloat(values["hw.pagesize"]) / 1024
+	if total := sysinfo.SysctlMemTotal(goos, values); total > 0 {
+		metrics = append(metrics, mm.Metric{Name: "memory/MemTotal", Type: "gauge", Number: float64(total) / 1024})
+	}
+	memory := []struct {
+		name   string
+		metric string
+		pages  bool
+	}{
+		{"vm.stats.vm.v_free_count", "MemFree", true},
+		{"vm.page_free_count", "MemFree", true},
+		{"vm.stats.vm.v_active_count", "Active", true},
+		{"vm.stats.vm.v_inactive_count", "Inactive", true},
+		{"vm.stats.vm.v_cache_count", "Cached", true},
+		{"vm.stats.vm.v_wire_count", "Wired", true},
+		{"vm.swap_total", "SwapTotal", false},
+	}
+	for _, mem := range memory {
+		val, ok := values[mem.name]
+		if !ok {
+			continue
+		}
+		n := StrToFloat(val)
+		if mem.pages {
+			n *= pageKb
+		} else {
+			n /= 1024
+		}
+		metrics = append(metrics, mm.Metric{Name: "memory/" + mem.metric, Type: "gauge", Number: n})
+	}
+
+	// Mac OS X swap: total = 2048.00M  used = 1024.50M  free = 1023.50M  (encrypted)
+	if swap := strings.Fields(values["vm.swapusage"]); len(swap) >= 9 {
+		metrics = append(metrics, mm.Metric{Name: "memory/SwapTotal", Type: "gauge", Number: megToKb(swap[2])})
+		metrics = append(metrics, mm.Metric{Name: "memory/SwapFree", Type: "gauge", Number: megToKb(swap[8])})
+	}
+
+	return metrics, nil
+}
+
+func cpTimeToProcStat(cpu string, cpTime []string) string {
+	if len(cpTime) != 5 {
+		return ""
+	}
+	// user nice system idle iowait irq
+	return fmt.Sprintf("%s %s %s %s %s 0 %s\n", cpu, cpTime[0], cpTime[1], cpTime[2], cpTime[4], cpTime[3])
+}
+
+func megToKb(s string) float64 {
+	return StrToFloat(strings.TrimSuffix(s, "M")) * 1024
+}
@@ -22,10 +22,12 @@ import (
 	"github.com/percona/percona-agent/mm"
 	"github.com/percona/percona-agent/mm/system"
 	"github.com/percona/percona-agent/pct"
+	sysinfo "github.com/percona/percona-agent/sysinfo/system"
 	"github.com/percona/percona-agent/test"
 	. "gopkg.in/check.v1"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -269,6 +271,62 @@ func (s *ProcVmstatTestSuite) TestProcVmstat001(t *C) {
 	}
 }
 
+/////////////////////////////////////////////////////////////////////////////
+// NUMA
+/////////////////////////////////////////////////////////////////////////////
+
+type NUMATestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&NUMATestSuite{})
+
+func (s *NUMATestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logger = pct.NewLogger(s.logChan, "system-monitor-test")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *NUMATestSuite) TestNUMA(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+	numaSample := test.RootDir + "/sysinfo/numa"
+	info, errs := sysinfo.ReadNUMA(numaSample+"/proc", numaSample+"/sys")
+	t.Assert(errs, HasLen, 0)
+	got := m.NUMA(info)
+	expect := []mm.Metric{
+		{Name: "vm/swappiness", Type: "gauge", Number: 60},
+		{Name: "vm/zone_reclaim_mode", Type: "gauge", Number: 1},
+		{Name: "numa/node0/MemTotal", Type: "gauge", Number: 16307476},
+		{Name: "numa/node0/MemFree", Type: "gauge", Number: 262144},
+		{Name: "numa/node0/MemUsed", Type: "gauge", Number: 16045332},
+		{Name: "numa/node0/numa_hit", Type: "counter", Number: 1004567321},
+		{Name: "numa/node0/numa_miss", Type: "counter", Number: 150938475},
+		{Name: "numa/node0/numa_foreign", Type: "counter", Number: 51000000},
+		{Name: "numa/node0/interleave_hit", Type: "counter", Number: 23415},
+		{Name: "numa/node0/local_node", Type: "counter", Number: 1004512345},
+		{Name: "numa/node0/other_node", Type: "counter", Number: 150993451},
+		{Name: "numa/node1/MemTotal", Type: "gauge", Number: 16307476},
+		{Name: "numa/node1/MemFree", Type: "gauge", Number: 6291456},
+		{Name: "numa/node1/MemUsed", Type: "gauge", Number: 10016020},
+		{Name: "numa/node1/numa_hit", Type: "counter", Number: 923807329},
+		{Name: "numa/node1/numa_miss", Type: "counter", Number: 51000000},
+		{Name: "numa/node1/numa_foreign", Type: "counter", Number: 150938475},
+		{Name: "numa/node1/interleave_hit", Type: "counter", Number: 23398},
+		{Name: "numa/node1/local_node", Type: "counter", Number: 923801234},
+		{Name: "numa/node1/other_node", Type: "counter", Number: 51006095},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// No NUMA: only swappiness.
+	got = m.NUMA(&sysinfo.NUMAInfo{Swappiness: 1})
+	t.Check(got, DeepEquals, []mm.Metric{{Name: "vm/swappiness", Type: "gauge", Number: 1}})
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // ProcLoadavg
 /////////////////////////////////////////////////////////////////////////////
@@ -445,6 +503,192 @@ func (s *ProcDiskstatsTestSuite) TestProcDiskstats001(t *C) {
 	}
 }
 
+/////////////////////////////////////////////////////////////////////////////
+// Cgroup
+/////////////////////////////////////////////////////////////////////////////
+
+type CgroupTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&CgroupTestSuite{})
+
+func (s *CgroupTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logger = pct.NewLogger(s.logChan, "system-monitor-test")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *CgroupTestSuite) TestCgroup(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+
+	cg := &pct.Cgroup{
+		Container:   "docker",
+		Version:     1,
+		MemoryLimit: 2147483648,
+		MemoryUsage: 536870912,
+		CPUQuota:    1.5,
+		CPUUsage:    98765432100,
+	}
+	got := m.Cgroup(cg)
+	expect := []mm.Metric{
+		{Name: "cgroup/memory_limit", Type: "gauge", Number: 2147483648},
+		{Name: "cgroup/memory_usage", Type: "gauge", Number: 536870912},
+		{Name: "cgroup/cpu_quota", Type: "gauge", Number: 1.5},
+		{Name: "cgroup/cpu_usage", Type: "counter", Number: 98765432100},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// No limits, only usage.
+	cg.MemoryLimit = 0
+	cg.CPUQuota = 0
+	got = m.Cgroup(cg)
+	expect = []mm.Metric{
+		{Name: "cgroup/memory_usage", Type: "gauge", Number: 536870912},
+		{Name: "cgroup/cpu_usage", Type: "counter", Number: 98765432100},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Sysctl
+/////////////////////////////////////////////////////////////////////////////
+
+type SysctlTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&SysctlTestSuite{})
+
+func (s *SysctlTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logger = pct.NewLogger(s.logChan, "system-monitor-test")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *SysctlTestSuite) TestFreeBSD(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+
+	content, err := ioutil.ReadFile(sample + "/sysctl/freebsd001.txt")
+	t.Assert(err, IsNil)
+	got, err := m.Sysctl("freebsd", pct.ParseSysctl(content))
+	t.Assert(err, IsNil)
+
+	// No CPU metrics on first call because they're diffs.
+	expect := []mm.Metric{
+		{Name: "loadavg/1min", Type: "gauge", Number: 0.21},
+		{Name: "loadavg/5min", Type: "gauge", Number: 0.30},
+		{Name: "loadavg/15min", Type: "gauge", Number: 0.27},
+		{Name: "memory/MemTotal", Type: "gauge", Number: 8336672},
+		{Name: "memory/MemFree", Type: "gauge", Number: 5873308},
+		{Name: "memory/Active", Type: "gauge", Number: 843536},
+		{Name: "memory/Inactive", Type: "gauge", Number: 1279020},
+		{Name: "memory/Cached", Type: "gauge", Number: 48136},
+		{Name: "memory/Wired", Type: "gauge", Number: 261764},
+		{Name: "memory/SwapTotal", Type: "gauge", Number: 4194304},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	content, err = ioutil.ReadFile(sample + "/sysctl/freebsd002.txt")
+	t.Assert(err, IsNil)
+	got, err = m.Sysctl("freebsd", pct.ParseSysctl(content))
+	t.Assert(err, IsNil)
+
+	// kern.cp_time and kern.cp_times map to the same CPU metrics as /proc/stat.
+	cpu := map[string]float64{}
+	for _, metric := range got {
+		if strings.HasPrefix(metric.Name, "cpu") {
+			cpu[metric.Name] = metric.Number
+		}
+	}
+	t.Check(cpu, HasLen, 18) // cpu, cpu0, cpu1 x user nice system idle iowait irq
+	t.Check(cpu["cpu/user"] > 15.38 && cpu["cpu/user"] < 15.39, Equals, true)
+	t.Check(cpu["cpu/idle"] > 69.23 && cpu["cpu/idle"] < 69.24, Equals, true)
+	t.Check(cpu["cpu/irq"] > 7.69 && cpu["cpu/irq"] < 7.70, Equals, true)
+	t.Check(cpu["cpu0/idle"] > 69.23 && cpu["cpu0/idle"] < 69.24, Equals, true)
+	t.Check(cpu["cpu1/nice"], Equals, float64(0))
+}
+
+func (s *SysctlTestSuite) TestDarwin(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+
+	content, err := ioutil.ReadFile(sample + "/sysctl/darwin001.txt")
+	t.Assert(err, IsNil)
+	got, err := m.Sysctl("darwin", pct.ParseSysctl(content))
+	t.Assert(err, IsNil)
+
+	expect := []mm.Metric{
+		{Name: "loadavg/1min", Type: "gauge", Number: 1.23},
+		{Name: "loadavg/5min", Type: "gauge", Number: 1.45},
+		{Name: "loadavg/15min", Type: "gauge", Number: 1.60},
+		{Name: "memory/MemTotal", Type: "gauge", Number: 16777216},
+		{Name: "memory/MemFree", Type: "gauge", Number: 2097152},
+		{Name: "memory/SwapTotal", Type: "gauge", Number: 2097152},
+		{Name: "memory/SwapFree", Type: "gauge", Number: 1048064},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *SysctlTestSuite) TestWindows(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+
+	stats := system.WindowsStats{
+		IdleTime:      800000000, // 8s
+		KernelTime:    1000000000,
+		UserTime:      200000000,
+		TotalPhys:     8589934592, // 8G
+		AvailPhys:     2147483648,
+		TotalPageFile: 10737418240, // + 2G page file
+		AvailPageFile: 3221225472,
+	}
+	got, err := m.Windows(stats)
+	t.Assert(err, IsNil)
+
+	// No CPU metrics on first call because they're diffs.
+	expect := []mm.Metric{
+		{Name: "memory/MemTotal", Type: "gauge", Number: 8388608},
+		{Name: "memory/MemFree", Type: "gauge", Number: 2097152},
+		{Name: "memory/SwapTotal", Type: "gauge", Number: 2097152},
+		{Name: "memory/SwapFree", Type: "gauge", Number: 1048576},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// +1s user, +1s system (kernel minus idle), +2s idle
+	stats.IdleTime += 20000000
+	stats.KernelTime += 30000000
+	stats.UserTime += 10000000
+	got, err = m.Windows(stats)
+	t.Assert(err, IsNil)
+	cpu := map[string]float64{}
+	for _, metric := range got {
+		if strings.HasPrefix(metric.Name, "cpu") {
+			cpu[metric.Name] = metric.Number
+		}
+	}
+	t.Check(cpu["cpu/user"], Equals, float64(25))
+	t.Check(cpu["cpu/system"], Equals, float64(25))
+	t.Check(cpu["cpu/idle"], Equals, float64(50))
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager
 /////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,92 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+)
+
+// WindowsStats are the values that Windows has instead of /proc: system times
+// from GetSystemTimes, in 100-nanosecond units, and memory from
+// GlobalMemoryStatusEx, in bytes.
+type WindowsStats struct {
+	IdleTime      uint64
+	KernelTime    uint64 // includes IdleTime
+	UserTime      uint64
+	TotalPhys     uint64
+	AvailPhys     uint64
+	TotalPageFile uint64 // commit limit: RAM + page files
+	AvailPageFile uint64
+}
+
+// Windows reports the same metrics as the /proc methods, with the same names
+// and units, for the values that Windows has.  CPU times are only for all
+// CPUs, and Windows has no load average.
+func (m *Monitor) Windows(stats WindowsStats) ([]mm.Metric, error) {
+	m.logger.Debug("Windows:call")
+	defer m.logger.Debug("Windows:return")
+
+	m.status.Update(m.name, "Getting Windows metrics")
+
+	// CPU: rewriting the times as a /proc/stat line, in 1/100 seconds like
+	// USER_HZ, lets ProcStat() do the hard part: the diffs.
+	const hz = 100000 // 100ns units per 1/100 second
+	procStat := fmt.Sprintf("cpu %d 0 %d %d 0 0\n",
+		stats.UserTime/hz,
+		(stats.KernelTime-stats.IdleTime)/hz,
+		stats.IdleTime/hz,
+	)
+	metrics, err := m.ProcStat([]byte(procStat))
+	if err != nil {
+		return nil, err
+	}
+
+	// Memory, in kB like /proc/meminfo.  Swap is the page files: the commit
+	// limit minus RAM.
+	metrics = append(metrics,
+		mm.Metric{Name: "memory/MemTotal", Type: "gauge", Number: float64(stats.TotalPhys / 1024)},
+		mm.Metric{Name: "memory/MemFree", Type: "gauge", Number: float64(stats.AvailPhys / 1024)},
+	)
+	if stats.TotalPageFile > stats.TotalPhys {
+		swapFree := uint64(0)
+		if stats.AvailPageFile > stats.AvailPhys {
+			swapFree = stats.AvailPageFile - stats.AvailPhys
+		}
+		metrics = append(metrics,
+			mm.Metric{Name: "memory/SwapTotal", Type: "gauge", Number: float64((stats.TotalPageFile - stats.TotalPhys) / 1024)},
+			mm.Metric{Name: "memory/SwapFree", Type: "gauge", Number: float64(swapFree / 1024)},
+		)
+	}
+
+	return metrics, nil
+}
+
+func (m *Monitor) collectWindows() []mm.Metric {
+	stats, err := ReadWindowsStats()
+	if err != nil {
+		m.logger.Warn("system:run:ReadWindowsStats:", err)
+		return nil
+	}
+	metrics, err := m.Windows(stats)
+	if err != nil {
+		m.logger.Warn("system:run:Windows:", err)
+		return nil
+	}
+	return metrics
+}
@@ -0,0 +1,78 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	procGetSystemTimes       = kernel32.NewProc("GetSystemTimes")
+	procGlobalMemoryStatusEx = kernel32.NewProc("GlobalMemoryStatusEx")
+)
+
+// MEMORYSTATUSEX
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// ReadWindowsStats returns the system times and memory.
+func ReadWindowsStats() (WindowsStats, error) {
+	stats := WindowsStats{}
+
+	var idle, kernel, user syscall.Filetime
+	r, _, err := procGetSystemTimes.Call(
+		uintptr(unsafe.Pointer(&idle)),
+		uintptr(unsafe.Pointer(&kernel)),
+		uintptr(unsafe.Pointer(&user)),
+	)
+	if r == 0 {
+		return stats, err
+	}
+	stats.IdleTime = filetime(idle)
+	stats.KernelTime = filetime(kernel)
+	stats.UserTime = filetime(user)
+
+	mem := memoryStatusEx{}
+	mem.Length = uint32(unsafe.Sizeof(mem))
+	r, _, err = procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&mem)))
+	if r == 0 {
+		return stats, err
+	}
+	stats.TotalPhys = mem.TotalPhys
+	stats.AvailPhys = mem.AvailPhys
+	stats.TotalPageFile = mem.TotalPageFile
+	stats.AvailPageFile = mem.AvailPageFile
+
+	return stats, nil
+}
+
+// filetime returns a FILETIME that's a duration, not a date, in 100ns units.
+func filetime(ft syscall.Filetime) uint64 {
+	return uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime)
+}
@@ -0,0 +1,231 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"github.com/percona/percona-agent/pct"
+	"sync"
+	"time"
+)
+
+// How long a tick waits for busy monitors, in total, not per monitor.
+const TICK_WAIT = 20 * time.Millisecond
+
+// Goroutines per tickGroup that run Collector.Collect, no matter how many
+// collectors are in the group.
+const COLLECT_WORKERS = 4
+
+// A tickGroup shares one clock ticker and a fixed pool of collect workers
+// among the monitors that collect at the same interval: the clock ticks the
+// group and the group runs its collectors on the workers.  With many
+// instances, e.g. 20 MySQL, there are COLLECT_WORKERS goroutines collecting
+// per interval instead of one goroutine per monitor, and the clock has one
+// watcher per interval instead of one per monitor.  Monitors that aren't
+// Collectors still have their own goroutine; the group sends them the tick
+// and waits TICK_WAIT for all busy ones with one timer instead of waiting for
+// each in turn.
+type tickGroup struct {
+	collect  uint           // interval, not throttled
+	tickChan chan time.Time // from the clock
+	// --
+	monitors   map[chan time.Time]bool
+	collectors map[Collector]*member
+	mux        *sync.Mutex // guards monitors and collectors
+	queue      []job       // for the workers
+	stopping   bool
+	cond       *sync.Cond // guards queue and stopping
+	workers    *sync.WaitGroup
+	sync       *pct.SyncChan
+}
+
+// A member is a Collector in a tickGroup.
+type member struct {
+	busy bool            // queued or collecting
+	done *sync.WaitGroup // in-flight Collect, see RemoveCollector
+}
+
+type job struct {
+	c   Collector
+	m   *member
+	now time.Time
+}
+
+func newTickGroup(collect uint) *tickGroup {
+	g := &tickGroup{
+		collect:  collect,
+		tickChan: make(chan time.Time),
+		// --
+		monitors:   make(map[chan time.Time]bool),
+		collectors: make(map[Collector]*member),
+		mux:        &sync.Mutex{},
+		queue:      []job{},
+		cond:       sync.NewCond(&sync.Mutex{}),
+		workers:    &sync.WaitGroup{},
+		sync:       pct.NewSyncChan(),
+	}
+	return g
+}
+
+// @goroutine[0]
+func (g *tickGroup) Start() {
+	g.workers.Add(COLLECT_WORKERS)
+	for i := 0; i < COLLECT_WORKERS; i++ {
+		go g.worker()
+	}
+	go g.run()
+}
+
+// @goroutine[0]
+func (g *tickGroup) Stop() {
+	g.sync.Stop()
+	g.sync.Wait()
+	g.cond.L.Lock()
+	g.stopping = true
+	g.cond.Broadcast()
+	g.cond.L.Unlock()
+	g.workers.Wait()
+}
+
+// Add adds a monitor's tickChan to the group.
+func (g *tickGroup) Add(c chan time.Time) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.monitors[c] = true
+}
+
+// Remove removes a monitor's tickChan and returns how many monitors are left.
+func (g *tickGroup) Remove(c chan time.Time) int {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	delete(g.monitors, c)
+	return len(g.monitors) + len(g.collectors)
+}
+
+// AddCollector adds a Collector to run on the group's workers.
+func (g *tickGroup) AddCollector(c Collector) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.collectors[c] = &member{done: &sync.WaitGroup{}}
+}
+
+// RemoveCollector removes a Collector, waits for it to finish collecting if
+// it is, and returns how many monitors are left.  After it returns, Collect
+// isn't called again, so the Collector can be stopped.
+func (g *tickGroup) RemoveCollector(c Collector) int {
+	g.mux.Lock()
+	m, ok := g.collectors[c]
+	delete(g.collectors, c)
+	n := len(g.monitors) + len(g.collectors)
+	g.mux.Unlock()
+	if ok {
+		m.done.Wait() // tick() only adds members in g.collectors
+	}
+	return n
+}
+
+// @goroutine[2]
+func (g *tickGroup) run() {
+	defer g.sync.Done()
+	for {
+		select {
+		case now := <-g.tickChan:
+			g.tick(now)
+		case <-g.sync.StopChan:
+			return
+		}
+	}
+}
+
+// @goroutine[3]
+func (g *tickGroup) worker() {
+	defer g.workers.Done()
+	for {
+		g.cond.L.Lock()
+		for len(g.queue) == 0 && !g.stopping {
+			g.cond.Wait()
+		}
+		if len(g.queue) == 0 {
+			g.cond.L.Unlock()
+			return // stopping
+		}
+		j := g.queue[0]
+		g.queue = g.queue[1:]
+		g.cond.L.Unlock()
+		g.runCollect(j)
+	}
+}
+
+func (g *tickGroup) runCollect(j job) {
+	defer j.m.done.Done()
+	g.mux.Lock()
+	removed := g.collectors[j.c] != j.m
+	g.mux.Unlock()
+	if removed {
+		return // RemoveCollector is waiting, don't make it wait for Collect
+	}
+	defer func() {
+		g.mux.Lock()
+		j.m.busy = false
+		g.mux.Unlock()
+	}()
+	j.c.Collect(j.now)
+}
+
+// tick queues idle collectors for the workers, sends the tick to idle
+// monitors, then waits for busy monitors until TICK_WAIT.  Collectors still
+// queued or collecting from the last tick and monitors still busy then miss
+// the tick, like they would with their own ticker.
+func (g *tickGroup) tick(now time.Time) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	jobs := []job{}
+	for c, m := range g.collectors {
+		if m.busy {
+			continue
+		}
+		m.busy = true
+		m.done.Add(1)
+		jobs = append(jobs, job{c, m, now})
+	}
+	if len(jobs) > 0 {
+		g.cond.L.Lock()
+		g.queue = append(g.queue, jobs...)
+		g.cond.Broadcast()
+		g.cond.L.Unlock()
+	}
+	busy := []chan time.Time{}
+	for c := range g.monitors {
+		select {
+		case c <- now:
+		default:
+			busy = append(busy, c)
+		}
+	}
+	if len(busy) == 0 {
+		return
+	}
+	timeout := time.NewTimer(TICK_WAIT)
+	defer timeout.Stop()
+	for _, c := range busy {
+		select {
+		case c <- now:
+		case <-timeout.C:
+			return
+		}
+	}
+}
@@ -0,0 +1,169 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mongo
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Instance is a MongoDB instance in the instance repo, like proto.MySQLInstance.
+type Instance struct {
+	Id       uint
+	Hostname string
+	URL      string // mongodb://[user:pass@]host[:port][/db][?options], encrypted on disk like MySQL DSNs
+	Version  string `json:",omitempty"`
+}
+
+func init() {
+	instance.RegisterType("mongo", instance.Type{
+		New:    func() interface{} { return &Instance{} },
+		Secret: func(it interface{}) *string { return &it.(*Instance).URL },
+	})
+}
+
+// MongoDB error codes
+const (
+	NO_REPLICATION_ENABLED = 76 // replSetGetStatus when not running with --replSet
+)
+
+type Connector interface {
+	URL() string
+	Connect(tries uint) error
+	Close()
+	ServerStatus() (bson.M, error)
+	ReplSetGetStatus() (bson.M, error)
+}
+
+type Connection struct {
+	url             string
+	session         *mgo.Session
+	backoff         *pct.Backoff
+	connectedAmount uint
+	connectionMux   *sync.Mutex
+}
+
+func NewConnection(url string) *Connection {
+	c := &Connection{
+		url:           url,
+		backoff:       pct.NewBackoff(20 * time.Second),
+		connectionMux: new(sync.Mutex),
+	}
+	return c
+}
+
+func (c *Connection) URL() string {
+	return c.url
+}
+
+func (c *Connection) Connect(tries uint) error {
+	if tries == 0 {
+		return nil
+	}
+	c.connectionMux.Lock()
+	defer c.connectionMux.Unlock()
+	if c.connectedAmount > 0 {
+		// already have opened connection
+		c.connectedAmount++
+		return nil
+	}
+	var err error
+	var session *mgo.Session
+	for i := tries; i > 0; i-- {
+		// Wait before attempt.
+		time.Sleep(c.backoff.Wait())
+
+		// Dial connects and authenticates, so no ping is needed like MySQL.
+		session, err = mgo.DialWithTimeout(c.url, 5*time.Second)
+		if err != nil {
+			continue
+		}
+
+		// Connected.  Monotonic reads from a secondary, if the URL is one,
+		// so the monitor gets that server's status, not the primary's.
+		session.SetMode(mgo.Monotonic, true)
+		c.session = session
+		c.backoff.Success()
+		c.connectedAmount++
+		return nil
+	}
+
+	return fmt.Errorf("Failed to connect to MongoDB %s: %s", HideURLPassword(c.url), err)
+}
+
+func (c *Connection) Close() {
+	c.connectionMux.Lock()
+	defer c.connectionMux.Unlock()
+	if c.connectedAmount == 0 {
+		// connection closed already
+		return
+	}
+	c.connectedAmount--
+	if c.connectedAmount == 0 && c.session != nil {
+		c.session.Close()
+		c.session = nil
+	}
+}
+
+// ServerStatus returns the output of db.serverStatus().
+func (c *Connection) ServerStatus() (bson.M, error) {
+	status := bson.M{}
+	if err := c.session.DB("admin").Run(bson.D{{"serverStatus", 1}}, &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ReplSetGetStatus returns the output of rs.status(), or nil if the server
+// isn't a replica set member.
+func (c *Connection) ReplSetGetStatus() (bson.M, error) {
+	status := bson.M{}
+	if err := c.session.DB("admin").Run(bson.D{{"replSetGetStatus", 1}}, &status); err != nil {
+		if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == NO_REPLICATION_ENABLED {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return status, nil
+}
+
+// HideURLPassword replaces the password in a MongoDB URL, if any, for logging.
+func HideURLPassword(url string) string {
+	start := strings.Index(url, "://")
+	if start < 0 {
+		return url
+	}
+	start += 3
+	end := strings.LastIndex(url, "@")
+	if end < start {
+		return url
+	}
+	colon := strings.Index(url[start:end], ":")
+	if colon < 0 {
+		return url
+	}
+	return url[:start+colon+1] + mysql.HiddenPassword + url[end:]
+}
@@ -0,0 +1,214 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrAnalyzeNotSupported = errors.New("ANALYZE FORMAT=JSON requires MariaDB 10.1 or newer")
+var ErrAnalyzeNotSelect = errors.New("ANALYZE executes the query, so only SELECT is allowed")
+
+var mariadbVersionRe = regexp.MustCompile(`^(\d+)\.(\d+)\..*MariaDB`)
+
+// AnalyzeResult is the result of MariaDB ANALYZE FORMAT=JSON: the query plan
+// like EXPLAIN FORMAT=JSON plus r_* members with actual statistics from
+// executing the query.  Tables has the estimated and actual statistics of
+// each table in the plan.
+type AnalyzeResult struct {
+	JSON   string
+	Tables []AnalyzeTable
+}
+
+type AnalyzeTable struct {
+	Table          string
+	AccessType     string
+	Key            string  `json:",omitempty"`
+	Rows           float64 // estimated rows examined per loop
+	ActualRows     float64 // r_rows
+	Filtered       float64 // estimated % of rows matching the condition
+	ActualFiltered float64 // r_filtered
+	Loops          float64 // r_loops
+	TotalTimeMs    float64 // r_total_time_ms
+}
+
+// SupportsAnalyze returns true if @@version is MariaDB 10.1 or newer, which
+// has ANALYZE FORMAT=JSON.
+func SupportsAnalyze(version string) bool {
+	m := mariadbVersionRe.FindStringSubmatch(version)
+	if m == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major > 10 || (major == 10 && minor >= 1)
+}
+
+// Analyze runs ANALYZE FORMAT=JSON, which executes the query, so only SELECT
+// is allowed, and it's rolled back anyway.
+func (c *Connection) Analyze(query string, db string) (*AnalyzeResult, error) {
+	if !isSelect(query) {
+		return nil, ErrAnalyzeNotSelect
+	}
+	if c.conn == nil {
+		return nil, errors.New("Not connected")
+	}
+	if !SupportsAnalyze(c.GetGlobalVarString("version")) {
+		return nil, ErrAnalyzeNotSupported
+	}
+
+	// Transaction because we need to ensure USE and ANALYZE are run in one connection
+	tx, err := c.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if db != "" {
+		if _, err := tx.Exec(fmt.Sprintf("USE %s", db)); err != nil {
+			return nil, err
+		}
+	}
+
+	var jsonAnalyze string
+	if err := tx.QueryRow("ANALYZE FORMAT=JSON " + query).Scan(&jsonAnalyze); err != nil {
+		return nil, err
+	}
+	tables, err := ParseAnalyze(jsonAnalyze)
+	if err != nil {
+		return nil, err
+	}
+	result := &AnalyzeResult{
+		JSON:   jsonAnalyze,
+		Tables: tables,
+	}
+	return result, nil
+}
+
+// ParseAnalyze returns the tables in the output of ANALYZE FORMAT=JSON, in
+// plan order, wherever they're nested, e.g. in subqueries.  Joined tables can
+// be consecutive "table" members of the same object, so the JSON is decoded
+// in order, keeping duplicate members.
+func ParseAnalyze(jsonAnalyze string) ([]AnalyzeTable, error) {
+	dec := json.NewDecoder(strings.NewReader(jsonAnalyze))
+	plan, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	tables := []AnalyzeTable{}
+	walkAnalyze(plan, &tables)
+	return tables, nil
+}
+
+// member is one name: value of a JSON object, which is decoded as []member.
+type member struct {
+	name  string
+	value interface{}
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := []member{}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{name: name.(string), value: value})
+		}
+		_, err := dec.Token() // }
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token() // ]
+		return arr, err
+	}
+	return tok, nil
+}
+
+func walkAnalyze(node interface{}, tables *[]AnalyzeTable) {
+	switch v := node.(type) {
+	case []member:
+		values := make(map[string]interface{}, len(v))
+		for _, m := range v {
+			values[m.name] = m.value
+		}
+		if name, ok := values["table_name"].(string); ok {
+			t := AnalyzeTable{
+				Table:          name,
+				Rows:           analyzeNumber(values["rows"]),
+				ActualRows:     analyzeNumber(values["r_rows"]),
+				Filtered:       analyzeNumber(values["filtered"]),
+				ActualFiltered: analyzeNumber(values["r_filtered"]),
+				Loops:          analyzeNumber(values["r_loops"]),
+				TotalTimeMs:    analyzeNumber(values["r_total_time_ms"]),
+			}
+			t.AccessType, _ = values["access_type"].(string)
+			t.Key, _ = values["key"].(string)
+			*tables = append(*tables, t)
+		}
+		for _, m := range v {
+			walkAnalyze(m.value, tables)
+		}
+	case []interface{}:
+		for _, child := range v {
+			walkAnalyze(child, tables)
+		}
+	}
+}
+
+func analyzeNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+// isSelect returns true if the query is a SELECT that doesn't write a file.
+func isSelect(query string) bool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(q, "SELECT") && !strings.HasPrefix(q, "(SELECT") {
+		return false
+	}
+	return !strings.Contains(q, "OUTFILE") && !strings.Contains(q, "DUMPFILE")
+}
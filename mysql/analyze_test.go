@@ -0,0 +1,111 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql_test
+
+import (
+	"github.com/percona/percona-agent/mysql"
+	. "gopkg.in/check.v1"
+)
+
+type AnalyzeTestSuite struct {
+}
+
+var _ = Suite(&AnalyzeTestSuite{})
+
+func (s *AnalyzeTestSuite) TestSupportsAnalyze(t *C) {
+	t.Check(mysql.SupportsAnalyze("10.1.48-MariaDB"), Equals, true)
+	t.Check(mysql.SupportsAnalyze("10.6.12-MariaDB-0ubuntu0.22.04.1-log"), Equals, true)
+	t.Check(mysql.SupportsAnalyze("11.4.2-MariaDB"), Equals, true)
+	t.Check(mysql.SupportsAnalyze("10.0.38-MariaDB"), Equals, false)
+	t.Check(mysql.SupportsAnalyze("8.0.36"), Equals, false)
+	t.Check(mysql.SupportsAnalyze("5.7.44-48-log"), Equals, false)
+}
+
+func (s *AnalyzeTestSuite) TestParseAnalyze(t *C) {
+	// Joined tables are consecutive "table" members.
+	analyze := `{
+  "query_block": {
+    "select_id": 1,
+    "r_loops": 1,
+    "r_total_time_ms": 3.3,
+    "table": {
+      "table_name": "t1",
+      "access_type": "ALL",
+      "r_loops": 1,
+      "rows": 1000,
+      "r_rows": 1000,
+      "r_total_time_ms": 1.1,
+      "filtered": 100,
+      "r_filtered": 9.9,
+      "attached_condition": "t1.b is not null"
+    },
+    "table": {
+      "table_name": "t2",
+      "access_type": "ref",
+      "possible_keys": ["b"],
+      "key": "b",
+      "key_length": "5",
+      "used_key_parts": ["b"],
+      "ref": ["test.t1.b"],
+      "r_loops": 99,
+      "rows": 2,
+      "r_rows": 12.5,
+      "r_total_time_ms": 2.2,
+      "filtered": 100,
+      "r_filtered": 100
+    },
+    "subqueries": [
+      {
+        "query_block": {
+          "select_id": 2,
+          "table": {
+            "table_name": "t3",
+            "access_type": "index",
+            "key": "PRIMARY",
+            "r_loops": 1,
+            "rows": 10,
+            "r_rows": 10,
+            "filtered": 100,
+            "r_filtered": 100,
+            "using_index": true
+          }
+        }
+      }
+    ]
+  }
+}`
+	got, err := mysql.ParseAnalyze(analyze)
+	t.Assert(err, IsNil)
+	t.Check(got, DeepEquals, []mysql.AnalyzeTable{
+		{Table: "t1", AccessType: "ALL", Rows: 1000, ActualRows: 1000, Filtered: 100, ActualFiltered: 9.9, Loops: 1, TotalTimeMs: 1.1},
+		{Table: "t2", AccessType: "ref", Key: "b", Rows: 2, ActualRows: 12.5, Filtered: 100, ActualFiltered: 100, Loops: 99, TotalTimeMs: 2.2},
+		{Table: "t3", AccessType: "index", Key: "PRIMARY", Rows: 10, ActualRows: 10, Filtered: 100, ActualFiltered: 100, Loops: 1},
+	})
+
+	_, err = mysql.ParseAnalyze("{")
+	t.Check(err, NotNil)
+}
+
+func (s *AnalyzeTestSuite) TestAnalyzeOnlySelect(t *C) {
+	// The query is checked before the connection is used.
+	conn := mysql.NewConnection("user:pass@tcp(127.0.0.1:1)/")
+	_, err := conn.Analyze("DELETE FROM t", "")
+	t.Check(err, Equals, mysql.ErrAnalyzeNotSelect)
+	_, err = conn.Analyze("SELECT * FROM t INTO OUTFILE '/tmp/t'", "")
+	t.Check(err, Equals, mysql.ErrAnalyzeNotSelect)
+}
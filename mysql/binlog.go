@@ -0,0 +1,102 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"github.com/percona/percona-agent/pct"
+	"path/filepath"
+	"strconv"
+)
+
+// Binlogs are the binary logs of a MySQL instance and the filesystem they're on.
+// The filesystem is stat'ed on this host, so only for local MySQL, see IsLocal.
+type Binlogs struct {
+	Count         uint
+	Size          uint64 // bytes, all binlogs
+	ExpireSeconds uint64 // 0 if binlogs aren't purged automatically
+	Dir           string
+	FsSize        uint64 // bytes, 0 if Dir isn't on this host
+	FsFree        uint64 // bytes available to MySQL
+}
+
+// GetBinlogs returns the binary logs of MySQL, or nil if binary logging is off.
+// SHOW BINARY LOGS requires the REPLICATION CLIENT or SUPER privilege.  The
+// filesystem is stat'ed only if MySQL is local, else the binlog dir could be
+// a different filesystem on this host.
+func GetBinlogs(conn *sql.DB, local bool) (*Binlogs, error) {
+	var logBin bool
+	if err := conn.QueryRow("SELECT @@GLOBAL.log_bin").Scan(&logBin); err != nil {
+		return nil, err
+	}
+	if !logBin {
+		return nil, nil
+	}
+
+	b := &Binlogs{}
+	rows, err := conn.Query("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	// Log_name, File_size, and Encrypted since MySQL 8.0.14.
+	vals := make([]interface{}, len(cols))
+	for i := range vals {
+		vals[i] = new(sql.RawBytes)
+	}
+	for rows.Next() {
+		if err := rows.Scan(vals...); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.ParseUint(string(*vals[1].(*sql.RawBytes)), 10, 64)
+		b.Count++
+		b.Size += size
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// binlog_expire_logs_seconds since MySQL 8.0, else expire_logs_days.
+	if err := conn.QueryRow("SELECT @@GLOBAL.binlog_expire_logs_seconds").Scan(&b.ExpireSeconds); err != nil || b.ExpireSeconds == 0 {
+		var days uint64
+		conn.QueryRow("SELECT @@GLOBAL.expire_logs_days").Scan(&days)
+		b.ExpireSeconds = days * 86400
+	}
+
+	// log_bin_basename since MySQL 5.6, else binlogs are in the datadir
+	// unless log_bin is a path, which we can't know.
+	var basename sql.NullString
+	if err := conn.QueryRow("SELECT @@GLOBAL.log_bin_basename").Scan(&basename); err == nil && basename.String != "" {
+		b.Dir = filepath.Dir(basename.String)
+	} else {
+		conn.QueryRow("SELECT @@GLOBAL.datadir").Scan(&b.Dir)
+	}
+
+	if local && b.Dir != "" {
+		if size, free, _, err := pct.FsSpace(b.Dir); err == nil {
+			b.FsSize = size
+			b.FsFree = free
+		}
+	}
+
+	return b, nil
+}
@@ -20,9 +20,12 @@ package mysql
 import (
 	"errors"
 	"fmt"
+	"github.com/percona/percona-agent/pct"
+	"net"
 	"os/exec"
 	"os/user"
 	"path"
+	"runtime"
 	"strings"
 )
 
@@ -32,11 +35,14 @@ type DSN struct {
 	Hostname     string
 	Port         string
 	Socket       string
+	Pipe         string // Windows named pipe, e.g. MySQL for \\.\pipe\MySQL
 	OldPasswords bool
 	Protocol     string
+	Proxy        string // SOCKS5, HTTP or SSH jump host URL, see DSN_PROXY_PARAM
 }
 
 const (
+	DEFAULT_PIPE      = "MySQL" // mysqld --enable-named-pipe default
 	dsnSuffix         = "/?parseTime=true"
 	allowOldPasswords = "&allowOldPasswords=true"
 	HiddenPassword    = "<password-hidden>"
@@ -50,18 +56,23 @@ func (dsn DSN) DSN() (string, error) {
 		dsn.Password = ":" + dsn.Password
 	}
 
+	if dsn.Protocol == "pipe" && dsn.Pipe == "" {
+		dsn.Pipe = DEFAULT_PIPE
+	}
+
 	// Hostname always defaults to localhost.  If localhost means 127.0.0.1 or socket
 	// is handled next.
-	if dsn.Hostname == "" && dsn.Socket == "" {
+	if dsn.Hostname == "" && dsn.Socket == "" && dsn.Pipe == "" {
 		dsn.Hostname = "localhost"
 	}
 
 	// http://dev.mysql.com/doc/refman/5.0/en/connecting.html#option_general_protocol:
 	// "connections on Unix to localhost are made using a Unix socket file by default"
-	if dsn.Hostname == "localhost" && (dsn.Protocol == "" || dsn.Protocol == "socket") {
+	// but on Windows they're made using TCP unless the protocol is pipe.
+	if dsn.Hostname == "localhost" && dsn.Pipe == "" && runtime.GOOS != "windows" && (dsn.Protocol == "" || dsn.Protocol == "socket") {
 		if dsn.Socket == "" {
 			// Try to auto-detect MySQL socket from netstat output.
-			out, err := exec.Command("netstat", "-anp").Output()
+			out, err := exec.Command("netstat", netstatArgs(runtime.GOOS)...).Output()
 			if err != nil {
 				return "", ErrNoSocket
 			}
@@ -74,7 +85,13 @@ func (dsn DSN) DSN() (string, error) {
 	}
 
 	dsnString := ""
-	if dsn.Socket != "" {
+	if dsn.Pipe != "" {
+		dsnString = fmt.Sprintf("%s%s@pipe(%s)",
+			dsn.Username,
+			dsn.Password,
+			dsn.Pipe,
+		)
+	} else if dsn.Socket != "" {
 		dsnString = fmt.Sprintf("%s%s@unix(%s)",
 			dsn.Username,
 			dsn.Password,
@@ -101,11 +118,16 @@ func (dsn DSN) DSN() (string, error) {
 	if dsn.OldPasswords {
 		dsnString = dsnString + allowOldPasswords
 	}
+	if dsn.Proxy != "" && dsn.Socket == "" && dsn.Pipe == "" {
+		dsnString = dsnString + ProxyParam(dsn.Proxy)
+	}
 	return dsnString, nil
 }
 
 func (dsn DSN) To() string {
-	if dsn.Socket != "" {
+	if dsn.Pipe != "" {
+		return PipePath(dsn.Pipe)
+	} else if dsn.Socket != "" {
 		return dsn.Socket
 	} else if dsn.Hostname != "" {
 		if dsn.Port == "" {
@@ -121,6 +143,7 @@ func (dsn DSN) String() string {
 		dsn.Username = "<anonymous-user>"
 	}
 	dsn.Password = HiddenPassword
+	dsn.Proxy = ""
 	dsnString, _ := dsn.DSN()
 	dsnString = strings.TrimSuffix(dsnString, allowOldPasswords)
 	dsnString = strings.TrimSuffix(dsnString, dsnSuffix)
@@ -133,25 +156,96 @@ func (dsn DSN) StringWithSuffixes() string {
 	}
 	dsn.Password = HiddenPassword
 	dsnString, _ := dsn.DSN()
-	return dsnString
+	return hideProxyPassword(dsnString)
+}
+
+// PipePath returns the path of the named pipe, e.g. \\.\pipe\MySQL for MySQL.
+func PipePath(name string) string {
+	return `\\.\pipe\` + name
+}
+
+// netstatArgs returns the netstat options that list Unix sockets.  On Linux,
+// -p lists the program (e.g. mysqld) too, but on BSD -p is the protocol.
+func netstatArgs(goos string) []string {
+	if pct.UsesSysctl(goos) {
+		return []string{"-an", "-f", "unix"}
+	}
+	return []string{"-anp"}
 }
 
 func ParseSocketFromNetstat(out string) string {
 	lines := strings.Split(out, "\n")
 	for _, line := range lines {
-		if strings.HasPrefix(line, "unix") && strings.Contains(line, "mysql") {
-			fields := strings.Fields(line)
-			socket := fields[len(fields)-1]
-			if path.IsAbs(socket) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		socket := fields[len(fields)-1]
+		if !path.IsAbs(socket) {
+			continue
+		}
+		if strings.HasPrefix(line, "unix") {
+			// Linux: the line has the program name, e.g. 1234/mysqld.
+			if strings.Contains(line, "mysql") {
 				return socket
 			}
+		} else if strings.Contains(socket, "mysql") {
+			// FreeBSD and Mac OS X: the line has only the socket path.
+			return socket
 		}
 	}
 	return ""
 }
 
+// IsLocal returns true if the DSN connects to MySQL on this host: by socket,
+// named pipe, or TCP to a loopback or local interface address without a proxy.
+// Hostnames are resolved, so call it once, not for every collection.
+func IsLocal(dsn string) bool {
+	if i := strings.Index(dsn, "?"); i >= 0 && strings.Contains("&"+dsn[i+1:], "&"+DSN_PROXY_PARAM+"=") {
+		return false
+	}
+	for _, local := range []string{"@unix(", "@pipe("} {
+		if strings.Contains(dsn, local) {
+			return true
+		}
+	}
+	i := strings.LastIndex(dsn, "@tcp(")
+	if i < 0 {
+		return strings.Contains(dsn, "@/") // default socket
+	}
+	addr := dsn[i+len("@tcp("):]
+	if j := strings.Index(addr, ")"); j >= 0 {
+		addr = addr[:j]
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil {
+			return false
+		}
+	}
+	localAddrs, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() {
+			return true
+		}
+		for _, a := range localAddrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func HideDSNPassword(dsn string) string {
 	dsnParts := strings.Split(dsn, "@")
 	userPasswordParts := strings.Split(dsnParts[0], ":")
-	return userPasswordParts[0] + ":" + HiddenPassword + "@" + dsnParts[1]
+	return userPasswordParts[0] + ":" + HiddenPassword + "@" + hideProxyPassword(dsnParts[1])
 }
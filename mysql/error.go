@@ -28,4 +28,21 @@ func FormatError(err error) string {
 // MySQL error codes
 const (
 	ER_SPECIFIC_ACCESS_DENIED_ERROR = 1227
+	ER_DBACCESS_DENIED_ERROR        = 1044
+	ER_ACCESS_DENIED_ERROR          = 1045
+	ER_UNKNOWN_TABLE                = 1109
+	ER_BAD_DB_ERROR                 = 1049
+	ER_PARSE_ERROR                  = 1064
+	ER_TABLEACCESS_DENIED_ERROR     = 1142
+	ER_COLUMNACCESS_DENIED_ERROR    = 1143
+	ER_NO_SUCH_TABLE                = 1146
 )
+
+// MissingPrivilege returns true if MySQL denied an operation because the user
+// doesn't have a privilege, usually SUPER.  The agent user may not have SUPER
+// (see installer -mysql-minimal-grants), so features that need it should
+// degrade rather than fail.
+func MissingPrivilege(err error) bool {
+	code := MySQLErrorCode(err)
+	return code == ER_SPECIFIC_ACCESS_DENIED_ERROR || code == ER_DBACCESS_DENIED_ERROR
+}
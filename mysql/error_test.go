@@ -0,0 +1,47 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql_test
+
+import (
+	"errors"
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/percona/percona-agent/mysql"
+	. "gopkg.in/check.v1"
+)
+
+type ErrorTestSuite struct {
+}
+
+var _ = Suite(&ErrorTestSuite{})
+
+func (s *ErrorTestSuite) TestMissingPrivilege(t *C) {
+	err := &driver.MySQLError{
+		Number:  1227,
+		Message: "Access denied; you need (at least one of) the SUPER privilege(s) for this operation",
+	}
+	t.Check(mysql.MissingPrivilege(err), Equals, true)
+
+	err = &driver.MySQLError{
+		Number:  1193,
+		Message: "Unknown system variable 'userstat'",
+	}
+	t.Check(mysql.MissingPrivilege(err), Equals, false)
+
+	t.Check(mysql.MissingPrivilege(errors.New("Not connected")), Equals, false)
+	t.Check(mysql.MissingPrivilege(nil), Equals, false)
+}
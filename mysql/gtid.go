@@ -0,0 +1,118 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BinlogCoordinates are the binlog position and GTID sets of MySQL at one
+// point in time, e.g. to verify a failover: the new source must have executed
+// all of the old source's GTIDExecuted.
+type BinlogCoordinates struct {
+	Ts           time.Time // UTC
+	ServerUUID   string    // empty on MariaDB
+	GTIDMode     string    // ON, OFF, etc.; empty on MariaDB
+	GTIDExecuted string    // GTID set, or gtid_binlog_pos on MariaDB
+	GTIDPurged   string    // GTID set, empty on MariaDB
+	BinlogFile   string    // empty if binary logging is off
+	BinlogPos    uint64
+}
+
+// GetBinlogCoordinates returns the current binlog coordinates of MySQL.  The
+// binlog file, position and, since MySQL 5.6, the executed GTID set are from
+// the same SHOW MASTER STATUS, so they're consistent; the other values are
+// read after.  SHOW MASTER STATUS requires the REPLICATION CLIENT or SUPER
+// privilege.
+func GetBinlogCoordinates(conn *sql.DB) (*BinlogCoordinates, error) {
+	c := &BinlogCoordinates{
+		Ts: time.Now().UTC(),
+	}
+
+	// SHOW BINARY LOG STATUS replaces SHOW MASTER STATUS in MySQL 8.2.
+	status, err := showMasterStatus(conn, "SHOW MASTER STATUS")
+	if MySQLErrorCode(err) == ER_PARSE_ERROR {
+		status, err = showMasterStatus(conn, "SHOW BINARY LOG STATUS")
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.BinlogFile = status["File"]
+	c.BinlogPos, _ = strconv.ParseUint(status["Position"], 10, 64)
+	c.GTIDExecuted = NormalizeGTIDSet(status["Executed_Gtid_Set"])
+
+	// These vars don't exist in MariaDB or MySQL 5.5, so errors are ignored.
+	var v sql.NullString
+	if err := conn.QueryRow("SELECT @@GLOBAL.server_uuid").Scan(&v); err == nil {
+		c.ServerUUID = v.String
+	}
+	if err := conn.QueryRow("SELECT @@GLOBAL.gtid_mode").Scan(&v); err == nil {
+		c.GTIDMode = v.String
+	}
+	if err := conn.QueryRow("SELECT @@GLOBAL.gtid_purged").Scan(&v); err == nil {
+		c.GTIDPurged = NormalizeGTIDSet(v.String)
+	}
+	if c.GTIDExecuted == "" {
+		if err := conn.QueryRow("SELECT @@GLOBAL.gtid_executed").Scan(&v); err == nil {
+			c.GTIDExecuted = NormalizeGTIDSet(v.String)
+		} else if err := conn.QueryRow("SELECT @@GLOBAL.gtid_binlog_pos").Scan(&v); err == nil {
+			c.GTIDExecuted = v.String // MariaDB
+		}
+	}
+
+	return c, nil
+}
+
+// showMasterStatus returns the columns of the SHOW MASTER STATUS row, which
+// vary by version, or an empty map if binary logging is off (no row).
+func showMasterStatus(conn *sql.DB, query string) (map[string]string, error) {
+	status := make(map[string]string)
+	rows, err := conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return status, rows.Err()
+	}
+	vals := make([]interface{}, len(cols))
+	for i := range vals {
+		vals[i] = new(sql.RawBytes)
+	}
+	if err := rows.Scan(vals...); err != nil {
+		return nil, err
+	}
+	for i, col := range cols {
+		status[col] = string(*vals[i].(*sql.RawBytes))
+	}
+	return status, nil
+}
+
+// NormalizeGTIDSet removes the newlines and spaces that MySQL puts in GTID
+// sets with many server UUIDs, e.g. "uuid1:1-5,\nuuid2:1-3" is returned as
+// "uuid1:1-5,uuid2:1-3".
+func NormalizeGTIDSet(set string) string {
+	return strings.Join(strings.Fields(set), "")
+}
@@ -0,0 +1,38 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql_test
+
+import (
+	"github.com/percona/percona-agent/mysql"
+	. "gopkg.in/check.v1"
+)
+
+type GTIDTestSuite struct {
+}
+
+var _ = Suite(&GTIDTestSuite{})
+
+func (s *GTIDTestSuite) TestNormalizeGTIDSet(t *C) {
+	t.Check(mysql.NormalizeGTIDSet(""), Equals, "")
+	t.Check(mysql.NormalizeGTIDSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"), Equals, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5")
+	t.Check(
+		mysql.NormalizeGTIDSet("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,\n4d22fb58-82db-22f2-af44-d91bb0530673:1-3:7\n"),
+		Equals,
+		"3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,4d22fb58-82db-22f2-af44-d91bb0530673:1-3:7",
+	)
+}
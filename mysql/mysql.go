@@ -21,6 +21,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -41,6 +42,7 @@ type Connector interface {
 	Connect(tries uint) error
 	Close()
 	Explain(q string, db string) (explain *proto.ExplainResult, err error)
+	Analyze(q string, db string) (*AnalyzeResult, error)
 	Set([]Query) error
 	GetGlobalVarString(varName string) string
 	Uptime() (uptime int64)
@@ -48,6 +50,7 @@ type Connector interface {
 
 type Connection struct {
 	dsn             string
+	driverDSN       string // DriverDSN(dsn), key of conn in Connections
 	conn            *sql.DB
 	backoff         *pct.Backoff
 	connectedAmount uint
@@ -82,14 +85,17 @@ func (c *Connection) Connect(tries uint) error {
 		c.connectedAmount++
 		return nil
 	}
-	var err error
+	dsn, err := DriverDSN(c.dsn) // proxy, if any
+	if err != nil {
+		return err
+	}
 	var db *sql.DB
 	for i := tries; i > 0; i-- {
 		// Wait before attempt.
 		time.Sleep(c.backoff.Wait())
 
-		// Open connection to MySQL but...
-		db, err = sql.Open("mysql", c.dsn)
+		// Open connection to MySQL, shared with other Connections, but...
+		db, err = Connections.Open(dsn)
 		if err != nil {
 			continue
 		}
@@ -97,12 +103,13 @@ func (c *Connection) Connect(tries uint) error {
 		// ...try to use the connection for real.
 		if err = db.Ping(); err != nil {
 			// Connection failed.  Wrong username or password?
-			db.Close()
+			Connections.Release(dsn)
 			continue
 		}
 
 		// Connected
 		c.conn = db
+		c.driverDSN = dsn
 		c.backoff.Success()
 		c.connectedAmount++
 		return nil
@@ -120,7 +127,7 @@ func (c *Connection) Close() {
 	}
 	c.connectedAmount--
 	if c.connectedAmount == 0 && c.conn != nil {
-		c.conn.Close()
+		Connections.Release(c.driverDSN)
 		c.conn = nil
 	}
 }
@@ -171,14 +178,54 @@ func (c *Connection) Set(queries []Query) error {
 		}
 		if query.Verify != "" {
 			got := c.GetGlobalVarString(query.Verify)
-			if got != query.Expect {
-				return fmt.Errorf("@@GLOBAL.%s = '%s', expected '%s'", got, query.Expect)
+			if !query.Expected(got) {
+				return fmt.Errorf("@@GLOBAL.%s = '%s', expected '%s'", query.Verify, got, query.Expect)
 			}
 		}
 	}
 	return nil
 }
 
+// Expected returns true if got, the value of the Verify variable, is Expect.
+// Numbers are compared as numbers because MySQL formats them differently,
+// e.g. long_query_time=0 is 0.000000.
+func (q Query) Expected(got string) bool {
+	if got == q.Expect {
+		return true
+	}
+	g, err := strconv.ParseFloat(got, 64)
+	if err != nil {
+		return false
+	}
+	e, err := strconv.ParseFloat(q.Expect, 64)
+	return err == nil && g == e
+}
+
+// ErrNothingToVerify is returned by VerifyOnly if none of the queries has a
+// Verify variable, so MySQL cannot be checked.
+var ErrNothingToVerify = errors.New("no queries to verify")
+
+// VerifyOnly returns the queries without their SET statements, to check if
+// MySQL is already configured as the queries would configure it.  Queries
+// without a Verify variable are dropped; if none remain, it returns
+// ErrNothingToVerify because an empty check would always pass.
+func VerifyOnly(queries []Query) ([]Query, error) {
+	verify := []Query{}
+	for _, q := range queries {
+		if q.Verify == "" {
+			continue
+		}
+		verify = append(verify, Query{
+			Verify: q.Verify,
+			Expect: q.Expect,
+		})
+	}
+	if len(verify) == 0 {
+		return nil, ErrNothingToVerify
+	}
+	return verify, nil
+}
+
 func (c *Connection) GetGlobalVarString(varName string) string {
 	if c.conn == nil {
 		return ""
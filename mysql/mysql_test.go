@@ -69,6 +69,56 @@ func (s *MysqlTestSuite) TestConnection(t *C) {
 	t.Assert(conn.DB(), IsNil)
 }
 
+func (s *MysqlTestSuite) TestSharedConnection(t *C) {
+	// Two Connections to the same DSN share one connection pool.
+	conn1 := mysql.NewConnection(s.dsn)
+	conn2 := mysql.NewConnection(s.dsn)
+	t.Assert(conn1.Connect(1), IsNil)
+	t.Assert(conn2.Connect(1), IsNil)
+	t.Check(conn1.DB(), Equals, conn2.DB())
+
+	stats := mysql.Connections.Stats()
+	t.Assert(stats, HasLen, 1)
+	t.Check(stats[0].Refs, Equals, 2)
+	t.Check(stats[0].MaxOpen, Equals, mysql.DEFAULT_MAX_CONNECTIONS)
+
+	// The pool stays open until the last Connection closes.
+	conn1.Close()
+	t.Check(conn2.DB().Ping(), IsNil)
+	conn2.Close()
+	t.Check(mysql.Connections.Stats(), HasLen, 0)
+}
+
+func (s *MysqlTestSuite) TestRegistry(t *C) {
+	// sql.Open doesn't connect, so MySQL doesn't have to exist.
+	r := mysql.NewRegistry(2)
+	dsn1 := "user:pass@tcp(127.0.0.1:1)/"
+	dsn2 := "user:pass@tcp(127.0.0.1:2)/"
+	db1, err := r.Open(dsn1)
+	t.Assert(err, IsNil)
+	db1b, err := r.Open(dsn1)
+	t.Assert(err, IsNil)
+	t.Check(db1, Equals, db1b)
+	db2, err := r.Open(dsn2)
+	t.Assert(err, IsNil)
+	t.Check(db1, Not(Equals), db2)
+
+	r.SetMaxConnections(-1)
+	t.Check(r.Stats(), DeepEquals, []mysql.ConnStats{
+		{DSN: "user:" + mysql.HiddenPassword + "@tcp(127.0.0.1:1)/", Refs: 2},
+		{DSN: "user:" + mysql.HiddenPassword + "@tcp(127.0.0.1:2)/", Refs: 1},
+	})
+
+	r.Release(dsn1)
+	r.Release(dsn2)
+	r.Release(dsn2) // extra release is ignored
+	stats := r.Stats()
+	t.Assert(stats, HasLen, 1)
+	t.Check(stats[0].Refs, Equals, 1)
+	r.Release(dsn1)
+	t.Check(r.Stats(), HasLen, 0)
+}
+
 func (s *MysqlTestSuite) TestDSNString(t *C) {
 	dsn := mysql.DSN{
 		Username: "root",
@@ -110,3 +160,33 @@ func (s *MysqlTestSuite) TestErrorFormatting(t *C) {
 	}
 	t.Check(mysql.FormatError(e1), Equals, "connection refused: 127.0.0.1:3306")
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// Query test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type QueryTestSuite struct {
+}
+
+var _ = Suite(&QueryTestSuite{})
+
+func (s *QueryTestSuite) TestVerifyOnly(t *C) {
+	queries := []mysql.Query{
+		{Set: "SET GLOBAL slow_query_log=ON", Verify: "slow_query_log", Expect: "1"},
+		{Set: "SET GLOBAL long_query_time=0"},
+	}
+	got, err := mysql.VerifyOnly(queries)
+	t.Assert(err, IsNil)
+	t.Check(got, DeepEquals, []mysql.Query{{Verify: "slow_query_log", Expect: "1"}})
+	t.Check(queries[0].Set, Equals, "SET GLOBAL slow_query_log=ON") // not modified
+
+	// Like QAN Start queries from the API: nothing to verify, so verifying
+	// must fail rather than pass without checking anything.
+	queries = []mysql.Query{
+		{Set: "SET GLOBAL slow_query_log=ON"},
+		{Set: "SET GLOBAL long_query_time=0"},
+	}
+	got, err = mysql.VerifyOnly(queries)
+	t.Check(err, Equals, mysql.ErrNothingToVerify)
+	t.Check(got, IsNil)
+}
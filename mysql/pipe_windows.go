@@ -0,0 +1,37 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"net"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	driver "github.com/go-sql-driver/mysql"
+)
+
+const PIPE_TIMEOUT = 10 * time.Second
+
+// The driver has no named pipe support, so register "pipe" as a driver net
+// for DSNs like user:pass@pipe(MySQL)/, see DSN.Pipe.
+func init() {
+	driver.RegisterDial("pipe", func(name string) (net.Conn, error) {
+		timeout := PIPE_TIMEOUT
+		return winio.DialPipe(PipePath(name), &timeout)
+	})
+}
@@ -0,0 +1,238 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/percona/percona-agent/pct"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DSN param for connecting to MySQL through a proxy, e.g.
+// user:pass@tcp(db1:3306)/?parseTime=true&proxy=socks5%3A%2F%2Fbastion%3A1080.
+// The value is a URL-encoded proxy URL:
+//
+//	socks5://[user:pass@]host:port   SOCKS5 proxy (socks5h also works)
+//	http://[user:pass@]host:port     HTTP proxy with CONNECT
+//	ssh://user@host[:22]             SSH jump host, like ssh -J
+//
+// SSH jump hosts authenticate with a private key, key=/path (default
+// ~/.ssh/id_rsa), and verify the host key with known_hosts=/path (default
+// ~/.ssh/known_hosts), e.g. ssh://agent@bastion?key=/etc/percona/id_rsa.
+// Only tcp DSNs can use a proxy.  The param is removed before the DSN is
+// given to the driver.
+const DSN_PROXY_PARAM = "proxy"
+
+const PROXY_TIMEOUT = 10 * time.Second
+
+var (
+	proxyNets = make(map[string]string) // proxy URL => registered driver net
+	proxyMux  = &sync.Mutex{}
+)
+
+// DriverDSN returns the DSN for the driver.  If the DSN has a proxy param, the
+// proxy is registered as a driver net, e.g. proxy1, which replaces tcp.
+func DriverDSN(dsn string) (string, error) {
+	i := strings.Index(dsn, "?")
+	if i < 0 {
+		return dsn, nil
+	}
+	params := strings.Split(dsn[i+1:], "&")
+	proxyURL := ""
+	kept := []string{}
+	for _, param := range params {
+		if strings.HasPrefix(param, DSN_PROXY_PARAM+"=") {
+			v, err := url.QueryUnescape(strings.TrimPrefix(param, DSN_PROXY_PARAM+"="))
+			if err != nil {
+				return "", fmt.Errorf("Invalid DSN proxy: %s", err)
+			}
+			proxyURL = v
+			continue
+		}
+		kept = append(kept, param)
+	}
+	if proxyURL == "" {
+		return dsn, nil
+	}
+	dsn = dsn[:i]
+	if len(kept) > 0 {
+		dsn += "?" + strings.Join(kept, "&")
+	}
+	at := strings.LastIndex(dsn, "@tcp(")
+	if at < 0 {
+		return "", fmt.Errorf("Invalid DSN proxy: only tcp connections can use a proxy")
+	}
+	proxyNet, err := registerProxy(proxyURL)
+	if err != nil {
+		return "", err
+	}
+	return dsn[:at] + "@" + proxyNet + dsn[at+len("@tcp"):], nil
+}
+
+// ProxyParam returns the DSN param for the proxy URL, e.g. &proxy=socks5%3A%2F%2Fbastion%3A1080.
+func ProxyParam(proxyURL string) string {
+	return "&" + DSN_PROXY_PARAM + "=" + url.QueryEscape(proxyURL)
+}
+
+func registerProxy(proxyURL string) (string, error) {
+	proxyMux.Lock()
+	defer proxyMux.Unlock()
+	if proxyNet, ok := proxyNets[proxyURL]; ok {
+		return proxyNet, nil
+	}
+	dial, err := proxyDialer(proxyURL)
+	if err != nil {
+		return "", err
+	}
+	proxyNet := fmt.Sprintf("proxy%d", len(proxyNets)+1)
+	driver.RegisterDial(proxyNet, driver.DialFunc(dial))
+	proxyNets[proxyURL] = proxyNet
+	return proxyNet, nil
+}
+
+func proxyDialer(proxyURL string) (func(addr string) (net.Conn, error), error) {
+	if strings.HasPrefix(proxyURL, "ssh://") {
+		jump, err := newSSHJump(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		return jump.Dial, nil
+	}
+	u, err := pct.ParseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return func(addr string) (net.Conn, error) {
+		return pct.DialProxy(u, addr, PROXY_TIMEOUT)
+	}, nil
+}
+
+// sshJump dials MySQL through an SSH connection to a jump host, which is
+// shared by all connections and reconnected if it fails.
+type sshJump struct {
+	addr   string
+	config *ssh.ClientConfig
+	// --
+	client *ssh.Client
+	mux    *sync.Mutex
+}
+
+func newSSHJump(proxyURL string) (*sshJump, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SSH jump host %s: %s", proxyURL, err)
+	}
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid SSH jump host %s: expected ssh://user@host[:port]", proxyURL)
+	}
+	addr := u.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	home := os.Getenv("HOME")
+	keyFile := u.Query().Get("key")
+	if keyFile == "" {
+		keyFile = filepath.Join(home, ".ssh", "id_rsa")
+	}
+	knownHostsFile := u.Query().Get("known_hosts")
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read SSH key for jump host %s: %s", u.Host, err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SSH key %s: %s", keyFile, err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read SSH known hosts for jump host %s: %s", u.Host, err)
+	}
+	j := &sshJump{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         PROXY_TIMEOUT,
+		},
+		mux: &sync.Mutex{},
+	}
+	return j, nil
+}
+
+func (j *sshJump) Dial(addr string) (net.Conn, error) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.client != nil {
+		conn, err := j.client.Dial("tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		// Jump host connection probably failed; reconnect once.
+		j.client.Close()
+		j.client = nil
+	}
+	client, err := ssh.Dial("tcp", j.addr, j.config)
+	if err != nil {
+		return nil, fmt.Errorf("SSH jump host %s: %s", j.addr, err)
+	}
+	j.client = client
+	return client.Dial("tcp", addr)
+}
+
+// hideProxyPassword hides the proxy password in the DSN params, if any.  The
+// proxy URL is unescaped to be readable in logs.
+func hideProxyPassword(params string) string {
+	i := strings.Index(params, DSN_PROXY_PARAM+"=")
+	if i < 0 {
+		return params
+	}
+	end := strings.Index(params[i:], "&")
+	if end < 0 {
+		end = len(params)
+	} else {
+		end += i
+	}
+	proxyURL, err := url.QueryUnescape(params[i+len(DSN_PROXY_PARAM)+1 : end])
+	if err != nil {
+		return params
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.User == nil {
+		return params
+	}
+	if _, ok := u.User.Password(); !ok {
+		return params
+	}
+	hidden := u.Scheme + "://" + u.User.Username() + ":" + HiddenPassword + "@" + u.Host
+	return params[:i] + DSN_PROXY_PARAM + "=" + hidden + params[end:]
+}
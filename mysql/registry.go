@@ -0,0 +1,135 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+)
+
+// Default max open connections per DSN shared by all Connections.
+const DEFAULT_MAX_CONNECTIONS = 10
+
+// ConnStats are the connections to one DSN shared by all Connections.
+type ConnStats struct {
+	DSN     string // password hidden
+	Refs    int    // connected Connections sharing the connections
+	Open    int    // open connections to MySQL
+	MaxOpen int    // 0 = no limit
+}
+
+type sharedDB struct {
+	db   *sql.DB
+	refs int
+}
+
+// Registry shares one *sql.DB, i.e. one connection pool, per DSN among all
+// Connections, so mm, QAN, query and sysinfo don't each open connections to
+// the same MySQL, and it bounds the number of connections per DSN.
+type Registry struct {
+	maxConns int
+	dbs      map[string]*sharedDB // keyed on driver DSN
+	mux      *sync.Mutex
+}
+
+func NewRegistry(maxConns int) *Registry {
+	r := &Registry{
+		maxConns: maxConns,
+		dbs:      make(map[string]*sharedDB),
+		mux:      &sync.Mutex{},
+	}
+	return r
+}
+
+// Connections is the registry used by all Connections.
+var Connections = NewRegistry(DEFAULT_MAX_CONNECTIONS)
+
+// SetMaxConnections sets the max open connections per DSN, including for DSNs
+// already open.  Zero means DEFAULT_MAX_CONNECTIONS, less than zero means no
+// limit.
+func (r *Registry) SetMaxConnections(n int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if n == 0 {
+		n = DEFAULT_MAX_CONNECTIONS
+	} else if n < 0 {
+		n = 0 // no limit for sql.DB
+	}
+	r.maxConns = n
+	for _, s := range r.dbs {
+		s.db.SetMaxOpenConns(n)
+	}
+}
+
+// Open returns the shared *sql.DB for the driver DSN, opening it if it's the
+// first reference.  Every Open must be followed by a Release.
+func (r *Registry) Open(dsn string) (*sql.DB, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if s, ok := r.dbs[dsn]; ok {
+		s.refs++
+		return s.db, nil
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(r.maxConns)
+	r.dbs[dsn] = &sharedDB{db: db, refs: 1}
+	return db, nil
+}
+
+// Release releases a reference to the shared *sql.DB for the driver DSN, and
+// closes it when it's the last reference.
+func (r *Registry) Release(dsn string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	s, ok := r.dbs[dsn]
+	if !ok {
+		return
+	}
+	s.refs--
+	if s.refs <= 0 {
+		s.db.Close()
+		delete(r.dbs, dsn)
+	}
+}
+
+// Stats returns the connections per DSN, sorted by DSN.
+func (r *Registry) Stats() []ConnStats {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	stats := make([]ConnStats, 0, len(r.dbs))
+	for dsn, s := range r.dbs {
+		stats = append(stats, ConnStats{
+			DSN:     HideDSNPassword(dsn),
+			Refs:    s.refs,
+			Open:    s.db.Stats().OpenConnections,
+			MaxOpen: r.maxConns,
+		})
+	}
+	sort.Sort(connStatsByDSN(stats))
+	return stats
+}
+
+type connStatsByDSN []ConnStats
+
+func (a connStatsByDSN) Len() int           { return len(a) }
+func (a connStatsByDSN) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a connStatsByDSN) Less(i, j int) bool { return a[i].DSN < a[j].DSN }
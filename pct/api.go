@@ -43,9 +43,13 @@ var timeoutClientConfig = &TimeoutClientConfig{
 
 type APIConnector interface {
 	Connect(hostname, apiKey, agentUuid string) error
+	Failover() (bool, error)
+	ProbePreferred() (bool, error)
 	Get(apiKey, url string) (int, []byte, error)
+	GetIfModified(apiKey, url string, cached CacheValidators) (int, []byte, CacheValidators, error)
 	Post(apiKey, url string, data []byte) (*http.Response, []byte, error)
 	Put(apiKey, url string, data []byte) (*http.Response, []byte, error)
+	Delete(apiKey, url string) (*http.Response, []byte, error)
 	EntryLink(resource string) string
 	AgentLink(resource string) string
 	Origin() string
@@ -57,6 +61,7 @@ type APIConnector interface {
 type API struct {
 	origin     string
 	hostname   string
+	hostnames  []string // failover, in order of preference
 	apiKey     string
 	agentUuid  string
 	entryLinks map[string]string
@@ -65,6 +70,14 @@ type API struct {
 	client     *http.Client
 }
 
+// CacheValidators are the ETag and Last-Modified headers of a response.  A
+// conditional GET sends them back as If-None-Match and If-Modified-Since, and
+// the API returns 304 Not Modified without data if the resource is unchanged.
+type CacheValidators struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+}
+
 type TimeoutClientConfig struct {
 	ConnectTimeout   time.Duration
 	ReadWriteTimeout time.Duration
@@ -74,7 +87,9 @@ func NewAPI() *API {
 	hostname, _ := os.Hostname()
 	client := &http.Client{
 		Transport: &http.Transport{
-			Dial: TimeoutDialer(timeoutClientConfig),
+			Dial:            TimeoutDialer(timeoutClientConfig),
+			Proxy:           Proxy,
+			TLSClientConfig: TLSConfig(),
 		},
 	}
 	a := &API{
@@ -101,7 +116,9 @@ func Ping(hostname, apiKey string, headers map[string]string) (int, error) {
 
 	client := &http.Client{
 		Transport: &http.Transport{
-			Dial: TimeoutDialer(timeoutClientConfig),
+			Dial:            TimeoutDialer(timeoutClientConfig),
+			Proxy:           Proxy,
+			TLSClientConfig: TLSConfig(),
 		},
 	}
 	resp, err := client.Do(req)
@@ -168,6 +185,76 @@ func (a *API) Connect(hostname, apiKey, agentUuid string) error {
 	return nil
 }
 
+// ConnectAny connects to the first API hostname that works.  The hostnames,
+// in order of preference, are used by Failover and ProbePreferred.
+func (a *API) ConnectAny(hostnames []string, apiKey, agentUuid string) error {
+	if len(hostnames) == 0 {
+		return errors.New("No API hostname")
+	}
+	a.mux.Lock()
+	a.hostnames = hostnames
+	a.mux.Unlock()
+	errs := []string{}
+	for _, hostname := range hostnames {
+		err := a.Connect(hostname, apiKey, agentUuid)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, hostname+": "+err.Error())
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// Failover reconnects to the API when a connection to it fails.  The current
+// hostname is kept if it works, else the other hostnames are tried in order.
+// It returns true if the hostname changed, i.e. the agent links changed.
+// With only one hostname, it does nothing.
+func (a *API) Failover() (bool, error) {
+	a.mux.RLock()
+	current := a.hostname
+	hostnames := a.hostnames
+	apiKey := a.apiKey
+	agentUuid := a.agentUuid
+	a.mux.RUnlock()
+	if len(hostnames) < 2 {
+		return false, nil
+	}
+	try := []string{current}
+	for _, hostname := range hostnames {
+		if hostname != current {
+			try = append(try, hostname)
+		}
+	}
+	errs := []string{}
+	for _, hostname := range try {
+		if err := a.Connect(hostname, apiKey, agentUuid); err != nil {
+			errs = append(errs, hostname+": "+err.Error())
+			continue
+		}
+		return hostname != current, nil
+	}
+	return false, errors.New(strings.Join(errs, "; "))
+}
+
+// ProbePreferred reconnects to the preferred (first) hostname if Failover
+// changed to another and the preferred one works again.  It returns true if
+// it did, i.e. the agent links changed.
+func (a *API) ProbePreferred() (bool, error) {
+	a.mux.RLock()
+	current := a.hostname
+	hostnames := a.hostnames
+	apiKey := a.apiKey
+	agentUuid := a.agentUuid
+	a.mux.RUnlock()
+	if len(hostnames) < 2 || current == hostnames[0] {
+		return false, nil
+	}
+	if err := a.Connect(hostnames[0], apiKey, agentUuid); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (a *API) checkLinks(links map[string]string, req ...string) error {
 	for _, link := range req {
 		logLink, exist := links[link]
@@ -198,38 +285,65 @@ func (a *API) getLinks(apiKey, url string) (map[string]string, error) {
 }
 
 func (a *API) Get(apiKey, url string) (int, []byte, error) {
+	code, data, _, err := a.get(apiKey, url, CacheValidators{})
+	return code, data, err
+}
+
+// GetIfModified is a conditional GET: it returns 304 and no data if the
+// resource hasn't changed since the response with the cached validators, else
+// it's like Get and also returns the validators of the new response.
+func (a *API) GetIfModified(apiKey, url string, cached CacheValidators) (int, []byte, CacheValidators, error) {
+	return a.get(apiKey, url, cached)
+}
+
+func (a *API) get(apiKey, url string, cached CacheValidators) (int, []byte, CacheValidators, error) {
+	validators := CacheValidators{}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, validators, err
 	}
 	req.Header.Add("X-Percona-API-Key", apiKey)
+	if cached.ETag != "" {
+		req.Header.Add("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Add("If-Modified-Since", cached.LastModified)
+	}
 
 	// todo: timeout
+	sent := time.Now()
 	resp, err := a.client.Do(req)
 	if err != nil {
-		return 0, nil, fmt.Errorf("GET %s error: client.Do: %s", url, err)
+		return 0, nil, validators, fmt.Errorf("GET %s error: client.Do: %s", url, err)
 	}
 	defer resp.Body.Close()
+	MeasureClockSkew(sent, time.Now(), resp.Header)
+	validators.ETag = resp.Header.Get("ETag")
+	validators.LastModified = resp.Header.Get("Last-Modified")
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp.StatusCode, nil, cached, nil
+	}
 
 	var data []byte
 	if resp.Header.Get("Content-Type") == "application/x-gzip" {
 		buf := new(bytes.Buffer)
 		gz, err := gzip.NewReader(resp.Body)
 		if err != nil {
-			return 0, nil, err
+			return 0, nil, validators, err
 		}
 		if _, err := io.Copy(buf, gz); err != nil {
-			return resp.StatusCode, nil, err
+			return resp.StatusCode, nil, validators, err
 		}
 		data = buf.Bytes()
 	} else {
 		data, err = ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return resp.StatusCode, nil, fmt.Errorf("GET %s error: ioutil.ReadAll: %s", url, err)
+			return resp.StatusCode, nil, validators, fmt.Errorf("GET %s error: ioutil.ReadAll: %s", url, err)
 		}
 	}
 
-	return resp.StatusCode, data, nil
+	return resp.StatusCode, data, validators, nil
 }
 
 func (a *API) EntryLink(resource string) string {
@@ -275,16 +389,22 @@ func (a *API) Put(apiKey, url string, data []byte) (*http.Response, []byte, erro
 	return a.send("PUT", apiKey, url, data)
 }
 
+func (a *API) Delete(apiKey, url string) (*http.Response, []byte, error) {
+	return a.send("DELETE", apiKey, url, nil)
+}
+
 func (a *API) send(method, apiKey, url string, data []byte) (*http.Response, []byte, error) {
 	req, err := http.NewRequest(method, url, bytes.NewReader(data))
 	header := http.Header{}
 	header.Set("X-Percona-API-Key", apiKey)
 	req.Header = header
 
+	sent := time.Now()
 	resp, err := a.client.Do(req)
 	if err != nil {
 		return resp, nil, err
 	}
+	MeasureClockSkew(sent, time.Now(), resp.Header)
 	content, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
@@ -0,0 +1,117 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+type APITestSuite struct {
+}
+
+var _ = Suite(&APITestSuite{})
+
+// fakeAPI serves the entry and agent links, or 503 if down.
+type fakeAPI struct {
+	server *httptest.Server
+	down   bool
+}
+
+func newFakeAPI() *fakeAPI {
+	f := &fakeAPI{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		base := f.server.URL
+		if r.URL.Path == "/" {
+			fmt.Fprintf(w, `{"Links":{"agents":"%[1]s/agents","instances":"%[1]s/instances","download":"%[1]s/download"}}`, base)
+		} else {
+			ws := strings.Replace(base, "http://", "ws://", 1) + r.URL.Path
+			fmt.Fprintf(w, `{"Links":{"cmd":"%[1]s/cmd","log":"%[1]s/log","data":"%[1]s/data"}}`, ws)
+		}
+	}))
+	return f
+}
+
+func (f *fakeAPI) hostname() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *APITestSuite) TestFailover(t *C) {
+	api1 := newFakeAPI()
+	defer api1.server.Close()
+	api2 := newFakeAPI()
+	defer api2.server.Close()
+	hostnames := []string{api1.hostname(), api2.hostname()}
+
+	// Preferred API is down, so connect to the second.
+	api1.down = true
+	api := pct.NewAPI()
+	err := api.ConnectAny(hostnames, "123", "abc")
+	t.Assert(err, IsNil)
+	t.Check(api.Hostname(), Equals, api2.hostname())
+	t.Check(strings.HasPrefix(api.AgentLink("cmd"), "ws://"+api2.hostname()), Equals, true)
+
+	// Sticky: stay on the second while it works, even if the first is back.
+	api1.down = false
+	changed, err := api.Failover()
+	t.Check(err, IsNil)
+	t.Check(changed, Equals, false)
+	t.Check(api.Hostname(), Equals, api2.hostname())
+
+	// Until the preferred API is probed.
+	changed, err = api.ProbePreferred()
+	t.Check(err, IsNil)
+	t.Check(changed, Equals, true)
+	t.Check(api.Hostname(), Equals, api1.hostname())
+	t.Check(strings.HasPrefix(api.AgentLink("cmd"), "ws://"+api1.hostname()), Equals, true)
+
+	changed, err = api.ProbePreferred()
+	t.Check(err, IsNil)
+	t.Check(changed, Equals, false)
+
+	// Fail over when the current API goes down.
+	api1.down = true
+	changed, err = api.Failover()
+	t.Check(err, IsNil)
+	t.Check(changed, Equals, true)
+	t.Check(api.Hostname(), Equals, api2.hostname())
+
+	// Preferred API still down.
+	_, err = api.ProbePreferred()
+	t.Check(err, NotNil)
+	t.Check(api.Hostname(), Equals, api2.hostname())
+
+	// All down.
+	api2.down = true
+	changed, err = api.Failover()
+	t.Check(err, NotNil)
+	t.Check(changed, Equals, false)
+	t.Check(api.Hostname(), Equals, api2.hostname())
+	err = api.ConnectAny(hostnames, "123", "abc")
+	t.Check(err, NotNil)
+}
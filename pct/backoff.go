@@ -18,42 +18,113 @@
 package pct
 
 import (
-	"math"
+	"errors"
 	"math/rand"
+	"sync"
 	"time"
 )
 
+// Default backoff: 0s, 1s, 2s, 4s, ..., 1m4s, 2m, 2m, ... plus up to 50% jitter
+// so that many agents losing the same remote end don't reconnect in lockstep.
+const (
+	DEFAULT_BACKOFF_BASE       = 1 * time.Second
+	DEFAULT_BACKOFF_MULTIPLIER = 2.0
+	DEFAULT_BACKOFF_MAX        = 2 * time.Minute
+	DEFAULT_BACKOFF_JITTER     = 0.5
+)
+
+// BackoffConfig is the backoff policy for all reconnects, set in agent.conf.
+// Zero values use the defaults.
+type BackoffConfig struct {
+	Base       float64 `json:",omitempty"` // seconds
+	Multiplier float64 `json:",omitempty"`
+	Max        float64 `json:",omitempty"` // seconds
+	Jitter     float64 `json:",omitempty"` // fraction of wait, or -1 for none
+}
+
+var (
+	backoffConfig BackoffConfig
+	backoffMux    = &sync.RWMutex{}
+)
+
+// SetBackoff makes NewBackoff use config instead of the defaults.
+func SetBackoff(config BackoffConfig) error {
+	if config.Base < 0 || config.Max < 0 {
+		return errors.New("Backoff Base and Max must be >= 0")
+	}
+	if config.Multiplier != 0 && config.Multiplier < 1 {
+		return errors.New("Backoff Multiplier must be >= 1")
+	}
+	if config.Jitter > 1 || (config.Jitter < 0 && config.Jitter != -1) {
+		return errors.New("Backoff Jitter must be between 0 and 1, or -1")
+	}
+	backoffMux.Lock()
+	defer backoffMux.Unlock()
+	backoffConfig = config
+	return nil
+}
+
 type Backoff struct {
+	Base       time.Duration // wait after first failed try
+	Multiplier float64       // each wait is previous wait * Multiplier
+	Max        time.Duration // cap on wait before jitter
+	Jitter     float64       // add random [0, Jitter) * wait to wait
+	NowFunc    func() time.Time
+	// --
 	try         int
 	lastSuccess time.Time
 	resetAfter  time.Duration
-	NowFunc     func() time.Time
 }
 
 func NewBackoff(resetAfter time.Duration) *Backoff {
 	b := &Backoff{
-		resetAfter: resetAfter,
+		Base:       DEFAULT_BACKOFF_BASE,
+		Multiplier: DEFAULT_BACKOFF_MULTIPLIER,
+		Max:        DEFAULT_BACKOFF_MAX,
+		Jitter:     DEFAULT_BACKOFF_JITTER,
 		NowFunc:    time.Now,
+		resetAfter: resetAfter,
+	}
+	backoffMux.RLock()
+	defer backoffMux.RUnlock()
+	if backoffConfig.Base > 0 {
+		b.Base = time.Duration(backoffConfig.Base * float64(time.Second))
+	}
+	if backoffConfig.Multiplier > 0 {
+		b.Multiplier = backoffConfig.Multiplier
+	}
+	if backoffConfig.Max > 0 {
+		b.Max = time.Duration(backoffConfig.Max * float64(time.Second))
+	}
+	if backoffConfig.Jitter > 0 {
+		b.Jitter = backoffConfig.Jitter
+	} else if backoffConfig.Jitter < 0 {
+		b.Jitter = 0
 	}
 	return b
 }
 
+// Wait returns how long to wait before the next try. The first try does not
+// wait, then waits increase exponentially from Base to Max.
 func (b *Backoff) Wait() time.Duration {
-	var t int
 	if b.try == 0 {
-		t = 0
-		b.try++
-	} else if b.try < 7 {
-		// 1s, 3s, 7s, 15s, 31s, 1m3s = 2m
-		t = int(math.Pow(2, float64(b.try)) - 1)
 		b.try++
+		return 0
+	}
+	d := float64(b.Base)
+	for i := 1; i < b.try && d < float64(b.Max); i++ {
+		d *= b.Multiplier
+	}
+	if d >= float64(b.Max) {
+		d = float64(b.Max)
 	} else {
-		// [1m30s, 3m)
-		t = int(90 + (90 * rand.Float64()))
+		b.try++
 	}
-	return time.Duration(t) * time.Second
+	if b.Jitter > 0 {
+		d += d * b.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
 }
-
 func (b *Backoff) Success() {
 	if b.lastSuccess.IsZero() {
 		// First success, don't reset backoff yet because if the remote end
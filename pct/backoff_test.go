@@ -0,0 +1,75 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+type BackoffTestSuite struct {
+}
+
+var _ = Suite(&BackoffTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *BackoffTestSuite) TestWait(t *C) {
+	b := pct.NewBackoff(time.Minute)
+	b.Base = time.Second
+	b.Multiplier = 3
+	b.Max = 20 * time.Second
+	b.Jitter = 0
+	got := []time.Duration{}
+	for i := 0; i < 6; i++ {
+		got = append(got, b.Wait())
+	}
+	t.Check(got, DeepEquals, []time.Duration{
+		0,
+		1 * time.Second,
+		3 * time.Second,
+		9 * time.Second,
+		20 * time.Second, // capped
+		20 * time.Second,
+	})
+
+	// Jitter only adds to the wait.
+	b.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := b.Wait()
+		t.Check(d >= 20*time.Second && d < 30*time.Second, Equals, true, Commentf("%s", d))
+	}
+}
+
+func (s *BackoffTestSuite) TestSetBackoff(t *C) {
+	defer pct.SetBackoff(pct.BackoffConfig{})
+
+	err := pct.SetBackoff(pct.BackoffConfig{Multiplier: 0.5})
+	t.Check(err, NotNil)
+	err = pct.SetBackoff(pct.BackoffConfig{Jitter: 2})
+	t.Check(err, NotNil)
+
+	err = pct.SetBackoff(pct.BackoffConfig{Base: 0.5, Max: 60, Jitter: -1})
+	t.Assert(err, IsNil)
+	b := pct.NewBackoff(time.Minute)
+	t.Check(b.Base, Equals, 500*time.Millisecond)
+	t.Check(b.Multiplier, Equals, pct.DEFAULT_BACKOFF_MULTIPLIER)
+	t.Check(b.Max, Equals, time.Minute)
+	t.Check(b.Jitter, Equals, 0.0)
+}
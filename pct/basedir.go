@@ -35,6 +35,9 @@ const (
 	TRASH_DIR    = "trash"
 	START_LOCK   = "start.lock"
 	START_SCRIPT = "start.sh"
+	AUDIT_LOG    = "audit.log"
+	INSTANCE_KEY = "instance.key"
+	CONTROL_SOCK = "percona-agent.sock"
 )
 
 type basedir struct {
@@ -127,12 +130,35 @@ func (b *basedir) WriteConfig(service string, config interface{}) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(configFile, data, 0600)
+	return writeFileAtomic(configFile, data)
 }
 
 func (b *basedir) WriteConfigString(service, config string) error {
 	configFile := filepath.Join(b.configDir, service+CONFIG_FILE_SUFFIX)
-	return ioutil.WriteFile(configFile, []byte(config), 0600)
+	return writeFileAtomic(configFile, []byte(config))
+}
+
+// writeFileAtomic writes data to a temp file (mode 0600) in the same dir, then
+// renames it to file, so file is never partially written.
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
 }
 
 func (b *basedir) RemoveConfig(service string) error {
@@ -146,6 +172,12 @@ func (b *basedir) File(file string) string {
 		file = START_LOCK
 	case "start-script":
 		file = START_SCRIPT
+	case "audit-log":
+		file = AUDIT_LOG
+	case "instance-key":
+		file = INSTANCE_KEY
+	case "control-socket":
+		file = CONTROL_SOCK
 	default:
 		log.Panicf("Unknown basedir file: %s", file)
 	}
@@ -0,0 +1,265 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cgroup limits and usage of mysqld, or the agent process if mysqld isn't
+// running on the host.  In a container, host-level /proc numbers (MemTotal,
+// number of CPUs, etc.) are misleading; these are the real limits.  Zero
+// limits mean no limit.
+type Cgroup struct {
+	Container   string  // docker, lxc, kubernetes, etc.; empty if not in a container
+	Version     int     // 1 or 2
+	MemoryLimit uint64  // bytes
+	MemoryUsage uint64  // bytes
+	CPUQuota    float64 // number of CPUs, e.g. 1.5
+	CPUUsage    uint64  // nanoseconds, counter
+}
+
+// Root dirs are vars so tests can use sample files.
+var (
+	CgroupProcDir = "/proc"
+	CgroupSysDir  = "/sys/fs/cgroup"
+)
+
+// v1 "no limit" is the max int64 rounded down to the page size.
+const cgroupNoLimit = uint64(1 << 62)
+
+// GetCgroup returns the cgroup of mysqld, which is what the limits are for,
+// or of the current process if mysqld isn't running on the host, e.g. if the
+// agent monitors a remote MySQL.  It returns nil if the process isn't in a
+// cgroup (or cgroups aren't supported).
+func GetCgroup() *Cgroup {
+	pid := MySQLPid()
+	if pid == "" {
+		pid = "self"
+	}
+	return ProcCgroup(pid)
+}
+
+// MySQLPid returns the pid of mysqld (or MariaDB mariadbd), or "" if it's not
+// running.  If several are running, it returns the lowest pid.
+func MySQLPid() string {
+	dirs, _ := filepath.Glob(CgroupProcDir + "/[0-9]*")
+	pids := []int{}
+	for _, dir := range dirs {
+		comm, err := ioutil.ReadFile(dir + "/comm")
+		if err != nil {
+			continue // process exited
+		}
+		switch strings.TrimSpace(string(comm)) {
+		case "mysqld", "mariadbd":
+			if pid, err := strconv.Atoi(filepath.Base(dir)); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	if len(pids) == 0 {
+		return ""
+	}
+	sort.Ints(pids)
+	return strconv.Itoa(pids[0])
+}
+
+// ProcCgroup returns the cgroup of the process, e.g. "self" or a pid, from
+// /proc/<pid>/cgroup, or nil if the process isn't in a cgroup.
+func ProcCgroup(pid string) *Cgroup {
+	procDir := CgroupProcDir + "/" + pid
+	content, err := ioutil.ReadFile(procDir + "/cgroup")
+	if err != nil {
+		return nil
+	}
+	paths := ParseProcCgroup(content)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	// /proc/<pid>/root is the process's root dir, so it's / for the agent,
+	// and the container's root for mysqld in a container.
+	cg := &Cgroup{
+		Container: DetectContainer(content, FileExists(procDir+"/root/.dockerenv"), procEnv(procDir, "container")),
+	}
+
+	if path, ok := paths[""]; ok && len(paths) == 1 {
+		// cgroup v2: one unified hierarchy
+		cg.Version = 2
+		dir := cgroupDir(CgroupSysDir, path, "memory.max")
+		cg.MemoryLimit = readCgroupUint(dir + "/memory.max")
+		cg.MemoryUsage = readCgroupUint(dir + "/memory.current")
+		if max := readCgroupFields(dir + "/cpu.max"); len(max) == 2 {
+			cg.CPUQuota = cpuQuota(max[0], max[1])
+		}
+		for _, line := range readCgroupLines(dir + "/cpu.stat") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, _ := strconv.ParseUint(fields[1], 10, 64)
+				cg.CPUUsage = usec * 1000
+			}
+		}
+	} else {
+		cg.Version = 1
+		memDir := cgroupDir(CgroupSysDir+"/memory", paths["memory"], "memory.limit_in_bytes")
+		cg.MemoryLimit = readCgroupUint(memDir + "/memory.limit_in_bytes")
+		cg.MemoryUsage = readCgroupUint(memDir + "/memory.usage_in_bytes")
+
+		// cpu and cpuacct are usually mounted together as cpu,cpuacct.
+		cpuPath, ok := paths["cpu"]
+		if !ok {
+			cpuPath = paths["cpu,cpuacct"]
+		}
+		cpuDir := cgroupDir(CgroupSysDir+"/cpu", cpuPath, "cpu.cfs_quota_us")
+		cg.CPUQuota = cpuQuota(
+			strings.TrimSpace(readCgroupFile(cpuDir+"/cpu.cfs_quota_us")),
+			strings.TrimSpace(readCgroupFile(cpuDir+"/cpu.cfs_period_us")),
+		)
+		acctPath, ok := paths["cpuacct"]
+		if !ok {
+			acctPath = paths["cpu,cpuacct"]
+		}
+		acctDir := cgroupDir(CgroupSysDir+"/cpuacct", acctPath, "cpuacct.usage")
+		cg.CPUUsage = readCgroupUint(acctDir + "/cpuacct.usage")
+	}
+
+	if cg.MemoryLimit >= cgroupNoLimit {
+		cg.MemoryLimit = 0
+	}
+
+	return cg
+}
+
+// Limited returns true if the process is in a container or has cgroup limits,
+// i.e. if host-level numbers are probably misleading.
+func (cg *Cgroup) Limited() bool {
+	return cg != nil && (cg.Container != "" || cg.MemoryLimit > 0 || cg.CPUQuota > 0)
+}
+
+// ParseProcCgroup returns the controller => path map from /proc/<pid>/cgroup.
+// The cgroup v2 unified hierarchy has no controllers, so its key is "".
+func ParseProcCgroup(content []byte) map[string]string {
+	/**
+	 * v1:
+	 *   4:memory:/docker/8d0c0e...
+	 *   3:cpu,cpuacct:/docker/8d0c0e...
+	 * v2:
+	 *   0::/system.slice/mysql.service
+	 */
+	paths := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		paths[parts[1]] = parts[2]
+		// Index comounted controllers individually too: cpu,cpuacct => cpu, cpuacct.
+		if strings.Contains(parts[1], ",") {
+			for _, c := range strings.Split(parts[1], ",") {
+				if _, ok := paths[c]; !ok {
+					paths[c] = parts[2]
+				}
+			}
+		}
+	}
+	return paths
+}
+
+// DetectContainer returns the container type from the contents of
+// /proc/<pid>/cgroup, whether /.dockerenv exists, and the $container env var
+// (set by systemd-nspawn, LXC, and podman).
+func DetectContainer(procCgroup []byte, dockerenv bool, env string) string {
+	content := string(procCgroup)
+	switch {
+	case strings.Contains(content, "kubepods"):
+		return "kubernetes"
+	case dockerenv || strings.Contains(content, "/docker") || strings.Contains(content, "docker-"):
+		return "docker"
+	case strings.Contains(content, "/lxc"):
+		return "lxc"
+	case env != "":
+		return env
+	}
+	return ""
+}
+
+// procEnv returns the value of the process's environment variable, or "" if
+// it's not set or the agent can't read /proc/<pid>/environ.
+func procEnv(procDir, name string) string {
+	content, err := ioutil.ReadFile(procDir + "/environ")
+	if err != nil {
+		return ""
+	}
+	for _, kv := range strings.Split(string(content), "\x00") {
+		if strings.HasPrefix(kv, name+"=") {
+			return strings.TrimPrefix(kv, name+"=")
+		}
+	}
+	return ""
+}
+
+// cgroupDir returns root/path if it has the given file, else root.  Inside
+// a container the cgroup is usually mounted at root, not root/path.
+func cgroupDir(root, path, file string) string {
+	dir := filepath.Join(root, path)
+	if FileExists(dir + "/" + file) {
+		return dir
+	}
+	return root
+}
+
+func cpuQuota(quota, period string) float64 {
+	// v1: quota is -1 if not set; v2: quota is "max" if not set.
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+func readCgroupFile(file string) string {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+func readCgroupUint(file string) uint64 {
+	n, err := strconv.ParseUint(strings.TrimSpace(readCgroupFile(file)), 10, 64)
+	if err != nil {
+		return 0 // includes v2 "max"
+	}
+	return n
+}
+
+func readCgroupFields(file string) []string {
+	return strings.Fields(readCgroupFile(file))
+}
+
+func readCgroupLines(file string) []string {
+	return strings.Split(readCgroupFile(file), "\n")
+}
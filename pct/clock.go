@@ -0,0 +1,150 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Local clock skew greater than this is reported as skewed.
+const CLOCK_SKEW_WARN = 2 * time.Second
+
+// The skew is the median of the last CLOCK_SAMPLES measurements, so one bad
+// Date header, e.g. from a slow response or a proxy, doesn't change it.
+// Responses slower than CLOCK_MAX_RTT aren't measured: the midpoint is too
+// far from when the API set Date.
+const (
+	CLOCK_SAMPLES = 5
+	CLOCK_MAX_RTT = time.Second
+)
+
+var (
+	clockSkew     time.Duration // local - API
+	clockMeasured bool
+	clockSamples  []time.Duration // oldest first
+	clockAdjust   bool
+	clockMux      = &sync.RWMutex{}
+)
+
+// MeasureClockSkew compares the local time, at the midpoint of a request sent
+// and received, to the Date header of the API response.  Date has only second
+// resolution, so one measurement is only accurate to about half a second, and
+// responses from a cache (with an Age header) aren't measured because their
+// Date is when the API sent the original response.
+func MeasureClockSkew(sent, received time.Time, header http.Header) {
+	if header.Get("Age") != "" || received.Sub(sent) > CLOCK_MAX_RTT {
+		return
+	}
+	apiTime, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return
+	}
+	// Date is truncated to the second, so on average the API time is half
+	// a second later.
+	apiTime = apiTime.Add(500 * time.Millisecond)
+	local := sent.Add(received.Sub(sent) / 2)
+	clockMux.Lock()
+	defer clockMux.Unlock()
+	clockSamples = append(clockSamples, local.Sub(apiTime))
+	if len(clockSamples) > CLOCK_SAMPLES {
+		clockSamples = clockSamples[len(clockSamples)-CLOCK_SAMPLES:]
+	}
+	sorted := append([]time.Duration{}, clockSamples...)
+	sort.Sort(durations(sorted))
+	clockSkew = sorted[len(sorted)/2]
+	clockMeasured = true
+}
+
+// ResetClockSkew forgets the measured skew, e.g. for a different API.
+func ResetClockSkew() {
+	clockMux.Lock()
+	defer clockMux.Unlock()
+	clockSkew = 0
+	clockMeasured = false
+	clockSamples = nil
+}
+
+// ClockSkew returns how far the local clock is ahead (positive) or behind
+// (negative) the API, and false if it hasn't been measured yet.
+func ClockSkew() (time.Duration, bool) {
+	clockMux.RLock()
+	defer clockMux.RUnlock()
+	return clockSkew, clockMeasured
+}
+
+// SetClockAdjust makes AdjustTime correct times for the clock skew.
+func SetClockAdjust(adjust bool) {
+	clockMux.Lock()
+	defer clockMux.Unlock()
+	clockAdjust = adjust
+}
+
+// AdjustTime returns t corrected to API time, i.e. t.Add(ClockAdjustment()).
+func AdjustTime(t time.Time) time.Time {
+	return t.Add(ClockAdjustment())
+}
+
+// ClockAdjustment returns the correction from local to API time if
+// SetClockAdjust(true) and the skew is greater than CLOCK_SKEW_WARN, else 0.
+// The skew can change between calls, so callers should get it once for all
+// the times in a report.
+func ClockAdjustment() time.Duration {
+	clockMux.RLock()
+	defer clockMux.RUnlock()
+	if !clockAdjust || !clockMeasured || !clockSkewed(clockSkew) {
+		return 0
+	}
+	return -clockSkew
+}
+
+// ClockStatus returns the status of the local clock compared to the API.
+func ClockStatus() string {
+	clockMux.RLock()
+	defer clockMux.RUnlock()
+	if !clockMeasured {
+		return "Not measured"
+	}
+	skew := clockSkew - clockSkew%(100*time.Millisecond)
+	if !clockSkewed(clockSkew) {
+		return fmt.Sprintf("OK (skew %s)", skew)
+	}
+	dir := "ahead of"
+	if skew < 0 {
+		dir = "behind"
+		skew = -skew
+	}
+	status := fmt.Sprintf("Skewed: local clock is %s %s API", skew, dir)
+	if clockAdjust {
+		status += " (adjusting report timestamps)"
+	}
+	return status
+}
+
+func clockSkewed(skew time.Duration) bool {
+	return skew > CLOCK_SKEW_WARN || skew < -CLOCK_SKEW_WARN
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
@@ -0,0 +1,93 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+type ClockTestSuite struct {
+}
+
+var _ = Suite(&ClockTestSuite{})
+
+func (s *ClockTestSuite) SetUpTest(t *C) {
+	pct.ResetClockSkew() // API tests measure it too
+}
+
+func (s *ClockTestSuite) TearDownTest(t *C) {
+	pct.SetClockAdjust(false)
+	pct.ResetClockSkew()
+}
+
+func dateHeader(t time.Time) http.Header {
+	return http.Header{"Date": []string{t.UTC().Format(http.TimeFormat)}}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *ClockTestSuite) TestClockSkew(t *C) {
+	_, ok := pct.ClockSkew()
+	t.Check(ok, Equals, false)
+	t.Check(pct.ClockStatus(), Equals, "Not measured")
+
+	// API time is 10s behind local time, so local clock is 10s ahead.
+	now := time.Now()
+	apiTime := now.Add(-10 * time.Second).UTC().Truncate(time.Second)
+	pct.MeasureClockSkew(now, now, dateHeader(apiTime))
+
+	skew, ok := pct.ClockSkew()
+	t.Check(ok, Equals, true)
+	t.Check(skew > 9*time.Second && skew < 11*time.Second, Equals, true, Commentf("skew: %s", skew))
+	t.Check(pct.ClockStatus(), Matches, `Skewed: local clock is (9|10)\.\ds ahead of API`)
+
+	// Times are only adjusted if enabled.
+	t.Check(pct.AdjustTime(now), Equals, now)
+	t.Check(pct.ClockAdjustment(), Equals, time.Duration(0))
+	pct.SetClockAdjust(true)
+	t.Check(pct.AdjustTime(now), Equals, now.Add(-skew))
+	t.Check(pct.ClockAdjustment(), Equals, -skew)
+	t.Check(pct.ClockStatus(), Matches, `Skewed: .+ \(adjusting report timestamps\)`)
+
+	// Invalid, cached and slow responses are ignored.
+	pct.MeasureClockSkew(now, now, http.Header{})
+	cached := dateHeader(now)
+	cached.Set("Age", "30")
+	pct.MeasureClockSkew(now, now, cached)
+	pct.MeasureClockSkew(now, now.Add(pct.CLOCK_MAX_RTT+time.Second), dateHeader(now))
+	skew2, _ := pct.ClockSkew()
+	t.Check(skew2, Equals, skew)
+
+	// The skew is the median of the last samples, so one odd Date doesn't
+	// change it, but the majority does.
+	pct.MeasureClockSkew(now, now, dateHeader(now))
+	pct.MeasureClockSkew(now, now, dateHeader(apiTime))
+	skew2, _ = pct.ClockSkew()
+	t.Check(skew2, Equals, skew)
+	for i := 0; i < pct.CLOCK_SAMPLES; i++ {
+		pct.MeasureClockSkew(now, now, dateHeader(now))
+	}
+
+	// Small skew isn't adjusted.
+	t.Check(pct.ClockStatus(), Matches, `OK \(skew .+\)`)
+	t.Check(pct.AdjustTime(now), Equals, now)
+}
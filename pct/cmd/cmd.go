@@ -18,9 +18,12 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"sync"
 	"time"
 )
 
@@ -28,6 +31,9 @@ var (
 	DefaultTimeout = 30 * time.Second
 )
 
+// Appended to output truncated by RealCmd.MaxOutput.
+const TRUNCATED_MARKER = "\n[output truncated: %d bytes not shown]\n"
+
 var (
 	ErrNotFound                = errors.New("Executable file not found in $PATH")
 	ErrTimeout                 = errors.New("Timeout")
@@ -56,10 +62,36 @@ func (f *RealCmdFactory) Make(name string, args ...string) Cmd {
 	return NewRealCmd(name, args...)
 }
 
+// Limits are the Timeout and MaxOutput for commands, embedded by services
+// whose limits are set while they may be running commands, e.g. by the
+// sysinfo manager.  The zero value is DefaultTimeout and no output limit.
+type Limits struct {
+	timeout   time.Duration
+	maxOutput int
+	mux       sync.Mutex
+}
+
+func (l *Limits) SetLimits(timeout time.Duration, maxOutput int) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.timeout = timeout
+	l.maxOutput = maxOutput
+}
+
+func (l *Limits) GetLimits() (timeout time.Duration, maxOutput int) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.timeout == 0 {
+		return DefaultTimeout, l.maxOutput
+	}
+	return l.timeout, l.maxOutput
+}
+
 type RealCmd struct {
-	Timeout time.Duration
-	name    string
-	args    []string
+	Timeout   time.Duration
+	MaxOutput int // bytes, 0 = no limit
+	name      string
+	args      []string
 }
 
 type result struct {
@@ -83,7 +115,7 @@ func (c *RealCmd) Run() (output string, err error) {
 		cmd.Env = append(os.Environ(), "HOME=/root")
 	}
 
-	resultChan := runCmd(cmd)
+	resultChan := runCmd(cmd, c.MaxOutput)
 	select {
 	case <-time.After(c.Timeout):
 		killErr := cmd.Process.Kill()
@@ -107,16 +139,68 @@ func (c *RealCmd) Run() (output string, err error) {
 	}
 }
 
-func runCmd(cmd *exec.Cmd) (resultChan chan result) {
+func runCmd(cmd *exec.Cmd, maxOutput int) (resultChan chan result) {
 	// Below channels has buffer
 	// because we might get data before we would be waiting on this channel
 	resultChan = make(chan result, 1)
 	go func() {
-		output, err := cmd.Output()
+		var output string
+		var err error
+		if maxOutput > 0 {
+			// Don't buffer more than maxOutput else a chatty command could
+			// use a lot of memory.
+			buf := NewLimitedBuffer(maxOutput)
+			cmd.Stdout = buf
+			err = cmd.Run()
+			output = buf.String()
+		} else {
+			var out []byte
+			out, err = cmd.Output()
+			output = string(out)
+		}
 		select {
-		case resultChan <- result{output: string(output), err: err}:
+		case resultChan <- result{output: output, err: err}:
 		default:
 		}
 	}()
 	return resultChan
 }
+
+// LimitedBuffer keeps the first max bytes written to it and counts the rest.
+// String() appends TRUNCATED_MARKER if any bytes were dropped.
+type LimitedBuffer struct {
+	max     int
+	buf     bytes.Buffer
+	dropped int
+}
+
+func NewLimitedBuffer(max int) *LimitedBuffer {
+	return &LimitedBuffer{max: max}
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.buf.Len(); room < n {
+		if room > 0 {
+			b.buf.Write(p[0:room])
+		}
+		b.dropped += n - max(room, 0)
+		return n, nil
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *LimitedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+	return b.buf.String() + fmt.Sprintf(TRUNCATED_MARKER, b.dropped)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
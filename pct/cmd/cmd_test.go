@@ -18,9 +18,11 @@
 package cmd_test
 
 import (
+	"fmt"
 	"github.com/percona/percona-agent/pct/cmd"
 	. "gopkg.in/check.v1"
 	"testing"
+	"time"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -39,3 +41,47 @@ func (s *TestSuite) TestCmdNotFound(t *C) {
 	t.Assert(output, Equals, "")
 	t.Assert(err, Equals, cmd.ErrNotFound)
 }
+
+func (s *TestSuite) TestCmdMaxOutput(t *C) {
+	echo := cmd.NewRealCmd("echo", "-n", "0123456789")
+	echo.MaxOutput = 4
+	output, err := echo.Run()
+	t.Assert(err, IsNil)
+	t.Check(output, Equals, "0123"+fmt.Sprintf(cmd.TRUNCATED_MARKER, 6))
+
+	// Output within the limit isn't changed.
+	echo = cmd.NewRealCmd("echo", "-n", "0123456789")
+	echo.MaxOutput = 10
+	output, err = echo.Run()
+	t.Assert(err, IsNil)
+	t.Check(output, Equals, "0123456789")
+}
+
+func (s *TestSuite) TestCmdTimeout(t *C) {
+	sleep := cmd.NewRealCmd("sleep", "5")
+	sleep.Timeout = 100 * time.Millisecond
+	output, err := sleep.Run()
+	t.Check(output, Equals, "")
+	t.Check(err, Equals, cmd.ErrTimeout)
+}
+
+func (s *TestSuite) TestLimits(t *C) {
+	l := &cmd.Limits{}
+	timeout, maxOutput := l.GetLimits()
+	t.Check(timeout, Equals, cmd.DefaultTimeout)
+	t.Check(maxOutput, Equals, 0)
+
+	// Set while commands may be running, e.g. by the sysinfo manager.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			l.GetLimits()
+		}
+		done <- true
+	}()
+	l.SetLimits(time.Second, 1024)
+	<-done
+	timeout, maxOutput = l.GetLimits()
+	t.Check(timeout, Equals, time.Second)
+	t.Check(maxOutput, Equals, 1024)
+}
@@ -126,3 +126,14 @@ type DuplicateServiceInstanceError struct {
 func (e DuplicateServiceInstanceError) Error() string {
 	return fmt.Sprintf("Duplicate %s instance: %d", e.Service, e.Id)
 }
+
+/////////////////////////////////////////////////////////////////////////////
+
+type CmdNotAllowedError struct {
+	Service string
+	Cmd     string
+}
+
+func (e CmdNotAllowedError) Error() string {
+	return fmt.Sprintf("%s/%s command is not allowed by the agent config", e.Service, e.Cmd)
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"os"
+	"syscall"
+)
+
+// FsSpace returns the size, free (available to non-root users) and used bytes
+// of the filesystem that dir is on.  Statfs_t field types vary by OS, hence
+// all the conversions.
+func FsSpace(dir string) (size, free, used uint64, err error) {
+	st := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0, 0, err
+	}
+	size = uint64(st.Blocks) * uint64(st.Bsize)
+	free = uint64(st.Bavail) * uint64(st.Bsize)
+	used = size - uint64(st.Bfree)*uint64(st.Bsize)
+	return size, free, used, nil
+}
+
+// FileOwner returns the uid and gid of the file, or false if the OS doesn't
+// have them.
+func FileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
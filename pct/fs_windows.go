@@ -0,0 +1,43 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// FsSpace returns the size, free (available to the agent's user) and used
+// bytes of the volume that dir is on.
+func FsSpace(dir string) (size, free, used uint64, err error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &free, &size, &totalFree); err != nil {
+		return 0, 0, 0, err
+	}
+	return size, free, size - totalFree, nil
+}
+
+// FileOwner returns false: files on Windows have ACLs, not a uid and gid.
+func FileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return -1, -1, false
+}
@@ -20,23 +20,43 @@ package pct
 import (
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
+	"sync"
 	"time"
 )
 
+// Repeats of the same info or higher log message are collapsed for this long
+// into one "Last message repeated N times" entry, e.g. when MySQL is down and
+// a manager warns every interval.
+const LOG_DEDUP_WINDOW = 5 * time.Minute
+
 type Logger struct {
 	logChan chan *proto.LogEntry
 	service string
 	cmd     *proto.Cmd
+	// --
+	dedupWindow time.Duration
+	last        *proto.LogEntry // last info or higher entry
+	repeats     uint
+	mux         *sync.Mutex
 }
 
 func NewLogger(logChan chan *proto.LogEntry, service string) *Logger {
 	l := &Logger{
-		logChan: logChan,
-		service: service,
+		logChan:     logChan,
+		service:     service,
+		dedupWindow: LOG_DEDUP_WINDOW,
+		mux:         &sync.Mutex{},
 	}
 	return l
 }
 
+// SetDedupWindow sets how long repeated messages are collapsed, 0 to disable.
+func (l *Logger) SetDedupWindow(window time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.dedupWindow = window
+}
+
 func (l *Logger) Service() string {
 	return l.service
 }
@@ -85,6 +105,40 @@ func (l *Logger) log(offline bool, level byte, entry []interface{}) {
 		Msg:     fullMsg,
 		Offline: offline,
 	}
+	if level <= proto.LOG_INFO {
+		if !l.dedup(logEntry) {
+			return
+		}
+	}
+	l.send(logEntry)
+}
+
+// dedup returns false if the entry repeats the last one and should not be
+// sent.  Else it sends the count of repeats, if any, before the entry.
+func (l *Logger) dedup(logEntry *proto.LogEntry) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	last := l.last
+	if l.dedupWindow > 0 && last != nil && last.Level == logEntry.Level && last.Msg == logEntry.Msg && last.Offline == logEntry.Offline &&
+		logEntry.Ts.Sub(last.Ts) < l.dedupWindow {
+		l.repeats++
+		return false
+	}
+	if l.repeats > 0 {
+		l.send(&proto.LogEntry{
+			Ts:      logEntry.Ts,
+			Level:   last.Level,
+			Service: l.service,
+			Msg:     fmt.Sprintf("Last message repeated %d times", l.repeats),
+			Offline: last.Offline,
+		})
+		l.repeats = 0
+	}
+	l.last = logEntry
+	return true
+}
+
+func (l *Logger) send(logEntry *proto.LogEntry) {
 	select {
 	case l.logChan <- logEntry:
 	default:
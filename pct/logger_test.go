@@ -0,0 +1,78 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+type LoggerTestSuite struct {
+}
+
+var _ = Suite(&LoggerTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *LoggerTestSuite) TestDedup(t *C) {
+	logChan := make(chan *proto.LogEntry, 10)
+	logger := pct.NewLogger(logChan, "mm")
+	logger.SetDedupWindow(200 * time.Millisecond)
+
+	// Debug isn't deduped, same info and higher is.
+	logger.Debug("call")
+	logger.Debug("call")
+	logger.Warn("MySQL is down")
+	logger.Warn("MySQL is down")
+	logger.Warn("MySQL is down")
+	logger.Info("MySQL is up")
+	t.Check(msgs(logChan), DeepEquals, []string{
+		"call",
+		"call",
+		"MySQL is down",
+		"Last message repeated 2 times",
+		"MySQL is up",
+	})
+
+	// After the window, a repeat is sent with the count of repeats before it.
+	logger.Info("MySQL is up")
+	time.Sleep(250 * time.Millisecond)
+	logger.Info("MySQL is up")
+	t.Check(msgs(logChan), DeepEquals, []string{
+		"Last message repeated 1 times",
+		"MySQL is up",
+	})
+
+	logger.SetDedupWindow(0)
+	logger.Info("MySQL is up")
+	t.Check(msgs(logChan), DeepEquals, []string{"MySQL is up"})
+}
+
+func msgs(logChan chan *proto.LogEntry) []string {
+	msgs := []string{}
+	for {
+		select {
+		case e := <-logChan:
+			msgs = append(msgs, e.Msg)
+		default:
+			return msgs
+		}
+	}
+}
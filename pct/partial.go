@@ -0,0 +1,81 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"fmt"
+	"time"
+)
+
+// DEFAULT_CMD_DEADLINE is the deadline of Cmds that do many items, e.g.
+// explain many queries.  It's less than the 20s the agent waits for a Cmd so
+// that a partial reply is sent before the agent replies with a CmdTimeoutError.
+const DEFAULT_CMD_DEADLINE = 15 * time.Second
+
+// A PartialReply is the reply data of a Cmd that does many items.  Items not
+// done by the deadline are TimedOut rather than failing the whole Cmd.
+type PartialReply struct {
+	Results  map[string]interface{} // keyed on item id
+	Errors   map[string]string      `json:",omitempty"` // keyed on item id
+	TimedOut []string               `json:",omitempty"` // item ids, in order
+}
+
+// A partial is the result of one item: data, error, or both, e.g. an error with
+// details of how to fix it.
+type partial struct {
+	id   string
+	data interface{}
+	err  error
+}
+
+// RunItems calls do for each item id, in order, until the deadline.  Each call
+// runs in its own goroutine so a slow item can't block the reply: when the
+// deadline passes, it and the items not yet done are TimedOut, and their calls
+// are left to finish in the background.
+func RunItems(deadline time.Time, ids []string, do func(id string) (interface{}, error)) *PartialReply {
+	reply := &PartialReply{
+		Results: make(map[string]interface{}),
+		Errors:  make(map[string]string),
+	}
+	timeout := time.After(deadline.Sub(time.Now()))
+	doneChan := make(chan partial, 1) // buffered so a timed out call can finish
+	for i, id := range ids {
+		go func(id string) {
+			defer func() {
+				if err := recover(); err != nil {
+					doneChan <- partial{id: id, err: fmt.Errorf("%s crashed: %s", id, err)}
+				}
+			}()
+			data, err := do(id)
+			doneChan <- partial{id, data, err}
+		}(id)
+		select {
+		case p := <-doneChan:
+			if p.data != nil {
+				reply.Results[p.id] = p.data
+			}
+			if p.err != nil {
+				reply.Errors[p.id] = p.err.Error()
+			}
+		case <-timeout:
+			reply.TimedOut = append(reply.TimedOut, ids[i:]...)
+			return reply
+		}
+	}
+	return reply
+}
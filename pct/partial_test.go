@@ -0,0 +1,70 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"errors"
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+type PartialTestSuite struct {
+}
+
+var _ = Suite(&PartialTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *PartialTestSuite) TestRunItems(t *C) {
+	ids := []string{"a", "b", "c"}
+	got := pct.RunItems(time.Now().Add(time.Second), ids, func(id string) (interface{}, error) {
+		if id == "b" {
+			return "fix b", errors.New("b failed")
+		}
+		return id + "!", nil
+	})
+	t.Check(got.Results, DeepEquals, map[string]interface{}{"a": "a!", "b": "fix b", "c": "c!"})
+	t.Check(got.Errors, DeepEquals, map[string]string{"b": "b failed"})
+	t.Check(got.TimedOut, HasLen, 0)
+}
+
+func (s *PartialTestSuite) TestRunItemsDeadline(t *C) {
+	// b blocks past the deadline, so it and c time out, but a is replied.
+	block := make(chan bool)
+	defer close(block)
+	ids := []string{"a", "b", "c"}
+	t0 := time.Now()
+	got := pct.RunItems(time.Now().Add(200*time.Millisecond), ids, func(id string) (interface{}, error) {
+		if id == "b" {
+			<-block
+		}
+		return id, nil
+	})
+	t.Check(time.Now().Sub(t0) < time.Second, Equals, true)
+	t.Check(got.Results, DeepEquals, map[string]interface{}{"a": "a"})
+	t.Check(got.Errors, HasLen, 0)
+	t.Check(got.TimedOut, DeepEquals, []string{"b", "c"})
+}
+
+func (s *PartialTestSuite) TestRunItemsCrash(t *C) {
+	got := pct.RunItems(time.Now().Add(time.Second), []string{"a"}, func(id string) (interface{}, error) {
+		panic("oops")
+	})
+	t.Check(got.Errors, DeepEquals, map[string]string{"a": "a crashed: oops"})
+}
@@ -0,0 +1,327 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	proxyFunc = envProxy
+	proxyMux  = &sync.RWMutex{}
+)
+
+// Proxy returns the proxy for the request, or nil if none.  It's used for all
+// API requests and websockets.  By default, the proxy is set by the standard
+// HTTP_PROXY, HTTPS_PROXY, ALL_PROXY and NO_PROXY env vars; SetProxy overrides
+// them.
+func Proxy(req *http.Request) (*url.URL, error) {
+	proxyMux.RLock()
+	defer proxyMux.RUnlock()
+	return proxyFunc(req)
+}
+
+// SetProxy makes Proxy return proxyURL for all hosts except those in noProxy,
+// a comma-separated list of hosts and domains like NO_PROXY.  If proxyURL is
+// empty, the env vars are used.
+func SetProxy(proxyURL, noProxy string) error {
+	proxyMux.Lock()
+	defer proxyMux.Unlock()
+	if proxyURL == "" {
+		proxyFunc = envProxy
+		return nil
+	}
+	u, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return err
+	}
+	proxyFunc = func(req *http.Request) (*url.URL, error) {
+		// Like http.ProxyFromEnvironment, never proxy localhost.
+		if NoProxy("localhost,127.0.0.1,::1", req.URL.Host) || NoProxy(noProxy, req.URL.Host) {
+			return nil, nil
+		}
+		return u, nil
+	}
+	return nil
+}
+
+// envProxy is http.ProxyFromEnvironment plus ALL_PROXY, which curl and most
+// tools use for SOCKS proxies.
+func envProxy(req *http.Request) (*url.URL, error) {
+	u, err := http.ProxyFromEnvironment(req)
+	if u != nil || err != nil {
+		return u, err
+	}
+	allProxy := getenvAny("ALL_PROXY", "all_proxy")
+	if allProxy == "" {
+		return nil, nil
+	}
+	if NoProxy("localhost,127.0.0.1,::1", req.URL.Host) || NoProxy(getenvAny("NO_PROXY", "no_proxy"), req.URL.Host) {
+		return nil, nil
+	}
+	return ParseProxyURL(allProxy)
+}
+
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if val := os.Getenv(name); val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// ParseProxyURL parses a proxy URL like HTTP_PROXY: the scheme is optional,
+// e.g. proxy.example.com:3128 is http://proxy.example.com:3128.  SOCKS5
+// proxies are socks5://[user:pass@]host:port; the proxy resolves host names
+// with either socks5 or socks5h.
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	if !strings.Contains(proxyURL, "://") {
+		proxyURL = "http://" + proxyURL
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL %s: %s", proxyURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("Invalid proxy URL %s: scheme must be http, https, socks5 or socks5h", proxyURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy URL %s: no host", proxyURL)
+	}
+	return u, nil
+}
+
+// NoProxy returns true if host[:port] matches noProxy: "*", the host, or a
+// domain of the host (with or without leading dot).
+func NoProxy(noProxy, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, p := range strings.Split(noProxy, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if p == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(p); err == nil {
+			p = h
+		}
+		p = strings.TrimPrefix(p, ".")
+		if host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialProxy connects to addr (host:port) through the HTTP proxy with CONNECT,
+// or through the SOCKS5 proxy, for non-HTTP connections like websockets.
+func DialProxy(proxyURL *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h" {
+		if err := socks5Connect(conn, proxyURL, addr); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Proxy %s CONNECT %s: %s", proxyURL.Host, addr, err)
+		}
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		pass, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s CONNECT %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// SOCKS5 protocol values, RFC 1928 and RFC 1929 (username/password auth).
+const (
+	SOCKS5_VERSION       = 0x05
+	SOCKS5_AUTH_NONE     = 0x00
+	SOCKS5_AUTH_PASSWORD = 0x02
+	SOCKS5_AUTH_NO_MATCH = 0xFF
+	SOCKS5_CMD_CONNECT   = 0x01
+	SOCKS5_ATYP_IPV4     = 0x01
+	SOCKS5_ATYP_DOMAIN   = 0x03
+	SOCKS5_ATYP_IPV6     = 0x04
+)
+
+var socks5Errors = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// socks5Connect does the SOCKS5 handshake on conn to the proxy, then connects
+// to addr.  Host names are sent to the proxy to resolve because the agent
+// host might not be able to resolve external names.
+func socks5Connect(conn net.Conn, proxyURL *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portStr)
+	}
+
+	// Greeting: version, number of auth methods, methods.
+	methods := []byte{SOCKS5_AUTH_NONE}
+	if proxyURL.User != nil {
+		methods = append(methods, SOCKS5_AUTH_PASSWORD)
+	}
+	greeting := append([]byte{SOCKS5_VERSION, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != SOCKS5_VERSION {
+		return fmt.Errorf("not a SOCKS5 proxy (version %d)", buf[0])
+	}
+	switch buf[1] {
+	case SOCKS5_AUTH_NONE:
+	case SOCKS5_AUTH_PASSWORD:
+		if proxyURL.User == nil {
+			return fmt.Errorf("proxy requires username and password")
+		}
+		user := proxyURL.User.Username()
+		pass, _ := proxyURL.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return fmt.Errorf("username or password too long")
+		}
+		auth := []byte{0x01, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(pass)))
+		auth = append(auth, pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return fmt.Errorf("username and password authentication failed")
+		}
+	case SOCKS5_AUTH_NO_MATCH:
+		return fmt.Errorf("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", buf[1])
+	}
+
+	// Connect request: version, command, reserved, address type, address, port.
+	req := []byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, SOCKS5_ATYP_IPV4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, SOCKS5_ATYP_IPV6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(req, SOCKS5_ATYP_DOMAIN, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, address type, bound address, port.
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		if msg, ok := socks5Errors[reply[1]]; ok {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("SOCKS5 error %d", reply[1])
+	}
+	var boundLen int
+	switch reply[3] {
+	case SOCKS5_ATYP_IPV4:
+		boundLen = net.IPv4len
+	case SOCKS5_ATYP_IPV6:
+		boundLen = net.IPv6len
+	case SOCKS5_ATYP_DOMAIN:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		boundLen = int(reply[0])
+	default:
+		return fmt.Errorf("unknown address type %d", reply[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, boundLen+2)); err != nil {
+		return err
+	}
+	return nil
+}
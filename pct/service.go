@@ -28,3 +28,9 @@ type ServiceManager interface {
 	GetConfig() ([]proto.AgentConfig, []error)
 	Handle(cmd *proto.Cmd) *proto.Reply
 }
+
+// A Pauser is a ServiceManager that can pause and resume collecting or sending
+// data without stopping, e.g. for the agent Pause cmd (maintenance mode).
+type Pauser interface {
+	Pause(paused bool)
+}
@@ -51,6 +51,7 @@ func (s *Status) Update(proc string, status string) {
 		return
 	}
 	s.status[proc] = status
+	recordStatus(proc, status)
 }
 
 func (s *Status) UpdateRe(proc string, status string, cmd *proto.Cmd) {
@@ -60,6 +61,18 @@ func (s *Status) UpdateRe(proc string, status string, cmd *proto.Cmd) {
 		return
 	}
 	s.status[proc] = fmt.Sprintf("%s %s", status, cmd)
+	recordStatus(proc, s.status[proc])
+}
+
+// Discard removes the history of the procs, see StatusTree.  Call it when the
+// Status is no longer used, e.g. by a short-lived worker, else the history of
+// its procs is kept forever.
+func (s *Status) Discard() {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for proc := range s.status {
+		discardHistory(proc)
+	}
 }
 
 func (s *Status) Get(proc string) string {
@@ -0,0 +1,105 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+type StatusTestSuite struct {
+}
+
+var _ = Suite(&StatusTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *StatusTestSuite) TestStatusTree(t *C) {
+	status := pct.NewStatus([]string{"tqan", "tqan-parser", "tqan-last-interval", "tmm-mysql-1"})
+	status.Update("tqan", "Running")
+	status.Update("tqan-parser", "Crashed")
+	status.Update("tqan-parser", "Idle (0 of 2 running)")
+	status.Update("tmm-mysql-1", "Stopped")
+
+	tree := pct.StatusTree(status.All())
+	t.Assert(tree, HasLen, 2)
+
+	// No "tmm" proc, so tmm-mysql-1 is a root.
+	t.Check(tree[0].Name, Equals, "tmm-mysql-1")
+	t.Check(tree[0].State, Equals, pct.STATE_STOPPED)
+	t.Check(tree[0].Children, HasLen, 0)
+
+	qan := tree[1]
+	t.Check(qan.Name, Equals, "tqan")
+	t.Check(qan.State, Equals, pct.STATE_RUNNING)
+	t.Check(qan.Since.IsZero(), Equals, false)
+	t.Assert(qan.Children, HasLen, 2)
+	t.Check(qan.Children[0].Name, Equals, "tqan-last-interval")
+	t.Check(qan.Children[0].State, Equals, pct.STATE_UNKNOWN)
+	t.Check(qan.Children[1].Name, Equals, "tqan-parser")
+	t.Check(qan.Children[1].State, Equals, pct.STATE_IDLE)
+	t.Check(qan.Children[1].Status, Equals, "Idle (0 of 2 running)")
+	t.Check(qan.Children[1].LastError, Equals, "Crashed")
+}
+
+func (s *StatusTestSuite) TestStatusHistory(t *C) {
+	status := pct.NewStatus([]string{"hsender"})
+	status.Update("hsender", "Idle")
+	status.Update("hsender", "Sending data")
+	status.Update("hsender", "Sending more data")
+	status.Update("hsender", "Crashed")
+	status.Update("hsender", "Crashed")
+
+	tree := pct.StatusTree(status.All())
+	t.Assert(tree, HasLen, 1)
+	node := tree[0]
+	t.Check(node.Changed.IsZero(), Equals, false)
+	t.Check(node.Changed.Before(node.Since), Equals, false)
+
+	// Only state changes are history, not every status change.
+	t.Assert(node.History, HasLen, 3)
+	t.Check(node.History[0].From, Equals, pct.STATE_UNKNOWN)
+	t.Check(node.History[0].To, Equals, pct.STATE_IDLE)
+	t.Check(node.History[1].To, Equals, pct.STATE_RUNNING)
+	t.Check(node.History[1].Status, Equals, "Sending data")
+	t.Check(node.History[2].From, Equals, pct.STATE_RUNNING)
+	t.Check(node.History[2].To, Equals, pct.STATE_CRASHED)
+	t.Check(node.History[2].Ts, Equals, node.Since)
+
+	// Only the last STATUS_HISTORY_SIZE changes are kept.
+	for i := 0; i < pct.STATUS_HISTORY_SIZE; i++ {
+		status.Update("hsender", "Idle")
+		status.Update("hsender", "Running")
+	}
+	tree = pct.StatusTree(status.All())
+	t.Assert(tree[0].History, HasLen, pct.STATUS_HISTORY_SIZE)
+	t.Check(tree[0].History[pct.STATUS_HISTORY_SIZE-1].To, Equals, pct.STATE_RUNNING)
+
+	// Discarded status has no history, e.g. a new worker with the same name.
+	status.Discard()
+	tree = pct.StatusTree(status.All())
+	t.Check(tree[0].History, HasLen, 0)
+	t.Check(tree[0].Changed.IsZero(), Equals, true)
+}
+
+func (s *StatusTestSuite) TestStatusState(t *C) {
+	t.Check(pct.StatusState("Gave up after 5 restarts"), Equals, pct.STATE_CRASHED)
+	t.Check(pct.StatusState("Paused until 2015-01-01T00:00:00Z"), Equals, pct.STATE_PAUSED)
+	t.Check(pct.StatusState("Starting"), Equals, pct.STATE_STARTING)
+	t.Check(pct.StatusState("Sending data"), Equals, pct.STATE_RUNNING)
+}
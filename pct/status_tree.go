@@ -0,0 +1,196 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Structured status states, parsed from the status text.
+const (
+	STATE_UNKNOWN  = "unknown"
+	STATE_STARTING = "starting"
+	STATE_RUNNING  = "running"
+	STATE_IDLE     = "idle"
+	STATE_PAUSED   = "paused"
+	STATE_STOPPING = "stopping"
+	STATE_STOPPED  = "stopped"
+	STATE_ERROR    = "error"
+	STATE_CRASHED  = "crashed"
+)
+
+// Number of state changes kept per proc.
+const STATUS_HISTORY_SIZE = 10
+
+// A StatusNode is one proc in the structured status tree returned for version 2
+// of the Status cmd.  Status is the same text as the flat status map.  A proc's
+// children are the procs named "<proc>-<child>", e.g. qan-parser under qan.
+type StatusNode struct {
+	Name      string
+	State     string
+	Status    string
+	Since     time.Time     // when State last changed, zero if unknown
+	Changed   time.Time     // when Status last changed, zero if unknown
+	LastError string        `json:",omitempty"` // last error or crashed status
+	Children  []*StatusNode `json:",omitempty"`
+
+	// Last STATUS_HISTORY_SIZE state changes, oldest first.
+	History []StatusTransition `json:",omitempty"`
+}
+
+// A StatusTransition is a change of a proc's State, e.g. idle to crashed.
+type StatusTransition struct {
+	Ts     time.Time
+	From   string
+	To     string
+	Status string // status text that changed the state
+}
+
+// StatusState parses the state from status text like "Idle", "Running worker",
+// or "Crashed".
+func StatusState(status string) string {
+	switch {
+	case status == "":
+		return STATE_UNKNOWN
+	case strings.HasPrefix(status, "Crashed"), strings.HasPrefix(status, "Gave up"):
+		return STATE_CRASHED
+	case strings.HasPrefix(status, "ERROR"), strings.HasPrefix(status, "Error"), strings.HasPrefix(status, "Failed"):
+		return STATE_ERROR
+	case strings.HasPrefix(status, "Starting"), strings.HasPrefix(status, "Connecting"):
+		return STATE_STARTING
+	case strings.HasPrefix(status, "Stopping"):
+		return STATE_STOPPING
+	case strings.HasPrefix(status, "Stopped"):
+		return STATE_STOPPED
+	case strings.HasPrefix(status, "Paused"):
+		return STATE_PAUSED
+	case strings.HasPrefix(status, "Idle"), strings.HasPrefix(status, "Ready"):
+		return STATE_IDLE
+	}
+	return STATE_RUNNING
+}
+
+// Status.Update records when each proc's state and status change, its last
+// error, and its last state changes so StatusTree can report them.  Procs are
+// unique across the agent because all status is merged into one map.  Status
+// with procs named per run, e.g. qan-worker-1, must be discarded when done.
+type procHistory struct {
+	state       string
+	since       time.Time
+	lastError   string
+	status      string
+	changed     time.Time
+	transitions []StatusTransition
+}
+
+var history = make(map[string]*procHistory)
+var historyMux = &sync.Mutex{}
+
+func recordStatus(proc, status string) {
+	state := StatusState(status)
+	historyMux.Lock()
+	defer historyMux.Unlock()
+	h, ok := history[proc]
+	if !ok {
+		h = &procHistory{}
+		history[proc] = h
+	}
+	now := time.Now().UTC()
+	if h.status != status || h.changed.IsZero() {
+		h.status = status
+		h.changed = now
+	}
+	if h.state != state {
+		from := h.state
+		if from == "" {
+			from = STATE_UNKNOWN
+		}
+		h.transitions = append(h.transitions, StatusTransition{
+			Ts:     now,
+			From:   from,
+			To:     state,
+			Status: status,
+		})
+		if len(h.transitions) > STATUS_HISTORY_SIZE {
+			h.transitions = h.transitions[len(h.transitions)-STATUS_HISTORY_SIZE:]
+		}
+		h.state = state
+		h.since = now
+	}
+	if state == STATE_ERROR || state == STATE_CRASHED {
+		h.lastError = status
+	}
+}
+
+func discardHistory(proc string) {
+	historyMux.Lock()
+	defer historyMux.Unlock()
+	delete(history, proc)
+}
+
+// StatusTree returns the flat status map as a tree, sorted by name.
+func StatusTree(status map[string]string) []*StatusNode {
+	procs := make([]string, 0, len(status))
+	for proc := range status {
+		procs = append(procs, proc)
+	}
+	sort.Strings(procs) // parents before children
+
+	historyMux.Lock()
+	defer historyMux.Unlock()
+	nodes := make(map[string]*StatusNode)
+	roots := []*StatusNode{}
+	for _, proc := range procs {
+		node := &StatusNode{
+			Name:   proc,
+			State:  StatusState(status[proc]),
+			Status: status[proc],
+		}
+		if h, ok := history[proc]; ok {
+			if h.state == node.State {
+				node.Since = h.since
+			}
+			if h.status == node.Status {
+				node.Changed = h.changed
+			}
+			node.LastError = h.lastError
+			node.History = append([]StatusTransition{}, h.transitions...)
+		}
+		nodes[proc] = node
+
+		// The parent is the longest proc that prefixes this one, e.g. "mm" for
+		// "mm-mysql-1" if there's no "mm-mysql".
+		parent := proc
+		for {
+			i := strings.LastIndex(parent, "-")
+			if i < 0 {
+				roots = append(roots, node)
+				break
+			}
+			parent = parent[0:i]
+			if p, ok := nodes[parent]; ok {
+				p.Children = append(p.Children, node)
+				break
+			}
+		}
+	}
+	return roots
+}
@@ -54,7 +54,13 @@ func (sync *SyncChan) Start() bool {
 }
 
 func (sync *SyncChan) Stop() {
-	sync.StopChan <- true
+	select {
+	case sync.StopChan <- true:
+	case <-sync.CrashChan:
+		// Goroutine crashed, so there's nothing to stop.  Put the crash back
+		// for Wait.
+		sync.CrashChan <- true
+	}
 }
 
 func (sync *SyncChan) Wait() {
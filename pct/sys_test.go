@@ -19,7 +19,9 @@ package pct_test
 
 import (
 	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test"
 	. "gopkg.in/check.v1"
+	"io/ioutil"
 	"os"
 )
 
@@ -102,3 +104,83 @@ func (s *SysTestSuite) TestMbps(t *C) {
 	t.Check(pct.Mbps(222566303, 300.0), Equals, "5.94")  // 5m
 	t.Check(pct.Mbps(222566303, 3600.0), Equals, "0.49") // 1h
 }
+
+func (s *SysTestSuite) TestCgroupV1(t *C) {
+	defer func() {
+		pct.CgroupProcDir = "/proc"
+		pct.CgroupSysDir = "/sys/fs/cgroup"
+	}()
+	pct.CgroupProcDir = test.RootDir + "/pct/cgroup-v1/proc"
+	pct.CgroupSysDir = test.RootDir + "/pct/cgroup-v1/sys"
+
+	// No mysqld, so the agent's cgroup.
+	t.Check(pct.MySQLPid(), Equals, "")
+	cg := pct.GetCgroup()
+	t.Assert(cg, NotNil)
+	t.Check(cg.Container, Equals, "docker")
+	t.Check(cg.Version, Equals, 1)
+	t.Check(cg.MemoryLimit, Equals, uint64(2147483648))
+	t.Check(cg.MemoryUsage, Equals, uint64(536870912))
+	t.Check(cg.CPUQuota, Equals, 1.5)
+	t.Check(cg.CPUUsage, Equals, uint64(98765432100))
+	t.Check(cg.Limited(), Equals, true)
+}
+
+func (s *SysTestSuite) TestCgroupV2(t *C) {
+	defer func() {
+		pct.CgroupProcDir = "/proc"
+		pct.CgroupSysDir = "/sys/fs/cgroup"
+	}()
+	pct.CgroupProcDir = test.RootDir + "/pct/cgroup-v2/proc"
+	pct.CgroupSysDir = test.RootDir + "/pct/cgroup-v2/sys"
+
+	// mysqld's cgroup, not the agent's (self).
+	t.Check(pct.MySQLPid(), Equals, "2046")
+	cg := pct.GetCgroup()
+	t.Assert(cg, NotNil)
+	t.Check(cg.Version, Equals, 2)
+	t.Check(cg.MemoryLimit, Equals, uint64(0)) // max
+	t.Check(cg.MemoryUsage, Equals, uint64(1073741824))
+	t.Check(cg.CPUQuota, Equals, 2.0)
+	t.Check(cg.CPUUsage, Equals, uint64(5000000000))
+	t.Check(cg.Limited(), Equals, true)
+
+	// The agent's cgroup has no limits.
+	cg = pct.ProcCgroup("self")
+	t.Assert(cg, NotNil)
+	t.Check(cg.CPUQuota, Equals, float64(0))
+	t.Check(cg.MemoryUsage, Equals, uint64(0))
+
+	// Not in a cgroup
+	pct.CgroupProcDir = test.RootDir + "/pct/does-not-exist"
+	cg = pct.GetCgroup()
+	t.Check(cg, IsNil)
+	t.Check(cg.Limited(), Equals, false)
+}
+
+func (s *SysTestSuite) TestDetectContainer(t *C) {
+	t.Check(pct.DetectContainer([]byte("0::/system.slice/mysql.service\n"), false, ""), Equals, "")
+	t.Check(pct.DetectContainer([]byte("0::/system.slice/mysql.service\n"), true, ""), Equals, "docker")
+	t.Check(pct.DetectContainer([]byte("4:memory:/docker/8d0c0e0a2b3d\n"), false, ""), Equals, "docker")
+	t.Check(pct.DetectContainer([]byte("4:memory:/kubepods/burstable/pod1234\n"), false, ""), Equals, "kubernetes")
+	t.Check(pct.DetectContainer([]byte("4:memory:/lxc/mysql01\n"), false, ""), Equals, "lxc")
+	t.Check(pct.DetectContainer([]byte("0::/\n"), false, "systemd-nspawn"), Equals, "systemd-nspawn")
+}
+
+func (s *SysTestSuite) TestParseSysctl(t *C) {
+	content, err := ioutil.ReadFile(test.RootDir + "/pct/sysctl001.txt")
+	t.Assert(err, IsNil)
+	got := pct.ParseSysctl(content)
+	expect := map[string]string{
+		"kern.ostype":    "FreeBSD",
+		"kern.osrelease": "10.1-RELEASE",
+		"hw.physmem":     "8536752128",
+		"vm.loadavg":     "{ 0.21 0.30 0.27 }",
+		"vm.swapusage":   "total = 2048.00M  used = 1024.50M  free = 1023.50M  (encrypted)",
+	}
+	t.Check(got, DeepEquals, expect)
+
+	t.Check(pct.UsesSysctl("freebsd"), Equals, true)
+	t.Check(pct.UsesSysctl("darwin"), Equals, true)
+	t.Check(pct.UsesSysctl("linux"), Equals, false)
+}
@@ -0,0 +1,60 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// UsesSysctl returns true if the OS has no /proc, so system info must be
+// gathered with sysctl(8) instead.
+func UsesSysctl(goos string) bool {
+	return goos == "freebsd" || goos == "darwin"
+}
+
+// Sysctl returns the values of the given sysctl names.  Names that the OS
+// doesn't have are not returned: FreeBSD and Mac OS X differ a lot, so
+// callers usually ask for both and use whichever are returned.
+func Sysctl(names ...string) (map[string]string, error) {
+	out, err := exec.Command("sysctl", names...).Output()
+	if err != nil && len(out) == 0 {
+		// sysctl exits non-zero if any name is unknown, but still prints
+		// the others, so it's only an error if nothing is printed.
+		return nil, err
+	}
+	return ParseSysctl(out), nil
+}
+
+func ParseSysctl(content []byte) map[string]string {
+	/**
+	 * hw.physmem: 8536752128
+	 * vm.loadavg: { 0.21 0.30 0.27 }
+	 * vm.swapusage: total = 2048.00M  used = 1024.50M  free = 1023.50M  (encrypted)
+	 */
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		// Skip errors like "sysctl: unknown oid 'hw.memsize'".
+		if len(kv) != 2 || kv[0] == "sysctl" || strings.ContainsAny(kv[0], " \t") {
+			continue
+		}
+		values[kv[0]] = strings.TrimSpace(kv[1])
+	}
+	return values
+}
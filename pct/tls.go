@@ -0,0 +1,218 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS_PIN_SPKI prefixes a public key pin: the base64 SHA-256 of the cert's
+// SubjectPublicKeyInfo, like curl --pinnedpubkey.  Other pins are the hex
+// SHA-256 fingerprint of a cert, colons optional, like openssl x509 -fingerprint.
+const TLS_PIN_SPKI = "sha256//"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var (
+	tlsConfig  = &tls.Config{}
+	clientCert *certReloader
+	tlsMux     = &sync.RWMutex{}
+)
+
+// TLSConfig returns a copy of the TLS config for all API requests and
+// websockets.  By default, it's the Go defaults; SetTLS changes it.
+func TLSConfig() *tls.Config {
+	tlsMux.RLock()
+	defer tlsMux.RUnlock()
+	config := tlsConfig.Clone()
+	if clientCert != nil {
+		config.GetClientCertificate = clientCert.GetClientCertificate
+	}
+	return config
+}
+
+// SetClientCert makes TLSConfig authenticate with the PEM client cert and key
+// (mutual TLS), in addition to or instead of the API key.  The files are
+// reloaded when they change, so rotated certs are used for new connections
+// without restarting the agent.  Empty files disable the client cert.
+func SetClientCert(certFile, keyFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("Client cert and key files are both required")
+	}
+	var r *certReloader
+	if certFile != "" {
+		r = &certReloader{
+			certFile: certFile,
+			keyFile:  keyFile,
+			mux:      &sync.Mutex{},
+		}
+		if _, err := r.GetClientCertificate(nil); err != nil {
+			return err
+		}
+	}
+	tlsMux.Lock()
+	defer tlsMux.Unlock()
+	clientCert = r
+	return nil
+}
+
+type certReloader struct {
+	certFile string
+	keyFile  string
+	// --
+	mux     *sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // of the newer file when cert was loaded
+}
+
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	modTime := time.Time{}
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			if r.cert != nil {
+				return r.cert, nil // rotating, use the old cert
+			}
+			return nil, fmt.Errorf("Cannot read client cert: %s", err)
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// Probably only one file has been rotated; try again next time.
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("Invalid client cert: %s", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+// SetTLS makes TLSConfig trust the PEM certs in caFile in addition to the system
+// CAs, require minVersion (1.0, 1.1, 1.2 or 1.3), and require that a cert in
+// the verified chain matches one of pins.  All are optional; empty values
+// use the Go defaults.
+func SetTLS(caFile, minVersion string, pins []string) error {
+	config := &tls.Config{}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("Cannot read CA file: %s", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No PEM certs in CA file %s", caFile)
+		}
+		config.RootCAs = roots
+	}
+
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return fmt.Errorf("Invalid TLS min version %s: must be 1.0, 1.1, 1.2 or 1.3", minVersion)
+		}
+		config.MinVersion = v
+	}
+
+	if len(pins) > 0 {
+		verify, err := verifyPins(pins)
+		if err != nil {
+			return err
+		}
+		config.VerifyPeerCertificate = verify
+	}
+
+	tlsMux.Lock()
+	defer tlsMux.Unlock()
+	tlsConfig = config
+	return nil
+}
+
+func verifyPins(pins []string) (func([][]byte, [][]*x509.Certificate) error, error) {
+	spkiPins := make(map[string]bool)
+	certPins := make(map[string]bool)
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		if strings.HasPrefix(pin, TLS_PIN_SPKI) {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, TLS_PIN_SPKI))
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("Invalid TLS public key pin %s: must be %s<base64 SHA-256>", pin, TLS_PIN_SPKI)
+			}
+			spkiPins[string(hash)] = true
+		} else {
+			hash, err := hex.DecodeString(strings.Replace(pin, ":", "", -1))
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("Invalid TLS cert pin %s: must be a hex SHA-256 fingerprint", pin)
+			}
+			certPins[string(hash)] = true
+		}
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		// Only check certs that were verified, else a server could send any
+		// pinned (public) cert after its own.  If verification is disabled,
+		// only the server's own cert is checked.
+		certs := []*x509.Certificate{}
+		for _, chain := range verifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(verifiedChains) == 0 && len(rawCerts) > 0 {
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		for _, cert := range certs {
+			certHash := sha256.Sum256(cert.Raw)
+			spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if certPins[string(certHash[:])] || spkiPins[string(spkiHash[:])] {
+				return nil
+			}
+		}
+		return errors.New("TLS cert does not match any pin")
+	}, nil
+}
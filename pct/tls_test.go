@@ -0,0 +1,170 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+)
+
+type TLSTestSuite struct {
+	server *httptest.Server
+	caFile string
+}
+
+var _ = Suite(&TLSTestSuite{})
+
+func (s *TLSTestSuite) SetUpSuite(t *C) {
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tmpFile, err := ioutil.TempFile("", "percona-agent-test-ca")
+	t.Assert(err, IsNil)
+	pem.Encode(tmpFile, &pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+	tmpFile.Close()
+	s.caFile = tmpFile.Name()
+}
+
+func (s *TLSTestSuite) TearDownSuite(t *C) {
+	s.server.Close()
+	os.Remove(s.caFile)
+}
+
+func (s *TLSTestSuite) TearDownTest(t *C) {
+	pct.SetTLS("", "", nil)
+	pct.SetClientCert("", "")
+}
+
+func (s *TLSTestSuite) get() error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: pct.TLSConfig()}}
+	resp, err := client.Get(s.server.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+// --------------------------------------------------------------------------
+
+func (s *TLSTestSuite) TestCAFile(t *C) {
+	// Test server cert is self-signed, so not trusted by default.
+	t.Check(s.get(), NotNil)
+
+	t.Assert(pct.SetTLS(s.caFile, "1.2", nil), IsNil)
+	t.Check(s.get(), IsNil)
+
+	t.Check(pct.SetTLS(s.caFile, "2.0", nil), ErrorMatches, "Invalid TLS min version 2.0.+")
+	t.Check(pct.SetTLS("/does/not/exist", "", nil), ErrorMatches, "Cannot read CA file.+")
+}
+
+func (s *TLSTestSuite) TestPins(t *C) {
+	cert := s.server.Certificate()
+	certHash := sha256.Sum256(cert.Raw)
+	spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	wrongHash := sha256.Sum256([]byte("wrong"))
+
+	// Cert fingerprint pin.
+	t.Assert(pct.SetTLS(s.caFile, "", []string{hex.EncodeToString(certHash[:])}), IsNil)
+	t.Check(s.get(), IsNil)
+
+	// Public key pin.
+	t.Assert(pct.SetTLS(s.caFile, "", []string{pct.TLS_PIN_SPKI + base64.StdEncoding.EncodeToString(spkiHash[:])}), IsNil)
+	t.Check(s.get(), IsNil)
+
+	// No matching pin.
+	t.Assert(pct.SetTLS(s.caFile, "", []string{hex.EncodeToString(wrongHash[:])}), IsNil)
+	t.Check(s.get(), ErrorMatches, ".*TLS cert does not match any pin")
+
+	t.Check(pct.SetTLS(s.caFile, "", []string{"sha256//nope"}), ErrorMatches, "Invalid TLS public key pin.+")
+}
+
+// writeClientCert writes a new self-signed client cert and key with the serial
+// number to certFile and keyFile.
+func writeClientCert(t *C, certFile, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	t.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	t.Assert(err, IsNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	t.Assert(err, IsNil)
+	t.Assert(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), IsNil)
+	t.Assert(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), IsNil)
+}
+
+func (s *TLSTestSuite) TestClientCert(t *C) {
+	serials := make(chan int64, 2)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serials <- r.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func() error {
+		// New transport for a new conn because the cert is sent in the handshake.
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: pct.TLSConfig()}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	dir := t.MkDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeClientCert(t, certFile, keyFile, 1)
+
+	t.Check(pct.SetClientCert(certFile, ""), ErrorMatches, "Client cert and key files are both required")
+	t.Check(pct.SetClientCert(certFile, certFile), ErrorMatches, "Invalid client cert: .+")
+
+	// httptest servers use the same cert as s.server.
+	t.Assert(pct.SetTLS(s.caFile, "", nil), IsNil)
+	t.Check(get(), NotNil) // no client cert
+	t.Assert(pct.SetClientCert(certFile, keyFile), IsNil)
+	t.Assert(get(), IsNil)
+	t.Check(<-serials, Equals, int64(1))
+
+	// Rotate the cert: it's reloaded because the files changed.
+	writeClientCert(t, certFile, keyFile, 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	t.Assert(get(), IsNil)
+	t.Check(<-serials, Equals, int64(2))
+}
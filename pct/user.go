@@ -0,0 +1,117 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// RunAs is the dedicated, non-root user that percona-agent runs as after
+// binding its local listeners (-user).
+type RunAs struct {
+	Name string
+	Uid  int
+	Gid  int
+	Gids []int // supplementary groups, e.g. mysql to read the slow log
+}
+
+func LookupRunAs(name string) (*RunAs, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &RunAs{Name: u.Username}
+	if r.Uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("Invalid uid for user %s: %s", name, u.Uid)
+	}
+	if r.Gid, err = strconv.Atoi(u.Gid); err != nil {
+		return nil, fmt.Errorf("Invalid gid for user %s: %s", name, u.Gid)
+	}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get groups of user %s: %s", name, err)
+	}
+	for _, g := range groupIds {
+		gid, err := strconv.Atoi(g)
+		if err != nil {
+			continue
+		}
+		r.Gids = append(r.Gids, gid)
+	}
+	return r, nil
+}
+
+// CheckPermissions returns an error for the first dir or file under each path
+// that the user cannot use.  Dirs must be rwx (the agent creates and removes
+// files in them) and files must be rw (configs are rewritten, spool files are
+// removed).  Sockets and the instance key are skipped: the control socket is
+// root-only on purpose, and the instance key is loaded before dropping root
+// (see instance.PreloadKey), so it should stay root-only too.
+func (r *RunAs) CheckPermissions(paths ...string) error {
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode()&os.ModeSocket != 0 || fi.Name() == INSTANCE_KEY {
+				return nil
+			}
+			if fi.IsDir() {
+				if !r.CanAccess(fi, 07) {
+					return fmt.Errorf("Directory %s is not readable and writable by user %s; run chown -R %s %s", file, r.Name, r.Name, path)
+				}
+			} else if !r.CanAccess(fi, 06) {
+				return fmt.Errorf("File %s is not readable and writable by user %s; run chown -R %s %s", file, r.Name, r.Name, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CanAccess returns true if the user has all perm bits (e.g. 04 to read) on
+// the file, checked like the kernel does: owner, then groups, then other.
+func (r *RunAs) CanAccess(fi os.FileInfo, perm os.FileMode) bool {
+	if r.Uid == 0 {
+		return true
+	}
+	mode := fi.Mode().Perm()
+	uid, gid, ok := FileOwner(fi)
+	if !ok {
+		return mode&perm == perm
+	}
+	if uid == r.Uid {
+		return (mode>>6)&perm == perm
+	}
+	if gid == r.Gid {
+		return (mode>>3)&perm == perm
+	}
+	for _, g := range r.Gids {
+		if gid == g {
+			return (mode>>3)&perm == perm
+		}
+	}
+	return mode&perm == perm
+}
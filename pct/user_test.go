@@ -0,0 +1,68 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"github.com/percona/percona-agent/pct"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type RunAsTestSuite struct {
+}
+
+var _ = Suite(&RunAsTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *RunAsTestSuite) TestCheckPermissions(t *C) {
+	dir := t.MkDir()
+	file := filepath.Join(dir, "agent.conf")
+	err := ioutil.WriteFile(file, []byte("{}"), 0600)
+	t.Assert(err, IsNil)
+	os.Chmod(dir, 0700)
+
+	// Another user, not in our group: neither dir nor file is accessible.
+	r := &pct.RunAs{Name: "other", Uid: os.Getuid() + 1, Gid: os.Getgid() + 1}
+	err = r.CheckPermissions(dir)
+	t.Check(err, ErrorMatches, "Directory "+dir+" is not readable and writable by user other.*")
+
+	os.Chmod(dir, 0770)
+	r.Gids = []int{os.Getgid()}
+	err = r.CheckPermissions(dir)
+	t.Check(err, ErrorMatches, "File "+file+" is not readable and writable by user other.*")
+
+	os.Chmod(file, 0660)
+	err = r.CheckPermissions(dir)
+	t.Check(err, IsNil)
+
+	// The instance key is root-only, loaded before dropping root.
+	keyFile := filepath.Join(dir, pct.INSTANCE_KEY)
+	err = ioutil.WriteFile(keyFile, []byte("00"), 0600)
+	t.Assert(err, IsNil)
+	err = r.CheckPermissions(dir)
+	t.Check(err, IsNil)
+
+	// The owner can always use its files.
+	os.Chmod(dir, 0700)
+	os.Chmod(file, 0600)
+	owner := &pct.RunAs{Name: "owner", Uid: os.Getuid(), Gid: os.Getgid()}
+	t.Check(owner.CheckPermissions(dir), IsNil)
+}
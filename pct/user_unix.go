@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Drop switches the process (all threads) to the user.  It must be called as
+// root, after binding listeners on privileged ports or root-only paths.
+func (r *RunAs) Drop() error {
+	if os.Getuid() == r.Uid {
+		return nil // already running as the user
+	}
+	if os.Getuid() != 0 {
+		return fmt.Errorf("Cannot run as user %s: percona-agent is not running as root", r.Name)
+	}
+	gids := r.Gids
+	if len(gids) == 0 {
+		gids = []int{r.Gid}
+	}
+	if err := syscall.Setgroups(gids); err != nil {
+		return fmt.Errorf("Cannot set groups for user %s: %s", r.Name, err)
+	}
+	if err := syscall.Setgid(r.Gid); err != nil {
+		return fmt.Errorf("Cannot set gid %d for user %s: %s", r.Gid, r.Name, err)
+	}
+	if err := syscall.Setuid(r.Uid); err != nil {
+		return fmt.Errorf("Cannot set uid %d for user %s: %s", r.Uid, r.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+)
+
+type MonitorConfig struct {
+	mm.Config
+	Plugin string // name in the plugin config, must be TYPE_MM
+}
+
+// Monitor is an mm monitor that runs an mm plugin to collect metrics.  Metric
+// names are prefixed with the plugin name, e.g. redis/connected_clients.
+type Monitor struct {
+	name   string
+	config *MonitorConfig
+	logger *pct.Logger
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	sync           *pct.SyncChan
+	status         *pct.Status
+	running        bool
+}
+
+func NewMonitor(name string, config *MonitorConfig, logger *pct.Logger) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		// --
+		status: pct.NewStatus([]string{name}),
+		sync:   pct.NewSyncChan(),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	m.logger.Debug("Start:call")
+	defer m.logger.Debug("Start:return")
+
+	if m.running {
+		return pct.ServiceIsRunningError{Service: m.name}
+	}
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	m.logger.Debug("Stop:call")
+	defer m.logger.Debug("Stop:return")
+
+	if !m.running {
+		return nil
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.running = false
+	m.logger.Info("Stopped")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+func (m *Monitor) run() {
+	m.logger.Debug("run:call")
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Plugin monitor crashed: ", err)
+		}
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+		m.logger.Debug("run:return")
+	}()
+
+	var lastTs int64
+	for {
+		m.logger.Debug("run:idle")
+		m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", time.Unix(lastTs, 0)))
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			m.status.Update(m.name, "Running "+m.config.Plugin)
+
+			metrics, err := m.Collect()
+			if err != nil {
+				m.logger.Warn(err)
+				continue
+			}
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:      now.UTC().Unix(),
+				Metrics: metrics,
+			}
+			if len(c.Metrics) > 0 {
+				select {
+				case m.collectionChan <- c:
+					lastTs = c.Ts
+				case <-time.After(500 * time.Millisecond):
+					// lost collection
+					m.logger.Debug("Lost plugin metrics; timeout spooling after 500ms")
+				}
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// Collect runs the plugin and returns its valid metrics.
+func (m *Monitor) Collect() ([]mm.Metric, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	p, f, err := Verify(config, m.config.Plugin, TYPE_MM)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	output, err := Run(p, f, DEFAULT_TIMEOUT*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", m.config.Plugin, err)
+	}
+	metrics := []mm.Metric{}
+	for _, metric := range output.Metrics {
+		if metric.Name == "" || !mm.MetricTypes[metric.Type] {
+			m.logger.Warn(fmt.Sprintf("%s: invalid metric: %+v", m.config.Plugin, metric))
+			continue
+		}
+		metric.Name = m.config.Plugin + "/" + metric.Name
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
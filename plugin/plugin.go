@@ -0,0 +1,147 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package plugin runs external executables which print JSON to stdout as mm
+// monitors (metrics) and sysinfo services, so app-specific metrics and info
+// can be collected without changing the agent.
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/pct/cmd"
+	"github.com/percona/percona-agent/sysinfo/script"
+)
+
+const (
+	CONFIG_NAME     = "plugin"
+	DEFAULT_TIMEOUT = 10 // seconds
+	MAX_OUTPUT      = 1024 * 1024
+)
+
+// Plugin types: what a plugin can be used as.
+const (
+	TYPE_MM      = "mm"
+	TYPE_SYSINFO = "sysinfo"
+)
+
+// Config is read from CONFIG_NAME in the basedir config dir.  Like the
+// sysinfo-script config, it's only written by the operator, never by the
+// agent or API, else the API could run any executable.
+type Config struct {
+	Dir     string            // only plugins in this dir can be run
+	Plugins map[string]Plugin // plugin name => plugin
+}
+
+type Plugin struct {
+	File     string   // executable file name in Config.Dir
+	Checksum string   // SHA256 (hex) of File
+	Args     []string // optional
+	Type     string   // TYPE_MM or TYPE_SYSINFO
+	Timeout  uint     // seconds (default: DEFAULT_TIMEOUT)
+}
+
+// Output is the JSON a plugin prints to stdout.  An mm plugin prints Metrics,
+// a sysinfo plugin prints Data.  If the plugin fails, it prints Error or exits
+// non-zero.
+type Output struct {
+	Metrics []mm.Metric
+	Data    json.RawMessage
+	Error   string
+}
+
+// ReadConfig reads the config every time so the operator can change it
+// without restarting the agent.
+func ReadConfig() (*Config, error) {
+	config := &Config{}
+	if err := pct.Basedir.ReadConfig(CONFIG_NAME, config); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No plugins are configured: %s does not exist", pct.Basedir.ConfigFile(CONFIG_NAME))
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// Verify returns the plugin and its open executable if it's in the config, is
+// the given type, and its checksum matches, else it returns an error.  The
+// caller must close the file.
+func Verify(config *Config, name, pluginType string) (Plugin, *os.File, error) {
+	p, ok := config.Plugins[name]
+	if !ok {
+		return p, nil, fmt.Errorf("Plugin %s is not configured", name)
+	}
+	if p.Type != pluginType {
+		return p, nil, fmt.Errorf("Plugin %s is type %s, not %s", name, p.Type, pluginType)
+	}
+	// Same rules as whitelisted scripts: in Dir, a regular file that only
+	// root can change, with the pinned checksum.
+	f, err := script.Verify(&script.Config{Dir: config.Dir, Scripts: map[string]string{p.File: p.Checksum}}, p.File)
+	if err != nil {
+		return p, nil, err
+	}
+	return p, f, nil
+}
+
+// Run runs the plugin executable file returned by Verify and decodes its
+// output.  The timeout is the plugin's, if set.
+func Run(p Plugin, f *os.File, timeout time.Duration) (*Output, error) {
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Second
+	}
+
+	stdout := cmd.NewLimitedBuffer(MAX_OUTPUT)
+	stderr := cmd.NewLimitedBuffer(MAX_OUTPUT)
+	plugin := script.Command(f, p.Args...)
+	plugin.Stdout = stdout
+	plugin.Stderr = stderr
+	if err := plugin.Start(); err != nil {
+		return nil, err
+	}
+
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- plugin.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-doneChan:
+	case <-time.After(timeout):
+		plugin.Process.Kill()
+		return nil, cmd.ErrTimeout
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := &Output{}
+	if err := json.NewDecoder(bytes.NewBufferString(stdout.String())).Decode(output); err != nil {
+		return nil, fmt.Errorf("Invalid output: %s", err)
+	}
+	if output.Error != "" {
+		return nil, fmt.Errorf("%s", output.Error)
+	}
+	return output, nil
+}
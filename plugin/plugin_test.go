@@ -0,0 +1,167 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package plugin_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/plugin"
+	"github.com/percona/percona-agent/sysinfo/script"
+	"github.com/percona/percona-agent/test"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var sample = test.RootDir + "/plugin"
+
+var config = &plugin.Config{
+	Dir: sample,
+	Plugins: map[string]plugin.Plugin{
+		"app": {
+			File:     "metrics.sh",
+			Checksum: "82a33e635035d72e7aab39ee315363c7d9f24368ea416329671f86e722ff9ae8",
+			Type:     plugin.TYPE_MM,
+		},
+		"info": {
+			File:     "info.sh",
+			Checksum: "1e3615f916c1eec1f3ce01cd72cc53c9d94255da83c6020d8b8c52036899c34b",
+			Args:     []string{"-v", "x"},
+			Type:     plugin.TYPE_SYSINFO,
+		},
+		"fail": {
+			File:     "fail.sh",
+			Checksum: "e0a15a5009aad743a9bce05f4a3d4d78f6acce738fd50eb3c11d4ee33194a2e3",
+			Type:     plugin.TYPE_SYSINFO,
+		},
+	},
+}
+
+type TestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+	tmpDir  string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "plugin-test")
+
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-test")
+	t.Assert(err, IsNil)
+	if err := pct.Basedir.Init(s.tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sample plugins are owned by whoever checked out the repo.
+	script.OWNER_UID = os.Getuid()
+}
+
+func (s *TestSuite) SetUpTest(t *C) {
+	if err := pct.Basedir.WriteConfig(plugin.CONFIG_NAME, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *TestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *TestSuite) TestVerify(t *C) {
+	_, f, err := plugin.Verify(config, "app", plugin.TYPE_MM)
+	t.Assert(err, IsNil)
+	t.Check(f.Name(), Equals, sample+"/metrics.sh")
+	f.Close()
+
+	_, _, err = plugin.Verify(config, "app", plugin.TYPE_SYSINFO)
+	t.Check(err, ErrorMatches, "Plugin app is type mm, not sysinfo")
+
+	_, _, err = plugin.Verify(config, "nope", plugin.TYPE_MM)
+	t.Check(err, ErrorMatches, "Plugin nope is not configured")
+
+	bad := &plugin.Config{Dir: sample, Plugins: map[string]plugin.Plugin{
+		"app": {File: "metrics.sh", Checksum: "abc", Type: plugin.TYPE_MM},
+	}}
+	_, _, err = plugin.Verify(bad, "app", plugin.TYPE_MM)
+	t.Check(err, ErrorMatches, ".+checksum does not match.+")
+}
+
+func (s *TestSuite) TestMonitor(t *C) {
+	monitorConfig := &plugin.MonitorConfig{
+		Config: mm.Config{
+			ServiceInstance: proto.ServiceInstance{Service: "plugin", InstanceId: 1},
+			Collect:         1,
+			Report:          60,
+		},
+		Plugin: "app",
+	}
+	m := plugin.NewMonitor("mm-plugin-app", monitorConfig, s.logger)
+
+	tickChan := make(chan time.Time)
+	collectionChan := make(chan *mm.Collection, 1)
+	t.Assert(m.Start(tickChan, collectionChan), IsNil)
+	defer m.Stop()
+
+	now := time.Now()
+	tickChan <- now
+	select {
+	case c := <-collectionChan:
+		t.Check(c.ServiceInstance, DeepEquals, monitorConfig.ServiceInstance)
+		t.Check(c.Ts, Equals, now.UTC().Unix())
+		// The string metric is invalid and dropped.
+		t.Check(c.Metrics, DeepEquals, []mm.Metric{
+			{Name: "app/clients", Type: "gauge", Number: 5},
+			{Name: "app/hits", Type: "counter", Number: 100},
+		})
+	case <-time.After(2 * time.Second):
+		t.Fatal("No collection")
+	}
+}
+
+func (s *TestSuite) TestService(t *C) {
+	service := plugin.NewService(s.logger)
+
+	data, _ := json.Marshal(plugin.RunPlugin{Name: "info"})
+	reply := service.Handle(&proto.Cmd{Cmd: "Plugin", Data: data})
+	t.Assert(reply.Error, Equals, "")
+	t.Check(string(reply.Data), Equals, `{"Args":"-v x"}`)
+
+	data, _ = json.Marshal(plugin.RunPlugin{Name: "fail"})
+	reply = service.Handle(&proto.Cmd{Cmd: "Plugin", Data: data})
+	t.Check(reply.Error, Equals, "exit status 1: cannot connect")
+
+	// mm plugins can't be run as sysinfo.
+	data, _ = json.Marshal(plugin.RunPlugin{Name: "app"})
+	reply = service.Handle(&proto.Cmd{Cmd: "Plugin", Data: data})
+	t.Check(reply.Error, Equals, "Plugin app is type mm, not sysinfo")
+}
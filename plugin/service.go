@@ -0,0 +1,80 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/pct/cmd"
+)
+
+const SERVICE_NAME = "Plugin"
+
+// Cmd.Data for the Plugin command.
+type RunPlugin struct {
+	Name string // plugin name in the plugin config, must be TYPE_SYSINFO
+}
+
+// Service is a sysinfo service that runs a sysinfo plugin and replies with
+// the Data it prints.  It's a sysinfo.Limiter, but output is always limited
+// to MAX_OUTPUT.
+type Service struct {
+	logger *pct.Logger
+	// --
+	cmd.Limits
+}
+
+func NewService(logger *pct.Logger) *Service {
+	s := &Service{
+		logger: logger,
+	}
+	return s
+}
+
+func (s *Service) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	run := &RunPlugin{}
+	if protoCmd.Data == nil {
+		return protoCmd.Reply(nil, fmt.Errorf("%s: cmd.Data is empty", SERVICE_NAME))
+	}
+	if err := json.Unmarshal(protoCmd.Data, run); err != nil {
+		return protoCmd.Reply(nil, fmt.Errorf("%s: json.Unmarshal: %s", SERVICE_NAME, err))
+	}
+
+	config, err := ReadConfig()
+	if err != nil {
+		return protoCmd.Reply(nil, err)
+	}
+	p, f, err := Verify(config, run.Name, TYPE_SYSINFO)
+	if err != nil {
+		s.logger.Warn(err)
+		return protoCmd.Reply(nil, err)
+	}
+	defer f.Close()
+
+	s.logger.Info("Running " + f.Name())
+	timeout, _ := s.GetLimits()
+	output, err := Run(p, f, timeout)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("%s: %s", f.Name(), err))
+		return protoCmd.Reply(nil, err)
+	}
+	return protoCmd.Reply(output.Data)
+}
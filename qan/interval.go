@@ -33,7 +33,7 @@ type Interval struct {
 	StopTime    time.Time // UTC
 	Filename    string    // slow_query_log_file
 	StartOffset int64     // bytes @ StartTime
-	EndOffset   int64     // bytes @ StopTime
+	EndOffset   int64     // bytes @ StopTime, or END_OF_FILE
 }
 
 func (i *Interval) String() string {
@@ -147,6 +147,7 @@ func (i *FileIntervalIter) run() {
 		select {
 		case now := <-i.tickChan:
 			i.logger.Debug("run:tick")
+			now = now.UTC() // intervals are UTC, even across DST changes
 
 			// Get the MySQL slow log file name at each interval because it can change.
 			curFile, err := i.filename()
@@ -160,6 +161,9 @@ func (i *FileIntervalIter) run() {
 			i.logger.Debug("run:file size")
 			curSize, err := pct.FileSize(curFile)
 			if err != nil {
+				if os.IsPermission(err) {
+					err = fmt.Errorf("%s (the slow log and its directory must be readable by the user percona-agent runs as)", err)
+				}
 				i.logger.Warn(err)
 				cur = new(Interval)
 				continue
@@ -178,6 +182,27 @@ func (i *FileIntervalIter) run() {
 				i.logger.Debug("run:next")
 				i.intervalNo++
 
+				// If logrotate rotated and compressed the slow log, the rest
+				// of the interval is in the .gz file, so parse it first.
+				if fileChanged {
+					if rotated, modTime := rotatedSlowLog(curFile, cur.StartTime); rotated != "" {
+						i.logger.Info("Slow log rotated to " + rotated)
+						if modTime.After(now) {
+							modTime = now
+						}
+						i.sendInterval(&Interval{
+							Number:      i.intervalNo,
+							StartTime:   cur.StartTime,
+							StopTime:    modTime,
+							Filename:    rotated,
+							StartOffset: cur.StartOffset,
+							EndOffset:   END_OF_FILE,
+						})
+						i.intervalNo++
+						cur.StartTime = modTime
+					}
+				}
+
 				// End of current interval:
 				cur.Filename = curFile
 				if fileChanged {
@@ -189,12 +214,7 @@ func (i *FileIntervalIter) run() {
 				cur.StopTime = now
 				cur.Number = i.intervalNo
 
-				// Send interval to manager which should be ready to receive it.
-				select {
-				case i.intervalChan <- cur:
-				case <-time.After(1 * time.Second):
-					i.logger.Warn(fmt.Sprintf("Lost interval: %+v", cur))
-				}
+				i.sendInterval(cur)
 
 				// Next interval:
 				cur = &Interval{
@@ -216,6 +236,15 @@ func (i *FileIntervalIter) run() {
 	}
 }
 
+// Send interval to manager which should be ready to receive it.
+func (i *FileIntervalIter) sendInterval(interval *Interval) {
+	select {
+	case i.intervalChan <- interval:
+	case <-time.After(1 * time.Second):
+		i.logger.Warn(fmt.Sprintf("Lost interval: %+v", interval))
+	}
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // performance_schema iterator
 /////////////////////////////////////////////////////////////////////////////
@@ -270,6 +299,7 @@ func (i *PfsIntervalIter) run() {
 		select {
 		case now := <-i.tickChan:
 			i.logger.Debug("run:tick")
+			now = now.UTC() // intervals are UTC, even across DST changes
 
 			if !cur.StartTime.IsZero() { // StartTime is set
 				i.logger.Debug("run:next")
@@ -278,12 +308,7 @@ func (i *PfsIntervalIter) run() {
 				cur.StopTime = now
 				cur.Number = i.intervalNo
 
-				// Send interval to manager which should be ready to receive it.
-				select {
-				case i.intervalChan <- cur:
-				case <-time.After(1 * time.Second):
-					i.logger.Warn(fmt.Sprintf("Lost interval: %+v", cur))
-				}
+				i.sendInterval(cur)
 
 				// Next interval:
 				cur = &Interval{
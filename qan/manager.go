@@ -18,11 +18,13 @@
 package qan
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -47,7 +49,8 @@ type Manager struct {
 	// --
 	config          *Config
 	running         bool
-	mux             *sync.RWMutex // guards config and running
+	paused          bool
+	mux             *sync.RWMutex // guards config, running and paused
 	tickChan        chan time.Time
 	restartChan     <-chan bool
 	mysqlConn       mysql.Connector
@@ -151,6 +154,22 @@ func (m *Manager) Stop() error {
 	return nil
 }
 
+// Pause makes the parser skip intervals until resumed.  Intervals skipped
+// while paused are not parsed later.
+func (m *Manager) Pause(paused bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if paused == m.paused {
+		return
+	}
+	m.paused = paused
+	if paused {
+		m.logger.Info("Paused")
+	} else {
+		m.logger.Info("Resumed")
+	}
+}
+
 func (m *Manager) Status() map[string]string {
 	m.mux.RLock()
 	defer m.mux.RUnlock()
@@ -291,6 +310,15 @@ func (m *Manager) run(config Config) {
 				}
 			}
 
+			// Still rotate the slow log while paused so it doesn't grow unbounded.
+			m.mux.RLock()
+			paused := m.paused
+			m.mux.RUnlock()
+			if paused {
+				m.logger.Info("Paused, interval skipped")
+				continue
+			}
+
 			m.status.Update("qan-parser", "Running worker")
 			job := &Job{
 				Id:             fmt.Sprintf("%d", interval.Number),
@@ -301,6 +329,15 @@ func (m *Manager) run(config Config) {
 				ExampleQueries: config.ExampleQueries,
 			}
 
+			// Slow log times are local to MySQL, so the report has their offset.
+			tzOffset := ""
+			if config.CollectFrom == "slowlog" {
+				var err error
+				if tzOffset, err = m.slowLogTzOffset(); err != nil {
+					m.logger.Warn("Cannot get time zone of slow log times:", err)
+				}
+			}
+
 			// Make a MySQL connector for the worker, if needed.
 			var mysqlConn mysql.Connector
 			if config.CollectFrom == "perfschema" {
@@ -341,6 +378,7 @@ func (m *Manager) run(config Config) {
 				result.RunTime = t1.Sub(t0).Seconds()
 
 				report := MakeReport(config, interval, result)
+				report.TzOffset = tzOffset
 				if err := m.spool.Write("qan", report); err != nil {
 					m.logger.Warn("Lost report:", err)
 				}
@@ -419,7 +457,19 @@ func (m *Manager) configureMySQL(config Config) error {
 
 	// Set global vars to config/enable slow log or perf schema.
 	if err := m.mysqlConn.Set(config.Start); err != nil {
-		return err
+		if !mysql.MissingPrivilege(err) {
+			return err
+		}
+		// The agent MySQL user doesn't have SUPER, but that's ok if a DBA
+		// has already configured MySQL.
+		verify, verr := mysql.VerifyOnly(config.Start)
+		if verr == nil {
+			verr = m.mysqlConn.Set(verify)
+		}
+		if verr != nil {
+			return fmt.Errorf("Cannot configure MySQL (%s) and it is not already configured: %s", err, verr)
+		}
+		m.logger.Warn(fmt.Sprintf("Cannot configure MySQL (%s) but it is already configured", err))
 	}
 
 	return nil // success
@@ -440,6 +490,12 @@ func (m *Manager) rotateSlowLog(config Config, interval *Interval) error {
 
 	// Stop slow log so we don't move it while MySQL is using it.
 	if err := m.mysqlConn.Set(config.Stop); err != nil {
+		if mysql.MissingPrivilege(err) {
+			// Without SUPER the slow log can't be rotated, so it must be
+			// rotated by the DBA (e.g. with logrotate).
+			m.logger.Warn(fmt.Sprintf("Cannot rotate slow log: %s", err))
+			return nil
+		}
 		return err
 	}
 
@@ -574,6 +630,46 @@ func (m *Manager) start(config *Config) error {
 	return nil // success
 }
 
+// slowLogTzOffset returns TzOffset for the slow log of the MySQL instance.
+// log_timestamps doesn't exist before MySQL 5.7, when slow log times are
+// always in the system time zone.  The offset of the system time zone is from
+// CONVERT_TZ to SYSTEM, or from NOW() if time_zone=SYSTEM.
+func (m *Manager) slowLogTzOffset() (string, error) {
+	if err := m.mysqlConn.Connect(1); err != nil {
+		return "", err
+	}
+	defer m.mysqlConn.Close()
+	db := m.mysqlConn.DB()
+	if db == nil {
+		return "", nil // mock
+	}
+
+	logTimestamps := "SYSTEM"
+	var val string
+	if err := db.QueryRow("SELECT @@GLOBAL.log_timestamps").Scan(&val); err == nil {
+		logTimestamps = val
+	}
+
+	var systemTz, timeZone string
+	var nowOffset int
+	var systemOffset sql.NullInt64
+	err := db.QueryRow("SELECT @@GLOBAL.system_time_zone, @@SESSION.time_zone,"+
+		" TIMESTAMPDIFF(SECOND, UTC_TIMESTAMP(), NOW()),"+
+		" TIMESTAMPDIFF(SECOND, UTC_TIMESTAMP(), CONVERT_TZ(UTC_TIMESTAMP(), '+00:00', 'SYSTEM'))").
+		Scan(&systemTz, &timeZone, &nowOffset, &systemOffset)
+	if err != nil {
+		return "", err
+	}
+	offset := int(systemOffset.Int64)
+	if !systemOffset.Valid {
+		if strings.ToUpper(timeZone) != "SYSTEM" {
+			return "", fmt.Errorf("unknown offset of system_time_zone %s (time_zone=%s)", systemTz, timeZone)
+		}
+		offset = nowOffset
+	}
+	return TzOffset(logTimestamps, offset), nil
+}
+
 func (m *Manager) AbsDataFile(dataDir, fileName string) string {
 	if !path.IsAbs(fileName) {
 		fileName = path.Join(dataDir, fileName)
@@ -608,6 +704,10 @@ func (m *Manager) stop() error {
 	}
 	defer m.mysqlConn.Close()
 	if err := m.mysqlConn.Set(m.config.Stop); err != nil {
+		if mysql.MissingPrivilege(err) {
+			m.logger.Warn(fmt.Sprintf("Cannot unconfigure MySQL: %s", err))
+			return nil
+		}
 		return err
 	}
 
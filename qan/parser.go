@@ -0,0 +1,416 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package qan
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/percona/go-mysql/log"
+)
+
+// Size of the SlowLogParser read buffer.  Lines are sliced from it, so only
+// longer lines, e.g. big multi-row INSERTs, are copied.
+const PARSER_BUFFER_SIZE = 64 * 1024
+
+// Matchers are compiled once.  The regexps are only used for lines that occur
+// once per event (# Time, # User@Host, # admin); the lines of every event,
+// e.g. metrics and queries, are matched by the byte prefixes.
+var (
+	timeRe  = regexp.MustCompile(`Time: (\S+\s{1,2}\S+)`)
+	userRe  = regexp.MustCompile(`User@Host: ([^\[]+|\[[^[]+\]).*?@ (\S*) \[(.*)\]`)
+	adminRe = regexp.MustCompile(`command: (.+)`)
+
+	timePrefix  = []byte("# Time")
+	userPrefix  = []byte("# User")
+	adminPrefix = []byte("# admin")
+	usePrefix   = []byte("use ")
+	setPrefixes = [][]byte{
+		[]byte("SET last_insert_id"),
+		[]byte("SET insert_id"),
+		[]byte("SET timestamp"),
+	}
+	metaPrefixes = [][]byte{
+		[]byte("Time "),
+		[]byte("Tcp "),
+		[]byte("TCP "),
+	}
+	startedWith = []byte("with:\n")
+	colonSpace  = []byte(": ")
+)
+
+// SlowLogParser parses a MySQL slow log into the same events as the go-mysql
+// slow log parser, which was the hot path of the SlowLogWorker: it allocated
+// a string for every line and matched every line with several regexps.  This
+// parser reads lines with bufio.Reader.ReadSlice, so a line is a slice of the
+// read buffer, matches them as bytes, and reuses the query buffer, so it only
+// allocates what it sends: the event and its strings.
+type SlowLogParser struct {
+	file      *os.File
+	opts      log.Options
+	stopChan  chan bool
+	eventChan chan *log.Event
+	// --
+	reader      *bufio.Reader
+	long        []byte            // reused for lines longer than the buffer
+	query       bytes.Buffer      // reused for the query of every event
+	names       map[string]string // metric names, so they're allocated once
+	inHeader    bool
+	inQuery     bool
+	headerLines uint
+	queryLines  uint
+	bytesRead   uint64
+	lineOffset  uint64
+	stopped     bool
+	event       *log.Event
+}
+
+func NewSlowLogParser(file *os.File, opts log.Options) *SlowLogParser {
+	p := &SlowLogParser{
+		file:      file,
+		opts:      opts,
+		stopChan:  make(chan bool, 1),
+		eventChan: make(chan *log.Event),
+		// --
+		names:     make(map[string]string),
+		bytesRead: opts.StartOffset,
+		event:     log.NewEvent(),
+	}
+	return p
+}
+
+func (p *SlowLogParser) EventChan() <-chan *log.Event {
+	return p.eventChan
+}
+
+// Stop stops Start, which closes the event channel.  Call it only once.
+func (p *SlowLogParser) Stop() {
+	p.stopChan <- true
+}
+
+// Start parses the slow log from opts.StartOffset and sends each event on the
+// event channel until the end of the file or Stop.  An incomplete last line,
+// i.e. one that MySQL is still writing, is not parsed.
+func (p *SlowLogParser) Start() error {
+	defer close(p.eventChan)
+
+	if p.opts.StartOffset > 0 {
+		if _, err := p.file.Seek(int64(p.opts.StartOffset), os.SEEK_SET); err != nil {
+			return err
+		}
+	}
+	p.reader = bufio.NewReaderSize(p.file, PARSER_BUFFER_SIZE)
+
+SCANNER_LOOP:
+	for !p.stopped {
+		select {
+		case <-p.stopChan:
+			p.stopped = true
+			break SCANNER_LOOP
+		default:
+		}
+
+		line, err := p.readLine()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break SCANNER_LOOP
+		}
+		lineLen := uint64(len(line))
+		p.bytesRead += lineLen
+		p.lineOffset = p.bytesRead - lineLen
+
+		// Skip the lines that MySQL writes when it (re)opens the slow log:
+		// "/usr/sbin/mysqld, Version: ... started with:", "Tcp port: ...",
+		// and "Time                 Id Command    Argument".
+		if isMetaLine(line) {
+			continue
+		}
+
+		line = line[0 : lineLen-1] // \n
+		if p.inHeader {
+			p.parseHeader(line)
+		} else if p.inQuery {
+			p.parseQuery(line)
+		} else if isHeader(line) {
+			p.inHeader = true
+			p.inQuery = false
+			p.parseHeader(line)
+		}
+	}
+
+	if !p.stopped && p.queryLines > 0 {
+		p.sendEvent(false, false)
+	}
+
+	return nil
+}
+
+// readLine returns the next line, including the \n.  It's only valid until
+// the next call because it's a slice of the read buffer, or of p.long if
+// the line is longer than the buffer.
+func (p *SlowLogParser) readLine() ([]byte, error) {
+	line, err := p.reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	p.long = append(p.long[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = p.reader.ReadSlice('\n')
+		p.long = append(p.long, line...)
+	}
+	return p.long, err
+}
+
+func (p *SlowLogParser) parseHeader(line []byte) {
+	if !isHeader(line) {
+		p.inHeader = false
+		p.inQuery = true
+		p.parseQuery(line)
+		return
+	}
+
+	if p.headerLines == 0 {
+		p.event.Offset = p.lineOffset
+	}
+	p.headerLines++
+
+	switch {
+	case bytes.HasPrefix(line, timePrefix):
+		m := timeRe.FindSubmatch(line)
+		if len(m) < 2 {
+			return
+		}
+		p.event.Ts = string(m[1])
+		if m := userRe.FindSubmatch(line); len(m) >= 3 {
+			p.event.User = string(m[1])
+			p.event.Host = string(m[2])
+		}
+	case bytes.HasPrefix(line, userPrefix):
+		m := userRe.FindSubmatch(line)
+		if len(m) < 3 {
+			return
+		}
+		p.event.User = string(m[1])
+		p.event.Host = string(m[2])
+	case bytes.HasPrefix(line, adminPrefix):
+		p.parseAdmin(line)
+	default:
+		p.parseMetrics(line)
+	}
+}
+
+// parseMetrics parses "Name: value" pairs, e.g. "# Query_time: 0.000 ...",
+// like the regexp (\w+): (\S+|\z) but without allocating.
+func (p *SlowLogParser) parseMetrics(line []byte) {
+	for i := 0; i < len(line); {
+		colon := bytes.Index(line[i:], colonSpace)
+		if colon < 0 {
+			return
+		}
+		colon += i
+		start := colon
+		for start > i && isWordChar(line[start-1]) {
+			start--
+		}
+		valueStart := colon + len(colonSpace)
+		end := valueStart
+		for end < len(line) && !isSpace(line[end]) {
+			end++
+		}
+		i = end
+		if start == colon || (end == valueStart && end < len(line)) {
+			continue // no name, or no value before the end of the line
+		}
+		name := line[start:colon]
+		value := line[valueStart:end]
+		p.addMetric(name, value)
+	}
+}
+
+func (p *SlowLogParser) addMetric(name, value []byte) {
+	switch {
+	case bytes.HasSuffix(name, []byte("_time")) || bytes.HasSuffix(name, []byte("_wait")):
+		val, _ := strconv.ParseFloat(string(value), 64)
+		p.event.TimeMetrics[p.name(name)] = val
+	case string(value) == "Yes" || string(value) == "No":
+		p.event.BoolMetrics[p.name(name)] = string(value) == "Yes"
+	case string(name) == "Schema":
+		p.event.Db = string(value)
+	case string(name) == "Log_slow_rate_type":
+		p.event.RateType = string(value)
+	case string(name) == "Log_slow_rate_limit":
+		val, _ := strconv.ParseUint(string(value), 10, 64)
+		p.event.RateLimit = uint(val)
+	default:
+		val, _ := strconv.ParseUint(string(value), 10, 64)
+		p.event.NumberMetrics[p.name(name)] = val
+	}
+}
+
+// name returns the metric name as a string, which is allocated only the first
+// time the parser sees the name.
+func (p *SlowLogParser) name(b []byte) string {
+	if name, ok := p.names[string(b)]; ok {
+		return name
+	}
+	name := string(b)
+	p.names[name] = name
+	return name
+}
+
+func (p *SlowLogParser) parseQuery(line []byte) {
+	if bytes.HasPrefix(line, adminPrefix) {
+		p.parseAdmin(line)
+		return
+	} else if isHeader(line) {
+		p.inHeader = true
+		p.inQuery = false
+		p.sendEvent(true, false)
+		p.parseHeader(line)
+		return
+	}
+
+	if p.queryLines == 0 && isUse(line) {
+		// "use db;" sets the db, and it's the query if there's no other,
+		// i.e. if the user only ran the use command.
+		db := bytes.TrimRight(line[len(usePrefix):], ";")
+		p.event.Db = string(bytes.Trim(db, "`"))
+		p.query.Reset()
+		p.query.Write(line)
+	} else if isSet(line) {
+		// SET timestamp=N; etc. are session state, not the query.
+	} else {
+		if p.queryLines > 0 {
+			p.query.WriteByte('\n')
+		} else {
+			p.query.Reset()
+		}
+		p.query.Write(line)
+		p.queryLines++
+	}
+}
+
+func (p *SlowLogParser) parseAdmin(line []byte) {
+	m := adminRe.FindSubmatch(line)
+	if len(m) < 2 {
+		return
+	}
+	p.event.Admin = true
+	command := bytes.TrimSuffix(m[1], []byte(";")) // makes FilterAdminCommand work
+	p.query.Reset()
+	p.query.Write(command)
+
+	// The admin command is the last line of the event.  A filtered command,
+	// e.g. Binlog Dump, is discarded with its event.
+	if !p.opts.FilterAdminCommand[string(command)] {
+		p.sendEvent(false, false)
+	} else {
+		p.event = log.NewEvent()
+		p.query.Reset()
+		p.headerLines = 0
+		p.queryLines = 0
+		p.inHeader = false
+		p.inQuery = false
+	}
+}
+
+func (p *SlowLogParser) sendEvent(inHeader bool, inQuery bool) {
+	// Make a new event and reset our metadata.
+	defer func() {
+		p.event = log.NewEvent()
+		p.query.Reset()
+		p.headerLines = 0
+		p.queryLines = 0
+		p.inHeader = inHeader
+		p.inQuery = inQuery
+	}()
+
+	if _, ok := p.event.TimeMetrics["Query_time"]; !ok {
+		// Started parsing in the header after Query_time, e.g. at an offset
+		// in the middle of an event, so it's incomplete.
+		return
+	}
+
+	p.event.Query = string(bytes.TrimSuffix(p.query.Bytes(), []byte(";")))
+
+	// Send the event.  This will block.
+	select {
+	case p.eventChan <- p.event:
+	case <-p.stopChan:
+		p.stopped = true
+	}
+}
+
+// isHeader matches ^#\s+[A-Z], e.g. "# Query_time: ...".
+func isHeader(line []byte) bool {
+	if len(line) < 3 || line[0] != '#' || !isSpace(line[1]) {
+		return false
+	}
+	for _, c := range line[2:] {
+		if !isSpace(c) {
+			return c >= 'A' && c <= 'Z'
+		}
+	}
+	return false
+}
+
+// isMetaLine matches the lines that MySQL writes when it opens the slow log.
+// The line includes the \n.
+func isMetaLine(line []byte) bool {
+	if len(line) < 20 {
+		return false
+	}
+	if line[0] == '/' && bytes.HasSuffix(line, startedWith) {
+		return true
+	}
+	for _, prefix := range metaPrefixes {
+		if bytes.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUse matches ^(?i)use , e.g. "USE db;".
+func isUse(line []byte) bool {
+	return len(line) >= len(usePrefix) && bytes.EqualFold(line[0:len(usePrefix)], usePrefix)
+}
+
+// isSet matches ^SET (?:last_insert_id|insert_id|timestamp).
+func isSet(line []byte) bool {
+	for _, prefix := range setPrefixes {
+		if bytes.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
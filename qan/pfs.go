@@ -63,6 +63,7 @@ func (w *PfsWorker) Status() string {
 }
 
 func (w *PfsWorker) Run(job *Job) (*Result, error) {
+	defer w.status.Discard() // worker is discarded after one job
 	defer w.status.Update(w.name, "Idle")
 
 	w.status.Update(w.name, "Connecting to MySQL")
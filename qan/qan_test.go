@@ -18,6 +18,7 @@
 package qan_test
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -32,6 +33,7 @@ import (
 	. "github.com/go-test/test"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/go-mysql/event"
+	"github.com/percona/go-mysql/log"
 	gomysql "github.com/percona/go-mysql/test"
 	"github.com/percona/percona-agent/instance"
 	"github.com/percona/percona-agent/mysql"
@@ -201,6 +203,158 @@ func (s *SlowLogWorkerTestSuite) TestWorkerSlow011(t *C) {
 	}
 }
 
+func (s *SlowLogWorkerTestSuite) TestWorkerSlow001Gzip(t *C) {
+	// logrotate can rotate and compress the slow log before it's parsed.
+	// The worker should parse the .gz file like the original, to its end.
+	data, err := ioutil.ReadFile(inputDir + "slow001.log")
+	t.Assert(err, IsNil)
+	tmpFile, err := ioutil.TempFile("/tmp", "slow001.log.")
+	t.Assert(err, IsNil)
+	tmpFile.Close()
+	gzFile := tmpFile.Name() + ".gz"
+	os.Rename(tmpFile.Name(), gzFile)
+	defer os.Remove(gzFile)
+	writeGzip(t, gzFile, data)
+
+	job := &qan.Job{
+		SlowLogFile:    gzFile,
+		StartOffset:    0,
+		EndOffset:      qan.END_OF_FILE,
+		RunTime:        time.Duration(3 * time.Second),
+		ZeroRunTime:    true,
+		ExampleQueries: true,
+	}
+	got, err := s.RunSlowLogWorker(job)
+	t.Assert(err, IsNil)
+	t.Check(job.EndOffset, Equals, int64(len(data)))
+	expect := &qan.Result{}
+	test.LoadMmReport(outputDir+"slow001.json", expect)
+	sort.Sort(ByQueryId(got.Class))
+	sort.Sort(ByQueryId(expect.Class))
+	if ok, diff := IsDeeply(got, expect); !ok {
+		Dump(got)
+		t.Error(diff)
+	}
+}
+
+// Run with go test -check.b -check.f BenchmarkWorker to catch slow log
+// parsing throughput regressions in the worker or the parser.
+func (s *SlowLogWorkerTestSuite) BenchmarkWorker(t *C) {
+	data, err := ioutil.ReadFile(inputDir + "slow001.log")
+	t.Assert(err, IsNil)
+	tmpFile, err := ioutil.TempFile("/tmp", "slow-bench.log.")
+	t.Assert(err, IsNil)
+	defer os.Remove(tmpFile.Name())
+	for i := 0; i < 1000; i++ {
+		tmpFile.Write(data)
+	}
+	tmpFile.Close()
+	size := int64(len(data) * 1000)
+
+	t.SetBytes(size)
+	t.ResetTimer()
+	for i := 0; i < t.N; i++ {
+		job := &qan.Job{
+			SlowLogFile:    tmpFile.Name(),
+			EndOffset:      size,
+			RunTime:        time.Minute,
+			ExampleQueries: true,
+		}
+		if _, err := s.RunSlowLogWorker(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (s *SlowLogWorkerTestSuite) TestSlowLogParser(t *C) {
+	// A query longer than the parser buffer is read in parts.
+	long := "SELECT '" + strings.Repeat("x", qan.PARSER_BUFFER_SIZE) + "'"
+	data := `/usr/sbin/mysqld, Version: 5.6.24-log (MySQL Community Server (GPL)). started with:
+Tcp port: 3306  Unix socket: /var/lib/mysql/mysql.sock
+Time                 Id Command    Argument
+# Time: 071015 21:43:52
+# User@Host: root[root] @ localhost []
+# Query_time: 2  Lock_time: 0  Rows_sent: 1  Rows_examined: 0
+# QC_Hit: No  Full_scan: Yes
+use test;
+SET timestamp=1192484632;
+select sleep(2) from n
+where 1;
+# User@Host: repl[repl] @ slave []
+# Query_time: 0.5  Lock_time: 0
+# administrator command: Binlog Dump;
+# User@Host: root[root] @ localhost []
+# Query_time: 0.1  Lock_time: 0
+# administrator command: Quit;
+# User@Host: root[root] @ localhost []
+# Query_time: 3  Lock_time: 0
+` + long + `;
+# User@Host: root[root] @ localhost []
+# Query_time: 4  Lock_time: 0
+select 1;
+# Query_time: 5`
+
+	file, err := ioutil.TempFile(t.MkDir(), "slow.log.")
+	t.Assert(err, IsNil)
+	defer file.Close()
+	_, err = file.WriteString(data)
+	t.Assert(err, IsNil)
+	_, err = file.Seek(0, os.SEEK_SET)
+	t.Assert(err, IsNil)
+
+	opts := log.Options{FilterAdminCommand: map[string]bool{"Binlog Dump": true}}
+	p := qan.NewSlowLogParser(file, opts)
+	defer p.Stop()
+	go p.Start()
+	got := []*log.Event{}
+	for e := range p.EventChan() {
+		got = append(got, e)
+	}
+
+	expect := []*log.Event{log.NewEvent(), log.NewEvent(), log.NewEvent(), log.NewEvent()}
+	expect[0].Offset = 183
+	expect[0].Ts = "071015 21:43:52"
+	expect[0].User = "root"
+	expect[0].Host = "localhost"
+	expect[0].Db = "test"
+	expect[0].Query = "select sleep(2) from n\nwhere 1"
+	expect[0].TimeMetrics = map[string]float64{"Query_time": 2, "Lock_time": 0}
+	expect[0].NumberMetrics = map[string]uint64{"Rows_sent": 1, "Rows_examined": 0}
+	expect[0].BoolMetrics = map[string]bool{"QC_Hit": false, "Full_scan": true}
+	// Binlog Dump is filtered.
+	expect[1].Offset = 510
+	expect[1].Admin = true
+	expect[1].User = "root"
+	expect[1].Host = "localhost"
+	expect[1].Query = "Quit"
+	expect[1].TimeMetrics = map[string]float64{"Query_time": 0.1, "Lock_time": 0}
+	expect[2].Offset = 612
+	expect[2].User = "root"
+	expect[2].Host = "localhost"
+	expect[2].Query = long
+	expect[2].TimeMetrics = map[string]float64{"Query_time": 3, "Lock_time": 0}
+	expect[3].Offset = uint64(612 + 69 + len(long) + 2)
+	expect[3].User = "root"
+	expect[3].Host = "localhost"
+	expect[3].Query = "select 1"
+	expect[3].TimeMetrics = map[string]float64{"Query_time": 4, "Lock_time": 0}
+	// The last line is incomplete, so it's not parsed.
+	if ok, diff := IsDeeply(got, expect); !ok {
+		Dump(got)
+		t.Error(diff)
+	}
+}
+
+func writeGzip(t *C, filename string, data []byte) {
+	file, err := os.Create(filename)
+	t.Assert(err, IsNil)
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	_, err = gz.Write(data)
+	t.Assert(err, IsNil)
+	t.Assert(gz.Close(), IsNil)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager test suite
 /////////////////////////////////////////////////////////////////////////////
@@ -1389,14 +1543,14 @@ func (s *IntervalTestSuite) TestIterFile(t *C) {
 	i.Start()
 
 	// Send a tick to start the interval
-	t1 := time.Now()
+	t1 := time.Now().UTC()
 	tickChan <- t1
 
 	// Write more data to the file, pretend time passes...
 	_ = ioutil.WriteFile(tmpFile.Name(), []byte("123456"), 0777)
 
 	// Send a 2nd tick to finish the interval
-	t2 := time.Now()
+	t2 := time.Now().UTC()
 	tickChan <- t2
 
 	// Get the interval
@@ -1428,7 +1582,7 @@ func (s *IntervalTestSuite) TestIterFile(t *C) {
 	tmpFile.Close()
 	_ = ioutil.WriteFile(fileName, []byte("123456789A"), 0777)
 
-	t3 := time.Now()
+	t3 := time.Now().UTC()
 	tickChan <- t3
 
 	got = <-i.IntervalChan()
@@ -1445,7 +1599,7 @@ func (s *IntervalTestSuite) TestIterFile(t *C) {
 	// Iter should no longer detect file change.
 	_ = ioutil.WriteFile(fileName, []byte("123456789ABCDEF"), 0777)
 	//                                               ^^^^^ new data
-	t4 := time.Now()
+	t4 := time.Now().UTC()
 	tickChan <- t4
 
 	got = <-i.IntervalChan()
@@ -1462,6 +1616,56 @@ func (s *IntervalTestSuite) TestIterFile(t *C) {
 	i.Stop()
 }
 
+func (s *IntervalTestSuite) TestIterFileGzipRotated(t *C) {
+	tickChan := make(chan time.Time)
+
+	tmpFile, _ := ioutil.TempFile("/tmp", "interval_test.")
+	tmpFile.Close()
+	fileName = tmpFile.Name()
+	_ = ioutil.WriteFile(fileName, []byte("123"), 0777)
+	defer func() { os.Remove(fileName) }()
+
+	i := qan.NewFileIntervalIter(s.logger, getFilename, tickChan)
+	i.Start()
+	defer i.Stop()
+
+	t1 := time.Now().UTC()
+	tickChan <- t1
+
+	// More data is written, then logrotate rotates and compresses the slow
+	// log and MySQL writes to a new one.
+	time.Sleep(10 * time.Millisecond)
+	gzFile := fileName + ".1.gz"
+	writeGzip(t, gzFile, []byte("123456"))
+	defer os.Remove(gzFile)
+	_ = ioutil.WriteFile(fileName+".tmp", []byte("1234"), 0777)
+	os.Rename(fileName+".tmp", fileName)
+
+	t2 := time.Now().UTC()
+	tickChan <- t2
+
+	// The rest of the rotated slow log is parsed first...
+	got := <-i.IntervalChan()
+	t.Check(got.Number, Equals, 1)
+	t.Check(got.Filename, Equals, gzFile)
+	t.Check(got.StartTime, Equals, t1)
+	t.Check(got.StartOffset, Equals, int64(3))
+	t.Check(got.EndOffset, Equals, int64(qan.END_OF_FILE))
+	rotated := got.StopTime
+
+	// ...then the new slow log from its beginning.
+	got = <-i.IntervalChan()
+	expect := &qan.Interval{
+		Number:      2,
+		Filename:    fileName,
+		StartTime:   rotated,
+		StopTime:    t2,
+		StartOffset: 0,
+		EndOffset:   4,
+	}
+	t.Check(got, test.DeepEquals, expect)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // MakeReport (Result -> Report)
 /////////////////////////////////////////////////////////////////////////////
@@ -1505,6 +1709,15 @@ func (s *ReportTestSuite) TestResult001(t *C) {
 	t.Check(report.Class[4].Id, Equals, "5000000000000005")
 	t.Check(report.Class[4].Metrics.TimeMetrics["Query_time"].Sum, Equals, float64(0.101001))
 
+	// Tables per class, parsed from fingerprints.
+	t.Check(report.Tables, DeepEquals, map[string][]string{
+		"1000000000000001": []string{"t"},
+		"2000000000000002": []string{"user"},
+		"3000000000000003": []string{"data"},
+		"4000000000000004": []string{"old_table"},
+		"5000000000000005": []string{"user"},
+	})
+
 	// Limit=2 results in top 2 queries and the rest in 1 LRQ "query".
 	config.ReportLimit = 2
 	report = qan.MakeReport(config, interval, result)
@@ -1516,6 +1729,11 @@ func (s *ReportTestSuite) TestResult001(t *C) {
 	t.Check(report.Class[1].Id, Equals, "2000000000000002")
 	t.Check(report.Class[1].Metrics.TimeMetrics["Query_time"].Sum, Equals, float64(2))
 
+	t.Check(report.Tables, DeepEquals, map[string][]string{
+		"3000000000000003": []string{"data"},
+		"2000000000000002": []string{"user"},
+	})
+
 	t.Check(int(report.Class[2].TotalQueries), Equals, 3)
 	t.Check(report.Class[2].Id, Equals, "0")
 	t.Check(report.Class[2].Metrics.TimeMetrics["Query_time"].Sum, Equals, float64(1+1+0.101001))
@@ -1524,6 +1742,31 @@ func (s *ReportTestSuite) TestResult001(t *C) {
 	t.Check(report.Class[2].Metrics.TimeMetrics["Query_time"].Avg, Equals, float64(0.505))
 }
 
+func (s *ReportTestSuite) TestTimestamps(t *C) {
+	// Interval times can be in any zone, e.g. US Eastern, but report times
+	// are UTC.
+	est := time.FixedZone("EST", -5*3600)
+	result := &qan.Result{Global: &event.GlobalClass{}}
+	config := qan.Config{
+		ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+		CollectFrom:     "slowlog",
+	}
+	start := time.Date(2015, 1, 10, 8, 0, 0, 0, est)
+	interval := &qan.Interval{Filename: "slow.log", StartTime: start, StopTime: start.Add(time.Minute)}
+	report := qan.MakeReport(config, interval, result)
+	t.Check(report.StartTs.Location(), Equals, time.UTC)
+	t.Check(report.StartTs, Equals, time.Date(2015, 1, 10, 13, 0, 0, 0, time.UTC))
+	t.Check(report.EndTs, Equals, time.Date(2015, 1, 10, 13, 1, 0, 0, time.UTC))
+
+	// Slow log times are UTC or in the system time zone of MySQL, which is
+	// UTC-5 in winter and UTC-4 in summer (DST) for US Eastern.
+	t.Check(qan.TzOffset("UTC", -5*3600), Equals, "+00:00")
+	t.Check(qan.TzOffset("SYSTEM", -5*3600), Equals, "-05:00")
+	t.Check(qan.TzOffset("SYSTEM", -4*3600), Equals, "-04:00")
+	t.Check(qan.TzOffset("", 0), Equals, "+00:00")           // before MySQL 5.7
+	t.Check(qan.TzOffset("SYSTEM", 19800), Equals, "+05:30") // India
+}
+
 func (s *SlowLogWorkerTestSuite) TestResult014(t *C) {
 	job := &qan.Job{
 		SlowLogFile:    inputDir + "slow014.log",
@@ -1794,3 +2037,25 @@ func (s *ValidateConfigTestSuite) TestValidateConfig(t *C) {
 	err = qan.ValidateConfig(config)
 	t.Check(err, NotNil)
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// QueryTables
+/////////////////////////////////////////////////////////////////////////////
+
+type TablesTestSuite struct{}
+
+var _ = Suite(&TablesTestSuite{})
+
+func (s *TablesTestSuite) TestQueryTables(t *C) {
+	t.Check(qan.QueryTables("select c from t where id=?", "sakila"), DeepEquals, []string{"sakila.t"})
+	t.Check(qan.QueryTables("select sleep(?) from test.n", "sakila"), DeepEquals, []string{"test.n"})
+	t.Check(qan.QueryTables("insert into foo values(?+)", ""), DeepEquals, []string{"foo"})
+	t.Check(qan.QueryTables("select a.x, b.y from t1 a join db2.t2 as b on a.id=b.id left join t4 using (id) where x in (select id from t5)", "d"),
+		DeepEquals, []string{"d.t1", "d.t4", "d.t5", "db2.t2"})
+	t.Check(qan.QueryTables("update t1 a, t2 b set a.x=b.x", ""), DeepEquals, []string{"t1", "t2"})
+	t.Check(qan.QueryTables("insert into t7 (a) values (?) on duplicate key update a = ?", ""), DeepEquals, []string{"t7"})
+	t.Check(qan.QueryTables("select * from (select id from t6) as x for update", ""), DeepEquals, []string{"t6"})
+	t.Check(qan.QueryTables("TRUNCATE `events_statements_summary_by_digest` ", "performance_schema"), DeepEquals, []string{"performance_schema.events_statements_summary_by_digest"})
+	t.Check(qan.QueryTables("select ? from dual", ""), DeepEquals, []string{})
+	t.Check(qan.QueryTables("SELECT NOW ( ) ", ""), DeepEquals, []string{})
+}
@@ -20,7 +20,9 @@ package qan
 import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/go-mysql/event"
+	"github.com/percona/percona-agent/pct"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -33,6 +35,7 @@ type Result struct {
 	Class      []*event.QueryClass // per-class metrics
 	RunTime    float64             // seconds parsing data, hopefully < interval
 	StopOffset int64               // slow log offset where parsing stopped, should be <= end offset
+	RateLimit  uint                `json:",omitempty"` // counts and sums scaled by slow log rate limit
 	Error      string              `json:",omitempty"`
 }
 
@@ -46,11 +49,14 @@ type Report struct {
 	RunTime               float64             // seconds parsing data
 	Global                *event.GlobalClass  // metrics for all data
 	Class                 []*event.QueryClass // per-class metrics
+	Tables                map[string][]string `json:",omitempty"` // per-class tables, e.g. db.t, keyed on class Id
 	// slow log:
 	SlowLogFile string `json:",omitempty"` // not slow_query_log_file if rotated
 	StartOffset int64  `json:",omitempty"` // parsing starts
 	EndOffset   int64  `json:",omitempty"` // parsing stops, but...
 	StopOffset  int64  `json:",omitempty"` // ...parsing didn't complete if stop < end
+	RateLimit   uint   `json:",omitempty"` // counts and sums scaled by log_slow_rate_limit
+	TzOffset    string `json:",omitempty"` // of slow log times, e.g. "-05:00", see TzOffset
 }
 
 type ByQueryTime []*event.QueryClass
@@ -68,10 +74,11 @@ func MakeReport(config Config, interval *Interval, result *Result) *Report {
 	sort.Sort(ByQueryTime(result.Class))
 
 	// Make Report from Result and other metadata (e.g. Interval).
+	adjust := pct.ClockAdjustment()
 	report := &Report{
 		ServiceInstance: config.ServiceInstance,
-		StartTs:         interval.StartTime,
-		EndTs:           interval.StopTime,
+		StartTs:         interval.StartTime.Add(adjust).UTC(),
+		EndTs:           interval.StopTime.Add(adjust).UTC(),
 		RunTime:         result.RunTime,
 		Global:          result.Global,
 		Class:           result.Class,
@@ -82,17 +89,20 @@ func MakeReport(config Config, interval *Interval, result *Result) *Report {
 		report.StartOffset = interval.StartOffset
 		report.EndOffset = interval.EndOffset
 		report.StopOffset = result.StopOffset
+		report.RateLimit = result.RateLimit
 	}
 
 	// Return all query classes if there's no limit or number of classes is
 	// less than the limit.
 	n := len(result.Class)
 	if config.ReportLimit == 0 || n <= int(config.ReportLimit) {
+		report.Tables = classTables(report.Class)
 		return report // all classes, no LRQ
 	}
 
 	// Top queries
 	report.Class = result.Class[0:config.ReportLimit]
+	report.Tables = classTables(report.Class)
 
 	// Low-ranking Queries
 	lrq := event.NewQueryClass("0", "", false)
@@ -104,6 +114,26 @@ func MakeReport(config Config, interval *Interval, result *Result) *Report {
 	return report // top classes, the rest as LRQ
 }
 
+// classTables returns the tables that each class accesses, so the API can
+// tell which queries use a table, e.g. before changing it.  Unqualified tables
+// are in the default db of the class example, if any.
+func classTables(classes []*event.QueryClass) map[string][]string {
+	tables := make(map[string][]string)
+	for _, class := range classes {
+		db := ""
+		if class.Example != nil {
+			db = class.Example.Db
+		}
+		if t := QueryTables(class.Fingerprint, db); len(t) > 0 {
+			tables[class.Id] = t
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	return tables
+}
+
 func addQuery(dst, src *event.QueryClass) {
 	dst.TotalQueries++
 	for srcMetric, srcStats := range src.Metrics.TimeMetrics {
@@ -124,3 +154,16 @@ func addQuery(dst, src *event.QueryClass) {
 		}
 	}
 }
+
+// TzOffset returns the offset from UTC of slow log times, like "+02:00".
+// Slow log times without a zone, e.g. "# Time: 071015 21:43:52" in example
+// queries, are UTC if log_timestamps=UTC (MySQL 5.7 default), else the
+// system time zone of mysqld (system_time_zone), regardless of time_zone.
+// systemOffset is the offset of the system time zone in seconds.  It's per
+// report because it changes with DST.
+func TzOffset(logTimestamps string, systemOffset int) string {
+	if strings.ToUpper(logTimestamps) == "UTC" {
+		return "+00:00"
+	}
+	return time.Unix(0, 0).In(time.FixedZone("", systemOffset)).Format("-07:00")
+}
@@ -0,0 +1,88 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package qan
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Interval.EndOffset of a compressed slow log: the uncompressed size isn't
+// known until it's read, so the worker parses it to the end.
+const END_OF_FILE = -1
+
+// openSlowLog opens the slow log file for reading.  A gzipped slow log (.gz),
+// e.g. one that logrotate rotated and compressed, is uncompressed into an
+// unlinked temp file so it can be parsed, and seeked, like any other slow log.
+// The temp file is deleted when the returned file is closed.
+func openSlowLog(filename string) (*os.File, error) {
+	file, err := os.Open(filename)
+	if err != nil || !strings.HasSuffix(filename, ".gz") {
+		return file, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tmpFile, err := ioutil.TempFile("", "percona-agent-qan-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(tmpFile.Name()) // still readable until closed
+	if _, err := io.Copy(tmpFile, gz); err != nil {
+		tmpFile.Close()
+		return nil, err
+	}
+	if _, err := tmpFile.Seek(0, os.SEEK_SET); err != nil {
+		tmpFile.Close()
+		return nil, err
+	}
+	return tmpFile, nil
+}
+
+// rotatedSlowLog returns the gzipped slow log, and its mtime, that logrotate
+// rotated from the slow log file since the given time, e.g. slow.log.1.gz or
+// slow.log-20150102.gz, else an empty string.  gzip keeps the mtime of the
+// original file, so the newest one last modified since then has the rest of
+// the data that was written to the slow log before it was rotated.
+func rotatedSlowLog(filename string, since time.Time) (string, time.Time) {
+	files, _ := filepath.Glob(filename + "*.gz")
+	rotated := ""
+	modTime := time.Time{}
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if fi.ModTime().Before(since) || !fi.ModTime().After(modTime) {
+			continue
+		}
+		rotated = file
+		modTime = fi.ModTime().UTC()
+	}
+	return rotated, modTime
+}
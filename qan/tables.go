@@ -0,0 +1,100 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package qan
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	tableRe    = regexp.MustCompile(`^[A-Za-z0-9_$]+(\.[A-Za-z0-9_$]+)?$`)
+	tokenRe    = regexp.MustCompile("[(),;]|[^\\s(),;]+")
+	notAliases = map[string]bool{
+		"where": true, "set": true, "join": true, "inner": true, "left": true,
+		"right": true, "cross": true, "straight_join": true, "natural": true,
+		"outer": true, "on": true, "using": true, "group": true, "order": true,
+		"limit": true, "having": true, "union": true, "values": true,
+		"value": true, "select": true, "partition": true, "force": true,
+		"use": true, "ignore": true, "for": true, "lock": true, "into": true,
+		"window": true, "procedure": true,
+	}
+)
+
+// QueryTables returns the tables in the query fingerprint, e.g. "db.t" for
+// "select c from db.t where id=?", sorted and unique.  Unqualified tables are
+// in db, if not empty.  It's a best-effort parse of table lists after FROM,
+// JOIN, INTO, UPDATE, TABLE and TRUNCATE, not a SQL parser: subqueries are
+// found, but table functions and views are reported as tables.
+func QueryTables(fingerprint, db string) []string {
+	tokens := tokenRe.FindAllString(strings.Replace(fingerprint, "`", "", -1), -1)
+	seen := make(map[string]bool)
+	add := func(table string) {
+		if !strings.Contains(table, ".") && db != "" {
+			table = db + "." + table
+		}
+		seen[table] = true
+	}
+	for i := 0; i < len(tokens); i++ {
+		switch strings.ToLower(tokens[i]) {
+		case "truncate":
+			if i+1 < len(tokens) && strings.ToLower(tokens[i+1]) == "table" {
+				i++
+			}
+			i = tableList(tokens, i+1, add)
+		case "update":
+			if i > 0 && strings.ToLower(tokens[i-1]) == "key" {
+				continue // on duplicate key update
+			}
+			i = tableList(tokens, i+1, add)
+		case "from", "join", "straight_join", "into", "table":
+			i = tableList(tokens, i+1, add)
+		}
+	}
+	tables := make([]string, 0, len(seen))
+	for table := range seen {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// tableList adds the tables of a list like "t1 a, t2 as b" starting at
+// tokens[i], and returns the index of the last token in the list.
+func tableList(tokens []string, i int, add func(string)) int {
+	for i < len(tokens) && isTable(tokens[i]) {
+		add(tokens[i])
+		i++
+		if i < len(tokens) && strings.ToLower(tokens[i]) == "as" {
+			i += 2
+		} else if i < len(tokens) && tableRe.MatchString(tokens[i]) && !notAliases[strings.ToLower(tokens[i])] {
+			i++ // alias
+		}
+		if i >= len(tokens) || tokens[i] != "," {
+			break
+		}
+		i++
+	}
+	return i - 1
+}
+
+func isTable(token string) bool {
+	t := strings.ToLower(token)
+	return tableRe.MatchString(token) && !notAliases[t] && t != "dual" && t != "outfile" && t != "dumpfile"
+}
@@ -22,14 +22,19 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/go-mysql/event"
 	"github.com/percona/go-mysql/log"
-	parser "github.com/percona/go-mysql/log/slow"
 	"github.com/percona/go-mysql/query"
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
 	"os"
+	"os/user"
+	"strconv"
 	"time"
 )
 
+// How often a SlowLogWorker updates its status while parsing.  Busy servers log
+// millions of queries per interval, so it's not done for every event.
+const PROGRESS_INTERVAL = 1 * time.Second
+
 type Job struct {
 	Id             string
 	SlowLogFile    string
@@ -84,11 +89,7 @@ type SlowLogWorker struct {
 	logger *pct.Logger
 	name   string
 	// --
-	status          *pct.Status
-	queryChan       chan string
-	fingerprintChan chan string
-	errChan         chan interface{}
-	doneChan        chan bool
+	status *pct.Status
 }
 
 func NewSlowLogWorker(logger *pct.Logger, name string) *SlowLogWorker {
@@ -96,11 +97,7 @@ func NewSlowLogWorker(logger *pct.Logger, name string) *SlowLogWorker {
 		logger: logger,
 		name:   name,
 		// --
-		status:          pct.NewStatus([]string{name}),
-		queryChan:       make(chan string, 1),
-		fingerprintChan: make(chan string, 1),
-		errChan:         make(chan interface{}, 1),
-		doneChan:        make(chan bool, 1),
+		status: pct.NewStatus([]string{name}),
 	}
 	return w
 }
@@ -116,19 +113,40 @@ func (w *SlowLogWorker) Status() string {
 func (w *SlowLogWorker) Run(job *Job) (*Result, error) {
 	w.logger.Debug("Run:call")
 	defer w.logger.Debug("Run:return")
+	defer w.status.Discard() // worker is discarded after one job
 
 	w.status.Update(w.name, "Starting job "+job.Id)
 	result := &Result{}
 
-	// Open the slow log file.
-	file, err := os.Open(job.SlowLogFile)
+	// Open the slow log file, uncompressing it if it was rotated and gzipped.
+	file, err := openSlowLog(job.SlowLogFile)
 	if err != nil {
+		if os.IsPermission(err) {
+			// Common when running as a dedicated user (-user): the slow log
+			// is owned by mysql and not readable by others.
+			username := strconv.Itoa(os.Getuid())
+			if u, err := user.Current(); err == nil {
+				username = u.Username
+			}
+			return nil, fmt.Errorf("Cannot read slow log %s: permission denied for user %s;"+
+				" make it readable by the user, e.g. add the user to the mysql group and chmod g+r the slow log",
+				job.SlowLogFile, username)
+		}
 		return nil, err
 	}
 	defer file.Close()
 
+	// Parse a compressed slow log to its end, which isn't known until now.
+	if job.EndOffset == END_OF_FILE {
+		if fi, err := file.Stat(); err == nil {
+			job.EndOffset = fi.Size()
+		}
+	}
+
 	// Create a slow log parser and run it.  It sends log.Event via its channel.
-	// Be sure to stop it when done, else we'll leak goroutines.
+	// Be sure to stop it when done, else we'll leak goroutines.  Parsing is
+	// the hot path, see SlowLogParser, so the worker keeps its own per-event
+	// work small too, see PROGRESS_INTERVAL and fingerprint.
 	opts := log.Options{
 		StartOffset: uint64(job.StartOffset),
 		FilterAdminCommand: map[string]bool{
@@ -136,7 +154,7 @@ func (w *SlowLogWorker) Run(job *Job) (*Result, error) {
 			"Binlog Dump GTID": true,
 		},
 	}
-	p := parser.NewSlowLogParser(file, opts)
+	p := NewSlowLogParser(file, opts)
 	defer p.Stop()
 	go func() {
 		defer func() {
@@ -162,20 +180,27 @@ func (w *SlowLogWorker) Run(job *Job) (*Result, error) {
 	progress := "Not started"
 	rateType := ""
 	rateLimit := uint(0)
-
-	go w.fingerprinter()
-	defer func() { w.doneChan <- true }()
+	offset := uint64(0)
+	updateProgress := func() {
+		progress = fmt.Sprintf("%.1f%% %d/%d %d %.1fs",
+			float64(offset)/float64(job.EndOffset)*100, offset, job.EndOffset, jobSize, runtime.Seconds())
+	}
+	lastProgress := -PROGRESS_INTERVAL // update on the first event
 
 	t0 := time.Now()
 EVENT_LOOP:
 	for event := range p.EventChan() {
 		runtime = time.Now().Sub(t0)
-		progress = fmt.Sprintf("%.1f%% %d/%d %d %.1fs",
-			float64(event.Offset)/float64(job.EndOffset)*100, event.Offset, job.EndOffset, jobSize, runtime.Seconds())
-		w.status.Update(w.name, fmt.Sprintf("Parsing %s: %s", job.SlowLogFile, progress))
+		offset = event.Offset
+		if runtime-lastProgress >= PROGRESS_INTERVAL {
+			lastProgress = runtime
+			updateProgress()
+			w.status.Update(w.name, "Parsing "+job.SlowLogFile+": "+progress)
+		}
 
 		// Check runtime, stop if exceeded.
 		if runtime >= job.RunTime {
+			updateProgress()
 			errMsg := fmt.Sprintf("Timeout parsing %s: %s", job, progress)
 			w.logger.Warn(errMsg)
 			result.Error = errMsg
@@ -202,16 +227,15 @@ EVENT_LOOP:
 			}
 		}
 
-		var fingerprint string
-		w.queryChan <- event.Query
-		select {
-		case fingerprint = <-w.fingerprintChan:
-			id := query.Id(fingerprint)
-			a.AddEvent(event, id, fingerprint)
-		case _ = <-w.errChan:
+		fingerprint, err := w.fingerprint(event.Query)
+		if err != nil {
 			w.logger.Warn(fmt.Sprintf("Cannot fingerprint '%s'", event.Query))
-			go w.fingerprinter()
+			continue
 		}
+		a.AddEvent(event, query.Id(fingerprint), fingerprint)
+	}
+	if offset > 0 {
+		updateProgress()
 	}
 
 	if result.StopOffset == 0 {
@@ -233,6 +257,13 @@ EVENT_LOOP:
 	result.Global = r.Global
 	result.Class = classes
 
+	// Percona Server log_slow_rate_limit=N logs only 1 of every N queries or
+	// sessions, so scale the counts and sums to estimate the real ones.
+	if rateLimit > 1 {
+		w.status.Update(w.name, fmt.Sprintf("Scaling job %s by %s rate limit %d", job.Id, rateType, rateLimit))
+		scaleResult(result, rateLimit)
+	}
+
 	// Zero the runtime for testing.
 	if !job.ZeroRunTime {
 		result.RunTime = time.Now().Sub(t0).Seconds()
@@ -243,21 +274,40 @@ EVENT_LOOP:
 	return result, nil
 }
 
-func (w *SlowLogWorker) fingerprinter() {
-	w.logger.Debug("fingerprinter:call")
-	defer w.logger.Debug("fingerprinter:return")
+// scaleResult multiplies the query counts and the metric counts and sums by
+// the slow log rate limit.  Min, max, avg, etc. are not scaled because they're
+// the same for a sample of the queries.
+func scaleResult(result *Result, rateLimit uint) {
+	result.RateLimit = rateLimit
+	result.Global.TotalQueries *= uint64(rateLimit)
+	scaleMetrics(result.Global.Metrics, rateLimit)
+	for _, class := range result.Class {
+		class.TotalQueries *= uint64(rateLimit)
+		scaleMetrics(class.Metrics, rateLimit)
+	}
+}
+
+func scaleMetrics(metrics *event.Metrics, rateLimit uint) {
+	for _, stats := range metrics.TimeMetrics {
+		stats.Cnt *= rateLimit
+		stats.Sum *= float64(rateLimit)
+	}
+	for _, stats := range metrics.NumberMetrics {
+		stats.Cnt *= rateLimit
+		stats.Sum *= uint64(rateLimit)
+	}
+	for _, stats := range metrics.BoolMetrics {
+		stats.Cnt *= rateLimit
+		stats.True *= rateLimit
+	}
+}
+
+// fingerprint returns the fingerprint of the query, or the panic of the
+// fingerprinter if it can't handle the query.  It's called for every event,
+// so it's called directly instead of through a goroutine and channels.
+func (w *SlowLogWorker) fingerprint(q string) (f string, err interface{}) {
 	defer func() {
-		if err := recover(); err != nil {
-			w.errChan <- err
-		}
+		err = recover()
 	}()
-	for {
-		select {
-		case q := <-w.queryChan:
-			f := query.Fingerprint(q)
-			w.fingerprintChan <- f
-		case <-w.doneChan:
-			return
-		}
-	}
+	return query.Fingerprint(q), nil
 }
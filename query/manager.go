@@ -18,31 +18,62 @@
 package query
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
 	"sync"
+	"time"
 )
 
 const (
 	SERVICE_NAME = "query"
 )
 
+// Cmds are handled concurrently for different instances, so a slow Explain on
+// one instance doesn't delay cmds for others, but each instance only runs
+// MAX_INSTANCE_CMDS at once so a stuck server doesn't pile up connections.
+// Cmds wait for a free slot up to QUEUE_TIMEOUT, and at most MAX_INSTANCE_QUEUE
+// cmds can be running or waiting per instance, else QueueFullError.  A cmd's
+// deadline, pct.DEFAULT_CMD_DEADLINE, starts when it's received, so the wait
+// is part of it and the reply is sent before the agent times out the cmd.
+const (
+	MAX_INSTANCE_CMDS  = 2
+	MAX_INSTANCE_QUEUE = 10
+)
+
+var QUEUE_TIMEOUT = pct.DEFAULT_CMD_DEADLINE // var for testing
+
 type Manager struct {
-	logger  *pct.Logger
-	explain Service
+	logger      *pct.Logger
+	explain     Service
+	indexes     Service
+	replication Service
 	// --
 	running bool
 	sync.Mutex
 	// --
 	status *pct.Status
+	queues map[string]*instanceQueue // keyed on instance name, e.g. mysql-1
+}
+
+// An instanceQueue is the cmds for one instance.  running is a semaphore of
+// MAX_INSTANCE_CMDS; queued is the cmds running or waiting, guarded by the
+// Manager mutex.
+type instanceQueue struct {
+	running chan bool
+	queued  uint
 }
 
-func NewManager(logger *pct.Logger, explain Service) *Manager {
+func NewManager(logger *pct.Logger, explain, indexes, replication Service) *Manager {
 	m := &Manager{
-		logger:  logger,
-		explain: explain,
+		logger:      logger,
+		explain:     explain,
+		indexes:     indexes,
+		replication: replication,
 		// --
 		status: pct.NewStatus([]string{SERVICE_NAME}),
+		queues: make(map[string]*instanceQueue),
 	}
 	return m
 }
@@ -71,25 +102,120 @@ func (m *Manager) Stop() error {
 }
 
 func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
-	m.Lock()
-	defer m.Unlock()
-
-	m.status.UpdateRe(SERVICE_NAME, "Handling", cmd)
-	defer m.status.Update(SERVICE_NAME, "Running")
+	deadline := time.Now().Add(pct.DEFAULT_CMD_DEADLINE)
 
+	var service Service
+	var status string
 	switch cmd.Cmd {
-	case "Explain":
-		m.status.UpdateRe(SERVICE_NAME, "Running explain", cmd)
-		return m.explain.Handle(cmd)
+	case "Explain", "ExplainBatch":
+		service = m.explain
+		status = "Running explain"
+	case "DuplicateIndexes":
+		service = m.indexes
+		status = "Checking indexes"
+	case "GetBinlogCoordinates":
+		service = m.replication
+		status = "Getting binlog coordinates"
 	default:
 		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 	}
+
+	m.status.UpdateRe(SERVICE_NAME, "Handling", cmd)
+
+	name := instanceName(cmd)
+	q, err := m.enqueue(cmd, name)
+	if err != nil {
+		return cmd.Reply(nil, err)
+	}
+	defer m.dequeue(name, q)
+
+	wait := QUEUE_TIMEOUT
+	if d := deadline.Sub(time.Now()); d < wait {
+		wait = d
+	}
+	select {
+	case q.running <- true:
+		defer func() { <-q.running }()
+	case <-time.After(wait):
+		m.logger.Warn(fmt.Sprintf("Timeout waiting for %s cmds to finish", name), cmd)
+		return cmd.Reply(nil, pct.CmdTimeoutError{Cmd: cmd.Cmd})
+	}
+
+	m.status.UpdateRe(SERVICE_NAME, status, cmd)
+	if ds, ok := service.(DeadlineService); ok {
+		return ds.HandleBy(cmd, deadline)
+	}
+	return service.Handle(cmd)
 }
 
 func (m *Manager) Status() map[string]string {
-	return m.status.All()
+	m.Lock()
+	defer m.Unlock()
+	queues := make(map[string]string)
+	for name, q := range m.queues {
+		running := uint(len(q.running))
+		queues[SERVICE_NAME+"-"+name] = fmt.Sprintf("%d running, %d waiting", running, q.queued-running)
+	}
+	return m.status.Merge(queues)
 }
 
 func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
 	return nil, nil
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+func (m *Manager) enqueue(cmd *proto.Cmd, name string) (*instanceQueue, error) {
+	m.Lock()
+	defer m.Unlock()
+	q, ok := m.queues[name]
+	if !ok {
+		q = &instanceQueue{
+			running: make(chan bool, MAX_INSTANCE_CMDS),
+		}
+		m.queues[name] = q
+	}
+	if q.queued >= MAX_INSTANCE_QUEUE {
+		return nil, pct.QueueFullError{Cmd: cmd.Cmd, Name: SERVICE_NAME + "-" + name, Size: MAX_INSTANCE_QUEUE}
+	}
+	q.queued++
+	return q, nil
+}
+
+func (m *Manager) dequeue(name string, q *instanceQueue) {
+	m.Lock()
+	defer m.Unlock()
+	q.queued--
+	if q.queued == 0 {
+		delete(m.queues, name) // don't keep removed instances
+	}
+	if len(m.queues) == 0 {
+		m.status.Update(SERVICE_NAME, "Running")
+	}
+}
+
+// instanceName returns the name of the instance that the cmd is for, e.g.
+// mysql-1, or "unknown" if the cmd data doesn't say.  An ExplainBatch is for
+// the instance of its queries, or "batch" if they're for several instances.
+func instanceName(cmd *proto.Cmd) string {
+	data := struct {
+		proto.ServiceInstance
+		Queries []proto.ServiceInstance
+	}{}
+	if cmd.Data != nil {
+		json.Unmarshal(cmd.Data, &data)
+	}
+	it := data.ServiceInstance
+	for i, q := range data.Queries {
+		if i > 0 && (q.Service != it.Service || q.InstanceId != it.InstanceId) {
+			return "batch"
+		}
+		it = q
+	}
+	if it.Service == "" {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s-%d", it.Service, it.InstanceId)
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/percona/percona-agent/test/mock"
 	. "gopkg.in/check.v1"
 	"testing"
+	"time"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -57,11 +58,13 @@ func (s *ManagerTestSuite) TearDownSuite(t *C) {
 func (s *ManagerTestSuite) TestStartStopHandleManager(t *C) {
 	var err error
 
-	// Create explain service
+	// Create explain, indexes and replication services
 	explainService := mock.NewQueryService()
+	indexesService := mock.NewQueryService()
+	replicationService := mock.NewQueryService()
 
 	// Create query manager
-	m := query.NewManager(s.logger, explainService)
+	m := query.NewManager(s.logger, explainService, indexesService, replicationService)
 	t.Assert(m, Not(IsNil), Commentf("Make new query.Manager"))
 
 	// The agent calls mm.Start().
@@ -85,6 +88,22 @@ func (s *ManagerTestSuite) TestStartStopHandleManager(t *C) {
 	t.Assert(gotReply, NotNil)
 	t.Assert(gotReply.Error, Equals, "")
 
+	cmd = &proto.Cmd{
+		Service: "query",
+		Cmd:     "DuplicateIndexes",
+	}
+	gotReply = m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Assert(gotReply.Error, Equals, "")
+
+	cmd = &proto.Cmd{
+		Service: "query",
+		Cmd:     "GetBinlogCoordinates",
+	}
+	gotReply = m.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Assert(gotReply.Error, Equals, "")
+
 	// Test unknown cmd
 	cmd = &proto.Cmd{
 		Service: "query",
@@ -100,3 +119,106 @@ func (s *ManagerTestSuite) TestStartStopHandleManager(t *C) {
 	status = m.Status()
 	t.Check(status[query.SERVICE_NAME], Equals, "Running")
 }
+
+// blockingService blocks Handle for mysql-1 until unblocked.
+type blockingService struct {
+	unblock chan bool
+}
+
+func (b *blockingService) Handle(cmd *proto.Cmd) *proto.Reply {
+	if string(cmd.Data) == `{"Service":"mysql","InstanceId":1}` {
+		<-b.unblock
+	}
+	return cmd.Reply(nil)
+}
+
+func (s *ManagerTestSuite) TestInstanceQueues(t *C) {
+	explainService := &blockingService{unblock: make(chan bool)}
+	m := query.NewManager(s.logger, explainService, mock.NewQueryService(), mock.NewQueryService())
+	err := m.Start()
+	t.Assert(err, IsNil)
+
+	stuck := &proto.Cmd{Service: "query", Cmd: "Explain", Data: []byte(`{"Service":"mysql","InstanceId":1}`)}
+	replies := make(chan *proto.Reply, query.MAX_INSTANCE_QUEUE+1)
+	for i := 0; i < query.MAX_INSTANCE_QUEUE; i++ {
+		go func() { replies <- m.Handle(stuck) }()
+	}
+	for m.Status()["query-mysql-1"] != fmt.Sprintf("%d running, %d waiting", query.MAX_INSTANCE_CMDS, query.MAX_INSTANCE_QUEUE-query.MAX_INSTANCE_CMDS) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// mysql-1 is stuck, but mysql-2 isn't blocked by it.
+	cmd := &proto.Cmd{Service: "query", Cmd: "Explain", Data: []byte(`{"Service":"mysql","InstanceId":2}`)}
+	go func() { replies <- m.Handle(cmd) }()
+	select {
+	case reply := <-replies:
+		t.Check(reply.Error, Equals, "")
+	case <-time.After(time.Second):
+		t.Fatal("Explain for mysql-2 blocked by mysql-1")
+	}
+
+	// mysql-1 queue is full.
+	reply := m.Handle(stuck)
+	t.Check(reply.Error, Matches, "Cannot handle Explain command because the query-mysql-1 queue is full.*\n")
+
+	// Unblocked, all mysql-1 cmds are handled and its queue is removed.
+	close(explainService.unblock)
+	for i := 0; i < query.MAX_INSTANCE_QUEUE; i++ {
+		reply := <-replies
+		t.Check(reply.Error, Equals, "")
+	}
+	_, ok := m.Status()["query-mysql-1"]
+	t.Check(ok, Equals, false)
+}
+
+// deadlineService records the deadline of each cmd and blocks until unblocked.
+type deadlineService struct {
+	deadlines chan time.Time
+	unblock   chan bool
+}
+
+func (d *deadlineService) Handle(cmd *proto.Cmd) *proto.Reply {
+	return d.HandleBy(cmd, time.Time{})
+}
+
+func (d *deadlineService) HandleBy(cmd *proto.Cmd, deadline time.Time) *proto.Reply {
+	d.deadlines <- deadline
+	<-d.unblock
+	return cmd.Reply(nil)
+}
+
+func (s *ManagerTestSuite) TestQueueWaitInDeadline(t *C) {
+	explainService := &deadlineService{
+		deadlines: make(chan time.Time, query.MAX_INSTANCE_CMDS+1),
+		unblock:   make(chan bool),
+	}
+	m := query.NewManager(s.logger, explainService, mock.NewQueryService(), mock.NewQueryService())
+	err := m.Start()
+	t.Assert(err, IsNil)
+
+	// Fill the running slots for mysql-1, then queue one more cmd.
+	cmd := &proto.Cmd{Service: "query", Cmd: "Explain", Data: []byte(`{"Service":"mysql","InstanceId":1}`)}
+	replies := make(chan *proto.Reply, query.MAX_INSTANCE_CMDS+1)
+	for i := 0; i < query.MAX_INSTANCE_CMDS; i++ {
+		go func() { replies <- m.Handle(cmd) }()
+		<-explainService.deadlines
+	}
+	t.Check(m.Status()[query.SERVICE_NAME], Equals, fmt.Sprintf("Running explain %s", cmd))
+
+	received := time.Now()
+	go func() { replies <- m.Handle(cmd) }()
+	time.Sleep(500 * time.Millisecond)
+	explainService.unblock <- true
+	<-replies
+
+	// The queued cmd's deadline starts when it's received, not when it runs.
+	deadline := <-explainService.deadlines
+	t.Check(deadline.Before(received.Add(pct.DEFAULT_CMD_DEADLINE+100*time.Millisecond)), Equals, true)
+	t.Check(deadline.After(received.Add(pct.DEFAULT_CMD_DEADLINE-100*time.Millisecond)), Equals, true)
+
+	close(explainService.unblock)
+	for i := 0; i < query.MAX_INSTANCE_CMDS; i++ {
+		<-replies
+	}
+	t.Check(m.Status()[query.SERVICE_NAME], Equals, "Running")
+}
@@ -19,8 +19,16 @@ package query
 
 import (
 	"github.com/percona/cloud-protocol/proto"
+	"time"
 )
 
 type Service interface {
 	Handle(cmd *proto.Cmd) (reply *proto.Reply)
 }
+
+// A DeadlineService replies by the deadline, e.g. with a partial reply, which
+// the Manager sets when the cmd is received so time spent waiting in the
+// instance queue counts against it.
+type DeadlineService interface {
+	HandleBy(cmd *proto.Cmd, deadline time.Time) (reply *proto.Reply)
+}
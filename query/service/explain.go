@@ -20,16 +20,57 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	driver "github.com/go-sql-driver/mysql"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/instance"
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	SERVICE_NAME = "explain"
 )
 
+// ExplainBatch is the Cmd.Data of the ExplainBatch cmd: many queries to
+// explain, replied to by the deadline with those that were explained.
+type ExplainBatch struct {
+	Queries []ExplainQuery
+	Timeout uint // seconds, at most and by default pct.DEFAULT_CMD_DEADLINE
+}
+
+// Explain error codes, see ExplainError.
+const (
+	ACCESS_DENIED = "ACCESS_DENIED"
+	UNKNOWN_TABLE = "UNKNOWN_TABLE"
+	SYNTAX_ERROR  = "SYNTAX_ERROR"
+)
+
+// An ExplainError is why MySQL could not explain the query.  It's returned as
+// the reply data so the API can tell the user how to fix it, e.g. which grants
+// the agent's MySQL user needs.
+type ExplainError struct {
+	Code    string   // ACCESS_DENIED, UNKNOWN_TABLE, or SYNTAX_ERROR
+	Message string   // MySQL error message
+	Grants  []string `json:",omitempty"` // for ACCESS_DENIED, GRANT statements that fix it
+}
+
+func (e *ExplainError) Error() string {
+	if len(e.Grants) > 0 {
+		return fmt.Sprintf("%s: %s; fix with: %s", e.Code, e.Message, strings.Join(e.Grants, "; "))
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// ExplainQuery is proto.ExplainQuery plus options the API may not send.
+type ExplainQuery struct {
+	proto.ExplainQuery
+	Analyze bool // run MariaDB ANALYZE FORMAT=JSON instead, replying *mysql.AnalyzeResult
+}
+
 type Explain struct {
 	logger      *pct.Logger
 	connFactory mysql.ConnectionFactory
@@ -50,41 +91,107 @@ func NewExplain(logger *pct.Logger, connFactory mysql.ConnectionFactory, ir *ins
 /////////////////////////////////////////////////////////////////////////////
 
 func (e *Explain) Handle(cmd *proto.Cmd) *proto.Reply {
+	return e.HandleBy(cmd, time.Now().Add(pct.DEFAULT_CMD_DEADLINE))
+}
+
+// HandleBy handles the cmd like Handle, but an ExplainBatch replies by the
+// deadline instead of pct.DEFAULT_CMD_DEADLINE from now.
+func (e *Explain) HandleBy(cmd *proto.Cmd, deadline time.Time) *proto.Reply {
+	if cmd.Cmd == "ExplainBatch" {
+		return e.handleBatch(cmd, deadline)
+	}
+
 	// Get explain query
 	explainQuery, err := e.getExplainQuery(cmd)
 	if err != nil {
 		return cmd.Reply(nil, err)
 	}
 
+	e.logger.Info("Running explain", e.getInstanceName(explainQuery.Service, explainQuery.InstanceId), cmd)
+
+	data, err := e.explain(explainQuery)
+	if err != nil {
+		return cmd.Reply(data, err)
+	}
+	return cmd.Reply(data)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// explain runs EXPLAIN, or ANALYZE if set, for the query.  If MySQL can't
+// explain it for a reason the user can fix, the *ExplainError is returned as
+// the data with the error.
+func (e *Explain) explain(explainQuery *ExplainQuery) (interface{}, error) {
 	// The real name of the internal service, e.g. query-mysql-1:
 	name := e.getInstanceName(explainQuery.Service, explainQuery.InstanceId)
 
-	e.logger.Info("Running explain", name, cmd)
-
 	// Create connector to MySQL instance
 	conn, err := e.createConn(explainQuery.Service, explainQuery.InstanceId)
 	if err != nil {
-		return cmd.Reply(nil, fmt.Errorf("Unable to create connector for %s: %s", name, err))
+		return nil, fmt.Errorf("Unable to create connector for %s: %s", name, err)
 	}
 	defer conn.Close()
 
 	// Connect to MySQL instance
 	if err := conn.Connect(2); err != nil {
-		return cmd.Reply(nil, fmt.Errorf("Unable to connect to %s: %s", name, err))
+		return nil, fmt.Errorf("Unable to connect to %s: %s", name, err)
+	}
+
+	if explainQuery.Analyze {
+		// Run analyze, which executes the query (MariaDB only).
+		analyze, err := conn.Analyze(explainQuery.Query, explainQuery.Db)
+		if err != nil {
+			if eerr := MakeExplainError(err, explainQuery.Db); eerr != nil {
+				return eerr, fmt.Errorf("Analyze failed for %s: %s", name, eerr)
+			}
+			return nil, fmt.Errorf("Analyze failed for %s: %s", name, err)
+		}
+		return analyze, nil
 	}
 
 	// Run explain
 	explain, err := conn.Explain(explainQuery.Query, explainQuery.Db)
 	if err != nil {
-		return cmd.Reply(nil, fmt.Errorf("Explain failed for %s: %s", name, err))
+		if eerr := MakeExplainError(err, explainQuery.Db); eerr != nil {
+			return eerr, fmt.Errorf("Explain failed for %s: %s", name, eerr)
+		}
+		return nil, fmt.Errorf("Explain failed for %s: %s", name, err)
 	}
-
-	return cmd.Reply(explain)
+	return explain, nil
 }
 
-/////////////////////////////////////////////////////////////////////////////
-// Implementation
-/////////////////////////////////////////////////////////////////////////////
+// handleBatch explains many queries until the deadline, or the batch timeout
+// if sooner, replying with a pct.PartialReply of the explains keyed on query
+// index, e.g. "0", and the queries not explained by then.
+func (e *Explain) handleBatch(cmd *proto.Cmd, deadline time.Time) *proto.Reply {
+	batch := &ExplainBatch{}
+	if err := json.Unmarshal(cmd.Data, batch); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("%s.handleBatch:json.Unmarshal:%s", SERVICE_NAME, err))
+	}
+
+	// Not later than the deadline, else the agent times out the cmd first.
+	timeout := deadline.Sub(time.Now())
+	if t := time.Duration(batch.Timeout) * time.Second; t > 0 && t < timeout {
+		timeout = t
+	}
+
+	e.logger.Info(fmt.Sprintf("Running %d explains", len(batch.Queries)), cmd)
+
+	ids := make([]string, len(batch.Queries))
+	for i := range batch.Queries {
+		ids[i] = strconv.Itoa(i)
+	}
+	reply := pct.RunItems(time.Now().Add(timeout), ids, func(id string) (interface{}, error) {
+		i, _ := strconv.Atoi(id)
+		return e.explain(&batch.Queries[i])
+	})
+	if len(reply.TimedOut) > 0 {
+		e.logger.Warn(fmt.Sprintf("%d of %d explains timed out after %s", len(reply.TimedOut), len(ids), timeout))
+	}
+	return cmd.Reply(reply)
+}
 
 func (e *Explain) getInstanceName(service string, instanceId uint) (name string) {
 	// The real name of the internal service, e.g. query-mysql-1:
@@ -106,7 +213,7 @@ func (e *Explain) createConn(service string, instanceId uint) (conn mysql.Connec
 	return conn, nil
 }
 
-func (e *Explain) getExplainQuery(cmd *proto.Cmd) (explainQuery *proto.ExplainQuery, err error) {
+func (e *Explain) getExplainQuery(cmd *proto.Cmd) (explainQuery *ExplainQuery, err error) {
 	if cmd.Data == nil {
 		return nil, fmt.Errorf("%s.getExplainQuery:cmd.Data is empty", SERVICE_NAME)
 	}
@@ -117,3 +224,48 @@ func (e *Explain) getExplainQuery(cmd *proto.Cmd) (explainQuery *proto.ExplainQu
 
 	return explainQuery, nil
 }
+
+var (
+	// SELECT command denied to user 'agent'@'localhost' for table 't'
+	tableDeniedRe = regexp.MustCompile(`^(.+) command denied to user '([^']*)'@'([^']*)' for (?:column '[^']*' in )?table '([^']*)'`)
+	// Access denied for user 'agent'@'localhost' to database 'db'
+	dbDeniedRe = regexp.MustCompile(`^Access denied for user '([^']*)'@'([^']*)' to database '([^']*)'`)
+)
+
+// MakeExplainError returns an *ExplainError for the MySQL errors that users
+// can fix, else nil.  db is the default database of the query, used for the
+// grants if MySQL doesn't say which database a table is in.
+func MakeExplainError(err error, db string) *ExplainError {
+	eerr := &ExplainError{}
+	if merr, ok := err.(*driver.MySQLError); ok {
+		eerr.Message = merr.Message
+	}
+	switch mysql.MySQLErrorCode(err) {
+	case mysql.ER_TABLEACCESS_DENIED_ERROR, mysql.ER_COLUMNACCESS_DENIED_ERROR:
+		eerr.Code = ACCESS_DENIED
+		if m := tableDeniedRe.FindStringSubmatch(eerr.Message); m != nil {
+			on := "*.*"
+			if db != "" {
+				on = fmt.Sprintf("`%s`.`%s`", db, m[4])
+			}
+			// EXPLAIN needs SELECT on tables, and SHOW VIEW too on views.
+			privs := m[1]
+			if privs != "SELECT" {
+				privs = "SELECT, " + privs
+			}
+			eerr.Grants = []string{fmt.Sprintf("GRANT %s ON %s TO '%s'@'%s'", privs, on, m[2], m[3])}
+		}
+	case mysql.ER_DBACCESS_DENIED_ERROR:
+		eerr.Code = ACCESS_DENIED
+		if m := dbDeniedRe.FindStringSubmatch(eerr.Message); m != nil {
+			eerr.Grants = []string{fmt.Sprintf("GRANT SELECT ON `%s`.* TO '%s'@'%s'", m[3], m[1], m[2])}
+		}
+	case mysql.ER_NO_SUCH_TABLE, mysql.ER_UNKNOWN_TABLE, mysql.ER_BAD_DB_ERROR:
+		eerr.Code = UNKNOWN_TABLE
+	case mysql.ER_PARSE_ERROR:
+		eerr.Code = SYNTAX_ERROR
+	default:
+		return nil
+	}
+	return eerr
+}
@@ -20,6 +20,8 @@ package service_test
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	driver "github.com/go-sql-driver/mysql"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/instance"
 	"github.com/percona/percona-agent/mysql"
@@ -321,3 +323,59 @@ func (s *ManagerTestSuite) TestExplainWithDb(t *C) {
 	t.Assert(err, IsNil)
 	t.Assert(gotExplainResult, DeepEquals, expectedExplainResult)
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// ExplainError test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type ExplainErrorTestSuite struct{}
+
+var _ = Suite(&ExplainErrorTestSuite{})
+
+func (s *ExplainErrorTestSuite) TestAccessDenied(t *C) {
+	err := &driver.MySQLError{
+		Number:  1142,
+		Message: "SELECT command denied to user 'percona-agent'@'localhost' for table 't1'",
+	}
+	got := service.MakeExplainError(err, "db1")
+	t.Assert(got, NotNil)
+	t.Check(got.Code, Equals, service.ACCESS_DENIED)
+	t.Check(got.Message, Equals, err.Message)
+	t.Check(got.Grants, DeepEquals, []string{"GRANT SELECT ON `db1`.`t1` TO 'percona-agent'@'localhost'"})
+
+	// Views need SHOW VIEW too.  Without a db, MySQL doesn't say which one.
+	err = &driver.MySQLError{
+		Number:  1142,
+		Message: "SHOW VIEW command denied to user 'percona-agent'@'%' for table 'v1'",
+	}
+	got = service.MakeExplainError(err, "")
+	t.Assert(got, NotNil)
+	t.Check(got.Grants, DeepEquals, []string{"GRANT SELECT, SHOW VIEW ON *.* TO 'percona-agent'@'%'"})
+
+	err = &driver.MySQLError{
+		Number:  1044,
+		Message: "Access denied for user 'percona-agent'@'localhost' to database 'db2'",
+	}
+	got = service.MakeExplainError(err, "db2")
+	t.Assert(got, NotNil)
+	t.Check(got.Code, Equals, service.ACCESS_DENIED)
+	t.Check(got.Grants, DeepEquals, []string{"GRANT SELECT ON `db2`.* TO 'percona-agent'@'localhost'"})
+	t.Check(got.Error(), Equals, "ACCESS_DENIED: "+err.Message+"; fix with: GRANT SELECT ON `db2`.* TO 'percona-agent'@'localhost'")
+}
+
+func (s *ExplainErrorTestSuite) TestOtherErrors(t *C) {
+	err := &driver.MySQLError{Number: 1146, Message: "Table 'db1.t9' doesn't exist"}
+	got := service.MakeExplainError(err, "db1")
+	t.Assert(got, NotNil)
+	t.Check(got.Code, Equals, service.UNKNOWN_TABLE)
+	t.Check(got.Grants, HasLen, 0)
+
+	err = &driver.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}
+	got = service.MakeExplainError(err, "")
+	t.Assert(got, NotNil)
+	t.Check(got.Code, Equals, service.SYNTAX_ERROR)
+
+	// Errors users can't fix by changing the query or grants aren't coded.
+	t.Check(service.MakeExplainError(&driver.MySQLError{Number: 2013, Message: "Lost connection"}, ""), IsNil)
+	t.Check(service.MakeExplainError(errors.New("Not connected"), ""), IsNil)
+}
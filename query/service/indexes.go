@@ -0,0 +1,361 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"sort"
+	"strings"
+)
+
+const (
+	INDEXES_SERVICE_NAME = "indexes"
+)
+
+// Schemas checked when IndexQuery.Schemas is empty are all but these.
+var SystemSchemas []string = []string{"mysql", "information_schema", "performance_schema", "sys"}
+
+// IndexQuery is the Cmd.Data of the query service DuplicateIndexes cmd.
+type IndexQuery struct {
+	proto.ServiceInstance
+	Schemas []string // all non-system schemas if empty
+	Unused  bool     // report indexes not used since MySQL started
+}
+
+// Key is one index of a table, from information_schema.statistics.
+type Key struct {
+	Schema  string
+	Table   string
+	Name    string
+	Columns []string // in index order, with prefix length, e.g. "name(10)"
+	Unique  bool
+	Type    string // BTREE, HASH, FULLTEXT, SPATIAL
+}
+
+// DuplicateKey is a Key that can be dropped because another Key of the same
+// table, DuplicateOf, has the same columns (duplicate) or starts with them
+// (redundant).
+type DuplicateKey struct {
+	Schema             string
+	Table              string
+	Key                string
+	Columns            []string
+	DuplicateOf        string
+	DuplicateOfColumns []string
+	Reason             string // "duplicate" or "redundant"
+	Fix                string // ALTER TABLE to drop Key
+}
+
+// UnusedKey is a Key with no reads or writes in performance_schema since
+// MySQL started.  It's a candidate to make invisible (MySQL 8.0+) and, if
+// nothing slows down, drop.
+type UnusedKey struct {
+	Schema string
+	Table  string
+	Key    string
+	Fix    string // ALTER TABLE to make Key invisible
+}
+
+type IndexReport struct {
+	Duplicate []DuplicateKey
+	Unused    []UnusedKey `json:",omitempty"`
+}
+
+// Indexes is a pt-duplicate-key-checker: it finds duplicate and redundant
+// indexes, and optionally unused indexes.  Like all query service cmds, it can
+// be run on a schedule by the scheduler service.
+type Indexes struct {
+	logger      *pct.Logger
+	connFactory mysql.ConnectionFactory
+	ir          *instance.Repo
+}
+
+func NewIndexes(logger *pct.Logger, connFactory mysql.ConnectionFactory, ir *instance.Repo) *Indexes {
+	i := &Indexes{
+		logger:      logger,
+		connFactory: connFactory,
+		ir:          ir,
+	}
+	return i
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (i *Indexes) Handle(cmd *proto.Cmd) *proto.Reply {
+	q := &IndexQuery{}
+	if cmd.Data == nil {
+		return cmd.Reply(nil, fmt.Errorf("%s.Handle:cmd.Data is empty", INDEXES_SERVICE_NAME))
+	}
+	if err := json.Unmarshal(cmd.Data, q); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("%s.Handle:json.Unmarshal:%s", INDEXES_SERVICE_NAME, err))
+	}
+
+	// The real name of the internal service, e.g. indexes-mysql-1:
+	name := fmt.Sprintf("%s-%s", INDEXES_SERVICE_NAME, i.ir.Name(q.Service, q.InstanceId))
+
+	i.logger.Info("Checking indexes", name, cmd)
+
+	mysqlIt := &proto.MySQLInstance{}
+	if err := i.ir.Get(q.Service, q.InstanceId, mysqlIt); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("Unable to create connector for %s: %s", name, err))
+	}
+	conn := i.connFactory.Make(mysqlIt.DSN)
+	if err := conn.Connect(2); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("Unable to connect to %s: %s", name, err))
+	}
+	defer conn.Close()
+
+	keys, err := GetKeys(conn.DB(), q.Schemas)
+	if err != nil {
+		return cmd.Reply(nil, fmt.Errorf("Cannot get indexes for %s: %s", name, err))
+	}
+	report := &IndexReport{
+		Duplicate: FindDuplicateKeys(keys),
+	}
+	if q.Unused {
+		// Requires performance_schema, so it's an error if it's off.
+		report.Unused, err = GetUnusedKeys(conn.DB(), q.Schemas)
+		if err != nil {
+			return cmd.Reply(report, fmt.Errorf("Cannot get unused indexes for %s: %s", name, err))
+		}
+	}
+
+	return cmd.Reply(report)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// GetKeys returns all indexes of all tables in the schemas, or all non-system
+// schemas if none are given.
+func GetKeys(db *sql.DB, schemas []string) ([]Key, error) {
+	where, args := schemaFilter("TABLE_SCHEMA", schemas)
+	query := "SELECT /* percona-agent */ TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME, SUB_PART, INDEX_TYPE" +
+		" FROM information_schema.statistics" +
+		" WHERE " + where +
+		" ORDER BY TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	var key *Key
+	for rows.Next() {
+		var schema, table, index, indexType string
+		var nonUnique int
+		var column sql.NullString // NULL for functional key parts (8.0.13+)
+		var subPart sql.NullInt64
+		if err := rows.Scan(&schema, &table, &index, &nonUnique, &column, &subPart, &indexType); err != nil {
+			return nil, err
+		}
+		if key == nil || key.Schema != schema || key.Table != table || key.Name != index {
+			keys = append(keys, Key{
+				Schema: schema,
+				Table:  table,
+				Name:   index,
+				Unique: nonUnique == 0,
+				Type:   indexType,
+			})
+			key = &keys[len(keys)-1]
+		}
+		col := column.String
+		if !column.Valid {
+			col = "(expression)"
+		} else if subPart.Valid {
+			col = fmt.Sprintf("%s(%d)", col, subPart.Int64)
+		}
+		key.Columns = append(key.Columns, col)
+	}
+	return keys, rows.Err()
+}
+
+// GetUnusedKeys returns indexes with no I/O in performance_schema, except
+// primary keys, which are never unused.
+func GetUnusedKeys(db *sql.DB, schemas []string) ([]UnusedKey, error) {
+	where, args := schemaFilter("OBJECT_SCHEMA", schemas)
+	query := "SELECT /* percona-agent */ OBJECT_SCHEMA, OBJECT_NAME, INDEX_NAME" +
+		" FROM performance_schema.table_io_waits_summary_by_index_usage" +
+		" WHERE " + where +
+		" AND INDEX_NAME IS NOT NULL AND INDEX_NAME != 'PRIMARY' AND COUNT_STAR = 0" +
+		" ORDER BY OBJECT_SCHEMA, OBJECT_NAME, INDEX_NAME"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unused := []UnusedKey{}
+	for rows.Next() {
+		k := UnusedKey{}
+		if err := rows.Scan(&k.Schema, &k.Table, &k.Key); err != nil {
+			return nil, err
+		}
+		k.Fix = fmt.Sprintf("ALTER TABLE `%s`.`%s` ALTER INDEX `%s` INVISIBLE", k.Schema, k.Table, k.Key)
+		unused = append(unused, k)
+	}
+	return unused, rows.Err()
+}
+
+// FindDuplicateKeys returns the keys that can be dropped, like
+// pt-duplicate-key-checker:
+//   - Keys with the same columns in the same order are duplicates.  The
+//     primary or unique key is kept, else the first by name.
+//   - A BTREE key whose columns are the leftmost columns of another BTREE
+//     key is redundant, unless it's unique because dropping it would drop
+//     its constraint.
+//   - FULLTEXT keys with the same columns in any order are duplicates.
+//
+// Each key is reported once, against the key it duplicates.
+func FindDuplicateKeys(keys []Key) []DuplicateKey {
+	dupes := []DuplicateKey{}
+
+	// Group keys by table, keeping the order of tables.
+	tables := []string{}
+	byTable := make(map[string][]Key)
+	for _, k := range keys {
+		t := k.Schema + "." + k.Table
+		if _, ok := byTable[t]; !ok {
+			tables = append(tables, t)
+		}
+		byTable[t] = append(byTable[t], k)
+	}
+
+	for _, t := range tables {
+		tableKeys := byTable[t]
+		sort.Sort(keysByPreference(tableKeys))
+		dropped := make(map[string]bool)
+		for a := len(tableKeys) - 1; a >= 0; a-- {
+			// a is the key that might be dropped, b the key to keep.  Less
+			// preferred keys are checked first, against more preferred keys.
+			for b := 0; b < len(tableKeys); b++ {
+				if a == b || dropped[tableKeys[b].Name] {
+					continue
+				}
+				reason := compareKeys(tableKeys[a], tableKeys[b], a > b)
+				if reason == "" {
+					continue
+				}
+				k, of := tableKeys[a], tableKeys[b]
+				dupes = append(dupes, DuplicateKey{
+					Schema:             k.Schema,
+					Table:              k.Table,
+					Key:                k.Name,
+					Columns:            k.Columns,
+					DuplicateOf:        of.Name,
+					DuplicateOfColumns: of.Columns,
+					Reason:             reason,
+					Fix:                fmt.Sprintf("ALTER TABLE `%s`.`%s` DROP INDEX `%s`", k.Schema, k.Table, k.Name),
+				})
+				dropped[k.Name] = true
+				break
+			}
+		}
+	}
+
+	return dupes
+}
+
+// compareKeys returns "duplicate" or "redundant" if key a can be dropped
+// because of key b, else "".  Exact duplicates are only dropped in favor of
+// a more preferred key (aAfterB).
+func compareKeys(a, b Key, aAfterB bool) string {
+	if a.Name == "PRIMARY" {
+		return ""
+	}
+	if a.Type != b.Type {
+		return ""
+	}
+	if a.Type == "FULLTEXT" {
+		if aAfterB && sameColumns(sortedCopy(a.Columns), sortedCopy(b.Columns)) {
+			return "duplicate"
+		}
+		return ""
+	}
+	if sameColumns(a.Columns, b.Columns) {
+		if aAfterB {
+			return "duplicate"
+		}
+		return ""
+	}
+	if a.Type != "BTREE" || len(a.Columns) > len(b.Columns) || !sameColumns(a.Columns, b.Columns[:len(a.Columns)]) {
+		return ""
+	}
+	if a.Unique {
+		return "" // a enforces uniqueness of fewer columns than b
+	}
+	return "redundant"
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedCopy(cols []string) []string {
+	c := append([]string{}, cols...)
+	sort.Strings(c)
+	return c
+}
+
+func schemaFilter(column string, schemas []string) (string, []interface{}) {
+	in := schemas
+	not := ""
+	if len(in) == 0 {
+		in = SystemSchemas
+		not = "NOT "
+	}
+	args := make([]interface{}, len(in))
+	for i, s := range in {
+		args[i] = s
+	}
+	return fmt.Sprintf("%s %sIN (%s)", column, not, strings.TrimSuffix(strings.Repeat("?,", len(in)), ",")), args
+}
+
+// keysByPreference sorts keys by which to keep: primary, then unique, then by
+// name.
+type keysByPreference []Key
+
+func (a keysByPreference) Len() int      { return len(a) }
+func (a keysByPreference) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a keysByPreference) Less(i, j int) bool {
+	if (a[i].Name == "PRIMARY") != (a[j].Name == "PRIMARY") {
+		return a[i].Name == "PRIMARY"
+	}
+	if a[i].Unique != a[j].Unique {
+		return a[i].Unique
+	}
+	return a[i].Name < a[j].Name
+}
@@ -0,0 +1,83 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package service_test
+
+import (
+	"github.com/percona/percona-agent/query/service"
+	. "gopkg.in/check.v1"
+)
+
+type IndexesTestSuite struct {
+}
+
+var _ = Suite(&IndexesTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func key(name string, unique bool, cols ...string) service.Key {
+	return service.Key{Schema: "db", Table: "t", Name: name, Columns: cols, Unique: unique, Type: "BTREE"}
+}
+
+func (s *IndexesTestSuite) TestDuplicateKeys(t *C) {
+	keys := []service.Key{
+		key("PRIMARY", true, "id"),
+		key("idx_id", false, "id"),      // duplicate of PRIMARY
+		key("idx_a", false, "a"),        // redundant to idx_a_b
+		key("idx_a_b", false, "a", "b"), // redundant to idx_a_b_c
+		key("idx_a_b_c", false, "a", "b", "c"),
+		key("uk_b", true, "b"),          // unique: kept
+		key("idx_b_c", false, "b", "c"), // not redundant: uk_b is unique
+		key("idx_c", false, "c"),
+		key("idx_c2", false, "C"), // duplicate of idx_c
+		key("idx_d", false, "d(10)"),
+		key("idx_d_e", false, "d", "e"), // not redundant: idx_d is a prefix key
+	}
+	got := service.FindDuplicateKeys(keys)
+	expect := []service.DuplicateKey{
+		{Schema: "db", Table: "t", Key: "idx_id", Columns: []string{"id"}, DuplicateOf: "PRIMARY", DuplicateOfColumns: []string{"id"}, Reason: "duplicate", Fix: "ALTER TABLE `db`.`t` DROP INDEX `idx_id`"},
+		{Schema: "db", Table: "t", Key: "idx_c2", Columns: []string{"C"}, DuplicateOf: "idx_c", DuplicateOfColumns: []string{"c"}, Reason: "duplicate", Fix: "ALTER TABLE `db`.`t` DROP INDEX `idx_c2`"},
+		{Schema: "db", Table: "t", Key: "idx_a_b", Columns: []string{"a", "b"}, DuplicateOf: "idx_a_b_c", DuplicateOfColumns: []string{"a", "b", "c"}, Reason: "redundant", Fix: "ALTER TABLE `db`.`t` DROP INDEX `idx_a_b`"},
+		{Schema: "db", Table: "t", Key: "idx_a", Columns: []string{"a"}, DuplicateOf: "idx_a_b_c", DuplicateOfColumns: []string{"a", "b", "c"}, Reason: "redundant", Fix: "ALTER TABLE `db`.`t` DROP INDEX `idx_a`"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *IndexesTestSuite) TestDuplicateKeysTypes(t *C) {
+	ft1 := key("ft1", false, "title", "body")
+	ft1.Type = "FULLTEXT"
+	ft2 := key("ft2", false, "body", "title")
+	ft2.Type = "FULLTEXT"
+	ft3 := key("ft3", false, "title")
+	ft3.Type = "FULLTEXT"
+	keys := []service.Key{
+		ft1,
+		ft2, // duplicate: same columns in any order
+		ft3, // not redundant: FULLTEXT keys aren't leftmost prefixes
+		key("idx_title", false, "title"),
+	}
+	got := service.FindDuplicateKeys(keys)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Key, Equals, "ft2")
+	t.Check(got[0].DuplicateOf, Equals, "ft1")
+
+	// Keys on different tables are never duplicates.
+	k1 := key("idx_a", false, "a")
+	k2 := key("idx_a", false, "a")
+	k2.Table = "t2"
+	t.Check(service.FindDuplicateKeys([]service.Key{k1, k2}), HasLen, 0)
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/instance"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+)
+
+const (
+	REPLICATION_SERVICE_NAME = "replication"
+)
+
+// Replication replies to the GetBinlogCoordinates cmd with the current
+// *mysql.BinlogCoordinates of an instance: GTID executed and purged sets,
+// binlog file and position, and server_uuid.
+type Replication struct {
+	logger      *pct.Logger
+	connFactory mysql.ConnectionFactory
+	ir          *instance.Repo
+}
+
+func NewReplication(logger *pct.Logger, connFactory mysql.ConnectionFactory, ir *instance.Repo) *Replication {
+	r := &Replication{
+		logger:      logger,
+		connFactory: connFactory,
+		ir:          ir,
+	}
+	return r
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (r *Replication) Handle(cmd *proto.Cmd) *proto.Reply {
+	it := &proto.ServiceInstance{}
+	if cmd.Data == nil {
+		return cmd.Reply(nil, fmt.Errorf("%s.Handle:cmd.Data is empty", REPLICATION_SERVICE_NAME))
+	}
+	if err := json.Unmarshal(cmd.Data, it); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("%s.Handle:json.Unmarshal:%s", REPLICATION_SERVICE_NAME, err))
+	}
+
+	// The real name of the internal service, e.g. replication-mysql-1:
+	name := fmt.Sprintf("%s-%s", REPLICATION_SERVICE_NAME, r.ir.Name(it.Service, it.InstanceId))
+
+	r.logger.Info("Getting binlog coordinates", name, cmd)
+
+	mysqlIt := &proto.MySQLInstance{}
+	if err := r.ir.Get(it.Service, it.InstanceId, mysqlIt); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("Unable to create connector for %s: %s", name, err))
+	}
+	conn := r.connFactory.Make(mysqlIt.DSN)
+	if err := conn.Connect(2); err != nil {
+		return cmd.Reply(nil, fmt.Errorf("Unable to connect to %s: %s", name, err))
+	}
+	defer conn.Close()
+
+	coords, err := mysql.GetBinlogCoordinates(conn.DB())
+	if err != nil {
+		return cmd.Reply(nil, fmt.Errorf("Cannot get binlog coordinates for %s: %s", name, err))
+	}
+	return cmd.Reply(coords)
+}
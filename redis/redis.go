@@ -0,0 +1,166 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/percona/percona-agent/instance"
+)
+
+// Instance is a Redis instance in the instance repo, like proto.MySQLInstance.
+type Instance struct {
+	Id       uint
+	Hostname string
+	Addr     string // host:port or Unix socket path
+	Password string `json:",omitempty"` // AUTH password, encrypted on disk like MySQL DSNs
+	Version  string `json:",omitempty"`
+}
+
+func init() {
+	instance.RegisterType("redis", instance.Type{
+		New:    func() interface{} { return &Instance{} },
+		Secret: func(it interface{}) *string { return &it.(*Instance).Password },
+	})
+}
+
+const TIMEOUT = 2 * time.Second
+
+type Connector interface {
+	Addr() string
+	Info() (map[string]string, error)
+}
+
+// Connection connects to Redis for each command because commands are only run
+// every collect interval.  It speaks just enough of the Redis protocol (RESP)
+// for AUTH and INFO.
+type Connection struct {
+	addr     string
+	password string
+}
+
+func NewConnection(addr, password string) *Connection {
+	c := &Connection{
+		addr:     addr,
+		password: password,
+	}
+	return c
+}
+
+func (c *Connection) Addr() string {
+	return c.addr
+}
+
+// Info returns the output of the INFO command, e.g. connected_clients: 10.
+func (c *Connection) Info() (map[string]string, error) {
+	network := "tcp"
+	if strings.HasPrefix(c.addr, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, c.addr, TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+	r := bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := conn.Write(Command("AUTH", c.password)); err != nil {
+			return nil, err
+		}
+		if _, err := ReadReply(r); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := conn.Write(Command("INFO")); err != nil {
+		return nil, err
+	}
+	reply, err := ReadReply(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseInfo(reply), nil
+}
+
+// Command returns a command encoded as a RESP array of bulk strings.
+func Command(args ...string) []byte {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(cmd)
+}
+
+// ReadReply reads a simple string, error, integer, or bulk string reply.
+// Error replies are returned as errors.
+func ReadReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", errors.New("redis: " + line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: invalid bulk length: %q", line)
+		}
+		if n < 0 {
+			return "", nil // nil bulk string
+		}
+		buf := make([]byte, n+2) // + \r\n
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+	return "", fmt.Errorf("redis: unsupported reply: %q", line)
+}
+
+// ParseInfo parses the output of INFO: name:value lines in sections that
+// start with # comments.
+func ParseInfo(info string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[parts[0]] = parts[1]
+	}
+	return values
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package redis_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-agent/redis"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type RedisTestSuite struct {
+}
+
+var _ = Suite(&RedisTestSuite{})
+
+func (s *RedisTestSuite) TestCommand(t *C) {
+	t.Check(string(redis.Command("AUTH", "secret")), Equals, "*2\r\n$4\r\nAUTH\r\n$6\r\nsecret\r\n")
+}
+
+func (s *RedisTestSuite) TestReadReply(t *C) {
+	r := bufio.NewReader(strings.NewReader("+OK\r\n:5\r\n$5\r\nhello\r\n$-1\r\n-ERR invalid password\r\n"))
+	reply, err := redis.ReadReply(r)
+	t.Check(err, IsNil)
+	t.Check(reply, Equals, "OK")
+	reply, err = redis.ReadReply(r)
+	t.Check(err, IsNil)
+	t.Check(reply, Equals, "5")
+	reply, err = redis.ReadReply(r)
+	t.Check(err, IsNil)
+	t.Check(reply, Equals, "hello")
+	reply, err = redis.ReadReply(r)
+	t.Check(err, IsNil)
+	t.Check(reply, Equals, "")
+	_, err = redis.ReadReply(r)
+	t.Check(err, ErrorMatches, "redis: ERR invalid password")
+}
+
+func (s *RedisTestSuite) TestParseInfo(t *C) {
+	info := "# Server\r\nredis_version:2.8.17\r\n\r\n# Clients\r\nconnected_clients:3\r\n\r\n# Keyspace\r\ndb0:keys=10,expires=2,avg_ttl=0\r\n"
+	t.Check(redis.ParseInfo(info), DeepEquals, map[string]string{
+		"redis_version":     "2.8.17",
+		"connected_clients": "3",
+		"db0":               "keys=10,expires=2,avg_ttl=0",
+	})
+}
+
+func (s *RedisTestSuite) TestInfo(t *C) {
+	// Fake Redis that requires a password and replies to INFO.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	t.Assert(err, IsNil)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		readCmd := func() string {
+			line, _ := r.ReadString('\n') // *N
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			args := []string{}
+			for i := 0; i < n; i++ {
+				r.ReadString('\n') // $len
+				arg, _ := r.ReadString('\n')
+				args = append(args, strings.TrimSpace(arg))
+			}
+			return strings.Join(args, " ")
+		}
+		if readCmd() != "AUTH secret" {
+			conn.Write([]byte("-ERR invalid password\r\n"))
+			return
+		}
+		conn.Write([]byte("+OK\r\n"))
+		if readCmd() == "INFO" {
+			info := "# Clients\r\nconnected_clients:3\r\n"
+			conn.Write([]byte(fmt.Sprintf("$%d\r\n%s\r\n", len(info), info)))
+		}
+	}()
+
+	c := redis.NewConnection(l.Addr().String(), "secret")
+	info, err := c.Info()
+	t.Assert(err, IsNil)
+	t.Check(info, DeepEquals, map[string]string{"connected_clients": "3"})
+}
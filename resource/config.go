@@ -0,0 +1,34 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package resource
+
+const (
+	DEFAULT_CHECK_INTERVAL = 10 // seconds
+)
+
+// Config is soft limits for the agent's own resource usage.  Zero is no limit.
+// If any limit is exceeded and Degrade > 1, metrics are collected Degrade
+// times less often until the agent is under all limits again.
+type Config struct {
+	Interval   uint    // seconds between checks
+	CPU        float64 // percent of one CPU
+	RSS        uint64  // MB
+	Goroutines int
+	FDs        int // open file descriptors
+	Degrade    uint
+}
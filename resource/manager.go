@@ -0,0 +1,268 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttler reduces metric collection, e.g. mm.Manager.
+type Throttler interface {
+	Throttle(factor uint)
+}
+
+// Manager monitors the agent's own resource usage and, if it exceeds the soft
+// limits in its config, warns, reports "Over limit" in its status, and
+// degrades collection (Config.Degrade) so the agent doesn't impact the server.
+type Manager struct {
+	logger    *pct.Logger
+	throttler Throttler
+	usage     *UsageReader
+	// --
+	config   *Config
+	running  bool
+	degraded bool
+	mux      *sync.Mutex // guards config, running and degraded
+	sync     *pct.SyncChan
+	status   *pct.Status
+}
+
+func NewManager(logger *pct.Logger, throttler Throttler) *Manager {
+	m := &Manager{
+		logger:    logger,
+		throttler: throttler,
+		usage:     NewUsageReader(),
+		// --
+		mux:    &sync.Mutex{},
+		status: pct.NewStatus([]string{"resource", "resource-usage"}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Manager) Start() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.running {
+		return pct.ServiceIsRunningError{Service: "resource"}
+	}
+
+	// Load config from disk (optional: no limits by default).
+	config := &Config{}
+	if err := pct.Basedir.ReadConfig("resource", config); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := m.validateConfig(config); err != nil {
+		return err
+	}
+	m.config = config
+
+	m.sync = pct.NewSyncChan()
+	go m.run(time.Duration(config.Interval) * time.Second)
+	m.running = true
+
+	m.logger.Info("Started")
+	m.status.Update("resource", "Running")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Manager) Stop() error {
+	m.mux.Lock()
+	running := m.running
+	m.mux.Unlock()
+	if !running {
+		return nil
+	}
+	// Don't hold the lock while stopping run(); check() locks it.
+	m.sync.Stop()
+	m.sync.Wait()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.setDegraded(false)
+	m.running = false
+	m.logger.Info("Stopped")
+	m.status.Update("resource", "Stopped")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
+	m.logger.Info("Handle", cmd)
+	switch cmd.Cmd {
+	case "GetConfig":
+		config, errs := m.GetConfig()
+		return cmd.Reply(config, errs...)
+	case "SetConfig":
+		newConfig, errs := m.handleSetConfig(cmd)
+		return cmd.Reply(newConfig, errs...)
+	default:
+		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
+	}
+}
+
+// @goroutine[0:1]
+func (m *Manager) Status() map[string]string {
+	return m.status.All()
+}
+
+func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
+	m.logger.Debug("GetConfig:call")
+	defer m.logger.Debug("GetConfig:return")
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	bytes, err := json.Marshal(m.config)
+	if err != nil {
+		return nil, []error{err}
+	}
+	// Configs are always returned as array of AgentConfig resources.
+	config := proto.AgentConfig{
+		InternalService: "resource",
+		// no external service
+		Config:  string(bytes),
+		Running: m.running,
+	}
+	return []proto.AgentConfig{config}, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[1]
+func (m *Manager) run(interval time.Duration) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Resource monitor crashed: ", err)
+			m.status.Update("resource", "Crashed")
+		}
+		m.sync.Done()
+	}()
+
+	m.usage.Read() // first CPU measurement
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check(m.usage.Read())
+		case <-m.sync.StopChan:
+			return
+		}
+	}
+}
+
+// @goroutine[1]
+func (m *Manager) check(usage Usage) {
+	m.status.Update("resource-usage", usage.String())
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	over := Check(*m.config, usage)
+	if len(over) == 0 {
+		if m.degraded {
+			m.logger.Info("Under resource limits, restoring normal collection")
+		}
+		m.setDegraded(false)
+		m.status.Update("resource", "Running")
+		return
+	}
+	msg := "Over limit: " + strings.Join(over, ", ")
+	m.logger.Warn(msg)
+	if m.config.Degrade > 1 {
+		if !m.degraded {
+			m.logger.Warn(fmt.Sprintf("Degrading collection %dx until under resource limits", m.config.Degrade))
+		}
+		m.setDegraded(true)
+		msg += " (degraded)"
+	}
+	m.status.Update("resource", msg)
+}
+
+// setDegraded throttles collection, or not.  Caller must lock m.mux.
+func (m *Manager) setDegraded(degraded bool) {
+	if degraded == m.degraded || m.throttler == nil {
+		return
+	}
+	if degraded {
+		m.throttler.Throttle(m.config.Degrade)
+	} else {
+		m.throttler.Throttle(1)
+	}
+	m.degraded = degraded
+}
+
+func (m *Manager) validateConfig(config *Config) error {
+	if config.Interval == 0 {
+		config.Interval = DEFAULT_CHECK_INTERVAL
+	}
+	if config.CPU < 0 {
+		return errors.New("CPU must be >= 0")
+	}
+	if config.Goroutines < 0 {
+		return errors.New("Goroutines must be >= 0")
+	}
+	if config.FDs < 0 {
+		return errors.New("FDs must be >= 0")
+	}
+	if config.Degrade > 60 {
+		return errors.New("Degrade must be <= 60")
+	}
+	return nil
+}
+
+func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
+	newConfig := &Config{}
+	if err := json.Unmarshal(cmd.Data, newConfig); err != nil {
+		return nil, []error{err}
+	}
+	if err := m.validateConfig(newConfig); err != nil {
+		return nil, []error{err}
+	}
+
+	// Restart the monitor with the new config; limits are rechecked at the
+	// next interval.
+	if err := pct.Basedir.WriteConfig("resource", newConfig); err != nil {
+		return nil, []error{errors.New("resource.WriteConfig:" + err.Error())}
+	}
+	errs := []error{}
+	if err := m.Stop(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := m.Start(); err != nil {
+		errs = append(errs, err)
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.config, errs
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package resource_test
+
+import (
+	"encoding/json"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/resource"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type throttler struct {
+	factors []uint
+	mux     sync.Mutex
+}
+
+func (t *throttler) Throttle(factor uint) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.factors = append(t.factors, factor)
+}
+
+func (t *throttler) Factors() []uint {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return append([]uint{}, t.factors...)
+}
+
+type ResourceTestSuite struct {
+	basedir string
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&ResourceTestSuite{})
+
+func (s *ResourceTestSuite) SetUpSuite(t *C) {
+	var err error
+	s.basedir, err = ioutil.TempDir("/tmp", "percona-agent-resource-test")
+	t.Assert(err, IsNil)
+	if err := pct.Basedir.Init(s.basedir); err != nil {
+		t.Fatal(err)
+	}
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "resource-test")
+}
+
+func (s *ResourceTestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.basedir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *ResourceTestSuite) TestCheck(t *C) {
+	usage := resource.Usage{
+		CPU:        12.5,
+		RSS:        100 * 1024 * 1024,
+		Goroutines: 50,
+		FDs:        20,
+	}
+	t.Check(resource.Check(resource.Config{}, usage), HasLen, 0)
+	t.Check(resource.Check(resource.Config{CPU: 20, RSS: 200, Goroutines: 100, FDs: 100}, usage), HasLen, 0)
+	t.Check(resource.Check(resource.Config{CPU: 10, RSS: 50, Goroutines: 10, FDs: 10}, usage), DeepEquals, []string{
+		"cpu 12.5% > 10.0%",
+		"rss 100MB > 50MB",
+		"goroutines 50 > 10",
+		"fds 20 > 10",
+	})
+}
+
+func (s *ResourceTestSuite) TestUsage(t *C) {
+	r := resource.NewUsageReader()
+	usage := r.Read()
+	t.Check(usage.CPU, Equals, float64(0)) // no previous read
+	t.Check(usage.Goroutines > 0, Equals, true)
+	t.Check(usage.RSS > 0, Equals, true)
+	if runtime.GOOS == "linux" {
+		t.Check(usage.FDs > 0, Equals, true)
+	}
+	usage = r.Read()
+	t.Check(usage.CPU >= 0, Equals, true)
+}
+
+func (s *ResourceTestSuite) TestDegrade(t *C) {
+	// Limit is exceeded, so collection is degraded.
+	config := &resource.Config{Interval: 1, Goroutines: 1, Degrade: 5}
+	err := pct.Basedir.WriteConfig("resource", config)
+	t.Assert(err, IsNil)
+
+	th := &throttler{}
+	m := resource.NewManager(s.logger, th)
+	t.Assert(m.Start(), IsNil)
+	defer m.Stop()
+
+	var status map[string]string
+	for i := 0; i < 30; i++ {
+		time.Sleep(100 * time.Millisecond)
+		status = m.Status()
+		if status["resource"] != "Running" {
+			break
+		}
+	}
+	t.Check(status["resource"], Matches, `Over limit: goroutines \d+ > 1 \(degraded\)`)
+	t.Check(status["resource-usage"], Matches, `cpu=.+ rss=\d+MB goroutines=\d+ fds=-?\d+`)
+	t.Check(th.Factors(), DeepEquals, []uint{5})
+
+	// Raise the limit: restarting the monitor restores normal collection.
+	data, _ := json.Marshal(&resource.Config{Interval: 1, Goroutines: 100000, Degrade: 5})
+	reply := m.Handle(&proto.Cmd{Cmd: "SetConfig", Service: "resource", Data: data})
+	t.Assert(reply.Error, Equals, "")
+	t.Check(th.Factors(), DeepEquals, []uint{5, 1})
+	t.Check(m.Status()["resource"], Equals, "Running")
+
+	configs, errs := m.GetConfig()
+	t.Assert(errs, HasLen, 0)
+	t.Assert(configs, HasLen, 1)
+	t.Check(configs[0].Config, Equals, `{"Interval":1,"CPU":0,"RSS":0,"Goroutines":100000,"FDs":0,"Degrade":5}`)
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package resource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage is the agent's own resource usage.
+type Usage struct {
+	CPU        float64 // percent of one CPU since the previous Usage
+	RSS        uint64  // bytes
+	Goroutines int
+	FDs        int
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("cpu=%.1f%% rss=%dMB goroutines=%d fds=%d", u.CPU, u.RSS/1024/1024, u.Goroutines, u.FDs)
+}
+
+// Check returns a reason for each limit in config that usage exceeds.
+func Check(config Config, usage Usage) []string {
+	over := []string{}
+	if config.CPU > 0 && usage.CPU > config.CPU {
+		over = append(over, fmt.Sprintf("cpu %.1f%% > %.1f%%", usage.CPU, config.CPU))
+	}
+	if config.RSS > 0 && usage.RSS > config.RSS*1024*1024 {
+		over = append(over, fmt.Sprintf("rss %dMB > %dMB", usage.RSS/1024/1024, config.RSS))
+	}
+	if config.Goroutines > 0 && usage.Goroutines > config.Goroutines {
+		over = append(over, fmt.Sprintf("goroutines %d > %d", usage.Goroutines, config.Goroutines))
+	}
+	if config.FDs > 0 && usage.FDs > config.FDs {
+		over = append(over, fmt.Sprintf("fds %d > %d", usage.FDs, config.FDs))
+	}
+	return over
+}
+
+// UsageReader reads the agent's Usage.  CPU is measured between reads, so
+// the first read is 0.
+type UsageReader struct {
+	lastCPU time.Duration
+	lastTs  time.Time
+}
+
+func NewUsageReader() *UsageReader {
+	return &UsageReader{}
+}
+
+func (r *UsageReader) Read() Usage {
+	usage := Usage{
+		Goroutines: runtime.NumGoroutine(),
+		FDs:        openFDs(),
+	}
+	if cpu, maxRSS, err := cpuTime(); err == nil {
+		now := time.Now()
+		if !r.lastTs.IsZero() {
+			if wall := now.Sub(r.lastTs); wall > 0 {
+				usage.CPU = float64(cpu-r.lastCPU) / float64(wall) * 100
+			}
+		}
+		r.lastCPU = cpu
+		r.lastTs = now
+		usage.RSS = maxRSS
+	}
+	if rss, err := procRSS("/proc/self/statm"); err == nil {
+		usage.RSS = rss // current, not peak
+	}
+	return usage
+}
+
+// procRSS returns the RSS bytes from /proc/<pid>/statm on Linux.
+func procRSS(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	f := strings.Fields(string(content))
+	if len(f) < 2 {
+		return 0, fmt.Errorf("Invalid %s: %s", file, content)
+	}
+	pages, err := strconv.ParseUint(f[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
+
+// openFDs returns the number of open file descriptors, or -1 if unknown.
+func openFDs() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		files, err := ioutil.ReadDir(dir)
+		if err == nil {
+			return len(files) - 1 // ReadDir opened one
+		}
+	}
+	return -1
+}
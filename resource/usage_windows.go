@@ -0,0 +1,44 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package resource
+
+import (
+	"syscall"
+	"time"
+)
+
+// cpuTime returns the user and kernel CPU time of the agent.  The RSS is 0
+// because Windows has no /proc/self/statm or rusage; the working set needs
+// psapi, which isn't worth the dependency for a status line.
+func cpuTime() (time.Duration, uint64, error) {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0, 0, err
+	}
+	var created, exited, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &created, &exited, &kernel, &user); err != nil {
+		return 0, 0, err
+	}
+	return filetimeDuration(kernel) + filetimeDuration(user), 0, nil
+}
+
+// filetimeDuration converts a FILETIME that's a duration, not a date, in
+// 100-nanosecond units.  Filetime.Nanoseconds() is for dates since 1601.
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration((int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)) * 100)
+}
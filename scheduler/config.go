@@ -0,0 +1,46 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package scheduler
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Config struct {
+	Jobs []Job
+}
+
+// Job is a Cmd run on a schedule, like cron.  The reply is spooled as a Result.
+type Job struct {
+	Name     string          // unique
+	Schedule string          // cron expression, e.g. "0 * * * *" (hourly)
+	Service  string          // e.g. sysinfo
+	Cmd      string          // e.g. MySQLSummary
+	Data     json.RawMessage `json:",omitempty"` // Cmd.Data
+}
+
+// Result is the data spooled for each job run.
+type Result struct {
+	Ts      time.Time // when the job ran, UTC
+	Job     string
+	Service string
+	Cmd     string
+	Data    json.RawMessage `json:",omitempty"` // Reply.Data
+	Error   string          `json:",omitempty"` // Reply.Error
+}
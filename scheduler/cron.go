@@ -0,0 +1,137 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression: minute hour day-of-month month
+// day-of-week.  Each field is *, a number, a range (1-5), a step (*/15 or
+// 0-30/10), or a comma-separated list of those.  Like cron, if both
+// day-of-month and day-of-week are restricted (not *), either can match.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bit N set if value N matches
+	domStar, dowStar              bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max uint
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day-of-month", 1, 31},
+	{"month", 1, 12},
+	{"day-of-week", 0, 7}, // 0 and 7 are Sunday
+}
+
+// Shortcuts like cron's.
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func ParseSchedule(expr string) (*Schedule, error) {
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("Invalid schedule: %s: expected %d fields, got %d", expr, len(cronFields), len(fields))
+	}
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		bits[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schedule: %s: %s: %s", expr, cronFields[i].name, err)
+		}
+	}
+	s := &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	return s, nil
+}
+
+// Match returns true if the schedule matches t to the minute.
+func (s *Schedule) Match(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if !s.domStar && !s.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func parseCronField(field string, min, max uint) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid step: %s", part)
+			}
+			step = uint(n)
+			part = part[:i]
+		}
+		first, last := min, max
+		if part != "*" {
+			f := strings.SplitN(part, "-", 2)
+			n, err := strconv.ParseUint(f[0], 10, 8)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value: %s", part)
+			}
+			first, last = uint(n), uint(n)
+			if len(f) == 2 {
+				n, err := strconv.ParseUint(f[1], 10, 8)
+				if err != nil {
+					return 0, fmt.Errorf("invalid range: %s", part)
+				}
+				last = uint(n)
+			} else if step > 1 {
+				last = max // 5/10 is 5-max/10, like cron
+			}
+		}
+		if first < min || last > max || first > last {
+			return 0, fmt.Errorf("%s out of range %d-%d", part, min, max)
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
@@ -0,0 +1,307 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/data"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/ticker"
+)
+
+type job struct {
+	Job
+	schedule *Schedule
+	running  bool
+}
+
+// Manager runs Cmds on cron-like schedules (Config.Jobs) by passing them to
+// their service managers, and spools the replies, so periodic actions like
+// sysinfo snapshots don't require the API.  Cmds not allowed by the agent
+// (AllowCmds) cannot be scheduled.
+type Manager struct {
+	logger   *pct.Logger
+	clock    ticker.Manager
+	spool    data.Spooler
+	services map[string]pct.ServiceManager
+	allowed  func(service, cmd string) bool
+	// --
+	config   *Config
+	jobs     []*job
+	tickChan chan time.Time
+	running  bool
+	mux      *sync.Mutex // guards config, jobs and running
+	sync     *pct.SyncChan
+	status   *pct.Status
+}
+
+func NewManager(logger *pct.Logger, clock ticker.Manager, spool data.Spooler, services map[string]pct.ServiceManager, allowed func(service, cmd string) bool) *Manager {
+	m := &Manager{
+		logger:   logger,
+		clock:    clock,
+		spool:    spool,
+		services: services,
+		allowed:  allowed,
+		// --
+		tickChan: make(chan time.Time),
+		mux:      &sync.Mutex{},
+		status:   pct.NewStatus([]string{"scheduler", "scheduler-last-job"}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Manager) Start() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.running {
+		return pct.ServiceIsRunningError{Service: "scheduler"}
+	}
+
+	// Load config from disk (optional: no jobs by default).
+	config := &Config{}
+	if err := pct.Basedir.ReadConfig("scheduler", config); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	jobs, err := m.validateConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = config
+	m.jobs = jobs
+
+	// Check the jobs every minute, on the minute.
+	m.sync = pct.NewSyncChan()
+	go m.run()
+	m.clock.Add(m.tickChan, 60, true)
+	m.running = true
+
+	m.logger.Info("Started")
+	m.status.Update("scheduler", fmt.Sprintf("Running %d jobs", len(jobs)))
+	return nil
+}
+
+// @goroutine[0]
+func (m *Manager) Stop() error {
+	m.mux.Lock()
+	running := m.running
+	m.mux.Unlock()
+	if !running {
+		return nil
+	}
+	// Don't hold the lock while stopping run(); it locks to run jobs.
+	m.clock.Remove(m.tickChan)
+	m.sync.Stop()
+	m.sync.Wait()
+	m.mux.Lock()
+	m.running = false
+	m.mux.Unlock()
+	m.logger.Info("Stopped")
+	m.status.Update("scheduler", "Stopped")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
+	m.logger.Info("Handle", cmd)
+	switch cmd.Cmd {
+	case "GetConfig":
+		config, errs := m.GetConfig()
+		return cmd.Reply(config, errs...)
+	case "SetConfig":
+		newConfig, errs := m.handleSetConfig(cmd)
+		return cmd.Reply(newConfig, errs...)
+	default:
+		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
+	}
+}
+
+// @goroutine[0:1]
+func (m *Manager) Status() map[string]string {
+	return m.status.All()
+}
+
+func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
+	m.logger.Debug("GetConfig:call")
+	defer m.logger.Debug("GetConfig:return")
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	bytes, err := json.Marshal(m.config)
+	if err != nil {
+		return nil, []error{err}
+	}
+	// Configs are always returned as array of AgentConfig resources.
+	config := proto.AgentConfig{
+		InternalService: "scheduler",
+		// no external service
+		Config:  string(bytes),
+		Running: m.running,
+	}
+	return []proto.AgentConfig{config}, nil
+}
+
+func (m *Manager) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[1]
+func (m *Manager) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Scheduler crashed: ", err)
+			m.status.Update("scheduler", "Crashed")
+		}
+		m.sync.Done()
+	}()
+
+	for {
+		select {
+		case now := <-m.tickChan:
+			m.mux.Lock()
+			for _, j := range m.jobs {
+				if !j.schedule.Match(now) {
+					continue
+				}
+				// Jobs can be slow, e.g. sysinfo, so run each in its own
+				// goroutine, but only one at a time.
+				if j.running {
+					m.logger.Warn("Job", j.Name, "is still running, skipping this run")
+					continue
+				}
+				j.running = true
+				go m.runJob(j, now)
+			}
+			m.mux.Unlock()
+		case <-m.sync.StopChan:
+			m.sync.Graceful()
+			return
+		}
+	}
+}
+
+// @goroutine[2]
+func (m *Manager) runJob(j *job, now time.Time) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error("Job", j.Name, "crashed: ", err)
+		}
+		m.mux.Lock()
+		j.running = false
+		m.mux.Unlock()
+	}()
+
+	m.logger.Info("Running job", j.Name)
+	cmd := &proto.Cmd{
+		Ts:      now.UTC(),
+		User:    "scheduler",
+		Service: j.Service,
+		Cmd:     j.Cmd,
+		Data:    j.Data,
+	}
+	reply := m.services[j.Service].Handle(cmd)
+
+	result := &Result{
+		Ts:      now.UTC(),
+		Job:     j.Name,
+		Service: j.Service,
+		Cmd:     j.Cmd,
+		Data:    reply.Data,
+		Error:   reply.Error,
+	}
+	if reply.Error != "" {
+		m.logger.Warn("Job", j.Name, "error:", reply.Error)
+		m.status.Update("scheduler-last-job", fmt.Sprintf("%s at %s: %s", j.Name, now.UTC(), reply.Error))
+	} else {
+		m.status.Update("scheduler-last-job", fmt.Sprintf("%s at %s: OK", j.Name, now.UTC()))
+	}
+	if err := m.spool.Write("scheduler", result); err != nil {
+		m.logger.Warn("Lost job", j.Name, "result:", err)
+	}
+}
+
+func (m *Manager) validateConfig(config *Config) ([]*job, error) {
+	jobs := make([]*job, len(config.Jobs))
+	names := make(map[string]bool)
+	for i, j := range config.Jobs {
+		if j.Name == "" {
+			return nil, fmt.Errorf("Job %d: Name is not set", i+1)
+		}
+		if names[j.Name] {
+			return nil, fmt.Errorf("Duplicate job: %s", j.Name)
+		}
+		names[j.Name] = true
+		schedule, err := ParseSchedule(j.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("Job %s: %s", j.Name, err)
+		}
+		if _, ok := m.services[j.Service]; !ok || j.Service == "scheduler" {
+			return nil, fmt.Errorf("Job %s: %s", j.Name, pct.UnknownServiceError{Service: j.Service})
+		}
+		if j.Cmd == "" {
+			return nil, fmt.Errorf("Job %s: Cmd is not set", j.Name)
+		}
+		if m.allowed != nil && !m.allowed(j.Service, j.Cmd) {
+			return nil, fmt.Errorf("Job %s: %s", j.Name, pct.CmdNotAllowedError{Service: j.Service, Cmd: j.Cmd})
+		}
+		jobs[i] = &job{Job: j, schedule: schedule}
+	}
+	return jobs, nil
+}
+
+func (m *Manager) handleSetConfig(cmd *proto.Cmd) (interface{}, []error) {
+	newConfig := &Config{}
+	if err := json.Unmarshal(cmd.Data, newConfig); err != nil {
+		return nil, []error{err}
+	}
+	if _, err := m.validateConfig(newConfig); err != nil {
+		return nil, []error{err}
+	}
+
+	// Restart with the new jobs.
+	if err := pct.Basedir.WriteConfig("scheduler", newConfig); err != nil {
+		return nil, []error{errors.New("scheduler.WriteConfig:" + err.Error())}
+	}
+	errs := []error{}
+	if err := m.Stop(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := m.Start(); err != nil {
+		errs = append(errs, err)
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.config, errs
+}
@@ -0,0 +1,178 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package scheduler_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/scheduler"
+	"github.com/percona/percona-agent/test/mock"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type SchedulerTestSuite struct {
+	basedir  string
+	logChan  chan *proto.LogEntry
+	logger   *pct.Logger
+	dataChan chan interface{}
+	spool    *mock.Spooler
+	sysinfo  *mock.MockServiceManager
+	services map[string]pct.ServiceManager
+}
+
+var _ = Suite(&SchedulerTestSuite{})
+
+func (s *SchedulerTestSuite) SetUpSuite(t *C) {
+	var err error
+	s.basedir, err = ioutil.TempDir("/tmp", "percona-agent-scheduler-test")
+	t.Assert(err, IsNil)
+	if err := pct.Basedir.Init(s.basedir); err != nil {
+		t.Fatal(err)
+	}
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "scheduler-test")
+	s.dataChan = make(chan interface{}, 10)
+	s.spool = mock.NewSpooler(s.dataChan)
+}
+
+func (s *SchedulerTestSuite) SetUpTest(t *C) {
+	s.sysinfo = mock.NewMockServiceManager("sysinfo", nil, nil)
+	s.services = map[string]pct.ServiceManager{"sysinfo": s.sysinfo}
+	if err := pct.Basedir.RemoveConfig("scheduler"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *SchedulerTestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.basedir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *SchedulerTestSuite) TestSchedule(t *C) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04 Mon", s)
+		if err != nil {
+			panic(err)
+		}
+		return ts
+	}
+
+	sched, err := scheduler.ParseSchedule("*/15 9-17 * * 1-5")
+	t.Assert(err, IsNil)
+	t.Check(sched.Match(at("2014-06-02 09:30 Mon")), Equals, true)
+	t.Check(sched.Match(at("2014-06-02 09:31 Mon")), Equals, false)
+	t.Check(sched.Match(at("2014-06-02 18:00 Mon")), Equals, false)
+	t.Check(sched.Match(at("2014-06-01 09:30 Sun")), Equals, false)
+
+	sched, err = scheduler.ParseSchedule("@daily")
+	t.Assert(err, IsNil)
+	t.Check(sched.Match(at("2014-06-01 00:00 Sun")), Equals, true)
+	t.Check(sched.Match(at("2014-06-01 01:00 Sun")), Equals, false)
+
+	// Day of month or day of week (7 is Sunday), like cron.
+	sched, err = scheduler.ParseSchedule("0 0 15 * 7")
+	t.Assert(err, IsNil)
+	t.Check(sched.Match(at("2014-06-01 00:00 Sun")), Equals, true)
+	t.Check(sched.Match(at("2014-06-15 00:00 Sun")), Equals, true)
+	t.Check(sched.Match(at("2014-07-15 00:00 Tue")), Equals, true)
+	t.Check(sched.Match(at("2014-07-16 00:00 Wed")), Equals, false)
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "x * * * *"} {
+		_, err := scheduler.ParseSchedule(bad)
+		t.Check(err, NotNil, Commentf(bad))
+	}
+}
+
+func (s *SchedulerTestSuite) TestRunJob(t *C) {
+	config := &scheduler.Config{
+		Jobs: []scheduler.Job{
+			{
+				Name:     "summary",
+				Schedule: "0 * * * *",
+				Service:  "sysinfo",
+				Cmd:      "MySQLSummary",
+				Data:     json.RawMessage(`{"x":1}`),
+			},
+		},
+	}
+	err := pct.Basedir.WriteConfig("scheduler", config)
+	t.Assert(err, IsNil)
+
+	m := scheduler.NewManager(s.logger, mock.NewClock(), s.spool, s.services, nil)
+	t.Assert(m.Start(), IsNil)
+	defer m.Stop()
+	t.Check(m.Status()["scheduler"], Equals, "Running 1 jobs")
+
+	// Not on the hour: job doesn't run.
+	now := time.Date(2014, 6, 1, 10, 5, 0, 0, time.UTC)
+	m.TickChan() <- now
+	select {
+	case <-s.dataChan:
+		t.Fatal("Job ran at 10:05")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	now = time.Date(2014, 6, 1, 11, 0, 0, 0, time.UTC)
+	m.TickChan() <- now
+	select {
+	case data := <-s.dataChan:
+		result, ok := data.(*scheduler.Result)
+		t.Assert(ok, Equals, true)
+		t.Check(result.Ts, Equals, now)
+		t.Check(result.Job, Equals, "summary")
+		t.Check(result.Cmd, Equals, "MySQLSummary")
+		t.Check(result.Error, Equals, "")
+	case <-time.After(time.Second):
+		t.Fatal("Job did not run at 11:00")
+	}
+	t.Assert(s.sysinfo.Cmds, HasLen, 1)
+	t.Check(s.sysinfo.Cmds[0].User, Equals, "scheduler")
+	cmdData := map[string]int{}
+	t.Check(json.Unmarshal(s.sysinfo.Cmds[0].Data, &cmdData), IsNil)
+	t.Check(cmdData, DeepEquals, map[string]int{"x": 1})
+}
+
+func (s *SchedulerTestSuite) TestValidate(t *C) {
+	allowed := func(service, cmd string) bool { return cmd != "StopService" }
+	m := scheduler.NewManager(s.logger, mock.NewClock(), s.spool, s.services, allowed)
+
+	jobs := []scheduler.Job{
+		{Name: "a", Schedule: "* * * * *", Service: "qan", Cmd: "Status"},
+		{Name: "b", Schedule: "* * * * *", Service: "sysinfo", Cmd: "StopService"},
+		{Name: "", Schedule: "* * * * *", Service: "sysinfo", Cmd: "MySQLSummary"},
+		{Name: "c", Schedule: "* * *", Service: "sysinfo", Cmd: "MySQLSummary"},
+	}
+	for _, job := range jobs {
+		data, _ := json.Marshal(scheduler.Config{Jobs: []scheduler.Job{job}})
+		reply := m.Handle(&proto.Cmd{Cmd: "SetConfig", Service: "scheduler", Data: data})
+		t.Check(reply.Error, Not(Equals), "", Commentf("%+v", job))
+	}
+	t.Check(pct.FileExists(pct.Basedir.ConfigFile("scheduler")), Equals, false)
+}
@@ -0,0 +1,36 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sysinfo
+
+const (
+	DEFAULT_TIMEOUT        = 60      // seconds
+	DEFAULT_MAX_OUTPUT     = 1048576 // bytes
+	DEFAULT_MAX_CONCURRENT = 2
+)
+
+type Config struct {
+	Timeout       uint // seconds
+	MaxOutput     int  // bytes, 0 = no limit, DEFAULT_MAX_OUTPUT if not set
+	MaxConcurrent int
+	Services      map[string]ServiceConfig `json:",omitempty"` // overrides, keyed on service (cmd) name
+}
+
+type ServiceConfig struct {
+	Timeout   uint // seconds, 0 = Config.Timeout
+	MaxOutput int  // bytes, 0 = Config.MaxOutput
+}
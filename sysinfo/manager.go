@@ -18,10 +18,13 @@
 package sysinfo
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
+	"os"
 	"sync"
+	"time"
 )
 
 const (
@@ -31,9 +34,11 @@ const (
 type Manager struct {
 	logger *pct.Logger
 	// --
+	config     *Config
 	service    map[string]Service
 	running    bool
-	sync.Mutex // This manager is single threaded, this lock protects usage from multiple goroutines
+	sem        chan bool // limits concurrent service commands
+	sync.Mutex           // guards config, service, running, and sem; not held while a service runs
 	// --
 	status *pct.Status
 }
@@ -60,6 +65,18 @@ func (m *Manager) Start() error {
 		return pct.ServiceIsRunningError{Service: SERVICE_NAME}
 	}
 
+	// Load config from disk, if any, else use defaults.
+	config := &Config{MaxOutput: DEFAULT_MAX_OUTPUT}
+	if err := pct.Basedir.ReadConfig(SERVICE_NAME, config); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := m.validateConfig(config); err != nil {
+		return err
+	}
+	m.setConfig(config)
+
 	m.running = true
 	m.logger.Info("Started")
 	m.status.Update(SERVICE_NAME, "Running")
@@ -73,23 +90,89 @@ func (m *Manager) Stop() error {
 
 func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 	m.Lock()
-	defer m.Unlock()
-
 	if !m.running {
+		m.Unlock()
 		return cmd.Reply(nil, pct.ServiceIsNotRunningError{Service: SERVICE_NAME})
 	}
 
-	m.status.UpdateRe(SERVICE_NAME, "Handling", cmd)
-	defer m.status.Update(SERVICE_NAME, "Running")
+	switch cmd.Cmd {
+	case "SetConfig":
+		defer m.Unlock()
+		newConfig := &Config{MaxOutput: DEFAULT_MAX_OUTPUT} // if not set
+		if err := json.Unmarshal(cmd.Data, newConfig); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		if err := m.validateConfig(newConfig); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		m.setConfig(newConfig)
+		// Write the new config.  If this fails, agent will use old config if restarted.
+		if err := pct.Basedir.WriteConfig(SERVICE_NAME, m.config); err != nil {
+			return cmd.Reply(m.config, fmt.Errorf("sysinfo.WriteConfig: %s", err))
+		}
+		return cmd.Reply(m.config)
+	case "GetConfig":
+		m.Unlock()
+		config, errs := m.GetConfig()
+		return cmd.Reply(config, errs...)
+	}
 
 	serviceName := cmd.Cmd
 	service, registered := m.service[serviceName]
+	limits := m.serviceConfig(serviceName)
+	sem := m.sem
+	m.Unlock()
+
 	if !registered {
 		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 	}
 
+	// Don't let a stuck command or a flood of commands wedge the agent:
+	// only so many commands can run at once, and each must finish within
+	// its timeout.  A service that doesn't finish in time keeps its slot
+	// until it does finish.
+	timeout := time.Duration(limits.Timeout) * time.Second
+	select {
+	case sem <- true:
+	case <-time.After(timeout):
+		return cmd.Reply(nil, fmt.Errorf("Timeout waiting to run %s: %d commands already running", serviceName, cap(sem)))
+	}
+
 	m.status.UpdateRe(SERVICE_NAME, fmt.Sprintf("Running %s", serviceName), cmd)
-	return service.Handle(cmd)
+	defer m.status.Update(SERVICE_NAME, "Running")
+
+	replyChan := make(chan *proto.Reply, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				m.logger.Error(fmt.Sprintf("%s crashed: %s", serviceName, err))
+				replyChan <- cmd.Reply(nil, fmt.Errorf("%s crashed: %s", serviceName, err))
+			}
+			<-sem
+		}()
+		replyChan <- service.Handle(cmd)
+	}()
+
+	// Services that run commands enforce the timeout themselves, so give them
+	// a moment to return their own, more specific error.
+	select {
+	case reply := <-replyChan:
+		// Services that run commands cap their output, but the reply of others
+		// is truncated, e.g. a very long list of processes.
+		if _, ok := service.(Limiter); !ok && limits.MaxOutput > 0 && len(reply.Data) > limits.MaxOutput {
+			data, err := TruncateJSON(reply.Data, limits.MaxOutput)
+			if err != nil {
+				m.logger.Warn(fmt.Sprintf("%s reply too large: %d bytes: %s", serviceName, len(reply.Data), err))
+				return cmd.Reply(nil, fmt.Errorf("%s reply is %d bytes which exceeds the limit of %d bytes", serviceName, len(reply.Data), limits.MaxOutput))
+			}
+			m.logger.Warn(fmt.Sprintf("%s reply truncated from %d to %d bytes", serviceName, len(reply.Data), len(data)))
+			reply.Data = data
+		}
+		return reply
+	case <-time.After(timeout + time.Second):
+		m.logger.Warn(fmt.Sprintf("%s timeout after %s", serviceName, timeout))
+		return cmd.Reply(nil, fmt.Errorf("Timeout running %s after %s", serviceName, timeout))
+	}
 }
 
 func (m *Manager) Status() map[string]string {
@@ -97,7 +180,23 @@ func (m *Manager) Status() map[string]string {
 }
 
 func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
-	return nil, nil
+	m.Lock()
+	defer m.Unlock()
+	if m.config == nil {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(m.config)
+	if err != nil {
+		return nil, []error{err}
+	}
+	// Configs are always returned as array of AgentConfig resources.
+	config := proto.AgentConfig{
+		InternalService: SERVICE_NAME,
+		// no external service
+		Config:  string(bytes),
+		Running: m.running,
+	}
+	return []proto.AgentConfig{config}, nil
 }
 
 /////////////////////////////////////////////////////////////////////////////
@@ -107,12 +206,68 @@ func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
 func (m *Manager) RegisterService(serviceName string, service Service) (err error) {
 	m.Lock()
 	defer m.Unlock()
-
 	_, registered := m.service[serviceName]
 	if registered {
 		return fmt.Errorf("%s already registered", serviceName)
 	}
-
 	m.service[serviceName] = service
+	if m.config != nil {
+		m.setLimits(serviceName, service)
+	}
+	return nil
+}
+
+func (m *Manager) validateConfig(config *Config) error {
+	if config.Timeout == 0 {
+		config.Timeout = DEFAULT_TIMEOUT
+	}
+	if config.MaxOutput < 0 {
+		return fmt.Errorf("Invalid MaxOutput: %d: must be >= 0 (0 = no limit)", config.MaxOutput)
+	}
+	if config.MaxConcurrent < 0 {
+		return fmt.Errorf("Invalid MaxConcurrent: %d: must be > 0", config.MaxConcurrent)
+	} else if config.MaxConcurrent == 0 {
+		config.MaxConcurrent = DEFAULT_MAX_CONCURRENT
+	}
+	for name, c := range config.Services {
+		if c.MaxOutput < 0 {
+			return fmt.Errorf("Invalid %s MaxOutput: %d: must be >= 0", name, c.MaxOutput)
+		}
+	}
 	return nil
 }
+
+// @lock: caller must hold m.Lock
+func (m *Manager) setConfig(config *Config) {
+	m.config = config
+	// Commands already running release their slot in the old sem.
+	m.sem = make(chan bool, config.MaxConcurrent)
+	for name, service := range m.service {
+		m.setLimits(name, service)
+	}
+}
+
+// @lock: caller must hold m.Lock
+func (m *Manager) serviceConfig(serviceName string) ServiceConfig {
+	limits := ServiceConfig{
+		Timeout:   m.config.Timeout,
+		MaxOutput: m.config.MaxOutput,
+	}
+	if c, ok := m.config.Services[serviceName]; ok {
+		if c.Timeout > 0 {
+			limits.Timeout = c.Timeout
+		}
+		if c.MaxOutput > 0 {
+			limits.MaxOutput = c.MaxOutput
+		}
+	}
+	return limits
+}
+
+// @lock: caller must hold m.Lock
+func (m *Manager) setLimits(serviceName string, service Service) {
+	if l, ok := service.(Limiter); ok {
+		limits := m.serviceConfig(serviceName)
+		l.SetLimits(time.Duration(limits.Timeout)*time.Second, limits.MaxOutput)
+	}
+}
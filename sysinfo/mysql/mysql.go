@@ -35,6 +35,8 @@ type MySQL struct {
 	CmdName string
 	logger  *pct.Logger
 	ir      *instance.Repo
+	// --
+	cmd.Limits
 }
 
 func NewMySQL(logger *pct.Logger, ir *instance.Repo) *MySQL {
@@ -74,6 +76,7 @@ func (m *MySQL) Handle(protoCmd *proto.Cmd) *proto.Reply {
 
 	// Run ptMySQLSummary with params
 	ptMySQLSummary := cmd.NewRealCmd(m.CmdName, args...)
+	ptMySQLSummary.Timeout, ptMySQLSummary.MaxOutput = m.GetLimits()
 	output, err := ptMySQLSummary.Run()
 	if err != nil {
 		m.logger.Error(fmt.Sprintf("%s: %s", m.CmdName, err))
@@ -0,0 +1,268 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/pct/cmd"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SERVICE_NAME = "script"
+	CONFIG_NAME  = "sysinfo-script"
+)
+
+// Config is read from CONFIG_NAME in the basedir config dir.  It's only
+// written by the operator, never by the agent or API, else the API could
+// whitelist any script.
+type Config struct {
+	Dir     string            // only scripts in this dir can be run
+	Scripts map[string]string // script file name => SHA256 checksum (hex)
+}
+
+// Cmd.Data for the Script command.
+type Run struct {
+	Name string // script file name in Config.Dir
+}
+
+type Result struct {
+	Name     string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+type output interface {
+	io.Writer
+	String() string
+}
+
+type Script struct {
+	logger *pct.Logger
+	// --
+	cmd.Limits
+}
+
+func NewScript(logger *pct.Logger) *Script {
+	s := &Script{
+		logger: logger,
+	}
+	return s
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (s *Script) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	run := &Run{}
+	if protoCmd.Data == nil {
+		return protoCmd.Reply(nil, fmt.Errorf("%s: cmd.Data is empty", SERVICE_NAME))
+	}
+	if err := json.Unmarshal(protoCmd.Data, run); err != nil {
+		return protoCmd.Reply(nil, fmt.Errorf("%s: json.Unmarshal: %s", SERVICE_NAME, err))
+	}
+
+	// Re-read the config every time so the operator can change it without
+	// restarting the agent.
+	config := &Config{}
+	if err := pct.Basedir.ReadConfig(CONFIG_NAME, config); err != nil {
+		if os.IsNotExist(err) {
+			return protoCmd.Reply(nil, fmt.Errorf("No scripts are whitelisted: %s does not exist", pct.Basedir.ConfigFile(CONFIG_NAME)))
+		}
+		return protoCmd.Reply(nil, err)
+	}
+
+	f, err := Verify(config, run.Name)
+	if err != nil {
+		s.logger.Warn(err)
+		return protoCmd.Reply(nil, err)
+	}
+	defer f.Close()
+
+	s.logger.Info("Running " + f.Name())
+	result, err := s.run(f)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("%s: %s", f.Name(), err))
+	}
+	if result != nil {
+		result.Name = run.Name
+	}
+	return protoCmd.Reply(result, err)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// OWNER_UID must own whitelisted scripts and their dir: root, so only root
+// can change them.
+var OWNER_UID = 0 // var for testing
+
+// Verify opens the script if it's whitelisted in the config, its checksum
+// matches, and only root can change it and its dir, else it returns an error.
+// The script must be run from the returned file with Command, not by name, so
+// it's the file that was verified even if the name is replaced after.  The
+// caller must close the file.
+func Verify(config *Config, name string) (*os.File, error) {
+	if config.Dir == "" || !filepath.IsAbs(config.Dir) {
+		return nil, fmt.Errorf("Invalid %s config: Dir must be an absolute path", CONFIG_NAME)
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("Invalid script name: %s", name)
+	}
+	pinned, ok := config.Scripts[name]
+	if !ok {
+		return nil, fmt.Errorf("Script %s is not whitelisted", name)
+	}
+
+	fi, err := os.Stat(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", config.Dir)
+	}
+	if err := checkOwner(config.Dir, fi); err != nil {
+		return nil, err
+	}
+
+	// Don't follow a link to something else.
+	file := filepath.Join(config.Dir, name)
+	f, err := os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyFile(f, pinned); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Command returns the command to run the script file returned by Verify.  It's
+// run from the open file (/dev/fd/3 in the script process), not by name.
+func Command(f *os.File, args ...string) *exec.Cmd {
+	return &exec.Cmd{
+		Path:       "/dev/fd/3",
+		Args:       append([]string{f.Name()}, args...),
+		Dir:        filepath.Dir(f.Name()),
+		ExtraFiles: []*os.File{f},
+	}
+}
+
+func verifyFile(f *os.File, pinned string) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", f.Name())
+	}
+	if err := checkOwner(f.Name(), fi); err != nil {
+		return err
+	}
+
+	// Checksum the bytes in the open file, which is what's run.
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(sum, pinned) {
+		return fmt.Errorf("%s checksum does not match: %s", f.Name(), sum)
+	}
+	// Some systems run /dev/fd/N from the current offset.
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkOwner returns an error if anyone but OWNER_UID can change the file or dir.
+func checkOwner(file string, fi os.FileInfo) error {
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is group or world writable", file)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != OWNER_UID {
+		return fmt.Errorf("%s is not owned by uid %d", file, OWNER_UID)
+	}
+	return nil
+}
+
+func (s *Script) run(f *os.File) (*Result, error) {
+	timeout, maxOutput := s.GetLimits()
+	var stdout, stderr output = &bytes.Buffer{}, &bytes.Buffer{}
+	if maxOutput > 0 {
+		stdout = cmd.NewLimitedBuffer(maxOutput)
+		stderr = cmd.NewLimitedBuffer(maxOutput)
+	}
+
+	script := Command(f)
+	script.Stdout = stdout
+	script.Stderr = stderr
+	if err := script.Start(); err != nil {
+		return nil, err
+	}
+
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- script.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-doneChan:
+	case <-time.After(timeout):
+		// Don't wait for Wait(): it blocks until the script's children
+		// close stdout and stderr too.
+		script.Process.Kill()
+		return nil, cmd.ErrTimeout
+	}
+
+	result := &Result{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+	if err != nil {
+		// A non-zero exit isn't an error running the script, it's a result.
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, err
+		}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+		} else {
+			result.ExitCode = -1
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,190 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package script_test
+
+import (
+	"encoding/json"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/sysinfo/script"
+	"github.com/percona/percona-agent/test"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var sample = test.RootDir + "/sysinfo/scripts"
+
+const helloSum = "a4a6e45af53bbaf7d880800dfdf2c9b974ba6ddcbfa27482e3021a3525bad591"
+
+type TestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+	tmpDir  string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logger = pct.NewLogger(s.logChan, script.SERVICE_NAME+"-test")
+
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-test")
+	t.Assert(err, IsNil)
+	if err := pct.Basedir.Init(s.tmpDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sample scripts are owned by whoever checked out the repo.
+	script.OWNER_UID = os.Getuid()
+}
+
+func (s *TestSuite) SetUpTest(t *C) {
+	if err := pct.Basedir.RemoveConfig(script.CONFIG_NAME); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *TestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *TestSuite) TestVerify(t *C) {
+	config := &script.Config{
+		Dir: sample,
+		Scripts: map[string]string{
+			"hello.sh":   helloSum,
+			"missing.sh": helloSum,
+		},
+	}
+
+	f, err := script.Verify(config, "hello.sh")
+	t.Assert(err, IsNil)
+	t.Check(f.Name(), Equals, sample+"/hello.sh")
+	f.Close()
+
+	_, err = script.Verify(config, "missing.sh")
+	t.Check(err, NotNil)
+
+	_, err = script.Verify(config, "not-whitelisted.sh")
+	t.Check(err, ErrorMatches, "Script not-whitelisted.sh is not whitelisted")
+
+	_, err = script.Verify(config, "../scripts/hello.sh")
+	t.Check(err, ErrorMatches, "Invalid script name: .*")
+
+	config.Scripts["hello.sh"] = "0123456789abcdef"
+	_, err = script.Verify(config, "hello.sh")
+	t.Check(err, ErrorMatches, ".*checksum does not match.*")
+
+	config.Dir = "scripts"
+	_, err = script.Verify(config, "hello.sh")
+	t.Check(err, ErrorMatches, "Invalid .* config: Dir must be an absolute path")
+}
+
+func (s *TestSuite) TestVerifyOwner(t *C) {
+	// Only a script and dir owned by root (OWNER_UID) can be run.
+	config := &script.Config{
+		Dir:     sample,
+		Scripts: map[string]string{"hello.sh": helloSum},
+	}
+	script.OWNER_UID = os.Getuid() + 1
+	defer func() { script.OWNER_UID = os.Getuid() }()
+	_, err := script.Verify(config, "hello.sh")
+	t.Check(err, ErrorMatches, ".* is not owned by uid .*")
+	script.OWNER_UID = os.Getuid()
+
+	// The dir can't be group or world writable, else the script could be
+	// replaced by anyone.
+	dir := filepath.Join(s.tmpDir, "scripts")
+	err = os.Mkdir(dir, 0755)
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	content, err := ioutil.ReadFile(sample + "/hello.sh")
+	t.Assert(err, IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "hello.sh"), content, 0755)
+	t.Assert(err, IsNil)
+	config.Dir = dir
+	f, err := script.Verify(config, "hello.sh")
+	t.Assert(err, IsNil)
+	f.Close()
+
+	err = os.Chmod(dir, 0777)
+	t.Assert(err, IsNil)
+	_, err = script.Verify(config, "hello.sh")
+	t.Check(err, ErrorMatches, ".* is group or world writable")
+	os.Chmod(dir, 0755)
+
+	// Links aren't followed.
+	err = os.Symlink(sample+"/hello.sh", filepath.Join(dir, "link.sh"))
+	t.Assert(err, IsNil)
+	config.Scripts["link.sh"] = helloSum
+	_, err = script.Verify(config, "link.sh")
+	t.Check(err, NotNil)
+}
+
+func (s *TestSuite) TestRun(t *C) {
+	service := script.NewScript(s.logger)
+
+	cmd := &proto.Cmd{
+		Service: "sysinfo",
+		Cmd:     "Script",
+		Data:    []byte(`{"Name":"hello.sh"}`),
+	}
+
+	// No config, no scripts.
+	gotReply := service.Handle(cmd)
+	t.Check(gotReply.Error, Matches, "No scripts are whitelisted: .*")
+
+	config := &script.Config{
+		Dir:     sample,
+		Scripts: map[string]string{"hello.sh": helloSum},
+	}
+	err := pct.Basedir.WriteConfig(script.CONFIG_NAME, config)
+	t.Assert(err, IsNil)
+
+	gotReply = service.Handle(cmd)
+	t.Assert(gotReply.Error, Equals, "")
+	result := &script.Result{}
+	err = json.Unmarshal(gotReply.Data, result)
+	t.Assert(err, IsNil)
+	t.Check(result, DeepEquals, &script.Result{
+		Name:     "hello.sh",
+		Stdout:   "hello\n",
+		Stderr:   "oops\n",
+		ExitCode: 3,
+	})
+
+	// Output is capped.
+	service.SetLimits(time.Second, 2)
+	gotReply = service.Handle(cmd)
+	t.Assert(gotReply.Error, Equals, "")
+	err = json.Unmarshal(gotReply.Data, result)
+	t.Assert(err, IsNil)
+	t.Check(result.Stdout, Matches, "he\n.output truncated: 4 bytes not shown.\n")
+}
@@ -19,8 +19,17 @@ package sysinfo
 
 import (
 	"github.com/percona/cloud-protocol/proto"
+	"time"
 )
 
 type Service interface {
 	Handle(cmd *proto.Cmd) (reply *proto.Reply)
 }
+
+// Limiter is implemented by services that run external commands so the
+// manager can pass them the configured timeout and output cap.  The manager
+// applies the timeout to every service, but only a service can stop the
+// command it's running.
+type Limiter interface {
+	SetLimits(timeout time.Duration, maxOutput int)
+}
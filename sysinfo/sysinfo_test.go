@@ -18,13 +18,18 @@
 package sysinfo_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
 	"github.com/percona/percona-agent/sysinfo"
 	"github.com/percona/percona-agent/test/mock"
 	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -37,13 +42,33 @@ func Test(t *testing.T) { TestingT(t) }
 type ManagerTestSuite struct {
 	logChan chan *proto.LogEntry
 	logger  *pct.Logger
+	tmpDir  string
 }
 
 var _ = Suite(&ManagerTestSuite{})
 
 func (s *ManagerTestSuite) SetUpSuite(t *C) {
-	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logChan = make(chan *proto.LogEntry, 100)
 	s.logger = pct.NewLogger(s.logChan, sysinfo.SERVICE_NAME+"-manager-test")
+
+	var err error
+	s.tmpDir, err = ioutil.TempDir("/tmp", "agent-test")
+	t.Assert(err, IsNil)
+	if err := pct.Basedir.Init(s.tmpDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *ManagerTestSuite) SetUpTest(t *C) {
+	if err := pct.Basedir.RemoveConfig(sysinfo.SERVICE_NAME); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *ManagerTestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Error(err)
+	}
 }
 
 // --------------------------------------------------------------------------
@@ -97,3 +122,122 @@ func (s *ManagerTestSuite) TestStartStopHandleManager(t *C) {
 	status = m.Status()
 	t.Check(status[sysinfo.SERVICE_NAME], Equals, "Running")
 }
+
+func (s *ManagerTestSuite) TestLimits(t *C) {
+	config := &sysinfo.Config{
+		Timeout:       1,
+		MaxOutput:     100,
+		MaxConcurrent: 1,
+		Services: map[string]sysinfo.ServiceConfig{
+			"Big": {MaxOutput: 1000},
+		},
+	}
+	err := pct.Basedir.WriteConfig(sysinfo.SERVICE_NAME, config)
+	t.Assert(err, IsNil)
+
+	slowService := mock.NewSysinfoService()
+	slowService.Delay = 5 * time.Second
+	bigService := mock.NewSysinfoService()
+	bigService.Data = strings.Repeat("x", 500)
+
+	m := sysinfo.NewManager(s.logger)
+	m.RegisterService("Slow", slowService)
+	m.RegisterService("Big", bigService)
+	m.RegisterService("TooBig", bigService)
+	err = m.Start()
+	t.Assert(err, IsNil)
+
+	// Per-service MaxOutput overrides the global MaxOutput.  Replies of
+	// services that aren't Limiters are truncated to fit.
+	gotReply := m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "Big"})
+	t.Check(gotReply.Error, Equals, "")
+	t.Check(len(gotReply.Data), Equals, 502)
+	gotReply = m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "TooBig"})
+	t.Check(gotReply.Error, Equals, "")
+	t.Check(len(gotReply.Data), Equals, 100)
+	var got string
+	t.Check(json.Unmarshal(gotReply.Data, &got), IsNil)
+	t.Check(strings.HasSuffix(got, sysinfo.TRUNCATED_MARKER), Equals, true)
+
+	// A slow service times out, but it still holds the only slot (MaxConcurrent=1)
+	// until it's done, so the next cmd times out waiting to run.
+	gotReply = m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "Slow"})
+	t.Check(gotReply.Error, Equals, "Timeout running Slow after 1s")
+	gotReply = m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "Big"})
+	t.Check(gotReply.Error, Equals, "Timeout waiting to run Big: 1 commands already running")
+
+	// Once the slow service finishes, its slot is free again.
+	time.Sleep(2500 * time.Millisecond)
+	gotReply = m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "Big"})
+	t.Check(gotReply.Error, Equals, "")
+}
+
+func (s *ManagerTestSuite) TestSetConfig(t *C) {
+	m := sysinfo.NewManager(s.logger)
+	err := m.Start()
+	t.Assert(err, IsNil)
+
+	// Defaults are used if there's no config file.
+	configs, errs := m.GetConfig()
+	t.Assert(errs, HasLen, 0)
+	t.Assert(configs, HasLen, 1)
+	config := &sysinfo.Config{}
+	err = json.Unmarshal([]byte(configs[0].Config), config)
+	t.Assert(err, IsNil)
+	t.Check(config, DeepEquals, &sysinfo.Config{
+		Timeout:       sysinfo.DEFAULT_TIMEOUT,
+		MaxOutput:     sysinfo.DEFAULT_MAX_OUTPUT,
+		MaxConcurrent: sysinfo.DEFAULT_MAX_CONCURRENT,
+	})
+
+	config.MaxConcurrent = 5
+	data, _ := json.Marshal(config)
+	gotReply := m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "SetConfig", Data: data})
+	t.Check(gotReply.Error, Equals, "")
+
+	// New config is written to disk.
+	savedConfig := &sysinfo.Config{}
+	err = pct.Basedir.ReadConfig(sysinfo.SERVICE_NAME, savedConfig)
+	t.Assert(err, IsNil)
+	t.Check(savedConfig, DeepEquals, config)
+
+	// MaxOutput 0 is no limit, not the default.
+	config.MaxOutput = 0
+	data, _ = json.Marshal(config)
+	gotReply = m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "SetConfig", Data: data})
+	t.Check(gotReply.Error, Equals, "")
+	configs, _ = m.GetConfig()
+	err = json.Unmarshal([]byte(configs[0].Config), savedConfig)
+	t.Assert(err, IsNil)
+	t.Check(savedConfig.MaxOutput, Equals, 0)
+
+	config.MaxOutput = -1
+	data, _ = json.Marshal(config)
+	gotReply = m.Handle(&proto.Cmd{Service: sysinfo.SERVICE_NAME, Cmd: "SetConfig", Data: data})
+	t.Check(gotReply.Error, Not(Equals), "")
+}
+
+func (s *ManagerTestSuite) TestTruncateJSON(t *C) {
+	procs := make([]map[string]interface{}, 100)
+	for i := range procs {
+		procs[i] = map[string]interface{}{"Pid": i, "Cmd": "mysqld"}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"Procs": procs, "Total": 100})
+	got, err := sysinfo.TruncateJSON(data, 300)
+	t.Assert(err, IsNil)
+	t.Check(len(got) <= 300, Equals, true)
+
+	// Still valid JSON with the first processes.
+	top := struct {
+		Procs []struct{ Pid int }
+		Total int
+	}{}
+	t.Assert(json.Unmarshal(got, &top), IsNil)
+	t.Check(len(top.Procs) > 0, Equals, true)
+	t.Check(top.Procs[0].Pid, Equals, 0)
+	t.Check(top.Total, Equals, 100)
+
+	// Data that can't be cut is an error.
+	_, err = sysinfo.TruncateJSON([]byte(`{"a":1,"b":2}`), 5)
+	t.Check(err, NotNil)
+}
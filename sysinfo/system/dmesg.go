@@ -0,0 +1,164 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/pct/cmd"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	KMSG_OOM      = "oom"
+	KMSG_IO_ERROR = "io-error"
+	KMSG_HUNG     = "hung-task"
+
+	DEFAULT_KMSG_LIMIT = 100
+)
+
+// Kernel messages that frequently explain MySQL crashes and stalls.  Order
+// matters: a line is categorized by the first pattern it matches.
+var kmsgPatterns = []struct {
+	category string
+	re       *regexp.Regexp
+}{
+	{KMSG_OOM, regexp.MustCompile(`(?i)out of memory|oom-killer|oom_reaper|killed process \d+|memory cgroup out of memory`)},
+	{KMSG_HUNG, regexp.MustCompile(`(?i)blocked for more than \d+ seconds|hung_task_timeout_secs|task \S+ blocked`)},
+	{KMSG_IO_ERROR, regexp.MustCompile(`(?i)i/o error|blk_update_request|medium error|end_request|ext[234]-fs error|xfs.*(error|corruption)|aborting journal|remounting filesystem read-only|ata\d+.*(failed command|exception)|scsi error|sense key`)},
+}
+
+// KmsgConfig is the optional cmd.Data for the KernelLog command.
+type KmsgConfig struct {
+	Categories []string // default all
+	Limit      int      // most recent messages, default DEFAULT_KMSG_LIMIT
+}
+
+type KernelMessage struct {
+	Uptime   float64 // seconds since boot, 0 if not printed
+	Category string
+	Message  string
+}
+
+type Dmesg struct {
+	CmdName string
+	logger  *pct.Logger
+	// --
+	cmd.Limits
+}
+
+func NewDmesg(logger *pct.Logger) *Dmesg {
+	return &Dmesg{
+		CmdName: "dmesg",
+		logger:  logger,
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (d *Dmesg) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	config := &KmsgConfig{}
+	if protoCmd.Data != nil {
+		if err := json.Unmarshal(protoCmd.Data, config); err != nil {
+			return protoCmd.Reply(nil, fmt.Errorf("Invalid KernelLog config: %s", err))
+		}
+	}
+	if config.Limit <= 0 {
+		config.Limit = DEFAULT_KMSG_LIMIT
+	}
+
+	// The output cap isn't applied to dmesg because the most recent messages
+	// are at the end.  The reply is capped by config.Limit instead.
+	dmesg := cmd.NewRealCmd(d.CmdName)
+	dmesg.Timeout, _ = d.GetLimits()
+	output, err := dmesg.Run()
+	if err != nil {
+		d.logger.Error(fmt.Sprintf("%s: %s", d.CmdName, err))
+		return protoCmd.Reply(nil, err)
+	}
+
+	messages := FilterKernelMessages(output, config.Categories, config.Limit)
+	return protoCmd.Reply(messages)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// FilterKernelMessages returns the last limit messages in dmesg output that
+// match one of the categories (all categories if none are given).
+func FilterKernelMessages(output string, categories []string, limit int) []KernelMessage {
+	want := make(map[string]bool)
+	for _, c := range categories {
+		want[c] = true
+	}
+
+	messages := []KernelMessage{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		category := categorize(line)
+		if category == "" || (len(want) > 0 && !want[category]) {
+			continue
+		}
+		uptime, msg := splitUptime(line)
+		messages = append(messages, KernelMessage{
+			Uptime:   uptime,
+			Category: category,
+			Message:  msg,
+		})
+	}
+
+	if limit > 0 && len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return messages
+}
+
+func categorize(line string) string {
+	for _, p := range kmsgPatterns {
+		if p.re.MatchString(line) {
+			return p.category
+		}
+	}
+	return ""
+}
+
+// splitUptime splits "[ 1234.567890] msg" into 1234.56789 and "msg".
+func splitUptime(line string) (float64, string) {
+	if !strings.HasPrefix(line, "[") {
+		return 0, line
+	}
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return 0, line
+	}
+	uptime, err := strconv.ParseFloat(strings.TrimSpace(line[1:end]), 64)
+	if err != nil {
+		return 0, line
+	}
+	return uptime, strings.TrimSpace(line[end+1:])
+}
@@ -0,0 +1,397 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/pct/cmd"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	HEALTH_OK       = "ok"
+	HEALTH_DEGRADED = "degraded"
+	HEALTH_FAILED   = "failed"
+	HEALTH_UNKNOWN  = "unknown"
+)
+
+// SMART attributes which, when non-zero, mean a disk is failing even if its
+// overall self-assessment still passes.
+var smartFailingAttributes = []string{
+	"Reallocated_Sector_Ct",
+	"Reported_Uncorrect",
+	"Current_Pending_Sector",
+	"Offline_Uncorrectable",
+}
+
+type DiskHealth struct {
+	Tools  []string // tools found and used, e.g. smartctl, MegaCli64
+	Disks  []Disk
+	Arrays []Array
+}
+
+type Disk struct {
+	Device     string
+	Model      string
+	Serial     string
+	Health     string
+	Attributes map[string]int64 `json:",omitempty"` // raw values of smartFailingAttributes
+}
+
+type Array struct {
+	Controller string // tool that reported the array
+	Name       string
+	Level      string
+	Size       string
+	State      string // as reported by the tool
+	Health     string
+}
+
+type Health struct {
+	logger *pct.Logger
+	// Candidate names of each tool; the first one found in $PATH is used.
+	Smartctl []string
+	MegaCLI  []string
+	HPSSACLI []string
+	// --
+	cmd.Limits
+}
+
+func NewHealth(logger *pct.Logger) *Health {
+	h := &Health{
+		logger:   logger,
+		Smartctl: []string{"smartctl"},
+		MegaCLI:  []string{"MegaCli64", "MegaCli", "megacli"},
+		HPSSACLI: []string{"hpssacli", "ssacli", "hpacucli"},
+	}
+	return h
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (h *Health) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	health := &DiskHealth{
+		Tools:  []string{},
+		Disks:  []Disk{},
+		Arrays: []Array{},
+	}
+	errs := []error{}
+
+	if smartctl := findTool(h.Smartctl); smartctl != "" {
+		health.Tools = append(health.Tools, smartctl)
+		disks, err := h.smart(smartctl)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		health.Disks = append(health.Disks, disks...)
+	}
+
+	if megacli := findTool(h.MegaCLI); megacli != "" {
+		health.Tools = append(health.Tools, megacli)
+		output, err := h.run(megacli, "-LDInfo", "-Lall", "-aALL", "-NoLog")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", megacli, err))
+		} else {
+			health.Arrays = append(health.Arrays, ParseMegaCLI(output)...)
+		}
+	}
+
+	if hpssacli := findTool(h.HPSSACLI); hpssacli != "" {
+		health.Tools = append(health.Tools, hpssacli)
+		output, err := h.run(hpssacli, "ctrl", "all", "show", "config")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", hpssacli, err))
+		} else {
+			health.Arrays = append(health.Arrays, ParseHPSSACLI(output)...)
+		}
+	}
+
+	for _, err := range errs {
+		h.logger.Warn(err)
+	}
+
+	if len(health.Tools) == 0 {
+		return protoCmd.Reply(health, fmt.Errorf("None of smartctl, MegaCLI, or hpssacli found in $PATH"))
+	}
+	return protoCmd.Reply(health, errs...)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+func findTool(names []string) string {
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func (h *Health) run(name string, args ...string) (string, error) {
+	c := cmd.NewRealCmd(name, args...)
+	c.Timeout, _ = h.GetLimits()
+	return c.Run()
+}
+
+func (h *Health) smart(smartctl string) ([]Disk, error) {
+	output, err := h.run(smartctl, "--scan")
+	if err != nil {
+		return nil, fmt.Errorf("%s --scan: %s", smartctl, err)
+	}
+	disks := []Disk{}
+	for _, dev := range ParseSmartctlScan(output) {
+		// smartctl exit status is a bitmask which is non-zero for failing
+		// disks, so the output is parsed even if there's an error.
+		args := append([]string{"-i", "-H", "-A"}, dev...)
+		output, err := h.run(smartctl, args...)
+		if err == cmd.ErrTimeout || err == cmd.ErrKillProcessAfterTimeout {
+			return disks, fmt.Errorf("%s %s: %s", smartctl, strings.Join(args, " "), err)
+		}
+		disk := ParseSmartctl(output)
+		disk.Device = strings.Join(dev, " ")
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+// ParseSmartctlScan returns the device args (e.g. [/dev/sda -d sat]) of each
+// device listed by smartctl --scan.
+func ParseSmartctlScan(output string) [][]string {
+	/**
+	 * /dev/sda -d scsi # /dev/sda, SCSI device
+	 * /dev/bus/0 -d megaraid,0 # /dev/bus/0 [megaraid_disk_00], SCSI device
+	 */
+	devices := [][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if i := strings.Index(line, "#"); i > -1 {
+			line = line[0:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		devices = append(devices, fields)
+	}
+	return devices
+}
+
+var smartAttrRe = regexp.MustCompile(`^\s*\d+\s+(\S+)\s+0x[0-9a-fA-F]+\s+\d+\s+\d+\s+\S+\s+\S+\s+\S+\s+\S+\s+(\d+)`)
+
+func ParseSmartctl(output string) Disk {
+	/**
+	 * Device Model:     INTEL SSDSC2BB480G4
+	 * Serial Number:    BTWL4123456789
+	 * SMART overall-health self-assessment test result: PASSED
+	 * ...
+	 * ID# ATTRIBUTE_NAME          FLAG     VALUE WORST THRESH TYPE      UPDATED  WHEN_FAILED RAW_VALUE
+	 *   5 Reallocated_Sector_Ct   0x0032   100   100   000    Old_age   Always       -       0
+	 *
+	 * SAS disks report "SMART Health Status: OK" instead.
+	 */
+	disk := Disk{
+		Health:     HEALTH_UNKNOWN,
+		Attributes: make(map[string]int64),
+	}
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Device Model:"), strings.HasPrefix(line, "Product:"):
+			disk.Model = strings.TrimSpace(line[strings.Index(line, ":")+1:])
+		case strings.HasPrefix(line, "Serial Number:"), strings.HasPrefix(line, "Serial number:"):
+			disk.Serial = strings.TrimSpace(line[strings.Index(line, ":")+1:])
+		case strings.HasPrefix(line, "SMART overall-health self-assessment test result:"),
+			strings.HasPrefix(line, "SMART Health Status:"):
+			result := strings.TrimSpace(line[strings.LastIndex(line, ":")+1:])
+			if result == "PASSED" || result == "OK" {
+				disk.Health = HEALTH_OK
+			} else {
+				disk.Health = HEALTH_FAILED
+			}
+		default:
+			m := smartAttrRe.FindStringSubmatch(line)
+			if len(m) != 3 {
+				continue
+			}
+			for _, attr := range smartFailingAttributes {
+				if m[1] == attr {
+					val, _ := strconv.ParseInt(m[2], 10, 64)
+					disk.Attributes[attr] = val
+				}
+			}
+		}
+	}
+	if disk.Health == HEALTH_OK {
+		for _, val := range disk.Attributes {
+			if val > 0 {
+				disk.Health = HEALTH_DEGRADED
+				break
+			}
+		}
+	}
+	return disk
+}
+
+func ParseMegaCLI(output string) []Array {
+	/**
+	 * Adapter 0 -- Virtual Drive Information:
+	 * Virtual Drive: 0 (Target Id: 0)
+	 * Name                :
+	 * RAID Level          : Primary-1, Secondary-0, RAID Level Qualifier-0
+	 * Size                : 278.875 GB
+	 * State               : Optimal
+	 */
+	arrays := []Array{}
+	adapter := ""
+	var array *Array
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Adapter ") {
+			if fields := strings.Fields(line); len(fields) > 1 {
+				adapter = fields[1]
+			}
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		val := strings.TrimSpace(kv[1])
+		switch key {
+		case "Virtual Drive", "Virtual Disk":
+			fields := strings.Fields(val)
+			if len(fields) == 0 {
+				continue // no drive number, e.g. truncated output
+			}
+			if array != nil {
+				arrays = append(arrays, *array)
+			}
+			array = &Array{
+				Controller: "megacli",
+				Name:       "a" + adapter + "/vd" + fields[0],
+				Health:     HEALTH_UNKNOWN,
+			}
+		case "RAID Level":
+			if array != nil {
+				array.Level = megaCLIRAIDLevel(val)
+			}
+		case "Size":
+			if array != nil {
+				array.Size = val
+			}
+		case "State":
+			if array != nil {
+				array.State = val
+				switch {
+				case strings.HasPrefix(val, "Optimal"):
+					array.Health = HEALTH_OK
+				case strings.Contains(val, "Degraded"):
+					array.Health = HEALTH_DEGRADED
+				case strings.HasPrefix(val, "Offline"), strings.HasPrefix(val, "Failed"):
+					array.Health = HEALTH_FAILED
+				}
+			}
+		}
+	}
+	if array != nil {
+		arrays = append(arrays, *array)
+	}
+	return arrays
+}
+
+// megaCLIRAIDLevel maps "Primary-1, Secondary-3, ..." to the common name.
+func megaCLIRAIDLevel(val string) string {
+	primary, secondary := "", ""
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "Primary-") {
+			primary = part[len("Primary-"):]
+		} else if strings.HasPrefix(part, "Secondary-") {
+			secondary = part[len("Secondary-"):]
+		}
+	}
+	if primary == "" {
+		return val
+	}
+	if primary == "1" && secondary == "3" {
+		return "RAID 10"
+	}
+	return "RAID " + primary
+}
+
+var hpArrayRe = regexp.MustCompile(`^\s*logicaldrive\s+(\S+)\s+\(([^)]*)\)`)
+
+func ParseHPSSACLI(output string) []Array {
+	/**
+	 * Smart Array P420i in Slot 0 (Embedded)    (sn: 001438029B1B2A0)
+	 *    array A (SAS, Unused Space: 0  MB)
+	 *       logicaldrive 1 (279.4 GB, RAID 1, OK)
+	 *       physicaldrive 1I:1:1 (port 1I:box 1:bay 1, SAS, 300 GB, OK)
+	 */
+	arrays := []Array{}
+	slot := ""
+	for _, line := range strings.Split(output, "\n") {
+		if i := strings.Index(line, " in Slot "); i > -1 {
+			if fields := strings.Fields(line[i+len(" in Slot "):]); len(fields) > 0 {
+				slot = fields[0]
+			}
+			continue
+		}
+		m := hpArrayRe.FindStringSubmatch(line)
+		if len(m) != 3 {
+			continue
+		}
+		parts := strings.Split(m[2], ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		array := Array{
+			Controller: "hpssacli",
+			Name:       "slot" + slot + "/ld" + m[1],
+			Size:       parts[0],
+			Health:     HEALTH_UNKNOWN,
+		}
+		if len(parts) > 1 {
+			array.Level = parts[1]
+		}
+		if len(parts) > 2 {
+			// The status is last and can include progress, e.g.
+			// "Recovering, 25% complete".
+			array.State = strings.Join(parts[2:], ", ")
+			switch {
+			case parts[2] == "OK":
+				array.Health = HEALTH_OK
+			case parts[2] == "Failed":
+				array.Health = HEALTH_FAILED
+			default:
+				// Interim Recovery Mode, Recovering, Ready for Rebuild, etc.
+				array.Health = HEALTH_DEGRADED
+			}
+		}
+		arrays = append(arrays, array)
+	}
+	return arrays
+}
@@ -0,0 +1,263 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+)
+
+// Thresholds for NUMA and swapping warnings.
+const (
+	MAX_SWAPPINESS       = 10   // 1 is recommended for MySQL
+	MAX_NUMA_MISS_RATIO  = 0.05 // numa_miss / (numa_hit + numa_miss)
+	NUMA_LOW_FREE_RATIO  = 0.05 // node almost out of memory...
+	NUMA_HIGH_FREE_RATIO = 0.25 // ...while another node has plenty
+)
+
+type NUMANode struct {
+	Id       int
+	CPUs     string            // e.g. 0-7,16-23
+	MemTotal uint64            // bytes
+	MemFree  uint64            // bytes
+	MemUsed  uint64            // bytes
+	Stats    map[string]uint64 // numastat: numa_hit, numa_miss, etc. (pages)
+}
+
+type NUMAInfo struct {
+	Nodes           []NUMANode
+	Swappiness      int    // vm.swappiness
+	ZoneReclaimMode int    // vm.zone_reclaim_mode
+	SwapTotal       uint64 // bytes
+	SwapFree        uint64 // bytes
+	SwapIn          uint64 // pages since boot (pswpin)
+	SwapOut         uint64 // pages since boot (pswpout)
+	Warnings        []string
+}
+
+// NUMA is the NUMA sysinfo service: NUMA layout, per-node memory and numastat,
+// swapping, and warnings about settings and imbalance that hurt MySQL.
+type NUMA struct {
+	logger *pct.Logger
+	// Root dirs are vars so tests can use sample files.
+	ProcDir string
+	SysDir  string
+}
+
+func NewNUMA(logger *pct.Logger) *NUMA {
+	n := &NUMA{
+		logger:  logger,
+		ProcDir: "/proc",
+		SysDir:  "/sys",
+	}
+	return n
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (n *NUMA) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	// Partial info is better than none, so errors are only logged.
+	info, errs := ReadNUMA(n.ProcDir, n.SysDir)
+	for _, err := range errs {
+		n.logger.Warn(err)
+	}
+	info.Warnings = CheckNUMA(info)
+	return protoCmd.Reply(info)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// ReadNUMA reads the NUMA nodes from sysDir/devices/system/node and swapping
+// info from procDir.  Hosts without NUMA have no nodes.
+func ReadNUMA(procDir, sysDir string) (*NUMAInfo, []error) {
+	info := &NUMAInfo{Nodes: []NUMANode{}}
+	errs := []error{}
+
+	dirs, _ := filepath.Glob(filepath.Join(sysDir, "devices/system/node/node[0-9]*"))
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		node := NUMANode{Id: id}
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "cpulist")); err == nil {
+			node.CPUs = strings.TrimSpace(string(content))
+		}
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "meminfo")); err != nil {
+			errs = append(errs, err)
+		} else {
+			mem := ParseNodeMeminfo(content)
+			node.MemTotal = mem["MemTotal"]
+			node.MemFree = mem["MemFree"]
+			node.MemUsed = mem["MemUsed"]
+		}
+		if content, err := ioutil.ReadFile(filepath.Join(dir, "numastat")); err != nil {
+			errs = append(errs, err)
+		} else {
+			node.Stats = ParseNumastat(content)
+		}
+		info.Nodes = append(info.Nodes, node)
+	}
+	sort.Sort(nodesById(info.Nodes))
+
+	if v, err := readInt(filepath.Join(procDir, "sys/vm/swappiness")); err != nil {
+		errs = append(errs, err)
+	} else {
+		info.Swappiness = v
+	}
+	if v, err := readInt(filepath.Join(procDir, "sys/vm/zone_reclaim_mode")); err == nil {
+		info.ZoneReclaimMode = v // only exists with NUMA
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(procDir, "meminfo")); err != nil {
+		errs = append(errs, err)
+	} else {
+		mem := ParseMeminfo(content)
+		info.SwapTotal = mem.SwapTotal
+		info.SwapFree = mem.SwapFree
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(procDir, "vmstat")); err != nil {
+		errs = append(errs, err)
+	} else {
+		vmstat := ParseNumastat(content) // same "name value" format
+		info.SwapIn = vmstat["pswpin"]
+		info.SwapOut = vmstat["pswpout"]
+	}
+
+	return info, errs
+}
+
+// CheckNUMA returns warnings about settings and conditions that commonly cause
+// MySQL to swap or stall, most important first.
+func CheckNUMA(info *NUMAInfo) []string {
+	warnings := []string{}
+
+	if swapUsed := info.SwapTotal - info.SwapFree; swapUsed > 0 && info.SwapOut > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d MB of swap is used (%d pages swapped out since boot)", swapUsed/1024/1024, info.SwapOut))
+	}
+	if info.Swappiness > MAX_SWAPPINESS {
+		warnings = append(warnings, fmt.Sprintf("vm.swappiness is %d; 1 is recommended so the kernel does not swap out the InnoDB buffer pool", info.Swappiness))
+	}
+	if len(info.Nodes) < 2 {
+		return warnings
+	}
+
+	if info.ZoneReclaimMode != 0 {
+		warnings = append(warnings, fmt.Sprintf("vm.zone_reclaim_mode is %d; 0 is recommended so nodes use each other's memory instead of reclaiming (and swapping) their own", info.ZoneReclaimMode))
+	}
+
+	var hit, miss uint64
+	for _, node := range info.Nodes {
+		hit += node.Stats["numa_hit"]
+		miss += node.Stats["numa_miss"]
+	}
+	if hit+miss > 0 {
+		if ratio := float64(miss) / float64(hit+miss); ratio > MAX_NUMA_MISS_RATIO {
+			warnings = append(warnings, fmt.Sprintf("%.1f%% of allocations missed their NUMA node (numa_miss)", ratio*100))
+		}
+	}
+
+	var low, high *NUMANode
+	for i, node := range info.Nodes {
+		if node.MemTotal == 0 {
+			continue
+		}
+		free := float64(node.MemFree) / float64(node.MemTotal)
+		if free < NUMA_LOW_FREE_RATIO && low == nil {
+			low = &info.Nodes[i]
+		} else if free > NUMA_HIGH_FREE_RATIO && high == nil {
+			high = &info.Nodes[i]
+		}
+	}
+	if low != nil && high != nil {
+		warnings = append(warnings, fmt.Sprintf("NUMA memory is imbalanced: node %d has %.1f%% free but node %d has %.1f%% free; consider innodb_numa_interleave or numactl --interleave=all",
+			low.Id, float64(low.MemFree)/float64(low.MemTotal)*100,
+			high.Id, float64(high.MemFree)/float64(high.MemTotal)*100))
+	}
+
+	return warnings
+}
+
+// ParseNodeMeminfo parses /sys/devices/system/node/nodeN/meminfo, returning
+// bytes by name, e.g. MemFree.
+func ParseNodeMeminfo(content []byte) map[string]uint64 {
+	/**
+	 * Node 0 MemTotal:       16307476 kB
+	 * Node 0 MemFree:          432864 kB
+	 * Node 0 MemUsed:        15874612 kB
+	 * Node 0 HugePages_Total:     0
+	 */
+	mem := make(map[string]uint64)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "Node" {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 4 && fields[4] == "kB" {
+			val *= 1024
+		}
+		mem[strings.TrimRight(fields[2], ":")] = val
+	}
+	return mem
+}
+
+// ParseNumastat parses /sys/devices/system/node/nodeN/numastat, or any file
+// of "name value" lines like /proc/vmstat.
+func ParseNumastat(content []byte) map[string]uint64 {
+	stats := make(map[string]uint64)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[fields[0]] = val
+	}
+	return stats
+}
+
+func readInt(file string) (int, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+type nodesById []NUMANode
+
+func (a nodesById) Len() int           { return len(a) }
+func (a nodesById) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a nodesById) Less(i, j int) bool { return a[i].Id < a[j].Id }
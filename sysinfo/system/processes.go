@@ -0,0 +1,334 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_TOP_N     = 10
+	DEFAULT_TOP_SLEEP = 1 // seconds between the two /proc samples
+	MAX_TOP_SLEEP     = 5 // seconds, well within pct.DEFAULT_CMD_DEADLINE
+)
+
+// TopConfig is the optional cmd.Data for the TopProcesses command.
+type TopConfig struct {
+	N     int // processes per list
+	Sleep int // seconds
+}
+
+type Process struct {
+	Pid     int
+	User    string
+	State   string
+	Command string
+	CPU     float64 // percent of one CPU, like top
+	RSS     uint64  // bytes
+	VSZ     uint64  // bytes
+}
+
+type TopProcesses struct {
+	ByCPU    []Process
+	ByMemory []Process
+}
+
+// PidStat is the part of /proc/<pid>/stat that we need.
+type PidStat struct {
+	Pid   int
+	Comm  string
+	State string
+	Ticks uint64 // utime + stime
+	VSZ   uint64 // bytes
+	RSS   uint64 // pages
+}
+
+type Top struct {
+	logger   *pct.Logger
+	ProcDir  string
+	pageSize uint64
+}
+
+func NewTop(logger *pct.Logger) *Top {
+	t := &Top{
+		logger:   logger,
+		ProcDir:  "/proc",
+		pageSize: uint64(os.Getpagesize()),
+	}
+	return t
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (t *Top) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	if pct.UsesSysctl(runtime.GOOS) {
+		return protoCmd.Reply(nil, fmt.Errorf("TopProcesses is not supported on %s: it requires /proc", runtime.GOOS))
+	}
+
+	config := &TopConfig{
+		N:     DEFAULT_TOP_N,
+		Sleep: DEFAULT_TOP_SLEEP,
+	}
+	if protoCmd.Data != nil {
+		if err := json.Unmarshal(protoCmd.Data, config); err != nil {
+			return protoCmd.Reply(nil, fmt.Errorf("Invalid TopProcesses config: %s", err))
+		}
+	}
+	if config.N <= 0 {
+		config.N = DEFAULT_TOP_N
+	}
+	if config.Sleep < 0 {
+		config.Sleep = 0
+	} else if config.Sleep > MAX_TOP_SLEEP {
+		config.Sleep = MAX_TOP_SLEEP
+	}
+
+	top, err := t.Top(config.N, time.Duration(config.Sleep)*time.Second)
+	if err != nil {
+		t.logger.Error(err)
+	}
+	return protoCmd.Reply(top, err)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// Top samples /proc twice, sleep apart, and returns the n processes using the
+// most CPU during that time and the n processes using the most memory.
+func (t *Top) Top(n int, sleep time.Duration) (*TopProcesses, error) {
+	t.logger.Debug("Top:call")
+	defer t.logger.Debug("Top:return")
+
+	prevTotal, nCPU, err := t.cpuTicks()
+	if err != nil {
+		return nil, err
+	}
+	prev := t.procStats()
+
+	time.Sleep(sleep)
+
+	currTotal, _, err := t.cpuTicks()
+	if err != nil {
+		return nil, err
+	}
+	curr := t.procStats()
+
+	// Total CPU ticks for all CPUs, so ticks per CPU is total / nCPU.
+	// Then a process that was on one CPU the whole time is 100%.
+	cpuTicks := float64(currTotal-prevTotal) / float64(nCPU)
+
+	// User and Command need a lookup and a file read per process, so they're
+	// set only for the top n processes, not for the hundreds in /proc.
+	procs := []Process{}
+	for pid, stat := range curr {
+		p := Process{
+			Pid:   pid,
+			State: stat.State,
+			RSS:   stat.RSS * t.pageSize,
+			VSZ:   stat.VSZ,
+		}
+		if prevStat, ok := prev[pid]; ok && cpuTicks > 0 && stat.Ticks >= prevStat.Ticks {
+			p.CPU = float64(stat.Ticks-prevStat.Ticks) * 100 / cpuTicks
+		}
+		procs = append(procs, p)
+	}
+
+	top := &TopProcesses{}
+
+	sort.Sort(byCPU(procs))
+	top.ByCPU = append([]Process{}, procs[0:min(n, len(procs))]...)
+
+	sort.Sort(byMemory(procs))
+	top.ByMemory = append([]Process{}, procs[0:min(n, len(procs))]...)
+
+	users := make(map[int]string)
+	commands := make(map[int]string)
+	for _, list := range [][]Process{top.ByCPU, top.ByMemory} {
+		for i := range list {
+			pid := list[i].Pid
+			if _, ok := commands[pid]; !ok {
+				users[pid] = t.user(pid)
+				commands[pid] = t.command(pid, curr[pid].Comm)
+			}
+			list[i].User = users[pid]
+			list[i].Command = commands[pid]
+		}
+	}
+
+	return top, nil
+}
+
+// cpuTicks returns the sum of all fields of the "cpu" line in /proc/stat and
+// the number of CPUs (cpuN lines).
+func (t *Top) cpuTicks() (uint64, int, error) {
+	content, err := ioutil.ReadFile(t.ProcDir + "/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	var total uint64
+	nCPU := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			nCPU++
+			continue
+		}
+		for _, val := range fields[1:] {
+			n, _ := strconv.ParseUint(val, 10, 64)
+			total += n
+		}
+	}
+	if nCPU == 0 {
+		nCPU = 1
+	}
+	return total, nCPU, nil
+}
+
+func (t *Top) procStats() map[int]PidStat {
+	stats := make(map[int]PidStat)
+	dirs, _ := filepath.Glob(t.ProcDir + "/[0-9]*")
+	for _, dir := range dirs {
+		// Processes come and go, so errors are expected and ignored.
+		content, err := ioutil.ReadFile(dir + "/stat")
+		if err != nil {
+			continue
+		}
+		stat, err := ParseProcPidStat(content)
+		if err != nil {
+			continue
+		}
+		stats[stat.Pid] = stat
+	}
+	return stats
+}
+
+func ParseProcPidStat(content []byte) (PidStat, error) {
+	/**
+	 * 1234 (mysqld) S 1 1234 1234 0 -1 4202752 ... utime stime ... vsize rss ...
+	 *
+	 * The command (field 2) is in parens and can contain spaces and parens,
+	 * so the fields after it are split from the last ')'.  After the command:
+	 * state is field 3, utime 14, stime 15, vsize 23, and rss 24 (pages).
+	 * http://man7.org/linux/man-pages/man5/proc.5.html
+	 */
+	s := string(content)
+	start := strings.Index(s, "(")
+	end := strings.LastIndex(s, ")")
+	if start < 0 || end < start {
+		return PidStat{}, fmt.Errorf("Invalid /proc/<pid>/stat: %s", s)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(s[0:start]))
+	if err != nil {
+		return PidStat{}, fmt.Errorf("Invalid /proc/<pid>/stat pid: %s", err)
+	}
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 22 {
+		return PidStat{}, fmt.Errorf("Invalid /proc/<pid>/stat: expected at least 24 fields, got %d", len(fields)+2)
+	}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	vsz, _ := strconv.ParseUint(fields[20], 10, 64)
+	rss, _ := strconv.ParseInt(fields[21], 10, 64)
+	if rss < 0 {
+		rss = 0
+	}
+	stat := PidStat{
+		Pid:   pid,
+		Comm:  s[start+1 : end],
+		State: fields[0],
+		Ticks: utime + stime,
+		VSZ:   vsz,
+		RSS:   uint64(rss),
+	}
+	return stat, nil
+}
+
+func (t *Top) command(pid int, comm string) string {
+	// Kernel threads have no cmdline, so use [comm] like ps.
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/cmdline", t.ProcDir, pid))
+	if err != nil || len(content) == 0 {
+		return "[" + comm + "]"
+	}
+	return strings.TrimSpace(strings.Replace(string(content), "\x00", " ", -1))
+}
+
+func (t *Top) user(pid int) string {
+	fi, err := os.Stat(fmt.Sprintf("%s/%d", t.ProcDir, pid))
+	if err != nil {
+		return ""
+	}
+	uid := fileUid(fi)
+	if u, err := user.LookupId(uid); err == nil && uid != "" {
+		return u.Username
+	}
+	return uid
+}
+
+func fileUid(fi os.FileInfo) string {
+	if uid, _, ok := pct.FileOwner(fi); ok {
+		return strconv.Itoa(uid)
+	}
+	return ""
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type byCPU []Process
+
+func (p byCPU) Len() int      { return len(p) }
+func (p byCPU) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byCPU) Less(i, j int) bool {
+	if p[i].CPU == p[j].CPU {
+		return p[i].RSS > p[j].RSS
+	}
+	return p[i].CPU > p[j].CPU
+}
+
+type byMemory []Process
+
+func (p byMemory) Len() int      { return len(p) }
+func (p byMemory) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byMemory) Less(i, j int) bool {
+	if p[i].RSS == p[j].RSS {
+		return p[i].CPU > p[j].CPU
+	}
+	return p[i].RSS > p[j].RSS
+}
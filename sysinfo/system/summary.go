@@ -0,0 +1,533 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Host is a structured overview of the host, roughly what pt-summary reports
+// but gathered natively so it works when Percona Toolkit isn't installed.
+type Host struct {
+	Hostname       string
+	Platform       string
+	Kernel         string
+	Virtualization string
+	Cgroup         *pct.Cgroup // nil if not in a container and no limits
+	CPU            CPU
+	Memory         Memory
+	RAID           RAID
+	Filesystems    []Filesystem
+	Network        []Interface
+}
+
+type CPU struct {
+	Model   string
+	Sockets int
+	Cores   int
+	Threads int
+	MHz     float64
+}
+
+// Memory values are bytes.
+type Memory struct {
+	Total     uint64
+	Free      uint64
+	Buffers   uint64
+	Cached    uint64
+	SwapTotal uint64
+	SwapFree  uint64
+}
+
+type RAID struct {
+	Controllers []string  // hardware controllers from /proc/scsi/scsi
+	Arrays      []MDArray // software (md) arrays from /proc/mdstat
+}
+
+type MDArray struct {
+	Name    string
+	State   string
+	Level   string
+	Devices []string
+}
+
+// Filesystem sizes are bytes.
+type Filesystem struct {
+	Device     string
+	MountPoint string
+	Type       string
+	Options    string
+	Size       uint64
+	Used       uint64
+	Free       uint64
+}
+
+type Interface struct {
+	Name         string
+	MTU          int
+	HardwareAddr string
+	Flags        string
+	Addrs        []string
+}
+
+type Summary struct {
+	logger *pct.Logger
+	// Root dirs are vars so tests can use sample files.
+	ProcDir string
+	SysDir  string
+}
+
+func NewSummary(logger *pct.Logger) *Summary {
+	s := &Summary{
+		logger:  logger,
+		ProcDir: "/proc",
+		SysDir:  "/sys",
+	}
+	return s
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+func (s *Summary) Handle(protoCmd *proto.Cmd) *proto.Reply {
+	// Partial info is better than none, so errors are only logged.
+	host, errs := s.Host()
+	for _, err := range errs {
+		s.logger.Warn(err)
+	}
+	return protoCmd.Reply(host)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// Host collects every section it can, returning errors for those it cannot.
+func (s *Summary) Host() (*Host, []error) {
+	s.logger.Debug("Host:call")
+	defer s.logger.Debug("Host:return")
+
+	host := &Host{
+		Platform: runtime.GOOS,
+	}
+	errs := []error{}
+
+	if hostname, err := os.Hostname(); err != nil {
+		errs = append(errs, err)
+	} else {
+		host.Hostname = hostname
+	}
+
+	// FreeBSD and Mac OS X don't have /proc.
+	if pct.UsesSysctl(runtime.GOOS) {
+		errs = append(errs, s.sysctlHost(host)...)
+	} else {
+		errs = append(errs, s.procHost(host)...)
+	}
+
+	if ifaces, err := Interfaces(); err != nil {
+		errs = append(errs, err)
+	} else {
+		host.Network = ifaces
+	}
+
+	return host, errs
+}
+
+func (s *Summary) procHost(host *Host) []error {
+	errs := []error{}
+
+	if content, err := ioutil.ReadFile(s.ProcDir + "/sys/kernel/osrelease"); err != nil {
+		errs = append(errs, err)
+	} else {
+		host.Kernel = strings.TrimSpace(string(content))
+	}
+
+	cpuinfo, err := ioutil.ReadFile(s.ProcDir + "/cpuinfo")
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		host.CPU = ParseCPUInfo(cpuinfo)
+	}
+
+	if content, err := ioutil.ReadFile(s.ProcDir + "/meminfo"); err != nil {
+		errs = append(errs, err)
+	} else {
+		host.Memory = ParseMeminfo(content)
+	}
+
+	// Both files are optional: they don't exist on most hosts.
+	if content, err := ioutil.ReadFile(s.ProcDir + "/scsi/scsi"); err == nil {
+		host.RAID.Controllers = ParseSCSI(content)
+	}
+	if content, err := ioutil.ReadFile(s.ProcDir + "/mdstat"); err == nil {
+		host.RAID.Arrays = ParseMdstat(content)
+	}
+
+	productName, _ := ioutil.ReadFile(s.SysDir + "/class/dmi/id/product_name")
+	host.Virtualization = DetectVirtualization(cpuinfo, productName)
+	if _, err := os.Stat(s.ProcDir + "/xen"); err == nil && host.Virtualization == "Unknown hypervisor" {
+		host.Virtualization = "Xen"
+	}
+
+	// In a container, host-level CPU and memory numbers are misleading, so
+	// report the real limits too.
+	if cg := pct.GetCgroup(); cg.Limited() {
+		host.Cgroup = cg
+	}
+
+	if content, err := ioutil.ReadFile(s.ProcDir + "/mounts"); err != nil {
+		errs = append(errs, err)
+	} else {
+		host.Filesystems = ParseMounts(content)
+		statFilesystems(host.Filesystems)
+	}
+
+	return errs
+}
+
+func (s *Summary) sysctlHost(host *Host) []error {
+	errs := []error{}
+
+	values, err := pct.Sysctl(HostSysctlNames(runtime.GOOS)...)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		SysctlHost(host, runtime.GOOS, values)
+	}
+
+	if out, err := exec.Command("mount").Output(); err != nil {
+		errs = append(errs, err)
+	} else {
+		host.Filesystems = ParseMountOutput(out)
+		statFilesystems(host.Filesystems)
+	}
+
+	return errs
+}
+
+// statFilesystems sets the sizes of the filesystems.
+func statFilesystems(filesystems []Filesystem) {
+	for i := range filesystems {
+		fs := &filesystems[i]
+		size, free, used, err := pct.FsSpace(fs.MountPoint)
+		if err != nil {
+			continue
+		}
+		fs.Size, fs.Free, fs.Used = size, free, used
+	}
+}
+
+func ParseCPUInfo(content []byte) CPU {
+	/**
+	 * processor	: 0
+	 * vendor_id	: GenuineIntel
+	 * model name	: Intel(R) Core(TM) i7-3770 CPU @ 3.40GHz
+	 * cpu MHz		: 1600.000
+	 * physical id	: 0
+	 * core id		: 0
+	 * ...
+	 */
+	cpu := CPU{}
+	sockets := make(map[string]bool)
+	cores := make(map[string]bool)
+	physicalId := ""
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		val := strings.TrimSpace(kv[1])
+		switch key {
+		case "processor":
+			cpu.Threads++
+		case "model name":
+			cpu.Model = strings.Join(strings.Fields(val), " ")
+		case "cpu MHz":
+			cpu.MHz, _ = strconv.ParseFloat(val, 64)
+		case "physical id":
+			physicalId = val
+			sockets[val] = true
+		case "core id":
+			cores[physicalId+":"+val] = true
+		}
+	}
+	cpu.Sockets = len(sockets)
+	cpu.Cores = len(cores)
+
+	// Some VMs and non-x86 CPUs don't report physical and core ids.
+	if cpu.Sockets == 0 && cpu.Threads > 0 {
+		cpu.Sockets = 1
+	}
+	if cpu.Cores == 0 {
+		cpu.Cores = cpu.Threads
+	}
+
+	return cpu
+}
+
+func ParseMeminfo(content []byte) Memory {
+	mem := Memory{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			val *= 1024
+		}
+		switch strings.TrimRight(fields[0], ":") {
+		case "MemTotal":
+			mem.Total = val
+		case "MemFree":
+			mem.Free = val
+		case "Buffers":
+			mem.Buffers = val
+		case "Cached":
+			mem.Cached = val
+		case "SwapTotal":
+			mem.SwapTotal = val
+		case "SwapFree":
+			mem.SwapFree = val
+		}
+	}
+	return mem
+}
+
+// ParseMounts returns real (block device backed) filesystems from /proc/mounts.
+func ParseMounts(content []byte) []Filesystem {
+	/**
+	 * /dev/sda1 / ext4 rw,relatime,errors=remount-ro,data=ordered 0 0
+	 * proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+	 */
+	filesystems := []Filesystem{}
+	seen := make(map[string]bool)
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		if !strings.HasPrefix(fields[0], "/") || seen[fields[1]] {
+			continue
+		}
+		seen[fields[1]] = true
+		fs := Filesystem{
+			Device:     fields[0],
+			MountPoint: fields[1],
+			Type:       fields[2],
+			Options:    fields[3],
+		}
+		filesystems = append(filesystems, fs)
+	}
+	return filesystems
+}
+
+func ParseMdstat(content []byte) []MDArray {
+	/**
+	 * Personalities : [raid1]
+	 * md0 : active raid1 sdb1[1] sda1[0]
+	 *       1048512 blocks [2/2] [UU]
+	 */
+	arrays := []MDArray{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "md") || fields[1] != ":" {
+			continue
+		}
+		md := MDArray{
+			Name:    fields[0],
+			State:   fields[2],
+			Devices: []string{},
+		}
+		devices := fields[3:]
+		if strings.HasPrefix(fields[3], "raid") || fields[3] == "linear" {
+			md.Level = fields[3]
+			devices = fields[4:]
+		}
+		for _, dev := range devices {
+			if i := strings.Index(dev, "["); i > 0 {
+				dev = dev[0:i]
+			}
+			md.Devices = append(md.Devices, dev)
+		}
+		arrays = append(arrays, md)
+	}
+	return arrays
+}
+
+// ParseSCSI returns the vendor and model of RAID controllers in /proc/scsi/scsi.
+func ParseSCSI(content []byte) []string {
+	/**
+	 * Host: scsi0 Channel: 02 Id: 00 Lun: 00
+	 *   Vendor: LSI      Model: MegaRAID SAS RMB Rev: 1.40
+	 *   Type:   Direct-Access                    ANSI  SCSI revision: 05
+	 */
+	controllers := []string{}
+	seen := make(map[string]bool)
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "Vendor:") {
+			continue
+		}
+		vendor := strings.TrimSpace(line[len("Vendor:"):])
+		model := ""
+		if i := strings.Index(vendor, "Model:"); i > -1 {
+			model = strings.TrimSpace(vendor[i+len("Model:"):])
+			vendor = strings.TrimSpace(vendor[0:i])
+		}
+		if i := strings.Index(model, "Rev:"); i > -1 {
+			model = strings.TrimSpace(model[0:i])
+		}
+		name := strings.Join(strings.Fields(vendor+" "+model), " ")
+		if !isRAIDController(name) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		controllers = append(controllers, name)
+	}
+	return controllers
+}
+
+// RAID controller vendors and product lines, as lowercase words.  A name
+// matches if it has the words in order, or a word is one of these followed by
+// a model number, e.g. PERC6 or LSI1068.
+var raidVendors = [][]string{
+	{"megaraid"},
+	{"lsi"},
+	{"lsilogic"},
+	{"perc"},
+	{"adaptec"},
+	{"3ware"},
+	{"areca"},
+	{"smart", "array"},
+	{"hp", "logical"},
+	{"ips"},
+}
+
+func isRAIDController(name string) bool {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := range words {
+		for _, v := range raidVendors {
+			if i+len(v) > len(words) {
+				continue
+			}
+			match := true
+			for j, w := range v {
+				if !raidWord(words[i+j], w) {
+					match = false
+					break
+				}
+			}
+			if match {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// raidWord returns true if word is w or w followed by a model number.
+func raidWord(word, w string) bool {
+	if !strings.HasPrefix(word, w) {
+		return false
+	}
+	return len(word) == len(w) || unicode.IsDigit(rune(word[len(w)]))
+}
+
+// DetectVirtualization returns the hypervisor name, or "" if the host
+// appears to be bare metal.  productName is /sys/class/dmi/id/product_name
+// which can be empty if not readable (it's usually root only).
+func DetectVirtualization(cpuinfo, productName []byte) string {
+	product := strings.ToLower(strings.TrimSpace(string(productName)))
+	switch {
+	case strings.Contains(product, "vmware"):
+		return "VMWare"
+	case strings.Contains(product, "virtualbox"):
+		return "VirtualBox"
+	case strings.Contains(product, "kvm"), strings.Contains(product, "bochs"):
+		return "KVM"
+	case strings.Contains(product, "hvm domu"):
+		return "Xen HVM"
+	case strings.Contains(product, "virtual machine"):
+		return "Microsoft Hyper-V"
+	case strings.Contains(product, "openstack"):
+		return "OpenStack"
+	}
+	// The hypervisor flag is set by all modern hypervisors, but it doesn't
+	// say which.
+	lines := strings.Split(string(cpuinfo), "\n")
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "flags") {
+			continue
+		}
+		for _, flag := range strings.Fields(line) {
+			if flag == "hypervisor" {
+				return "Unknown hypervisor"
+			}
+		}
+		break
+	}
+	return ""
+}
+
+func Interfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("net.Interfaces: %s", err)
+	}
+	interfaces := []Interface{}
+	for _, iface := range ifaces {
+		i := Interface{
+			Name:         iface.Name,
+			MTU:          iface.MTU,
+			HardwareAddr: iface.HardwareAddr.String(),
+			Flags:        iface.Flags.String(),
+			Addrs:        []string{},
+		}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				i.Addrs = append(i.Addrs, addr.String())
+			}
+		}
+		interfaces = append(interfaces, i)
+	}
+	return interfaces, nil
+}
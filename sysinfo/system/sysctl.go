@@ -0,0 +1,177 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Sysctl names for memory on FreeBSD and Mac OS X, which don't have /proc.
+// The mm system monitor uses them too.
+var (
+	FreeBSDMemSysctlNames = []string{
+		"hw.pagesize",
+		"hw.physmem",
+		"hw.realmem",
+		"vm.stats.vm.v_free_count",
+		"vm.stats.vm.v_cache_count",
+		"vm.swap_total",
+	}
+	DarwinMemSysctlNames = []string{
+		"hw.pagesize",
+		"hw.memsize",
+		"vm.page_free_count",
+		"vm.swapusage",
+	}
+)
+
+// HostSysctlNames returns the sysctl names that SysctlHost uses on the OS.
+func HostSysctlNames(goos string) []string {
+	names := []string{
+		"kern.osrelease",
+		"hw.ncpu",
+	}
+	switch goos {
+	case "freebsd":
+		names = append(names, "hw.model", "hw.clockrate", "kern.vm_guest")
+		names = append(names, FreeBSDMemSysctlNames...)
+	case "darwin":
+		names = append(names, "machdep.cpu.brand_string", "hw.packages", "hw.physicalcpu", "hw.logicalcpu", "hw.cpufrequency")
+		names = append(names, DarwinMemSysctlNames...)
+	}
+	return names
+}
+
+// SysctlMemTotal returns total memory in bytes.  On Mac OS X it's hw.memsize
+// because hw.physmem is a 32-bit int, so it's capped at 2GB.  On FreeBSD it's
+// hw.physmem, or hw.realmem if the kernel doesn't have it.
+func SysctlMemTotal(goos string, values map[string]string) uint64 {
+	switch goos {
+	case "darwin":
+		return sysctlUint(values, "hw.memsize")
+	case "freebsd":
+		if total := sysctlUint(values, "hw.physmem"); total > 0 {
+			return total
+		}
+		return sysctlUint(values, "hw.realmem")
+	}
+	return 0
+}
+
+// kern.vm_guest values => DetectVirtualization names.
+var vmGuests = map[string]string{
+	"kvm":     "KVM",
+	"xen":     "Xen",
+	"vmware":  "VMWare",
+	"hv":      "Microsoft Hyper-V",
+	"bhyve":   "bhyve",
+	"generic": "Unknown hypervisor",
+}
+
+// SysctlHost sets the Host fields that FreeBSD and Mac OS X report with sysctl,
+// given the values of HostSysctlNames(goos).
+func SysctlHost(host *Host, goos string, values map[string]string) {
+	host.Kernel = values["kern.osrelease"]
+	host.Virtualization = vmGuests[values["kern.vm_guest"]]
+
+	// On Mac OS X hw.model is the machine, e.g. MacBookPro11,3.
+	if model, ok := values["machdep.cpu.brand_string"]; ok {
+		host.CPU.Model = model
+	} else {
+		host.CPU.Model = values["hw.model"]
+	}
+	host.CPU.Model = strings.Join(strings.Fields(host.CPU.Model), " ")
+	host.CPU.Sockets = sysctlInt(values, "hw.packages")
+	host.CPU.Cores = sysctlInt(values, "hw.physicalcpu")
+	host.CPU.Threads = sysctlInt(values, "hw.logicalcpu")
+	if host.CPU.Threads == 0 {
+		host.CPU.Threads = sysctlInt(values, "hw.ncpu")
+	}
+	// FreeBSD doesn't report sockets or cores without parsing dmesg.boot.
+	if host.CPU.Sockets == 0 && host.CPU.Threads > 0 {
+		host.CPU.Sockets = 1
+	}
+	if host.CPU.Cores == 0 {
+		host.CPU.Cores = host.CPU.Threads
+	}
+	if mhz, ok := values["hw.clockrate"]; ok {
+		host.CPU.MHz, _ = strconv.ParseFloat(mhz, 64)
+	} else if hz, ok := values["hw.cpufrequency"]; ok {
+		f, _ := strconv.ParseFloat(hz, 64)
+		host.CPU.MHz = f / 1000000
+	}
+
+	pageSize := sysctlUint(values, "hw.pagesize")
+	host.Memory.Total = SysctlMemTotal(goos, values)
+	host.Memory.Free = (sysctlUint(values, "vm.stats.vm.v_free_count") + sysctlUint(values, "vm.page_free_count")) * pageSize
+	host.Memory.Cached = sysctlUint(values, "vm.stats.vm.v_cache_count") * pageSize
+	host.Memory.SwapTotal = sysctlUint(values, "vm.swap_total")
+
+	// Mac OS X: total = 2048.00M  used = 1024.50M  free = 1023.50M  (encrypted)
+	if swap := strings.Fields(values["vm.swapusage"]); len(swap) >= 9 {
+		host.Memory.SwapTotal = megToBytes(swap[2])
+		host.Memory.SwapFree = megToBytes(swap[8])
+	}
+}
+
+// ParseMountOutput returns real filesystems from mount(8) output on FreeBSD
+// and Mac OS X.  ZFS datasets aren't devices, but they're real filesystems.
+func ParseMountOutput(content []byte) []Filesystem {
+	/**
+	 * /dev/ada0p2 on / (ufs, local, journaled soft-updates)
+	 * devfs on /dev (devfs, local, multilabel)
+	 * zroot/data on /data (zfs, local, nfsv4acls)
+	 */
+	filesystems := []Filesystem{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		on := strings.Index(line, " on ")
+		opts := strings.LastIndex(line, " (")
+		if on < 0 || opts < on || !strings.HasSuffix(line, ")") {
+			continue
+		}
+		fs := Filesystem{
+			Device:     line[0:on],
+			MountPoint: line[on+len(" on ") : opts],
+		}
+		options := strings.Split(line[opts+2:len(line)-1], ", ")
+		fs.Type = options[0]
+		fs.Options = strings.Join(options[1:], ",")
+		if !strings.HasPrefix(fs.Device, "/dev/") && fs.Type != "zfs" {
+			continue
+		}
+		filesystems = append(filesystems, fs)
+	}
+	return filesystems
+}
+
+func sysctlInt(values map[string]string, name string) int {
+	n, _ := strconv.Atoi(values[name])
+	return n
+}
+
+func sysctlUint(values map[string]string, name string) uint64 {
+	n, _ := strconv.ParseUint(values[name], 10, 64)
+	return n
+}
+
+func megToBytes(s string) uint64 {
+	f, _ := strconv.ParseFloat(strings.TrimSuffix(s, "M"), 64)
+	return uint64(f * 1024 * 1024)
+}
@@ -32,6 +32,8 @@ const (
 type System struct {
 	CmdName string
 	logger  *pct.Logger
+	// --
+	cmd.Limits
 }
 
 func NewSystem(logger *pct.Logger) *System {
@@ -50,6 +52,7 @@ func (s *System) Handle(protoCmd *proto.Cmd) *proto.Reply {
 		"--sleep", PT_SLEEP_SECONDS,
 	}
 	ptSummary := cmd.NewRealCmd(s.CmdName, args...)
+	ptSummary.Timeout, ptSummary.MaxOutput = s.GetLimits()
 	output, err := ptSummary.Run()
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("%s: %s", s.CmdName, err))
@@ -22,6 +22,7 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
 	"github.com/percona/percona-agent/sysinfo/system"
+	"github.com/percona/percona-agent/test"
 	. "github.com/percona/percona-agent/test/checkers"
 	"github.com/percona/percona-agent/test/mock"
 	. "gopkg.in/check.v1"
@@ -34,6 +35,8 @@ import (
 // Hook up gocheck into the "go test" runner.
 func Test(t *testing.T) { TestingT(t) }
 
+var sample = test.RootDir + "/sysinfo"
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager test suite
 /////////////////////////////////////////////////////////////////////////////
@@ -144,3 +147,426 @@ func (s *TestSuite) TestExecutableNotFound(t *C) {
 	// changing this string means breaking contract between agent/api and web-app
 	t.Assert(gotReply.Error, Equals, "Executable file not found in $PATH")
 }
+
+func (s *TestSuite) TestParseCPUInfo(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/cpuinfo001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseCPUInfo(content)
+	expect := system.CPU{
+		Model:   "Intel(R) Xeon(R) CPU E5-2620 0 @ 2.00GHz",
+		Sockets: 2,
+		Cores:   4,
+		Threads: 8,
+		MHz:     1200,
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestParseMeminfo(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/meminfo001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseMeminfo(content)
+	expect := system.Memory{
+		Total:     8046892 * 1024,
+		Free:      5273644 * 1024,
+		Buffers:   300684 * 1024,
+		Cached:    1370112 * 1024,
+		SwapTotal: 4194300 * 1024,
+		SwapFree:  4194300 * 1024,
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestParseMounts(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/mounts001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseMounts(content)
+	expect := []system.Filesystem{
+		{Device: "/dev/mapper/vg0-root", MountPoint: "/", Type: "ext4", Options: "rw,relatime,errors=remount-ro,data=ordered"},
+		{Device: "/dev/sda1", MountPoint: "/boot", Type: "ext2", Options: "rw,relatime"},
+		{Device: "/dev/md0", MountPoint: "/var/lib/mysql", Type: "xfs", Options: "rw,noatime,nobarrier,attr2,inode64,noquota"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestParseRAID(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/mdstat001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseMdstat(content)
+	expect := []system.MDArray{
+		{Name: "md0", State: "active", Level: "raid10", Devices: []string{"sdd1", "sdc1", "sdb1", "sda1"}},
+		{Name: "md1", State: "active", Level: "raid1", Devices: []string{"sdf1", "sde1"}},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	content, err = ioutil.ReadFile(sample + "/proc/scsi001.txt")
+	t.Assert(err, IsNil)
+
+	controllers := system.ParseSCSI(content)
+	t.Check(controllers, DeepEquals, []string{"LSI MegaRAID SAS RMB"})
+
+	// Vendors match whole words or model numbers, not any name with the
+	// same letters, like PHILIPS (ips) or Percussion (perc).
+	content = []byte(`Attached devices:
+Host: scsi0 Channel: 00 Id: 00 Lun: 00
+  Vendor: DELL     Model: PERC H710P       Rev: 3.13
+Host: scsi1 Channel: 00 Id: 00 Lun: 00
+  Vendor: LSILOGIC Model: 1068             Rev: 1.00
+Host: scsi2 Channel: 00 Id: 00 Lun: 00
+  Vendor: HP       Model: LOGICAL VOLUME   Rev: 5.70
+Host: scsi3 Channel: 00 Id: 00 Lun: 00
+  Vendor: DELL     Model: PERC6/i          Rev: 1.22
+Host: scsi4 Channel: 00 Id: 00 Lun: 00
+  Vendor: PHILIPS  Model: SSD 128G         Rev: 1.00
+Host: scsi5 Channel: 00 Id: 00 Lun: 00
+  Vendor: ATA      Model: Percussion 2     Rev: 1.00
+Host: scsi6 Channel: 00 Id: 00 Lun: 00
+  Vendor: Pulsio   Model: Flash Disk       Rev: 1.00
+`)
+	controllers = system.ParseSCSI(content)
+	t.Check(controllers, DeepEquals, []string{"DELL PERC H710P", "LSILOGIC 1068", "HP LOGICAL VOLUME", "DELL PERC6/i"})
+}
+
+func (s *TestSuite) TestDetectVirtualization(t *C) {
+	cpuinfo, err := ioutil.ReadFile(sample + "/proc/cpuinfo001.txt")
+	t.Assert(err, IsNil)
+
+	t.Check(system.DetectVirtualization(cpuinfo, nil), Equals, "")
+	t.Check(system.DetectVirtualization(cpuinfo, []byte("VMware Virtual Platform\n")), Equals, "VMWare")
+	t.Check(system.DetectVirtualization([]byte("flags : fpu hypervisor lm\n"), nil), Equals, "Unknown hypervisor")
+}
+
+func (s *TestSuite) TestSummary(t *C) {
+	service := system.NewSummary(s.logger)
+
+	cmd := &proto.Cmd{
+		Service: "sysinfo",
+		Cmd:     "HostSummary",
+	}
+
+	gotReply := service.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Assert(gotReply.Error, Equals, "")
+
+	host := &system.Host{}
+	err := json.Unmarshal(gotReply.Data, host)
+	t.Assert(err, IsNil)
+	t.Check(host.Kernel, Not(Equals), "")
+	t.Check(host.CPU.Threads > 0, Equals, true)
+	t.Check(host.Memory.Total > 0, Equals, true)
+}
+
+func (s *TestSuite) TestParseProcPidStat(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/pid-stat001.txt")
+	t.Assert(err, IsNil)
+
+	got, err := system.ParseProcPidStat(content)
+	t.Assert(err, IsNil)
+	expect := system.PidStat{
+		Pid:   2046,
+		Comm:  "mysqld (main)",
+		State: "S",
+		Ticks: 4325 + 1183,
+		VSZ:   1322037248,
+		RSS:   114718,
+	}
+	t.Check(got, DeepEquals, expect)
+
+	_, err = system.ParseProcPidStat([]byte("2046 (mysqld) S 1"))
+	t.Check(err, NotNil)
+}
+
+func (s *TestSuite) TestTopProcesses(t *C) {
+	service := system.NewTop(s.logger)
+
+	cmd := &proto.Cmd{
+		Service: "sysinfo",
+		Cmd:     "TopProcesses",
+		Data:    []byte(`{"N":3,"Sleep":0}`),
+	}
+
+	gotReply := service.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Assert(gotReply.Error, Equals, "")
+
+	top := &system.TopProcesses{}
+	err := json.Unmarshal(gotReply.Data, top)
+	t.Assert(err, IsNil)
+	t.Check(len(top.ByCPU) > 0 && len(top.ByCPU) <= 3, Equals, true)
+	t.Check(len(top.ByMemory) > 0 && len(top.ByMemory) <= 3, Equals, true)
+	for i := 1; i < len(top.ByMemory); i++ {
+		t.Check(top.ByMemory[i-1].RSS >= top.ByMemory[i].RSS, Equals, true)
+	}
+	for _, p := range append(top.ByCPU, top.ByMemory...) {
+		t.Check(p.Command, Not(Equals), "", Commentf("pid %d", p.Pid))
+	}
+}
+
+func (s *TestSuite) TestFilterKernelMessages(t *C) {
+	content, err := ioutil.ReadFile(sample + "/dmesg001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.FilterKernelMessages(string(content), nil, 0)
+	expect := []system.KernelMessage{
+		{Uptime: 8512.120043, Category: system.KMSG_HUNG, Message: "INFO: task mysqld:2046 blocked for more than 120 seconds."},
+		{Uptime: 8512.12005, Category: system.KMSG_HUNG, Message: `"echo 0 > /proc/sys/kernel/hung_task_timeout_secs" disables this message.`},
+		{Uptime: 9001.334812, Category: system.KMSG_IO_ERROR, Message: "end_request: I/O error, dev sdb, sector 1953519488"},
+		{Uptime: 9001.33499, Category: system.KMSG_IO_ERROR, Message: "Buffer I/O error on device sdb1, logical block 244189680"},
+		{Uptime: 12045.551201, Category: system.KMSG_OOM, Message: "mysqld invoked oom-killer: gfp_mask=0x201da, order=0, oom_score_adj=0"},
+		{Uptime: 12045.551433, Category: system.KMSG_OOM, Message: "Out of memory: Kill process 2046 (mysqld) score 912 or sacrifice child"},
+		{Uptime: 12045.552001, Category: system.KMSG_OOM, Message: "Killed process 2046 (mysqld) total-vm:12910720kB, anon-rss:7604224kB, file-rss:0kB"},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	// Filter by category and limit to most recent.
+	got = system.FilterKernelMessages(string(content), []string{system.KMSG_OOM}, 2)
+	t.Check(got, DeepEquals, expect[5:])
+}
+
+func (s *TestSuite) TestDmesgExecutableNotFound(t *C) {
+	service := system.NewDmesg(s.logger)
+	service.CmdName = "unknown-executable"
+
+	cmd := &proto.Cmd{
+		Service: "sysinfo",
+		Cmd:     "KernelLog",
+	}
+
+	gotReply := service.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Assert(gotReply.Error, Equals, "Executable file not found in $PATH")
+}
+
+func (s *TestSuite) TestParseSmartctlScan(t *C) {
+	content, err := ioutil.ReadFile(sample + "/health/smartctl-scan001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseSmartctlScan(string(content))
+	expect := [][]string{
+		{"/dev/sda", "-d", "scsi"},
+		{"/dev/bus/0", "-d", "megaraid,0"},
+		{"/dev/bus/0", "-d", "megaraid,1"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestParseSmartctl(t *C) {
+	content, err := ioutil.ReadFile(sample + "/health/smartctl001.txt")
+	t.Assert(err, IsNil)
+	got := system.ParseSmartctl(string(content))
+	expect := system.Disk{
+		Model:  "INTEL SSDSC2BB480G4",
+		Serial: "BTWL4123456789",
+		Health: system.HEALTH_OK,
+		Attributes: map[string]int64{
+			"Reallocated_Sector_Ct":  0,
+			"Reported_Uncorrect":     0,
+			"Current_Pending_Sector": 0,
+		},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	// Self-assessment passed but sectors are being reallocated.
+	content, err = ioutil.ReadFile(sample + "/health/smartctl002.txt")
+	t.Assert(err, IsNil)
+	got = system.ParseSmartctl(string(content))
+	t.Check(got.Health, Equals, system.HEALTH_DEGRADED)
+	t.Check(got.Attributes["Reallocated_Sector_Ct"], Equals, int64(264))
+	t.Check(got.Attributes["Offline_Uncorrectable"], Equals, int64(8))
+
+	// SAS disk
+	content, err = ioutil.ReadFile(sample + "/health/smartctl003.txt")
+	t.Assert(err, IsNil)
+	got = system.ParseSmartctl(string(content))
+	t.Check(got.Model, Equals, "ST300MM0006")
+	t.Check(got.Serial, Equals, "S0K1XXXX")
+	t.Check(got.Health, Equals, system.HEALTH_FAILED)
+
+	got = system.ParseSmartctl("")
+	t.Check(got.Health, Equals, system.HEALTH_UNKNOWN)
+}
+
+func (s *TestSuite) TestParseMegaCLI(t *C) {
+	content, err := ioutil.ReadFile(sample + "/health/megacli001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseMegaCLI(string(content))
+	expect := []system.Array{
+		{Controller: "megacli", Name: "a0/vd0", Level: "RAID 1", Size: "278.875 GB", State: "Optimal", Health: system.HEALTH_OK},
+		{Controller: "megacli", Name: "a0/vd1", Level: "RAID 10", Size: "1.089 TB", State: "Partially Degraded", Health: system.HEALTH_DEGRADED},
+		{Controller: "megacli", Name: "a0/vd2", Level: "RAID 0", Size: "557.75 GB", State: "Offline", Health: system.HEALTH_FAILED},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	// Truncated or odd output doesn't crash the agent.
+	got = system.ParseMegaCLI("Adapter \nVirtual Drive:\nState: Optimal\n")
+	t.Check(got, HasLen, 0)
+}
+
+func (s *TestSuite) TestParseHPSSACLI(t *C) {
+	content, err := ioutil.ReadFile(sample + "/health/hpssacli001.txt")
+	t.Assert(err, IsNil)
+
+	got := system.ParseHPSSACLI(string(content))
+	expect := []system.Array{
+		{Controller: "hpssacli", Name: "slot0/ld1", Level: "RAID 1", Size: "279.4 GB", State: "OK", Health: system.HEALTH_OK},
+		{Controller: "hpssacli", Name: "slot0/ld2", Level: "RAID 1+0", Size: "838.2 GB", State: "Recovering, 25% complete", Health: system.HEALTH_DEGRADED},
+	}
+	t.Check(got, DeepEquals, expect)
+
+	// Truncated or odd output doesn't crash the agent.
+	got = system.ParseHPSSACLI("Smart Array P420i in Slot \n   logicaldrive 1 (279.4 GB, RAID 1, OK)\n")
+	t.Check(got, HasLen, 1)
+}
+
+func (s *TestSuite) TestHealthNoTools(t *C) {
+	service := system.NewHealth(s.logger)
+	service.Smartctl = []string{"unknown-executable"}
+	service.MegaCLI = []string{"unknown-executable"}
+	service.HPSSACLI = []string{"unknown-executable"}
+
+	cmd := &proto.Cmd{
+		Service: "sysinfo",
+		Cmd:     "DiskHealth",
+	}
+
+	gotReply := service.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Check(gotReply.Error, Equals, "None of smartctl, MegaCLI, or hpssacli found in $PATH")
+}
+
+func (s *TestSuite) TestSysctlHost(t *C) {
+	content, err := ioutil.ReadFile(sample + "/sysctl/freebsd001.txt")
+	t.Assert(err, IsNil)
+	host := &system.Host{}
+	system.SysctlHost(host, "freebsd", pct.ParseSysctl(content))
+	t.Check(host.Kernel, Equals, "10.1-RELEASE")
+	t.Check(host.Virtualization, Equals, "KVM")
+	t.Check(host.CPU, DeepEquals, system.CPU{
+		Model:   "Intel(R) Xeon(R) CPU E5-2630 v2 @ 2.60GHz",
+		Sockets: 1,
+		Cores:   4,
+		Threads: 4,
+		MHz:     2600,
+	})
+	t.Check(host.Memory, DeepEquals, system.Memory{
+		Total:     8536752128,
+		Free:      1468327 * 4096,
+		Cached:    12034 * 4096,
+		SwapTotal: 4294967296,
+	})
+
+	content, err = ioutil.ReadFile(sample + "/sysctl/darwin001.txt")
+	t.Assert(err, IsNil)
+	host = &system.Host{}
+	system.SysctlHost(host, "darwin", pct.ParseSysctl(content))
+	t.Check(host.Kernel, Equals, "14.0.0")
+	t.Check(host.Virtualization, Equals, "")
+	t.Check(host.CPU, DeepEquals, system.CPU{
+		Model:   "Intel(R) Core(TM) i7-4870HQ CPU @ 2.50GHz",
+		Sockets: 1,
+		Cores:   4,
+		Threads: 8,
+		MHz:     2500,
+	})
+	t.Check(host.Memory, DeepEquals, system.Memory{
+		Total:     17179869184,
+		Free:      524288 * 4096,
+		SwapTotal: 2048 * 1024 * 1024,
+		SwapFree:  uint64(1023.5 * 1024 * 1024),
+	})
+}
+
+func (s *TestSuite) TestSysctlMemTotal(t *C) {
+	// Mac OS X hw.physmem is capped at 2GB, so it's ignored.
+	values := map[string]string{"hw.physmem": "2147483648", "hw.memsize": "17179869184"}
+	t.Check(system.SysctlMemTotal("darwin", values), Equals, uint64(17179869184))
+	t.Check(system.SysctlMemTotal("freebsd", values), Equals, uint64(2147483648))
+
+	values = map[string]string{"hw.realmem": "8589934592"}
+	t.Check(system.SysctlMemTotal("freebsd", values), Equals, uint64(8589934592))
+	t.Check(system.SysctlMemTotal("darwin", values), Equals, uint64(0))
+}
+
+func (s *TestSuite) TestParseMountOutput(t *C) {
+	content, err := ioutil.ReadFile(sample + "/sysctl/mount001.txt")
+	t.Assert(err, IsNil)
+	got := system.ParseMountOutput(content)
+	expect := []system.Filesystem{
+		{Device: "/dev/ada0p2", MountPoint: "/", Type: "ufs", Options: "local,journaled soft-updates"},
+		{Device: "/dev/ada1p1", MountPoint: "/var/db/mysql", Type: "ufs", Options: "local,noatime,soft-updates"},
+		{Device: "zroot/data", MountPoint: "/data", Type: "zfs", Options: "local,nfsv4acls"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestParseNodeMeminfo(t *C) {
+	content, err := ioutil.ReadFile(sample + "/numa/sys/devices/system/node/node0/meminfo")
+	t.Assert(err, IsNil)
+	got := system.ParseNodeMeminfo(content)
+	t.Check(got["MemTotal"], Equals, uint64(16307476*1024))
+	t.Check(got["MemFree"], Equals, uint64(262144*1024))
+	t.Check(got["MemUsed"], Equals, uint64(16045332*1024))
+	t.Check(got["HugePages_Total"], Equals, uint64(0))
+}
+
+func (s *TestSuite) TestNUMA(t *C) {
+	service := system.NewNUMA(s.logger)
+	service.ProcDir = sample + "/numa/proc"
+	service.SysDir = sample + "/numa/sys"
+
+	cmd := &proto.Cmd{
+		Service: "sysinfo",
+		Cmd:     "NUMA",
+	}
+	gotReply := service.Handle(cmd)
+	t.Assert(gotReply, NotNil)
+	t.Assert(gotReply.Error, Equals, "")
+
+	info := &system.NUMAInfo{}
+	err := json.Unmarshal(gotReply.Data, info)
+	t.Assert(err, IsNil)
+
+	t.Assert(info.Nodes, HasLen, 2)
+	t.Check(info.Nodes[0].Id, Equals, 0)
+	t.Check(info.Nodes[0].CPUs, Equals, "0-7,16-23")
+	t.Check(info.Nodes[1].Id, Equals, 1)
+	t.Check(info.Nodes[1].MemFree, Equals, uint64(6291456*1024))
+	t.Check(info.Nodes[1].Stats["numa_miss"], Equals, uint64(51000000))
+	t.Check(info.Swappiness, Equals, 60)
+	t.Check(info.ZoneReclaimMode, Equals, 1)
+	t.Check(info.SwapTotal, Equals, uint64(4194300*1024))
+	t.Check(info.SwapFree, Equals, uint64(3145724*1024))
+	t.Check(info.SwapIn, Equals, uint64(1204))
+	t.Check(info.SwapOut, Equals, uint64(262144))
+
+	// Everything's wrong with this host.
+	t.Check(info.Warnings, DeepEquals, []string{
+		"1024 MB of swap is used (262144 pages swapped out since boot)",
+		"vm.swappiness is 60; 1 is recommended so the kernel does not swap out the InnoDB buffer pool",
+		"vm.zone_reclaim_mode is 1; 0 is recommended so nodes use each other's memory instead of reclaiming (and swapping) their own",
+		"9.5% of allocations missed their NUMA node (numa_miss)",
+		"NUMA memory is imbalanced: node 0 has 1.6% free but node 1 has 38.6% free; consider innodb_numa_interleave or numactl --interleave=all",
+	})
+}
+
+func (s *TestSuite) TestCheckNUMA(t *C) {
+	// Single node, no swap, good swappiness: nothing to warn about, and
+	// zone_reclaim_mode doesn't matter.
+	info := &system.NUMAInfo{
+		Nodes:           []system.NUMANode{{Id: 0, MemTotal: 100, MemFree: 1}},
+		Swappiness:      1,
+		ZoneReclaimMode: 1,
+		SwapTotal:       100,
+		SwapFree:        100,
+	}
+	t.Check(system.CheckNUMA(info), HasLen, 0)
+
+	// No NUMA at all.
+	info = &system.NUMAInfo{Nodes: []system.NUMANode{}, Swappiness: 10}
+	t.Check(system.CheckNUMA(info), HasLen, 0)
+}
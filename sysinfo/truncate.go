@@ -0,0 +1,109 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sysinfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Appended to strings truncated by TruncateJSON.
+const TRUNCATED_MARKER = "[truncated]"
+
+// TruncateJSON returns the JSON data cut to at most max bytes but still valid
+// JSON: the largest array loses its last elements, or the largest string its
+// end, until the data fits.  This is for replies of services that aren't
+// Limiters, e.g. a list of processes, so a large reply is partial instead of
+// an error.
+func TruncateJSON(data []byte, max int) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	for {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		over := len(data) - max
+		if over <= 0 {
+			return data, nil
+		}
+		largest := &jsonValue{}
+		findLargest(v, func(nv interface{}) { v = nv }, largest)
+		if largest.cut == nil {
+			return nil, fmt.Errorf("cannot truncate %d bytes to %d bytes", len(data), max)
+		}
+		largest.cut(over)
+	}
+}
+
+// A jsonValue is an array or string that can be cut by at least over bytes.
+type jsonValue struct {
+	size int
+	cut  func(over int)
+}
+
+func findLargest(v interface{}, set func(interface{}), largest *jsonValue) {
+	switch val := v.(type) {
+	case []interface{}:
+		// An array of one is cut by cutting its element.
+		if size := encodedSize(val); len(val) > 1 && size > largest.size {
+			largest.size = size
+			largest.cut = func(over int) {
+				n := (over*len(val) + size - 1) / size // elements of average size
+				if n > len(val) {
+					n = len(val)
+				}
+				set(val[0 : len(val)-n])
+			}
+		}
+		for i := range val {
+			i := i
+			findLargest(val[i], func(nv interface{}) { val[i] = nv }, largest)
+		}
+	case map[string]interface{}:
+		for k := range val {
+			k := k
+			findLargest(val[k], func(nv interface{}) { val[k] = nv }, largest)
+		}
+	case string:
+		if size := encodedSize(val); len(val) > len(TRUNCATED_MARKER) && size > largest.size {
+			largest.size = size
+			largest.cut = func(over int) {
+				n := len(val) - over - len(TRUNCATED_MARKER)
+				if n < 0 {
+					n = 0
+				}
+				for n > 0 && !utf8.RuneStart(val[n]) {
+					n--
+				}
+				set(val[0:n] + TRUNCATED_MARKER)
+			}
+		}
+	}
+}
+
+func encodedSize(v interface{}) int {
+	data, _ := json.Marshal(v)
+	return len(data)
+}
@@ -18,6 +18,7 @@
 package mock
 
 import (
+	"github.com/percona/percona-agent/pct"
 	"net/http"
 )
 
@@ -30,6 +31,10 @@ type API struct {
 	GetCode   []int
 	GetData   [][]byte
 	GetError  []error
+	// GetIfModified returns the Get values and these validators, and saves
+	// the validators it was called with.
+	GetValidators []pct.CacheValidators
+	IfModified    []pct.CacheValidators
 }
 
 func NewAPI(origin, hostname, apiKey, agentUuid string, links map[string]string) *API {
@@ -54,6 +59,14 @@ func (a *API) Connect(hostname, apiKey, agentUuid string) error {
 	return nil
 }
 
+func (a *API) Failover() (bool, error) {
+	return false, nil
+}
+
+func (a *API) ProbePreferred() (bool, error) {
+	return false, nil
+}
+
 func (a *API) AgentLink(resource string) string {
 	return a.links[resource]
 }
@@ -97,6 +110,17 @@ func (a *API) Get(string, string) (int, []byte, error) {
 	return code, data, err
 }
 
+func (a *API) GetIfModified(apiKey, url string, cached pct.CacheValidators) (int, []byte, pct.CacheValidators, error) {
+	a.IfModified = append(a.IfModified, cached)
+	code, data, err := a.Get(apiKey, url)
+	validators := pct.CacheValidators{}
+	if len(a.GetValidators) > 0 {
+		validators = a.GetValidators[0]
+		a.GetValidators = a.GetValidators[1:len(a.GetValidators)]
+	}
+	return code, data, validators, err
+}
+
 func (a *API) Post(apiKey, url string, data []byte) (*http.Response, []byte, error) {
 	return nil, nil, nil
 }
@@ -104,3 +128,7 @@ func (a *API) Post(apiKey, url string, data []byte) (*http.Response, []byte, err
 func (a *API) Put(apiKey, url string, data []byte) (*http.Response, []byte, error) {
 	return nil, nil, nil
 }
+
+func (a *API) Delete(apiKey, url string) (*http.Response, []byte, error) {
+	return nil, nil, nil
+}
@@ -22,9 +22,10 @@ import (
 )
 
 type Clock struct {
-	Added   []uint
-	Removed []chan time.Time
-	Eta     float64
+	Added    []uint
+	Removed  []chan time.Time
+	Eta      float64
+	Watchers []chan time.Time // added, in order
 }
 
 func NewClock() *Clock {
@@ -37,6 +38,7 @@ func NewClock() *Clock {
 
 func (m *Clock) Add(c chan time.Time, t uint, sync bool) {
 	m.Added = append(m.Added, t)
+	m.Watchers = append(m.Watchers, c)
 }
 
 func (m *Clock) Remove(c chan time.Time) {
@@ -64,6 +64,7 @@ func (m *MmMonitor) Start(tickChan chan time.Time, collectionChan chan *mm.Colle
 	if m.ReadyChan != nil {
 		<-m.ReadyChan
 	}
+	m.tickChan = tickChan
 	m.running = true
 	return nil
 }
@@ -94,3 +95,25 @@ func (m *MmMonitor) Config() interface{} {
 func (m *MmMonitor) SetConfig(v interface{}) {
 	m.config = v
 }
+
+// --------------------------------------------------------------------------
+
+// MmCollector is an MmMonitor that the mm.Manager runs on its collect
+// workers.  Each Collect sends its tick to CollectChan, if set.
+type MmCollector struct {
+	*MmMonitor
+	CollectChan chan time.Time
+}
+
+func NewMmCollector() *MmCollector {
+	c := &MmCollector{
+		MmMonitor: NewMmMonitor(),
+	}
+	return c
+}
+
+func (c *MmCollector) Collect(now time.Time) {
+	if c.CollectChan != nil {
+		c.CollectChan <- now
+	}
+}
@@ -58,6 +58,10 @@ func (n *NullMySQL) Explain(query string, db string) (explain *proto.ExplainResu
 	return n.explain[query], nil
 }
 
+func (n *NullMySQL) Analyze(query string, db string) (*mysql.AnalyzeResult, error) {
+	return nil, mysql.ErrAnalyzeNotSupported
+}
+
 func (n *NullMySQL) SetExplain(query string, explain *proto.ExplainResult) {
 	n.explain[query] = explain
 }
@@ -30,6 +30,8 @@ type MockServiceManager struct {
 	StartErr     error
 	StopErr      error
 	IsRunningVal bool
+	ConfigsVal   []proto.AgentConfig // GetConfig return value, if set
+	CmdErrs      map[string]error    // Handle reply error by cmd, if set
 	status       *pct.Status
 	Cmds         []*proto.Cmd
 }
@@ -71,6 +73,9 @@ func (m *MockServiceManager) Status() map[string]string {
 }
 
 func (m *MockServiceManager) GetConfig() ([]proto.AgentConfig, []error) {
+	if m.ConfigsVal != nil {
+		return m.ConfigsVal, nil
+	}
 	configs := []proto.AgentConfig{
 		{
 			InternalService: m.name,
@@ -88,7 +93,12 @@ func (m *MockServiceManager) IsRunning() bool {
 
 func (m *MockServiceManager) Handle(cmd *proto.Cmd) *proto.Reply {
 	m.Cmds = append(m.Cmds, cmd)
-	return cmd.Reply(nil)
+	return cmd.Reply(nil, m.CmdErrs[cmd.Cmd])
+}
+
+// Crash sets the status like a manager whose goroutine crashed.
+func (m *MockServiceManager) Crash() {
+	m.status.Update(m.name, "Crashed")
 }
 
 func (m *MockServiceManager) Reset() {
@@ -59,6 +59,10 @@ func (s *SlowMySQL) Explain(query string, db string) (explain *proto.ExplainResu
 	return s.realConnection.Explain(query, db)
 }
 
+func (s *SlowMySQL) Analyze(query string, db string) (*mysql.AnalyzeResult, error) {
+	return s.realConnection.Analyze(query, db)
+}
+
 func (s *SlowMySQL) Set(queries []mysql.Query) error {
 	return s.realConnection.Set(queries)
 }
@@ -27,6 +27,9 @@ type Spooler struct {
 	DataIn        []interface{}
 	dataChan      chan interface{}
 	RejectedFiles []string
+	Order         string
+	Store         string
+	SigningKey    []byte
 }
 
 func NewSpooler(dataChan chan interface{}) *Spooler {
@@ -70,6 +73,18 @@ func (s *Spooler) Files() <-chan string {
 	return filesChan
 }
 
+func (s *Spooler) SetOrder(order string) {
+	s.Order = order
+}
+
+func (s *Spooler) SetStore(backend string) {
+	s.Store = backend
+}
+
+func (s *Spooler) SetSigningKey(key []byte) {
+	s.SigningKey = key
+}
+
 func (s *Spooler) Read(file string) ([]byte, error) {
 	return s.DataOut[file], nil
 }
@@ -19,9 +19,12 @@ package mock
 
 import (
 	"github.com/percona/cloud-protocol/proto"
+	"time"
 )
 
 type SysinfoService struct {
+	Delay time.Duration // sleep before replying
+	Data  interface{}   // reply data
 }
 
 func NewSysinfoService() *SysinfoService {
@@ -30,5 +33,6 @@ func NewSysinfoService() *SysinfoService {
 }
 
 func (q *SysinfoService) Handle(cmd *proto.Cmd) (reply *proto.Reply) {
-	return cmd.Reply(nil)
+	time.Sleep(q.Delay)
+	return cmd.Reply(q.Data)
 }
@@ -0,0 +1,3 @@
+#!/bin/sh
+echo "cannot connect" >&2
+exit 1
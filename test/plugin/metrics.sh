@@ -0,0 +1,2 @@
+#!/bin/sh
+echo '{"Metrics": [{"Name": "clients", "Type": "gauge", "Number": 5}, {"Name": "hits", "Type": "counter", "Number": 100}, {"Name": "bad", "Type": "string"}]}'
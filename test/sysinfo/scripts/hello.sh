@@ -0,0 +1,4 @@
+#!/bin/sh
+echo "hello"
+echo "oops" >&2
+exit 3
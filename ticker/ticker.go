@@ -48,10 +48,13 @@ func (f *RealTickerFactory) Make(atInterval uint, sync bool) Ticker {
 	}
 }
 
+// If the wall clock and the monotonic clock disagree by this much after
+// waiting for a tick, the wall clock was stepped (e.g. by NTP).
+const CLOCK_JUMP = 1 * time.Second
+
 type EvenTicker struct {
 	atInterval uint
 	sleep      func(time.Duration)
-	ticker     *time.Ticker
 	watcher    map[chan time.Time]bool
 	watcherMux *sync.Mutex
 	sync       *pct.SyncChan
@@ -78,23 +81,56 @@ func (et *EvenTicker) Run(nowNanosecond int64) {
 	i := float64(time.Duration(et.atInterval) * time.Second)
 	d := i - math.Mod(float64(nowNanosecond), i)
 	et.sleep(time.Duration(d) * time.Nanosecond)
-	et.ticker = time.NewTicker(time.Duration(et.atInterval) * time.Second)
-	et.tick(time.Now().UTC()) // first tick
+	interval := time.Duration(et.atInterval) * time.Second
+	// First tick: the boundary nearest now, in case sleep returned early.
+	last := boundary(time.Now().Add(interval/2), interval)
+	et.tick(last)
+
+	/**
+	 * A time.Ticker ticks every interval on the monotonic clock, so its ticks
+	 * drift from the wall clock interval boundaries when the process is
+	 * delayed or the wall clock is stepped. Instead, wait on the monotonic
+	 * clock for the time remaining until the next wall clock boundary, then
+	 * tick that boundary. This re-aligns every tick, so drift never adds up.
+	 */
 	for {
+		start := time.Now()
+		timer := time.NewTimer(interval - time.Duration(start.UnixNano()%int64(interval)))
 		select {
-		case now := <-et.ticker.C:
-			et.tick(now.UTC())
+		case <-timer.C:
 		case <-et.sync.StopChan:
+			timer.Stop()
 			return
 		}
+		now := time.Now()
+
+		// Elapsed wall time minus elapsed monotonic time is how much the wall
+		// clock was stepped while waiting. Round(0) strips the monotonic reading.
+		jump := now.Round(0).Sub(start.Round(0)) - now.Sub(start)
+		if jump >= CLOCK_JUMP || jump <= -CLOCK_JUMP {
+			log.Printf("Clock jumped %s, re-syncing %ds ticker", jump, et.atInterval)
+		}
+
+		next := boundary(now, interval)
+		if !next.After(last) {
+			if jump > -interval {
+				// Woke before the boundary (small backward step or slew),
+				// so wait the rest of the way.
+				continue
+			}
+			// The wall clock went back an interval or more. Waiting for it to
+			// pass the last tick could take hours, so resume from here.
+		}
+		// If the wall clock jumped forward, the missed boundaries are skipped
+		// and only the latest one is ticked.
+		last = next
+		et.tick(last)
 	}
 }
 
 func (et *EvenTicker) Stop() {
 	et.sync.Stop()
 	et.sync.Wait()
-	et.ticker.Stop()
-	et.ticker = nil
 }
 
 func (et *EvenTicker) Add(c chan time.Time) {
@@ -119,6 +155,13 @@ func (et *EvenTicker) ETA(nowNanosecond int64) float64 {
 	return time.Duration(d).Seconds()
 }
 
+// boundary returns the last interval boundary at or before t, counting from
+// the Unix epoch like Run and ETA.
+func boundary(t time.Time, interval time.Duration) time.Time {
+	n := t.UnixNano()
+	return time.Unix(0, n-n%int64(interval)).UTC()
+}
+
 func (et *EvenTicker) tick(t time.Time) {
 	et.watcherMux.Lock()
 	defer et.watcherMux.Unlock()
@@ -165,6 +165,32 @@ TICK_LOOP:
 	et.Stop()
 }
 
+func (s *TickerTestSuite) TestTickerNoDrift(t *check.C) {
+	// Ticks are the interval boundaries themselves, not the time the ticker
+	// happened to wake up, so consecutive ticks are exactly 1 interval apart.
+	c := make(chan time.Time)
+	et := ticker.NewEvenTicker(1, time.Sleep)
+	et.Add(c)
+	go et.Run(time.Now().UnixNano())
+	defer et.Stop()
+
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case tick := <-c:
+			ticks = append(ticks, tick)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No tick %d", i)
+		}
+	}
+	for i, tick := range ticks {
+		t.Check(tick.Nanosecond(), check.Equals, 0)
+		if i > 0 {
+			t.Check(tick.Sub(ticks[i-1]), check.Equals, time.Second)
+		}
+	}
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager test suite
 /////////////////////////////////////////////////////////////////////////////